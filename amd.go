@@ -0,0 +1,270 @@
+package sipgox
+
+import (
+	"context"
+	"math"
+	"time"
+)
+
+// AMDResult is classification of answered call
+type AMDResult int
+
+const (
+	AMDNotSure AMDResult = iota
+	AMDHuman
+	AMDMachine
+)
+
+func (r AMDResult) String() string {
+	switch r {
+	case AMDHuman:
+		return "human"
+	case AMDMachine:
+		return "machine"
+	}
+	return "notsure"
+}
+
+// AMDDecision is result of answering machine detection
+type AMDDecision struct {
+	Result AMDResult
+	// Reason of decision, ex. "long greeting" or "beep"
+	Reason string
+	// Beep is set when machine beep was detected. Message can be left after it
+	Beep bool
+	// Duration is audio analyzed until decision
+	Duration time.Duration
+}
+
+// AMD detects answering machine from audio received after answer. Human usually answers with
+// short greeting and waits, while machine plays long greeting or many words, or starts with long
+// silence or beep. Zero values use defaults. AMD is used for one call
+type AMD struct {
+	// Threshold is RMS level in dBFS above which frame is voice. Zero is -40 dBFS
+	Threshold float64
+	// InitialSilence is max silence before greeting. Zero is 2.5s
+	InitialSilence time.Duration
+	// Greeting is max voice duration of human greeting. Zero is 1.5s
+	Greeting time.Duration
+	// AfterGreetingSilence is silence after greeting which means human. Zero is 800ms
+	AfterGreetingSilence time.Duration
+	// MinWordLength is min voice duration counted as word. Zero is 100ms
+	MinWordLength time.Duration
+	// BetweenWordsSilence is min silence separating words. Zero is 50ms
+	BetweenWordsSilence time.Duration
+	// MaxWords is max words of human greeting. Zero is 3
+	MaxWords int
+	// TotalAnalysis limits analysis, after it result is not sure. Zero is 5s
+	TotalAnalysis time.Duration
+	// WaitBeep continues analysis after machine is detected until beep or BeepTimeout,
+	// so that message is left after beep
+	WaitBeep bool
+	// BeepTimeout limits waiting for beep. Zero is 30s
+	BeepTimeout time.Duration
+
+	elapsed  time.Duration
+	block    []int16
+	decision AMDDecision
+	decided  bool
+
+	voiceTotal time.Duration
+	silence    time.Duration
+	// wordRun is voice of current word. Silence shorter than BetweenWordsSilence does not end word
+	wordRun time.Duration
+	words   int
+	// inWord is set when current word was counted
+	inWord bool
+
+	beepFreq float64
+	beepRun  time.Duration
+}
+
+const (
+	// amdBlock is analysis block
+	amdBlock = 20 * time.Millisecond
+	// amdBeepMin is min duration of steady tone detected as beep
+	amdBeepMin = 160 * time.Millisecond
+)
+
+func (a *AMD) defaults() {
+	if a.Threshold == 0 {
+		a.Threshold = -40
+	}
+	if a.InitialSilence == 0 {
+		a.InitialSilence = 2500 * time.Millisecond
+	}
+	if a.Greeting == 0 {
+		a.Greeting = 1500 * time.Millisecond
+	}
+	if a.AfterGreetingSilence == 0 {
+		a.AfterGreetingSilence = 800 * time.Millisecond
+	}
+	if a.MinWordLength == 0 {
+		a.MinWordLength = 100 * time.Millisecond
+	}
+	if a.BetweenWordsSilence == 0 {
+		a.BetweenWordsSilence = 50 * time.Millisecond
+	}
+	if a.MaxWords == 0 {
+		a.MaxWords = 3
+	}
+	if a.TotalAnalysis == 0 {
+		a.TotalAnalysis = 5 * time.Second
+	}
+	if a.BeepTimeout == 0 {
+		a.BeepTimeout = 30 * time.Second
+	}
+}
+
+// Process analyzes mono PCM received after answer. It returns decision and true once call is classified
+func (a *AMD) Process(pcm []int16, sampleRate int) (AMDDecision, bool) {
+	if a.decided || sampleRate <= 0 {
+		return a.decision, a.decided
+	}
+	a.defaults()
+
+	size := sampleRate * int(amdBlock) / int(time.Second)
+	for len(pcm) > 0 {
+		n := min(size-len(a.block), len(pcm))
+		a.block = append(a.block, pcm[:n]...)
+		pcm = pcm[n:]
+		if len(a.block) < size {
+			break
+		}
+		a.analyze(a.block, sampleRate)
+		a.block = a.block[:0]
+		if a.decided {
+			break
+		}
+	}
+	return a.decision, a.decided
+}
+
+// Detect reads audio until call is classified. Reader must decode mono audio, ex. G.711
+func (a *AMD) Detect(ctx context.Context, r *AudioReader) (AMDDecision, error) {
+	sampleRate := r.Decoder.Info().SampleRate
+	buf := make([]byte, 1920)
+	for {
+		n, err := r.ReadCtx(ctx, buf)
+		if err != nil {
+			return a.decision, err
+		}
+		if d, ok := a.Process(pcmSamples(nil, buf[:n]), sampleRate); ok {
+			return d, nil
+		}
+	}
+}
+
+func (a *AMD) decide(result AMDResult, reason string) {
+	if a.decision.Result == AMDMachine && a.WaitBeep {
+		// Machine is already known, only beep is waited
+		return
+	}
+	a.decision = AMDDecision{Result: result, Reason: reason, Duration: a.elapsed}
+	a.decided = result != AMDMachine || !a.WaitBeep
+}
+
+func (a *AMD) analyze(block []int16, sampleRate int) {
+	a.elapsed += amdBlock
+
+	if a.detectBeep(block, sampleRate) {
+		a.decision = AMDDecision{Result: AMDMachine, Reason: "beep", Beep: true, Duration: a.elapsed}
+		a.decided = true
+		return
+	}
+	if a.decision.Result == AMDMachine {
+		if a.elapsed >= a.BeepTimeout {
+			a.decided = true
+		}
+		return
+	}
+
+	var sum float64
+	for _, s := range block {
+		sum += float64(s) * float64(s)
+	}
+	rms := math.Sqrt(sum / float64(len(block)))
+	voice := rms > 0 && 20*math.Log10(rms/math.MaxInt16) > a.Threshold
+
+	if voice {
+		a.silence = 0
+		a.voiceTotal += amdBlock
+		a.wordRun += amdBlock
+		if !a.inWord && a.wordRun >= a.MinWordLength {
+			a.inWord = true
+			a.words++
+		}
+		switch {
+		case a.voiceTotal >= a.Greeting:
+			a.decide(AMDMachine, "long greeting")
+		case a.words > a.MaxWords:
+			a.decide(AMDMachine, "max words")
+		}
+	} else {
+		a.silence += amdBlock
+		if a.silence >= a.BetweenWordsSilence {
+			a.wordRun = 0
+			a.inWord = false
+		}
+		switch {
+		case a.voiceTotal == 0 && a.silence >= a.InitialSilence:
+			a.decide(AMDMachine, "initial silence")
+		case a.words > 0 && a.silence >= a.AfterGreetingSilence:
+			a.decide(AMDHuman, "human")
+		}
+	}
+
+	if !a.decided && a.decision.Result != AMDMachine && a.elapsed >= a.TotalAnalysis {
+		a.decide(AMDNotSure, "max analysis time")
+	}
+}
+
+// detectBeep reports end of steady tone, which machines play before recording.
+// Frequency is estimated with zero crossings and checked to hold most of energy
+func (a *AMD) detectBeep(block []int16, sampleRate int) bool {
+	// Frequency is measured between first and last crossing, which is more precise than count in block
+	crossings, first, last := 0, 0, 0
+	for i := 1; i < len(block); i++ {
+		if (block[i-1] < 0) != (block[i] < 0) {
+			if crossings == 0 {
+				first = i
+			}
+			last = i
+			crossings++
+		}
+	}
+	var freq float64
+	if crossings > 1 {
+		freq = float64(crossings-1) * float64(sampleRate) / float64(2*(last-first))
+	}
+
+	tone := freq >= 400 && freq <= 2100 && goertzelRatio(block, freq, sampleRate) > 0.8
+	if tone && (a.beepRun == 0 || math.Abs(freq-a.beepFreq) < 50) {
+		if a.beepRun == 0 {
+			a.beepFreq = freq
+		}
+		a.beepRun += amdBlock
+		return false
+	}
+	beep := a.beepRun >= amdBeepMin
+	a.beepRun = 0
+	return beep
+}
+
+// goertzelRatio returns part of block energy at frequency
+func goertzelRatio(block []int16, freq float64, sampleRate int) float64 {
+	coeff := 2 * math.Cos(2*math.Pi*freq/float64(sampleRate))
+	var s1, s2, total float64
+	for _, v := range block {
+		x := float64(v)
+		total += x * x
+		s0 := x + coeff*s1 - s2
+		s2, s1 = s1, s0
+	}
+	if total == 0 {
+		return 0
+	}
+	power := s1*s1 + s2*s2 - coeff*s1*s2
+	// Power of pure tone is N/2 times its energy
+	return power / (total * float64(len(block)) / 2)
+}
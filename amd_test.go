@@ -0,0 +1,89 @@
+package sipgox
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// amdAudio returns 8000 PCM of speech like noise bursts or tone
+func amdAudio(d time.Duration, amp float64, freq float64) []int16 {
+	pcm := make([]int16, int(d*8000/time.Second))
+	for i := range pcm {
+		if freq > 0 {
+			pcm[i] = int16(amp * math.Sin(2*math.Pi*freq*float64(i)/8000))
+			continue
+		}
+		// Mix of frequencies is not detected as beep
+		t := float64(i) / 8000
+		pcm[i] = int16(amp / 3 * (math.Sin(2*math.Pi*300*t) + math.Sin(2*math.Pi*1170*t) + math.Sin(2*math.Pi*2450*t)))
+	}
+	return pcm
+}
+
+func amdRun(a *AMD, parts ...[]int16) (AMDDecision, bool) {
+	for _, p := range parts {
+		// Frames of 20ms like RTP
+		for i := 0; i < len(p); i += 160 {
+			if d, ok := a.Process(p[i:min(i+160, len(p))], 8000); ok {
+				return d, ok
+			}
+		}
+	}
+	return AMDDecision{}, false
+}
+
+func TestAMD(t *testing.T) {
+	word := amdAudio(300*time.Millisecond, 8000, 0)
+	pause := amdAudio(200*time.Millisecond, 0, 0)
+	silence := amdAudio(time.Second, 0, 0)
+
+	t.Run("Human", func(t *testing.T) {
+		d, ok := amdRun(&AMD{}, pause, word, silence)
+		require.True(t, ok)
+		require.Equal(t, AMDHuman, d.Result)
+		require.Equal(t, 1300*time.Millisecond, d.Duration)
+	})
+
+	t.Run("LongGreeting", func(t *testing.T) {
+		d, ok := amdRun(&AMD{}, word, pause, word, pause, word, pause, word, pause, word, pause, word)
+		require.True(t, ok)
+		require.Equal(t, AMDDecision{Result: AMDMachine, Reason: "max words", Duration: 1600 * time.Millisecond}, d)
+
+		d, ok = amdRun(&AMD{}, amdAudio(2*time.Second, 8000, 0))
+		require.True(t, ok)
+		require.Equal(t, "long greeting", d.Reason)
+	})
+
+	t.Run("InitialSilence", func(t *testing.T) {
+		d, ok := amdRun(&AMD{}, silence, silence, silence)
+		require.True(t, ok)
+		require.Equal(t, AMDMachine, d.Result)
+		require.Equal(t, "initial silence", d.Reason)
+	})
+
+	t.Run("Beep", func(t *testing.T) {
+		a := &AMD{WaitBeep: true}
+		d, ok := amdRun(a, amdAudio(2*time.Second, 8000, 0))
+		require.False(t, ok)
+		d, ok = amdRun(a, pause, amdAudio(400*time.Millisecond, 8000, 1000), pause)
+		require.True(t, ok)
+		require.Equal(t, AMDMachine, d.Result)
+		require.True(t, d.Beep)
+		require.Equal(t, 2620*time.Millisecond, d.Duration)
+	})
+
+	t.Run("NotSure", func(t *testing.T) {
+		// Short words without long enough pause
+		var parts [][]int16
+		for i := 0; i < 30; i++ {
+			parts = append(parts, amdAudio(40*time.Millisecond, 8000, 0), amdAudio(160*time.Millisecond, 0, 0))
+		}
+		d, ok := amdRun(&AMD{}, parts...)
+		require.True(t, ok)
+		require.Equal(t, AMDNotSure, d.Result)
+		require.Equal(t, 5*time.Second, d.Duration)
+	})
+}
@@ -0,0 +1,260 @@
+package sipgox
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+
+	"github.com/emiago/sipgox/sdp"
+	"github.com/pion/rtp"
+)
+
+// AudioReader reads RTP payload and decodes it to 16 bit little endian PCM
+type AudioReader struct {
+	Reader  RTPStreamReader
+	Decoder Decoder
+	// Gain is applied to decoded PCM. It defaults to RecvGain of session when reading from RTPReader
+	Gain *GainControl
+	// Speech detects speech in decoded PCM, after gain
+	Speech *SpeechDetector
+
+	// sess is set when reading from RTPReader, for echo cancellation
+	sess *MediaSession
+	// rtpReader is set when reading from RTPReader, for loss recovery with FEC
+	rtpReader *RTPReader
+	// last is header of last read packet
+	last     rtp.Header
+	lastRead bool
+	payload  []byte
+	samples  []int16
+	// unread is decoded PCM not yet returned
+	unread []byte
+}
+
+// NewAudioReader creates reader decoding G.711 payload type. Use NewAudioReaderDecoder for other codecs
+func NewAudioReader(r RTPStreamReader, payloadType uint8) (*AudioReader, error) {
+	c, ok := sdp.StaticCodec(strconv.Itoa(int(payloadType)))
+	if !ok {
+		return nil, fmt.Errorf("audio payload type %d not supported", payloadType)
+	}
+	d, err := NewDecoder(c)
+	if err != nil {
+		return nil, fmt.Errorf("audio payload type %d not supported: %w", payloadType, err)
+	}
+	return NewAudioReaderDecoder(r, d), nil
+}
+
+// NewAudioReaderDecoder creates reader decoding with decoder
+func NewAudioReaderDecoder(r RTPStreamReader, d Decoder) *AudioReader {
+	ar := &AudioReader{Reader: r, Decoder: d}
+	if rr, ok := r.(*RTPReader); ok {
+		ar.Gain = rr.Sess.RecvGain
+		ar.sess = rr.Sess
+		ar.rtpReader = rr
+	}
+	return ar
+}
+
+// Read reads PCM of one or more RTP packets. Samples which do not fit in b are returned on next read
+func (r *AudioReader) Read(b []byte) (int, error) {
+	return r.ReadCtx(context.Background(), b)
+}
+
+// ReadCtx is Read with context
+func (r *AudioReader) ReadCtx(ctx context.Context, b []byte) (int, error) {
+	if len(r.unread) > 0 {
+		n := copy(b, r.unread)
+		r.unread = r.unread[n:]
+		return n, nil
+	}
+
+	if cap(r.payload) < 1500 {
+		r.payload = make([]byte, 1500)
+	}
+	n, err := r.Reader.ReadCtx(ctx, r.payload[:cap(r.payload)])
+	if err != nil {
+		return 0, err
+	}
+
+	// Opus frame can have up to 120ms, and lost one can be recovered before it
+	info := r.Decoder.Info()
+	size := 2 * max(n, 6*info.FrameSize*max(info.Channels, 1))
+	if cap(r.samples) < size {
+		r.samples = make([]int16, size)
+	}
+	samples, err := r.recoverLoss(r.payload[:n], size)
+	if err != nil {
+		return 0, err
+	}
+	decoded, err := r.Decoder.Decode(r.samples[samples:cap(r.samples)], r.payload[:n])
+	if err != nil {
+		return 0, err
+	}
+	samples += decoded
+	processGains(r.samples[:samples], r.Gain)
+	if r.Speech != nil {
+		r.Speech.Process(r.samples[:samples], info.SampleRate, info.Channels)
+	}
+	if r.sess != nil && r.sess.EchoCanceller != nil {
+		r.sess.echoFar.push(r.samples[:samples], info.SampleRate)
+	}
+
+	pcm := pcmBytes(make([]byte, 0, 2*samples), r.samples[:samples])
+	written := copy(b, pcm)
+	r.unread = pcm[written:]
+	return written, nil
+}
+
+// recoverLoss recovers packets lost just before payload. Last lost packet is decoded from FEC data
+// of payload, when decoder supports it and codec fmtp has useinbandfec=1. Others are concealed
+// when decoder supports PLC. Samples buffer is grown to fit recovered audio and size more.
+// It returns number of recovered samples
+func (r *AudioReader) recoverLoss(payload []byte, size int) (int, error) {
+	if r.rtpReader == nil {
+		return 0, nil
+	}
+	hdr, last, lastRead := r.rtpReader.PacketHeader, r.last, r.lastRead
+	r.last, r.lastRead = hdr, true
+
+	codec := r.rtpReader.codec
+	fecDec, fec := r.Decoder.(FECDecoder)
+	fec = fec && codec.Params["useinbandfec"] == "1"
+	plcDec, plc := r.Decoder.(PLCDecoder)
+	clockRate := int(codec.ClockRate)
+	if (!fec && !plc) || !lastRead || clockRate == 0 || hdr.SSRC != last.SSRC {
+		return 0, nil
+	}
+	gap := hdr.SequenceNumber - last.SequenceNumber
+	if gap < 2 || gap > 50 {
+		// No loss, reordering or stream restart
+		return 0, nil
+	}
+
+	// Lost packets are assumed to have same duration
+	info := r.Decoder.Info()
+	channels := max(info.Channels, 1)
+	duration := (hdr.Timestamp - last.Timestamp) / uint32(gap)
+	samples := int(duration) * info.SampleRate / clockRate
+	if samples == 0 {
+		return 0, nil
+	}
+	lost := int(gap) - 1
+	if need := lost*samples*channels + size; cap(r.samples) < need {
+		r.samples = make([]int16, need)
+	}
+
+	pcm := r.samples[:cap(r.samples)]
+	recovered := 0
+	for i := 0; i < lost; i++ {
+		var n int
+		var err error
+		switch {
+		case i == lost-1 && fec:
+			n, err = fecDec.DecodeFEC(pcm[recovered:], payload, samples)
+		case plc:
+			n, err = plcDec.DecodePLC(pcm[recovered:], samples)
+		default:
+			continue
+		}
+		if err != nil {
+			return recovered, err
+		}
+		recovered += n
+	}
+	return recovered, nil
+}
+
+// AudioWriter encodes 16 bit little endian PCM and writes it as RTP payload
+type AudioWriter struct {
+	Writer  RTPStreamWriter
+	Encoder Encoder
+	// Gain is applied to PCM before encoding. It defaults to SendGain of session when writing to RTPWriter
+	Gain *GainControl
+
+	// sess is set when writing to RTPWriter, for echo cancellation
+	sess    *MediaSession
+	payload []byte
+	samples []int16
+	far     []int16
+	// lossPercent is packet loss last set on encoder
+	lossPercent int
+}
+
+// NewAudioWriter creates writer encoding G.711 payload type. Use NewAudioWriterEncoder for other codecs
+func NewAudioWriter(w RTPStreamWriter, payloadType uint8) (*AudioWriter, error) {
+	c, ok := sdp.StaticCodec(strconv.Itoa(int(payloadType)))
+	if !ok {
+		return nil, fmt.Errorf("audio payload type %d not supported", payloadType)
+	}
+	e, err := NewEncoder(c)
+	if err != nil {
+		return nil, fmt.Errorf("audio payload type %d not supported: %w", payloadType, err)
+	}
+	return NewAudioWriterEncoder(w, e), nil
+}
+
+// NewAudioWriterEncoder creates writer encoding with encoder
+func NewAudioWriterEncoder(w RTPStreamWriter, e Encoder) *AudioWriter {
+	aw := &AudioWriter{Writer: w, Encoder: e}
+	if rw, ok := w.(*RTPWriter); ok {
+		aw.Gain = rw.Sess.SendGain
+		aw.sess = rw.Sess
+	}
+	return aw
+}
+
+// Write encodes PCM as single RTP packet. Writer paces packets, so b should be one frame,
+// ex. 320 bytes for 20ms of G.711. It returns number of PCM bytes written
+func (w *AudioWriter) Write(b []byte) (int, error) {
+	return w.WriteCtx(context.Background(), b)
+}
+
+// WriteCtx is Write with context
+func (w *AudioWriter) WriteCtx(ctx context.Context, b []byte) (int, error) {
+	if len(b)%2 != 0 {
+		return 0, fmt.Errorf("PCM length %d is not multiple of sample size", len(b))
+	}
+	w.samples = pcmSamples(w.samples, b)
+	if w.sess != nil && w.sess.EchoCanceller != nil {
+		if cap(w.far) < len(w.samples) {
+			w.far = make([]int16, len(w.samples))
+		}
+		w.far = w.far[:len(w.samples)]
+		w.sess.echoFar.pull(w.far, w.Encoder.Info().SampleRate)
+		w.sess.EchoCanceller.Cancel(w.samples, w.far)
+	}
+	processGains(w.samples, w.Gain)
+	if rw, ok := w.Writer.(*RTPWriter); ok && rw.Sess.audioLevelID.Load() != 0 {
+		rw.SetAudioLevel(PCMAudioLevel(w.samples, audioLevelVoice))
+	}
+	if err := w.adaptLoss(); err != nil {
+		return 0, err
+	}
+	if cap(w.payload) < max(len(w.samples), 1500) {
+		w.payload = make([]byte, max(len(w.samples), 1500))
+	}
+	n, err := w.Encoder.Encode(w.payload[:cap(w.payload)], w.samples)
+	if err != nil {
+		return 0, err
+	}
+
+	if _, err := w.Writer.WriteCtx(ctx, w.payload[:n]); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// adaptLoss passes loss reported by remote to encoder, so that it adds FEC under loss
+func (w *AudioWriter) adaptLoss() error {
+	enc, ok := w.Encoder.(LossAdaptiveEncoder)
+	if !ok || w.sess == nil {
+		return nil
+	}
+	percent := int(math.Round(w.sess.Stats().SentLoss * 100))
+	if percent == w.lossPercent {
+		return nil
+	}
+	w.lossPercent = percent
+	return enc.SetPacketLoss(percent)
+}
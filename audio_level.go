@@ -0,0 +1,73 @@
+package sipgox
+
+import (
+	"math"
+
+	"github.com/pion/rtp"
+)
+
+// audioLevelExtensionID is id of audio level extension in local offer
+const audioLevelExtensionID = 1
+
+// audioLevelVoice is level below which AudioWriter marks audio as voice
+const audioLevelVoice = 50
+
+// audioLevelSet marks that level was set on writer
+const audioLevelSet = 0x100
+
+// PCMAudioLevel returns RFC 6464 level of PCM in -dBov (0 loudest, 127 silence).
+// Voice is set when audio is louder than voiceLevel, ex. 50
+func PCMAudioLevel(pcm []int16, voiceLevel uint8) rtp.AudioLevelExtension {
+	var sum float64
+	for _, s := range pcm {
+		sum += float64(s) * float64(s)
+	}
+	if len(pcm) == 0 || sum == 0 {
+		return rtp.AudioLevelExtension{Level: 127}
+	}
+	dbov := 20 * math.Log10(math.Sqrt(sum/float64(len(pcm)))/math.MaxInt16)
+	level := uint8(min(max(math.Round(-dbov), 0), 127))
+	return rtp.AudioLevelExtension{Level: level, Voice: level < voiceLevel}
+}
+
+// SetAudioLevel sets audio level sent with next packets, when remote negotiated audio level extension.
+// AudioWriter sets it from written PCM
+func (p *RTPWriter) SetAudioLevel(a rtp.AudioLevelExtension) {
+	v := uint32(audioLevelSet) | uint32(min(a.Level, 127))
+	if a.Voice {
+		v |= 0x80
+	}
+	p.audioLevel.Store(v)
+}
+
+// setAudioLevelExtension adds audio level to header of packet
+func (p *RTPWriter) setAudioLevelExtension(h *rtp.Header) {
+	id := uint8(p.Sess.audioLevelID.Load())
+	v := p.audioLevel.Load()
+	if id == 0 || v&audioLevelSet == 0 {
+		return
+	}
+	// Two bytes header is used when remote mapped id out of one byte range
+	if id > 14 {
+		h.Extension = true
+		h.ExtensionProfile = 0x1000
+	}
+	h.SetExtension(id, []byte{byte(v)})
+}
+
+// AudioLevel returns RFC 6464 level of last read packet. False is returned if packet did not have it
+func (r *RTPReader) AudioLevel() (rtp.AudioLevelExtension, bool) {
+	return r.audioLevel, r.audioLevelOK
+}
+
+// readAudioLevel stores audio level of packet. It must be read before payload overwrites buffer
+func (r *RTPReader) readAudioLevel(h *rtp.Header) {
+	r.audioLevelOK = false
+	id := uint8(r.Sess.recvAudioLevelID.Load())
+	if id == 0 {
+		return
+	}
+	if ext := h.GetExtension(id); ext != nil {
+		r.audioLevelOK = r.audioLevel.Unmarshal(ext) == nil
+	}
+}
@@ -0,0 +1,78 @@
+package sipgox
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPCMAudioLevel(t *testing.T) {
+	require.Equal(t, rtp.AudioLevelExtension{Level: 127}, PCMAudioLevel(make([]int16, 160), 50))
+	// Sine of amplitude 8000 has RMS of -15 dBov
+	require.Equal(t, rtp.AudioLevelExtension{Level: 15, Voice: true}, PCMAudioLevel(gainTone(8000), 50))
+	require.Equal(t, rtp.AudioLevelExtension{Level: 64}, PCMAudioLevel(gainTone(31), 50))
+}
+
+func TestAudioLevelExtension(t *testing.T) {
+	sessA, err := NewMediaSession(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, nil)
+	require.NoError(t, err)
+	defer sessA.Close()
+	sessB, err := NewMediaSession(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, nil)
+	require.NoError(t, err)
+	defer sessB.Close()
+
+	// Offer of B uses other id, which A answers with
+	sessA.AudioLevel = true
+	sessB.AudioLevel = true
+	offer := sessB.LocalSDP()
+	require.Contains(t, string(offer), "a=extmap:1 urn:ietf:params:rtp-hdrext:ssrc-audio-level vad=on")
+	offer = []byte(strings.Replace(string(offer), "a=extmap:1 ", "a=extmap:5 ", 1))
+	require.NoError(t, sessA.RemoteSDP(offer))
+	answer := sessA.LocalSDP()
+	require.Contains(t, string(answer), "a=extmap:5 urn:ietf:params:rtp-hdrext:ssrc-audio-level vad=on")
+	require.NoError(t, sessB.RemoteSDP(answer))
+
+	w, err := NewAudioWriter(NewRTPWriter(sessA), 0)
+	require.NoError(t, err)
+	_, err = w.Write(pcmBytes(nil, gainTone(8000)))
+	require.NoError(t, err)
+
+	r := NewRTPReader(sessB)
+	sessB.rtpConn.SetReadDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, 1500)
+	n, err := r.Read(buf)
+	require.NoError(t, err)
+	require.Equal(t, 160, n)
+	level, ok := r.AudioLevel()
+	require.True(t, ok)
+	require.Equal(t, rtp.AudioLevelExtension{Level: 15, Voice: true}, level)
+
+	// Remote without extension does not get it
+	sessB.AudioLevel = false
+	require.NoError(t, sessA.RemoteSDP(sessB.LocalSDP()))
+	require.Zero(t, sessA.audioLevelID.Load())
+
+	// Extension not offered is not answered
+	require.NoError(t, sessA.RemoteSDP(sessB.LocalSDP()))
+	require.NotContains(t, string(sessA.LocalSDP()), "extmap")
+	require.Zero(t, sessA.recvAudioLevelID.Load())
+
+	// Direction of offer is reversed in answer
+	sessB.AudioLevel = true
+	offer = []byte(strings.Replace(string(offer), "a=extmap:5 ", "a=extmap:3/sendonly ", 1))
+	require.NoError(t, sessA.RemoteSDP(offer))
+	require.Contains(t, string(sessA.LocalSDP()), "a=extmap:3/recvonly urn:ietf:params:rtp-hdrext:ssrc-audio-level vad=on")
+	require.Zero(t, sessA.audioLevelID.Load())
+	require.Equal(t, uint32(3), sessA.recvAudioLevelID.Load())
+
+	// Renegotiation updates extension
+	answer, err = sessA.Renegotiate([]byte(strings.Replace(string(offer), "a=extmap:3/sendonly ", "a=extmap:4 ", 1)))
+	require.NoError(t, err)
+	require.Contains(t, string(answer), "a=extmap:4 urn:ietf:params:rtp-hdrext:ssrc-audio-level vad=on")
+	require.Equal(t, uint32(4), sessA.audioLevelID.Load())
+	require.Equal(t, uint32(4), sessA.recvAudioLevelID.Load())
+}
@@ -0,0 +1,45 @@
+package sipgox
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAudioReaderWriter(t *testing.T) {
+	_, err := NewAudioReader(&greetingFakeReader{}, 18)
+	require.Error(t, err)
+	_, err = NewAudioWriter(&greetingFakeWriter{}, 18)
+	require.Error(t, err)
+
+	fw := &greetingFakeWriter{}
+	w, err := NewAudioWriter(fw, 8)
+	require.NoError(t, err)
+	pcm := make([]byte, 320)
+	for i := 0; i < 160; i++ {
+		binary.LittleEndian.PutUint16(pcm[2*i:], uint16(int16(i*100)))
+	}
+	n, err := w.Write(pcm)
+	require.NoError(t, err)
+	require.Equal(t, 320, n)
+	require.Len(t, fw.written, 160)
+	_, err = w.Write(pcm[:3])
+	require.Error(t, err)
+
+	r, err := NewAudioReader(&greetingFakeReader{frames: func(i int) []byte { return fw.written }}, 8)
+	require.NoError(t, err)
+	// Packet is returned over multiple reads when buffer is small
+	out := make([]byte, 200)
+	n, err = r.Read(out)
+	require.NoError(t, err)
+	require.Equal(t, 200, n)
+	decoded := append([]byte{}, out[:n]...)
+	n, err = r.Read(out)
+	require.NoError(t, err)
+	require.Equal(t, 120, n)
+	decoded = append(decoded, out[:n]...)
+	for i := 0; i < 160; i++ {
+		require.InDelta(t, i*100, int16(binary.LittleEndian.Uint16(decoded[2*i:])), float64(i*100)/16+16)
+	}
+}
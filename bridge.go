@@ -0,0 +1,201 @@
+package sipgox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Bridge connects media of two sessions, ex. legs of B2BUA. Payload is passed as is when
+// negotiated codecs are same, otherwise it is decoded, resampled and encoded with codec of other leg,
+// ex. PCMU to PCMA or Opus to PCMU. DTMF is forwarded as telephone events.
+// Audio is also decoded when gain is set, on bridge or as RecvGain and SendGain of sessions
+type Bridge struct {
+	A *MediaSession
+	B *MediaSession
+	// GainAB and GainBA adjust audio from A to B and from B to A
+	GainAB *GainControl
+	GainBA *GainControl
+}
+
+func NewBridge(a *MediaSession, b *MediaSession) *Bridge {
+	return &Bridge{A: a, B: b}
+}
+
+// Transcoding reports whether negotiated codecs of sessions differ
+func (br *Bridge) Transcoding() bool {
+	_, ca, _, _ := br.A.negotiated()
+	_, cb, _, _ := br.B.negotiated()
+	return !ca.Equal(cb)
+}
+
+// Run bridges media in both directions until ctx is done or one of sessions is closed.
+// It returns nil when session is closed. Codecs are chosen on start, so Run should be called
+// again after renegotiation
+func (br *Bridge) Run(ctx context.Context) error {
+	ab, err := newBridgeLeg(br.A, br.B, br.GainAB)
+	if err != nil {
+		return err
+	}
+	ba, err := newBridgeLeg(br.B, br.A, br.GainBA)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	errs := make(chan error, 2)
+	for _, l := range []*bridgeLeg{ab, ba} {
+		l := l
+		br.A.goEngine(func() {
+			// Error is queued before other leg is stopped, so it is returned first
+			errs <- l.run(ctx)
+			cancel()
+		})
+	}
+
+	err = <-errs
+	<-errs
+	if errors.Is(err, io.EOF) {
+		return nil
+	}
+	return err
+}
+
+// bridgeLeg forwards media from one session to other
+type bridgeLeg struct {
+	r *RTPReader
+	w *RTPWriter
+
+	// dec and enc are set when transcoding or gain is applied
+	dec       Decoder
+	enc       Encoder
+	resampler *Resampler
+	gains     []*GainControl
+
+	buf       []byte
+	payload   []byte
+	pcm       []int16
+	remixed   []int16
+	resampled []int16
+
+	lastTimestamp uint32
+	duration      uint32
+	started       bool
+}
+
+func newBridgeLeg(from *MediaSession, to *MediaSession, gain *GainControl) (*bridgeLeg, error) {
+	l := &bridgeLeg{
+		r:   NewRTPReader(from),
+		w:   NewRTPWriter(to),
+		buf: make([]byte, from.bufferPool().Size()),
+	}
+	for _, g := range []*GainControl{from.RecvGain, gain, to.SendGain} {
+		if g != nil {
+			l.gains = append(l.gains, g)
+		}
+	}
+	l.duration = l.w.ClockRateTimestamp
+	l.r.OnDTMF = func(digit rune) {
+		if err := l.w.WriteDTMF(digit); err != nil {
+			to.log.Debug().Err(err).Msg("Fail to forward DTMF")
+		}
+	}
+
+	_, fromCodec, _, _ := from.negotiated()
+	_, toCodec, _, _ := to.negotiated()
+	if fromCodec.Equal(toCodec) && len(l.gains) == 0 {
+		return l, nil
+	}
+
+	var err error
+	if l.dec, err = from.NewDecoder(); err != nil {
+		return nil, fmt.Errorf("bridge can not decode %s: %w", fromCodec, err)
+	}
+	if l.enc, err = to.NewEncoder(); err != nil {
+		return nil, fmt.Errorf("bridge can not encode %s: %w", toCodec, err)
+	}
+	einfo := l.enc.Info()
+	if l.resampler, err = NewResampler(l.dec.Info().SampleRate, einfo.SampleRate, max(einfo.Channels, 1)); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *bridgeLeg) run(ctx context.Context) error {
+	for {
+		n, err := l.r.ReadCtx(ctx, l.buf)
+		if err != nil {
+			return err
+		}
+		hdr := l.r.PacketHeader
+
+		payload, duration, err := l.convert(l.buf[:n], hdr.Timestamp)
+		if err != nil {
+			l.r.Sess.log.Debug().Err(err).Msg("Bridge fail to transcode")
+			continue
+		}
+		if _, err := l.w.WriteSamples(payload, duration, hdr.Marker || l.w.nextTimestamp == 0, l.w.PayloadType); err != nil {
+			return err
+		}
+	}
+}
+
+// convert returns payload for other leg and its duration in RTP clock rate of other leg
+func (l *bridgeLeg) convert(payload []byte, timestamp uint32) ([]byte, uint32, error) {
+	if l.dec == nil {
+		// Duration of packet is not known until next one, so previous is used
+		if l.started && timestamp != l.lastTimestamp {
+			l.duration = timestamp - l.lastTimestamp
+		}
+		l.started = true
+		l.lastTimestamp = timestamp
+		return payload, l.duration, nil
+	}
+
+	dinfo, einfo := l.dec.Info(), l.enc.Info()
+	// Opus frame can have up to 120ms
+	if size := max(len(payload), 6*dinfo.FrameSize*max(dinfo.Channels, 1)); len(l.pcm) < size {
+		l.pcm = make([]int16, size)
+	}
+	n, err := l.dec.Decode(l.pcm, payload)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	processGains(l.pcm[:n], l.gains...)
+	l.remixed = remixPCM(l.remixed, l.pcm[:n], max(dinfo.Channels, 1), max(einfo.Channels, 1))
+	l.resampled = l.resampler.Resample(l.resampled[:0], l.remixed)
+
+	if len(l.payload) < max(2*len(l.resampled), 1500) {
+		l.payload = make([]byte, max(2*len(l.resampled), 1500))
+	}
+	n, err = l.enc.Encode(l.payload, l.resampled)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	samples := len(l.resampled) / max(einfo.Channels, 1)
+	duration := uint64(samples) * uint64(l.w.SampleRate) / uint64(einfo.SampleRate)
+	return l.payload[:n], uint32(duration), nil
+}
+
+// remixPCM converts interleaved samples between mono and stereo
+func remixPCM(dst []int16, src []int16, from int, to int) []int16 {
+	if from == to {
+		return append(dst[:0], src...)
+	}
+	dst = dst[:0]
+	switch {
+	case from == 2 && to == 1:
+		for i := 0; i+1 < len(src); i += 2 {
+			dst = append(dst, int16((int32(src[i])+int32(src[i+1]))/2))
+		}
+	case from == 1 && to == 2:
+		for _, s := range src {
+			dst = append(dst, s, s)
+		}
+	}
+	return dst
+}
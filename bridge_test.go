@@ -0,0 +1,113 @@
+package sipgox
+
+import (
+	"context"
+	"math"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/emiago/sipgox/sdp"
+	"github.com/stretchr/testify/require"
+)
+
+// bridgePair creates local session connected to remote session with format
+func bridgePair(t *testing.T, format string) (*MediaSession, *MediaSession) {
+	local, err := NewMediaSession(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, nil)
+	require.NoError(t, err)
+	remote, err := NewMediaSession(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, nil)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		local.Close()
+		remote.Close()
+	})
+	local.Formats = sdp.NewFormats(format)
+	remote.Formats = sdp.NewFormats(format)
+	local.SetRemoteAddr(remote.Laddr)
+	remote.SetRemoteAddr(local.Laddr)
+	return local, remote
+}
+
+func TestBridgeTranscoding(t *testing.T) {
+	a, caller := bridgePair(t, sdp.FORMAT_TYPE_ULAW)
+	b, callee := bridgePair(t, sdp.FORMAT_TYPE_ALAW)
+
+	br := NewBridge(a, b)
+	require.True(t, br.Transcoding())
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- br.Run(ctx) }()
+
+	// Caller sends 1kHz tone in PCMU
+	pcm := make([]int16, 160)
+	for i := range pcm {
+		pcm[i] = int16(8000 * math.Sin(2*math.Pi*1000*float64(i)/8000))
+	}
+	payload := make([]byte, 160)
+	EncodeULaw(payload, pcmBytes(nil, pcm))
+	w := NewRTPWriter(caller)
+	go func() {
+		for i := 0; i < 10; i++ {
+			if _, err := w.WriteCtx(ctx, payload); err != nil {
+				return
+			}
+		}
+	}()
+
+	// Callee receives it as PCMA
+	r := NewRTPReader(callee)
+	buf := make([]byte, 1500)
+	n, err := r.ReadCtx(ctxTimeout(t, time.Second), buf)
+	require.NoError(t, err)
+	require.Equal(t, uint8(8), r.PacketHeader.PayloadType)
+	require.Equal(t, 160, n)
+	decoded := make([]byte, 2*n)
+	DecodeALaw(decoded, buf[:n])
+	for i, s := range pcmSamples(nil, decoded) {
+		require.InDelta(t, pcm[i], s, 600)
+	}
+
+	cancel()
+	require.ErrorIs(t, <-done, context.Canceled)
+}
+
+func TestBridgePassthrough(t *testing.T) {
+	a, caller := bridgePair(t, sdp.FORMAT_TYPE_ULAW)
+	b, callee := bridgePair(t, sdp.FORMAT_TYPE_ULAW)
+
+	br := NewBridge(a, b)
+	require.False(t, br.Transcoding())
+	done := make(chan error)
+	go func() { done <- br.Run(context.Background()) }()
+
+	// Callee sends towards caller, payload is not changed
+	w := NewRTPWriter(callee)
+	r := NewRTPReader(caller)
+	buf := make([]byte, 1500)
+	for i := 0; i < 3; i++ {
+		_, err := w.WriteSamples([]byte{1, 2, 3}, 160, i == 0, w.PayloadType)
+		require.NoError(t, err)
+		n, err := r.ReadCtx(ctxTimeout(t, time.Second), buf)
+		require.NoError(t, err)
+		require.Equal(t, []byte{1, 2, 3}, buf[:n])
+	}
+
+	// Bridge stops when leg is closed
+	a.Close()
+	select {
+	case err := <-done:
+		require.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("bridge not stopped")
+	}
+}
+
+func ctxTimeout(t *testing.T, d time.Duration) context.Context {
+	ctx, cancel := context.WithTimeout(context.Background(), d)
+	t.Cleanup(cancel)
+	return ctx
+}
+
+func TestRemixPCM(t *testing.T) {
+	require.Equal(t, []int16{15, 35}, remixPCM(nil, []int16{10, 20, 30, 40}, 2, 1))
+}
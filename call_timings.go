@@ -0,0 +1,105 @@
+package sipgox
+
+import (
+	"time"
+
+	"github.com/emiago/sipgo/sip"
+)
+
+// CallTimings are timestamps of outbound call. Trunk SLAs are evaluated on delays derived from them
+type CallTimings struct {
+	InviteSent time.Time
+	// Provisional is first provisional response other than 100 Trying, ex. 180 Ringing or 183.
+	// Zero if none was received
+	Provisional time.Time
+	// Answered is when 2xx was received. Zero if call was not answered
+	Answered time.Time
+	// Ended is when answered call was closed
+	Ended time.Time
+}
+
+// PostDialDelay is time from INVITE to first provisional response. Zero if none was received
+func (t CallTimings) PostDialDelay() time.Duration {
+	if t.Provisional.IsZero() {
+		return 0
+	}
+	return t.Provisional.Sub(t.InviteSent)
+}
+
+// AnswerDelay is time from INVITE to answer. Zero if call was not answered
+func (t CallTimings) AnswerDelay() time.Duration {
+	if t.Answered.IsZero() {
+		return 0
+	}
+	return t.Answered.Sub(t.InviteSent)
+}
+
+// Duration is time from answer to end of call. For active call it is time since answer
+func (t CallTimings) Duration() time.Duration {
+	if t.Answered.IsZero() {
+		return 0
+	}
+	if t.Ended.IsZero() {
+		return time.Since(t.Answered)
+	}
+	return t.Ended.Sub(t.Answered)
+}
+
+// response records time of response to INVITE
+func (t *CallTimings) response(res *sip.Response) {
+	now := time.Now()
+	switch {
+	case res.IsProvisional():
+		if res.StatusCode != sip.StatusTrying && t.Provisional.IsZero() {
+			t.Provisional = now
+		}
+	case res.IsSuccess():
+		if t.Answered.IsZero() {
+			t.Answered = now
+		}
+	}
+}
+
+// CDR is call detail record of outbound call
+type CDR struct {
+	CallID string
+	From   string
+	To     string
+	// StatusCode is final response to INVITE. Zero if none was received
+	StatusCode int
+	Timings    CallTimings
+}
+
+func newCDR(invite *sip.Request, res *sip.Response, timings CallTimings) CDR {
+	cdr := CDR{
+		Timings: timings,
+	}
+	if h := invite.CallID(); h != nil {
+		cdr.CallID = h.Value()
+	}
+	if h := invite.From(); h != nil {
+		cdr.From = h.Address.String()
+	}
+	if h := invite.To(); h != nil {
+		cdr.To = h.Address.String()
+	}
+	if res != nil {
+		cdr.StatusCode = int(res.StatusCode)
+	}
+	return cdr
+}
+
+// WithPhoneCDR sets handler receiving CDR of every outbound call, when dial fails
+// after INVITE is sent or when answered call is closed
+func WithPhoneCDR(f func(cdr CDR)) PhoneOption {
+	return func(p *Phone) {
+		p.onCDR = f
+	}
+}
+
+func (p *Phone) recordCDR(invite *sip.Request, res *sip.Response, timings CallTimings) {
+	if p.onCDR == nil {
+		return
+	}
+	p.onCDR(newCDR(invite, res, timings))
+}
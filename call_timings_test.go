@@ -0,0 +1,51 @@
+package sipgox
+
+import (
+	"testing"
+	"time"
+
+	"github.com/emiago/sipgo/sip"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCallTimings(t *testing.T) {
+	req := sip.NewRequest(sip.INVITE, sip.Uri{User: "bob", Host: "127.0.0.1"})
+	req.AppendHeader(&sip.FromHeader{Address: sip.Uri{User: "alice", Host: "127.0.0.1"}})
+	req.AppendHeader(&sip.ToHeader{Address: sip.Uri{User: "bob", Host: "127.0.0.1"}, Params: sip.NewParams()})
+	callid := sip.CallIDHeader("abc")
+	req.AppendHeader(&callid)
+
+	timings := CallTimings{InviteSent: time.Now()}
+	require.Zero(t, timings.PostDialDelay())
+	require.Zero(t, timings.AnswerDelay())
+	require.Zero(t, timings.Duration())
+
+	timings.response(sip.NewResponseFromRequest(req, sip.StatusTrying, "Trying", nil))
+	require.True(t, timings.Provisional.IsZero())
+
+	time.Sleep(10 * time.Millisecond)
+	timings.response(sip.NewResponseFromRequest(req, sip.StatusRinging, "Ringing", nil))
+	provisional := timings.Provisional
+	require.GreaterOrEqual(t, timings.PostDialDelay(), 10*time.Millisecond)
+
+	// Only first provisional counts
+	timings.response(sip.NewResponseFromRequest(req, sip.StatusSessionInProgress, "Session Progress", nil))
+	require.Equal(t, provisional, timings.Provisional)
+
+	time.Sleep(10 * time.Millisecond)
+	res := sip.NewResponseFromRequest(req, sip.StatusOK, "OK", nil)
+	timings.response(res)
+	require.Greater(t, timings.AnswerDelay(), timings.PostDialDelay())
+
+	timings.Ended = timings.Answered.Add(time.Minute)
+	require.Equal(t, time.Minute, timings.Duration())
+
+	cdr := newCDR(req, res, timings)
+	require.Equal(t, "abc", cdr.CallID)
+	require.Equal(t, "sip:alice@127.0.0.1", cdr.From)
+	require.Equal(t, "sip:bob@127.0.0.1", cdr.To)
+	require.Equal(t, 200, cdr.StatusCode)
+	require.Equal(t, timings, cdr.Timings)
+
+	require.Zero(t, newCDR(req, nil, timings).StatusCode)
+}
@@ -0,0 +1,203 @@
+package sipgox
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/emiago/sipgox/sdp"
+)
+
+// CodecInfo describes audio codec of encoder or decoder
+type CodecInfo struct {
+	PayloadType uint8
+	// SampleRate of PCM samples
+	SampleRate int
+	Channels   int
+	// FrameSize is number of samples per channel in one 20ms frame
+	FrameSize int
+}
+
+// Encoder encodes 16 bit PCM samples to RTP payload.
+// Samples of multiple channels are interleaved
+type Encoder interface {
+	Info() CodecInfo
+	// Encode encodes frame of samples to payload and returns payload length
+	Encode(payload []byte, pcm []int16) (int, error)
+}
+
+// Decoder decodes RTP payload to 16 bit PCM samples
+type Decoder interface {
+	Info() CodecInfo
+	// Decode decodes payload to pcm and returns number of samples
+	Decode(pcm []int16, payload []byte) (int, error)
+}
+
+// FECDecoder is decoder which can recover lost packet from in band FEC of next packet, ex. Opus
+type FECDecoder interface {
+	Decoder
+	// DecodeFEC decodes packet before payload from its FEC data. Samples is duration of lost packet
+	// per channel. Without FEC data loss is concealed
+	DecodeFEC(pcm []int16, payload []byte, samples int) (int, error)
+}
+
+// PLCDecoder is decoder which can conceal lost packet without any data of it, ex. Opus
+type PLCDecoder interface {
+	Decoder
+	// DecodePLC conceals lost packet. Samples is duration of lost packet per channel
+	DecodePLC(pcm []int16, samples int) (int, error)
+}
+
+// LossAdaptiveEncoder is encoder which adds redundancy based on packet loss, ex. Opus in band FEC
+type LossAdaptiveEncoder interface {
+	Encoder
+	// SetPacketLoss sets expected packet loss in percent
+	SetPacketLoss(percent int) error
+}
+
+// CodecFactory creates encoders and decoders of negotiated codec
+type CodecFactory struct {
+	NewEncoder func(c sdp.Codec) (Encoder, error)
+	NewDecoder func(c sdp.Codec) (Decoder, error)
+}
+
+var (
+	codecFactoriesMu sync.RWMutex
+	codecFactories   = map[string]CodecFactory{
+		"pcmu": {NewEncoder: newG711Encoder, NewDecoder: newG711Decoder},
+		"pcma": {NewEncoder: newG711Encoder, NewDecoder: newG711Decoder},
+		"g722": {NewEncoder: newG722Encoder, NewDecoder: newG722Decoder},
+	}
+)
+
+// RegisterCodec registers factory for codec name as in rtpmap, ex. "opus".
+// PCMU, PCMA and G722 are built in. Opus is registered when built with opus tag
+func RegisterCodec(name string, f CodecFactory) {
+	codecFactoriesMu.Lock()
+	defer codecFactoriesMu.Unlock()
+	codecFactories[strings.ToLower(name)] = f
+}
+
+func codecFactory(c sdp.Codec) (CodecFactory, error) {
+	codecFactoriesMu.RLock()
+	defer codecFactoriesMu.RUnlock()
+	f, exists := codecFactories[strings.ToLower(c.Name)]
+	if !exists {
+		return f, fmt.Errorf("codec %s not supported", c.String())
+	}
+	return f, nil
+}
+
+// NewEncoder creates encoder of codec
+func NewEncoder(c sdp.Codec) (Encoder, error) {
+	f, err := codecFactory(c)
+	if err != nil {
+		return nil, err
+	}
+	return f.NewEncoder(c)
+}
+
+// NewDecoder creates decoder of codec
+func NewDecoder(c sdp.Codec) (Decoder, error) {
+	f, err := codecFactory(c)
+	if err != nil {
+		return nil, err
+	}
+	return f.NewDecoder(c)
+}
+
+// NewEncoder creates encoder of negotiated codec
+func (s *MediaSession) NewEncoder() (Encoder, error) {
+	f, c, _, ok := s.negotiated()
+	if !ok {
+		return nil, fmt.Errorf("format %s not known", f)
+	}
+	return NewEncoder(c)
+}
+
+// NewDecoder creates decoder of negotiated codec with payload type remote sends with
+func (s *MediaSession) NewDecoder() (Decoder, error) {
+	f, c, pt, ok := s.negotiated()
+	if !ok {
+		return nil, fmt.Errorf("format %s not known", f)
+	}
+	c.PayloadType = pt
+	return NewDecoder(c)
+}
+
+// g711Codec is G.711 encoder and decoder
+type g711Codec struct {
+	info   CodecInfo
+	decode func(byte) int16
+	encode func(int16) byte
+}
+
+func newG711Codec(c sdp.Codec) (*g711Codec, error) {
+	var pt uint8
+	switch strings.ToLower(c.Name) {
+	case "pcmu":
+		pt = sdp.FormatNumeric(sdp.FORMAT_TYPE_ULAW)
+	case "pcma":
+		pt = sdp.FormatNumeric(sdp.FORMAT_TYPE_ALAW)
+	}
+	decode, encode, ok := g711Funcs(pt)
+	if !ok {
+		return nil, fmt.Errorf("codec %s is not G.711", c.Name)
+	}
+	return &g711Codec{
+		info:   CodecInfo{PayloadType: c.PayloadType, SampleRate: 8000, Channels: 1, FrameSize: 160},
+		decode: decode,
+		encode: encode,
+	}, nil
+}
+
+func newG711Encoder(c sdp.Codec) (Encoder, error) {
+	return newG711Codec(c)
+}
+
+func newG711Decoder(c sdp.Codec) (Decoder, error) {
+	return newG711Codec(c)
+}
+
+func (c *g711Codec) Info() CodecInfo {
+	return c.info
+}
+
+func (c *g711Codec) Encode(payload []byte, pcm []int16) (int, error) {
+	if len(payload) < len(pcm) {
+		return 0, fmt.Errorf("payload buffer too small")
+	}
+	for i, s := range pcm {
+		payload[i] = c.encode(s)
+	}
+	return len(pcm), nil
+}
+
+func (c *g711Codec) Decode(pcm []int16, payload []byte) (int, error) {
+	if len(pcm) < len(payload) {
+		return 0, fmt.Errorf("pcm buffer too small")
+	}
+	for i, b := range payload {
+		pcm[i] = c.decode(b)
+	}
+	return len(payload), nil
+}
+
+// pcmSamples converts 16 bit little endian PCM to samples
+func pcmSamples(samples []int16, pcm []byte) []int16 {
+	samples = samples[:0]
+	for i := 0; i+1 < len(pcm); i += 2 {
+		samples = append(samples, int16(binary.LittleEndian.Uint16(pcm[i:])))
+	}
+	return samples
+}
+
+// pcmBytes converts samples to 16 bit little endian PCM
+func pcmBytes(pcm []byte, samples []int16) []byte {
+	pcm = pcm[:0]
+	for _, s := range samples {
+		pcm = binary.LittleEndian.AppendUint16(pcm, uint16(s))
+	}
+	return pcm
+}
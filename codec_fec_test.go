@@ -0,0 +1,134 @@
+package sipgox
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/emiago/sipgox/sdp"
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+	"github.com/stretchr/testify/require"
+)
+
+// fecTestDecoder is G.711 decoder recording FEC and PLC requests
+type fecTestDecoder struct {
+	Decoder
+	fec []int
+	plc []int
+}
+
+func (d *fecTestDecoder) DecodeFEC(pcm []int16, payload []byte, samples int) (int, error) {
+	d.fec = append(d.fec, samples)
+	clear(pcm[:samples])
+	return samples, nil
+}
+
+func (d *fecTestDecoder) DecodePLC(pcm []int16, samples int) (int, error) {
+	d.plc = append(d.plc, samples)
+	clear(pcm[:samples])
+	return samples, nil
+}
+
+type lossTestEncoder struct {
+	Encoder
+	loss []int
+}
+
+func (e *lossTestEncoder) SetPacketLoss(percent int) error {
+	e.loss = append(e.loss, percent)
+	return nil
+}
+
+func TestAudioReaderFEC(t *testing.T) {
+	sess, err := NewMediaSession(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, nil)
+	require.NoError(t, err)
+	defer sess.Close()
+	sess.SetRemoteAddr(sess.Laddr)
+
+	dec, err := NewDecoder(sdp.Codec{PayloadType: 0, Name: "PCMU", ClockRate: 8000})
+	require.NoError(t, err)
+	fec := &fecTestDecoder{Decoder: dec}
+	sess.FormatParams = map[string]string{"0": "useinbandfec=1"}
+	r := NewAudioReaderDecoder(NewRTPReader(sess), fec)
+
+	buf := make([]byte, 1500)
+	for _, seq := range []uint16{1, 2, 4, 5, 9} {
+		pkt := &rtp.Packet{
+			Header:  rtp.Header{Version: 2, SSRC: 1, SequenceNumber: seq, Timestamp: uint32(seq) * 160},
+			Payload: make([]byte, 160),
+		}
+		require.NoError(t, sess.WriteRTP(pkt))
+		sess.rtpConn.SetReadDeadline(time.Now().Add(time.Second))
+		n, err := r.Read(buf)
+		require.NoError(t, err)
+
+		// Lost packets are recovered together with received one
+		expected := 320
+		switch seq {
+		case 4:
+			expected = 640
+		case 9:
+			expected = 4 * 320
+		}
+		require.Equal(t, expected, n, seq)
+	}
+	// Last lost packet is recovered from FEC and others are concealed
+	require.Equal(t, []int{160, 160}, fec.fec)
+	require.Equal(t, []int{160, 160}, fec.plc)
+}
+
+func TestAudioReaderFECNotNegotiated(t *testing.T) {
+	sess, err := NewMediaSession(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, nil)
+	require.NoError(t, err)
+	defer sess.Close()
+	sess.SetRemoteAddr(sess.Laddr)
+
+	dec, err := NewDecoder(sdp.Codec{PayloadType: 0, Name: "PCMU", ClockRate: 8000})
+	require.NoError(t, err)
+	fec := &fecTestDecoder{Decoder: dec}
+	r := NewAudioReaderDecoder(NewRTPReader(sess), fec)
+
+	buf := make([]byte, 1500)
+	for _, seq := range []uint16{1, 3} {
+		pkt := &rtp.Packet{
+			Header:  rtp.Header{Version: 2, SSRC: 1, SequenceNumber: seq, Timestamp: uint32(seq) * 160},
+			Payload: make([]byte, 160),
+		}
+		require.NoError(t, sess.WriteRTP(pkt))
+		sess.rtpConn.SetReadDeadline(time.Now().Add(time.Second))
+		_, err := r.Read(buf)
+		require.NoError(t, err)
+	}
+	// Without useinbandfec=1 loss is only concealed
+	require.Empty(t, fec.fec)
+	require.Equal(t, []int{160}, fec.plc)
+}
+
+func TestAudioWriterLossAdaptive(t *testing.T) {
+	sess, err := NewMediaSession(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, nil)
+	require.NoError(t, err)
+	defer sess.Close()
+	sess.SetRemoteAddr(sess.Laddr)
+
+	enc, err := NewEncoder(sdp.Codec{PayloadType: 0, Name: "PCMU", ClockRate: 8000})
+	require.NoError(t, err)
+	loss := &lossTestEncoder{Encoder: enc}
+	rw := NewRTPWriter(sess)
+	w := NewAudioWriterEncoder(rw, loss)
+
+	pcm := make([]byte, 320)
+	_, err = w.Write(pcm)
+	require.NoError(t, err)
+	require.Empty(t, loss.loss)
+
+	// Remote reports quarter of our packets lost
+	sess.sentRTCPStats([]rtcp.Packet{rw.SenderReport()})
+	sess.readRTCPStats([]rtcp.Packet{&rtcp.ReceiverReport{Reports: []rtcp.ReceptionReport{{SSRC: rw.SSRC, FractionLost: 64}}}}, time.Now())
+	require.Equal(t, 0.25, sess.Stats().SentLoss)
+	for i := 0; i < 2; i++ {
+		_, err = w.Write(pcm)
+		require.NoError(t, err)
+	}
+	require.Equal(t, []int{25}, loss.loss)
+}
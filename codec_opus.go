@@ -0,0 +1,192 @@
+//go:build opus
+
+package sipgox
+
+/*
+#cgo pkg-config: opus
+#include <opus.h>
+
+static int sipgox_opus_set_inband_fec(OpusEncoder *e, int v) {
+	return opus_encoder_ctl(e, OPUS_SET_INBAND_FEC(v));
+}
+
+static int sipgox_opus_set_dtx(OpusEncoder *e, int v) {
+	return opus_encoder_ctl(e, OPUS_SET_DTX(v));
+}
+
+static int sipgox_opus_set_packet_loss_perc(OpusEncoder *e, int v) {
+	return opus_encoder_ctl(e, OPUS_SET_PACKET_LOSS_PERC(v));
+}
+*/
+import "C"
+
+import (
+	"fmt"
+	"runtime"
+	"unsafe"
+
+	"github.com/emiago/sipgox/sdp"
+)
+
+// Opus is built with libopus. Build with -tags opus and libopus development files installed
+func init() {
+	RegisterCodec("opus", CodecFactory{
+		NewEncoder: func(c sdp.Codec) (Encoder, error) { return NewOpusEncoder(c) },
+		NewDecoder: func(c sdp.Codec) (Decoder, error) { return NewOpusDecoder(c) },
+	})
+}
+
+func opusError(code C.int) error {
+	return fmt.Errorf("opus: %s", C.GoString(C.opus_strerror(code)))
+}
+
+// opusInfo returns info of Opus codec. RTP clock rate is always 48000 and
+// number of channels is signaled with stereo parameter (RFC 7587)
+func opusInfo(c sdp.Codec) CodecInfo {
+	channels := 1
+	if p, _ := c.OpusParams(); p.Stereo {
+		channels = 2
+	}
+	return CodecInfo{PayloadType: c.PayloadType, SampleRate: 48000, Channels: channels, FrameSize: 960}
+}
+
+// OpusEncoder encodes Opus with libopus
+type OpusEncoder struct {
+	enc  *C.OpusEncoder
+	info CodecInfo
+}
+
+// NewOpusEncoder creates encoder for VoIP. In band FEC and DTX are enabled as in codec fmtp
+func NewOpusEncoder(c sdp.Codec) (*OpusEncoder, error) {
+	info := opusInfo(c)
+	var code C.int
+	enc := C.opus_encoder_create(C.opus_int32(info.SampleRate), C.int(info.Channels), C.OPUS_APPLICATION_VOIP, &code)
+	if code != C.OPUS_OK {
+		return nil, opusError(code)
+	}
+	e := &OpusEncoder{enc: enc, info: info}
+	runtime.SetFinalizer(e, (*OpusEncoder).Close)
+
+	p, _ := c.OpusParams()
+	if p.UseInbandFEC {
+		if code := C.sipgox_opus_set_inband_fec(enc, 1); code != C.OPUS_OK {
+			return nil, opusError(code)
+		}
+	}
+	if p.UseDTX {
+		if code := C.sipgox_opus_set_dtx(enc, 1); code != C.OPUS_OK {
+			return nil, opusError(code)
+		}
+	}
+	return e, nil
+}
+
+func (e *OpusEncoder) Info() CodecInfo {
+	return e.info
+}
+
+// Encode encodes frame of 2.5, 5, 10, 20, 40 or 60ms
+func (e *OpusEncoder) Encode(payload []byte, pcm []int16) (int, error) {
+	if len(pcm) == 0 || len(payload) == 0 {
+		return 0, fmt.Errorf("opus: empty buffer")
+	}
+	n := C.opus_encode(e.enc, (*C.opus_int16)(unsafe.Pointer(&pcm[0])), C.int(len(pcm)/e.info.Channels),
+		(*C.uchar)(unsafe.Pointer(&payload[0])), C.opus_int32(len(payload)))
+	if n < 0 {
+		return 0, opusError(C.int(n))
+	}
+	return int(n), nil
+}
+
+// SetPacketLoss sets expected loss. With in band FEC enabled encoder adds FEC data when loss is high enough
+func (e *OpusEncoder) SetPacketLoss(percent int) error {
+	if code := C.sipgox_opus_set_packet_loss_perc(e.enc, C.int(min(max(percent, 0), 100))); code != C.OPUS_OK {
+		return opusError(code)
+	}
+	return nil
+}
+
+// Close frees encoder
+func (e *OpusEncoder) Close() {
+	if e.enc != nil {
+		C.opus_encoder_destroy(e.enc)
+		e.enc = nil
+	}
+}
+
+// OpusDecoder decodes Opus with libopus
+type OpusDecoder struct {
+	dec  *C.OpusDecoder
+	info CodecInfo
+}
+
+func NewOpusDecoder(c sdp.Codec) (*OpusDecoder, error) {
+	info := opusInfo(c)
+	var code C.int
+	dec := C.opus_decoder_create(C.opus_int32(info.SampleRate), C.int(info.Channels), &code)
+	if code != C.OPUS_OK {
+		return nil, opusError(code)
+	}
+	d := &OpusDecoder{dec: dec, info: info}
+	runtime.SetFinalizer(d, (*OpusDecoder).Close)
+	return d, nil
+}
+
+func (d *OpusDecoder) Info() CodecInfo {
+	return d.info
+}
+
+// Decode decodes packet. Empty payload is decoded as lost packet with concealment
+func (d *OpusDecoder) Decode(pcm []int16, payload []byte) (int, error) {
+	return d.decode(pcm, payload, len(pcm)/d.info.Channels, false)
+}
+
+// DecodeFEC decodes lost packet from in band FEC of payload, which is packet after lost one
+func (d *OpusDecoder) DecodeFEC(pcm []int16, payload []byte, samples int) (int, error) {
+	if len(pcm) < samples*d.info.Channels {
+		return 0, fmt.Errorf("opus: pcm buffer too small")
+	}
+	return d.decode(pcm, payload, samples, true)
+}
+
+// DecodePLC conceals lost packet with packet loss concealment
+func (d *OpusDecoder) DecodePLC(pcm []int16, samples int) (int, error) {
+	if len(pcm) < samples*d.info.Channels {
+		return 0, fmt.Errorf("opus: pcm buffer too small")
+	}
+	return d.decode(pcm, nil, samples, false)
+}
+
+func (d *OpusDecoder) decode(pcm []int16, payload []byte, frameSize int, fec bool) (int, error) {
+	if len(pcm) == 0 {
+		return 0, fmt.Errorf("opus: empty buffer")
+	}
+	var data *C.uchar
+	if len(payload) > 0 {
+		data = (*C.uchar)(unsafe.Pointer(&payload[0]))
+	}
+	decodeFEC := C.int(0)
+	if fec {
+		decodeFEC = 1
+	}
+	n := C.opus_decode(d.dec, data, C.opus_int32(len(payload)), (*C.opus_int16)(unsafe.Pointer(&pcm[0])),
+		C.int(frameSize), decodeFEC)
+	if n < 0 {
+		return 0, opusError(n)
+	}
+	return int(n) * d.info.Channels, nil
+}
+
+// Close frees decoder
+func (d *OpusDecoder) Close() {
+	if d.dec != nil {
+		C.opus_decoder_destroy(d.dec)
+		d.dec = nil
+	}
+}
+
+var (
+	_ FECDecoder          = (*OpusDecoder)(nil)
+	_ PLCDecoder          = (*OpusDecoder)(nil)
+	_ LossAdaptiveEncoder = (*OpusEncoder)(nil)
+)
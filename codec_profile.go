@@ -0,0 +1,102 @@
+package sipgox
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/emiago/sipgox/sdp"
+)
+
+// CodecProfile is preset of codecs and packetization applied to media session, so that
+// formats, fmtp and packet time stay consistent for trunk or call
+type CodecProfile struct {
+	Name string
+	// Formats in preference order
+	Formats sdp.Formats
+	// Codecs describe dynamic formats. They are registered in session Registry
+	Codecs []sdp.Codec
+	// FormatParams are fmtp of static formats. See MediaSession.FormatParams
+	FormatParams map[string]string
+	// Ptime paces RTPWriter and is advertised with MaxPtime
+	Ptime    time.Duration
+	MaxPtime time.Duration
+	// Bandwidth is advertised in SDP
+	Bandwidth []sdp.Bandwidth
+	// TelephoneEvents are advertised telephone events. Zero is default
+	TelephoneEvents sdp.TelephoneEvents
+	// JitterDelay is target delay for jitter buffer of application. Session does not buffer received RTP
+	JitterDelay time.Duration
+}
+
+// Codec profile names
+const (
+	CodecProfileNarrowbandTelco = "narrowband-telco"
+	CodecProfileWidebandWebRTC  = "wideband-webrtc"
+	CodecProfileLowBandwidth    = "low-bandwidth"
+)
+
+// CodecProfiles are preset profiles by name. Applications can add own profiles before calls are made
+var CodecProfiles = map[string]CodecProfile{
+	CodecProfileNarrowbandTelco: {
+		Name:         CodecProfileNarrowbandTelco,
+		Formats:      sdp.NewFormats(sdp.FORMAT_TYPE_ULAW, sdp.FORMAT_TYPE_ALAW, sdp.FORMAT_TYPE_G729),
+		FormatParams: map[string]string{sdp.FORMAT_TYPE_G729: "annexb=no"},
+		Ptime:        20 * time.Millisecond,
+		MaxPtime:     40 * time.Millisecond,
+		JitterDelay:  60 * time.Millisecond,
+	},
+	CodecProfileWidebandWebRTC: {
+		Name:        CodecProfileWidebandWebRTC,
+		Formats:     sdp.NewFormats(sdp.FORMAT_TYPE_OPUS, sdp.FORMAT_TYPE_G722, sdp.FORMAT_TYPE_ULAW, sdp.FORMAT_TYPE_ALAW),
+		Codecs:      []sdp.Codec{sdp.OpusCodec(111, sdp.OpusParams{UseInbandFEC: true})},
+		Ptime:       20 * time.Millisecond,
+		MaxPtime:    120 * time.Millisecond,
+		JitterDelay: 40 * time.Millisecond,
+	},
+	CodecProfileLowBandwidth: {
+		Name:         CodecProfileLowBandwidth,
+		Formats:      sdp.NewFormats(sdp.FORMAT_TYPE_OPUS, sdp.FORMAT_TYPE_G729, sdp.FORMAT_TYPE_ULAW),
+		Codecs:       []sdp.Codec{sdp.OpusCodec(111, sdp.OpusParams{MaxPlaybackRate: 8000, UseInbandFEC: true, UseDTX: true})},
+		FormatParams: map[string]string{sdp.FORMAT_TYPE_G729: "annexb=yes"},
+		Ptime:        40 * time.Millisecond,
+		MaxPtime:     60 * time.Millisecond,
+		Bandwidth:    []sdp.Bandwidth{{Type: sdp.BandwidthAS, Value: 24}},
+		JitterDelay:  100 * time.Millisecond,
+	},
+}
+
+// LookupCodecProfile returns profile by name
+func LookupCodecProfile(name string) (CodecProfile, error) {
+	p, exists := CodecProfiles[name]
+	if !exists {
+		return p, fmt.Errorf("codec profile %q not found", name)
+	}
+	return p, nil
+}
+
+// Apply configures session with profile. It must be called before session SDP is created.
+// Profile codecs are registered in session Registry, which is created if nil
+func (p CodecProfile) Apply(s *MediaSession) error {
+	if len(p.Formats) == 0 {
+		return fmt.Errorf("codec profile %q has no formats", p.Name)
+	}
+	if len(p.Codecs) > 0 && s.Registry == nil {
+		s.Registry = sdp.NewCodecRegistry()
+	}
+	for _, c := range p.Codecs {
+		if err := s.Registry.Register(c); err != nil {
+			return fmt.Errorf("codec profile %q: %w", p.Name, err)
+		}
+	}
+
+	s.Formats = append(sdp.Formats{}, p.Formats...)
+	s.FormatParams = map[string]string{}
+	for f, fmtp := range p.FormatParams {
+		s.FormatParams[f] = fmtp
+	}
+	s.Ptime = p.Ptime
+	s.MaxPtime = p.MaxPtime
+	s.Bandwidth = append([]sdp.Bandwidth{}, p.Bandwidth...)
+	s.TelephoneEvents = p.TelephoneEvents
+	return nil
+}
@@ -0,0 +1,69 @@
+package sipgox
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/emiago/sipgox/sdp"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCodecProfile(t *testing.T) {
+	_, err := LookupCodecProfile("unknown")
+	require.Error(t, err)
+
+	for name := range CodecProfiles {
+		p, err := LookupCodecProfile(name)
+		require.NoError(t, err)
+
+		sess, err := NewMediaSession(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, nil)
+		require.NoError(t, err)
+		require.NoError(t, p.Apply(sess), name)
+		require.Equal(t, p.Formats, sess.Formats)
+		require.Equal(t, p.Ptime, sess.PacketTime())
+
+		offer := sess.LocalSDP()
+		for _, f := range sdp.Check(offer) {
+			require.NotEqual(t, sdp.SeverityError, f.Severity, name+": "+f.String())
+		}
+		sess.Close()
+	}
+
+	sess, err := NewMediaSession(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, nil)
+	require.NoError(t, err)
+	defer sess.Close()
+	p := CodecProfiles[CodecProfileLowBandwidth]
+	require.NoError(t, p.Apply(sess))
+
+	offer := string(sess.LocalSDP())
+	require.Contains(t, offer, "a=rtpmap:111 opus/48000/2\r\n")
+	require.Contains(t, offer, "a=fmtp:111 maxplaybackrate=8000;useinbandfec=1;usedtx=1\r\n")
+	require.Contains(t, offer, "a=fmtp:18 annexb=yes\r\n")
+	require.Contains(t, offer, "a=ptime:40\r\n")
+	require.Contains(t, offer, "b=AS:24\r\n")
+	require.Equal(t, 40*time.Millisecond, sess.PacketTime())
+}
+
+func TestCodecProfileSharedRegistry(t *testing.T) {
+	registry := sdp.NewCodecRegistry()
+	p := CodecProfiles[CodecProfileLowBandwidth]
+
+	// Sessions sharing registry apply profile while others look up codecs
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			registry.Lookup("111")
+		}
+	}()
+	for i := 0; i < 10; i++ {
+		sess := &MediaSession{Registry: registry}
+		require.NoError(t, p.Apply(sess))
+	}
+	<-done
+
+	c, ok := registry.Lookup("111")
+	require.True(t, ok)
+	require.Equal(t, "opus", c.Name)
+}
@@ -0,0 +1,92 @@
+package sipgox
+
+import (
+	"net"
+	"testing"
+
+	"github.com/emiago/sipgox/sdp"
+	"github.com/stretchr/testify/require"
+)
+
+// pcm16Codec is linear PCM codec used for testing registration
+type pcm16Codec struct {
+	info CodecInfo
+}
+
+func (c *pcm16Codec) Info() CodecInfo { return c.info }
+
+func (c *pcm16Codec) Encode(payload []byte, pcm []int16) (int, error) {
+	return len(pcmBytes(payload[:0], pcm)), nil
+}
+
+func (c *pcm16Codec) Decode(pcm []int16, payload []byte) (int, error) {
+	return len(pcmSamples(pcm[:0], payload)), nil
+}
+
+func TestCodec(t *testing.T) {
+	ulaw, _ := sdp.StaticCodec(sdp.FORMAT_TYPE_ULAW)
+	enc, err := NewEncoder(ulaw)
+	require.NoError(t, err)
+	dec, err := NewDecoder(ulaw)
+	require.NoError(t, err)
+	require.Equal(t, CodecInfo{PayloadType: 0, SampleRate: 8000, Channels: 1, FrameSize: 160}, enc.Info())
+
+	pcm := []int16{0, 1000, -1000, 8000}
+	payload := make([]byte, 4)
+	n, err := enc.Encode(payload, pcm)
+	require.NoError(t, err)
+	require.Equal(t, 4, n)
+	decoded := make([]int16, 4)
+	n, err = dec.Decode(decoded, payload)
+	require.NoError(t, err)
+	require.Equal(t, 4, n)
+	for i := range pcm {
+		require.InDelta(t, pcm[i], decoded[i], float64(abs(int(pcm[i])))/16+8)
+	}
+	_, err = enc.Encode(payload[:2], pcm)
+	require.Error(t, err)
+
+	_, err = NewEncoder(sdp.Codec{Name: "L16X", ClockRate: 8000})
+	require.Error(t, err)
+
+	RegisterCodec("L16X", CodecFactory{
+		NewEncoder: func(c sdp.Codec) (Encoder, error) {
+			return &pcm16Codec{CodecInfo{c.PayloadType, int(c.ClockRate), 1, 160}}, nil
+		},
+		NewDecoder: func(c sdp.Codec) (Decoder, error) {
+			return &pcm16Codec{CodecInfo{c.PayloadType, int(c.ClockRate), 1, 160}}, nil
+		},
+	})
+	defer func() {
+		codecFactoriesMu.Lock()
+		delete(codecFactories, "l16x")
+		codecFactoriesMu.Unlock()
+	}()
+
+	// Session creates codec of negotiated format
+	sess, err := NewMediaSession(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, nil)
+	require.NoError(t, err)
+	defer sess.Close()
+	sess.Registry = sdp.NewCodecRegistry()
+	require.NoError(t, sess.Registry.Register(sdp.Codec{PayloadType: 98, Name: "L16X", ClockRate: 16000, Channels: 1}))
+	sess.Formats = sdp.NewFormats("98")
+	enc, err = sess.NewEncoder()
+	require.NoError(t, err)
+	require.Equal(t, uint8(98), enc.Info().PayloadType)
+	require.Equal(t, 16000, enc.Info().SampleRate)
+
+	// Reader and writer pass PCM through codec
+	fw := &greetingFakeWriter{}
+	w := NewAudioWriterEncoder(fw, enc)
+	_, err = w.Write([]byte{1, 2, 3, 4})
+	require.NoError(t, err)
+	require.Equal(t, []byte{1, 2, 3, 4}, fw.written)
+
+	dec, err = sess.NewDecoder()
+	require.NoError(t, err)
+	r := NewAudioReaderDecoder(&greetingFakeReader{frames: func(i int) []byte { return fw.written }}, dec)
+	out := make([]byte, 10)
+	n, err = r.Read(out)
+	require.NoError(t, err)
+	require.Equal(t, []byte{1, 2, 3, 4}, out[:n])
+}
@@ -0,0 +1,98 @@
+package sipgox
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/emiago/sipgo/sip"
+)
+
+// DialBlocklist is checked by phone before any call is originated.
+// Use it to enforce do-not-originate or compliance lists
+type DialBlocklist interface {
+	// Blocked returns reason and true if recipient must not be dialed
+	Blocked(recipient sip.Uri) (reason string, blocked bool)
+}
+
+// DialBlocklistFunc allows external callback to be used as DialBlocklist
+type DialBlocklistFunc func(recipient sip.Uri) (reason string, blocked bool)
+
+func (f DialBlocklistFunc) Blocked(recipient sip.Uri) (string, bool) {
+	return f(recipient)
+}
+
+// DialBlockedError is returned by Dial when recipient is blocked
+type DialBlockedError struct {
+	Recipient sip.Uri
+	Reason    string
+}
+
+func (e *DialBlockedError) Error() string {
+	return fmt.Sprintf("dial to %s blocked: %s", e.Recipient.String(), e.Reason)
+}
+
+// NumberBlocklist matches user part of recipient uri by exact number, prefix or regex.
+// It is safe to update while phone is dialing
+type NumberBlocklist struct {
+	mu       sync.RWMutex
+	exact    map[string]struct{}
+	prefixes []string
+	regexps  []*regexp.Regexp
+}
+
+func NewNumberBlocklist() *NumberBlocklist {
+	return &NumberBlocklist{
+		exact: make(map[string]struct{}),
+	}
+}
+
+func (b *NumberBlocklist) AddExact(numbers ...string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, n := range numbers {
+		b.exact[n] = struct{}{}
+	}
+}
+
+func (b *NumberBlocklist) AddPrefix(prefixes ...string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.prefixes = append(b.prefixes, prefixes...)
+}
+
+func (b *NumberBlocklist) AddRegexp(expr string) error {
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.regexps = append(b.regexps, re)
+	return nil
+}
+
+func (b *NumberBlocklist) Blocked(recipient sip.Uri) (string, bool) {
+	number := recipient.User
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if _, exists := b.exact[number]; exists {
+		return "number blocked", true
+	}
+
+	for _, p := range b.prefixes {
+		if strings.HasPrefix(number, p) {
+			return "prefix " + p + " blocked", true
+		}
+	}
+
+	for _, re := range b.regexps {
+		if re.MatchString(number) {
+			return "pattern " + re.String() + " blocked", true
+		}
+	}
+	return "", false
+}
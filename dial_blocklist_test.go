@@ -0,0 +1,84 @@
+package sipgox
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/emiago/sipgo"
+	"github.com/emiago/sipgo/sip"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNumberBlocklist(t *testing.T) {
+	b := NewNumberBlocklist()
+	b.AddExact("123")
+	b.AddPrefix("+1900")
+	require.NoError(t, b.AddRegexp("^555[0-9]{4}$"))
+	require.Error(t, b.AddRegexp("("))
+
+	for user, blocked := range map[string]bool{
+		"123":       true,
+		"1234":      false,
+		"+19001111": true,
+		"5551234":   true,
+		"55512345":  false,
+	} {
+		_, isBlocked := b.Blocked(sip.Uri{User: user, Host: "localhost"})
+		require.Equal(t, blocked, isBlocked, user)
+	}
+}
+
+func TestPhoneDialBlocked(t *testing.T) {
+	ua, err := sipgo.NewUA()
+	require.NoError(t, err)
+	defer ua.Close()
+
+	b := NewNumberBlocklist()
+	b.AddExact("bob")
+	phone := NewPhone(ua, WithPhoneDialBlocklist(b))
+
+	_, err = phone.Dial(context.Background(), sip.Uri{User: "bob", Host: "127.0.0.1", Port: 5060}, DialOptions{})
+	var berr *DialBlockedError
+	require.True(t, errors.As(err, &berr))
+	require.Equal(t, "bob", berr.Recipient.User)
+}
+
+type blocklistFakeTx struct {
+	sip.ServerTransaction
+	res *sip.Response
+}
+
+func (tx *blocklistFakeTx) Respond(res *sip.Response) error {
+	tx.res = res
+	return nil
+}
+
+func TestPhoneReferBlocked(t *testing.T) {
+	ua, err := sipgo.NewUA()
+	require.NoError(t, err)
+	defer ua.Close()
+
+	b := NewNumberBlocklist()
+	b.AddExact("bob")
+	phone := NewPhone(ua, WithPhoneDialBlocklist(b))
+
+	refer := func(target string) *sip.Request {
+		req := sip.NewRequest(sip.REFER, sip.Uri{User: "alice", Host: "127.0.0.1"})
+		req.AppendHeader(&sip.FromHeader{Address: sip.Uri{User: "bob", Host: "127.0.0.2"}, Params: sip.HeaderParams{"tag": "from1"}})
+		req.AppendHeader(&sip.ToHeader{Address: sip.Uri{User: "alice", Host: "127.0.0.1"}, Params: sip.HeaderParams{"tag": "to1"}})
+		callid := sip.CallIDHeader("call-1")
+		req.AppendHeader(&callid)
+		req.AppendHeader(&sip.CSeqHeader{SeqNo: 2, MethodName: sip.REFER})
+		req.AppendHeader(sip.NewHeader("Refer-To", target))
+		return req
+	}
+
+	tx := &blocklistFakeTx{}
+	require.True(t, phone.rejectBlockedRefer(refer("sip:bob@127.0.0.1:5060"), tx))
+	require.Equal(t, sip.StatusForbidden, tx.res.StatusCode)
+
+	tx = &blocklistFakeTx{}
+	require.False(t, phone.rejectBlockedRefer(refer("sip:carol@127.0.0.1:5060"), tx))
+	require.Nil(t, tx.res)
+}
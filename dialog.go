@@ -0,0 +1,63 @@
+package sipgox
+
+import (
+	"context"
+	"time"
+
+	"github.com/emiago/sipgo/sip"
+	"github.com/emiago/sipgox/sdp"
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+)
+
+// Dialog is common interface of DialogClientSession and DialogServerSession.
+// Call control logic depending on it can be tested with MockDialog
+type Dialog interface {
+	Context() context.Context
+	Done() <-chan struct{}
+	State() <-chan sip.DialogState
+	// Media returns media session. It is nil for signaling only calls
+	Media() Media
+	Hangup(ctx context.Context) error
+	Refer(ctx context.Context, referTo sip.Uri) error
+	Close() error
+}
+
+// Media is interface of MediaSession used by call control logic.
+// It can be replaced with MockMediaSession in tests
+type Media interface {
+	ReadRTP() (rtp.Packet, error)
+	ReadRTPDeadline(t time.Time) (rtp.Packet, error)
+	WriteRTP(p *rtp.Packet) error
+	ReadRTCP(pkts []rtcp.Packet) (n int, err error)
+	WriteRTCP(p rtcp.Packet) error
+
+	LocalSDP() []byte
+	RemoteSDP(sdpReceived []byte) error
+	HoldOffer(mode sdp.Mode) []byte
+	ResumeOffer() []byte
+	Direction() sdp.Mode
+	Close()
+}
+
+var (
+	_ Dialog = (*DialogClientSession)(nil)
+	_ Dialog = (*DialogServerSession)(nil)
+	_ Media  = (*MediaSession)(nil)
+)
+
+// Media returns media session of dialog
+func (d *DialogClientSession) Media() Media {
+	if d.MediaSession == nil {
+		return nil
+	}
+	return d.MediaSession
+}
+
+// Media returns media session of dialog
+func (d *DialogServerSession) Media() Media {
+	if d.MediaSession == nil {
+		return nil
+	}
+	return d.MediaSession
+}
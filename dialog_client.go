@@ -2,7 +2,6 @@ package sipgox
 
 import (
 	"context"
-	"sync"
 	"time"
 
 	"github.com/emiago/sipgo"
@@ -16,14 +15,24 @@ type DialogClientSession struct {
 
 	*sipgo.DialogClientSession
 
-	subscriptions sync.Map
+	// Metadata is call metadata received in answer response headers
+	Metadata CallMetadata
+
+	// Timings are post dial delay, answer delay and duration of call. Ended is set on Close
+	Timings CallTimings
+
+	transfers transfers
 
 	// onClose used to cleanup internal logic
 	onClose func()
+	// onRequest is called with every request sent within dialog, ex. to persist CSeq
+	onRequest func(req *sip.Request)
 }
 
 func (d *DialogClientSession) Close() error {
-	defer d.MediaSession.Close()
+	if d.MediaSession != nil {
+		defer d.MediaSession.Close()
+	}
 
 	if d.onClose != nil {
 		d.onClose()
@@ -93,47 +102,39 @@ func (d *DialogClientSession) Echo() {
 
 // Refer tries todo refer (blind transfer) on call
 func (d *DialogClientSession) Refer(ctx context.Context, referTo sip.Uri) error {
+	// There is now implicit subscription
+	_, err := d.ReferTransfer(ctx, referTo)
+	return err
+}
+
+// TransactionRequest sends request within dialog
+func (d *DialogClientSession) TransactionRequest(ctx context.Context, req *sip.Request) (sip.ClientTransaction, error) {
+	tx, err := d.DialogClientSession.TransactionRequest(ctx, req)
+	// CSeq is consumed even when sending fails
+	if d.onRequest != nil {
+		d.onRequest(req)
+	}
+	return tx, err
+}
+
+// ReferTransfer does blind transfer and returns transfer progress once REFER is accepted.
+// Use Transfer.WaitComplete before hanging up to be sure transfer went through
+func (d *DialogClientSession) ReferTransfer(ctx context.Context, referTo sip.Uri) (*Transfer, error) {
 	// TODO check state of call
 
 	req := sip.NewRequest(sip.REFER, d.InviteRequest.Recipient)
 	UACRequestBuild(req, d.InviteRequest, d.InviteResponse)
+	return d.transfers.refer(ctx, d, req, referTo)
+}
 
-	req.AppendHeader(sip.NewHeader("Refer-to", referTo.String()))
-
-	tx, err := d.TransactionRequest(ctx, req)
-	if err != nil {
-		return err
-	}
-
-	select {
-	case <-tx.Done():
-		return tx.Err()
-	case res := <-tx.Responses():
-		if res.StatusCode != sip.StatusAccepted {
-			return sipgo.ErrDialogResponse{
-				Res: res,
-			}
-		}
-
-	case <-ctx.Done():
-		return tx.Cancel()
+// Notify reads NOTIFY of transfer implicit subscription
+func (d *DialogClientSession) Notify(req *sip.Request) error {
+	if req.CallID().Value() != d.InviteRequest.CallID().Value() {
+		return sipgo.ErrDialogDoesNotExists
 	}
-
-	// There is now implicit subscription
-	return nil
+	return d.transfers.readNotify(req)
 }
 
-// func (d *DialogClientSession) readNotify(req *sip.Request, tx sip.ServerTransaction) error {
-// 	sub := d.subscriptions.Load(req.CallID().Value())
-
-// 	select {
-// 	case <-d.Context().Done():
-// 		return d.Context().Err()
-// 	case d.notifyChan <- req:
-// 	}
-// 	return nil
-// }
-
 func (d *DialogClientSession) MediaStream(s MediaStreamer) error {
 	return s.MediaStream(d.MediaSession)
 }
@@ -2,8 +2,6 @@ package sipgox
 
 import (
 	"context"
-	"fmt"
-	"strings"
 
 	"github.com/emiago/sipgo"
 	"github.com/emiago/sipgo/sip"
@@ -15,10 +13,15 @@ type DialogServerSession struct {
 
 	*sipgo.DialogServerSession
 
-	waitNotify chan error
+	// Metadata is call metadata received in INVITE request headers
+	Metadata CallMetadata
+
+	transfers transfers
 
 	// onClose used to cleanup internal logic
 	onClose func()
+	// onRequest is called with every request sent within dialog, ex. to persist CSeq
+	onRequest func(req *sip.Request)
 }
 
 func (d *DialogServerSession) Close() error {
@@ -46,70 +49,39 @@ func (d *DialogServerSession) Bye(ctx context.Context) error {
 
 // Refer tries todo refer (blind transfer) on call
 func (d *DialogServerSession) Refer(ctx context.Context, referTo sip.Uri) error {
-	// TODO check state of call
-
-	req := sip.NewRequest(sip.REFER, d.InviteRequest.Contact().Address)
-	UASRequestBuild(req, d.InviteResponse)
-
-	// Invite request tags must be preserved but switched
-	req.AppendHeader(sip.NewHeader("Refer-to", referTo.String()))
-
-	d.waitNotify = make(chan error)
-
-	tx, err := d.TransactionRequest(ctx, req)
-	if err != nil {
+	if _, err := d.ReferTransfer(ctx, referTo); err != nil {
 		return err
 	}
+	return d.Hangup(ctx)
+}
 
-	select {
-	case <-tx.Done():
-		return tx.Err()
-	case res := <-tx.Responses():
-		if res.StatusCode != sip.StatusAccepted {
-			return sipgo.ErrDialogResponse{
-				Res: res,
-			}
-		}
-
-	case <-ctx.Done():
-		return tx.Cancel()
+// TransactionRequest sends request within dialog
+func (d *DialogServerSession) TransactionRequest(ctx context.Context, req *sip.Request) (sip.ClientTransaction, error) {
+	tx, err := d.DialogServerSession.TransactionRequest(ctx, req)
+	// CSeq is consumed even when sending fails
+	if d.onRequest != nil {
+		d.onRequest(req)
 	}
+	return tx, err
+}
 
-	return d.Hangup(ctx)
+// ReferTransfer does blind transfer and returns transfer progress once REFER is accepted.
+// Use Transfer.WaitComplete before hanging up to be sure transfer went through
+func (d *DialogServerSession) ReferTransfer(ctx context.Context, referTo sip.Uri) (*Transfer, error) {
+	// TODO check state of call
 
-	// There is now implicit subscription
-	select {
-	case e := <-d.waitNotify:
-		return e
-	case <-d.Context().Done():
-		return d.Context().Err()
-	}
+	req := sip.NewRequest(sip.REFER, d.InviteRequest.Contact().Address)
+	// Invite request tags must be preserved but switched
+	UASRequestBuild(req, d.InviteResponse)
+	return d.transfers.refer(ctx, d, req, referTo)
 }
 
+// Notify reads NOTIFY of transfer implicit subscription
 func (d *DialogServerSession) Notify(req *sip.Request) error {
 	if req.CallID().Value() != d.InviteResponse.CallID().Value() {
 		return sipgo.ErrDialogDoesNotExists
 	}
-
-	if req.Body() == nil {
-		return fmt.Errorf("no body in notify")
-	}
-
-	if strings.HasPrefix("SIP/2.0 1", string(req.Body())) {
-		return nil
-	}
-
-	var e error = nil
-	if !strings.HasPrefix("SIP/2.0 200", string(req.Body())) {
-		e = fmt.Errorf("not 200 response")
-	}
-
-	select {
-	case d.waitNotify <- e:
-	case <-d.Context().Done():
-		return d.Context().Err()
-	}
-	return nil
+	return d.transfers.readNotify(req)
 }
 
 func (d *DialogServerSession) Echo() {
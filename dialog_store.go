@@ -0,0 +1,303 @@
+package sipgox
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/emiago/sipgo"
+	"github.com/emiago/sipgo/sip"
+)
+
+// DialogState is persisted state of confirmed dialog.
+// Invite request and response hold route set, tags and targets needed for in-dialog requests
+type DialogState struct {
+	ID string
+	// UAC is true when dialog was created by our INVITE
+	UAC bool
+	// InviteRequest and InviteResponse are raw SIP messages
+	InviteRequest  string
+	InviteResponse string
+	// CSeq is last local CSeq number used in dialog
+	CSeq uint32
+}
+
+// DialogStore persists confirmed dialogs so they survive process restart.
+// Implementations must be safe for concurrent use
+type DialogStore interface {
+	Save(state DialogState) error
+	Delete(id string) error
+	List() ([]DialogState, error)
+}
+
+// WithPhoneDialogStore persists confirmed dialogs created by Dial and Answer.
+// Dialogs are deleted from store on Close
+func WithPhoneDialogStore(s DialogStore) PhoneOption {
+	return func(p *Phone) {
+		p.dialogStore = s
+	}
+}
+
+// FileDialogStore stores every dialog as JSON file in directory
+type FileDialogStore struct {
+	Dir string
+}
+
+func (s *FileDialogStore) path(id string) string {
+	return filepath.Join(s.Dir, base64.RawURLEncoding.EncodeToString([]byte(id))+".json")
+}
+
+func (s *FileDialogStore) Save(state DialogState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	// Write and rename so that crash does not leave partial file
+	tmp := s.path(state.ID) + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path(state.ID))
+}
+
+func (s *FileDialogStore) Delete(id string) error {
+	err := os.Remove(s.path(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+func (s *FileDialogStore) List() ([]DialogState, error) {
+	entries, err := os.ReadDir(s.Dir)
+	if err != nil {
+		return nil, err
+	}
+
+	states := []DialogState{}
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".json") {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(s.Dir, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		var state DialogState
+		if err := json.Unmarshal(data, &state); err != nil {
+			return nil, fmt.Errorf("bad dialog state file %q: %w", e.Name(), err)
+		}
+		states = append(states, state)
+	}
+	return states, nil
+}
+
+// MemoryDialogStore keeps dialogs in memory. Useful for testing
+type MemoryDialogStore struct {
+	dialogs sync.Map
+}
+
+func (s *MemoryDialogStore) Save(state DialogState) error {
+	s.dialogs.Store(state.ID, state)
+	return nil
+}
+
+func (s *MemoryDialogStore) Delete(id string) error {
+	s.dialogs.Delete(id)
+	return nil
+}
+
+func (s *MemoryDialogStore) List() ([]DialogState, error) {
+	states := []DialogState{}
+	s.dialogs.Range(func(key, value any) bool {
+		states = append(states, value.(DialogState))
+		return true
+	})
+	return states, nil
+}
+
+// RecoveredDialog is dialog loaded from store after restart.
+// It can only match in-dialog requests and terminate call with BYE
+type RecoveredDialog struct {
+	State          DialogState
+	InviteRequest  *sip.Request
+	InviteResponse *sip.Response
+}
+
+// RecoverDialogs loads all dialogs from phone dialog store
+func (p *Phone) RecoverDialogs() ([]*RecoveredDialog, error) {
+	if p.dialogStore == nil {
+		return nil, fmt.Errorf("phone has no dialog store")
+	}
+
+	states, err := p.dialogStore.List()
+	if err != nil {
+		return nil, err
+	}
+
+	dialogs := make([]*RecoveredDialog, 0, len(states))
+	for _, state := range states {
+		d, err := newRecoveredDialog(state)
+		if err != nil {
+			return nil, fmt.Errorf("fail to recover dialog %q: %w", state.ID, err)
+		}
+		dialogs = append(dialogs, d)
+	}
+	return dialogs, nil
+}
+
+func newRecoveredDialog(state DialogState) (*RecoveredDialog, error) {
+	msg, err := sip.ParseMessage([]byte(state.InviteRequest))
+	if err != nil {
+		return nil, err
+	}
+	req, ok := msg.(*sip.Request)
+	if !ok {
+		return nil, fmt.Errorf("invite request is not request")
+	}
+
+	msg, err = sip.ParseMessage([]byte(state.InviteResponse))
+	if err != nil {
+		return nil, err
+	}
+	res, ok := msg.(*sip.Response)
+	if !ok {
+		return nil, fmt.Errorf("invite response is not response")
+	}
+
+	return &RecoveredDialog{
+		State:          state,
+		InviteRequest:  req,
+		InviteResponse: res,
+	}, nil
+}
+
+// MatchRequest checks does in-dialog request belong to this dialog
+func (d *RecoveredDialog) MatchRequest(req *sip.Request) bool {
+	readID := sip.UASReadRequestDialogID
+	if d.State.UAC {
+		// Remote side is sending request with our tags swapped
+		readID = sip.UACReadRequestDialogID
+	}
+	id, err := readID(req)
+	if err != nil {
+		return false
+	}
+	return id == d.State.ID
+}
+
+// Bye terminates recovered dialog
+func (d *RecoveredDialog) Bye(ctx context.Context, client *sipgo.Client) error {
+	var bye *sip.Request
+	if d.State.UAC {
+		// Remote target is contact of response
+		target := d.InviteRequest.Recipient
+		if cont := d.InviteResponse.Contact(); cont != nil {
+			target = cont.Address
+		}
+		bye = sip.NewRequest(sip.BYE, target)
+		UACRequestBuild(bye, d.InviteRequest, d.InviteResponse)
+
+		// Route set is Record-Route in reverse order
+		hdrs := d.InviteResponse.GetHeaders("Record-Route")
+		for i := len(hdrs) - 1; i >= 0; i-- {
+			bye.AppendHeader(sip.NewHeader("Route", hdrs[i].Value()))
+		}
+	} else {
+		bye = sip.NewRequest(sip.BYE, d.InviteRequest.Contact().Address)
+		UASRequestBuild(bye, d.InviteResponse)
+		for _, h := range d.InviteRequest.GetHeaders("Record-Route") {
+			bye.AppendHeader(sip.NewHeader("Route", h.Value()))
+		}
+	}
+	bye.SetTransport(d.InviteRequest.Transport())
+
+	// Transaction request increases CSeq
+	bye.AppendHeader(&sip.CSeqHeader{SeqNo: d.State.CSeq, MethodName: sip.BYE})
+
+	tx, err := client.TransactionRequest(ctx, bye)
+	if err != nil {
+		return err
+	}
+	defer tx.Terminate()
+
+	res, err := getResponse(ctx, tx)
+	if err != nil {
+		return err
+	}
+	if !res.IsSuccess() {
+		return sipgo.ErrDialogResponse{Res: res}
+	}
+	return nil
+}
+
+func (p *Phone) saveDialog(uac bool, req *sip.Request, res *sip.Response, cseq uint32) {
+	if p.dialogStore == nil {
+		return
+	}
+
+	id, err := sip.MakeDialogIDFromResponse(res)
+	if err != nil {
+		p.log.Error().Err(err).Msg("Fail to persist dialog")
+		return
+	}
+
+	state := DialogState{
+		ID:             id,
+		UAC:            uac,
+		InviteRequest:  req.String(),
+		InviteResponse: res.String(),
+		CSeq:           cseq,
+	}
+
+	if err := p.dialogStore.Save(state); err != nil {
+		p.log.Error().Err(err).Str("id", id).Msg("Fail to persist dialog")
+	}
+}
+
+// dialogCSeqSaver returns hook which persists CSeq of every request sent within dialog,
+// so recovered dialog continues with higher CSeq. It is nil if phone has no dialog store
+func (p *Phone) dialogCSeqSaver(uac bool, invite *sip.Request, res *sip.Response) func(req *sip.Request) {
+	if p.dialogStore == nil {
+		return nil
+	}
+
+	var mu sync.Mutex
+	var last uint32
+	return func(req *sip.Request) {
+		cseq := req.CSeq()
+		if cseq == nil || req.IsAck() || req.IsCancel() {
+			return
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		// Concurrent requests can finish out of order
+		if cseq.SeqNo <= last {
+			return
+		}
+		last = cseq.SeqNo
+		p.saveDialog(uac, invite, res, cseq.SeqNo)
+	}
+}
+
+func (p *Phone) deleteDialog(res *sip.Response) {
+	if p.dialogStore == nil {
+		return
+	}
+
+	id, err := sip.MakeDialogIDFromResponse(res)
+	if err != nil {
+		return
+	}
+	if err := p.dialogStore.Delete(id); err != nil {
+		p.log.Error().Err(err).Str("id", id).Msg("Fail to delete persisted dialog")
+	}
+}
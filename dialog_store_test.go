@@ -0,0 +1,57 @@
+package sipgox
+
+import (
+	"testing"
+
+	"github.com/emiago/sipgo/sip"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileDialogStoreRecover(t *testing.T) {
+	req := sip.NewRequest(sip.INVITE, sip.Uri{User: "bob", Host: "127.0.0.1", Port: 5060})
+	req.AppendHeader(&sip.FromHeader{Address: sip.Uri{User: "alice", Host: "127.0.0.2"}, Params: sip.HeaderParams{"tag": "from1"}})
+	req.AppendHeader(&sip.ToHeader{Address: sip.Uri{User: "bob", Host: "127.0.0.1"}, Params: sip.NewParams()})
+	callid := sip.CallIDHeader("call-1")
+	req.AppendHeader(&callid)
+	req.AppendHeader(&sip.CSeqHeader{SeqNo: 2, MethodName: sip.INVITE})
+	req.AppendHeader(&sip.ContactHeader{Address: sip.Uri{User: "alice", Host: "127.0.0.2", Port: 5060}})
+
+	res := sip.NewResponseFromRequest(req, 200, "OK", nil)
+	res.To().Params["tag"] = "to1"
+	res.AppendHeader(&sip.ContactHeader{Address: sip.Uri{User: "bob", Host: "127.0.0.1", Port: 5060}})
+
+	store := &FileDialogStore{Dir: t.TempDir()}
+	p := &Phone{dialogStore: store}
+	p.log = p.log.Level(-1)
+	p.saveDialog(true, req, res, req.CSeq().SeqNo)
+
+	dialogs, err := p.RecoverDialogs()
+	require.NoError(t, err)
+	require.Len(t, dialogs, 1)
+
+	d := dialogs[0]
+	require.True(t, d.State.UAC)
+	require.Equal(t, uint32(2), d.State.CSeq)
+	require.Equal(t, "call-1", d.InviteResponse.CallID().Value())
+
+	bye := sip.NewRequest(sip.BYE, req.Contact().Address)
+	UASRequestBuild(bye, res)
+	bye.AppendHeader(&sip.CSeqHeader{SeqNo: 1, MethodName: sip.BYE})
+	require.True(t, d.MatchRequest(bye))
+
+	// In-dialog requests refresh persisted CSeq
+	onRequest := p.dialogCSeqSaver(true, req, res)
+	for _, seq := range []uint32{4, 3} {
+		info := sip.NewRequest(sip.INFO, res.Contact().Address)
+		info.AppendHeader(&sip.CSeqHeader{SeqNo: seq, MethodName: sip.INFO})
+		onRequest(info)
+	}
+	dialogs, err = p.RecoverDialogs()
+	require.NoError(t, err)
+	require.Equal(t, uint32(4), dialogs[0].State.CSeq)
+
+	p.deleteDialog(res)
+	dialogs, err = p.RecoverDialogs()
+	require.NoError(t, err)
+	require.Empty(t, dialogs)
+}
@@ -0,0 +1,291 @@
+package sipgox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// NAPTR is DNS NAPTR record (RFC 3403)
+type NAPTR struct {
+	Order       uint16
+	Preference  uint16
+	Flags       string
+	Service     string
+	Regexp      string
+	Replacement string
+}
+
+// Resolver abstracts DNS lookups done by phone.
+// Implement it to pin resolvers or stub DNS in tests
+type Resolver interface {
+	LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error)
+	LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+	LookupNAPTR(ctx context.Context, name string) ([]*NAPTR, error)
+}
+
+// NetResolver resolves with net.Resolver. NAPTR is not supported by net package
+// so it is queried directly on Nameserver
+type NetResolver struct {
+	// Resolver is used for A/AAAA/SRV. If nil net.DefaultResolver is used
+	Resolver *net.Resolver
+	// Nameserver host:port used for NAPTR. If empty first nameserver from /etc/resolv.conf is used
+	Nameserver string
+}
+
+func (r *NetResolver) resolver() *net.Resolver {
+	if r.Resolver == nil {
+		return net.DefaultResolver
+	}
+	return r.Resolver
+}
+
+func (r *NetResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	return r.resolver().LookupIPAddr(ctx, host)
+}
+
+func (r *NetResolver) LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+	return r.resolver().LookupSRV(ctx, service, proto, name)
+}
+
+func (r *NetResolver) LookupNAPTR(ctx context.Context, name string) ([]*NAPTR, error) {
+	ns := r.Nameserver
+	if ns == "" {
+		var err error
+		ns, err = systemNameserver()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return lookupNAPTR(ctx, ns, name)
+}
+
+// CachingResolver caches lookups of wrapped resolver.
+// Not found answers are cached for NegativeTTL, other errors are not cached
+type CachingResolver struct {
+	Resolver    Resolver
+	TTL         time.Duration
+	NegativeTTL time.Duration
+
+	mu      sync.Mutex
+	entries map[string]dnsCacheEntry
+}
+
+type dnsCacheEntry struct {
+	value   any
+	err     error
+	expires time.Time
+}
+
+// NewCachingResolver wraps resolver with cache. Std resolver does not expose record TTL
+// so fixed ttl is used for all answers
+func NewCachingResolver(r Resolver, ttl time.Duration, negativeTTL time.Duration) *CachingResolver {
+	return &CachingResolver{
+		Resolver:    r,
+		TTL:         ttl,
+		NegativeTTL: negativeTTL,
+		entries:     make(map[string]dnsCacheEntry),
+	}
+}
+
+type srvAnswer struct {
+	cname string
+	addrs []*net.SRV
+}
+
+func (r *CachingResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	v, err := r.lookup("ip:"+host, func() (any, error) {
+		return r.Resolver.LookupIPAddr(ctx, host)
+	})
+	addrs, _ := v.([]net.IPAddr)
+	return addrs, err
+}
+
+func (r *CachingResolver) LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+	v, err := r.lookup("srv:_"+service+"._"+proto+"."+name, func() (any, error) {
+		cname, addrs, err := r.Resolver.LookupSRV(ctx, service, proto, name)
+		return srvAnswer{cname, addrs}, err
+	})
+	ans, _ := v.(srvAnswer)
+	return ans.cname, ans.addrs, err
+}
+
+func (r *CachingResolver) LookupNAPTR(ctx context.Context, name string) ([]*NAPTR, error) {
+	v, err := r.lookup("naptr:"+name, func() (any, error) {
+		return r.Resolver.LookupNAPTR(ctx, name)
+	})
+	recs, _ := v.([]*NAPTR)
+	return recs, err
+}
+
+func (r *CachingResolver) lookup(key string, f func() (any, error)) (any, error) {
+	now := time.Now()
+	r.mu.Lock()
+	if r.entries == nil {
+		r.entries = make(map[string]dnsCacheEntry)
+	}
+	e, exists := r.entries[key]
+	r.mu.Unlock()
+	if exists && now.Before(e.expires) {
+		return e.value, e.err
+	}
+
+	v, err := f()
+	ttl := r.TTL
+	if err != nil {
+		if !isDNSNotFound(err) {
+			return v, err
+		}
+		ttl = r.NegativeTTL
+	}
+	if ttl <= 0 {
+		return v, err
+	}
+
+	r.mu.Lock()
+	r.entries[key] = dnsCacheEntry{value: v, err: err, expires: now.Add(ttl)}
+	r.mu.Unlock()
+	return v, err
+}
+
+// Flush removes all cached answers
+func (r *CachingResolver) Flush() {
+	r.mu.Lock()
+	r.entries = make(map[string]dnsCacheEntry)
+	r.mu.Unlock()
+}
+
+func isDNSNotFound(err error) bool {
+	var dnsErr *net.DNSError
+	return errors.As(err, &dnsErr) && dnsErr.IsNotFound
+}
+
+// LookupENUM resolves E.164 number to SIP URI using ENUM (RFC 6116)
+func LookupENUM(ctx context.Context, r Resolver, number string) (string, error) {
+	digits := make([]byte, 0, len(number))
+	for i := 0; i < len(number); i++ {
+		if number[i] >= '0' && number[i] <= '9' {
+			digits = append(digits, number[i])
+		}
+	}
+	if len(digits) == 0 {
+		return "", fmt.Errorf("invalid E.164 number %q", number)
+	}
+
+	labels := make([]string, 0, len(digits)+1)
+	for i := len(digits) - 1; i >= 0; i-- {
+		labels = append(labels, string(digits[i]))
+	}
+	labels = append(labels, "e164.arpa")
+
+	records, err := r.LookupNAPTR(ctx, strings.Join(labels, "."))
+	if err != nil {
+		return "", err
+	}
+
+	sort.SliceStable(records, func(i, j int) bool {
+		if records[i].Order != records[j].Order {
+			return records[i].Order < records[j].Order
+		}
+		return records[i].Preference < records[j].Preference
+	})
+
+	aus := "+" + string(digits)
+	for _, rec := range records {
+		if !strings.EqualFold(rec.Flags, "u") || !strings.Contains(strings.ToUpper(rec.Service), "E2U+SIP") {
+			continue
+		}
+		uri, err := applyNAPTRRegexp(rec.Regexp, aus)
+		if err != nil {
+			return "", err
+		}
+		return uri, nil
+	}
+	return "", &net.DNSError{Err: "no SIP ENUM record", Name: number, IsNotFound: true}
+}
+
+// applyNAPTRRegexp applies substitution expression in form of delim pattern delim replacement delim flags
+func applyNAPTRRegexp(expr string, input string) (string, error) {
+	if len(expr) < 3 {
+		return "", fmt.Errorf("invalid NAPTR regexp %q", expr)
+	}
+	parts := strings.Split(expr[1:], expr[:1])
+	if len(parts) != 3 {
+		return "", fmt.Errorf("invalid NAPTR regexp %q", expr)
+	}
+
+	pattern := parts[0]
+	if strings.Contains(parts[2], "i") {
+		pattern = "(?i)" + pattern
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return "", fmt.Errorf("invalid NAPTR regexp %q: %w", expr, err)
+	}
+	if !re.MatchString(input) {
+		return "", fmt.Errorf("NAPTR regexp %q does not match %q", expr, input)
+	}
+
+	// Back references are \1 - \9
+	repl := regexp.MustCompile(`\\([0-9])`).ReplaceAllString(parts[1], "$${$1}")
+	return re.ReplaceAllString(input, repl), nil
+}
+
+// resolveSIPDestination resolves host to ip:port (RFC 3263 without NAPTR transport selection).
+// SRV is used when port is not set
+func resolveSIPDestination(ctx context.Context, r Resolver, transport string, host string, port int) (string, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		if port == 0 {
+			port = defaultSIPPort(transport)
+		}
+		return net.JoinHostPort(host, strconv.Itoa(port)), nil
+	}
+
+	if port == 0 {
+		service, proto := "sip", "udp"
+		switch strings.ToLower(transport) {
+		case "tcp", "ws":
+			proto = "tcp"
+		case "tls", "wss":
+			service, proto = "sips", "tcp"
+		}
+
+		_, srvs, err := r.LookupSRV(ctx, service, proto, host)
+		if err != nil && !isDNSNotFound(err) {
+			return "", err
+		}
+		// Records are sorted by priority and weight
+		for _, srv := range srvs {
+			addrs, err := r.LookupIPAddr(ctx, strings.TrimSuffix(srv.Target, "."))
+			if err != nil || len(addrs) == 0 {
+				continue
+			}
+			return net.JoinHostPort(addrs[0].IP.String(), strconv.Itoa(int(srv.Port))), nil
+		}
+		port = defaultSIPPort(transport)
+	}
+
+	addrs, err := r.LookupIPAddr(ctx, host)
+	if err != nil {
+		return "", err
+	}
+	if len(addrs) == 0 {
+		return "", &net.DNSError{Err: "no addresses", Name: host, IsNotFound: true}
+	}
+	return net.JoinHostPort(addrs[0].IP.String(), strconv.Itoa(port)), nil
+}
+
+func defaultSIPPort(transport string) int {
+	switch strings.ToLower(transport) {
+	case "tls", "wss":
+		return 5061
+	}
+	return 5060
+}
@@ -0,0 +1,240 @@
+package sipgox
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	dnsTypeNAPTR = 35
+	dnsClassIN   = 1
+)
+
+// systemNameserver returns first nameserver from /etc/resolv.conf
+func systemNameserver() (string, error) {
+	f, err := os.Open("/etc/resolv.conf")
+	if err != nil {
+		return "", fmt.Errorf("no nameserver for NAPTR lookup: %w", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "nameserver" {
+			return net.JoinHostPort(fields[1], "53"), nil
+		}
+	}
+	return "", fmt.Errorf("no nameserver in /etc/resolv.conf")
+}
+
+// lookupNAPTR queries nameserver over UDP and retries over TCP on truncated answer
+func lookupNAPTR(ctx context.Context, nameserver string, name string) ([]*NAPTR, error) {
+	id := uint16(rand.Uint32())
+	query, err := dnsBuildQuery(id, name, dnsTypeNAPTR)
+	if err != nil {
+		return nil, err
+	}
+
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(5 * time.Second)
+	}
+
+	d := net.Dialer{}
+	conn, err := d.DialContext(ctx, "udp", nameserver)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(deadline)
+
+	if _, err := conn.Write(query); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 4096)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return nil, err
+	}
+
+	records, truncated, err := dnsParseNAPTR(buf[:n], id, name)
+	if !truncated {
+		return records, err
+	}
+
+	tconn, err := d.DialContext(ctx, "tcp", nameserver)
+	if err != nil {
+		return nil, err
+	}
+	defer tconn.Close()
+	tconn.SetDeadline(deadline)
+
+	msg := binary.BigEndian.AppendUint16(nil, uint16(len(query)))
+	if _, err := tconn.Write(append(msg, query...)); err != nil {
+		return nil, err
+	}
+	var l [2]byte
+	if _, err := io.ReadFull(tconn, l[:]); err != nil {
+		return nil, err
+	}
+	resp := make([]byte, binary.BigEndian.Uint16(l[:]))
+	if _, err := io.ReadFull(tconn, resp); err != nil {
+		return nil, err
+	}
+	records, _, err = dnsParseNAPTR(resp, id, name)
+	return records, err
+}
+
+func dnsBuildQuery(id uint16, name string, qtype uint16) ([]byte, error) {
+	msg := make([]byte, 12, 12+len(name)+6)
+	binary.BigEndian.PutUint16(msg[0:], id)
+	binary.BigEndian.PutUint16(msg[2:], 0x0100) // Recursion desired
+	binary.BigEndian.PutUint16(msg[4:], 1)
+
+	for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		if len(label) == 0 || len(label) > 63 {
+			return nil, fmt.Errorf("invalid DNS name %q", name)
+		}
+		msg = append(msg, byte(len(label)))
+		msg = append(msg, label...)
+	}
+	msg = append(msg, 0)
+	msg = binary.BigEndian.AppendUint16(msg, qtype)
+	msg = binary.BigEndian.AppendUint16(msg, dnsClassIN)
+	return msg, nil
+}
+
+var errDNSMalformed = errors.New("malformed DNS message")
+
+func dnsParseNAPTR(msg []byte, id uint16, name string) ([]*NAPTR, bool, error) {
+	if len(msg) < 12 {
+		return nil, false, errDNSMalformed
+	}
+	if binary.BigEndian.Uint16(msg[0:]) != id {
+		return nil, false, fmt.Errorf("DNS response id mismatch")
+	}
+
+	flags := binary.BigEndian.Uint16(msg[2:])
+	if flags&0x0200 != 0 {
+		return nil, true, nil
+	}
+	switch rcode := flags & 0x000F; rcode {
+	case 0:
+	case 3:
+		return nil, false, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+	default:
+		return nil, false, &net.DNSError{Err: fmt.Sprintf("server failure rcode=%d", rcode), Name: name}
+	}
+
+	qdcount := int(binary.BigEndian.Uint16(msg[4:]))
+	ancount := int(binary.BigEndian.Uint16(msg[6:]))
+
+	off := 12
+	var err error
+	for i := 0; i < qdcount; i++ {
+		if _, off, err = dnsReadName(msg, off); err != nil {
+			return nil, false, err
+		}
+		off += 4
+	}
+
+	records := []*NAPTR{}
+	for i := 0; i < ancount; i++ {
+		if _, off, err = dnsReadName(msg, off); err != nil {
+			return nil, false, err
+		}
+		if off+10 > len(msg) {
+			return nil, false, errDNSMalformed
+		}
+		rtype := binary.BigEndian.Uint16(msg[off:])
+		rdlen := int(binary.BigEndian.Uint16(msg[off+8:]))
+		off += 10
+		if off+rdlen > len(msg) {
+			return nil, false, errDNSMalformed
+		}
+		if rtype == dnsTypeNAPTR {
+			rec, err := dnsReadNAPTR(msg, off, off+rdlen)
+			if err != nil {
+				return nil, false, err
+			}
+			records = append(records, rec)
+		}
+		off += rdlen
+	}
+
+	if len(records) == 0 {
+		return nil, false, &net.DNSError{Err: "no NAPTR records", Name: name, IsNotFound: true}
+	}
+	return records, false, nil
+}
+
+func dnsReadNAPTR(msg []byte, off int, end int) (*NAPTR, error) {
+	if off+4 > end {
+		return nil, errDNSMalformed
+	}
+	rec := &NAPTR{
+		Order:      binary.BigEndian.Uint16(msg[off:]),
+		Preference: binary.BigEndian.Uint16(msg[off+2:]),
+	}
+	off += 4
+
+	for _, s := range []*string{&rec.Flags, &rec.Service, &rec.Regexp} {
+		if off >= end || off+1+int(msg[off]) > end {
+			return nil, errDNSMalformed
+		}
+		l := int(msg[off])
+		*s = string(msg[off+1 : off+1+l])
+		off += 1 + l
+	}
+
+	var err error
+	rec.Replacement, _, err = dnsReadName(msg, off)
+	return rec, err
+}
+
+// dnsReadName reads possibly compressed domain name and returns offset after it
+func dnsReadName(msg []byte, off int) (string, int, error) {
+	labels := []string{}
+	next := -1
+	for jumps := 0; ; {
+		if off >= len(msg) {
+			return "", 0, errDNSMalformed
+		}
+		l := int(msg[off])
+		switch {
+		case l == 0:
+			off++
+			if next < 0 {
+				next = off
+			}
+			return strings.Join(labels, "."), next, nil
+
+		case l&0xC0 == 0xC0:
+			if off+1 >= len(msg) || jumps > 10 {
+				return "", 0, errDNSMalformed
+			}
+			if next < 0 {
+				next = off + 2
+			}
+			off = int(binary.BigEndian.Uint16(msg[off:]) & 0x3FFF)
+			jumps++
+
+		default:
+			if off+1+l > len(msg) {
+				return "", 0, errDNSMalformed
+			}
+			labels = append(labels, string(msg[off+1:off+1+l]))
+			off += 1 + l
+		}
+	}
+}
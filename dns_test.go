@@ -0,0 +1,167 @@
+package sipgox
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/emiago/sipgo/sip"
+	"github.com/stretchr/testify/require"
+)
+
+type stubResolver struct {
+	ips    map[string][]net.IPAddr
+	srvs   map[string][]*net.SRV
+	naptrs map[string][]*NAPTR
+	calls  int
+}
+
+func (r *stubResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	r.calls++
+	if ips, ok := r.ips[host]; ok {
+		return ips, nil
+	}
+	return nil, &net.DNSError{Name: host, IsNotFound: true}
+}
+
+func (r *stubResolver) LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+	r.calls++
+	if srvs, ok := r.srvs["_"+service+"._"+proto+"."+name]; ok {
+		return "", srvs, nil
+	}
+	return "", nil, &net.DNSError{Name: name, IsNotFound: true}
+}
+
+func (r *stubResolver) LookupNAPTR(ctx context.Context, name string) ([]*NAPTR, error) {
+	r.calls++
+	if recs, ok := r.naptrs[name]; ok {
+		return recs, nil
+	}
+	return nil, &net.DNSError{Name: name, IsNotFound: true}
+}
+
+func TestCachingResolver(t *testing.T) {
+	stub := &stubResolver{ips: map[string][]net.IPAddr{"sip.example.com": {{IP: net.IPv4(10, 0, 0, 1)}}}}
+	r := NewCachingResolver(stub, time.Minute, time.Minute)
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		ips, err := r.LookupIPAddr(ctx, "sip.example.com")
+		require.NoError(t, err)
+		require.Equal(t, "10.0.0.1", ips[0].IP.String())
+
+		_, err = r.LookupIPAddr(ctx, "missing.example.com")
+		require.True(t, isDNSNotFound(err))
+	}
+	require.Equal(t, 2, stub.calls)
+
+	r.Flush()
+	r.LookupIPAddr(ctx, "sip.example.com")
+	require.Equal(t, 3, stub.calls)
+}
+
+func TestResolveSIPDestination(t *testing.T) {
+	stub := &stubResolver{
+		ips: map[string][]net.IPAddr{
+			"pbx1.example.com": {{IP: net.IPv4(10, 0, 0, 1)}},
+			"example.com":      {{IP: net.IPv4(10, 0, 0, 2)}},
+		},
+		srvs: map[string][]*net.SRV{
+			"_sip._udp.example.com": {{Target: "pbx1.example.com.", Port: 5080}},
+		},
+	}
+	ctx := context.Background()
+
+	dest, err := resolveSIPDestination(ctx, stub, "udp", "example.com", 0)
+	require.NoError(t, err)
+	require.Equal(t, "10.0.0.1:5080", dest)
+
+	dest, err = resolveSIPDestination(ctx, stub, "udp", "example.com", 5070)
+	require.NoError(t, err)
+	require.Equal(t, "10.0.0.2:5070", dest)
+
+	// No SRV falls back to A record
+	dest, err = resolveSIPDestination(ctx, stub, "tls", "example.com", 0)
+	require.NoError(t, err)
+	require.Equal(t, "10.0.0.2:5061", dest)
+
+	dest, err = resolveSIPDestination(ctx, stub, "udp", "127.0.0.1", 0)
+	require.NoError(t, err)
+	require.Equal(t, "127.0.0.1:5060", dest)
+}
+
+func TestLookupENUM(t *testing.T) {
+	stub := &stubResolver{naptrs: map[string][]*NAPTR{
+		"4.3.2.1.4.4.e164.arpa": {
+			{Order: 100, Preference: 10, Flags: "u", Service: "E2U+mailto", Regexp: "!^.*$!mailto:info@example.com!"},
+			{Order: 100, Preference: 20, Flags: "u", Service: "E2U+sip", Regexp: `!^\+44(.*)$!sip:\1@example.com!`},
+		},
+	}}
+
+	uri, err := LookupENUM(context.Background(), stub, "+44 1234")
+	require.NoError(t, err)
+	require.Equal(t, "sip:1234@example.com", uri)
+
+	_, err = LookupENUM(context.Background(), stub, "+385")
+	require.Error(t, err)
+}
+
+func TestLookupNAPTRWire(t *testing.T) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	defer conn.Close()
+
+	go func() {
+		buf := make([]byte, 512)
+		n, raddr, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		query := buf[:n]
+
+		resp := append([]byte{}, query[:2]...)
+		resp = append(resp, 0x81, 0x80, 0, 1, 0, 1, 0, 0, 0, 0)
+		resp = append(resp, query[12:]...)
+		// Answer with name pointer to question
+		rdata := []byte{0, 100, 0, 10, 1, 'u', 7}
+		rdata = append(rdata, "E2U+sip"...)
+		re := "!^.*$!sip:bob@example.com!"
+		rdata = append(rdata, byte(len(re)))
+		rdata = append(rdata, re...)
+		rdata = append(rdata, 0)
+		resp = append(resp, 0xC0, 12, 0, dnsTypeNAPTR, 0, 1, 0, 0, 0, 60)
+		resp = binary.BigEndian.AppendUint16(resp, uint16(len(rdata)))
+		resp = append(resp, rdata...)
+		conn.WriteToUDP(resp, raddr)
+	}()
+
+	r := &NetResolver{Nameserver: conn.LocalAddr().String()}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	recs, err := r.LookupNAPTR(ctx, "4.3.2.1.e164.arpa")
+	require.NoError(t, err)
+	require.Len(t, recs, 1)
+	require.Equal(t, NAPTR{Order: 100, Preference: 10, Flags: "u", Service: "E2U+sip", Regexp: "!^.*$!sip:bob@example.com!"}, *recs[0])
+}
+
+func TestPhoneResolveTargetAddr(t *testing.T) {
+	stub := &stubResolver{ips: map[string][]net.IPAddr{"pbx.example.com": {{IP: net.IPv4(127, 0, 0, 1)}}}}
+	p := &Phone{resolver: stub}
+	ctx := context.Background()
+
+	require.Equal(t, "127.0.0.1:5060", p.resolveTargetAddr(ctx, "pbx.example.com:5060"))
+	require.Equal(t, "10.0.0.1:5060", p.resolveTargetAddr(ctx, "10.0.0.1:5060"))
+	require.Equal(t, "unknown.example.com:5060", p.resolveTargetAddr(ctx, "unknown.example.com:5060"))
+
+	// Interface is chosen for resolved loopback target
+	host, _, err := p.getInterfaceHostPort(ctx, "udp", "pbx.example.com:5060")
+	require.NoError(t, err)
+	require.Equal(t, "127.0.0.99", host)
+
+	// REGISTER is resolved same as INVITE
+	req := sip.NewRequest(sip.REGISTER, sip.Uri{Host: "pbx.example.com", Port: 5060})
+	require.NoError(t, p.resolveDestination(ctx, req))
+	require.Equal(t, "127.0.0.1:5060", req.Destination())
+}
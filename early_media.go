@@ -0,0 +1,69 @@
+package sipgox
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/emiago/sipgo"
+	"github.com/emiago/sipgo/sip"
+	"github.com/rs/zerolog"
+)
+
+// earlyMedia sends 183 Session Progress with answer SDP and runs handler while
+// caller can hear media. Returned code is handler result
+func (p *Phone) earlyMedia(ctx context.Context, log *zerolog.Logger, dialog *sipgo.DialogServerSession, tx sip.ServerTransaction,
+	req *sip.Request, msess *MediaSession, answerSDP []byte, handler func(ctx context.Context, sess *MediaSession) int) (int, error) {
+	res := sip.NewResponseFromRequest(req, 183, "Session Progress", answerSDP)
+	res.AppendHeader(sip.NewHeader("Content-Type", "application/sdp"))
+	p.hooks.outgoing(res)
+	p.tapSIP(TapDirectionOut, res)
+	if err := dialog.WriteResponse(res); err != nil {
+		return 0, fmt.Errorf("failed to send 183 response: %w", err)
+	}
+	p.logSipResponse(log, res)
+
+	earlyCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	canceled := make(chan struct{})
+	go func() {
+		select {
+		case <-tx.Cancels():
+			close(canceled)
+			cancel()
+		case <-tx.Done():
+			cancel()
+		case <-earlyCtx.Done():
+		}
+	}()
+
+	code := handler(earlyCtx, msess)
+	cancel()
+	select {
+	case <-canceled:
+		return 0, fmt.Errorf("received CANCEL")
+	default:
+	}
+	if err := tx.Err(); err != nil {
+		return 0, fmt.Errorf("invite transaction ended during early media: %w", err)
+	}
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	return code, nil
+}
+
+// PlayAudio writes audio encoded with writer payload type in frames of frameSize bytes.
+// Writer paces frames, so it returns when audio is played, ex. announcement in OnEarlyMedia
+func PlayAudio(ctx context.Context, w RTPStreamWriter, audio []byte, frameSize int) error {
+	if frameSize <= 0 {
+		return fmt.Errorf("invalid frame size %d", frameSize)
+	}
+	for len(audio) > 0 {
+		n := min(frameSize, len(audio))
+		if _, err := w.WriteCtx(ctx, audio[:n]); err != nil {
+			return err
+		}
+		audio = audio[n:]
+	}
+	return nil
+}
@@ -0,0 +1,33 @@
+package sipgox
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPlayAudio(t *testing.T) {
+	w := &frameSizeWriter{}
+	audio := greetingTone(400, 1000)
+	require.NoError(t, PlayAudio(context.Background(), w, audio, 160))
+	require.Equal(t, []int{160, 160, 80}, w.frames)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	require.ErrorIs(t, PlayAudio(ctx, w, audio, 160), context.Canceled)
+	require.Error(t, PlayAudio(context.Background(), w, audio, 0))
+}
+
+type frameSizeWriter struct {
+	greetingFakeWriter
+	frames []int
+}
+
+func (w *frameSizeWriter) WriteCtx(ctx context.Context, b []byte) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	w.frames = append(w.frames, len(b))
+	return w.Write(b)
+}
@@ -0,0 +1,156 @@
+package sipgox
+
+import (
+	"sync"
+	"time"
+)
+
+// EchoCanceller removes echo of far end audio from near end audio, ex. sound of speaker picked up
+// by microphone. Implementations keep state, so one is needed per session
+type EchoCanceller interface {
+	// Cancel removes echo from near in place. Far is audio received from remote in same time
+	// and has same length. Samples are mono at sample rate of codec
+	Cancel(near []int16, far []int16)
+}
+
+// EchoCancellerFunc is function implementing EchoCanceller
+type EchoCancellerFunc func(near []int16, far []int16)
+
+func (f EchoCancellerFunc) Cancel(near []int16, far []int16) {
+	f(near, far)
+}
+
+// echoFarDuration is far end audio kept for near end frames
+const echoFarDuration = time.Second
+
+// echoReference keeps decoded far end audio on wall clock timeline, so near end frame is matched
+// with far end audio received in same time, even when reads and writes drift or pause.
+// AudioReader pushes, AudioWriter pulls
+type echoReference struct {
+	mu sync.Mutex
+	// far is ring of echoFarDuration. Sample at position p is at index p % len(far)
+	far        []int16
+	sampleRate int
+	start      time.Time
+	// end is position after newest pushed sample
+	end int64
+	// now is time source, replaced in tests
+	now func() time.Time
+}
+
+func (e *echoReference) clock() time.Time {
+	if e.now != nil {
+		return e.now()
+	}
+	return time.Now()
+}
+
+// position is sample position of time t. Caller must hold lock
+func (e *echoReference) position(t time.Time) int64 {
+	return int64(t.Sub(e.start)) * int64(e.sampleRate) / int64(time.Second)
+}
+
+// push adds far end samples received now. Samples of burst, ex. after jitter,
+// follow previous ones instead of overlapping them
+func (e *echoReference) push(pcm []int16, sampleRate int) {
+	if sampleRate <= 0 {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	now := e.clock()
+	if e.sampleRate != sampleRate {
+		e.sampleRate = sampleRate
+		e.far = make([]int16, int64(sampleRate)*int64(echoFarDuration)/int64(time.Second))
+		// Timeline starts ring before now, so first samples are placed before now
+		e.start = now.Add(-echoFarDuration)
+		e.end = 0
+	}
+
+	size := int64(len(e.far))
+	start := max(e.position(now)-int64(len(pcm)), e.end)
+	// Gap since last push is silence
+	for p := max(e.end, start-size); p < start; p++ {
+		e.far[p%size] = 0
+	}
+	if n := int64(len(pcm)); n > size {
+		pcm = pcm[n-size:]
+		start += n - size
+	}
+	for i, s := range pcm {
+		e.far[(start+int64(i))%size] = s
+	}
+	e.end = start + int64(len(pcm))
+}
+
+// pull fills dst with far end samples received during last len(dst) samples before now.
+// Missing samples and samples of other sample rate are silence
+func (e *echoReference) pull(dst []int16, sampleRate int) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	clear(dst)
+	if e.sampleRate != sampleRate || len(e.far) == 0 {
+		return
+	}
+
+	size := int64(len(e.far))
+	from := e.position(e.clock()) - int64(len(dst))
+	for i := range dst {
+		p := from + int64(i)
+		if p >= 0 && p < e.end && p >= e.end-size {
+			dst[i] = e.far[p%size]
+		}
+	}
+}
+
+// NLMSEchoCanceller is built in echo canceller using normalized least mean squares adaptive filter.
+// It handles echo paths shorter than filter length, ex. speakerphone, but not nonlinear distortion
+type NLMSEchoCanceller struct {
+	// Step is adaptation rate (0-1]. Higher adapts faster but is less stable
+	Step float64
+
+	weights []float64
+	history []float64
+	// pos is index of newest far end sample in history
+	pos   int
+	power float64
+}
+
+// NewNLMSEchoCanceller creates echo canceller with filter of taps samples,
+// ex. 256 covers echo path of 32ms at 8000
+func NewNLMSEchoCanceller(taps int) *NLMSEchoCanceller {
+	taps = max(taps, 1)
+	return &NLMSEchoCanceller{
+		Step:    0.5,
+		weights: make([]float64, taps),
+		history: make([]float64, taps),
+	}
+}
+
+func (c *NLMSEchoCanceller) Cancel(near []int16, far []int16) {
+	taps := len(c.weights)
+	for i := range near {
+		var x float64
+		if i < len(far) {
+			x = float64(far[i])
+		}
+		c.pos = (c.pos + 1) % taps
+		c.power += x*x - c.history[c.pos]*c.history[c.pos]
+		c.history[c.pos] = x
+
+		var echo float64
+		for k, w := range c.weights {
+			echo += w * c.history[(c.pos-k+taps)%taps]
+		}
+		e := float64(near[i]) - echo
+		near[i] = clampInt16(e)
+
+		if c.power < 1 {
+			continue
+		}
+		mu := c.Step * e / (c.power + 1)
+		for k := range c.weights {
+			c.weights[k] += mu * c.history[(c.pos-k+taps)%taps]
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package sipgox
+
+import (
+	"math"
+	"math/rand"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNLMSEchoCanceller(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	c := NewNLMSEchoCanceller(64)
+
+	// Echo is far end delayed by 10 samples and attenuated
+	var history []int16
+	var inPower, outPower float64
+	for frame := 0; frame < 200; frame++ {
+		far := make([]int16, 160)
+		near := make([]int16, 160)
+		for i := range far {
+			far[i] = int16(rnd.NormFloat64() * 3000)
+			history = append(history, far[i])
+			if j := len(history) - 11; j >= 0 {
+				near[i] = history[j] / 2
+			}
+		}
+		in := append([]int16{}, near...)
+		c.Cancel(near, far)
+		if frame >= 150 {
+			for i := range near {
+				inPower += float64(in[i]) * float64(in[i])
+				outPower += float64(near[i]) * float64(near[i])
+			}
+		}
+	}
+	// Echo return loss enhancement after convergence
+	require.Greater(t, 10*math.Log10(inPower/outPower), 30.0)
+}
+
+func TestAudioEchoCanceller(t *testing.T) {
+	sess, err := NewMediaSession(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, nil)
+	require.NoError(t, err)
+	defer sess.Close()
+	sess.SetRemoteAddr(sess.Laddr)
+
+	// Frozen clock, so far frame is pulled whole however long read and write take
+	now := time.Now()
+	sess.echoFar.now = func() time.Time { return now }
+	frames := [][]int16{}
+	sess.EchoCanceller = EchoCancellerFunc(func(near []int16, far []int16) {
+		frames = append(frames, append([]int16{}, far...))
+		for i := range near {
+			near[i] -= far[i]
+		}
+	})
+
+	// Session sends to itself, so written audio is received as far end
+	w, err := NewAudioWriter(NewRTPWriter(sess), 0)
+	require.NoError(t, err)
+	r, err := NewAudioReader(NewRTPReader(sess), 0)
+	require.NoError(t, err)
+
+	pcm := make([]int16, 160)
+	for i := range pcm {
+		pcm[i] = 1000
+	}
+	_, err = w.Write(pcmBytes(nil, pcm))
+	require.NoError(t, err)
+	sess.rtpConn.SetReadDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, 320)
+	_, err = r.Read(buf)
+	require.NoError(t, err)
+
+	// Near frame is synchronized with received far frame and echo is removed
+	_, err = w.Write(pcmBytes(nil, pcm))
+	require.NoError(t, err)
+	sess.rtpConn.SetReadDeadline(time.Now().Add(time.Second))
+	n, err := r.Read(buf)
+	require.NoError(t, err)
+	require.Len(t, frames, 2)
+	require.Equal(t, make([]int16, 160), frames[0])
+	require.InDelta(t, 1000, frames[1][0], 50)
+	for _, s := range pcmSamples(nil, buf[:n]) {
+		require.InDelta(t, 0, s, 50)
+	}
+}
+
+func TestEchoReferenceAlignment(t *testing.T) {
+	now := time.Unix(100, 0)
+	e := &echoReference{now: func() time.Time { return now }}
+	frame := func(v int16) []int16 {
+		pcm := make([]int16, 320)
+		for i := range pcm {
+			pcm[i] = v
+		}
+		return pcm
+	}
+
+	// Buffer is sized by sample rate
+	e.push(frame(1), 16000)
+	require.Len(t, e.far, 16000)
+
+	// Far frame is pulled at time it was received, not in order of pulls
+	dst := make([]int16, 320)
+	now = now.Add(20 * time.Millisecond)
+	e.push(frame(2), 16000)
+	now = now.Add(10 * time.Millisecond)
+	e.pull(dst, 16000)
+	require.Equal(t, int16(2), dst[0])
+	require.Equal(t, int16(0), dst[319])
+
+	// Pause of reads leaves silence and burst follows previous frames
+	now = now.Add(100 * time.Millisecond)
+	e.push(frame(3), 16000)
+	e.push(frame(4), 16000)
+	e.pull(dst, 16000)
+	require.Equal(t, frame(3), dst)
+	now = now.Add(20 * time.Millisecond)
+	e.pull(dst, 16000)
+	require.Equal(t, frame(4), dst)
+
+	// Other sample rate is silence
+	e.pull(dst, 8000)
+	require.Equal(t, make([]int16, 320), dst)
+}
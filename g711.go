@@ -0,0 +1,137 @@
+package sipgox
+
+import (
+	"encoding/binary"
+
+	"github.com/emiago/sipgox/sdp"
+)
+
+// G.711 conversion between 8 bit companded and 16 bit linear samples (ITU-T G.711)
+
+const (
+	ulawBias = 0x84
+	ulawClip = 32635
+)
+
+func ulawDecode(u byte) int16 {
+	u = ^u
+	t := (int16(u&0x0F) << 3) + ulawBias
+	t <<= (u & 0x70) >> 4
+	if u&0x80 != 0 {
+		return ulawBias - t
+	}
+	return t - ulawBias
+}
+
+func ulawEncode(s int16) byte {
+	sample := int(s)
+	sign := 0
+	if sample < 0 {
+		sample = -sample
+		sign = 0x80
+	}
+	if sample > ulawClip {
+		sample = ulawClip
+	}
+	sample += ulawBias
+
+	exponent := 7
+	for mask := 0x4000; sample&mask == 0 && exponent > 0; mask >>= 1 {
+		exponent--
+	}
+	mantissa := (sample >> (exponent + 3)) & 0x0F
+	return ^byte(sign | exponent<<4 | mantissa)
+}
+
+func alawDecode(a byte) int16 {
+	a ^= 0x55
+	t := int16(a&0x0F) << 4
+	seg := (a & 0x70) >> 4
+	switch seg {
+	case 0:
+		t += 8
+	case 1:
+		t += 0x108
+	default:
+		t += 0x108
+		t <<= seg - 1
+	}
+	if a&0x80 != 0 {
+		return t
+	}
+	return -t
+}
+
+func alawEncode(s int16) byte {
+	sample := int(s)
+	sign := 0x80
+	if sample < 0 {
+		// One's complement keeps symmetry of segments
+		sample = -sample - 1
+		sign = 0
+	}
+	if sample > 0x7FFF {
+		sample = 0x7FFF
+	}
+
+	var b int
+	if sample < 256 {
+		b = sample >> 4
+	} else {
+		exponent := 7
+		for mask := 0x4000; sample&mask == 0 && exponent > 1; mask >>= 1 {
+			exponent--
+		}
+		b = exponent<<4 | (sample>>(exponent+3))&0x0F
+	}
+	return byte(b|sign) ^ 0x55
+}
+
+// g711Funcs returns sample conversion of PCMU or PCMA payload type
+func g711Funcs(payloadType uint8) (decode func(byte) int16, encode func(int16) byte, ok bool) {
+	switch payloadType {
+	case sdp.FormatNumeric(sdp.FORMAT_TYPE_ULAW):
+		return ulawDecode, ulawEncode, true
+	case sdp.FormatNumeric(sdp.FORMAT_TYPE_ALAW):
+		return alawDecode, alawEncode, true
+	}
+	return nil, nil, false
+}
+
+// DecodeULaw decodes µ-law payload to 16 bit little endian PCM.
+// pcm must have 2 bytes per sample. Returns number of written bytes
+func DecodeULaw(pcm []byte, ulaw []byte) int {
+	return g711Decode(pcm, ulaw, ulawDecode)
+}
+
+// EncodeULaw encodes 16 bit little endian PCM to µ-law. Returns number of written bytes
+func EncodeULaw(ulaw []byte, pcm []byte) int {
+	return g711Encode(ulaw, pcm, ulawEncode)
+}
+
+// DecodeALaw decodes A-law payload to 16 bit little endian PCM.
+// pcm must have 2 bytes per sample. Returns number of written bytes
+func DecodeALaw(pcm []byte, alaw []byte) int {
+	return g711Decode(pcm, alaw, alawDecode)
+}
+
+// EncodeALaw encodes 16 bit little endian PCM to A-law. Returns number of written bytes
+func EncodeALaw(alaw []byte, pcm []byte) int {
+	return g711Encode(alaw, pcm, alawEncode)
+}
+
+func g711Decode(pcm []byte, payload []byte, decode func(byte) int16) int {
+	n := min(len(payload), len(pcm)/2)
+	for i, b := range payload[:n] {
+		binary.LittleEndian.PutUint16(pcm[2*i:], uint16(decode(b)))
+	}
+	return 2 * n
+}
+
+func g711Encode(payload []byte, pcm []byte, encode func(int16) byte) int {
+	n := min(len(payload), len(pcm)/2)
+	for i := range payload[:n] {
+		payload[i] = encode(int16(binary.LittleEndian.Uint16(pcm[2*i:])))
+	}
+	return n
+}
@@ -0,0 +1,57 @@
+package sipgox
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestG711(t *testing.T) {
+	for i := 0; i < 256; i++ {
+		b := byte(i)
+		require.Equal(t, b, alawEncode(alawDecode(b)), "alaw %x", b)
+		if b != 0x7F {
+			// Negative zero is encoded as positive
+			require.Equal(t, b, ulawEncode(ulawDecode(b)), "ulaw %x", b)
+		}
+	}
+
+	for _, v := range []int16{0, 100, -100, 1000, -1000, 8000, -8000, 32767, -32768} {
+		require.InDelta(t, v, ulawDecode(ulawEncode(v)), float64(abs(int(v)))/16+8, "ulaw %d", v)
+		require.InDelta(t, v, alawDecode(alawEncode(v)), float64(abs(int(v)))/16+16, "alaw %d", v)
+	}
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+func TestG711PCM(t *testing.T) {
+	pcm := make([]byte, 8)
+	for i, v := range []int16{0, 1000, -1000, 32767} {
+		binary.LittleEndian.PutUint16(pcm[2*i:], uint16(v))
+	}
+
+	for _, c := range []struct {
+		encode func([]byte, []byte) int
+		decode func([]byte, []byte) int
+	}{{EncodeULaw, DecodeULaw}, {EncodeALaw, DecodeALaw}} {
+		payload := make([]byte, 4)
+		require.Equal(t, 4, c.encode(payload, pcm))
+		decoded := make([]byte, 8)
+		require.Equal(t, 8, c.decode(decoded, payload))
+		for i := 0; i < 4; i++ {
+			v := int16(binary.LittleEndian.Uint16(pcm[2*i:]))
+			d := int16(binary.LittleEndian.Uint16(decoded[2*i:]))
+			require.InDelta(t, v, d, float64(abs(int(v)))/16+16)
+		}
+
+		// Short destination is not overflown
+		require.Equal(t, 2, c.encode(payload[:2], pcm))
+		require.Equal(t, 6, c.decode(decoded[:7], payload))
+	}
+}
@@ -0,0 +1,299 @@
+package sipgox
+
+import (
+	"fmt"
+
+	"github.com/emiago/sipgox/sdp"
+)
+
+// G.722 64 kbit/s sub-band ADPCM codec (ITU-T G.722). Audio is sampled at 16000,
+// but RTP clock rate is 8000 as RFC 3551 defines, so every byte of payload is one RTP timestamp unit
+// and two PCM samples
+
+var (
+	g722QMFCoeffs = [12]int{3, -11, 12, 32, -210, 951, 3876, -805, 362, -156, 53, -11}
+
+	g722Q6   = [32]int{0, 35, 72, 110, 150, 190, 233, 276, 323, 370, 422, 473, 530, 587, 650, 714, 786, 858, 940, 1023, 1121, 1219, 1339, 1458, 1612, 1765, 1980, 2195, 2557, 2919, 0, 0}
+	g722ILN  = [32]int{0, 63, 62, 31, 30, 29, 28, 27, 26, 25, 24, 23, 22, 21, 20, 19, 18, 17, 16, 15, 14, 13, 12, 11, 10, 9, 8, 7, 6, 5, 4, 0}
+	g722ILP  = [32]int{0, 61, 60, 59, 58, 57, 56, 55, 54, 53, 52, 51, 50, 49, 48, 47, 46, 45, 44, 43, 42, 41, 40, 39, 38, 37, 36, 35, 34, 33, 32, 0}
+	g722WL   = [8]int{-60, -30, 58, 172, 334, 538, 1198, 3042}
+	g722RL42 = [16]int{0, 7, 6, 5, 4, 3, 2, 1, 7, 6, 5, 4, 3, 2, 1, 0}
+	g722ILB  = [32]int{2048, 2093, 2139, 2186, 2233, 2282, 2332, 2383, 2435, 2489, 2543, 2599, 2656, 2714, 2774, 2834, 2896, 2960, 3025, 3091, 3158, 3228, 3298, 3371, 3444, 3520, 3597, 3676, 3756, 3838, 3922, 4008}
+	g722QM4  = [16]int{0, -20456, -12896, -8968, -6288, -4240, -2584, -1200, 20456, 12896, 8968, 6288, 4240, 2584, 1200, 0}
+	g722QM6  = [64]int{
+		-136, -136, -136, -136, -24808, -21904, -19008, -16704,
+		-14984, -13512, -12280, -11192, -10232, -9360, -8576, -7856,
+		-7192, -6576, -6000, -5456, -4944, -4464, -4008, -3576,
+		-3168, -2776, -2400, -2032, -1688, -1360, -1040, -728,
+		24808, 21904, 19008, 16704, 14984, 13512, 12280, 11192,
+		10232, 9360, 8576, 7856, 7192, 6576, 6000, 5456,
+		4944, 4464, 4008, 3576, 3168, 2776, 2400, 2032,
+		1688, 1360, 1040, 728, 432, 136, -432, -136,
+	}
+	g722QM2 = [4]int{-7408, -1616, 7408, 1616}
+	g722IHN = [3]int{0, 1, 0}
+	g722IHP = [3]int{0, 3, 2}
+	g722WH  = [3]int{0, -214, 798}
+	g722RH2 = [4]int{2, 1, 2, 1}
+)
+
+func g722Saturate(v int) int {
+	return min(max(v, -32768), 32767)
+}
+
+// g722Band is adaptive predictor state of lower or higher sub-band
+type g722Band struct {
+	s, sp, sz int
+	r         [3]int
+	a, ap     [3]int
+	p         [3]int
+	d         [7]int
+	b, bp     [7]int
+	sg        [7]int
+	nb, det   int
+}
+
+// update is block 4 of G.722: reconstruction and adaptation of predictor with quantized difference d
+func (s *g722Band) update(d int) {
+	s.d[0] = d
+	s.r[0] = g722Saturate(s.s + d)
+	s.p[0] = g722Saturate(s.sz + d)
+
+	// UPPOL2
+	for i := 0; i < 3; i++ {
+		s.sg[i] = s.p[i] >> 15
+	}
+	wd1 := g722Saturate(s.a[1] << 2)
+	wd2 := wd1
+	if s.sg[0] == s.sg[1] {
+		wd2 = -wd1
+	}
+	wd2 = min(wd2, 32767)
+	wd3 := -128
+	if s.sg[0] == s.sg[2] {
+		wd3 = 128
+	}
+	wd3 += wd2 >> 7
+	wd3 += (s.a[2] * 32512) >> 15
+	s.ap[2] = min(max(wd3, -12288), 12288)
+
+	// UPPOL1
+	s.sg[0] = s.p[0] >> 15
+	s.sg[1] = s.p[1] >> 15
+	wd1 = -192
+	if s.sg[0] == s.sg[1] {
+		wd1 = 192
+	}
+	wd2 = (s.a[1] * 32640) >> 15
+	s.ap[1] = g722Saturate(wd1 + wd2)
+	wd3 = g722Saturate(15360 - s.ap[2])
+	s.ap[1] = min(max(s.ap[1], -wd3), wd3)
+
+	// UPZERO
+	wd1 = 128
+	if d == 0 {
+		wd1 = 0
+	}
+	s.sg[0] = d >> 15
+	for i := 1; i < 7; i++ {
+		s.sg[i] = s.d[i] >> 15
+		wd2 = -wd1
+		if s.sg[i] == s.sg[0] {
+			wd2 = wd1
+		}
+		wd3 = (s.b[i] * 32640) >> 15
+		s.bp[i] = g722Saturate(wd2 + wd3)
+	}
+
+	// DELAYA
+	for i := 6; i > 0; i-- {
+		s.d[i] = s.d[i-1]
+		s.b[i] = s.bp[i]
+	}
+	for i := 2; i > 0; i-- {
+		s.r[i] = s.r[i-1]
+		s.p[i] = s.p[i-1]
+		s.a[i] = s.ap[i]
+	}
+
+	// FILTEP
+	wd1 = g722Saturate(s.r[1] + s.r[1])
+	wd1 = (s.a[1] * wd1) >> 15
+	wd2 = g722Saturate(s.r[2] + s.r[2])
+	wd2 = (s.a[2] * wd2) >> 15
+	s.sp = g722Saturate(wd1 + wd2)
+
+	// FILTEZ
+	s.sz = 0
+	for i := 6; i > 0; i-- {
+		wd1 = g722Saturate(s.d[i] + s.d[i])
+		s.sz += (s.b[i] * wd1) >> 15
+	}
+	s.sz = g722Saturate(s.sz)
+
+	// PREDIC
+	s.s = g722Saturate(s.sp + s.sz)
+}
+
+// scale updates log scale factor and step size of band (LOGSCL/SCALEL, LOGSCH/SCALEH)
+func (s *g722Band) scale(w int, maxNB int, shift int) {
+	nb := (s.nb*127)>>7 + w
+	s.nb = min(max(nb, 0), maxNB)
+	wd1 := (s.nb >> 6) & 31
+	wd2 := shift - (s.nb >> 11)
+	if wd2 < 0 {
+		s.det = (g722ILB[wd1] << -wd2) << 2
+		return
+	}
+	s.det = (g722ILB[wd1] >> wd2) << 2
+}
+
+// g722Codec is G.722 encoder and decoder. Encoding and decoding keep separate state
+type g722Codec struct {
+	info CodecInfo
+	enc  g722State
+	dec  g722State
+}
+
+type g722State struct {
+	x    [24]int
+	band [2]g722Band
+}
+
+func newG722State() g722State {
+	st := g722State{}
+	st.band[0].det = 32
+	st.band[1].det = 8
+	return st
+}
+
+func newG722Codec(c sdp.Codec) (*g722Codec, error) {
+	return &g722Codec{
+		info: CodecInfo{PayloadType: c.PayloadType, SampleRate: 16000, Channels: 1, FrameSize: 320},
+		enc:  newG722State(),
+		dec:  newG722State(),
+	}, nil
+}
+
+func newG722Encoder(c sdp.Codec) (Encoder, error) {
+	return newG722Codec(c)
+}
+
+func newG722Decoder(c sdp.Codec) (Decoder, error) {
+	return newG722Codec(c)
+}
+
+func (c *g722Codec) Info() CodecInfo {
+	return c.info
+}
+
+// Encode encodes pairs of 16000 samples to one byte each. Odd last sample is not encoded
+func (c *g722Codec) Encode(payload []byte, pcm []int16) (int, error) {
+	if len(payload) < len(pcm)/2 {
+		return 0, fmt.Errorf("payload buffer too small")
+	}
+	st := &c.enc
+	n := 0
+	for j := 0; j+1 < len(pcm); j += 2 {
+		// Transmit QMF
+		copy(st.x[:22], st.x[2:])
+		st.x[22] = int(pcm[j])
+		st.x[23] = int(pcm[j+1])
+		sumOdd, sumEven := 0, 0
+		for i := 0; i < 12; i++ {
+			sumOdd += st.x[2*i] * g722QMFCoeffs[i]
+			sumEven += st.x[2*i+1] * g722QMFCoeffs[11-i]
+		}
+		xlow := (sumEven + sumOdd) >> 14
+		xhigh := (sumEven - sumOdd) >> 14
+
+		// Lower band
+		low := &st.band[0]
+		el := g722Saturate(xlow - low.s)
+		wd := el
+		if el < 0 {
+			wd = -(el + 1)
+		}
+		i := 1
+		for ; i < 30; i++ {
+			if wd < (g722Q6[i]*low.det)>>12 {
+				break
+			}
+		}
+		ilow := g722ILP[i]
+		if el < 0 {
+			ilow = g722ILN[i]
+		}
+		ril := ilow >> 2
+		dlow := (low.det * g722QM4[ril]) >> 15
+		low.scale(g722WL[g722RL42[ril]], 18432, 8)
+		low.update(dlow)
+
+		// Higher band
+		high := &st.band[1]
+		eh := g722Saturate(xhigh - high.s)
+		wd = eh
+		if eh < 0 {
+			wd = -(eh + 1)
+		}
+		mih := 1
+		if wd >= (564*high.det)>>12 {
+			mih = 2
+		}
+		ihigh := g722IHP[mih]
+		if eh < 0 {
+			ihigh = g722IHN[mih]
+		}
+		dhigh := (high.det * g722QM2[ihigh]) >> 15
+		high.scale(g722WH[g722RH2[ihigh]], 22528, 10)
+		high.update(dhigh)
+
+		payload[n] = byte(ihigh<<6 | ilow)
+		n++
+	}
+	return n, nil
+}
+
+// Decode decodes every byte to two 16000 samples
+func (c *g722Codec) Decode(pcm []int16, payload []byte) (int, error) {
+	if len(pcm) < 2*len(payload) {
+		return 0, fmt.Errorf("pcm buffer too small")
+	}
+	st := &c.dec
+	n := 0
+	for _, code := range payload {
+		ilow := int(code & 0x3F)
+		ihigh := int(code>>6) & 0x03
+
+		// Lower band
+		low := &st.band[0]
+		rlow := low.s + (low.det*g722QM6[ilow])>>15
+		rlow = min(max(rlow, -16384), 16383)
+		ril := ilow >> 2
+		dlow := (low.det * g722QM4[ril]) >> 15
+		low.scale(g722WL[g722RL42[ril]], 18432, 8)
+		low.update(dlow)
+
+		// Higher band
+		high := &st.band[1]
+		dhigh := (high.det * g722QM2[ihigh]) >> 15
+		rhigh := dhigh + high.s
+		rhigh = min(max(rhigh, -16384), 16383)
+		high.scale(g722WH[g722RH2[ihigh]], 22528, 10)
+		high.update(dhigh)
+
+		// Receive QMF
+		copy(st.x[:22], st.x[2:])
+		st.x[22] = rlow + rhigh
+		st.x[23] = rlow - rhigh
+		xout1, xout2 := 0, 0
+		for i := 0; i < 12; i++ {
+			xout2 += st.x[2*i] * g722QMFCoeffs[i]
+			xout1 += st.x[2*i+1] * g722QMFCoeffs[11-i]
+		}
+		pcm[n] = int16(g722Saturate(xout1 >> 11))
+		pcm[n+1] = int16(g722Saturate(xout2 >> 11))
+		n += 2
+	}
+	return n, nil
+}
@@ -0,0 +1,89 @@
+package sipgox
+
+import (
+	"bytes"
+	"io"
+	"math"
+	"net"
+	"testing"
+
+	"github.com/emiago/sipgo/fakes"
+	"github.com/emiago/sipgox/sdp"
+	"github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/require"
+)
+
+func TestG722(t *testing.T) {
+	c, ok := sdp.StaticCodec(sdp.FORMAT_TYPE_G722)
+	require.True(t, ok)
+	require.Equal(t, uint32(8000), c.ClockRate)
+	require.Equal(t, uint32(16000), c.SampleRate())
+
+	enc, err := NewEncoder(c)
+	require.NoError(t, err)
+	dec, err := NewDecoder(c)
+	require.NoError(t, err)
+	require.Equal(t, CodecInfo{PayloadType: 9, SampleRate: 16000, Channels: 1, FrameSize: 320}, enc.Info())
+
+	// 1s of 1kHz tone encoded in 20ms frames
+	pcm := make([]int16, 16000)
+	for i := range pcm {
+		pcm[i] = int16(8000 * math.Sin(2*math.Pi*1000*float64(i)/16000))
+	}
+	decoded := make([]int16, 0, len(pcm))
+	payload := make([]byte, 160)
+	frame := make([]int16, 320)
+	for i := 0; i < len(pcm); i += 320 {
+		n, err := enc.Encode(payload, pcm[i:i+320])
+		require.NoError(t, err)
+		require.Equal(t, 160, n)
+
+		n, err = dec.Decode(frame, payload[:n])
+		require.NoError(t, err)
+		require.Equal(t, 320, n)
+		decoded = append(decoded, frame[:n]...)
+	}
+
+	// QMF filters delay signal, find best alignment after adaptation settles
+	best := 0.0
+	for delay := 0; delay < 64; delay++ {
+		var sig, noise float64
+		for i := 4000; i < len(pcm)-64; i++ {
+			d := float64(decoded[i+delay]) - float64(pcm[i])
+			sig += float64(pcm[i]) * float64(pcm[i])
+			noise += d * d
+		}
+		best = max(best, 10*math.Log10(sig/noise))
+	}
+	require.Greater(t, best, 20.0, "SNR %.1f dB", best)
+
+	_, err = enc.Encode(payload[:10], pcm[:320])
+	require.Error(t, err)
+}
+
+func TestG722RTPTimestamp(t *testing.T) {
+	sess := &MediaSession{
+		Formats: sdp.Formats{sdp.FORMAT_TYPE_G722},
+		Laddr:   &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)},
+		Raddr:   &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1234},
+	}
+	sess.SetLogger(log.Logger)
+	sess.rtpConn = &fakes.UDPConn{
+		Writers: map[string]io.Writer{
+			"127.0.0.1:1234": bytes.NewBuffer([]byte{}),
+		},
+	}
+
+	w := NewRTPWriter(sess)
+	require.Equal(t, uint8(9), w.PayloadType)
+	// 20ms of 16000 audio is 160 bytes but advances RTP clock of 8000
+	require.Equal(t, uint32(160), w.ClockRateTimestamp)
+	for i := 0; i < 3; i++ {
+		_, err := w.Write(make([]byte, 160))
+		require.NoError(t, err)
+		require.Equal(t, w.nextTimestamp, w.LastPacket.Timestamp+160)
+	}
+
+	body := sdp.GenerateForAudio(net.IPv4(127, 0, 0, 1), net.IPv4(127, 0, 0, 1), 1234, sdp.ModeSendrecv, sdp.NewFormats(sdp.FORMAT_TYPE_G722))
+	require.Contains(t, string(body), "a=rtpmap:9 G722/8000")
+}
@@ -0,0 +1,108 @@
+package sipgox
+
+import (
+	"math"
+	"sync"
+)
+
+const (
+	agcDefaultTarget  = -20
+	agcDefaultMaxGain = 20
+	// agcNoiseGate is RMS level in dBFS below which AGC keeps gain, so silence is not amplified
+	agcNoiseGate = -50
+)
+
+// GainControl adjusts level of decoded PCM before it is encoded or mixed. Fixed gain is applied
+// first and then AGC when enabled. It keeps AGC state, so one direction of audio needs own GainControl.
+// It is safe for concurrent use, ex. RecvGain of session used by AudioReader and Bridge,
+// but fields must not be changed while audio is processed
+type GainControl struct {
+	// Gain in dB. Negative attenuates
+	Gain float64
+	// AGC enables automatic gain control which moves level towards AGCTarget
+	AGC bool
+	// AGCTarget is RMS level in dBFS AGC aims to. Zero is -20 dBFS
+	AGCTarget float64
+	// AGCMaxGain limits AGC amplification and attenuation in dB. Zero is 20 dB
+	AGCMaxGain float64
+
+	mu sync.Mutex
+	// agc is current AGC gain in dB
+	agc float64
+}
+
+// Process applies gain to interleaved samples in place
+func (g *GainControl) Process(pcm []int16) {
+	if len(pcm) == 0 {
+		return
+	}
+	gain := g.Gain
+	if g.AGC {
+		g.mu.Lock()
+		g.updateAGC(pcm)
+		gain += g.agc
+		g.mu.Unlock()
+	}
+	if gain == 0 {
+		return
+	}
+	factor := math.Pow(10, gain/20)
+	for i, s := range pcm {
+		pcm[i] = clampInt16(float64(s) * factor)
+	}
+}
+
+// updateAGC adapts AGC gain to level of chunk. Gain is reduced fast to avoid clipping
+// and raised slowly so that pauses in speech are not pumped. Caller must hold lock
+func (g *GainControl) updateAGC(pcm []int16) {
+	var sum float64
+	for _, s := range pcm {
+		sum += float64(s) * float64(s)
+	}
+	rms := math.Sqrt(sum/float64(len(pcm))) * math.Pow(10, g.Gain/20)
+	if rms == 0 {
+		return
+	}
+	level := 20 * math.Log10(rms/math.MaxInt16)
+	if level < agcNoiseGate {
+		return
+	}
+
+	target, maxGain := g.AGCTarget, g.AGCMaxGain
+	if target == 0 {
+		target = agcDefaultTarget
+	}
+	if maxGain == 0 {
+		maxGain = agcDefaultMaxGain
+	}
+	desired := min(max(target-level, -maxGain), maxGain)
+
+	rate := 0.05
+	if desired < g.agc {
+		rate = 0.5
+	}
+	g.agc += (desired - g.agc) * rate
+}
+
+// AGCGain returns current AGC gain in dB
+func (g *GainControl) AGCGain() float64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.agc
+}
+
+// Reset drops AGC state, ex. when call is transferred
+func (g *GainControl) Reset() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.agc = 0
+}
+
+// processGains applies non nil gains in order
+func processGains(pcm []int16, gains ...*GainControl) {
+	for _, g := range gains {
+		if g != nil {
+			g.Process(pcm)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package sipgox
+
+import (
+	"context"
+	"math"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/emiago/sipgox/sdp"
+	"github.com/stretchr/testify/require"
+)
+
+func gainTone(amp float64) []int16 {
+	pcm := make([]int16, 160)
+	for i := range pcm {
+		pcm[i] = int16(amp * math.Sin(2*math.Pi*1000*float64(i)/8000))
+	}
+	return pcm
+}
+
+func gainLevel(pcm []int16) float64 {
+	var sum float64
+	for _, s := range pcm {
+		sum += float64(s) * float64(s)
+	}
+	return 20 * math.Log10(math.Sqrt(sum/float64(len(pcm)))/math.MaxInt16)
+}
+
+func TestGainControl(t *testing.T) {
+	pcm := []int16{100, -100, 20000}
+	g := &GainControl{Gain: 6}
+	g.Process(pcm)
+	require.Equal(t, []int16{199, -199, 32767}, pcm)
+
+	// Quiet speech is raised towards target
+	g = &GainControl{AGC: true}
+	var out []int16
+	for i := 0; i < 200; i++ {
+		out = gainTone(600)
+		g.Process(out)
+	}
+	require.InDelta(t, -20, gainLevel(out), 1)
+
+	// Silence keeps gain
+	agc := g.AGCGain()
+	g.Process(make([]int16, 160))
+	require.Equal(t, agc, g.AGCGain())
+
+	// Loud speech is reduced fast
+	for i := 0; i < 10; i++ {
+		out = gainTone(30000)
+		g.Process(out)
+	}
+	require.InDelta(t, -20, gainLevel(out), 1)
+
+	// Gain is limited
+	g = &GainControl{AGC: true, AGCMaxGain: 6}
+	for i := 0; i < 200; i++ {
+		g.Process(gainTone(1000))
+	}
+	require.InDelta(t, 6, g.AGCGain(), 0.1)
+
+	// Shared by reader and bridge
+	g = &GainControl{AGC: true}
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				g.Process(gainTone(600))
+			}
+		}()
+	}
+	wg.Wait()
+	require.Greater(t, g.AGCGain(), 0.0)
+}
+
+func TestBridgeGain(t *testing.T) {
+	a, caller := bridgePair(t, sdp.FORMAT_TYPE_ULAW)
+	b, callee := bridgePair(t, sdp.FORMAT_TYPE_ULAW)
+	b.SendGain = &GainControl{Gain: -6}
+
+	br := NewBridge(a, b)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- br.Run(ctx) }()
+
+	pcm := gainTone(8000)
+	w, err := NewAudioWriter(NewRTPWriter(caller), 0)
+	require.NoError(t, err)
+	go func() {
+		for i := 0; i < 10; i++ {
+			if _, err := w.WriteCtx(ctx, pcmBytes(nil, pcm)); err != nil {
+				return
+			}
+		}
+	}()
+
+	// Session gain of callee leg is applied although codecs are same
+	r, err := NewAudioReader(NewRTPReader(callee), 0)
+	require.NoError(t, err)
+	buf := make([]byte, 320)
+	n, err := r.ReadCtx(ctxTimeout(t, time.Second), buf)
+	require.NoError(t, err)
+	require.InDelta(t, gainLevel(pcm)-6, gainLevel(pcmSamples(nil, buf[:n])), 0.5)
+
+	cancel()
+	require.ErrorIs(t, <-done, context.Canceled)
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package sipgox
+
+import (
+	"errors"
+	"net"
+)
+
+// DefaultGateway is not supported on this platform. Set gateway explicitly
+func DefaultGateway() (net.IP, error) {
+	return nil, errors.ErrUnsupported
+}
@@ -11,6 +11,7 @@ require (
 	github.com/pion/rtp v1.8.6
 	github.com/rs/zerolog v1.32.0
 	github.com/stretchr/testify v1.9.0
+	golang.org/x/sys v0.19.0
 )
 
 require (
@@ -29,5 +30,4 @@ require (
 	github.com/mattn/go-isatty v0.0.20 // indirect
 	github.com/pion/randutil v0.1.0 // indirect
 	github.com/satori/go.uuid v1.2.1-0.20181028125025-b2ce2384e17b // indirect
-	golang.org/x/sys v0.19.0 // indirect
 )
@@ -0,0 +1,392 @@
+package sipgox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+var (
+	ErrGreetingCanceled     = errors.New("greeting recording canceled")
+	ErrGreetingNotConfirmed = errors.New("greeting not confirmed")
+	ErrGreetingNotFound     = errors.New("greeting not found")
+)
+
+// greetingSampleRate is sample rate of G.711 greetings
+const greetingSampleRate = 8000
+
+// Greeting is recorded audio encoded as PCMU or PCMA 8000Hz samples
+type Greeting struct {
+	PayloadType uint8
+	Audio       []byte
+}
+
+// Duration returns length of greeting audio
+func (g Greeting) Duration() time.Duration {
+	return time.Duration(len(g.Audio)) * time.Second / greetingSampleRate
+}
+
+// TrimSilence removes leading and trailing samples with amplitude below threshold.
+// pad of silence is kept on both sides so that speech does not start abruptly
+func TrimSilence(g Greeting, threshold int16, pad time.Duration) (Greeting, error) {
+	decode, _, ok := g711Funcs(g.PayloadType)
+	if !ok {
+		return g, fmt.Errorf("greeting payload type %d not supported", g.PayloadType)
+	}
+
+	start, end := -1, -1
+	for i, s := range g.Audio {
+		if abs16(decode(s)) >= threshold {
+			if start < 0 {
+				start = i
+			}
+			end = i
+		}
+	}
+	if start < 0 {
+		return Greeting{PayloadType: g.PayloadType, Audio: []byte{}}, nil
+	}
+
+	padSamples := int(pad * greetingSampleRate / time.Second)
+	start = max(start-padSamples, 0)
+	end = min(end+padSamples+1, len(g.Audio))
+	return Greeting{PayloadType: g.PayloadType, Audio: append([]byte{}, g.Audio[start:end]...)}, nil
+}
+
+// NormalizeGreeting scales audio so that its peak is at peak (0-1] of full scale
+func NormalizeGreeting(g Greeting, peak float64) (Greeting, error) {
+	decode, encode, ok := g711Funcs(g.PayloadType)
+	if !ok {
+		return g, fmt.Errorf("greeting payload type %d not supported", g.PayloadType)
+	}
+	if peak <= 0 || peak > 1 {
+		return g, fmt.Errorf("invalid normalize peak %v", peak)
+	}
+
+	var maxAmp int16
+	for _, s := range g.Audio {
+		maxAmp = max(maxAmp, abs16(decode(s)))
+	}
+	out := Greeting{PayloadType: g.PayloadType, Audio: make([]byte, len(g.Audio))}
+	if maxAmp == 0 {
+		copy(out.Audio, g.Audio)
+		return out, nil
+	}
+
+	gain := peak * math.MaxInt16 / float64(maxAmp)
+	for i, s := range g.Audio {
+		out.Audio[i] = encode(clampInt16(float64(decode(s)) * gain))
+	}
+	return out, nil
+}
+
+func abs16(v int16) int16 {
+	if v < 0 {
+		if v == math.MinInt16 {
+			return math.MaxInt16
+		}
+		return -v
+	}
+	return v
+}
+
+// GreetingStore stores greetings by name. Implementations must be safe for concurrent use
+type GreetingStore interface {
+	Save(name string, g Greeting) error
+	// Load returns ErrGreetingNotFound if greeting does not exist
+	Load(name string) (Greeting, error)
+	Delete(name string) error
+}
+
+// FileGreetingStore stores every greeting as raw G.711 file in directory,
+// with .pcmu or .pcma extension. Files can be played with sox -t ul or -t al
+type FileGreetingStore struct {
+	Dir string
+}
+
+// greetingExtensions are file extensions by payload type
+var greetingExtensions = map[uint8]string{
+	0: ".pcmu",
+	8: ".pcma",
+}
+
+func (s *FileGreetingStore) Save(name string, g Greeting) error {
+	ext, exists := greetingExtensions[g.PayloadType]
+	if !exists {
+		return fmt.Errorf("greeting payload type %d not supported", g.PayloadType)
+	}
+	if err := s.Delete(name); err != nil {
+		return err
+	}
+
+	// Write and rename so that crash does not leave partial file
+	path := filepath.Join(s.Dir, filepath.Base(name)+ext)
+	if err := os.WriteFile(path+".tmp", g.Audio, 0600); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+func (s *FileGreetingStore) Load(name string) (Greeting, error) {
+	for pt, ext := range greetingExtensions {
+		data, err := os.ReadFile(filepath.Join(s.Dir, filepath.Base(name)+ext))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return Greeting{}, err
+		}
+		return Greeting{PayloadType: pt, Audio: data}, nil
+	}
+	return Greeting{}, ErrGreetingNotFound
+}
+
+func (s *FileGreetingStore) Delete(name string) error {
+	for _, ext := range greetingExtensions {
+		err := os.Remove(filepath.Join(s.Dir, filepath.Base(name)+ext))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// MemoryGreetingStore keeps greetings in memory. Useful for testing
+type MemoryGreetingStore struct {
+	greetings sync.Map
+}
+
+func (s *MemoryGreetingStore) Save(name string, g Greeting) error {
+	s.greetings.Store(name, g)
+	return nil
+}
+
+func (s *MemoryGreetingStore) Load(name string) (Greeting, error) {
+	v, exists := s.greetings.Load(name)
+	if !exists {
+		return Greeting{}, ErrGreetingNotFound
+	}
+	return v.(Greeting), nil
+}
+
+func (s *MemoryGreetingStore) Delete(name string) error {
+	s.greetings.Delete(name)
+	return nil
+}
+
+// GreetingRecorder runs greeting call flow: prompt, record until # or silence,
+// play recording back and confirm with DTMF. 1 accepts, 2 records again and * cancels
+type GreetingRecorder struct {
+	Reader RTPStreamReader
+	Writer RTPStreamWriter
+	// Digits receives DTMF digits of caller. See NewGreetingRecorder
+	Digits <-chan rune
+	// PayloadType of reader and writer. Only PCMU and PCMA are supported
+	PayloadType uint8
+	Store       GreetingStore
+
+	// RecordPrompt and ConfirmPrompt are optional audio encoded with PayloadType
+	RecordPrompt  []byte
+	ConfirmPrompt []byte
+
+	// MaxDuration limits recording length. Default 60s
+	MaxDuration time.Duration
+	// SilenceTimeout stops recording after silence once speech is detected. Default 3s
+	SilenceTimeout time.Duration
+	// SilenceThreshold is amplitude below which samples are silence. Default 500
+	SilenceThreshold int16
+	// ConfirmTimeout is waiting for confirm digit. Default 10s
+	ConfirmTimeout time.Duration
+	// MaxAttempts is number of recordings before giving up. Default 3
+	MaxAttempts int
+	// Peak of normalized greeting. Zero disables normalizing
+	Peak float64
+	// FrameSize is number of samples written per packet. Default 160
+	FrameSize int
+}
+
+// NewGreetingRecorder creates recorder on reader and writer of media session.
+// Reader OnDTMF is replaced to pass digits to recorder
+func NewGreetingRecorder(r *RTPReader, w *RTPWriter, store GreetingStore) *GreetingRecorder {
+	digits := make(chan rune, 10)
+	r.OnDTMF = func(digit rune) {
+		select {
+		case digits <- digit:
+		default:
+		}
+	}
+	return &GreetingRecorder{
+		Reader:      r,
+		Writer:      w,
+		Digits:      digits,
+		PayloadType: w.PayloadType,
+		Store:       store,
+		Peak:        0.9,
+	}
+}
+
+func (g *GreetingRecorder) defaults() {
+	if g.MaxDuration == 0 {
+		g.MaxDuration = 60 * time.Second
+	}
+	if g.SilenceTimeout == 0 {
+		g.SilenceTimeout = 3 * time.Second
+	}
+	if g.SilenceThreshold == 0 {
+		g.SilenceThreshold = 500
+	}
+	if g.ConfirmTimeout == 0 {
+		g.ConfirmTimeout = 10 * time.Second
+	}
+	if g.MaxAttempts == 0 {
+		g.MaxAttempts = 3
+	}
+	if g.FrameSize == 0 {
+		g.FrameSize = 160
+	}
+}
+
+// Record runs call flow and saves confirmed greeting under name in store.
+// Saved greeting is trimmed of silence and normalized
+func (g *GreetingRecorder) Record(ctx context.Context, name string) (Greeting, error) {
+	g.defaults()
+	if _, _, ok := g711Funcs(g.PayloadType); !ok {
+		return Greeting{}, fmt.Errorf("greeting payload type %d not supported", g.PayloadType)
+	}
+
+	for attempt := 0; attempt < g.MaxAttempts; attempt++ {
+		if err := g.play(ctx, g.RecordPrompt); err != nil {
+			return Greeting{}, err
+		}
+
+		greeting, err := g.record(ctx)
+		if err != nil {
+			return Greeting{}, err
+		}
+		greeting, err = g.process(greeting)
+		if err != nil {
+			return Greeting{}, err
+		}
+
+		if err := g.play(ctx, greeting.Audio); err != nil {
+			return Greeting{}, err
+		}
+		if err := g.play(ctx, g.ConfirmPrompt); err != nil {
+			return Greeting{}, err
+		}
+
+		digit, err := g.waitDigit(ctx, g.ConfirmTimeout)
+		if err != nil {
+			return Greeting{}, err
+		}
+		switch digit {
+		case '1':
+			if err := g.Store.Save(name, greeting); err != nil {
+				return Greeting{}, err
+			}
+			return greeting, nil
+		case '*':
+			return Greeting{}, ErrGreetingCanceled
+		}
+	}
+	return Greeting{}, ErrGreetingNotConfirmed
+}
+
+func (g *GreetingRecorder) process(greeting Greeting) (Greeting, error) {
+	greeting, err := TrimSilence(greeting, g.SilenceThreshold, 200*time.Millisecond)
+	if err != nil || g.Peak == 0 {
+		return greeting, err
+	}
+	return NormalizeGreeting(greeting, g.Peak)
+}
+
+// record reads audio until #, silence after speech or max duration
+func (g *GreetingRecorder) record(ctx context.Context) (Greeting, error) {
+	decode, _, _ := g711Funcs(g.PayloadType)
+	recCtx, cancel := context.WithTimeout(ctx, g.MaxDuration)
+	defer cancel()
+
+	g.drainDigits()
+	greeting := Greeting{PayloadType: g.PayloadType}
+	silenceLimit := int(g.SilenceTimeout * greetingSampleRate / time.Second)
+	speech := false
+	silence := 0
+	buf := make([]byte, 1500)
+	for {
+		n, err := g.Reader.ReadCtx(recCtx, buf)
+		if err != nil {
+			if ctx.Err() == nil && recCtx.Err() != nil {
+				// Max duration reached
+				return greeting, nil
+			}
+			return greeting, err
+		}
+		greeting.Audio = append(greeting.Audio, buf[:n]...)
+
+		for _, s := range buf[:n] {
+			if abs16(decode(s)) >= g.SilenceThreshold {
+				speech = true
+				silence = 0
+				continue
+			}
+			silence++
+		}
+		if speech && silence >= silenceLimit {
+			return greeting, nil
+		}
+
+		select {
+		case d := <-g.Digits:
+			if d == '#' {
+				return greeting, nil
+			}
+			if d == '*' {
+				return greeting, ErrGreetingCanceled
+			}
+		default:
+		}
+	}
+}
+
+// waitDigit keeps reading media, as DTMF is received with it, until digit or timeout.
+// Zero digit is returned on timeout
+func (g *GreetingRecorder) waitDigit(ctx context.Context, timeout time.Duration) (rune, error) {
+	waitCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	buf := make([]byte, 1500)
+	for {
+		select {
+		case d := <-g.Digits:
+			return d, nil
+		default:
+		}
+
+		if _, err := g.Reader.ReadCtx(waitCtx, buf); err != nil {
+			if ctx.Err() == nil && waitCtx.Err() != nil {
+				return 0, nil
+			}
+			return 0, err
+		}
+	}
+}
+
+// play writes audio in frames paced by writer
+func (g *GreetingRecorder) play(ctx context.Context, audio []byte) error {
+	return PlayAudio(ctx, g.Writer, audio, g.FrameSize)
+}
+
+func (g *GreetingRecorder) drainDigits() {
+	for {
+		select {
+		case <-g.Digits:
+		default:
+			return
+		}
+	}
+}
@@ -0,0 +1,187 @@
+package sipgox
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func greetingTone(n int, amplitude float64) []byte {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = ulawEncode(int16(amplitude * math.Sin(2*math.Pi*400*float64(i)/8000)))
+	}
+	return b
+}
+
+func greetingSilence(n int) []byte {
+	return greetingTone(n, 0)
+}
+
+type greetingFakeReader struct {
+	frames func(i int) []byte
+	i      int
+}
+
+func (r *greetingFakeReader) Read(b []byte) (int, error) {
+	return r.ReadCtx(context.Background(), b)
+}
+
+func (r *greetingFakeReader) ReadCtx(ctx context.Context, b []byte) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	r.i++
+	return copy(b, r.frames(r.i)), nil
+}
+
+type greetingFakeWriter struct {
+	written []byte
+}
+
+func (w *greetingFakeWriter) Write(b []byte) (int, error) {
+	w.written = append(w.written, b...)
+	return len(b), nil
+}
+
+func (w *greetingFakeWriter) WriteCtx(ctx context.Context, b []byte) (int, error) {
+	return w.Write(b)
+}
+
+func (w *greetingFakeWriter) WriteSamples(payload []byte, clockRateTimestamp uint32, marker bool, payloadType uint8) (int, error) {
+	return w.Write(payload)
+}
+
+func TestGreetingTrimNormalize(t *testing.T) {
+	audio := append(append(greetingSilence(8000), greetingTone(4000, 4000)...), greetingSilence(8000)...)
+	g := Greeting{PayloadType: 0, Audio: audio}
+	require.Equal(t, 2500*time.Millisecond, g.Duration())
+
+	trimmed, err := TrimSilence(g, 500, 100*time.Millisecond)
+	require.NoError(t, err)
+	require.InDelta(t, 4000+2*800, len(trimmed.Audio), 40)
+
+	norm, err := NormalizeGreeting(trimmed, 0.9)
+	require.NoError(t, err)
+	var peak int16
+	for _, s := range norm.Audio {
+		peak = max(peak, abs16(ulawDecode(s)))
+	}
+	require.InDelta(t, 0.9*math.MaxInt16, float64(peak), 0.05*math.MaxInt16)
+
+	_, err = TrimSilence(Greeting{PayloadType: 9}, 500, 0)
+	require.Error(t, err)
+}
+
+func TestGreetingStore(t *testing.T) {
+	for name, store := range map[string]GreetingStore{
+		"File":   &FileGreetingStore{Dir: t.TempDir()},
+		"Memory": &MemoryGreetingStore{},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := store.Load("alice")
+			require.ErrorIs(t, err, ErrGreetingNotFound)
+
+			g := Greeting{PayloadType: 8, Audio: []byte{1, 2, 3}}
+			require.NoError(t, store.Save("alice", g))
+			loaded, err := store.Load("alice")
+			require.NoError(t, err)
+			require.Equal(t, g, loaded)
+
+			require.NoError(t, store.Delete("alice"))
+			_, err = store.Load("alice")
+			require.ErrorIs(t, err, ErrGreetingNotFound)
+		})
+	}
+}
+
+func TestGreetingRecorder(t *testing.T) {
+	digits := make(chan rune, 10)
+	attempt := 0
+	reader := &greetingFakeReader{}
+	reader.frames = func(i int) []byte {
+		switch {
+		case i <= 20:
+			return greetingSilence(160)
+		case i <= 45:
+			return greetingTone(160, 4000)
+		case i == 46:
+			// Caller ends recording
+			digits <- '#'
+			return greetingSilence(160)
+		}
+		// Confirm, first attempt is recorded again
+		attempt++
+		if attempt == 1 {
+			digits <- '2'
+			reader.i = 0
+		} else {
+			digits <- '1'
+		}
+		return greetingSilence(160)
+	}
+	writer := &greetingFakeWriter{}
+	store := &MemoryGreetingStore{}
+
+	rec := &GreetingRecorder{
+		Reader:        reader,
+		Writer:        writer,
+		Digits:        digits,
+		PayloadType:   0,
+		Store:         store,
+		RecordPrompt:  greetingTone(800, 1000),
+		ConfirmPrompt: greetingTone(800, 1000),
+		Peak:          0.9,
+	}
+
+	g, err := rec.Record(context.Background(), "alice")
+	require.NoError(t, err)
+	require.Equal(t, 2, attempt)
+	// Speech with 200ms leading padding. Trailing padding is cut by # frame
+	require.InDelta(t, 25*160+1600+160, len(g.Audio), 160)
+
+	stored, err := store.Load("alice")
+	require.NoError(t, err)
+	require.Equal(t, g, stored)
+
+	// 2 attempts of prompt, playback and confirm prompt
+	require.Equal(t, 2*(800+len(g.Audio)+800), len(writer.written))
+
+	t.Run("Canceled", func(t *testing.T) {
+		digits := make(chan rune, 10)
+		reader := &greetingFakeReader{frames: func(i int) []byte {
+			if i == 10 {
+				digits <- '*'
+			}
+			return greetingTone(160, 4000)
+		}}
+		rec := &GreetingRecorder{Reader: reader, Writer: &greetingFakeWriter{}, Digits: digits, Store: store}
+		_, err := rec.Record(context.Background(), "bob")
+		require.ErrorIs(t, err, ErrGreetingCanceled)
+	})
+
+	t.Run("SilenceTimeout", func(t *testing.T) {
+		reader := &greetingFakeReader{frames: func(i int) []byte {
+			if i <= 10 {
+				return greetingTone(160, 4000)
+			}
+			return greetingSilence(160)
+		}}
+		rec := &GreetingRecorder{
+			Reader:         reader,
+			Writer:         &greetingFakeWriter{},
+			Digits:         make(chan rune),
+			Store:          store,
+			SilenceTimeout: 500 * time.Millisecond,
+			ConfirmTimeout: 10 * time.Millisecond,
+			MaxAttempts:    1,
+		}
+		_, err := rec.Record(context.Background(), "bob")
+		require.ErrorIs(t, err, ErrGreetingNotConfirmed)
+		// Recording stopped on silence and confirm timed out
+		require.Greater(t, reader.i, 10+25)
+	})
+}
@@ -0,0 +1,284 @@
+package sipgox
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"math/rand"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/emiago/sipgo/sip"
+	"github.com/emiago/sipgox/sdp"
+)
+
+const (
+	stunAttrUsername         = 0x0006
+	stunAttrMessageIntegrity = 0x0008
+	stunAttrFingerprint      = 0x8028
+	stunAttrUseCandidate     = 0x0025
+
+	stunFingerprintXOR = 0x5354554e
+)
+
+// ICECandidate is candidate attribute (RFC 8839)
+type ICECandidate = sdp.ICECandidate
+
+// ParseICECandidate parses candidate attribute value with or without "candidate:" prefix
+func ParseICECandidate(attr string) (ICECandidate, error) {
+	return sdp.ParseICECandidate(attr)
+}
+
+// ICEAgent is ICE agent (RFC 8445) of media session.
+// It answers connectivity checks on RTP and RTCP sockets and sends media
+// to address of nominated pair. Full agent also runs checks, see ICEConnect
+type ICEAgent struct {
+	Ufrag string
+	Pwd   string
+	// Lite agent only answers checks
+	Lite bool
+	// Controlling is role of full agent. Offerer is controlling
+	Controlling bool
+
+	tieBreaker uint64
+	// srflx are gathered server reflexive candidates of full agent
+	srflx []ICECandidate
+	// relay are gathered relay candidates and relays are their allocations by component
+	relay  []ICECandidate
+	relays [2]*TURNAllocation
+
+	mu               sync.Mutex
+	remoteUfrag      string
+	remotePwd        string
+	remoteLite       bool
+	remoteCandidates []ICECandidate
+}
+
+func newICEAgent(lite bool) *ICEAgent {
+	return &ICEAgent{
+		Ufrag:      sip.RandString(8),
+		Pwd:        sip.RandString(24),
+		Lite:       lite,
+		tieBreaker: rand.Uint64(),
+	}
+}
+
+// RemoteCandidates returns candidates received in remote SDP
+func (a *ICEAgent) RemoteCandidates() []ICECandidate {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return append([]ICECandidate(nil), a.remoteCandidates...)
+}
+
+// Remote returns remote credentials received in SDP
+func (a *ICEAgent) Remote() (ufrag string, pwd string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.remoteUfrag, a.remotePwd
+}
+
+// hostCandidates returns RTP and RTCP host candidates
+func (a *ICEAgent) hostCandidates(ip net.IP, rtpPort int) []ICECandidate {
+	cands := make([]ICECandidate, 2)
+	for i := range cands {
+		comp := i + 1
+		cands[i] = ICECandidate{
+			Foundation: "1",
+			Component:  comp,
+			Transport:  "UDP",
+			// Host type preference 126 and max local preference
+			Priority: (126 << 24) | (65535 << 8) | uint32(256-comp),
+			IP:       ip,
+			Port:     rtpPort + i,
+			Type:     "host",
+		}
+	}
+	return cands
+}
+
+// sdp adds ICE attributes to generated SDP. ice-lite must be on session level
+func (a *ICEAgent) sdp(body []byte, ip net.IP, rtpPort int) []byte {
+	return sdp.AppendICE(body, sdp.ICEAttributes{
+		Ufrag:      a.Ufrag,
+		Pwd:        a.Pwd,
+		Lite:       a.Lite,
+		Candidates: append(append(a.hostCandidates(ip, rtpPort), a.srflx...), a.relay...),
+	})
+}
+
+// updateRemote reads remote credentials and candidates from SDP attributes
+func (a *ICEAgent) updateRemote(ice sdp.ICEAttributes) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if ice.Ufrag != "" {
+		a.remoteUfrag = ice.Ufrag
+	}
+	if ice.Pwd != "" {
+		a.remotePwd = ice.Pwd
+	}
+	a.remoteLite = ice.Lite
+	a.remoteCandidates = append(a.remoteCandidates[:0], ice.Candidates...)
+}
+
+// bestRemoteCandidate returns highest priority UDP candidate of component
+func (a *ICEAgent) bestRemoteCandidate(component int) (ICECandidate, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	var best ICECandidate
+	found := false
+	for _, c := range a.remoteCandidates {
+		if c.Component != component || !strings.EqualFold(c.Transport, "udp") {
+			continue
+		}
+		if !found || c.Priority > best.Priority {
+			best, found = c, true
+		}
+	}
+	return best, found
+}
+
+// handleCheck answers connectivity check. Returns true if check nominated pair
+func (a *ICEAgent) handleCheck(conn net.PacketConn, msg []byte, raddr net.Addr) (nominated bool, err error) {
+	if binary.BigEndian.Uint16(msg[0:]) != stunBindingRequest {
+		return false, fmt.Errorf("ice: not binding request")
+	}
+
+	var username []byte
+	useCandidate := false
+	miOffset := -1
+	err = stunRangeAttrs(msg, func(typ uint16, val []byte, off int) {
+		switch typ {
+		case stunAttrUsername:
+			username = val
+		case stunAttrUseCandidate:
+			useCandidate = true
+		case stunAttrMessageIntegrity:
+			if miOffset < 0 {
+				miOffset = off
+			}
+		}
+	})
+	if err != nil {
+		return false, err
+	}
+
+	if !bytes.HasPrefix(username, []byte(a.Ufrag+":")) {
+		return false, fmt.Errorf("ice: username mismatch")
+	}
+	if miOffset < 0 || !stunCheckIntegrity(msg, miOffset, []byte(a.Pwd)) {
+		return false, fmt.Errorf("ice: message integrity failed")
+	}
+
+	udpAddr, ok := raddr.(*net.UDPAddr)
+	if !ok {
+		return false, fmt.Errorf("ice: not udp address")
+	}
+
+	res := make([]byte, 20, 96)
+	binary.BigEndian.PutUint16(res[0:], stunBindingResponse)
+	copy(res[4:20], msg[4:20])
+	res = stunAppendXORMappedAddress(res, udpAddr)
+	res = stunAppendIntegrity(res, []byte(a.Pwd))
+	res = stunAppendFingerprint(res)
+
+	if _, err := conn.WriteTo(res, raddr); err != nil {
+		return false, err
+	}
+	return useCandidate, nil
+}
+
+// isSTUN checks is packet STUN message by first bits and magic cookie (RFC 7983)
+func isSTUN(data []byte) bool {
+	return len(data) >= 20 && data[0] < 4 && binary.BigEndian.Uint32(data[4:]) == stunMagicCookie
+}
+
+func stunRangeAttrs(msg []byte, f func(typ uint16, val []byte, off int)) error {
+	length := int(binary.BigEndian.Uint16(msg[2:]))
+	if 20+length > len(msg) {
+		return fmt.Errorf("stun: message truncated")
+	}
+
+	off := 20
+	end := 20 + length
+	for off+4 <= end {
+		typ := binary.BigEndian.Uint16(msg[off:])
+		l := int(binary.BigEndian.Uint16(msg[off+2:]))
+		if off+4+l > end {
+			return fmt.Errorf("stun: attribute truncated")
+		}
+		f(typ, msg[off+4:off+4+l], off)
+		off += 4 + (l+3)&^3
+	}
+	return nil
+}
+
+func stunSetLength(msg []byte, length int) {
+	binary.BigEndian.PutUint16(msg[2:], uint16(length))
+}
+
+func stunAppendAttr(msg []byte, typ uint16, val []byte) []byte {
+	msg = binary.BigEndian.AppendUint16(msg, typ)
+	msg = binary.BigEndian.AppendUint16(msg, uint16(len(val)))
+	msg = append(msg, val...)
+	for i := len(val); i%4 != 0; i++ {
+		msg = append(msg, 0)
+	}
+	stunSetLength(msg, len(msg)-20)
+	return msg
+}
+
+func stunAppendXORMappedAddress(msg []byte, addr *net.UDPAddr) []byte {
+	return stunAppendXORAddress(msg, stunAttrXORMappedAddress, addr)
+}
+
+// stunAppendXORAddress appends XOR encoded address attribute of type
+func stunAppendXORAddress(msg []byte, typ uint16, addr *net.UDPAddr) []byte {
+	ip := addr.IP.To4()
+	family := byte(0x01)
+	if ip == nil {
+		ip = addr.IP.To16()
+		family = 0x02
+	}
+
+	val := make([]byte, 4+len(ip))
+	val[1] = family
+	binary.BigEndian.PutUint16(val[2:], uint16(addr.Port)^uint16(stunMagicCookie>>16))
+	// Cookie and transaction id are XOR key
+	key := msg[4:20]
+	for i := range ip {
+		val[4+i] = ip[i] ^ key[i]
+	}
+	return stunAppendAttr(msg, typ, val)
+}
+
+// stunAppendIntegrity appends MESSAGE-INTEGRITY with short term credential key
+func stunAppendIntegrity(msg []byte, key []byte) []byte {
+	// Length must include integrity attribute while hashing
+	stunSetLength(msg, len(msg)-20+24)
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg)
+	return stunAppendAttr(msg, stunAttrMessageIntegrity, mac.Sum(nil))
+}
+
+func stunCheckIntegrity(msg []byte, miOffset int, key []byte) bool {
+	if miOffset+24 > len(msg) {
+		return false
+	}
+	hdr := make([]byte, miOffset)
+	copy(hdr, msg[:miOffset])
+	stunSetLength(hdr, miOffset-20+24)
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(hdr)
+	return hmac.Equal(mac.Sum(nil), msg[miOffset+4:miOffset+24])
+}
+
+func stunAppendFingerprint(msg []byte) []byte {
+	stunSetLength(msg, len(msg)-20+8)
+	crc := crc32.ChecksumIEEE(msg) ^ stunFingerprintXOR
+	return stunAppendAttr(msg, stunAttrFingerprint, binary.BigEndian.AppendUint32(nil, crc))
+}
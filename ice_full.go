@@ -0,0 +1,328 @@
+package sipgox
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	stunAttrPriority       = 0x0024
+	stunAttrICEControlled  = 0x8029
+	stunAttrICEControlling = 0x802A
+
+	// iceCheckInterval is pacing of connectivity checks (Ta)
+	iceCheckInterval = 50 * time.Millisecond
+	// iceConnectTimeout limits checks done by phone
+	iceConnectTimeout = 5 * time.Second
+	// iceDirectTimeout limits direct checks before relay is tried
+	iceDirectTimeout = 2 * time.Second
+)
+
+var ErrICENoValidPair = errors.New("ice: no valid candidate pair")
+
+// EnableICE enables full ICE agent. Server reflexive candidates are gathered with stun if not nil.
+// After remote SDP is set ICEConnect must be called before reading media.
+// It must be called before LocalSDP and reading
+func (s *MediaSession) EnableICE(stun *STUNClient) (*ICEAgent, error) {
+	return s.EnableICEWithTURN(stun, nil)
+}
+
+// EnableICEWithTURN is EnableICE which also allocates relay candidates with turn if not nil.
+// Relay is used by ICEConnect when direct checks fail
+func (s *MediaSession) EnableICEWithTURN(stun *STUNClient, turn *TURNClient) (*ICEAgent, error) {
+	if s.ice != nil {
+		return s.ice, nil
+	}
+
+	a := newICEAgent(false)
+	for i, conn := range []net.PacketConn{s.rtpConn, s.rtcpConn} {
+		comp := i + 1
+		laddr, ok := conn.LocalAddr().(*net.UDPAddr)
+		if !ok {
+			a.closeRelays()
+			return nil, fmt.Errorf("ice: media transport %s is not udp", conn.LocalAddr())
+		}
+
+		if stun != nil {
+			addr, err := stun.Discover(conn)
+			if err != nil {
+				a.closeRelays()
+				return nil, fmt.Errorf("ice: fail to gather server reflexive candidate: %w", err)
+			}
+			// Not behind NAT if equal
+			if addr.String() != laddr.String() {
+				a.srflx = append(a.srflx, ICECandidate{
+					Foundation:  "2",
+					Component:   comp,
+					Transport:   "UDP",
+					Priority:    (100 << 24) | (65535 << 8) | uint32(256-comp),
+					IP:          addr.IP,
+					Port:        addr.Port,
+					Type:        "srflx",
+					RelatedIP:   laddr.IP,
+					RelatedPort: laddr.Port,
+				})
+			}
+		}
+
+		if turn != nil {
+			alloc, err := turn.Allocate(conn)
+			if err != nil {
+				a.closeRelays()
+				return nil, fmt.Errorf("ice: fail to gather relay candidate: %w", err)
+			}
+			a.mu.Lock()
+			a.relays[i] = alloc
+			a.mu.Unlock()
+			a.relay = append(a.relay, ICECandidate{
+				Foundation:  "3",
+				Component:   comp,
+				Transport:   "UDP",
+				Priority:    (0 << 24) | (65535 << 8) | uint32(256-comp),
+				IP:          alloc.Relayed.IP,
+				Port:        alloc.Relayed.Port,
+				Type:        "relay",
+				RelatedIP:   alloc.Mapped.IP,
+				RelatedPort: alloc.Mapped.Port,
+			})
+		}
+	}
+	s.ice = a
+	return a, nil
+}
+
+// ICEConnect runs connectivity checks against remote candidates and switches
+// remote addresses to first valid pair. Controlling agent nominates pair with
+// aggressive nomination. Media arriving during checks is dropped
+func (s *MediaSession) ICEConnect(ctx context.Context) error {
+	a := s.ice
+	if a == nil || a.Lite {
+		return nil
+	}
+
+	a.mu.Lock()
+	controlling := a.Controlling || a.remoteLite
+	a.mu.Unlock()
+
+	for i, conn := range []net.PacketConn{s.rtpConn, s.rtcpConn} {
+		comp := i + 1
+		cands := a.componentCandidates(comp)
+		if len(cands) == 0 {
+			a.closeRelay(i)
+			continue
+		}
+
+		addr, relayed, err := s.iceConnectComponent(ctx, conn, i, cands, controlling)
+		if err != nil {
+			if comp == 1 {
+				return err
+			}
+			s.log.Info().Err(err).Msg("ICE RTCP component not connected")
+			continue
+		}
+
+		s.mu.Lock()
+		if relayed != nil {
+			if comp == 1 {
+				s.rtpConn = relayed
+			} else {
+				s.rtcpConn = relayed
+			}
+		}
+		if comp == 1 {
+			s.log.Info().Str("raddr", addr.String()).Bool("relay", relayed != nil).Msg("ICE connected")
+			s.Raddr = addr
+		} else {
+			s.rtcpRaddr = addr
+		}
+		s.mu.Unlock()
+	}
+	return nil
+}
+
+// iceConnectComponent checks pairs directly and falls back to relay of component if it is allocated.
+// Relay conn is returned when pair is valid through relay. Unused allocation is released
+func (s *MediaSession) iceConnectComponent(ctx context.Context, conn net.PacketConn, i int, cands []ICECandidate, controlling bool) (*net.UDPAddr, net.PacketConn, error) {
+	comp := i + 1
+	s.ice.mu.Lock()
+	alloc := s.ice.relays[i]
+	s.ice.mu.Unlock()
+	if alloc == nil {
+		addr, err := s.iceCheck(ctx, conn, comp, cands, controlling)
+		return addr, nil, err
+	}
+
+	directCtx, cancel := context.WithTimeout(ctx, iceDirectTimeout)
+	addr, err := s.iceCheck(directCtx, conn, comp, cands, controlling)
+	cancel()
+	if err == nil {
+		s.ice.closeRelay(i)
+		return addr, nil, nil
+	}
+	s.log.Debug().Err(err).Int("component", comp).Msg("ICE direct checks failed, trying relay")
+
+	ips := make([]net.IP, 0, len(cands))
+	for _, c := range cands {
+		if !containsIP(ips, c.IP) {
+			ips = append(ips, c.IP)
+		}
+	}
+	if err := alloc.CreatePermission(ips...); err != nil {
+		s.ice.closeRelay(i)
+		return nil, nil, err
+	}
+
+	relayed := alloc.relayConn()
+	addr, err = s.iceCheck(ctx, relayed, comp, cands, controlling)
+	if err != nil {
+		s.ice.closeRelay(i)
+		return nil, nil, err
+	}
+
+	done := s.closed()
+	s.goEngine(func() {
+		alloc.refreshLoop(done, func(err error) {
+			s.log.Warn().Err(err).Msg("TURN refresh failed")
+			s.reportError(BackgroundTURNRefresh, alloc.server.String(), err)
+		})
+	})
+	return addr, relayed, nil
+}
+
+// closeRelay releases allocation of component. Allocation is kept while session uses relay
+func (a *ICEAgent) closeRelay(i int) {
+	a.mu.Lock()
+	alloc := a.relays[i]
+	a.relays[i] = nil
+	a.mu.Unlock()
+	if alloc == nil {
+		return
+	}
+	alloc.setRelaying(false)
+	alloc.Close()
+}
+
+func (a *ICEAgent) closeRelays() {
+	for i := range a.relays {
+		a.closeRelay(i)
+	}
+}
+
+func (a *ICEAgent) componentCandidates(comp int) []ICECandidate {
+	a.mu.Lock()
+	cands := make([]ICECandidate, 0, len(a.remoteCandidates))
+	for _, c := range a.remoteCandidates {
+		if c.Component == comp && strings.EqualFold(c.Transport, "udp") {
+			cands = append(cands, c)
+		}
+	}
+	a.mu.Unlock()
+
+	sort.SliceStable(cands, func(i, j int) bool {
+		return cands[i].Priority > cands[j].Priority
+	})
+	return cands
+}
+
+func (s *MediaSession) iceCheck(ctx context.Context, conn net.PacketConn, comp int, cands []ICECandidate, controlling bool) (*net.UDPAddr, error) {
+	a := s.ice
+	rufrag, rpwd := a.Remote()
+	defer conn.SetReadDeadline(time.Time{})
+
+	// Checks are retransmitted until context is done
+	txs := map[string]*net.UDPAddr{}
+	buf := make([]byte, 1500)
+	for i := 0; ; i++ {
+		if err := ctx.Err(); err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrICENoValidPair, err)
+		}
+
+		c := cands[i%len(cands)]
+		raddr := &net.UDPAddr{IP: c.IP, Port: c.Port}
+		req, txid, err := a.buildCheck(rufrag, rpwd, comp, controlling)
+		if err != nil {
+			return nil, err
+		}
+		txs[string(txid)] = raddr
+		if _, err := conn.WriteTo(req, raddr); err != nil {
+			s.log.Debug().Err(err).Str("raddr", raddr.String()).Msg("ICE check send failed")
+		}
+
+		conn.SetReadDeadline(time.Now().Add(iceCheckInterval))
+		for {
+			n, from, err := conn.ReadFrom(buf)
+			if err != nil {
+				var netErr net.Error
+				if errors.As(err, &netErr) && netErr.Timeout() {
+					break
+				}
+				if isICMPUnreachable(err) {
+					continue
+				}
+				return nil, err
+			}
+
+			msg := buf[:n]
+			if !isSTUN(msg) {
+				continue
+			}
+
+			switch binary.BigEndian.Uint16(msg[0:]) {
+			case stunBindingRequest:
+				// Remote checks must be answered as well
+				nominated, err := a.handleCheck(conn, msg, from)
+				if err == nil && nominated {
+					return from.(*net.UDPAddr), nil
+				}
+			case stunBindingResponse:
+				if _, exists := txs[string(msg[8:20])]; !exists {
+					continue
+				}
+				if !stunCheckResponseIntegrity(msg, []byte(rpwd)) {
+					continue
+				}
+				return from.(*net.UDPAddr), nil
+			}
+		}
+	}
+}
+
+func (a *ICEAgent) buildCheck(rufrag string, rpwd string, comp int, controlling bool) ([]byte, []byte, error) {
+	req := make([]byte, 20, 128)
+	binary.BigEndian.PutUint16(req[0:], stunBindingRequest)
+	binary.BigEndian.PutUint32(req[4:], stunMagicCookie)
+	if _, err := rand.Read(req[8:20]); err != nil {
+		return nil, nil, err
+	}
+
+	req = stunAppendAttr(req, stunAttrUsername, []byte(rufrag+":"+a.Ufrag))
+	// Priority of peer reflexive candidate
+	req = stunAppendAttr(req, stunAttrPriority, binary.BigEndian.AppendUint32(nil, (110<<24)|(65535<<8)|uint32(256-comp)))
+	tie := binary.BigEndian.AppendUint64(nil, a.tieBreaker)
+	if controlling {
+		req = stunAppendAttr(req, stunAttrICEControlling, tie)
+		req = stunAppendAttr(req, stunAttrUseCandidate, nil)
+	} else {
+		req = stunAppendAttr(req, stunAttrICEControlled, tie)
+	}
+	req = stunAppendIntegrity(req, []byte(rpwd))
+	req = stunAppendFingerprint(req)
+	return req, req[8:20], nil
+}
+
+func stunCheckResponseIntegrity(msg []byte, key []byte) bool {
+	miOffset := -1
+	err := stunRangeAttrs(msg, func(typ uint16, val []byte, off int) {
+		if typ == stunAttrMessageIntegrity && miOffset < 0 {
+			miOffset = off
+		}
+	})
+	return err == nil && miOffset >= 0 && stunCheckIntegrity(msg, miOffset, key)
+}
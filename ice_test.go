@@ -0,0 +1,189 @@
+package sipgox
+
+import (
+	"context"
+	"encoding/binary"
+	"hash/crc32"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseICECandidate(t *testing.T) {
+	c, err := ParseICECandidate("candidate:842163049 1 udp 1677729535 203.0.113.5 46154 typ srflx raddr 10.0.0.5 rport 46154")
+	require.NoError(t, err)
+	require.Equal(t, 1, c.Component)
+	require.Equal(t, uint32(1677729535), c.Priority)
+	require.Equal(t, "203.0.113.5", c.IP.String())
+	require.Equal(t, 46154, c.Port)
+	require.Equal(t, "srflx", c.Type)
+
+	_, err = ParseICECandidate("candidate:1 1 udp")
+	require.Error(t, err)
+}
+
+func TestICELite(t *testing.T) {
+	sess, err := NewMediaSession(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, nil)
+	require.NoError(t, err)
+	defer sess.Close()
+	agent := sess.EnableICELite()
+
+	local := string(sess.LocalSDP())
+	require.Less(t, strings.Index(local, "a=ice-lite"), strings.Index(local, "m=audio"))
+	require.Contains(t, local, "a=ice-ufrag:"+agent.Ufrag)
+	require.Contains(t, local, "a=ice-pwd:"+agent.Pwd)
+	require.Contains(t, local, "a=candidate:1 1 UDP 2130706431 127.0.0.1")
+
+	// Full agent side
+	peer, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	defer peer.Close()
+	paddr := peer.LocalAddr().(*net.UDPAddr)
+
+	remote := "v=0\r\no=- 1 1 IN IP4 0.0.0.0\r\ns=-\r\nc=IN IP4 0.0.0.0\r\nt=0 0\r\nm=audio 9 RTP/AVP 0\r\n" +
+		"a=ice-ufrag:peer\r\na=ice-pwd:peerpasswordpeerpassword\r\n" +
+		"a=candidate:1 1 udp 2130706431 10.0.0.9 4000 typ host\r\n" +
+		"a=candidate:2 1 udp 1694498815 10.0.0.10 4002 typ srflx\r\n"
+	require.NoError(t, sess.RemoteSDP([]byte(remote)))
+	ufrag, _ := agent.Remote()
+	require.Equal(t, "peer", ufrag)
+	require.Len(t, agent.RemoteCandidates(), 2)
+	// Connection address is not usable so best candidate is used
+	require.Equal(t, "10.0.0.9:4000", sess.Raddr.String())
+
+	check := make([]byte, 20)
+	binary.BigEndian.PutUint16(check[0:], stunBindingRequest)
+	binary.BigEndian.PutUint32(check[4:], stunMagicCookie)
+	copy(check[8:], "0123456789ab")
+	check = stunAppendAttr(check, stunAttrUsername, []byte(agent.Ufrag+":peer"))
+	check = stunAppendAttr(check, stunAttrUseCandidate, nil)
+	check = stunAppendIntegrity(check, []byte(agent.Pwd))
+	check = stunAppendFingerprint(check)
+
+	_, err = peer.WriteTo(check, sess.Laddr)
+	require.NoError(t, err)
+	pkt := rtp.Packet{Header: rtp.Header{Version: 2, PayloadType: 0, SequenceNumber: 1}, Payload: []byte{1, 2, 3}}
+	data, _ := pkt.Marshal()
+	_, err = peer.WriteTo(data, sess.Laddr)
+	require.NoError(t, err)
+
+	// Check is answered while reading RTP
+	buf := make([]byte, 1500)
+	sess.rtpConn.SetReadDeadline(time.Now().Add(time.Second))
+	n, err := sess.ReadRTPRaw(buf)
+	require.NoError(t, err)
+	require.Equal(t, data, buf[:n])
+	require.Equal(t, paddr.String(), sess.Raddr.String())
+
+	peer.SetReadDeadline(time.Now().Add(time.Second))
+	n, _, err = peer.ReadFrom(buf)
+	require.NoError(t, err)
+	res := buf[:n]
+	require.Equal(t, uint16(stunBindingResponse), binary.BigEndian.Uint16(res))
+
+	mapped, err := stunParseBindingResponse(res, check[8:20])
+	require.NoError(t, err)
+	require.Equal(t, paddr.String(), mapped.String())
+
+	var miOffset, fpOffset int
+	require.NoError(t, stunRangeAttrs(res, func(typ uint16, val []byte, off int) {
+		switch typ {
+		case stunAttrMessageIntegrity:
+			miOffset = off
+		case stunAttrFingerprint:
+			fpOffset = off
+		}
+	}))
+	require.True(t, stunCheckIntegrity(res, miOffset, []byte(agent.Pwd)))
+	require.Equal(t, crc32.ChecksumIEEE(res[:fpOffset])^stunFingerprintXOR, binary.BigEndian.Uint32(res[fpOffset+4:]))
+
+	// Bad credentials are not answered and do not change remote
+	sess.Raddr = &net.UDPAddr{IP: net.IPv4(10, 0, 0, 9), Port: 4000}
+	check[len(check)-30] ^= 0xFF
+	peer.WriteTo(check, sess.Laddr)
+	peer.WriteTo(data, sess.Laddr)
+	_, err = sess.ReadRTPRaw(buf)
+	require.NoError(t, err)
+	require.Equal(t, "10.0.0.9:4000", sess.Raddr.String())
+
+}
+
+func TestICEFull(t *testing.T) {
+	newSess := func() *MediaSession {
+		sess, err := NewMediaSession(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, nil)
+		require.NoError(t, err)
+		t.Cleanup(sess.Close)
+		return sess
+	}
+
+	t.Run("LitePeer", func(t *testing.T) {
+		a, b := newSess(), newSess()
+		_, err := a.EnableICE(nil)
+		require.NoError(t, err)
+		b.EnableICELite()
+
+		require.NoError(t, b.RemoteSDP(a.LocalSDP()))
+		require.NoError(t, a.RemoteSDP(b.LocalSDP()))
+		// Addresses must be learned by ICE
+		a.Raddr = &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9}
+		b.Raddr = &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9}
+
+		received := make(chan []byte)
+		go func() {
+			buf := make([]byte, 1500)
+			n, err := b.ReadRTPRaw(buf)
+			if err == nil {
+				received <- buf[:n]
+			}
+		}()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		// Remote is lite so we are controlling
+		require.NoError(t, a.ICEConnect(ctx))
+		require.Equal(t, b.Laddr.String(), a.Raddr.String())
+
+		_, err = a.WriteRTPRaw([]byte{0x80, 0, 0, 1})
+		require.NoError(t, err)
+		require.Equal(t, []byte{0x80, 0, 0, 1}, <-received)
+		require.Equal(t, a.Laddr.String(), b.Raddr.String())
+	})
+
+	t.Run("FullPeer", func(t *testing.T) {
+		a, b := newSess(), newSess()
+		ia, err := a.EnableICE(nil)
+		require.NoError(t, err)
+		ia.Controlling = true
+		_, err = b.EnableICE(nil)
+		require.NoError(t, err)
+
+		require.NoError(t, b.RemoteSDP(a.LocalSDP()))
+		require.NoError(t, a.RemoteSDP(b.LocalSDP()))
+
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		errs := make(chan error)
+		go func() { errs <- b.ICEConnect(ctx) }()
+		require.NoError(t, a.ICEConnect(ctx))
+		require.NoError(t, <-errs)
+
+		require.Equal(t, b.Laddr.String(), a.Raddr.String())
+		require.Equal(t, a.Laddr.String(), b.Raddr.String())
+	})
+
+	t.Run("Timeout", func(t *testing.T) {
+		a := newSess()
+		_, err := a.EnableICE(nil)
+		require.NoError(t, err)
+		remote := "v=0\r\nc=IN IP4 127.0.0.1\r\nm=audio 9 RTP/AVP 0\r\na=ice-ufrag:peer\r\na=ice-pwd:peerpasswordpeerpassword\r\na=candidate:1 1 udp 2130706431 127.0.0.1 9 typ host\r\n"
+		require.NoError(t, a.RemoteSDP([]byte(remote)))
+
+		ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+		defer cancel()
+		require.ErrorIs(t, a.ICEConnect(ctx), ErrICENoValidPair)
+	})
+}
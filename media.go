@@ -4,8 +4,10 @@ import (
 	"encoding/binary"
 	"fmt"
 	"io"
+	"math/rand"
 	"net"
 	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -17,16 +19,6 @@ import (
 	"github.com/rs/zerolog/log"
 )
 
-var (
-	// RTPPortStart and RTPPortEnd allows defining rtp port range for media
-	RTPPortStart  = 0
-	RTPPortEnd    = 0
-	rtpPortOffset = atomic.Int32{}
-
-	RTPDebug  = false
-	RTCPDebug = false
-)
-
 // MediaSession represents active media session with RTP/RTCP
 type MediaSession struct {
 	// Raddr is our target remote address. Normally it is resolved by SDP parsing.
@@ -34,6 +26,9 @@ type MediaSession struct {
 	Raddr *net.UDPAddr
 	// Laddr our local address which has full IP and port after media session creation
 	Laddr *net.UDPAddr
+	// AdvertisedAddr is address put in local SDP instead of Laddr, ex. public NAT address.
+	// Zero port means Laddr port is advertised
+	AdvertisedAddr *net.UDPAddr
 
 	rtpConn   net.PacketConn
 	rtcpConn  net.PacketConn
@@ -43,11 +38,125 @@ type MediaSession struct {
 	// Depending of negotiation this can change.
 	Formats sdp.Formats
 	Mode    sdp.Mode
+	// remoteMode is media direction of last remote SDP
+	remoteMode sdp.Mode
+	// Registry defines dynamic payload types used in Formats. Static types are always known
+	Registry *sdp.CodecRegistry
+	// Codecs are remote rtpmap/fmtp descriptions of negotiated Formats.
+	// They are set when remote SDP is applied
+	Codecs []sdp.Codec
+	// FormatParams are fmtp of static formats advertised in local SDP, ex. {"18": "annexb=no"}.
+	// Remote fmtp of negotiated format is used instead if present
+	FormatParams map[string]string
+	// DTMFPayloadType is telephone-event payload type advertised in local SDP and used for DTMF.
+	// It is updated to remote payload type when remote SDP is applied. Default 101
+	DTMFPayloadType uint8
+	// TelephoneEvents are RFC 4733 events advertised in local SDP, ex. to enable extended events.
+	// Zero advertises sdp.DefaultTelephoneEvents
+	TelephoneEvents sdp.TelephoneEvents
+	// remoteEvents are telephone events remote can receive. Zero if remote SDP has no telephone-event
+	remoteEvents sdp.TelephoneEvents
+	// recvFormats maps negotiated (send) format to format remote sends with, which is number
+	// from our offer. Numbers differ when remote answered with own dynamic payload types. Nil is same
+	recvFormats map[string]string
+	// recvDTMF is telephone-event payload type advertised in local SDP. Zero is same as DTMFPayloadType
+	recvDTMF uint8
+	// DTMFReceivePayloadType is payload type of received DTMF for peers which do not send
+	// with negotiated one. Zero uses negotiated payload type. See PeerQuirks
+	DTMFReceivePayloadType uint8
+	// RecvGain and SendGain adjust level of decoded audio received from and sent to remote.
+	// They are applied by AudioReader, AudioWriter and Bridge
+	RecvGain *GainControl
+	SendGain *GainControl
+	// EchoCanceller removes echo of received audio from sent audio. AudioReader provides
+	// far end frames and AudioWriter near end frames, so both must be used with session
+	EchoCanceller EchoCanceller
+	echoFar       echoReference
+	// AudioLevel advertises RFC 6464 audio level header extension. Level is sent only when remote
+	// supports it. See RTPWriter SetAudioLevel and RTPReader AudioLevel
+	AudioLevel bool
+	// audioLevelID is extension id of audio level sent to remote. Zero if not negotiated.
+	// Ids are atomic as they are read per packet while session is renegotiated
+	audioLevelID atomic.Uint32
+	// recvAudioLevelID is extension id of audio level remote sends with. Zero if not negotiated
+	recvAudioLevelID atomic.Uint32
+	// remoteAudioLevel is audio level extension of last remote SDP, which answer keeps.
+	// Nil if remote did not map it
+	remoteAudioLevel *sdp.ExtMap
+	// remoteApplied is set once remote SDP is applied. Local SDP is answer to it from then
+	remoteApplied bool
+	// NACK advertises RTCP Generic NACK feedback (a=rtcp-fb:* nack). RTPReader sends NACK only
+	// when remote supports it. See RTPReader NACKEnabled
+	NACK bool
+	// remoteNACK is set when last remote SDP has NACK feedback, which answer keeps
+	remoteNACK bool
+	// nackNegotiated is set when both sides support NACK. It is read per packet
+	nackNegotiated atomic.Bool
+	// sendSSRC is SSRC of sent RTP, used as sender of RTCP feedback. See senderSSRC
+	sendSSRC atomic.Uint32
+	// Ptime and MaxPtime are packet times advertised in local SDP. Zero is not advertised
+	Ptime    time.Duration
+	MaxPtime time.Duration
+	// remotePtime and remoteMaxPtime are packet times requested by remote SDP
+	remotePtime    time.Duration
+	remoteMaxPtime time.Duration
+	// Bandwidth limits are advertised in local SDP, ex. b=AS:64
+	Bandwidth []sdp.Bandwidth
+	// RemoteBandwidth are bandwidth limits of remote SDP. They are set when remote SDP is applied
+	RemoteBandwidth []sdp.Bandwidth
+
+	bufPool *BufferPool
+
+	// alloc created connections and gets them back on Close
+	alloc ListenerAllocator
+
+	// tap mirrors RTP when call is tapped
+	tap *callTap
+
+	// forks duplicate RTP. See Fork
+	forks mediaForks
+
+	// interceptors process raw RTP and RTCP. See RegisterInterceptor
+	interceptors mediaInterceptors
+
+	// demux reads RTP when started. See StartDemux
+	demux atomic.Pointer[MediaDemux]
+
+	// ice is set when ICE is enabled
+	ice *ICEAgent
+
+	unreachable *unreachableDetector
+
+	// shaper limits outgoing RTP. See SetShaper
+	shaper *MediaShaper
+
+	stats mediaStats
+
+	// debug controls packet logging. See SetDebug
+	debug mediaDebug
+
+	// mu guards remote address, formats and mode when they change during call.
+	// See Renegotiate
+	mu          sync.RWMutex
+	offerAnswer sdp.OfferAnswer
+	// origin keeps o= line of local SDP versioned across renegotiations
+	origin sdp.OriginVersion
+
+	// tcpSetup is set when media is over TCP. See NewMediaSessionTCP
+	tcpSetup string
+
+	// engine tracks session while it is active. See MediaEngine
+	engine    *MediaEngine
+	closeOnce sync.Once
+	doneOnce  sync.Once
+	done      chan struct{}
 
 	log zerolog.Logger
 }
 
-func NewMediaSession(laddr *net.UDPAddr) (s *MediaSession, e error) {
+// NewMediaSession creates RTP and RTCP listeners on laddr.
+// Sockets are created by alloc. If alloc is nil DefaultListenerAllocator is used
+func NewMediaSession(laddr *net.UDPAddr, alloc ListenerAllocator) (s *MediaSession, e error) {
 	s = &MediaSession{
 		Formats: sdp.Formats{
 			sdp.FORMAT_TYPE_ULAW, sdp.FORMAT_TYPE_ALAW,
@@ -58,10 +167,11 @@ func NewMediaSession(laddr *net.UDPAddr) (s *MediaSession, e error) {
 	}
 
 	// Try to listen on this ports
-	if err := s.createListeners(s.Laddr); err != nil {
+	if err := s.createListeners(s.Laddr, alloc); err != nil {
 		return nil, err
 	}
 
+	MediaEngineDefault.Add(s)
 	return s, nil
 }
 
@@ -69,6 +179,19 @@ func (s *MediaSession) SetLogger(log zerolog.Logger) {
 	s.log = log
 }
 
+// SetBufferPool sets pool used for reading packets. Pool size is max packet size.
+// It is not thread safe and should be called before reading
+func (s *MediaSession) SetBufferPool(p *BufferPool) {
+	s.bufPool = p
+}
+
+func (s *MediaSession) bufferPool() *BufferPool {
+	if s.bufPool == nil {
+		return DefaultBufferPool
+	}
+	return s.bufPool
+}
+
 // SetRemoteAddr is helper to set Raddr and rtcp address.
 // It is not thread safe
 func (s *MediaSession) SetRemoteAddr(raddr *net.UDPAddr) {
@@ -78,11 +201,104 @@ func (s *MediaSession) SetRemoteAddr(raddr *net.UDPAddr) {
 	s.rtcpRaddr.Port++
 }
 
+// DiscoverAdvertisedAddr sets AdvertisedAddr to public address of RTP socket discovered with STUN.
+// It must be called before reading from session
+func (s *MediaSession) DiscoverAdvertisedAddr(c *STUNClient) error {
+	addr, err := c.Discover(s.rtpConn)
+	if err != nil {
+		return err
+	}
+	s.AdvertisedAddr = addr
+	return nil
+}
+
+// EnableICELite enables ICE-lite. Local SDP will contain ICE credentials and host candidates
+// and connectivity checks are answered while reading RTP and RTCP.
+// It must be called before LocalSDP and reading
+func (s *MediaSession) EnableICELite() *ICEAgent {
+	if s.ice == nil {
+		s.ice = newICEAgent(true)
+	}
+	return s.ice
+}
+
+// ICE returns ICE agent or nil if not enabled
+func (s *MediaSession) ICE() *ICEAgent {
+	return s.ice
+}
+
 func (s *MediaSession) LocalSDP() []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ip, rtpPort := s.advertisedAddr()
+
+	// Remote sends with payload types we advertise now
+	s.recvFormats = nil
+	s.recvDTMF = s.dtmfPayloadType()
+
+	dtmf := strconv.Itoa(int(s.dtmfPayloadType()))
+	body := sdp.GenerateForAudioDTMF(ip, ip, rtpPort, s.Mode, s.Formats, dtmf)
+	if !s.TelephoneEvents.IsZero() {
+		body = sdp.SetTelephoneEvents(body, dtmf, s.TelephoneEvents)
+	}
+	body = sdp.AppendCodecs(body, s.describedCodecs())
+	body = sdp.AppendPtime(body, s.Ptime, s.MaxPtime)
+	body = sdp.AppendBandwidth(body, "audio", s.Bandwidth...)
+	if a, ok := s.localRTCP(); ok {
+		body = sdp.AppendRTCP(body, a)
+	}
+	body = s.appendAudioLevel(body)
+	if s.NACK && (!s.remoteApplied || s.remoteNACK) {
+		body = sdp.AppendRTCPFeedback(body, "*", sdp.RTCPFeedbackNACK)
+	}
+	if s.tcpSetup != "" {
+		body = tcpSDP(body, s.tcpSetup)
+	}
+	if s.ice != nil {
+		body = s.ice.sdp(body, ip, rtpPort)
+	}
+	return s.origin.Apply(body)
+}
+
+// advertisedAddr returns address put in local SDP
+func (s *MediaSession) advertisedAddr() (net.IP, int) {
 	ip := s.Laddr.IP
 	rtpPort := s.Laddr.Port
+	if s.AdvertisedAddr != nil {
+		if s.AdvertisedAddr.IP != nil {
+			ip = s.AdvertisedAddr.IP
+		}
+		if s.AdvertisedAddr.Port != 0 {
+			rtpPort = s.AdvertisedAddr.Port
+		}
+	}
+	return ip, rtpPort
+}
 
-	return sdp.GenerateForAudio(ip, ip, rtpPort, s.Mode, s.Formats)
+// localRTCP returns rtcp attribute if RTCP port is not RTP port + 1.
+// With advertised port RTCP mapping is not known, so attribute is not returned
+func (s *MediaSession) localRTCP() (sdp.RTCPAttribute, bool) {
+	if s.rtcpConn == nil || s.tcpSetup != "" || (s.AdvertisedAddr != nil && s.AdvertisedAddr.Port != 0) {
+		return sdp.RTCPAttribute{}, false
+	}
+	addr, ok := s.rtcpConn.LocalAddr().(*net.UDPAddr)
+	if !ok || addr.Port == s.Laddr.Port+1 {
+		return sdp.RTCPAttribute{}, false
+	}
+	return sdp.RTCPAttribute{Port: addr.Port}, true
+}
+
+// setRemoteRTCP sets RTCP address from remote rtcp attribute. Without it RTP port + 1 is used
+func (s *MediaSession) setRemoteRTCP(sd sdp.SessionDescription) {
+	a, ok := sd.RTCP("audio")
+	if !ok {
+		return
+	}
+	ip := a.IP
+	if ip == nil {
+		ip = s.Raddr.IP
+	}
+	s.rtcpRaddr = &net.UDPAddr{IP: ip, Port: a.Port}
 }
 
 func (s *MediaSession) RemoteSDP(sdpReceived []byte) error {
@@ -96,6 +312,9 @@ func (s *MediaSession) RemoteSDP(sdpReceived []byte) error {
 	if err != nil {
 		return err
 	}
+	if md.Rejected() {
+		return fmt.Errorf("audio stream: %w", sdp.ErrMediaRejected)
+	}
 
 	ci, err := sd.ConnectionInformation()
 	if err != nil {
@@ -104,155 +323,407 @@ func (s *MediaSession) RemoteSDP(sdpReceived []byte) error {
 
 	raddr := &net.UDPAddr{IP: ci.IP, Port: md.Port}
 	s.SetRemoteAddr(raddr)
-
-	s.updateFormats(md.Formats)
+	s.setRemoteRTCP(sd)
+	if s.tcpSetup != "" {
+		if err := s.connectTCP(sd, md, raddr); err != nil {
+			return err
+		}
+	}
+	s.updateICE(sd)
+
+	s.mu.Lock()
+	s.updateFormats(md.Formats, sd)
+	s.updateCodecs(sd)
+	s.updateLimits(sd, md)
+	s.updateAudioLevel(sd)
+	s.updateNACK(sd)
+	s.mu.Unlock()
+	s.setRemoteMode(sd)
 	return nil
 }
 
-func (s *MediaSession) updateFormats(formats sdp.Formats) {
-	// Check remote vs local
-	if len(s.Formats) > 0 {
-		filter := make([]string, 0, cap(formats))
-		// Always prefer remote side?
-		for _, cr := range formats {
-			for _, cs := range s.Formats {
-				if cr == cs {
-					filter = append(filter, cr)
-				}
-			}
+// updateAudioLevel reads remote audio level extension. Direction is of remote, so sendonly
+// extension is only received and recvonly only sent
+func (s *MediaSession) updateAudioLevel(sd sdp.SessionDescription) {
+	s.audioLevelID.Store(0)
+	s.recvAudioLevelID.Store(0)
+	s.remoteAudioLevel = nil
+	s.remoteApplied = true
+	if !s.AudioLevel {
+		return
+	}
+	e, ok := sd.ExtMap("audio", sdp.ExtMapAudioLevel)
+	if !ok {
+		return
+	}
+	s.remoteAudioLevel = &e
+	switch e.Direction {
+	case "", "sendrecv":
+		s.audioLevelID.Store(uint32(e.ID))
+		s.recvAudioLevelID.Store(uint32(e.ID))
+	case "recvonly":
+		s.audioLevelID.Store(uint32(e.ID))
+	case "sendonly":
+		s.recvAudioLevelID.Store(uint32(e.ID))
+	}
+}
+
+// updateNACK reads remote NACK feedback of negotiated format. Caller must hold lock
+func (s *MediaSession) updateNACK(sd sdp.SessionDescription) {
+	s.remoteNACK = len(s.Formats) > 0 && sd.RTCPFeedback("audio", s.Formats[0], sdp.RTCPFeedbackNACK)
+	s.nackNegotiated.Store(s.NACK && s.remoteNACK)
+}
+
+// ipHeaderSize returns IP header size of sent packets, which is 40 for IPv6 and 20 for IPv4.
+// Remote address decides it, or local one when remote is not known
+func (s *MediaSession) ipHeaderSize() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	addr := s.Raddr
+	if addr == nil || addr.IP == nil {
+		addr = s.Laddr
+	}
+	if addr != nil && addr.IP != nil && addr.IP.To4() == nil {
+		return 40
+	}
+	return 20
+}
+
+// senderSSRC returns SSRC of sent RTP. Random one is chosen if nothing is sent yet,
+// as RTCP feedback must have sender
+func (s *MediaSession) senderSSRC() uint32 {
+	for {
+		if ssrc := s.sendSSRC.Load(); ssrc != 0 {
+			return ssrc
 		}
+		s.sendSSRC.CompareAndSwap(0, rand.Uint32())
+	}
+}
 
-		// for _, cs := range s.Formats {
-		// 	for _, cr := range formats {
-		// 		if cr == cs {
-		// 			filter = append(filter, cr)
-		// 		}
-		// 	}
-		// }
-		// Update new list of formats
-		s.Formats = sdp.Formats(filter)
-	} else {
-		s.Formats = formats
+// appendAudioLevel adds audio level extension to local SDP. Answer keeps id of offer with
+// reversed direction and has no extension if offer did not map it
+func (s *MediaSession) appendAudioLevel(body []byte) []byte {
+	if !s.AudioLevel {
+		return body
+	}
+	e := sdp.ExtMap{ID: audioLevelExtensionID, URI: sdp.ExtMapAudioLevel, Attributes: "vad=on"}
+	if !s.remoteApplied {
+		// Remote can send with offered id before answer
+		s.recvAudioLevelID.Store(uint32(e.ID))
+		return sdp.AppendExtMap(body, e)
+	}
+
+	r := s.remoteAudioLevel
+	if r == nil {
+		return body
+	}
+	e.ID = r.ID
+	switch r.Direction {
+	case "sendonly":
+		e.Direction = "recvonly"
+	case "recvonly":
+		e.Direction = "sendonly"
+	case "inactive":
+		e.Direction = "inactive"
 	}
+	return sdp.AppendExtMap(body, e)
 }
 
-// Listen creates listeners instead
-func (s *MediaSession) createListeners(laddr *net.UDPAddr) error {
-	// var err error
+// updateICE reads remote ICE attributes. Until check is nominated media is sent
+// to best remote candidate if connection address is not usable
+func (s *MediaSession) updateICE(sd sdp.SessionDescription) {
+	if s.ice == nil {
+		return
+	}
+	s.ice.updateRemote(sd.ICE("audio"))
 
-	if laddr.Port != 0 {
-		return s.listenRTPandRTCP(laddr)
+	if s.Raddr.IP != nil && !s.Raddr.IP.IsUnspecified() {
+		return
+	}
+	if c, ok := s.ice.bestRemoteCandidate(1); ok {
+		s.SetRemoteAddr(&net.UDPAddr{IP: c.IP, Port: c.Port})
+	}
+	if c, ok := s.ice.bestRemoteCandidate(2); ok {
+		s.rtcpRaddr = &net.UDPAddr{IP: c.IP, Port: c.Port}
 	}
+}
 
-	if laddr.Port == 0 && RTPPortStart > 0 && RTPPortEnd > RTPPortStart {
-		// Get next available port
-		port := RTPPortStart + int(rtpPortOffset.Load())
-		var err error
-		for ; port < RTPPortEnd; port += 2 {
-			laddr.Port = port
-			err = s.listenRTPandRTCP(laddr)
-			if err == nil {
-				break
-			}
-		}
-		if err != nil {
-			return fmt.Errorf("No available ports in range %d:%d: %w", RTPPortStart, RTPPortEnd, err)
-		}
-		// Add some offset so that we use more from range
-		offset := (port + 2 - RTPPortStart) % (RTPPortEnd - RTPPortStart)
-		rtpPortOffset.Store(int32(offset)) // Reset to zero with module
-		return nil
-	}
-
-	// Because we want to go +2 with ports in racy situations this will always fail
-	// So we need to add some control and retry if needed
-	// We are always in race with other services so only try to offset to reduce retries
-	var err error
-	for retries := 0; retries < 10; retries += 1 {
-		err = s.listenRTPandRTCP(laddr)
-		if err == nil {
+// updateFormats applies remote formats. Remote keeps sending with our payload types,
+// so local ones are remembered for receiving
+func (s *MediaSession) updateFormats(formats sdp.Formats, sd sdp.SessionDescription) {
+	var local map[string]string
+	s.Formats, local = s.matchFormatPairs(formats, sd)
+	s.recvFormats = nil
+	for cr, cs := range local {
+		if cr != cs {
+			s.recvFormats = local
 			break
 		}
 	}
+}
 
-	return err
+// matchFormats returns remote formats supported locally in remote order
+func (s *MediaSession) matchFormats(formats sdp.Formats, sd sdp.SessionDescription) sdp.Formats {
+	filter, _ := s.matchFormatPairs(formats, sd)
+	return filter
 }
 
-func (s *MediaSession) listenRTPandRTCP(laddr *net.UDPAddr) error {
-	var err error
-	s.rtpConn, err = net.ListenUDP("udp", &net.UDPAddr{IP: laddr.IP, Port: laddr.Port})
-	if err != nil {
-		return err
+// matchFormatPairs is matchFormats which also returns local format of each remote format
+func (s *MediaSession) matchFormatPairs(formats sdp.Formats, sd sdp.SessionDescription) (sdp.Formats, map[string]string) {
+	// Check remote vs local
+	if len(s.Formats) == 0 {
+		return formats, nil
 	}
-	laddr = s.rtpConn.LocalAddr().(*net.UDPAddr)
 
-	s.rtcpConn, err = net.ListenUDP("udp", &net.UDPAddr{IP: laddr.IP, Port: laddr.Port + 1})
-	if err != nil {
-		s.rtpConn.Close()
-		return err
+	remote := remoteRTPMaps(sd)
+	filter := make([]string, 0, cap(formats))
+	local := make(map[string]string, len(formats))
+	// Always prefer remote side?
+	for _, cr := range formats {
+		for _, cs := range s.Formats {
+			if s.formatMatch(cs, cr, remote) {
+				filter = append(filter, cr)
+				local[cr] = cs
+				break
+			}
+		}
 	}
+	return sdp.Formats(filter), local
+}
 
-	// Update laddr as it can be empheral
-	s.Laddr = laddr
-	return nil
+// ReceivePayloadType returns payload type remote sends format with. Negotiated Formats are
+// payload types of remote SDP used for sending, but remote sends with payload types of our SDP
+func (s *MediaSession) ReceivePayloadType(format string) uint8 {
+	if f, exists := s.recvFormats[format]; exists {
+		return sdp.FormatNumeric(f)
+	}
+	return sdp.FormatNumeric(format)
 }
 
-func (s *MediaSession) createListeners2(laddr *net.UDPAddr) error {
-	var err error
+// formatMatch compares local and remote format by codec when both are known.
+// Dynamic payload types can have different numbers on each side
+func (s *MediaSession) formatMatch(local string, remote string, remoteCodecs map[string]sdp.Codec) bool {
+	lc, lok := s.Registry.Lookup(local)
+	rc, rok := remoteCodecs[remote]
+	if !rok {
+		rc, rok = sdp.StaticCodec(remote)
+	}
+	if !lok || !rok {
+		return local == remote
+	}
+	return lc.Equal(rc)
+}
 
-	if laddr.Port == 0 && RTPPortStart > 0 && RTPPortEnd > RTPPortStart {
-		// Get next available port
-		port := RTPPortStart + int(rtpPortOffset.Load())
-		for ; port < RTPPortEnd; port += 2 {
-			rtpconn, err := net.ListenUDP("udp", &net.UDPAddr{IP: laddr.IP, Port: port})
-			if err != nil {
-				continue
-			}
-			rtpconn.Close()
+// remoteRTPMaps parses rtpmap attributes by format. Invalid ones are skipped
+func remoteRTPMaps(sd sdp.SessionDescription) map[string]sdp.Codec {
+	codecs := map[string]sdp.Codec{}
+	for _, a := range sd.Values("a") {
+		v, found := strings.CutPrefix(a, "rtpmap:")
+		if !found {
+			continue
+		}
+		c, err := sdp.ParseRTPMap(v)
+		if err != nil {
+			continue
+		}
+		codecs[strconv.Itoa(int(c.PayloadType))] = c
+	}
+	return codecs
+}
 
-			rtpcconn, err := net.ListenUDP("udp", &net.UDPAddr{IP: laddr.IP, Port: port + 1})
-			if err != nil {
-				continue
+// codec returns codec of format. Remote negotiated codecs are preferred over registry
+func (s *MediaSession) codec(format string) (sdp.Codec, bool) {
+	for _, c := range s.Codecs {
+		if strconv.Itoa(int(c.PayloadType)) == format {
+			if c.Fmtp == "" {
+				c.Fmtp = s.FormatParams[format]
+				c.Params = sdp.ParseFmtpParams(c.Fmtp)
 			}
-			rtpcconn.Close()
-			laddr.Port = port
-			break
+			return c, true
 		}
-		if laddr.Port == 0 {
-			return fmt.Errorf("No available ports in range %d:%d", RTPPortStart, RTPPortEnd)
+	}
+	c, ok := s.Registry.Lookup(format)
+	if fmtp, exists := s.FormatParams[format]; ok && exists && c.Fmtp == "" {
+		c.Fmtp = fmtp
+		c.Params = sdp.ParseFmtpParams(fmtp)
+	}
+	return c, ok
+}
+
+// updateCodecs sets codecs of negotiated formats and telephone-event payload type from remote SDP
+func (s *MediaSession) updateCodecs(sd sdp.SessionDescription) {
+	if pt, ok := sd.TelephoneEvent(); ok {
+		if n, err := strconv.ParseUint(pt, 10, 8); err == nil {
+			s.DTMFPayloadType = uint8(n)
 		}
-		// Add some offset so that we use more from range
-		offset := (port + 2 - RTPPortStart) % (RTPPortEnd - RTPPortStart)
-		rtpPortOffset.Store(int32(offset)) // Reset to zero with module
 	}
+	s.remoteEvents, _ = sd.TelephoneEvents()
 
-	// Without RTP port ranges this can fail on RTCP to be reused in racy conditions
-	s.rtpConn, err = net.ListenUDP("udp", &net.UDPAddr{IP: laddr.IP, Port: laddr.Port})
+	codecs, err := sd.Codecs("audio")
 	if err != nil {
-		return err
+		s.log.Debug().Err(err).Msg("Fail to parse remote codecs")
+		s.Codecs = nil
+		return
+	}
+
+	s.Codecs = make([]sdp.Codec, 0, len(s.Formats))
+	for _, f := range s.Formats {
+		for _, c := range codecs {
+			if strconv.Itoa(int(c.PayloadType)) == f {
+				s.Codecs = append(s.Codecs, c)
+				break
+			}
+		}
+	}
+}
+
+// describedCodecs returns codecs of dynamic formats which need rtpmap in SDP
+// and static formats with fmtp
+func (s *MediaSession) describedCodecs() []sdp.Codec {
+	codecs := []sdp.Codec{}
+	for _, f := range s.Formats {
+		c, ok := s.codec(f)
+		if !ok {
+			continue
+		}
+		if _, static := sdp.StaticCodec(f); static && c.Fmtp == "" {
+			continue
+		}
+		codecs = append(codecs, c)
+	}
+	return codecs
+}
+
+// updateLimits reads packet time and bandwidth requested by remote
+func (s *MediaSession) updateLimits(sd sdp.SessionDescription, md sdp.MediaDescription) {
+	s.remotePtime = md.Ptime
+	s.remoteMaxPtime = md.MaxPtime
+	s.RemoteBandwidth = sd.Bandwidths("audio")
+}
+
+// PacketTime returns packetization interval for sending. Remote ptime is preferred,
+// then local Ptime, limited by remote maxptime. Default is 20ms
+func (s *MediaSession) PacketTime() time.Duration {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	ptime := 20 * time.Millisecond
+	switch {
+	case s.remotePtime > 0:
+		ptime = s.remotePtime
+	case s.Ptime > 0:
+		ptime = s.Ptime
+	}
+	if s.remoteMaxPtime > 0 && ptime > s.remoteMaxPtime {
+		ptime = s.remoteMaxPtime
+	}
+	return ptime
+}
+
+// dtmfPayloadType returns telephone-event payload type for sending. Caller must hold lock
+// when negotiation can run, see sendDTMF
+func (s *MediaSession) dtmfPayloadType() uint8 {
+	if s.DTMFPayloadType == 0 {
+		return 101
+	}
+	return s.DTMFPayloadType
+}
+
+// sendDTMF returns telephone-event payload type and events remote receives.
+// It is safe to call while session is renegotiated
+func (s *MediaSession) sendDTMF() (uint8, sdp.TelephoneEvents) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.dtmfPayloadType(), s.remoteEvents
+}
+
+// negotiated returns first negotiated format with its codec and payload type remote sends it with.
+// It is safe to call while session is renegotiated
+func (s *MediaSession) negotiated() (string, sdp.Codec, uint8, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	f := s.Formats[0]
+	c, ok := s.codec(f)
+	return f, c, s.ReceivePayloadType(f), ok
+}
+
+// recvDTMFPayloadType returns telephone-event payload type remote sends with.
+// It is safe to call while session is renegotiated
+func (s *MediaSession) recvDTMFPayloadType() uint8 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.DTMFReceivePayloadType != 0 {
+		return s.DTMFReceivePayloadType
+	}
+	if s.recvDTMF == 0 {
+		return s.dtmfPayloadType()
+	}
+	return s.recvDTMF
+}
+
+// Listen creates listeners instead
+func (s *MediaSession) createListeners(laddr *net.UDPAddr, alloc ListenerAllocator) error {
+	if alloc == nil {
+		alloc = DefaultListenerAllocator
 	}
-	laddr = s.rtpConn.LocalAddr().(*net.UDPAddr)
 
-	s.rtcpConn, err = net.ListenUDP("udp", &net.UDPAddr{IP: laddr.IP, Port: laddr.Port + 1})
+	rtpConn, rtcpConn, err := alloc.Allocate(laddr)
 	if err != nil {
 		return err
 	}
 
 	// Update laddr as it can be empheral
-	s.Laddr = laddr
+	addr, ok := rtpConn.LocalAddr().(*net.UDPAddr)
+	if !ok {
+		rtpConn.Close()
+		rtcpConn.Close()
+		alloc.Release(rtpConn, rtcpConn)
+		return fmt.Errorf("allocated RTP connection is not UDP: %s", rtpConn.LocalAddr())
+	}
+
+	s.rtpConn, s.rtcpConn = rtpConn, rtcpConn
+	s.Laddr = addr
+	s.alloc = alloc
 	return nil
 }
 
+// Close closes connections and unblocks pending reads. It is safe to call multiple times
 func (s *MediaSession) Close() {
-	if s.rtcpConn != nil {
-		s.rtcpConn.Close()
-	}
+	s.closeOnce.Do(func() {
+		if s.ice != nil {
+			// Released before conns are closed, as release is sent on them
+			s.ice.closeRelays()
+		}
+		if s.rtcpConn != nil {
+			s.rtcpConn.Close()
+		}
 
-	if s.rtpConn != nil {
-		s.rtpConn.Close()
-	}
+		if s.rtpConn != nil {
+			s.rtpConn.Close()
+		}
+
+		if s.alloc != nil {
+			// Allocator releases its own conns, not relay ones
+			s.alloc.Release(unwrapRelay(s.rtpConn), unwrapRelay(s.rtcpConn))
+			s.alloc = nil
+		}
+		if s.engine != nil {
+			s.engine.remove(s)
+		}
+		close(s.closed())
+		s.forks.closeAll()
+	})
 }
 
+// closed returns channel which is closed when session is closed
+func (s *MediaSession) closed() chan struct{} {
+	s.doneOnce.Do(func() {
+		s.done = make(chan struct{})
+	})
+	return s.done
+}
+
+// UpdateDestinationSDP applies remote SDP without creating answer. Use Renegotiate for re-INVITE offer.
+// Remote address, formats and mode are updated under lock
 func (s *MediaSession) UpdateDestinationSDP(sdpReceived []byte) error {
 	sd := sdp.SessionDescription{}
 	if err := sdp.Unmarshal(sdpReceived, &sd); err != nil {
@@ -264,29 +735,119 @@ func (s *MediaSession) UpdateDestinationSDP(sdpReceived []byte) error {
 	if err != nil {
 		return err
 	}
+	if md.Rejected() {
+		return fmt.Errorf("audio stream: %w", sdp.ErrMediaRejected)
+	}
 
 	ci, err := sd.ConnectionInformation()
 	if err != nil {
 		return err
 	}
 
-	// TODO fix race problem, but it is rare this to happen
-	s.Raddr.IP = ci.IP
-	s.Raddr.Port = md.Port
-
-	s.updateFormats(md.Formats)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// Media path keeps reading old address, so new one is swapped in
+	s.SetRemoteAddr(&net.UDPAddr{IP: ci.IP, Port: md.Port})
+	s.setRemoteRTCP(sd)
+	s.updateICE(sd)
+	s.updateFormats(md.Formats, sd)
+	s.updateCodecs(sd)
+	s.updateLimits(sd, md)
+	s.updateAudioLevel(sd)
+	s.updateNACK(sd)
+	s.remoteMode = sd.Mode()
 	return nil
 }
 
-var rtpBufPool = &sync.Pool{
-	New: func() any { return make([]byte, 1600) },
+// Renegotiate applies offer received in re-INVITE and returns answer SDP.
+// Formats, direction and remote address are updated under lock, so it is safe to call
+// while session is read and written. Answer direction mirrors offer (hold/resume)
+func (s *MediaSession) Renegotiate(remoteSDP []byte) ([]byte, error) {
+	sd := sdp.SessionDescription{}
+	if err := sdp.Unmarshal(remoteSDP, &sd); err != nil {
+		return nil, fmt.Errorf("fail to parse received SDP: %w", err)
+	}
+
+	md, err := sd.MediaDescription("audio")
+	if err != nil {
+		return nil, err
+	}
+	if md.Rejected() {
+		return nil, fmt.Errorf("audio stream in offer: %w", sdp.ErrMediaRejected)
+	}
+
+	ci, err := sd.ConnectionInformation()
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.offerAnswer.SetRemoteDescription(sdp.TypeOffer, remoteSDP); err != nil {
+		return nil, err
+	}
+
+	ip, rtpPort := s.advertisedAddr()
+	answer, err := s.offerAnswer.CreateAnswer(sdp.AudioOptions{
+		OriginIP:     ip,
+		ConnectionIP: ip,
+		Port:         rtpPort,
+		// Remote numbering is used for dynamic payload types
+		Formats:         s.matchFormats(md.Formats, sd),
+		DTMFPayloadType: strconv.Itoa(int(s.dtmfPayloadType())),
+		TelephoneEvents: s.TelephoneEvents,
+		Ptime:           s.Ptime,
+		MaxPtime:        s.MaxPtime,
+	})
+	if err != nil {
+		s.offerAnswer.Rollback()
+		return nil, err
+	}
+
+	s.updateFormats(md.Formats, sd)
+	s.updateCodecs(sd)
+	s.updateLimits(sd, md)
+	s.updateAudioLevel(sd)
+	s.updateNACK(sd)
+	// Answer mirrors offer payload types, so they are same in both directions
+	s.recvFormats = nil
+	s.recvDTMF = 0
+	answer = sdp.AppendCodecs(answer, s.describedCodecs())
+	answer = sdp.AppendBandwidth(answer, "audio", s.Bandwidth...)
+	if a, ok := s.localRTCP(); ok {
+		answer = sdp.AppendRTCP(answer, a)
+	}
+	answer = s.appendAudioLevel(answer)
+	if s.NACK && s.remoteNACK {
+		answer = sdp.AppendRTCPFeedback(answer, "*", sdp.RTCPFeedbackNACK)
+	}
+	s.Mode = sdp.AnswerMode(sd.Mode(), sdp.ModeSendrecv)
+	s.remoteMode = sd.Mode()
+	if ci.IP.IsUnspecified() {
+		// Old style hold. Remote address is kept for resume
+		s.Mode = sdp.ModeInactive
+		s.remoteMode = sdp.ModeInactive
+	} else {
+		s.SetRemoteAddr(&net.UDPAddr{IP: ci.IP, Port: md.Port})
+		s.setRemoteRTCP(sd)
+	}
+	if s.tcpSetup != "" {
+		answer = tcpSDP(answer, s.tcpSetup)
+	}
+	if s.ice != nil {
+		s.updateICE(sd)
+		answer = s.ice.sdp(answer, ip, rtpPort)
+	}
+	return s.origin.Apply(answer), nil
 }
 
 // readRTPNoAlloc will replace ReadRTP
 // NOTE: this function will be replaced with passing packet as buf. This helps caller to reduce memory and GC
 func (m *MediaSession) readRTPNoAlloc(pkt *rtp.Packet) error {
-	buf := rtpBufPool.Get().([]byte)
-	defer rtpBufPool.Put(buf)
+	pool := m.bufferPool()
+	buf := pool.Get()
+	defer pool.Put(buf)
 
 	n, err := m.ReadRTPRaw(buf)
 	if err != nil {
@@ -302,7 +863,7 @@ func (m *MediaSession) readRTPNoAlloc(pkt *rtp.Packet) error {
 	// 	return err
 	// }
 
-	if RTPDebug {
+	if m.debug.rtp() {
 		m.log.Debug().Msgf("Recv RTP\n%s", pkt.String())
 	}
 	return err
@@ -313,7 +874,7 @@ func (m *MediaSession) readRTPNoAlloc(pkt *rtp.Packet) error {
 func (m *MediaSession) ReadRTP() (rtp.Packet, error) {
 	p := rtp.Packet{}
 
-	buf := make([]byte, 1600)
+	buf := make([]byte, m.bufferPool().Size())
 
 	n, err := m.ReadRTPRaw(buf)
 	if err != nil {
@@ -324,7 +885,7 @@ func (m *MediaSession) ReadRTP() (rtp.Packet, error) {
 		return p, err
 	}
 
-	if RTPDebug {
+	if m.debug.rtp() {
 		m.log.Debug().Msgf("Recv RTP\n%s", p.String())
 	}
 	return p, err
@@ -338,8 +899,58 @@ func (m *MediaSession) ReadRTPDeadline(t time.Time) (rtp.Packet, error) {
 }
 
 func (m *MediaSession) ReadRTPRaw(buf []byte) (int, error) {
-	n, _, err := m.rtpConn.ReadFrom(buf)
-	return n, err
+	for {
+		n, raddr, err := m.rtpConn.ReadFrom(buf)
+		if err != nil {
+			// ICMP errors are reported on read, but socket is still usable
+			if m.unreachable != nil && m.unreachable.readError(m, err) {
+				continue
+			}
+			return n, err
+		}
+
+		if m.ice != nil && isSTUN(buf[:n]) {
+			m.handleICECheck(m.rtpConn, buf[:n], raddr, 1)
+			continue
+		}
+
+		if m.interceptors.active() {
+			n, err = m.interceptors.interceptRead(buf, n, m.interceptors.rtp)
+			if err != nil {
+				return 0, err
+			}
+			if n == 0 {
+				continue
+			}
+		}
+
+		m.receivedRTP(buf[:n])
+		return n, nil
+	}
+}
+
+// receivedRTP runs hooks of every received RTP packet
+func (m *MediaSession) receivedRTP(data []byte) {
+	if m.unreachable != nil {
+		m.unreachable.received()
+	}
+	if m.tap != nil {
+		m.tap.rtp(TapDirectionIn, data)
+	}
+	m.forks.deliver(TapDirectionIn, data)
+}
+
+// readRTPRawBatch is fallback for batch reading where it reads single packet
+func (m *MediaSession) readRTPRawBatch(bufs [][]byte, ns []int) (int, error) {
+	if len(bufs) == 0 {
+		return 0, nil
+	}
+	n, err := m.ReadRTPRaw(bufs[0])
+	if err != nil {
+		return 0, err
+	}
+	ns[0] = n
+	return 1, nil
 }
 
 func (m *MediaSession) ReadRTPRawDeadline(buf []byte, t time.Time) (int, error) {
@@ -348,8 +959,8 @@ func (m *MediaSession) ReadRTPRawDeadline(buf []byte, t time.Time) (int, error)
 }
 
 func (m *MediaSession) ReadRTCP(pkts []rtcp.Packet) (n int, err error) {
-	// TODO fix this
-	rawBuf := make([]byte, 1600)
+	// Packets hold reference on buffer so it can not be pooled
+	rawBuf := make([]byte, m.bufferPool().Size())
 	nn, err := m.ReadRTCPRaw(rawBuf)
 	if err != nil {
 		return n, err
@@ -359,15 +970,16 @@ func (m *MediaSession) ReadRTCP(pkts []rtcp.Packet) (n int, err error) {
 	if err != nil {
 		return 0, err
 	}
+	m.readRTCPStats(pkts[:n], time.Now())
 
-	if RTCPDebug {
+	if m.debug.rtcp() {
 		for _, p := range pkts[:n] {
 			if s, ok := p.(fmt.Stringer); ok {
 				m.log.Debug().Msgf("RTCP read:\n%s", s.String())
 				continue
 			}
-			log.Debug().Interface("data", p).
-				Msg("RTCP read (Unknown):\n%s")
+			m.log.Debug().Interface("data", p).
+				Msg("RTCP read (Unknown)")
 		}
 	}
 	return n, err
@@ -380,16 +992,61 @@ func (m *MediaSession) ReadRTCPDeadline(pkts []rtcp.Packet, t time.Time) (n int,
 
 func (m *MediaSession) ReadRTCPRaw(buf []byte) (int, error) {
 	if m.rtcpConn == nil {
-		// just block
-		select {}
+		// no RTCP, just block until closed
+		<-m.closed()
+		return 0, net.ErrClosed
+	}
+	for {
+		n, raddr, err := m.rtcpConn.ReadFrom(buf)
+		if err != nil {
+			return n, err
+		}
+		if m.ice != nil && isSTUN(buf[:n]) {
+			m.handleICECheck(m.rtcpConn, buf[:n], raddr, 2)
+			continue
+		}
+		if m.interceptors.active() {
+			n, err = m.interceptors.interceptRead(buf, n, m.interceptors.rtcp)
+			if err != nil {
+				return 0, err
+			}
+			if n == 0 {
+				continue
+			}
+		}
+		return n, nil
+	}
+}
+
+func (m *MediaSession) handleICECheck(conn net.PacketConn, msg []byte, raddr net.Addr, component int) {
+	nominated, err := m.ice.handleCheck(conn, msg, raddr)
+	if err != nil {
+		m.log.Debug().Err(err).Str("raddr", raddr.String()).Msg("ICE check rejected")
+		return
+	}
+	if !nominated {
+		return
 	}
-	n, _, err := m.rtcpConn.ReadFrom(buf)
 
-	return n, err
+	addr, ok := raddr.(*net.UDPAddr)
+	if !ok {
+		m.log.Debug().Str("raddr", raddr.String()).Msg("ICE nominated address is not UDP")
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if component == 1 {
+		if m.Raddr == nil || m.Raddr.String() != addr.String() {
+			m.log.Info().Str("raddr", addr.String()).Msg("ICE nominated RTP address")
+			m.Raddr = addr
+		}
+		return
+	}
+	m.rtcpRaddr = addr
 }
 
 func (m *MediaSession) WriteRTP(p *rtp.Packet) error {
-	if RTPDebug {
+	if m.debug.rtp() {
 		m.log.Debug().Msgf("RTP write:\n%s", p.String())
 	}
 
@@ -410,12 +1067,48 @@ func (m *MediaSession) WriteRTP(p *rtp.Packet) error {
 }
 
 func (m *MediaSession) WriteRTPRaw(data []byte) (n int, err error) {
-	n, err = m.rtpConn.WriteTo(data, m.Raddr)
+	// Tap and forks see packet as written by application, before interceptors
+	wire, err := m.interceptors.rtp(TapDirectionOut, data)
+	if err != nil {
+		return 0, err
+	}
+	if wire == nil {
+		// Dropped by interceptor
+		return len(data), nil
+	}
+
+	if !m.shape(len(wire)) {
+		// Dropped by shaper, like lost on network
+		return len(data), nil
+	}
+
+	m.mu.RLock()
+	raddr := m.Raddr
+	m.mu.RUnlock()
+
+	if RTPBatchWriterDefault != nil {
+		n, err = RTPBatchWriterDefault.WriteTo(m.rtpConn, wire, raddr)
+	} else {
+		n, err = m.rtpConn.WriteTo(wire, raddr)
+	}
+	if m.unreachable != nil {
+		m.unreachable.sent(m, err)
+	}
+	if err == nil && m.tap != nil {
+		m.tap.rtp(TapDirectionOut, data)
+	}
+	if err == nil {
+		m.forks.deliver(TapDirectionOut, data)
+	}
+	if err == nil && n == len(wire) {
+		// Interceptor can change size of packet
+		n = len(data)
+	}
 	return
 }
 
 func (m *MediaSession) WriteRTCP(p rtcp.Packet) error {
-	if RTCPDebug {
+	if m.debug.rtcp() {
 		if sr, ok := p.(fmt.Stringer); ok {
 			m.log.Debug().Msgf("RTCP write: \n%s", sr.String())
 		}
@@ -425,6 +1118,7 @@ func (m *MediaSession) WriteRTCP(p rtcp.Packet) error {
 	if err != nil {
 		return err
 	}
+	m.sentRTCPStats([]rtcp.Packet{p})
 
 	return m.writeRTCP(data)
 }
@@ -440,15 +1134,23 @@ func (m *MediaSession) WriteRTCPs(pkts []rtcp.Packet) error {
 	if err != nil {
 		return err
 	}
+	m.sentRTCPStats(pkts)
 
 	return m.writeRTCP(data)
 }
 
 func (m *MediaSession) writeRTCP(data []byte) error {
-	var err error
+	data, err := m.interceptors.rtcp(TapDirectionOut, data)
+	if err != nil || data == nil {
+		return err
+	}
 	var n int
 
-	n, err = m.rtcpConn.WriteTo(data, m.rtcpRaddr)
+	m.mu.RLock()
+	raddr := m.rtcpRaddr
+	m.mu.RUnlock()
+
+	n, err = m.rtcpConn.WriteTo(data, raddr)
 	if err != nil {
 		return err
 	}
@@ -498,11 +1200,24 @@ var dtmfEventMapping = map[rune]byte{
 	'D': 15,
 }
 
+// dtmfDigit returns digit of DTMF event
+func dtmfDigit(event uint8) (rune, bool) {
+	for r, e := range dtmfEventMapping {
+		if e == event {
+			return r, true
+		}
+	}
+	return 0, false
+}
+
 // RTPDTMFEncode creates series of DTMF redudant events which should be encoded as payload
 // It is currently only 8000 sample rate considered for telophone event
 func RTPDTMFEncode(char rune) []DTMFEvent {
-	event := dtmfEventMapping[char]
+	return RTPEventEncode(dtmfEventMapping[char])
+}
 
+// RTPEventEncode is RTPDTMFEncode for any RFC 4733 event, ex. 16 for flash
+func RTPEventEncode(event uint8) []DTMFEvent {
 	events := make([]DTMFEvent, 7)
 
 	for i := 0; i < 4; i++ {
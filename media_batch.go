@@ -0,0 +1,137 @@
+package sipgox
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	// RTPBatchWriterDefault when set makes all media sessions queue RTP writes
+	// and flush them in batches. On linux this is done with sendmmsg.
+	// Packets that do not fit in full socket buffer are dropped, like lost on network,
+	// instead blocking other sessions. Check RTPBatchWriter.Dropped
+	RTPBatchWriterDefault *RTPBatchWriter
+)
+
+type batchMsg struct {
+	data []byte
+	addr *net.UDPAddr
+}
+
+// RTPBatchWriter coalesces RTP packets from many sessions and flushes them per socket
+// with single syscall where supported.
+// Packets are flushed when socket queue reaches BatchSize or on every flush interval.
+// Packets are dropped when socket buffer is full.
+type RTPBatchWriter struct {
+	BatchSize int
+
+	mu     sync.Mutex
+	queues map[net.PacketConn][]batchMsg
+
+	dropped atomic.Uint64
+
+	done      chan struct{}
+	closeOnce sync.Once
+	log       zerolog.Logger
+}
+
+// NewRTPBatchWriter creates batch writer and starts flushing with interval.
+// Interval should be lower than packetization time (ex 20ms) to avoid adding jitter.
+// MediaEngineDefault CloseAll closes it
+func NewRTPBatchWriter(batchSize int, flushInterval time.Duration) *RTPBatchWriter {
+	w := &RTPBatchWriter{
+		BatchSize: batchSize,
+		queues:    make(map[net.PacketConn][]batchMsg),
+		done:      make(chan struct{}),
+		log:       log.With().Str("caller", "rtp_batch").Logger(),
+	}
+
+	MediaEngineDefault.goService(func() { w.flushLoop(flushInterval) }, w.Close)
+	return w
+}
+
+// WriteTo queues packet for sending. Data is copied so caller can reuse buffer
+func (w *RTPBatchWriter) WriteTo(conn net.PacketConn, data []byte, raddr *net.UDPAddr) (int, error) {
+	msg := batchMsg{
+		data: make([]byte, len(data)),
+		addr: raddr,
+	}
+	copy(msg.data, data)
+
+	w.mu.Lock()
+	queue := append(w.queues[conn], msg)
+	if len(queue) < w.BatchSize {
+		w.queues[conn] = queue
+		w.mu.Unlock()
+		return len(data), nil
+	}
+	delete(w.queues, conn)
+	w.mu.Unlock()
+
+	return len(data), w.writeBatch(conn, queue)
+}
+
+// Dropped returns number of packets dropped because socket buffer was full
+func (w *RTPBatchWriter) Dropped() uint64 {
+	return w.dropped.Load()
+}
+
+func (w *RTPBatchWriter) writeBatch(conn net.PacketConn, queue []batchMsg) error {
+	dropped, err := writeBatch(conn, queue)
+	if dropped > 0 {
+		w.dropped.Add(uint64(dropped))
+		w.log.Debug().Int("dropped", dropped).Str("laddr", conn.LocalAddr().String()).Msg("Socket buffer full. Dropping packets")
+	}
+	return err
+}
+
+// Flush sends all queued packets
+func (w *RTPBatchWriter) Flush() {
+	w.mu.Lock()
+	queues := w.queues
+	w.queues = make(map[net.PacketConn][]batchMsg, len(queues))
+	w.mu.Unlock()
+
+	for conn, queue := range queues {
+		if err := w.writeBatch(conn, queue); err != nil {
+			w.log.Debug().Err(err).Str("laddr", conn.LocalAddr().String()).Msg("Batch write failed")
+			MediaEngineDefault.reportError(&BackgroundError{Source: BackgroundBatchWrite, Addr: conn.LocalAddr().String(), Err: err})
+		}
+	}
+}
+
+// Close stops flushing. Queued packets are flushed before return. It is safe to call multiple times
+func (w *RTPBatchWriter) Close() {
+	w.closeOnce.Do(func() {
+		close(w.done)
+	})
+	w.Flush()
+}
+
+func (w *RTPBatchWriter) flushLoop(interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-w.done:
+			return
+		case <-t.C:
+		}
+		w.Flush()
+	}
+}
+
+// writeBatchLoop is fallback for platforms without batch syscalls
+func writeBatchLoop(conn net.PacketConn, msgs []batchMsg) error {
+	for _, m := range msgs {
+		if _, err := conn.WriteTo(m.data, m.addr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
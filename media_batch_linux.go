@@ -0,0 +1,154 @@
+//go:build linux
+
+package sipgox
+
+import (
+	"net"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// mmsghdr matches struct mmsghdr used by recvmmsg/sendmmsg
+type mmsghdr struct {
+	Hdr unix.Msghdr
+	Len uint32
+}
+
+// ReadRTPRawBatch reads multiple RTP packets with single recvmmsg syscall.
+// Each buffer in bufs is filled with one packet and its size is stored in ns at same index.
+// It returns number of packets read which is at least 1 when error is nil.
+// Read deadlines set on session are respected.
+func (m *MediaSession) ReadRTPRawBatch(bufs [][]byte, ns []int) (int, error) {
+	sc, ok := m.rtpConn.(syscall.Conn)
+	// ICE checks must be answered and interceptors called on read, which is done with single reads
+	if !ok || len(bufs) == 0 || m.ice != nil || m.interceptors.active() {
+		return m.readRTPRawBatch(bufs, ns)
+	}
+
+	rc, err := sc.SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+
+	iovs := make([]unix.Iovec, len(bufs))
+	hdrs := make([]mmsghdr, len(bufs))
+	for i, b := range bufs {
+		if len(b) > 0 {
+			iovs[i].Base = &b[0]
+		}
+		iovs[i].SetLen(len(b))
+		hdrs[i].Hdr.Iov = &iovs[i]
+		hdrs[i].Hdr.SetIovlen(1)
+	}
+
+	var n int
+	var operr error
+	err = rc.Read(func(fd uintptr) bool {
+		r, _, errno := unix.Syscall6(unix.SYS_RECVMMSG, fd, uintptr(unsafe.Pointer(&hdrs[0])), uintptr(len(hdrs)), 0, 0, 0)
+		if errno == unix.EAGAIN || errno == unix.EWOULDBLOCK {
+			// Let runtime poller wait for data
+			return false
+		}
+		if errno != 0 {
+			operr = errno
+			return true
+		}
+		n = int(r)
+		return true
+	})
+	if err != nil {
+		return 0, err
+	}
+	if operr != nil {
+		return 0, operr
+	}
+
+	for i := 0; i < n; i++ {
+		ns[i] = int(hdrs[i].Len)
+		m.receivedRTP(bufs[i][:ns[i]])
+	}
+	return n, nil
+}
+
+// writeBatch sends all messages on conn with sendmmsg.
+// When socket buffer is full rest of messages are dropped and their number returned
+func writeBatch(conn net.PacketConn, msgs []batchMsg) (int, error) {
+	sc, ok := conn.(syscall.Conn)
+	if !ok || len(msgs) == 0 {
+		return 0, writeBatchLoop(conn, msgs)
+	}
+
+	rc, err := sc.SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+
+	var dropped int
+	var operr error
+	err = rc.Write(func(fd uintptr) bool {
+		// Socket family decides how destination must be encoded
+		sa, err := unix.Getsockname(int(fd))
+		if err != nil {
+			operr = err
+			return true
+		}
+		_, inet6 := sa.(*unix.SockaddrInet6)
+
+		names4 := make([]unix.RawSockaddrInet4, len(msgs))
+		names6 := make([]unix.RawSockaddrInet6, len(msgs))
+		iovs := make([]unix.Iovec, len(msgs))
+		hdrs := make([]mmsghdr, len(msgs))
+		for i, m := range msgs {
+			if len(m.data) > 0 {
+				iovs[i].Base = &m.data[0]
+			}
+			iovs[i].SetLen(len(m.data))
+			hdrs[i].Hdr.Iov = &iovs[i]
+			hdrs[i].Hdr.SetIovlen(1)
+
+			if inet6 {
+				n := &names6[i]
+				n.Family = unix.AF_INET6
+				copy(n.Addr[:], m.addr.IP.To16())
+				n.Port = htons(m.addr.Port)
+				hdrs[i].Hdr.Name = (*byte)(unsafe.Pointer(n))
+				hdrs[i].Hdr.Namelen = unix.SizeofSockaddrInet6
+				continue
+			}
+
+			n := &names4[i]
+			n.Family = unix.AF_INET
+			copy(n.Addr[:], m.addr.IP.To4())
+			n.Port = htons(m.addr.Port)
+			hdrs[i].Hdr.Name = (*byte)(unsafe.Pointer(n))
+			hdrs[i].Hdr.Namelen = unix.SizeofSockaddrInet4
+		}
+
+		for sent := 0; sent < len(hdrs); {
+			r, _, errno := unix.Syscall6(unix.SYS_SENDMMSG, fd, uintptr(unsafe.Pointer(&hdrs[sent])), uintptr(len(hdrs)-sent), 0, 0, 0)
+			if errno == unix.EAGAIN || errno == unix.EWOULDBLOCK {
+				// Drop rest instead blocking all queued sessions
+				dropped = len(hdrs) - sent
+				return true
+			}
+			if errno != 0 {
+				operr = errno
+				return true
+			}
+			sent += int(r)
+		}
+		return true
+	})
+	if err != nil {
+		return 0, err
+	}
+	return dropped, operr
+}
+
+// htons returns port in network byte order as stored in raw sockaddr
+func htons(port int) uint16 {
+	b := [2]byte{byte(port >> 8), byte(port)}
+	return *(*uint16)(unsafe.Pointer(&b[0]))
+}
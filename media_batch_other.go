@@ -0,0 +1,15 @@
+//go:build !linux
+
+package sipgox
+
+import "net"
+
+// ReadRTPRawBatch reads multiple RTP packets.
+// On this platform there is no batch syscall so it reads single packet.
+func (m *MediaSession) ReadRTPRawBatch(bufs [][]byte, ns []int) (int, error) {
+	return m.readRTPRawBatch(bufs, ns)
+}
+
+func writeBatch(conn net.PacketConn, msgs []batchMsg) (int, error) {
+	return 0, writeBatchLoop(conn, msgs)
+}
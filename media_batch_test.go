@@ -0,0 +1,45 @@
+package sipgox
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRTPBatchWriter(t *testing.T) {
+	recv, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	defer recv.Close()
+	raddr := recv.LocalAddr().(*net.UDPAddr)
+
+	w := NewRTPBatchWriter(4, time.Hour)
+	defer w.Close()
+
+	sessions := []*MediaSession{}
+	for i := 0; i < 2; i++ {
+		sess, err := NewMediaSession(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, nil)
+		require.NoError(t, err)
+		defer sess.Close()
+		sess.SetRemoteAddr(raddr)
+		sessions = append(sessions, sess)
+	}
+
+	for i := 0; i < 3; i++ {
+		for _, s := range sessions {
+			_, err := w.WriteTo(s.rtpConn, []byte{byte(i)}, s.Raddr)
+			require.NoError(t, err)
+		}
+	}
+	w.Flush()
+
+	buf := make([]byte, 100)
+	recv.SetReadDeadline(time.Now().Add(time.Second))
+	for i := 0; i < 6; i++ {
+		n, err := recv.Read(buf)
+		require.NoError(t, err)
+		require.Equal(t, 1, n)
+	}
+	require.Zero(t, w.Dropped())
+}
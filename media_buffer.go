@@ -0,0 +1,43 @@
+package sipgox
+
+import "sync"
+
+var (
+	// DefaultBufferPool is used by media sessions unless SetBufferPool is called.
+	// Size 1600 fits any packet within ethernet MTU
+	DefaultBufferPool = NewBufferPool(1600)
+)
+
+// BufferPool is pool of read buffers with fixed size.
+// Size defines max packet size that can be read.
+// Use bigger size for large video packets or smaller for memory constrained targets
+type BufferPool struct {
+	pool sync.Pool
+	size int
+}
+
+func NewBufferPool(size int) *BufferPool {
+	p := &BufferPool{
+		size: size,
+	}
+	p.pool.New = func() any { return make([]byte, size) }
+	return p
+}
+
+// Get returns buffer with length of pool size
+func (p *BufferPool) Get() []byte {
+	return p.pool.Get().([]byte)
+}
+
+// Put returns buffer to pool. Buffers of different size are ignored
+func (p *BufferPool) Put(buf []byte) {
+	if cap(buf) != p.size {
+		return
+	}
+	p.pool.Put(buf[:p.size])
+}
+
+// Size is max packet size
+func (p *BufferPool) Size() int {
+	return p.size
+}
@@ -0,0 +1,96 @@
+package sipgox
+
+import (
+	"context"
+	"time"
+
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+)
+
+// deadlineNow is used to unblock connection operations
+var deadlineNow = time.Unix(1, 0)
+
+// connCtx unblocks connection operation by setting deadline in past once ctx is done.
+// Returned done must be called with operation result. It resets deadline if context fired
+// and returns ctx error in case operation was aborted
+func connCtx(ctx context.Context, setDeadline func(t time.Time) error) (done func(err error) error) {
+	stop := context.AfterFunc(ctx, func() {
+		setDeadline(deadlineNow)
+	})
+
+	return func(err error) error {
+		if stop() {
+			return err
+		}
+
+		// Context fired. Reset deadline so that connection can be reused
+		setDeadline(time.Time{})
+		if err != nil {
+			return ctx.Err()
+		}
+		return nil
+	}
+}
+
+// ReadRTPCtx is ReadRTP which returns when context is canceled
+func (m *MediaSession) ReadRTPCtx(ctx context.Context) (rtp.Packet, error) {
+	done := connCtx(ctx, m.rtpConn.SetReadDeadline)
+	p, err := m.ReadRTP()
+	return p, done(err)
+}
+
+// ReadRTPRawCtx is ReadRTPRaw which returns when context is canceled
+func (m *MediaSession) ReadRTPRawCtx(ctx context.Context, buf []byte) (int, error) {
+	done := connCtx(ctx, m.rtpConn.SetReadDeadline)
+	n, err := m.ReadRTPRaw(buf)
+	return n, done(err)
+}
+
+// ReadRTCPCtx is ReadRTCP which returns when context is canceled
+func (m *MediaSession) ReadRTCPCtx(ctx context.Context, pkts []rtcp.Packet) (int, error) {
+	if m.rtcpConn == nil {
+		<-ctx.Done()
+		return 0, ctx.Err()
+	}
+
+	done := connCtx(ctx, m.rtcpConn.SetReadDeadline)
+	n, err := m.ReadRTCP(pkts)
+	return n, done(err)
+}
+
+// WriteRTPCtx is WriteRTP which returns when context is canceled
+func (m *MediaSession) WriteRTPCtx(ctx context.Context, p *rtp.Packet) error {
+	done := connCtx(ctx, m.rtpConn.SetWriteDeadline)
+	return done(m.WriteRTP(p))
+}
+
+// WriteRTCPCtx is WriteRTCP which returns when context is canceled
+func (m *MediaSession) WriteRTCPCtx(ctx context.Context, p rtcp.Packet) error {
+	done := connCtx(ctx, m.rtcpConn.SetWriteDeadline)
+	return done(m.WriteRTCP(p))
+}
+
+// ReadCtx is Read which returns when context is canceled
+func (r *RTPReader) ReadCtx(ctx context.Context, b []byte) (int, error) {
+	done := connCtx(ctx, r.setReadDeadline)
+	n, err := r.Read(b)
+	return n, done(err)
+}
+
+// WriteCtx is Write which returns when context is canceled.
+// It does not wait media clock rate if context is done
+func (p *RTPWriter) WriteCtx(ctx context.Context, b []byte) (int, error) {
+	done := connCtx(ctx, p.Sess.rtpConn.SetWriteDeadline)
+	n, err := p.WriteSamples(b, p.ClockRateTimestamp, p.nextTimestamp == 0, p.PayloadType)
+	if err := done(err); err != nil {
+		return n, err
+	}
+
+	select {
+	case <-p.clockTicker.C:
+	case <-ctx.Done():
+		return n, ctx.Err()
+	}
+	return n, nil
+}
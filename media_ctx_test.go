@@ -0,0 +1,34 @@
+package sipgox
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMediaSessionReadCtx(t *testing.T) {
+	sess, err := NewMediaSession(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 0}, nil)
+	require.NoError(t, err)
+	defer sess.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	_, err = sess.ReadRTPCtx(ctx)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+
+	// Connection must be usable after context cancel
+	conn, err := net.DialUDP("udp", nil, sess.Laddr)
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("data"))
+	require.NoError(t, err)
+
+	buf := make([]byte, 100)
+	n, err := sess.ReadRTPRawCtx(context.Background(), buf)
+	require.NoError(t, err)
+	require.Equal(t, "data", string(buf[:n]))
+}
@@ -0,0 +1,87 @@
+package sipgox
+
+import (
+	"sync/atomic"
+)
+
+// MediaDebug controls logging of RTP and RTCP packets of media session.
+// It can be changed at runtime with MediaSession.SetDebug, MediaEngine.SetDebug or SetMediaDebugDefault
+type MediaDebug struct {
+	RTP  bool
+	RTCP bool
+	// Sample logs only every Nth packet, counted separately for RTP and RTCP.
+	// 0 or 1 logs every packet
+	Sample uint64
+}
+
+var mediaDebugDefault atomic.Pointer[MediaDebug]
+
+// SetMediaDebugDefault sets debug of all sessions which do not have own debug set
+func SetMediaDebugDefault(d MediaDebug) {
+	mediaDebugDefault.Store(&d)
+}
+
+// MediaDebugDefault returns debug of sessions which do not have own debug set
+func MediaDebugDefault() MediaDebug {
+	if d := mediaDebugDefault.Load(); d != nil {
+		return *d
+	}
+	return MediaDebug{}
+}
+
+// mediaDebug is debug state of session. Media path reads it without lock
+type mediaDebug struct {
+	cfg       atomic.Pointer[MediaDebug]
+	rtpCount  atomic.Uint64
+	rtcpCount atomic.Uint64
+}
+
+func (d *mediaDebug) load() MediaDebug {
+	if c := d.cfg.Load(); c != nil {
+		return *c
+	}
+	return MediaDebugDefault()
+}
+
+// rtp reports should RTP packet be logged
+func (d *mediaDebug) rtp() bool {
+	cfg := d.load()
+	return cfg.RTP && cfg.sampled(d.rtpCount.Add(1))
+}
+
+// rtcp reports should RTCP packet be logged
+func (d *mediaDebug) rtcp() bool {
+	cfg := d.load()
+	return cfg.RTCP && cfg.sampled(d.rtcpCount.Add(1))
+}
+
+func (d MediaDebug) sampled(n uint64) bool {
+	if d.Sample <= 1 {
+		return true
+	}
+	return (n-1)%d.Sample == 0
+}
+
+// SetDebug sets packet logging of session. It is safe to call while media is flowing
+func (s *MediaSession) SetDebug(d MediaDebug) {
+	s.debug.cfg.Store(&d)
+}
+
+// ResetDebug makes session use MediaDebugDefault again
+func (s *MediaSession) ResetDebug() {
+	s.debug.cfg.Store(nil)
+}
+
+// Debug returns current packet logging of session
+func (s *MediaSession) Debug() MediaDebug {
+	return s.debug.load()
+}
+
+// SetDebug sets packet logging of all active sessions, ex. from admin endpoint
+func (e *MediaEngine) SetDebug(d MediaDebug) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for s := range e.sessions {
+		s.SetDebug(d)
+	}
+}
@@ -0,0 +1,56 @@
+package sipgox
+
+import (
+	"bytes"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMediaDebug(t *testing.T) {
+	sess, err := NewMediaSession(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, nil)
+	require.NoError(t, err)
+	defer sess.Close()
+	sess.SetRemoteAddr(sess.Laddr)
+
+	out := &bytes.Buffer{}
+	sess.SetLogger(zerolog.New(out).Level(zerolog.DebugLevel))
+	count := func(msg string) int {
+		return strings.Count(out.String(), msg)
+	}
+	pkt := &rtp.Packet{Header: rtp.Header{Version: 2, SSRC: 1}, Payload: []byte{0}}
+	writeN := func(n int) {
+		for i := 0; i < n; i++ {
+			require.NoError(t, sess.WriteRTP(pkt))
+			require.NoError(t, sess.WriteRTCP(&rtcp.PictureLossIndication{MediaSSRC: 1}))
+		}
+	}
+
+	writeN(2)
+	require.Zero(t, count("RTP write"))
+
+	// Default applies to sessions without own debug
+	SetMediaDebugDefault(MediaDebug{RTCP: true})
+	writeN(2)
+	SetMediaDebugDefault(MediaDebug{})
+	require.Zero(t, count("RTP write"))
+	require.Equal(t, 2, count("RTCP write"))
+
+	// Every 3rd packet is logged
+	e := NewMediaEngine()
+	e.Add(sess)
+	e.SetDebug(MediaDebug{RTP: true, Sample: 3})
+	require.Equal(t, MediaDebug{RTP: true, Sample: 3}, sess.Debug())
+	writeN(7)
+	require.Equal(t, 3, count("RTP write"))
+	require.Equal(t, 2, count("RTCP write"))
+
+	sess.ResetDebug()
+	writeN(2)
+	require.Equal(t, 3, count("RTP write"))
+}
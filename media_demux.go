@@ -0,0 +1,333 @@
+package sipgox
+
+import (
+	"errors"
+	"io"
+	"os"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pion/rtp"
+)
+
+// PayloadTypeCN is static payload type of comfort noise (RFC 3389)
+const PayloadTypeCN = 13
+
+// MediaDemux reads RTP of session in single loop and routes packets by payload type, so that
+// audio reader, DTMF and comfort noise handlers do not steal each other packets from socket.
+// Packets of payload types without route are passed to RTPReader, which drops those it does not accept.
+// See MediaSession StartDemux
+type MediaDemux struct {
+	sess *MediaSession
+
+	mu       sync.Mutex
+	streams  []*DemuxStream
+	handlers map[uint8]func(pkt *rtp.Packet)
+	onDTMF   func(digit rune)
+	// err is read error which stopped demux
+	err error
+
+	dtmf    dtmfReceiver
+	dropped atomic.Uint64
+}
+
+// DemuxStream is queue of raw RTP packets routed by MediaDemux. Packets are dropped if stream
+// is not read fast enough
+type DemuxStream struct {
+	demux        *MediaDemux
+	payloadTypes []uint8
+	// rest stream gets packets without other route or handler, including DTMF
+	rest    bool
+	packets chan []byte
+	dropped atomic.Uint64
+
+	done      chan struct{}
+	closeOnce sync.Once
+
+	mu       sync.Mutex
+	deadline time.Time
+	// wake is closed when deadline changes
+	wake chan struct{}
+}
+
+// StartDemux starts reading RTP of session in single goroutine until session is closed.
+// RTPReader created after it reads its payload types through demux. Other readers of session
+// must not be used. It is safe to call multiple times
+func (s *MediaSession) StartDemux() *MediaDemux {
+	d := &MediaDemux{
+		sess:     s,
+		handlers: make(map[uint8]func(pkt *rtp.Packet)),
+	}
+	if !s.demux.CompareAndSwap(nil, d) {
+		return s.demux.Load()
+	}
+	s.goEngine(d.run)
+	return d
+}
+
+// Demux returns demux of session or nil if it is not started
+func (s *MediaSession) Demux() *MediaDemux {
+	return s.demux.Load()
+}
+
+// Route creates stream of packets with payload types. Payload type routed twice goes to first stream.
+// Size is queue of packets. Default size is 100
+func (d *MediaDemux) Route(size int, payloadTypes ...uint8) *DemuxStream {
+	return d.route(size, false, payloadTypes)
+}
+
+// route creates stream. Rest stream is of RTPReader, which filters payload types itself and
+// must see DTMF to track whole sequence of source
+func (d *MediaDemux) route(size int, rest bool, payloadTypes []uint8) *DemuxStream {
+	if size <= 0 {
+		size = 100
+	}
+	st := &DemuxStream{
+		demux:        d,
+		payloadTypes: payloadTypes,
+		rest:         rest,
+		packets:      make(chan []byte, size),
+		done:         make(chan struct{}),
+		wake:         make(chan struct{}),
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.err != nil {
+		close(st.packets)
+		return st
+	}
+	d.streams = append(d.streams, st)
+	return st
+}
+
+// Handle sets handler of packets with payload type, ex. PayloadTypeCN. It is called from demux
+// loop and must not block. Packet is valid only during call. Nil removes handler
+func (d *MediaDemux) Handle(payloadType uint8, f func(pkt *rtp.Packet)) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if f == nil {
+		delete(d.handlers, payloadType)
+		return
+	}
+	d.handlers[payloadType] = f
+}
+
+// HandleDTMF calls f once for every received DTMF digit (RFC 4733) on negotiated payload type,
+// which is looked up per packet as renegotiation can change it. RTPReader stream still gets
+// DTMF packets. Nil removes handler
+func (d *MediaDemux) HandleDTMF(f func(digit rune)) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.onDTMF = f
+}
+
+// Dropped returns number of packets without route
+func (d *MediaDemux) Dropped() uint64 {
+	return d.dropped.Load()
+}
+
+func (d *MediaDemux) run() {
+	buf := make([]byte, d.sess.bufferPool().Size())
+	pkt := rtp.Packet{}
+	// Deadline can be left from reads before demux
+	d.sess.rtpConn.SetReadDeadline(time.Time{})
+	for {
+		n, err := d.sess.ReadRTPRaw(buf)
+		if err != nil {
+			// Deadline can be set by caller on session, but demux reads until close.
+			// It is cleared, otherwise every read fails immediately
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				d.sess.rtpConn.SetReadDeadline(time.Time{})
+				continue
+			}
+			d.stop(err)
+			return
+		}
+		if n < 2 {
+			continue
+		}
+		pt := buf[1] & 0x7f
+		isDTMF := pt == d.sess.recvDTMFPayloadType()
+
+		d.mu.Lock()
+		st := d.stream(pt)
+		h := d.handlers[pt]
+		onDTMF := d.onDTMF
+		var rest *DemuxStream
+		if st == nil {
+			rest = d.restStream()
+		}
+		d.mu.Unlock()
+
+		switch {
+		case st != nil:
+			st.push(buf[:n])
+		case isDTMF && (rest != nil || onDTMF != nil):
+			if onDTMF != nil {
+				if err := pkt.Unmarshal(buf[:n]); err != nil {
+					d.sess.log.Debug().Err(err).Msg("Demux failed to unmarshal RTP")
+					continue
+				}
+				d.dtmf.read(d.sess, &pkt, onDTMF)
+			}
+			if rest != nil {
+				rest.push(buf[:n])
+			}
+		case h != nil:
+			if err := pkt.Unmarshal(buf[:n]); err != nil {
+				d.sess.log.Debug().Err(err).Msg("Demux failed to unmarshal RTP")
+				continue
+			}
+			h(&pkt)
+		case rest != nil:
+			rest.push(buf[:n])
+		default:
+			d.dropped.Add(1)
+		}
+	}
+}
+
+// stream returns stream routing payload type. Caller must hold lock
+func (d *MediaDemux) stream(pt uint8) *DemuxStream {
+	for _, st := range d.streams {
+		if slices.Contains(st.payloadTypes, pt) {
+			return st
+		}
+	}
+	return nil
+}
+
+// restStream returns first rest stream. Caller must hold lock
+func (d *MediaDemux) restStream() *DemuxStream {
+	for _, st := range d.streams {
+		if st.rest {
+			return st
+		}
+	}
+	return nil
+}
+
+// remove removes stream from routing
+func (d *MediaDemux) remove(st *DemuxStream) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.streams = slices.DeleteFunc(d.streams, func(s *DemuxStream) bool { return s == st })
+}
+
+// stop ends all streams with read error
+func (d *MediaDemux) stop(err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.err = err
+	for _, st := range d.streams {
+		close(st.packets)
+	}
+	d.streams = nil
+}
+
+func (st *DemuxStream) push(data []byte) {
+	select {
+	case st.packets <- append([]byte(nil), data...):
+	default:
+		st.dropped.Add(1)
+	}
+}
+
+// ReadRTPRaw reads next routed packet. It returns read error of session once demux stops
+func (st *DemuxStream) ReadRTPRaw(buf []byte) (int, error) {
+	for {
+		st.mu.Lock()
+		deadline, wake := st.deadline, st.wake
+		st.mu.Unlock()
+
+		var timer *time.Timer
+		var timeout <-chan time.Time
+		if !deadline.IsZero() {
+			d := time.Until(deadline)
+			if d <= 0 {
+				return 0, os.ErrDeadlineExceeded
+			}
+			timer = time.NewTimer(d)
+			timeout = timer.C
+		}
+
+		n, ok, err := st.read(buf, timeout, wake)
+		if timer != nil {
+			timer.Stop()
+		}
+		if ok {
+			return n, err
+		}
+		// Deadline changed
+	}
+}
+
+func (st *DemuxStream) read(buf []byte, timeout <-chan time.Time, wake chan struct{}) (int, bool, error) {
+	select {
+	case data, ok := <-st.packets:
+		if !ok {
+			st.demux.mu.Lock()
+			defer st.demux.mu.Unlock()
+			return 0, true, st.demux.err
+		}
+		return copy(buf, data), true, nil
+	case <-st.done:
+		return 0, true, io.EOF
+	case <-timeout:
+		return 0, true, os.ErrDeadlineExceeded
+	case <-wake:
+		return 0, false, nil
+	}
+}
+
+// SetReadDeadline sets deadline of ReadRTPRaw like on connection. Zero disables deadline
+func (st *DemuxStream) SetReadDeadline(t time.Time) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.deadline = t
+	close(st.wake)
+	st.wake = make(chan struct{})
+	return nil
+}
+
+// Dropped returns number of packets dropped due to full queue
+func (st *DemuxStream) Dropped() uint64 {
+	return st.dropped.Load()
+}
+
+// Close removes stream from demux, so its payload types are routed as they were not routed.
+// ReadRTPRaw returns io.EOF after close. It is safe to call multiple times
+func (st *DemuxStream) Close() {
+	st.closeOnce.Do(func() {
+		st.demux.remove(st)
+		close(st.done)
+	})
+}
+
+// dtmfReceiver reports DTMF event on its first end packet. End packets are sent redundantly
+type dtmfReceiver struct {
+	// timestamp is timestamp of last reported event
+	timestamp uint32
+	reported  bool
+}
+
+func (r *dtmfReceiver) read(sess *MediaSession, pkt *rtp.Packet, onDTMF func(digit rune)) {
+	ev := DTMFEvent{}
+	if err := DTMFDecode(pkt.Payload, &ev); err != nil {
+		sess.log.Debug().Err(err).Msg("Invalid DTMF packet")
+		return
+	}
+	if !ev.EndOfEvent || (r.reported && r.timestamp == pkt.Timestamp) {
+		return
+	}
+	r.timestamp = pkt.Timestamp
+	r.reported = true
+
+	digit, ok := dtmfDigit(ev.Event)
+	if ok && onDTMF != nil {
+		onDTMF(digit)
+	}
+}
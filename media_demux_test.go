@@ -0,0 +1,96 @@
+package sipgox
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/emiago/sipgox/sdp"
+	"github.com/pion/rtp"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMediaDemux(t *testing.T) {
+	sess, err := NewMediaSession(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, nil)
+	require.NoError(t, err)
+	defer sess.Close()
+	sess.Formats = sdp.Formats{sdp.FORMAT_TYPE_ULAW}
+
+	remote, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	defer remote.Close()
+
+	d := sess.StartDemux()
+	require.Same(t, d, sess.StartDemux())
+
+	digits := make(chan rune, 1)
+	d.HandleDTMF(func(digit rune) { digits <- digit })
+	cn := make(chan []byte, 1)
+	d.Handle(PayloadTypeCN, func(pkt *rtp.Packet) { cn <- append([]byte(nil), pkt.Payload...) })
+	reader := NewRTPReader(sess)
+	readerDigits := make(chan rune, 1)
+	reader.OnDTMF = func(digit rune) { readerDigits <- digit }
+
+	send := func(pt uint8, seq uint16, payload []byte) {
+		pkt := rtp.Packet{
+			Header:  rtp.Header{Version: 2, SSRC: 1234, PayloadType: pt, SequenceNumber: seq, Timestamp: 160},
+			Payload: payload,
+		}
+		data, _ := pkt.Marshal()
+		_, err := remote.WriteTo(data, sess.Laddr)
+		require.NoError(t, err)
+	}
+	send(PayloadTypeCN, 1, []byte{40})
+	send(0, 2, []byte{0, 0, 0})
+	send(101, 3, DTMFEncode(DTMFEvent{Event: 5, EndOfEvent: true, Duration: 800}))
+	send(0, 4, []byte{1, 2, 3})
+
+	buf := make([]byte, 1500)
+	_, err = reader.Read(buf)
+	require.NoError(t, err)
+	n, err := reader.Read(buf)
+	require.NoError(t, err)
+	require.Equal(t, []byte{1, 2, 3}, buf[:n])
+	require.Equal(t, []byte{40}, <-cn)
+	require.Equal(t, '5', <-digits)
+	require.Equal(t, '5', <-readerDigits)
+	// DTMF is not counted as loss
+	require.Equal(t, uint64(4), sess.stats.recv.lastSeq)
+	require.Zero(t, sess.stats.recv.loss)
+
+	// Routed stream gets packets until closed
+	stream := d.Route(0, 8)
+	send(8, 5, []byte{4})
+	_, err = stream.ReadRTPRaw(buf)
+	require.NoError(t, err)
+	require.Equal(t, byte(8), buf[1]&0x7f)
+	stream.Close()
+	stream.Close()
+	_, err = stream.ReadRTPRaw(buf)
+	require.ErrorIs(t, err, io.EOF)
+	d.mu.Lock()
+	require.Nil(t, d.stream(8))
+	d.mu.Unlock()
+
+	// Deadline left on session does not stop demux
+	sess.rtpConn.SetReadDeadline(time.Now().Add(-time.Second))
+	time.Sleep(10 * time.Millisecond)
+	send(PayloadTypeCN, 7, []byte{41})
+	select {
+	case payload := <-cn:
+		require.Equal(t, []byte{41}, payload)
+	case <-time.After(time.Second):
+		t.Fatal("demux stopped reading")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err = reader.ReadCtx(ctx, buf)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+
+	sess.Close()
+	_, err = reader.Read(buf)
+	require.ErrorIs(t, err, io.EOF)
+}
@@ -0,0 +1,108 @@
+package sipgox
+
+import (
+	"context"
+	"sync"
+)
+
+// MediaEngineDefault tracks all media sessions created by constructors, so they can be
+// closed together on shutdown with CloseAll
+var MediaEngineDefault = NewMediaEngine()
+
+// MediaEngine tracks active media sessions and goroutines reading or writing them
+type MediaEngine struct {
+	mu       sync.Mutex
+	sessions map[*MediaSession]struct{}
+	wg       sync.WaitGroup
+	onError  func(err *BackgroundError)
+	// stops end goroutines of services not bound to session. See goService
+	stops []func()
+}
+
+func NewMediaEngine() *MediaEngine {
+	return &MediaEngine{sessions: map[*MediaSession]struct{}{}}
+}
+
+// Add tracks session until it is closed. Session is moved from engine which tracked it before
+func (e *MediaEngine) Add(s *MediaSession) {
+	if s.engine != nil && s.engine != e {
+		s.engine.remove(s)
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.sessions[s] = struct{}{}
+	s.engine = e
+}
+
+func (e *MediaEngine) remove(s *MediaSession) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	delete(e.sessions, s)
+}
+
+// Len returns number of active sessions
+func (e *MediaEngine) Len() int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return len(e.sessions)
+}
+
+// Go runs f in goroutine which CloseAll waits for. Use it for session readers and writers,
+// which must return once session is closed
+func (e *MediaEngine) Go(f func()) {
+	e.wg.Add(1)
+	go func() {
+		defer e.wg.Done()
+		f()
+	}()
+}
+
+// goService runs f like Go for service not bound to session, ex. tap delivery.
+// CloseAll calls stop after sessions are closed, which must make f return
+func (e *MediaEngine) goService(f func(), stop func()) {
+	e.mu.Lock()
+	e.stops = append(e.stops, stop)
+	e.mu.Unlock()
+	e.Go(f)
+}
+
+// goEngine runs f with Go of session engine or MediaEngineDefault
+func (s *MediaSession) goEngine(f func()) {
+	e := s.engine
+	if e == nil {
+		e = MediaEngineDefault
+	}
+	e.Go(f)
+}
+
+// CloseAll closes every active session, which unblocks all pending reads, and waits
+// goroutines started with Go until ctx is done. It is safe to call concurrently with Close
+func (e *MediaEngine) CloseAll(ctx context.Context) error {
+	e.mu.Lock()
+	sessions := make([]*MediaSession, 0, len(e.sessions))
+	for s := range e.sessions {
+		sessions = append(sessions, s)
+	}
+	stops := e.stops
+	e.stops = nil
+	e.mu.Unlock()
+
+	for _, s := range sessions {
+		s.Close()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		for _, stop := range stops {
+			stop()
+		}
+		e.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,72 @@
+package sipgox
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/pion/rtcp"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMediaEngineCloseAll(t *testing.T) {
+	e := NewMediaEngine()
+
+	sess, err := NewMediaSession(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, nil)
+	require.NoError(t, err)
+	e.Add(sess)
+
+	// Session without RTCP blocks on RTCP read until closed
+	noRTCP, err := NewMediaSession(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, nil)
+	require.NoError(t, err)
+	noRTCP.rtcpConn.Close()
+	noRTCP.rtcpConn = nil
+	e.Add(noRTCP)
+	require.Equal(t, 2, e.Len())
+
+	errs := make(chan error, 3)
+	e.Go(func() {
+		_, err := sess.ReadRTP()
+		errs <- err
+	})
+	e.Go(func() {
+		_, err := sess.ReadRTCP(make([]rtcp.Packet, 5))
+		errs <- err
+	})
+	e.Go(func() {
+		_, err := noRTCP.ReadRTCPRaw(make([]byte, 1500))
+		errs <- err
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	require.NoError(t, e.CloseAll(ctx))
+	require.Equal(t, 0, e.Len())
+	for i := 0; i < 3; i++ {
+		require.ErrorIs(t, <-errs, net.ErrClosed)
+	}
+
+	// Closing again is safe
+	sess.Close()
+
+	// Deadline is returned if goroutine does not stop
+	block := make(chan struct{})
+	defer close(block)
+	e.Go(func() { <-block })
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	require.ErrorIs(t, e.CloseAll(ctx), context.DeadlineExceeded)
+}
+
+func TestMediaEngineCloseAllServices(t *testing.T) {
+	e := NewMediaEngine()
+	tap := &BufferedTapDelivery{queue: make(chan tapItem, 1), done: make(chan struct{}), delivery: &tapRecorder{}}
+	e.goService(tap.run, tap.Close)
+	tap.DeliverRTP("call", TapDirectionIn, []byte{1})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	require.NoError(t, e.CloseAll(ctx))
+	require.Equal(t, uint64(1), tap.Stats().Delivered)
+}
@@ -0,0 +1,74 @@
+package sipgox
+
+import "fmt"
+
+// BackgroundSource identifies internal goroutine which failed
+type BackgroundSource string
+
+const (
+	// BackgroundTCPConnect is connecting TCP media as active side
+	BackgroundTCPConnect BackgroundSource = "tcp_connect"
+	// BackgroundNACK is sending RTCP NACK for lost packets
+	BackgroundNACK BackgroundSource = "nack"
+	// BackgroundBatchWrite is flushing RTPBatchWriter queue
+	BackgroundBatchWrite BackgroundSource = "batch_write"
+	// BackgroundPortMapping is mapping or renewing media port mapping on gateway
+	BackgroundPortMapping BackgroundSource = "port_mapping"
+	// BackgroundRegister is refreshing registration while answering
+	BackgroundRegister BackgroundSource = "register"
+	// BackgroundTURNRefresh is refreshing TURN allocation of relayed media
+	BackgroundTURNRefresh BackgroundSource = "turn_refresh"
+	// BackgroundTCPAccept is accepting TCP media as passive side
+	BackgroundTCPAccept BackgroundSource = "tcp_accept"
+	// BackgroundReplication is syncing or streaming state to standby
+	BackgroundReplication BackgroundSource = "replication"
+	// BackgroundRefer is dialing transfer target of received REFER
+	BackgroundRefer BackgroundSource = "refer"
+)
+
+// BackgroundError is failure of internal goroutine reported to MediaEngine.OnError
+type BackgroundError struct {
+	Source BackgroundSource
+	// Session is media session of failed loop. Nil if loop is not bound to session
+	Session *MediaSession
+	// Addr is local or remote address involved, if any
+	Addr string
+	Err  error
+}
+
+func (e *BackgroundError) Error() string {
+	if e.Addr != "" {
+		return fmt.Sprintf("%s %s: %s", e.Source, e.Addr, e.Err)
+	}
+	return fmt.Sprintf("%s: %s", e.Source, e.Err)
+}
+
+func (e *BackgroundError) Unwrap() error {
+	return e.Err
+}
+
+// OnError sets callback for failures of internal goroutines of engine sessions.
+// Failures are still logged. Callback must not block
+func (e *MediaEngine) OnError(f func(err *BackgroundError)) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.onError = f
+}
+
+func (e *MediaEngine) reportError(err *BackgroundError) {
+	e.mu.Lock()
+	f := e.onError
+	e.mu.Unlock()
+	if f != nil {
+		f(err)
+	}
+}
+
+// reportError reports failure to engine of session or MediaEngineDefault
+func (s *MediaSession) reportError(src BackgroundSource, addr string, err error) {
+	e := s.engine
+	if e == nil {
+		e = MediaEngineDefault
+	}
+	e.reportError(&BackgroundError{Source: src, Session: s, Addr: addr, Err: err})
+}
@@ -0,0 +1,42 @@
+package sipgox
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMediaEngineOnError(t *testing.T) {
+	offerer, err := NewMediaSessionTCP(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	offer := offerer.LocalSDP()
+	// Nobody listens anymore, so connecting fails
+	offerer.Close()
+
+	answerer, err := NewMediaSessionTCP(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	defer answerer.Close()
+
+	e := NewMediaEngine()
+	e.Add(answerer)
+	errs := make(chan *BackgroundError, 2)
+	e.OnError(func(err *BackgroundError) { errs <- err })
+
+	require.NoError(t, answerer.RemoteSDP(offer))
+	for i := 0; i < 2; i++ {
+		select {
+		case err := <-errs:
+			require.Equal(t, BackgroundTCPConnect, err.Source)
+			require.Equal(t, answerer, err.Session)
+			require.NotEmpty(t, err.Addr)
+			var opErr *net.OpError
+			require.True(t, errors.As(err, &opErr))
+			require.Contains(t, err.Error(), "tcp_connect 127.0.0.1:")
+		case <-time.After(2 * time.Second):
+			t.Fatal("error not reported")
+		}
+	}
+}
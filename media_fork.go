@@ -0,0 +1,157 @@
+package sipgox
+
+import (
+	"context"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pion/rtp"
+)
+
+// ForkPacket is RTP packet duplicated by MediaFork
+type ForkPacket struct {
+	Direction TapDirection
+	Time      time.Time
+	// Data is copy of raw RTP packet and can be retained
+	Data []byte
+}
+
+// Unmarshal parses RTP packet
+func (p ForkPacket) Unmarshal() (*rtp.Packet, error) {
+	pkt := &rtp.Packet{}
+	return pkt, pkt.Unmarshal(p.Data)
+}
+
+// MediaFork is read-only duplicate of received and sent RTP of media session, used by recorders,
+// analytics or SIPREC. Packets are queued and dropped if fork is not read fast enough,
+// so live call path is never blocked
+type MediaFork struct {
+	sess    *MediaSession
+	packets chan ForkPacket
+	dropped atomic.Uint64
+
+	closeOnce sync.Once
+	done      chan struct{}
+}
+
+// mediaForks is list of session forks. It is copied on change so media path reads it without lock
+type mediaForks struct {
+	mu    sync.Mutex
+	forks atomic.Pointer[[]*MediaFork]
+	// closed is set when session is closed
+	closed bool
+}
+
+// Fork creates fork of session RTP with queue of size packets. Default size is 250 (5s of 20ms packets).
+// Fork ends when it is closed or session is closed
+func (s *MediaSession) Fork(size int) *MediaFork {
+	if size <= 0 {
+		size = 250
+	}
+	f := &MediaFork{
+		sess:    s,
+		packets: make(chan ForkPacket, size),
+		done:    make(chan struct{}),
+	}
+
+	s.forks.mu.Lock()
+	defer s.forks.mu.Unlock()
+	if s.forks.closed {
+		f.closeOnce.Do(func() { close(f.done) })
+		return f
+	}
+	forks := []*MediaFork{}
+	if cur := s.forks.forks.Load(); cur != nil {
+		forks = append(forks, *cur...)
+	}
+	forks = append(forks, f)
+	s.forks.forks.Store(&forks)
+	return f
+}
+
+// deliver copies packet to all forks
+func (l *mediaForks) deliver(dir TapDirection, data []byte) {
+	forks := l.forks.Load()
+	if forks == nil {
+		return
+	}
+	now := time.Now()
+	for _, f := range *forks {
+		cp := make([]byte, len(data))
+		copy(cp, data)
+		select {
+		case f.packets <- ForkPacket{Direction: dir, Time: now, Data: cp}:
+		default:
+			f.dropped.Add(1)
+		}
+	}
+}
+
+func (l *mediaForks) remove(f *MediaFork) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	cur := l.forks.Load()
+	if cur == nil {
+		return
+	}
+	forks := make([]*MediaFork, 0, len(*cur))
+	for _, c := range *cur {
+		if c != f {
+			forks = append(forks, c)
+		}
+	}
+	l.forks.Store(&forks)
+}
+
+// closeAll closes all forks, called when session is closed
+func (l *mediaForks) closeAll() {
+	l.mu.Lock()
+	l.closed = true
+	cur := l.forks.Load()
+	l.mu.Unlock()
+	if cur == nil {
+		return
+	}
+	for _, f := range *cur {
+		f.Close()
+	}
+}
+
+// ReadPacket returns next packet. Queued packets are returned after fork is closed,
+// and then io.EOF
+func (f *MediaFork) ReadPacket(ctx context.Context) (ForkPacket, error) {
+	select {
+	case p := <-f.packets:
+		return p, nil
+	default:
+	}
+
+	select {
+	case p := <-f.packets:
+		return p, nil
+	case <-f.done:
+		select {
+		case p := <-f.packets:
+			return p, nil
+		default:
+		}
+		return ForkPacket{}, io.EOF
+	case <-ctx.Done():
+		return ForkPacket{}, ctx.Err()
+	}
+}
+
+// Dropped returns number of packets dropped because queue was full
+func (f *MediaFork) Dropped() uint64 {
+	return f.dropped.Load()
+}
+
+// Close detaches fork from session. It is safe to call multiple times
+func (f *MediaFork) Close() {
+	f.closeOnce.Do(func() {
+		f.sess.forks.remove(f)
+		close(f.done)
+	})
+}
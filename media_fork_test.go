@@ -0,0 +1,64 @@
+package sipgox
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMediaFork(t *testing.T) {
+	sessA, err := NewMediaSession(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, nil)
+	require.NoError(t, err)
+	defer sessA.Close()
+	sessB, err := NewMediaSession(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, nil)
+	require.NoError(t, err)
+	sessA.SetRemoteAddr(sessB.Laddr)
+	sessB.SetRemoteAddr(sessA.Laddr)
+
+	fork := sessB.Fork(2)
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	// Incoming
+	pkt := &rtp.Packet{Header: rtp.Header{Version: 2, SequenceNumber: 1, SSRC: 1}, Payload: []byte("in")}
+	require.NoError(t, sessA.WriteRTP(pkt))
+	_, err = sessB.ReadRTP()
+	require.NoError(t, err)
+	// Outgoing
+	require.NoError(t, sessB.WriteRTP(&rtp.Packet{Header: rtp.Header{Version: 2, SequenceNumber: 1, SSRC: 2}, Payload: []byte("out")}))
+	// Queue is full, so packet is dropped and call is not blocked
+	require.NoError(t, sessB.WriteRTP(&rtp.Packet{Header: rtp.Header{Version: 2, SequenceNumber: 2, SSRC: 2}, Payload: []byte("out")}))
+	require.Equal(t, uint64(1), fork.Dropped())
+
+	p, err := fork.ReadPacket(ctx)
+	require.NoError(t, err)
+	require.Equal(t, TapDirectionIn, p.Direction)
+	forked, err := p.Unmarshal()
+	require.NoError(t, err)
+	require.Equal(t, []byte("in"), forked.Payload)
+
+	// Queued packets are still read after session is closed
+	sessB.Close()
+	p, err = fork.ReadPacket(ctx)
+	require.NoError(t, err)
+	require.Equal(t, TapDirectionOut, p.Direction)
+	_, err = fork.ReadPacket(ctx)
+	require.ErrorIs(t, err, io.EOF)
+
+	// Fork of closed session ends immediately
+	_, err = sessB.Fork(0).ReadPacket(ctx)
+	require.ErrorIs(t, err, io.EOF)
+
+	// Closed fork does not receive packets
+	fork = sessA.Fork(0)
+	fork.Close()
+	fork.Close()
+	require.NoError(t, sessA.WriteRTP(pkt))
+	_, err = fork.ReadPacket(ctx)
+	require.ErrorIs(t, err, io.EOF)
+}
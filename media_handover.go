@@ -0,0 +1,199 @@
+package sipgox
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	"github.com/emiago/sipgox/sdp"
+	"github.com/rs/zerolog/log"
+)
+
+// MediaSessionState is state of media session which can be passed to other process.
+// Sockets are not part of state and they are passed as files. See Export
+type MediaSessionState struct {
+	Laddr     string
+	Raddr     string
+	RTCPRaddr string
+
+	Formats sdp.Formats
+	Mode    sdp.Mode
+	// Codecs, FormatParams and Registry describe negotiated Formats. See MediaSession
+	Codecs       []sdp.Codec       `json:",omitempty"`
+	FormatParams map[string]string `json:",omitempty"`
+	Registry     []sdp.Codec       `json:",omitempty"`
+	// RecvFormats maps format to format remote sends with. See MediaSession.ReceivePayloadType
+	RecvFormats map[string]string `json:",omitempty"`
+
+	// Writer state is needed for remote to see continuous stream
+	Writer *RTPWriterState `json:",omitempty"`
+}
+
+// RTPWriterState is state of RTP writer needed to continue stream without SSRC change
+type RTPWriterState struct {
+	SSRC          uint32
+	PayloadType   uint8
+	SampleRate    uint32
+	Sequence      uint16
+	NextTimestamp uint32
+	// PacketTime is duration of written packets. Zero is 20ms
+	PacketTime time.Duration
+	// DTMFPayloadType is telephone-event payload type DTMF is sent with
+	DTMFPayloadType uint8
+	// DTMFEvents are telephone events remote receives. Zero if remote did not advertise them
+	DTMFEvents sdp.TelephoneEvents
+}
+
+// Export returns state and duplicated sockets (RTP, RTCP) of this session.
+// Session can be closed after export, as files have their own file descriptors.
+// Pass writer if stream should continue with same SSRC and sequence in new process
+func (s *MediaSession) Export(w *RTPWriter) (MediaSessionState, []*os.File, error) {
+	s.mu.RLock()
+	state := MediaSessionState{
+		Laddr:        s.Laddr.String(),
+		Formats:      s.Formats,
+		Mode:         s.Mode,
+		Codecs:       s.Codecs,
+		FormatParams: s.FormatParams,
+		Registry:     s.Registry.Codecs(),
+		RecvFormats:  s.recvFormats,
+	}
+	if s.Raddr != nil {
+		state.Raddr = s.Raddr.String()
+	}
+	if s.rtcpRaddr != nil {
+		state.RTCPRaddr = s.rtcpRaddr.String()
+	}
+	s.mu.RUnlock()
+	if w != nil {
+		st := w.State()
+		state.Writer = &st
+	}
+
+	files := make([]*os.File, 0, 2)
+	for _, c := range []net.PacketConn{s.rtpConn, s.rtcpConn} {
+		fc, ok := c.(interface{ File() (*os.File, error) })
+		if !ok {
+			closeFiles(files)
+			return state, nil, fmt.Errorf("media connection %T can not be exported", c)
+		}
+		f, err := fc.File()
+		if err != nil {
+			closeFiles(files)
+			return state, nil, err
+		}
+		files = append(files, f)
+	}
+	return state, files, nil
+}
+
+// ImportMediaSession recreates media session from exported state and files.
+// Files are closed after import as connections hold their own copy
+func ImportMediaSession(state MediaSessionState, files []*os.File) (*MediaSession, error) {
+	defer closeFiles(files)
+	if len(files) != 2 {
+		return nil, fmt.Errorf("expected RTP and RTCP file, got %d", len(files))
+	}
+
+	rtpConn, err := net.FilePacketConn(files[0])
+	if err != nil {
+		return nil, err
+	}
+	rtcpConn, err := net.FilePacketConn(files[1])
+	if err != nil {
+		rtpConn.Close()
+		return nil, err
+	}
+
+	laddr, ok := rtpConn.LocalAddr().(*net.UDPAddr)
+	if !ok {
+		rtpConn.Close()
+		rtcpConn.Close()
+		return nil, fmt.Errorf("imported RTP connection is not UDP: %s", rtpConn.LocalAddr())
+	}
+
+	s := &MediaSession{
+		Laddr:        laddr,
+		rtpConn:      rtpConn,
+		rtcpConn:     rtcpConn,
+		Formats:      state.Formats,
+		Mode:         state.Mode,
+		Codecs:       state.Codecs,
+		FormatParams: state.FormatParams,
+		recvFormats:  state.RecvFormats,
+		log:          log.With().Str("caller", "media").Logger(),
+	}
+
+	if len(state.Registry) > 0 {
+		s.Registry = sdp.NewCodecRegistry()
+		for _, c := range state.Registry {
+			if err := s.Registry.Register(c); err != nil {
+				s.Close()
+				return nil, err
+			}
+		}
+	}
+
+	if state.Raddr != "" {
+		if s.Raddr, err = net.ResolveUDPAddr("udp", state.Raddr); err != nil {
+			s.Close()
+			return nil, err
+		}
+	}
+	if state.RTCPRaddr != "" {
+		if s.rtcpRaddr, err = net.ResolveUDPAddr("udp", state.RTCPRaddr); err != nil {
+			s.Close()
+			return nil, err
+		}
+	}
+	MediaEngineDefault.Add(s)
+	return s, nil
+}
+
+// State returns writer state for handover
+func (p *RTPWriter) State() RTPWriterState {
+	st := RTPWriterState{
+		SSRC:          p.SSRC,
+		PayloadType:   p.PayloadType,
+		SampleRate:    p.SampleRate,
+		Sequence:      p.seq.seqNum,
+		NextTimestamp: p.nextTimestamp,
+	}
+	if p.SampleRate > 0 {
+		st.PacketTime = time.Duration(uint64(p.ClockRateTimestamp) * uint64(time.Second) / uint64(p.SampleRate))
+	}
+	st.DTMFPayloadType, st.DTMFEvents = p.Sess.sendDTMF()
+	return st
+}
+
+// NewRTPWriterFromState creates writer continuing stream from exported state.
+// DTMF payload type and events of state are set on sess
+func NewRTPWriterFromState(sess *MediaSession, st RTPWriterState) *RTPWriter {
+	w := NewRTPWriter(sess)
+	w.SSRC = st.SSRC
+	w.PayloadType = st.PayloadType
+	w.SampleRate = st.SampleRate
+	w.seq.InitSeq(st.Sequence)
+	w.nextTimestamp = st.NextTimestamp
+	ptime := st.PacketTime
+	if ptime == 0 {
+		ptime = 20 * time.Millisecond
+	}
+	w.updateClockRate(ptime)
+
+	// DTMF is sent with payload type of session, which is not negotiated again after handover
+	sess.mu.Lock()
+	if st.DTMFPayloadType != 0 {
+		sess.DTMFPayloadType = st.DTMFPayloadType
+	}
+	sess.remoteEvents = st.DTMFEvents
+	sess.mu.Unlock()
+	return w
+}
+
+func closeFiles(files []*os.File) {
+	for _, f := range files {
+		f.Close()
+	}
+}
@@ -0,0 +1,80 @@
+//go:build unix
+
+package sipgox
+
+import (
+	"net"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/emiago/sipgox/sdp"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMediaSessionHandover(t *testing.T) {
+	sess, err := NewMediaSession(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 0}, nil)
+	require.NoError(t, err)
+	sess.SetRemoteAddr(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 4000})
+	sess.Ptime = 30 * time.Millisecond
+	sess.DTMFPayloadType = 96
+	sess.remoteEvents = sdp.DTMFTelephoneEvents
+	// Remote answered our opus 111 with own dynamic payload type 96
+	sess.Registry = sdp.NewCodecRegistry()
+	opus := sdp.Codec{PayloadType: 111, Name: "opus", ClockRate: 48000, Channels: 2}
+	require.NoError(t, sess.Registry.Register(opus))
+	sess.Formats = sdp.Formats{sdp.FORMAT_TYPE_G722, "96", sdp.FORMAT_TYPE_G729}
+	sess.Codecs = []sdp.Codec{{PayloadType: 96, Name: "opus", ClockRate: 48000, Channels: 2, Fmtp: "useinbandfec=1"}}
+	sess.FormatParams = map[string]string{sdp.FORMAT_TYPE_G729: "annexb=no"}
+	sess.recvFormats = map[string]string{"96": "111"}
+	w := NewRTPWriter(sess)
+
+	state, files, err := sess.Export(w)
+	require.NoError(t, err)
+	sess.Close()
+
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_DGRAM, 0)
+	require.NoError(t, err)
+	newUnixConn := func(fd int) *net.UnixConn {
+		f := os.NewFile(uintptr(fd), "unix")
+		defer f.Close()
+		c, err := net.FileConn(f)
+		require.NoError(t, err)
+		return c.(*net.UnixConn)
+	}
+	sender, receiver := newUnixConn(fds[0]), newUnixConn(fds[1])
+	defer sender.Close()
+	defer receiver.Close()
+
+	require.NoError(t, SendMediaSession(sender, state, files))
+	closeFiles(files)
+
+	imported, importedState, err := ReceiveMediaSession(receiver)
+	require.NoError(t, err)
+	defer imported.Close()
+
+	require.Equal(t, sess.Laddr.String(), imported.Laddr.String())
+	require.Equal(t, "127.0.0.1:4000", imported.Raddr.String())
+	require.Equal(t, "127.0.0.1:4001", imported.rtcpRaddr.String())
+
+	w2 := NewRTPWriterFromState(imported, *importedState.Writer)
+	require.Equal(t, w.SSRC, w2.SSRC)
+	require.Equal(t, w.seq.NextSeqNumber(), w2.seq.NextSeqNumber())
+	require.Equal(t, uint32(240), w2.ClockRateTimestamp)
+	pt, events := imported.sendDTMF()
+	require.Equal(t, uint8(96), pt)
+	require.Equal(t, sdp.DTMFTelephoneEvents, events)
+
+	require.Equal(t, sess.Formats, imported.Formats)
+	require.Equal(t, uint8(111), imported.ReceivePayloadType("96"))
+	c, ok := imported.codec("96")
+	require.True(t, ok)
+	require.Equal(t, "useinbandfec=1", c.Fmtp)
+	c, ok = imported.codec(sdp.FORMAT_TYPE_G729)
+	require.True(t, ok)
+	require.Equal(t, "annexb=no", c.Fmtp)
+	c, ok = imported.Registry.Lookup("111")
+	require.True(t, ok)
+	require.True(t, opus.Equal(c))
+}
@@ -0,0 +1,70 @@
+//go:build unix
+
+package sipgox
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+)
+
+// SendMediaSession passes exported session state and sockets over unix socket with SCM_RIGHTS.
+// Use it for zero downtime handover of media to new process
+func SendMediaSession(conn *net.UnixConn, state MediaSessionState, files []*os.File) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	fds := make([]int, len(files))
+	for i, f := range files {
+		fds[i] = int(f.Fd())
+	}
+
+	n, oobn, err := conn.WriteMsgUnix(data, syscall.UnixRights(fds...), nil)
+	if err != nil {
+		return err
+	}
+	if n != len(data) || oobn == 0 {
+		return fmt.Errorf("media session state not fully written")
+	}
+	return nil
+}
+
+// ReceiveMediaSession reads state and sockets sent with SendMediaSession and imports session
+func ReceiveMediaSession(conn *net.UnixConn) (*MediaSession, MediaSessionState, error) {
+	var state MediaSessionState
+	buf := make([]byte, 65535)
+	oob := make([]byte, syscall.CmsgSpace(2*4))
+	n, oobn, _, _, err := conn.ReadMsgUnix(buf, oob)
+	if err != nil {
+		return nil, state, err
+	}
+
+	msgs, err := syscall.ParseSocketControlMessage(oob[:oobn])
+	if err != nil {
+		return nil, state, err
+	}
+
+	files := []*os.File{}
+	for _, m := range msgs {
+		fds, err := syscall.ParseUnixRights(&m)
+		if err != nil {
+			closeFiles(files)
+			return nil, state, err
+		}
+		for _, fd := range fds {
+			files = append(files, os.NewFile(uintptr(fd), "media"))
+		}
+	}
+
+	if err := json.Unmarshal(buf[:n], &state); err != nil {
+		closeFiles(files)
+		return nil, state, err
+	}
+
+	sess, err := ImportMediaSession(state, files)
+	return sess, state, err
+}
@@ -0,0 +1,54 @@
+package sipgox
+
+import (
+	"github.com/emiago/sipgox/sdp"
+)
+
+// HoldOffer switches session to hold and returns SDP offer for re-INVITE.
+// Mode should be sdp.ModeSendonly (music on hold) or sdp.ModeInactive.
+// With inactive RTPWriter output is paused
+func (s *MediaSession) HoldOffer(mode sdp.Mode) []byte {
+	s.mu.Lock()
+	s.Mode = mode
+	s.mu.Unlock()
+	return s.LocalSDP()
+}
+
+// ResumeOffer switches session back to sendrecv and returns SDP offer for re-INVITE
+func (s *MediaSession) ResumeOffer() []byte {
+	s.mu.Lock()
+	s.Mode = sdp.ModeSendrecv
+	s.mu.Unlock()
+	return s.LocalSDP()
+}
+
+// OnHold returns true if session does not send media, ex. remote put call on hold
+func (s *MediaSession) OnHold() bool {
+	return !s.sending()
+}
+
+// sending returns false when mode does not allow sending. RTPWriter drops output then
+func (s *MediaSession) sending() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.Mode != sdp.ModeRecvonly && s.Mode != sdp.ModeInactive
+}
+
+// Direction returns negotiated audio direction from local point of view.
+// It combines local Mode with media level direction of remote SDP, ex. remote sendonly
+// gives recvonly, so application can stop sending or mute playback accordingly
+func (s *MediaSession) Direction() sdp.Mode {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	remote := s.remoteMode
+	if remote == "" {
+		remote = sdp.ModeSendrecv
+	}
+	return sdp.AnswerMode(remote, s.Mode)
+}
+
+func (s *MediaSession) setRemoteMode(sd sdp.SessionDescription) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.remoteMode = sd.Mode()
+}
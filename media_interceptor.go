@@ -0,0 +1,105 @@
+package sipgox
+
+import (
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+// MediaInterceptor observes or modifies raw RTP and RTCP packets of media session, ex. for stats,
+// SRTP, recording or debugging. It returns packet passed further. Received packet can be modified
+// in place, but sent packet must be modified in new buffer, ex. with appended auth tag, as data is
+// still owned by application and delivered to taps and forks after write.
+// Taps and forks are on application side of chain in both directions, so they see plain packets.
+// Returning nil drops packet and error is returned to caller.
+// Calls are done inline with media path, so implementation must not block
+type MediaInterceptor interface {
+	InterceptRTP(dir TapDirection, data []byte) ([]byte, error)
+	InterceptRTCP(dir TapDirection, data []byte) ([]byte, error)
+}
+
+// MediaInterceptorFuncs is MediaInterceptor from functions. Nil function passes packets unchanged
+type MediaInterceptorFuncs struct {
+	RTP  func(dir TapDirection, data []byte) ([]byte, error)
+	RTCP func(dir TapDirection, data []byte) ([]byte, error)
+}
+
+func (f MediaInterceptorFuncs) InterceptRTP(dir TapDirection, data []byte) ([]byte, error) {
+	if f.RTP == nil {
+		return data, nil
+	}
+	return f.RTP(dir, data)
+}
+
+func (f MediaInterceptorFuncs) InterceptRTCP(dir TapDirection, data []byte) ([]byte, error) {
+	if f.RTCP == nil {
+		return data, nil
+	}
+	return f.RTCP(dir, data)
+}
+
+// mediaInterceptors is chain of session interceptors. It is copied on change so media path reads it without lock
+type mediaInterceptors struct {
+	mu    sync.Mutex
+	chain atomic.Pointer[[]MediaInterceptor]
+}
+
+// RegisterInterceptor appends interceptor to chain. Sent packets pass interceptors in registration order
+// and received in reverse order, so first registered is closest to application and last to network.
+// Batch reads are done with single reads while interceptors are registered
+func (s *MediaSession) RegisterInterceptor(i MediaInterceptor) {
+	s.interceptors.mu.Lock()
+	defer s.interceptors.mu.Unlock()
+	chain := []MediaInterceptor{}
+	if cur := s.interceptors.chain.Load(); cur != nil {
+		chain = append(chain, *cur...)
+	}
+	chain = append(chain, i)
+	s.interceptors.chain.Store(&chain)
+}
+
+func (l *mediaInterceptors) active() bool {
+	return l.chain.Load() != nil
+}
+
+func (l *mediaInterceptors) rtp(dir TapDirection, data []byte) ([]byte, error) {
+	return l.intercept(dir, data, MediaInterceptor.InterceptRTP)
+}
+
+func (l *mediaInterceptors) rtcp(dir TapDirection, data []byte) ([]byte, error) {
+	return l.intercept(dir, data, MediaInterceptor.InterceptRTCP)
+}
+
+func (l *mediaInterceptors) intercept(dir TapDirection, data []byte, f func(MediaInterceptor, TapDirection, []byte) ([]byte, error)) ([]byte, error) {
+	cur := l.chain.Load()
+	if cur == nil {
+		return data, nil
+	}
+	chain := *cur
+	for i := range chain {
+		next := chain[i]
+		if dir == TapDirectionIn {
+			next = chain[len(chain)-1-i]
+		}
+
+		var err error
+		data, err = f(next, dir, data)
+		if err != nil || data == nil {
+			return nil, err
+		}
+	}
+	return data, nil
+}
+
+// interceptRead passes received packet in buf through chain. Modified packet is copied back to buf.
+// Zero size means packet is dropped
+func (l *mediaInterceptors) interceptRead(buf []byte, n int, f func(TapDirection, []byte) ([]byte, error)) (int, error) {
+	data, err := f(TapDirectionIn, buf[:n])
+	if err != nil || data == nil {
+		return 0, err
+	}
+	if len(data) > len(buf) {
+		return 0, io.ErrShortBuffer
+	}
+	return copy(buf, data), nil
+}
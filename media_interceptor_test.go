@@ -0,0 +1,80 @@
+package sipgox
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+	"github.com/stretchr/testify/require"
+)
+
+// trailerInterceptor appends trailer to sent packets and strips it from received, like SRTP auth tag
+func trailerInterceptor(trailer byte, order *[]string, name string) MediaInterceptor {
+	f := func(dir TapDirection, data []byte) ([]byte, error) {
+		*order = append(*order, name+" "+dir.String())
+		if dir == TapDirectionOut {
+			return append(append([]byte(nil), data...), trailer), nil
+		}
+		if len(data) == 0 || data[len(data)-1] != trailer {
+			// Not ours
+			return nil, nil
+		}
+		return data[:len(data)-1], nil
+	}
+	return MediaInterceptorFuncs{RTP: f, RTCP: f}
+}
+
+func TestMediaInterceptor(t *testing.T) {
+	sessA, err := NewMediaSession(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, nil)
+	require.NoError(t, err)
+	defer sessA.Close()
+	sessB, err := NewMediaSession(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, nil)
+	require.NoError(t, err)
+	defer sessB.Close()
+	sessA.SetRemoteAddr(sessB.Laddr)
+	sessB.SetRemoteAddr(sessA.Laddr)
+
+	order := []string{}
+	for _, s := range []*MediaSession{sessA, sessB} {
+		s.RegisterInterceptor(trailerInterceptor(1, &order, "first"))
+		s.RegisterInterceptor(trailerInterceptor(2, &order, "second"))
+	}
+
+	forkA, forkB := sessA.Fork(0), sessB.Fork(0)
+	pkt := &rtp.Packet{Header: rtp.Header{Version: 2, SequenceNumber: 1, SSRC: 1}, Payload: []byte("in")}
+	require.NoError(t, sessA.WriteRTP(pkt))
+	read, err := sessB.ReadRTP()
+	require.NoError(t, err)
+	require.Equal(t, []byte("in"), read.Payload)
+	require.Equal(t, []string{"first out", "second out", "second in", "first in"}, order)
+
+	// Forks are on application side in both directions
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	for _, fork := range []*MediaFork{forkA, forkB} {
+		p, err := fork.ReadPacket(ctx)
+		require.NoError(t, err)
+		forked, err := p.Unmarshal()
+		require.NoError(t, err)
+		require.Equal(t, []byte("in"), forked.Payload)
+	}
+
+	// Packet without trailers is dropped by receiver
+	order = order[:0]
+	_, err = sessB.rtpConn.WriteTo([]byte{0x80, 0, 0, 2, 0, 0, 0, 0, 0, 0, 0, 1}, sessA.Laddr)
+	require.NoError(t, err)
+	require.NoError(t, sessB.WriteRTP(&rtp.Packet{Header: rtp.Header{Version: 2, SequenceNumber: 3, SSRC: 1}, Payload: []byte("ok")}))
+	read, err = sessA.ReadRTP()
+	require.NoError(t, err)
+	require.Equal(t, uint16(3), read.SequenceNumber)
+
+	require.NoError(t, sessA.WriteRTCP(&rtcp.ReceiverReport{SSRC: 1}))
+	pkts := make([]rtcp.Packet, 5)
+	n, err := sessB.ReadRTCP(pkts)
+	require.NoError(t, err)
+	require.Equal(t, 1, n)
+	require.Equal(t, uint32(1), pkts[0].(*rtcp.ReceiverReport).SSRC)
+}
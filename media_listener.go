@@ -0,0 +1,60 @@
+package sipgox
+
+import (
+	"net"
+)
+
+// DefaultListenerAllocator is used by media sessions created without allocator
+var DefaultListenerAllocator ListenerAllocator = &UDPListenerAllocator{}
+
+// ListenerAllocator creates RTP and RTCP sockets for media session.
+// Implement it for pre-bound sockets, SO_REUSEPORT sharding or network namespaces
+type ListenerAllocator interface {
+	// Allocate returns RTP and RTCP connection. Port 0 in laddr means allocator chooses ports.
+	// RTP connection local address must be *net.UDPAddr
+	Allocate(laddr *net.UDPAddr) (rtpConn net.PacketConn, rtcpConn net.PacketConn, err error)
+	// Release is called when media session is closed and connections are closed
+	Release(rtpConn net.PacketConn, rtcpConn net.PacketConn)
+}
+
+// UDPListenerAllocator listens on UDP. RTCP is on RTP port + 1
+type UDPListenerAllocator struct {
+	// SocketOptions are applied on RTP and RTCP sockets
+	SocketOptions *MediaSocketOptions
+}
+
+func (a *UDPListenerAllocator) Allocate(laddr *net.UDPAddr) (net.PacketConn, net.PacketConn, error) {
+	if laddr.Port != 0 {
+		return listenRTPandRTCP(laddr, a.SocketOptions)
+	}
+
+	// Because we want to go +2 with ports in racy situations this will always fail
+	// So we need to add some control and retry if needed
+	// We are always in race with other services so only try to offset to reduce retries
+	var err error
+	var rtpConn, rtcpConn *net.UDPConn
+	for retries := 0; retries < 10; retries += 1 {
+		rtpConn, rtcpConn, err = listenRTPandRTCP(laddr, a.SocketOptions)
+		if err == nil {
+			return rtpConn, rtcpConn, nil
+		}
+	}
+	return nil, nil, err
+}
+
+func (a *UDPListenerAllocator) Release(rtpConn net.PacketConn, rtcpConn net.PacketConn) {}
+
+func listenRTPandRTCP(laddr *net.UDPAddr, opts *MediaSocketOptions) (*net.UDPConn, *net.UDPConn, error) {
+	rtpConn, err := opts.listenUDP(&net.UDPAddr{IP: laddr.IP, Port: laddr.Port})
+	if err != nil {
+		return nil, nil, err
+	}
+	laddr = rtpConn.LocalAddr().(*net.UDPAddr)
+
+	rtcpConn, err := opts.listenUDP(&net.UDPAddr{IP: laddr.IP, Port: laddr.Port + 1})
+	if err != nil {
+		rtpConn.Close()
+		return nil, nil, err
+	}
+	return rtpConn, rtcpConn, nil
+}
@@ -0,0 +1,109 @@
+package sipgox
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// RTPDelayWriter delays payloads written with Write by playout offset. It is used in
+// forwarding path to sync one stream with other, ex. delaying audio to match video.
+// Delay is applied in whole frames and can be changed at runtime: increasing it writes
+// silence until queue fills, decreasing it drops oldest queued frames.
+// WriteSamples is not delayed
+type RTPDelayWriter struct {
+	RTPStreamWriter
+
+	// FrameDuration is duration of single payload passed to Write
+	FrameDuration time.Duration
+	// Silence is sample written while delay queue fills
+	Silence byte
+
+	mu    sync.Mutex
+	delay time.Duration
+	queue [][]byte
+}
+
+var _ RTPStreamWriter = (*RTPDelayWriter)(nil)
+
+// NewRTPDelayWriter wraps writer. Silence is chosen for PCMU or PCMA payload type, and is 0 for others
+func NewRTPDelayWriter(w RTPStreamWriter, payloadType uint8, frameDuration time.Duration) *RTPDelayWriter {
+	d := &RTPDelayWriter{
+		RTPStreamWriter: w,
+		FrameDuration:   frameDuration,
+	}
+	if _, encode, ok := g711Funcs(payloadType); ok {
+		d.Silence = encode(0)
+	}
+	return d
+}
+
+// SetDelay sets playout delay. Negative delay is treated as zero
+func (d *RTPDelayWriter) SetDelay(delay time.Duration) {
+	d.mu.Lock()
+	d.delay = max(delay, 0)
+	d.mu.Unlock()
+}
+
+// Delay returns playout delay
+func (d *RTPDelayWriter) Delay() time.Duration {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.delay
+}
+
+// Write queues payload and writes payload delayed by number of frames
+func (d *RTPDelayWriter) Write(b []byte) (int, error) {
+	_, err := d.RTPStreamWriter.Write(d.next(b))
+	return len(b), err
+}
+
+// WriteCtx is Write which returns when context is canceled
+func (d *RTPDelayWriter) WriteCtx(ctx context.Context, b []byte) (int, error) {
+	_, err := d.RTPStreamWriter.WriteCtx(ctx, d.next(b))
+	return len(b), err
+}
+
+// next queues payload and returns payload to write now
+func (d *RTPDelayWriter) next(b []byte) []byte {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	frames := 0
+	if d.FrameDuration > 0 {
+		frames = int(d.delay / d.FrameDuration)
+	}
+	if frames == 0 && len(d.queue) == 0 {
+		return b
+	}
+
+	d.queue = append(d.queue, append([]byte{}, b...))
+	// Delay decreased
+	for len(d.queue) > frames+1 {
+		d.queue = d.queue[1:]
+	}
+	// Delay increased, fill with silence
+	if len(d.queue) <= frames {
+		silence := make([]byte, len(b))
+		for i := range silence {
+			silence[i] = d.Silence
+		}
+		return silence
+	}
+
+	out := d.queue[0]
+	d.queue = d.queue[1:]
+	return out
+}
+
+// SetPlayoutOffset shifts playout of stream a relative to stream b.
+// Positive offset delays a, negative offset delays b
+func SetPlayoutOffset(a *RTPDelayWriter, b *RTPDelayWriter, offset time.Duration) {
+	if offset >= 0 {
+		a.SetDelay(offset)
+		b.SetDelay(0)
+		return
+	}
+	a.SetDelay(0)
+	b.SetDelay(-offset)
+}
@@ -0,0 +1,73 @@
+package sipgox
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type frameRecorder struct {
+	frames [][]byte
+}
+
+func (w *frameRecorder) Write(b []byte) (int, error) {
+	w.frames = append(w.frames, append([]byte{}, b...))
+	return len(b), nil
+}
+
+func (w *frameRecorder) WriteCtx(ctx context.Context, b []byte) (int, error) {
+	return w.Write(b)
+}
+
+func (w *frameRecorder) WriteSamples(payload []byte, clockRateTimestamp uint32, marker bool, payloadType uint8) (int, error) {
+	return w.Write(payload)
+}
+
+func TestRTPDelayWriter(t *testing.T) {
+	rec := &frameRecorder{}
+	d := NewRTPDelayWriter(rec, 0, 20*time.Millisecond)
+	require.Equal(t, byte(0xFF), d.Silence)
+
+	write := func(v byte) {
+		n, err := d.Write([]byte{v, v})
+		require.NoError(t, err)
+		require.Equal(t, 2, n)
+	}
+	last := func() []byte { return rec.frames[len(rec.frames)-1] }
+
+	write(1)
+	require.Equal(t, []byte{1, 1}, last())
+
+	// 2 frames of delay are filled with silence
+	d.SetDelay(40 * time.Millisecond)
+	write(2)
+	require.Equal(t, []byte{0xFF, 0xFF}, last())
+	write(3)
+	require.Equal(t, []byte{0xFF, 0xFF}, last())
+	write(4)
+	require.Equal(t, []byte{2, 2}, last())
+	write(5)
+	require.Equal(t, []byte{3, 3}, last())
+
+	// Decreasing drops queued frames
+	d.SetDelay(20 * time.Millisecond)
+	write(6)
+	require.Equal(t, []byte{5, 5}, last())
+	d.SetDelay(0)
+	write(7)
+	require.Equal(t, []byte{7, 7}, last())
+
+	t.Run("PlayoutOffset", func(t *testing.T) {
+		a := NewRTPDelayWriter(&frameRecorder{}, 8, 20*time.Millisecond)
+		b := NewRTPDelayWriter(&frameRecorder{}, 8, 20*time.Millisecond)
+		SetPlayoutOffset(a, b, 60*time.Millisecond)
+		require.Equal(t, 60*time.Millisecond, a.Delay())
+		require.Zero(t, b.Delay())
+
+		SetPlayoutOffset(a, b, -40*time.Millisecond)
+		require.Zero(t, a.Delay())
+		require.Equal(t, 40*time.Millisecond, b.Delay())
+	})
+}
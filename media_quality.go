@@ -0,0 +1,184 @@
+package sipgox
+
+import (
+	"math"
+	"strings"
+	"time"
+
+	"github.com/emiago/sipgox/sdp"
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+)
+
+// QualityStats are quality of received media estimated with E-model (ITU-T G.107).
+// Loss and jitter are measured on RTP read with RTPReader, delay is derived from RTT
+type QualityStats struct {
+	// Loss is recent fraction of lost packets (0-1)
+	Loss float64
+	// Jitter is interarrival jitter as in RFC 3550
+	Jitter time.Duration
+	// Delay is estimated one way delay: half of RTT, jitter buffer and packetization
+	Delay time.Duration
+	// R is transmission rating factor (0-100)
+	R float64
+	// MOS is estimated mean opinion score (1-4.5). Zero until media is received
+	MOS float64
+}
+
+// EModelCodec is equipment impairment of codec for E-model (ITU-T G.113)
+type EModelCodec struct {
+	// Ie is impairment of codec without loss
+	Ie float64
+	// Bpl is robustness of codec to packet loss
+	Bpl float64
+}
+
+// EModelCodecs are impairments by lower case codec name. Codecs not listed use G.711 values
+var EModelCodecs = map[string]EModelCodec{
+	"pcmu": {Ie: 0, Bpl: 25.1},
+	"pcma": {Ie: 0, Bpl: 25.1},
+	"g722": {Ie: 0, Bpl: 25.1},
+	"g729": {Ie: 11, Bpl: 19},
+	"gsm":  {Ie: 20, Bpl: 10},
+	"opus": {Ie: 0, Bpl: 30},
+}
+
+// EstimateMOS calculates R factor and MOS with simplified E-model, where all impairments
+// other than delay and codec with loss are defaults. Loss is fraction (0-1)
+func EstimateMOS(c EModelCodec, loss float64, delay time.Duration) (float64, float64) {
+	d := float64(delay.Milliseconds())
+	id := 0.024 * d
+	if d > 177.3 {
+		id += 0.11 * (d - 177.3)
+	}
+	ppl := min(max(loss, 0), 1) * 100
+	ie := c.Ie + (95-c.Ie)*ppl/(ppl+c.Bpl)
+
+	r := min(max(93.2-id-ie, 0), 100)
+	return r, rFactorMOS(r)
+}
+
+// rFactorMOS converts R factor to MOS (ITU-T G.107 Annex B)
+func rFactorMOS(r float64) float64 {
+	switch {
+	case r <= 0:
+		return 1
+	case r >= 100:
+		return 4.5
+	}
+	return 1 + 0.035*r + r*(r-60)*(100-r)*7e-6
+}
+
+// qualityLossWeight is weight of packet in loss average, about 1.3s of 20ms packets
+const qualityLossWeight = 1.0 / 64
+
+// recvStats are measurements of received RTP
+type recvStats struct {
+	ssrc    uint32
+	started bool
+	lastSeq uint64
+	lastTS  uint32
+	// lastArrival is arrival of packet with lastSeq
+	lastArrival time.Time
+	jitter      float64
+	loss        float64
+	interval    time.Duration
+	codec       sdp.Codec
+}
+
+// readRTPStats updates loss and jitter with received packet. seq is extended sequence number
+func (s *MediaSession) readRTPStats(pkt *rtp.Packet, seq uint64, arrival time.Time, codec sdp.Codec) {
+	clockRate := float64(codec.ClockRate)
+	if clockRate == 0 {
+		clockRate = 8000
+	}
+
+	s.stats.mu.Lock()
+	defer s.stats.mu.Unlock()
+	st := &s.stats.recv
+	if !st.started || st.ssrc != pkt.SSRC {
+		*st = recvStats{ssrc: pkt.SSRC, started: true, lastSeq: seq, lastTS: pkt.Timestamp, lastArrival: arrival, codec: codec}
+		return
+	}
+	st.codec = codec
+	if seq <= st.lastSeq {
+		// Duplicate or late packet was already counted as lost
+		return
+	}
+
+	// RFC 3550 A.8, difference of transit times in RTP clock units
+	d := math.Abs(arrival.Sub(st.lastArrival).Seconds()*clockRate - float64(int32(pkt.Timestamp-st.lastTS)))
+	st.jitter += (d - st.jitter) / 16
+
+	// Average is close to 1 long before 256 losses
+	for lost := min(seq-st.lastSeq-1, 256); lost > 0; lost-- {
+		st.loss += (1 - st.loss) * qualityLossWeight
+	}
+	st.loss -= st.loss * qualityLossWeight
+	if seq == st.lastSeq+1 && pkt.Timestamp != st.lastTS {
+		st.interval = time.Duration(float64(pkt.Timestamp-st.lastTS) / clockRate * float64(time.Second))
+	}
+	st.lastSeq = seq
+	st.lastTS = pkt.Timestamp
+	st.lastArrival = arrival
+}
+
+// quality estimates quality of received media. Caller must hold stats lock
+func (s *MediaSession) quality() QualityStats {
+	st := s.stats.recv
+	if !st.started {
+		return QualityStats{}
+	}
+	clockRate := float64(st.codec.ClockRate)
+	if clockRate == 0 {
+		clockRate = 8000
+	}
+	q := QualityStats{
+		Loss:   st.loss,
+		Jitter: time.Duration(st.jitter / clockRate * float64(time.Second)),
+	}
+	interval := st.interval
+	if interval == 0 {
+		interval = 20 * time.Millisecond
+	}
+	// Jitter buffer is assumed to hold twice the jitter
+	q.Delay = s.stats.rtt.Current/2 + 2*q.Jitter + interval
+
+	c, ok := EModelCodecs[strings.ToLower(st.codec.Name)]
+	if !ok {
+		c = EModelCodecs["pcmu"]
+	}
+	q.R, q.MOS = EstimateMOS(c, q.Loss, q.Delay)
+	return q
+}
+
+// VoIPMetrics builds RTCP XR with VoIP metrics (RFC 3611) of received media, which remote or monitoring
+// can collect. It returns nil until media is received. RTPWriter RunReports sends it with sender reports
+func (s *MediaSession) VoIPMetrics(senderSSRC uint32) *rtcp.ExtendedReport {
+	s.stats.mu.Lock()
+	defer s.stats.mu.Unlock()
+	if !s.stats.recv.started {
+		return nil
+	}
+	q := s.quality()
+	rtt := s.stats.rtt.Current
+	block := &rtcp.VoIPMetricsReportBlock{
+		SSRC: s.stats.recv.ssrc,
+		// Loss is fraction of 256
+		LossRate:       uint8(min(q.Loss*256, 255)),
+		RoundTripDelay: uint16(min(rtt.Milliseconds(), math.MaxUint16)),
+		EndSystemDelay: uint16(min((q.Delay - rtt/2).Milliseconds(), math.MaxUint16)),
+		// 127 is unavailable
+		SignalLevel: 127,
+		NoiseLevel:  127,
+		RERL:        127,
+		Gmin:        16,
+		RFactor:     uint8(math.Round(q.R)),
+		ExtRFactor:  127,
+		// MOS is multiplied by 10
+		MOSLQ:     uint8(math.Round(q.MOS * 10)),
+		MOSCQ:     uint8(math.Round(q.MOS * 10)),
+		JBNominal: uint16(min((2 * q.Jitter).Milliseconds(), math.MaxUint16)),
+	}
+	return &rtcp.ExtendedReport{SenderSSRC: senderSSRC, Reports: []rtcp.ReportBlock{block}}
+}
@@ -0,0 +1,91 @@
+package sipgox
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/emiago/sipgox/sdp"
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEstimateMOS(t *testing.T) {
+	g711 := EModelCodecs["pcmu"]
+	r, mos := EstimateMOS(g711, 0, 20*time.Millisecond)
+	require.InDelta(t, 92.7, r, 0.1)
+	require.InDelta(t, 4.4, mos, 0.01)
+
+	_, lossy := EstimateMOS(g711, 0.05, 20*time.Millisecond)
+	require.Less(t, lossy, 4.0)
+	_, late := EstimateMOS(g711, 0, 400*time.Millisecond)
+	require.Less(t, late, 3.6)
+	_, g729 := EstimateMOS(EModelCodecs["g729"], 0, 20*time.Millisecond)
+	require.Less(t, g729, mos)
+
+	r, mos = EstimateMOS(g711, 1, time.Second)
+	require.Zero(t, r)
+	require.Equal(t, 1.0, mos)
+}
+
+func TestMediaQuality(t *testing.T) {
+	sess, err := NewMediaSession(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, nil)
+	require.NoError(t, err)
+	defer sess.Close()
+	require.Zero(t, sess.Stats().Quality)
+	require.Nil(t, sess.VoIPMetrics(1))
+
+	codec, _ := sdp.StaticCodec(sdp.FORMAT_TYPE_ULAW)
+	start := time.Now()
+	// Every 10th packet is lost and arrivals are 2ms late every other packet
+	for i := 0; i < 500; i++ {
+		if i%10 == 5 {
+			continue
+		}
+		arrival := start.Add(time.Duration(i) * 20 * time.Millisecond)
+		if i%2 == 1 {
+			arrival = arrival.Add(2 * time.Millisecond)
+		}
+		pkt := &rtp.Packet{Header: rtp.Header{SSRC: 5, SequenceNumber: uint16(i), Timestamp: uint32(i * 160)}}
+		sess.readRTPStats(pkt, uint64(i), arrival, codec)
+	}
+	sess.updateRTT(100 * time.Millisecond)
+
+	q := sess.Stats().Quality
+	require.InDelta(t, 0.1, q.Loss, 0.03)
+	require.InDelta(t, 2*time.Millisecond, q.Jitter, float64(500*time.Microsecond))
+	require.InDelta(t, 50*time.Millisecond+2*q.Jitter+20*time.Millisecond, q.Delay, float64(time.Millisecond))
+	r, mos := EstimateMOS(EModelCodecs["pcmu"], q.Loss, q.Delay)
+	require.Equal(t, r, q.R)
+	require.Equal(t, mos, q.MOS)
+	require.Less(t, q.MOS, 3.6)
+
+	xr := sess.VoIPMetrics(1)
+	require.NotNil(t, xr)
+	data, err := rtcp.Marshal([]rtcp.Packet{xr})
+	require.NoError(t, err)
+	pkts, err := rtcp.Unmarshal(data)
+	require.NoError(t, err)
+	block := pkts[0].(*rtcp.ExtendedReport).Reports[0].(*rtcp.VoIPMetricsReportBlock)
+	require.Equal(t, uint32(5), block.SSRC)
+	require.Equal(t, uint16(100), block.RoundTripDelay)
+	require.Equal(t, uint8(q.MOS*10+0.5), block.MOSCQ)
+
+	// Reports sent by writer carry metrics of received media
+	w := NewRTPWriter(sess)
+	pkts = w.reports()
+	require.Len(t, pkts, 2)
+	require.Equal(t, w.SSRC, pkts[1].(*rtcp.ExtendedReport).SenderSSRC)
+
+	// Jitter is in RTP clock units, which for G722 is 8000 while sampling is 16000
+	sess.stats.recv = recvStats{}
+	codec = sdp.Codec{PayloadType: 9, Name: "G722", ClockRate: 8000}
+	for i := 0; i < 100; i++ {
+		pkt := &rtp.Packet{Header: rtp.Header{SSRC: 6, SequenceNumber: uint16(i), Timestamp: uint32(i * 160)}}
+		sess.readRTPStats(pkt, uint64(i), start.Add(time.Duration(i)*20*time.Millisecond), codec)
+	}
+	q = sess.Stats().Quality
+	require.Zero(t, q.Jitter)
+	require.Equal(t, 20*time.Millisecond, sess.stats.recv.interval)
+}
@@ -0,0 +1,110 @@
+package sipgox
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// MediaShaperDefault when set shapes outgoing RTP of all media sessions together,
+	// ex. to protect uplink of relay. Session shaper is applied first. See SetShaper
+	MediaShaperDefault *MediaShaper
+)
+
+// MediaShaper limits outgoing RTP with token buckets on packets and bytes per second.
+// Packets over limit are dropped, as delaying realtime media only adds latency.
+// It is safe for concurrent use, so single shaper can be shared by many sessions
+type MediaShaper struct {
+	mu      sync.Mutex
+	packets tokenBucket
+	bytes   tokenBucket
+
+	dropped atomic.Uint64
+}
+
+// NewMediaShaper creates shaper. Zero rate disables that limit.
+// Burst is how much can be sent at once after idle period, and zero burst is one second of rate
+func NewMediaShaper(packetsPerSecond int, packetBurst int, bytesPerSecond int, byteBurst int) *MediaShaper {
+	return &MediaShaper{
+		packets: newTokenBucket(float64(packetsPerSecond), float64(packetBurst)),
+		bytes:   newTokenBucket(float64(bytesPerSecond), float64(byteBurst)),
+	}
+}
+
+// Allow consumes tokens for packet of size and reports whether it can be sent
+func (s *MediaShaper) Allow(size int) bool {
+	return s.allowAt(time.Now(), size)
+}
+
+func (s *MediaShaper) allowAt(now time.Time, size int) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.packets.refill(now)
+	s.bytes.refill(now)
+	// Both limits must pass before any tokens are taken
+	if !s.packets.has(1) || !s.bytes.has(float64(size)) {
+		s.dropped.Add(1)
+		return false
+	}
+	s.packets.take(1)
+	s.bytes.take(float64(size))
+	return true
+}
+
+// Dropped returns number of packets dropped by shaper
+func (s *MediaShaper) Dropped() uint64 {
+	return s.dropped.Load()
+}
+
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, burst float64) tokenBucket {
+	if burst <= 0 {
+		burst = rate
+	}
+	return tokenBucket{rate: rate, burst: burst, tokens: burst}
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	if b.rate <= 0 {
+		return
+	}
+	if !b.last.IsZero() && now.After(b.last) {
+		b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	}
+	b.last = now
+}
+
+func (b *tokenBucket) has(n float64) bool {
+	return b.rate <= 0 || b.tokens >= n
+}
+
+func (b *tokenBucket) take(n float64) {
+	if b.rate > 0 {
+		b.tokens -= n
+	}
+}
+
+// SetShaper sets shaper of outgoing RTP for this session. Shaper can be shared with other sessions.
+// Must be called before writing
+func (s *MediaSession) SetShaper(shaper *MediaShaper) {
+	s.shaper = shaper
+}
+
+// shape reports whether RTP packet of size can be sent by session and default shaper
+func (s *MediaSession) shape(size int) bool {
+	if s.shaper != nil && !s.shaper.Allow(size) {
+		return false
+	}
+	if MediaShaperDefault != nil && !MediaShaperDefault.Allow(size) {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,78 @@
+package sipgox
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMediaShaper(t *testing.T) {
+	now := time.Now()
+
+	t.Run("Packets", func(t *testing.T) {
+		s := NewMediaShaper(50, 5, 0, 0)
+		for i := 0; i < 5; i++ {
+			require.True(t, s.allowAt(now, 172))
+		}
+		require.False(t, s.allowAt(now, 172))
+
+		// 20ms refills single packet
+		require.True(t, s.allowAt(now.Add(20*time.Millisecond), 172))
+		require.False(t, s.allowAt(now.Add(20*time.Millisecond), 172))
+
+		// Idle period does not exceed burst
+		later := now.Add(10 * time.Second)
+		for i := 0; i < 5; i++ {
+			require.True(t, s.allowAt(later, 172))
+		}
+		require.False(t, s.allowAt(later, 172))
+		require.Equal(t, uint64(3), s.Dropped())
+	})
+
+	t.Run("Bytes", func(t *testing.T) {
+		s := NewMediaShaper(0, 0, 1000, 500)
+		require.True(t, s.allowAt(now, 400))
+		require.False(t, s.allowAt(now, 200))
+		// Smaller packet still fits
+		require.True(t, s.allowAt(now, 100))
+		require.True(t, s.allowAt(now.Add(200*time.Millisecond), 200))
+	})
+
+	t.Run("Both", func(t *testing.T) {
+		s := NewMediaShaper(1, 1, 1000, 0)
+		require.False(t, s.allowAt(now, 2000))
+		// Packet token was not taken by rejected packet
+		require.True(t, s.allowAt(now, 100))
+		require.False(t, s.allowAt(now, 100))
+	})
+}
+
+func TestMediaSessionShaper(t *testing.T) {
+	sess, err := NewMediaSession(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, nil)
+	require.NoError(t, err)
+	defer sess.Close()
+
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	defer conn.Close()
+	sess.SetRemoteAddr(conn.LocalAddr().(*net.UDPAddr))
+
+	shaper := NewMediaShaper(0, 0, 1000, 300)
+	sess.SetShaper(shaper)
+	for i := 0; i < 3; i++ {
+		n, err := sess.WriteRTPRaw(make([]byte, 172))
+		require.NoError(t, err)
+		require.Equal(t, 172, n)
+	}
+	require.Equal(t, uint64(2), shaper.Dropped())
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, 1500)
+	_, _, err = conn.ReadFromUDP(buf)
+	require.NoError(t, err)
+	conn.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	_, _, err = conn.ReadFromUDP(buf)
+	require.Error(t, err)
+}
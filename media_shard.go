@@ -0,0 +1,313 @@
+package sipgox
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/emiago/sipgo/sip"
+)
+
+// MediaReservation are connection details of reserved media ports on node
+type MediaReservation struct {
+	ID string
+	// Node is name of node holding reservation
+	Node string
+	// URL is base URL of node API holding reservation. It is set by MediaFarm
+	URL      string
+	IP       string
+	RTPPort  int
+	RTCPPort int
+}
+
+// MediaNodeStatus is load of media node
+type MediaNodeStatus struct {
+	Name     string
+	InUse    int
+	Capacity int
+}
+
+// MediaNode reserves ports from its pool on request of external controller.
+// Reserved sockets stay bound until they are claimed as media session or released.
+// Serve it over HTTP to allow controller (see MediaFarm) to scale out media
+type MediaNode struct {
+	Name string
+	IP   net.IP
+	// Token is bearer token required by HTTP API. All requests are rejected while it is empty
+	Token string
+	// ReserveTimeout releases reservation which is not claimed or released in time,
+	// ex. when controller failed to setup call. Default 30s
+	ReserveTimeout time.Duration
+
+	pool *PortPool
+
+	mu           sync.Mutex
+	reservations map[string]*mediaNodeReservation
+}
+
+type mediaNodeReservation struct {
+	MediaReservation
+	rtpConn  net.PacketConn
+	rtcpConn net.PacketConn
+	timer    *time.Timer
+}
+
+func NewMediaNode(name string, ip net.IP, pool *PortPool) *MediaNode {
+	return &MediaNode{
+		Name:         name,
+		IP:           ip,
+		pool:         pool,
+		reservations: make(map[string]*mediaNodeReservation),
+	}
+}
+
+// Reserve binds next free RTP/RTCP pair and returns its details
+func (n *MediaNode) Reserve() (MediaReservation, error) {
+	rtpConn, rtcpConn, err := n.pool.Allocate(&net.UDPAddr{IP: n.IP})
+	if err != nil {
+		return MediaReservation{}, err
+	}
+
+	laddr, ok := rtpConn.LocalAddr().(*net.UDPAddr)
+	if !ok {
+		rtpConn.Close()
+		rtcpConn.Close()
+		n.pool.Release(rtpConn, rtcpConn)
+		return MediaReservation{}, fmt.Errorf("reserved RTP connection is not UDP: %s", rtpConn.LocalAddr())
+	}
+	port := laddr.Port
+	r := &mediaNodeReservation{
+		MediaReservation: MediaReservation{
+			ID:       sip.RandString(16),
+			Node:     n.Name,
+			IP:       n.IP.String(),
+			RTPPort:  port,
+			RTCPPort: port + 1,
+		},
+		rtpConn:  rtpConn,
+		rtcpConn: rtcpConn,
+	}
+
+	timeout := n.ReserveTimeout
+	if timeout == 0 {
+		timeout = 30 * time.Second
+	}
+	n.mu.Lock()
+	n.reservations[r.ID] = r
+	r.timer = time.AfterFunc(timeout, func() { n.Release(r.ID) })
+	n.mu.Unlock()
+	return r.MediaReservation, nil
+}
+
+// take removes reservation so it can be released or claimed only once
+func (n *MediaNode) take(id string) (*mediaNodeReservation, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	r, exists := n.reservations[id]
+	if !exists {
+		return nil, fmt.Errorf("reservation %q not found", id)
+	}
+	delete(n.reservations, id)
+	r.timer.Stop()
+	return r, nil
+}
+
+// Release closes reserved sockets and returns ports to pool
+func (n *MediaNode) Release(id string) error {
+	r, err := n.take(id)
+	if err != nil {
+		return err
+	}
+
+	r.rtpConn.Close()
+	r.rtcpConn.Close()
+	n.pool.Release(r.rtpConn, r.rtcpConn)
+	return nil
+}
+
+// Claim creates media session on reserved sockets. Closing session returns ports to pool.
+// Reservation is released when session can not be created
+func (n *MediaNode) Claim(id string) (*MediaSession, error) {
+	r, err := n.take(id)
+	if err != nil {
+		return nil, err
+	}
+
+	alloc := &reservedAllocator{r: r, pool: n.pool}
+	sess, err := NewMediaSession(&net.UDPAddr{IP: n.IP}, alloc)
+	if err != nil {
+		alloc.close()
+		return nil, err
+	}
+	return sess, nil
+}
+
+// Status returns current load of node
+func (n *MediaNode) Status() MediaNodeStatus {
+	start, end := n.pool.Range()
+	return MediaNodeStatus{
+		Name:     n.Name,
+		InUse:    n.pool.InUse(),
+		Capacity: (end - start) / 2,
+	}
+}
+
+// ServeHTTP exposes node API. Requests must have Authorization header with bearer Token
+//
+//	POST   /reservations      reserve ports
+//	DELETE /reservations/{id} release reservation
+//	GET    /status            node load
+func (n *MediaNode) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !n.authorized(r) {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	switch {
+	case r.URL.Path == "/status" && r.Method == http.MethodGet:
+		writeJSON(w, http.StatusOK, n.Status())
+
+	case r.URL.Path == "/reservations" && r.Method == http.MethodPost:
+		res, err := n.Reserve()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		writeJSON(w, http.StatusCreated, res)
+
+	case strings.HasPrefix(r.URL.Path, "/reservations/") && r.Method == http.MethodDelete:
+		if err := n.Release(strings.TrimPrefix(r.URL.Path, "/reservations/")); err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (n *MediaNode) authorized(r *http.Request) bool {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && n.Token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(n.Token)) == 1
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+type reservedAllocator struct {
+	r    *mediaNodeReservation
+	pool *PortPool
+	// allocated is set when sockets are passed to session, which releases them on failure or Close
+	allocated bool
+}
+
+func (a *reservedAllocator) Allocate(laddr *net.UDPAddr) (net.PacketConn, net.PacketConn, error) {
+	a.allocated = true
+	return a.r.rtpConn, a.r.rtcpConn, nil
+}
+
+// close releases reserved sockets not passed to session
+func (a *reservedAllocator) close() {
+	if a.allocated {
+		return
+	}
+	a.r.rtpConn.Close()
+	a.r.rtcpConn.Close()
+	a.pool.Release(a.r.rtpConn, a.r.rtcpConn)
+}
+
+func (a *reservedAllocator) Release(rtpConn net.PacketConn, rtcpConn net.PacketConn) {
+	a.pool.Release(rtpConn, rtcpConn)
+}
+
+// MediaFarm is controller side of media nodes.
+// Reservations are spread round robin and node failing to reserve is skipped
+type MediaFarm struct {
+	// Nodes are base URLs of media nodes ex. http://10.0.0.1:8080
+	Nodes []string
+	// Token is bearer token of media node API
+	Token  string
+	Client *http.Client
+
+	next atomic.Uint32
+}
+
+var ErrMediaFarmExhausted = errors.New("no media node could reserve ports")
+
+// Reserve reserves ports on next available node
+func (f *MediaFarm) Reserve(ctx context.Context) (MediaReservation, error) {
+	var res MediaReservation
+	if len(f.Nodes) == 0 {
+		return res, ErrMediaFarmExhausted
+	}
+
+	start := int(f.next.Add(1))
+	errs := []error{ErrMediaFarmExhausted}
+	for i := 0; i < len(f.Nodes); i++ {
+		node := f.Nodes[(start+i)%len(f.Nodes)]
+		err := f.do(ctx, http.MethodPost, node+"/reservations", http.StatusCreated, &res)
+		if err == nil {
+			res.URL = node
+			return res, nil
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", node, err))
+	}
+	return res, errors.Join(errs...)
+}
+
+// Release releases reservation on node which reserved it
+func (f *MediaFarm) Release(ctx context.Context, r MediaReservation) error {
+	if r.URL == "" {
+		return fmt.Errorf("reservation %q has no node URL", r.ID)
+	}
+	return f.do(ctx, http.MethodDelete, r.URL+"/reservations/"+r.ID, http.StatusNoContent, nil)
+}
+
+// Status fetches load of node
+func (f *MediaFarm) Status(ctx context.Context, node string) (MediaNodeStatus, error) {
+	var status MediaNodeStatus
+	err := f.do(ctx, http.MethodGet, node+"/status", http.StatusOK, &status)
+	return status, err
+}
+
+func (f *MediaFarm) do(ctx context.Context, method string, url string, expected int, v any) error {
+	req, err := http.NewRequestWithContext(ctx, method, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+f.Token)
+
+	client := f.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	buf := bytes.Buffer{}
+	buf.ReadFrom(resp.Body)
+	if resp.StatusCode != expected {
+		return fmt.Errorf("media node responded %d: %s", resp.StatusCode, strings.TrimSpace(buf.String()))
+	}
+	if v == nil {
+		return nil
+	}
+	return json.Unmarshal(buf.Bytes(), v)
+}
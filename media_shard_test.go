@@ -0,0 +1,85 @@
+package sipgox
+
+import (
+	"context"
+	"net"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMediaFarm(t *testing.T) {
+	pool1, err := NewPortPool(5100, 5102)
+	require.NoError(t, err)
+	pool2, err := NewPortPool(5200, 5202)
+	require.NoError(t, err)
+
+	node1 := NewMediaNode("node1", net.IPv4(127, 0, 0, 1), pool1)
+	node2 := NewMediaNode("node2", net.IPv4(127, 0, 0, 1), pool2)
+	node1.Token, node2.Token = "secret", "secret"
+	srv1 := httptest.NewServer(node1)
+	defer srv1.Close()
+	srv2 := httptest.NewServer(node2)
+	defer srv2.Close()
+
+	ctx := context.Background()
+	// Requests without token are rejected
+	_, err = (&MediaFarm{Nodes: []string{srv1.URL}, Token: "wrong"}).Reserve(ctx)
+	require.ErrorContains(t, err, "401")
+	require.Equal(t, 0, pool1.InUse())
+
+	farm := &MediaFarm{Nodes: []string{srv1.URL, srv2.URL}, Token: "secret"}
+
+	r1, err := farm.Reserve(ctx)
+	require.NoError(t, err)
+	r2, err := farm.Reserve(ctx)
+	require.NoError(t, err)
+	require.NotEqual(t, r1.Node, r2.Node)
+	require.NotEqual(t, r1.URL, r2.URL)
+
+	// Both nodes are full
+	_, err = farm.Reserve(ctx)
+	require.ErrorIs(t, err, ErrMediaFarmExhausted)
+
+	status, err := farm.Status(ctx, srv1.URL)
+	require.NoError(t, err)
+	require.Equal(t, MediaNodeStatus{Name: "node1", InUse: 1, Capacity: 1}, status)
+
+	// Node claims reservation for call
+	id := r1.ID
+	if r2.Node == "node1" {
+		id = r2.ID
+	}
+	sess, err := node1.Claim(id)
+	require.NoError(t, err)
+	require.Equal(t, 5100, sess.Laddr.Port)
+	sess.Close()
+	require.Equal(t, 0, pool1.InUse())
+
+	r := r1
+	if r1.Node == "node1" {
+		r = r2
+	}
+	require.Equal(t, srv2.URL, r.URL)
+	require.NoError(t, farm.Release(ctx, r))
+	require.Equal(t, 0, pool2.InUse())
+	require.Error(t, farm.Release(ctx, r))
+}
+
+func TestMediaNodeReserveTimeout(t *testing.T) {
+	pool, err := NewPortPool(5300, 5302)
+	require.NoError(t, err)
+	node := NewMediaNode("node", net.IPv4(127, 0, 0, 1), pool)
+	node.ReserveTimeout = 10 * time.Millisecond
+
+	r, err := node.Reserve()
+	require.NoError(t, err)
+	require.Equal(t, 1, pool.InUse())
+
+	// Reservation not claimed in time is released
+	require.Eventually(t, func() bool { return pool.InUse() == 0 }, time.Second, 5*time.Millisecond)
+	_, err = node.Claim(r.ID)
+	require.Error(t, err)
+}
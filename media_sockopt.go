@@ -0,0 +1,59 @@
+package sipgox
+
+import (
+	"context"
+	"net"
+	"syscall"
+)
+
+// MediaSocketOptions are socket options applied on media (RTP/RTCP) listeners
+type MediaSocketOptions struct {
+	// ReadBuffer and WriteBuffer set SO_RCVBUF and SO_SNDBUF when not zero
+	ReadBuffer  int
+	WriteBuffer int
+
+	ReuseAddr bool
+	ReusePort bool
+
+	// BindToDevice binds socket to network interface (SO_BINDTODEVICE). Linux only
+	BindToDevice string
+}
+
+// listenUDP listens on laddr with options applied. Nil options is plain net.ListenUDP
+func (o *MediaSocketOptions) listenUDP(laddr *net.UDPAddr) (*net.UDPConn, error) {
+	if o == nil {
+		return net.ListenUDP("udp", laddr)
+	}
+
+	lc := net.ListenConfig{
+		Control: func(network, address string, c syscall.RawConn) error {
+			var err error
+			if cerr := c.Control(func(fd uintptr) {
+				err = o.control(fd)
+			}); cerr != nil {
+				return cerr
+			}
+			return err
+		},
+	}
+
+	pc, err := lc.ListenPacket(context.Background(), "udp", laddr.String())
+	if err != nil {
+		return nil, err
+	}
+	conn := pc.(*net.UDPConn)
+
+	if o.ReadBuffer > 0 {
+		if err := conn.SetReadBuffer(o.ReadBuffer); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	if o.WriteBuffer > 0 {
+		if err := conn.SetWriteBuffer(o.WriteBuffer); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
@@ -0,0 +1,26 @@
+//go:build linux
+
+package sipgox
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+func (o *MediaSocketOptions) control(fd uintptr) error {
+	if o.ReuseAddr {
+		if err := unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEADDR, 1); err != nil {
+			return err
+		}
+	}
+	if o.ReusePort {
+		if err := unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEPORT, 1); err != nil {
+			return err
+		}
+	}
+	if o.BindToDevice != "" {
+		if err := unix.BindToDevice(int(fd), o.BindToDevice); err != nil {
+			return err
+		}
+	}
+	return nil
+}
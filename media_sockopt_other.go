@@ -0,0 +1,14 @@
+//go:build !unix
+
+package sipgox
+
+import (
+	"fmt"
+)
+
+func (o *MediaSocketOptions) control(fd uintptr) error {
+	if o.ReuseAddr || o.ReusePort || o.BindToDevice != "" {
+		return fmt.Errorf("reuse and bind to device socket options are not supported on this platform")
+	}
+	return nil
+}
@@ -0,0 +1,142 @@
+package sipgox
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pion/rtcp"
+)
+
+// MediaStats are statistics of single call leg
+type MediaStats struct {
+	RTT RTTStats
+	// Quality of received media with MOS estimate
+	Quality QualityStats
+	// SentLoss is fraction of sent packets lost (0-1) in last report of remote
+	SentLoss float64
+}
+
+// RTTStats is round trip time calculated from RTCP reports (LSR/DLSR)
+type RTTStats struct {
+	Current time.Duration
+	Min     time.Duration
+	Max     time.Duration
+	// Samples is number of reports used for calculation
+	Samples int
+}
+
+type mediaStats struct {
+	mu sync.Mutex
+	// srSSRC are SSRCs for which we sent sender reports
+	srSSRC map[uint32]struct{}
+	rtt    RTTStats
+	recv   recvStats
+	// sentLoss is fraction lost of last remote report about our stream
+	sentLoss float64
+}
+
+// Stats returns current statistics of media session
+func (s *MediaSession) Stats() MediaStats {
+	s.stats.mu.Lock()
+	defer s.stats.mu.Unlock()
+	return MediaStats{
+		RTT:      s.stats.rtt,
+		Quality:  s.quality(),
+		SentLoss: s.stats.sentLoss,
+	}
+}
+
+// RTT returns last calculated round trip time. It is 0 until first report with our LSR is received
+func (s *MediaSession) RTT() time.Duration {
+	return s.Stats().RTT.Current
+}
+
+func (s *MediaSession) sentRTCPStats(pkts []rtcp.Packet) {
+	for _, p := range pkts {
+		sr, ok := p.(*rtcp.SenderReport)
+		if !ok {
+			continue
+		}
+		s.stats.mu.Lock()
+		if s.stats.srSSRC == nil {
+			s.stats.srSSRC = make(map[uint32]struct{})
+		}
+		s.stats.srSSRC[sr.SSRC] = struct{}{}
+		s.stats.mu.Unlock()
+	}
+}
+
+func (s *MediaSession) readRTCPStats(pkts []rtcp.Packet, arrival time.Time) {
+	for _, p := range pkts {
+		var reports []rtcp.ReceptionReport
+		switch r := p.(type) {
+		case *rtcp.SenderReport:
+			reports = r.Reports
+		case *rtcp.ReceiverReport:
+			reports = r.Reports
+		default:
+			continue
+		}
+
+		for _, rr := range reports {
+			s.stats.mu.Lock()
+			_, ours := s.stats.srSSRC[rr.SSRC]
+			if ours {
+				s.stats.sentLoss = float64(rr.FractionLost) / 256
+			}
+			s.stats.mu.Unlock()
+			if !ours {
+				continue
+			}
+
+			rtt, ok := rttFromReport(arrival, rr.LastSenderReport, rr.Delay)
+			if !ok {
+				continue
+			}
+			s.updateRTT(rtt)
+		}
+	}
+}
+
+func (s *MediaSession) updateRTT(rtt time.Duration) {
+	s.stats.mu.Lock()
+	defer s.stats.mu.Unlock()
+	st := &s.stats.rtt
+	st.Current = rtt
+	if st.Samples == 0 || rtt < st.Min {
+		st.Min = rtt
+	}
+	if rtt > st.Max {
+		st.Max = rtt
+	}
+	st.Samples++
+}
+
+// rttFromReport calculates RTT as described in RFC 3550 6.4.1
+// All values are middle 32 bits of NTP timestamp, in units of 1/65536 seconds
+func rttFromReport(arrival time.Time, lsr uint32, dlsr uint32) (time.Duration, bool) {
+	if lsr == 0 {
+		// No sender report received by remote yet
+		return 0, false
+	}
+
+	a := ntpMiddle32(arrival)
+	rtt := int32(a - lsr - dlsr)
+	if rtt < 0 {
+		return 0, false
+	}
+	return time.Duration(rtt) * time.Second / 65536, true
+}
+
+// ntpTime converts time to 64 bit NTP timestamp
+func ntpTime(t time.Time) uint64 {
+	// NTP epoch is 1900, unix is 1970
+	const ntpEpochOffset = 2208988800
+	secs := uint64(t.Unix() + ntpEpochOffset)
+	frac := uint64(t.Nanosecond()) << 32 / uint64(time.Second)
+	return secs<<32 | frac
+}
+
+func ntpMiddle32(t time.Time) uint32 {
+	return uint32(ntpTime(t) >> 16)
+}
@@ -1,6 +1,7 @@
 package sipgox
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"net"
@@ -10,18 +11,25 @@ import (
 	"github.com/rs/zerolog"
 )
 
-// type RTPReader interface {
-// 	ReadRTP() (rtp.Packet, error)
-// }
+// RTPStreamReader reads media payload from RTP stream. It is implemented by RTPReader.
+// Accept it instead of *RTPReader so that decorated readers (metrics, taps, decryption,
+// jitter buffer) can be passed interchangeably
+type RTPStreamReader interface {
+	Read(b []byte) (int, error)
+	ReadCtx(ctx context.Context, b []byte) (int, error)
+}
 
-// type RTPWriter interface {
-// 	WriteRTP(p rtp.Packet) error
-// }
+// RTPStreamWriter writes media payload as RTP stream. It is implemented by RTPWriter
+type RTPStreamWriter interface {
+	Write(b []byte) (int, error)
+	WriteCtx(ctx context.Context, b []byte) (int, error)
+	WriteSamples(payload []byte, clockRateTimestamp uint32, marker bool, payloadType uint8) (int, error)
+}
 
-// type RTPReaderWritter interface {
-// 	RTPReader
-// 	RTPWriter
-// }
+var (
+	_ RTPStreamReader = (*RTPReader)(nil)
+	_ RTPStreamWriter = (*RTPWriter)(nil)
+)
 
 type MediaStreamer interface {
 	MediaStream(s *MediaSession) error
@@ -46,7 +54,7 @@ func MediaStreamLogger(log zerolog.Logger) MediaStreamer {
 		// Just reader
 		defer s.Close()
 
-		go func() {
+		s.goEngine(func() {
 			log := log.With().Str("caller", "RTCP recv").Logger()
 			pkts := make([]rtcp.Packet, 5)
 			for {
@@ -65,7 +73,7 @@ func MediaStreamLogger(log zerolog.Logger) MediaStreamer {
 						Msg("RTCP packet received")
 				}
 			}
-		}()
+		})
 
 		lastSummaryTime := time.Now()
 		packetsCount := 0
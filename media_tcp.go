@@ -0,0 +1,317 @@
+package sipgox
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/emiago/sipgox/sdp"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// SDPProtoTCP is media transport for RTP over TCP (RFC 4571)
+	SDPProtoTCP = "TCP/RTP/AVP"
+
+	// TCP setup roles (RFC 4145)
+	tcpSetupActive  = string(sdp.SetupActive)
+	tcpSetupPassive = string(sdp.SetupPassive)
+	tcpSetupActpass = string(sdp.SetupActpass)
+)
+
+// MediaTCPDialTimeout is timeout for connecting active TCP media
+var MediaTCPDialTimeout = 5 * time.Second
+
+// NewMediaSessionTCP creates media session carrying RTP and RTCP over TCP with RFC 4571 framing.
+// Use it as fallback when UDP is blocked. It listens on laddr for RTP and next port for RTCP.
+// Local SDP offers TCP/RTP/AVP with actpass setup, and role is decided when remote SDP is applied.
+// Reading and writing is same as with UDP session. Writes before connection is established are dropped
+func NewMediaSessionTCP(laddr *net.UDPAddr) (*MediaSession, error) {
+	rtpConn, err := listenTCPMedia(&net.TCPAddr{IP: laddr.IP, Port: laddr.Port})
+	if err != nil {
+		return nil, err
+	}
+	addr := rtpConn.ln.Addr().(*net.TCPAddr)
+
+	rtcpConn, err := listenTCPMedia(&net.TCPAddr{IP: addr.IP, Port: addr.Port + 1})
+	if err != nil {
+		rtpConn.Close()
+		return nil, err
+	}
+
+	s := &MediaSession{
+		Formats: sdp.Formats{
+			sdp.FORMAT_TYPE_ULAW, sdp.FORMAT_TYPE_ALAW,
+		},
+		Laddr:    &net.UDPAddr{IP: addr.IP, Port: addr.Port},
+		Mode:     sdp.ModeSendrecv,
+		rtpConn:  rtpConn,
+		rtcpConn: rtcpConn,
+		tcpSetup: tcpSetupActpass,
+		log:      log.With().Str("caller", "media").Logger(),
+	}
+	MediaEngineDefault.Add(s)
+	s.goEngine(func() { s.acceptTCP(rtpConn) })
+	s.goEngine(func() { s.acceptTCP(rtcpConn) })
+	return s, nil
+}
+
+// tcpSDP changes media transport of generated SDP to TCP and adds setup attributes
+func tcpSDP(body []byte, setup string) []byte {
+	lines := strings.Split(strings.TrimRight(string(body), "\r\n"), "\r\n")
+	for i, l := range lines {
+		if strings.HasPrefix(l, "m=") {
+			lines[i] = strings.Replace(l, " RTP/AVP ", " "+SDPProtoTCP+" ", 1)
+		}
+	}
+	lines = append(lines, "a=setup:"+setup, "a=connection:new")
+	return []byte(strings.Join(lines, "\r\n") + "\r\n")
+}
+
+// connectTCP decides TCP role from remote SDP and connects if we are active
+func (s *MediaSession) connectTCP(sd sdp.SessionDescription, md sdp.MediaDescription, raddr *net.UDPAddr) error {
+	if md.Proto != SDPProtoTCP {
+		return fmt.Errorf("remote media transport %q is not %s", md.Proto, SDPProtoTCP)
+	}
+
+	remoteSetup := tcpSetupActive
+	if v, ok := sd.Setup(md.MediaType); ok {
+		remoteSetup = string(v)
+	}
+
+	switch remoteSetup {
+	case tcpSetupActive:
+		// Remote connects to our listeners
+		s.tcpSetup = tcpSetupPassive
+		return nil
+	case tcpSetupPassive, tcpSetupActpass:
+		s.tcpSetup = tcpSetupActive
+	default:
+		return fmt.Errorf("unsupported tcp setup %q", remoteSetup)
+	}
+
+	rtpConn := s.rtpConn.(*tcpMediaConn)
+	rtcpConn := s.rtcpConn.(*tcpMediaConn)
+	for i, c := range []*tcpMediaConn{rtpConn, rtcpConn} {
+		addr := &net.TCPAddr{IP: raddr.IP, Port: raddr.Port + i}
+		go func(c *tcpMediaConn) {
+			if err := c.dial(addr); err != nil {
+				log.Error().Err(err).Str("raddr", addr.String()).Msg("Fail to connect TCP media")
+				s.reportError(BackgroundTCPConnect, addr.String(), err)
+			}
+		}(c)
+	}
+	return nil
+}
+
+// tcpMediaConn is packet connection over single TCP connection with RFC 4571 framing.
+// It accepts first connection on listener or dials remote, whichever comes first
+type tcpMediaConn struct {
+	ln net.Listener
+
+	mu     sync.Mutex
+	conn   net.Conn
+	reader *bufio.Reader
+	ready  chan struct{}
+	closed chan struct{}
+	once   sync.Once
+
+	deadlineMu   sync.Mutex
+	readDeadline time.Time
+
+	writeMu sync.Mutex
+}
+
+func listenTCPMedia(laddr *net.TCPAddr) (*tcpMediaConn, error) {
+	ln, err := net.ListenTCP("tcp", laddr)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &tcpMediaConn{
+		ln:     ln,
+		ready:  make(chan struct{}),
+		closed: make(chan struct{}),
+	}
+	return c, nil
+}
+
+// accept waits single passive connection. Error after Close is not returned
+func (c *tcpMediaConn) accept() error {
+	conn, err := c.ln.Accept()
+	if err != nil {
+		select {
+		case <-c.closed:
+			return nil
+		default:
+		}
+		return err
+	}
+	c.setConn(conn)
+	return nil
+}
+
+// acceptTCP accepts passive connection of conn and reports failure
+func (s *MediaSession) acceptTCP(c *tcpMediaConn) {
+	if err := c.accept(); err != nil {
+		s.log.Error().Err(err).Str("laddr", c.LocalAddr().String()).Msg("Fail to accept TCP media")
+		s.reportError(BackgroundTCPAccept, c.LocalAddr().String(), err)
+	}
+}
+
+func (c *tcpMediaConn) dial(raddr *net.TCPAddr) error {
+	conn, err := net.DialTimeout("tcp", raddr.String(), MediaTCPDialTimeout)
+	if err != nil {
+		return err
+	}
+	c.setConn(conn)
+	return nil
+}
+
+func (c *tcpMediaConn) setConn(conn net.Conn) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	select {
+	case <-c.ready:
+		// Already connected
+		conn.Close()
+		return
+	case <-c.closed:
+		conn.Close()
+		return
+	default:
+	}
+
+	c.conn = conn
+	c.reader = bufio.NewReader(conn)
+	c.deadlineMu.Lock()
+	conn.SetReadDeadline(c.readDeadline)
+	close(c.ready)
+	c.deadlineMu.Unlock()
+	// Only one connection is used
+	c.ln.Close()
+}
+
+// waitConn waits connection respecting read deadline
+func (c *tcpMediaConn) waitConn() (net.Conn, *bufio.Reader, error) {
+	c.deadlineMu.Lock()
+	deadline := c.readDeadline
+	c.deadlineMu.Unlock()
+
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		t := time.NewTimer(time.Until(deadline))
+		defer t.Stop()
+		timeout = t.C
+	}
+
+	select {
+	case <-c.ready:
+		return c.conn, c.reader, nil
+	case <-c.closed:
+		return nil, nil, net.ErrClosed
+	case <-timeout:
+		return nil, nil, os.ErrDeadlineExceeded
+	}
+}
+
+func (c *tcpMediaConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	conn, r, err := c.waitConn()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	var hdr [2]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return 0, nil, err
+	}
+	size := int(binary.BigEndian.Uint16(hdr[:]))
+	if size > len(b) {
+		// Frame must be consumed to keep stream in sync
+		r.Discard(size)
+		return 0, nil, io.ErrShortBuffer
+	}
+
+	n, err := io.ReadFull(r, b[:size])
+	return n, conn.RemoteAddr(), err
+}
+
+func (c *tcpMediaConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	select {
+	case <-c.ready:
+	case <-c.closed:
+		return 0, net.ErrClosed
+	default:
+		// Like on UDP, packets before connection are lost
+		return len(b), nil
+	}
+
+	if len(b) > 0xFFFF {
+		return 0, errors.New("rtp packet too large for tcp framing")
+	}
+
+	frame := make([]byte, 2+len(b))
+	binary.BigEndian.PutUint16(frame, uint16(len(b)))
+	copy(frame[2:], b)
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if _, err := c.conn.Write(frame); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (c *tcpMediaConn) Close() error {
+	c.once.Do(func() {
+		c.mu.Lock()
+		close(c.closed)
+		c.mu.Unlock()
+	})
+
+	c.ln.Close()
+	select {
+	case <-c.ready:
+		return c.conn.Close()
+	default:
+	}
+	return nil
+}
+
+func (c *tcpMediaConn) LocalAddr() net.Addr {
+	return c.ln.Addr()
+}
+
+func (c *tcpMediaConn) SetDeadline(t time.Time) error {
+	c.SetWriteDeadline(t)
+	return c.SetReadDeadline(t)
+}
+
+func (c *tcpMediaConn) SetReadDeadline(t time.Time) error {
+	c.deadlineMu.Lock()
+	defer c.deadlineMu.Unlock()
+	c.readDeadline = t
+
+	select {
+	case <-c.ready:
+		return c.conn.SetReadDeadline(t)
+	default:
+	}
+	return nil
+}
+
+func (c *tcpMediaConn) SetWriteDeadline(t time.Time) error {
+	select {
+	case <-c.ready:
+		return c.conn.SetWriteDeadline(t)
+	default:
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package sipgox
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMediaSessionTCP(t *testing.T) {
+	offerer, err := NewMediaSessionTCP(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	defer offerer.Close()
+
+	answerer, err := NewMediaSessionTCP(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	defer answerer.Close()
+
+	offer := offerer.LocalSDP()
+	require.Contains(t, string(offer), "TCP/RTP/AVP 0 8 101")
+	require.Contains(t, string(offer), "a=setup:actpass")
+
+	require.NoError(t, answerer.RemoteSDP(offer))
+	answer := answerer.LocalSDP()
+	require.Contains(t, string(answer), "a=setup:active")
+	require.NoError(t, offerer.RemoteSDP(answer))
+
+	// Answerer connects, writes before connection are dropped, so wait for it
+	w := NewRTPWriter(answerer)
+	deadline := time.Now().Add(time.Second)
+	var pkt rtp.Packet
+	for {
+		_, err = w.Write([]byte{1, 2, 3})
+		require.NoError(t, err)
+
+		buf := make([]byte, 1500)
+		n, err := offerer.ReadRTPRawDeadline(buf, time.Now().Add(20*time.Millisecond))
+		if err == nil {
+			require.NoError(t, pkt.Unmarshal(buf[:n]))
+			break
+		}
+		require.True(t, time.Now().Before(deadline), "no RTP received over TCP")
+	}
+	require.Equal(t, []byte{1, 2, 3}, pkt.Payload)
+
+	// Reverse direction and RTCP on second connection
+	_, err = NewRTPWriter(offerer).WriteSamples([]byte{4}, 160, false, 0)
+	require.NoError(t, err)
+	pkt, err = answerer.ReadRTP()
+	require.NoError(t, err)
+	require.Equal(t, []byte{4}, pkt.Payload)
+
+	require.NoError(t, offerer.WriteRTCP(&rtcp.ReceiverReport{SSRC: 5}))
+	pkts := make([]rtcp.Packet, 2)
+	n, err := answerer.ReadRTCPDeadline(pkts, time.Now().Add(time.Second))
+	require.NoError(t, err)
+	require.Equal(t, 1, n)
+	require.Equal(t, uint32(5), pkts[0].(*rtcp.ReceiverReport).SSRC)
+}
+
+func TestMediaSessionTCPRejectsUDP(t *testing.T) {
+	sess, err := NewMediaSessionTCP(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	defer sess.Close()
+
+	udp, err := NewMediaSession(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, nil)
+	require.NoError(t, err)
+	defer udp.Close()
+
+	require.Error(t, sess.RemoteSDP(udp.LocalSDP()))
+}
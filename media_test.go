@@ -1,32 +1,114 @@
 package sipgox
 
 import (
+	"context"
+	"fmt"
 	"io"
 	"net"
+	"runtime"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/emiago/sipgo/fakes"
+	"github.com/emiago/sipgox/sdp"
 	"github.com/pion/rtcp"
 	"github.com/stretchr/testify/require"
 )
 
 func TestMediaPortRange(t *testing.T) {
-	RTPPortStart = 5000
-	RTPPortEnd = 5010
+	pool, err := NewPortPool(5000, 5010)
+	require.NoError(t, err)
 
 	sessions := []*MediaSession{}
-	for i := RTPPortStart; i < RTPPortEnd; i += 2 {
-		require.Equal(t, i-RTPPortStart, int(rtpPortOffset.Load()))
-		mess, err := NewMediaSession(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 0})
-		t.Log(mess.rtpConn.LocalAddr(), mess.rtcpConn.LocalAddr())
+	for i := 5000; i < 5010; i += 2 {
+		mess, err := NewMediaSession(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 0}, pool)
 		require.NoError(t, err)
+		t.Log(mess.rtpConn.LocalAddr(), mess.rtcpConn.LocalAddr())
+		require.Equal(t, i, mess.Laddr.Port)
 		sessions = append(sessions, mess)
 	}
+	require.Equal(t, 5, pool.InUse())
+
+	// Range exhausted
+	_, err = NewMediaSession(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 0}, pool)
+	require.Error(t, err)
+
+	// Released port is reused
+	sessions[1].Close()
+	mess, err := NewMediaSession(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 0}, pool)
+	require.NoError(t, err)
+	require.Equal(t, 5002, mess.Laddr.Port)
+	mess.Close()
 
 	for _, s := range sessions {
 		s.Close()
 	}
+	require.Equal(t, 0, pool.InUse())
+}
+
+type preboundAllocator struct {
+	rtp, rtcp net.PacketConn
+	released  bool
+}
+
+func (a *preboundAllocator) Allocate(laddr *net.UDPAddr) (net.PacketConn, net.PacketConn, error) {
+	return a.rtp, a.rtcp, nil
+}
+
+func (a *preboundAllocator) Release(rtpConn net.PacketConn, rtcpConn net.PacketConn) {
+	a.released = true
+}
+
+func TestMediaListenerAllocator(t *testing.T) {
+	rtpConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	rtcpConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+
+	alloc := &preboundAllocator{rtp: rtpConn, rtcp: rtcpConn}
+	sess, err := NewMediaSession(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, alloc)
+	require.NoError(t, err)
+	require.Equal(t, rtpConn.LocalAddr().String(), sess.Laddr.String())
+
+	sess.Close()
+	require.True(t, alloc.released)
+}
+
+func TestMediaSocketOptions(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("reuse port not supported")
+	}
+
+	alloc := &UDPListenerAllocator{SocketOptions: &MediaSocketOptions{
+		ReadBuffer: 1 << 20,
+		ReusePort:  true,
+	}}
+	sess1, err := NewMediaSession(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, alloc)
+	require.NoError(t, err)
+	defer sess1.Close()
+
+	// Same ports can be bound again with reuse port
+	sess2, err := NewMediaSession(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: sess1.Laddr.Port}, alloc)
+	require.NoError(t, err)
+	defer sess2.Close()
+
+	_, err = NewMediaSession(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: sess1.Laddr.Port}, nil)
+	require.Error(t, err)
+}
+
+func TestMediaAdvertisedAddr(t *testing.T) {
+	sess, err := NewMediaSession(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, nil)
+	require.NoError(t, err)
+	defer sess.Close()
+
+	sess.AdvertisedAddr = &net.UDPAddr{IP: net.IPv4(203, 0, 113, 10)}
+	sd := string(sess.LocalSDP())
+	require.Contains(t, sd, "c=IN IP4 203.0.113.10")
+	require.Contains(t, sd, fmt.Sprintf("m=audio %d ", sess.Laddr.Port))
 
+	sess.AdvertisedAddr.Port = 40000
+	require.Contains(t, string(sess.LocalSDP()), "m=audio 40000 ")
 }
 
 func TestDTMFEncodeDecode(t *testing.T) {
@@ -89,3 +171,674 @@ func TestReadRTCP(t *testing.T) {
 	require.IsType(t, &rtcp.ReceiverReport{}, pkts[1])
 
 }
+
+func TestReadRTPRawBatch(t *testing.T) {
+	sess, err := NewMediaSession(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 0}, nil)
+	require.NoError(t, err)
+	defer sess.Close()
+
+	conn, err := net.DialUDP("udp", nil, sess.Laddr)
+	require.NoError(t, err)
+	defer conn.Close()
+	fork := sess.Fork(0)
+
+	for i := 0; i < 3; i++ {
+		_, err := conn.Write([]byte{byte(i), byte(i), byte(i)})
+		require.NoError(t, err)
+	}
+
+	bufs := make([][]byte, 8)
+	for i := range bufs {
+		bufs[i] = make([]byte, 1500)
+	}
+	ns := make([]int, len(bufs))
+
+	read := 0
+	for read < 3 {
+		sess.rtpConn.SetReadDeadline(time.Now().Add(time.Second))
+		n, err := sess.ReadRTPRawBatch(bufs[read:], ns[read:])
+		require.NoError(t, err)
+		read += n
+	}
+
+	for i := 0; i < 3; i++ {
+		require.Equal(t, []byte{byte(i), byte(i), byte(i)}, bufs[i][:ns[i]])
+	}
+
+	// Batch reads are seen by forks like single reads
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	for i := 0; i < 3; i++ {
+		p, err := fork.ReadPacket(ctx)
+		require.NoError(t, err)
+		require.Equal(t, []byte{byte(i), byte(i), byte(i)}, p.Data)
+	}
+}
+
+func TestMediaSessionBufferPool(t *testing.T) {
+	session := &MediaSession{}
+	require.Equal(t, DefaultBufferPool, session.bufferPool())
+
+	pool := NewBufferPool(9000)
+	session.SetBufferPool(pool)
+	require.Len(t, session.bufferPool().Get(), 9000)
+
+	// Foreign buffers are not pooled
+	pool.Put(make([]byte, 10))
+	require.Len(t, pool.Get(), 9000)
+}
+
+func TestMediaStatsRTT(t *testing.T) {
+	sess, err := NewMediaSession(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, nil)
+	require.NoError(t, err)
+	defer sess.Close()
+
+	remote, err := NewMediaSession(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, nil)
+	require.NoError(t, err)
+	defer remote.Close()
+
+	sess.SetRemoteAddr(remote.Laddr)
+	remote.SetRemoteAddr(sess.Laddr)
+
+	w := NewRTPWriter(sess)
+	_, err = w.Write(make([]byte, 160))
+	require.NoError(t, err)
+
+	sr := w.SenderReport()
+	require.Equal(t, uint32(1), sr.PacketCount)
+	require.Equal(t, uint32(160), sr.OctetCount)
+	require.NoError(t, sess.WriteRTCP(sr))
+
+	// Remote holds report for 100ms before answering
+	pkts := make([]rtcp.Packet, 5)
+	_, err = remote.ReadRTCPDeadline(pkts, time.Now().Add(time.Second))
+	require.NoError(t, err)
+	received := pkts[0].(*rtcp.SenderReport)
+	rr := &rtcp.ReceiverReport{
+		SSRC: 1234,
+		Reports: []rtcp.ReceptionReport{{
+			SSRC:             w.SSRC,
+			LastSenderReport: uint32(received.NTPTime >> 16),
+			Delay:            uint32(100 * time.Millisecond * 65536 / time.Second),
+		}},
+	}
+	time.Sleep(100 * time.Millisecond)
+	require.NoError(t, remote.WriteRTCP(rr))
+
+	_, err = sess.ReadRTCPDeadline(pkts, time.Now().Add(time.Second))
+	require.NoError(t, err)
+
+	stats := sess.Stats().RTT
+	require.Equal(t, 1, stats.Samples)
+	require.Less(t, stats.Current, 50*time.Millisecond)
+	require.Equal(t, stats.Current, stats.Min)
+	require.Equal(t, stats.Current, stats.Max)
+
+	// Reports about other sources are ignored
+	rr.Reports[0].SSRC = w.SSRC + 1
+	require.NoError(t, remote.WriteRTCP(rr))
+	_, err = sess.ReadRTCPDeadline(pkts, time.Now().Add(time.Second))
+	require.NoError(t, err)
+	require.Equal(t, 1, sess.Stats().RTT.Samples)
+}
+
+func TestRTTFromReport(t *testing.T) {
+	now := time.Now()
+	lsr := ntpMiddle32(now.Add(-300 * time.Millisecond))
+	dlsr := uint32(100 * time.Millisecond * 65536 / time.Second)
+
+	rtt, ok := rttFromReport(now, lsr, dlsr)
+	require.True(t, ok)
+	require.InDelta(t, 200*time.Millisecond, rtt, float64(time.Millisecond))
+
+	_, ok = rttFromReport(now, 0, 0)
+	require.False(t, ok)
+
+	// Delay larger than elapsed time is invalid
+	_, ok = rttFromReport(now, lsr, dlsr*4)
+	require.False(t, ok)
+}
+
+func TestMediaRenegotiate(t *testing.T) {
+	sess, err := NewMediaSession(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, nil)
+	require.NoError(t, err)
+	defer sess.Close()
+
+	remote1, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	defer remote1.Close()
+	remote2, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	defer remote2.Close()
+
+	ip := net.IPv4(127, 0, 0, 1)
+	require.NoError(t, sess.RemoteSDP(sdp.GenerateForAudio(ip, ip, remote1.LocalAddr().(*net.UDPAddr).Port, sdp.ModeSendrecv, sdp.NewFormats("0", "8"))))
+
+	// Writer keeps running while session is renegotiated
+	done := make(chan struct{})
+	writerDone := make(chan struct{})
+	w := NewRTPWriter(sess)
+	go func() {
+		defer close(writerDone)
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			w.Write(make([]byte, 160))
+			// Negotiated formats and DTMF are read by media path during renegotiation
+			NewRTPReader(sess)
+			sess.recvDTMFPayloadType()
+			time.Sleep(time.Millisecond)
+		}
+	}()
+	defer func() {
+		close(done)
+		<-writerDone
+	}()
+
+	// Remote moves media and puts call on hold
+	offer := sdp.GenerateForAudio(ip, ip, remote2.LocalAddr().(*net.UDPAddr).Port, sdp.ModeSendonly, sdp.NewFormats("8"))
+	answer, err := sess.Renegotiate(offer)
+	require.NoError(t, err)
+	require.Contains(t, string(answer), "a=recvonly")
+	require.Contains(t, string(answer), fmt.Sprintf("m=audio %d RTP/AVP 8 101", sess.Laddr.Port))
+	require.Equal(t, sdp.Formats{"8"}, sess.Formats)
+	require.Equal(t, sdp.ModeRecvonly, sess.Mode)
+
+	// Resume
+	offer = sdp.GenerateForAudio(ip, ip, remote2.LocalAddr().(*net.UDPAddr).Port, sdp.ModeSendrecv, sdp.NewFormats("8"))
+	answer, err = sess.Renegotiate(offer)
+	require.NoError(t, err)
+	require.Contains(t, string(answer), "a=sendrecv")
+
+	remote2.SetReadDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, 1500)
+	_, err = remote2.Read(buf)
+	require.NoError(t, err)
+
+	// No common format leaves session unchanged
+	_, err = sess.Renegotiate(sdp.GenerateForAudio(ip, ip, 1000, sdp.ModeSendrecv, sdp.NewFormats("9")))
+	require.ErrorIs(t, err, sdp.ErrNoCommonFormat)
+	require.Equal(t, remote2.LocalAddr().String(), sess.Raddr.String())
+}
+
+func TestMediaHold(t *testing.T) {
+	sess, err := NewMediaSession(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, nil)
+	require.NoError(t, err)
+	defer sess.Close()
+
+	remote, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	defer remote.Close()
+	sess.SetRemoteAddr(remote.LocalAddr().(*net.UDPAddr))
+
+	offer := sess.HoldOffer(sdp.ModeInactive)
+	require.Contains(t, string(offer), "a=inactive")
+	require.True(t, sess.OnHold())
+
+	// Output is paused but media clock runs
+	w := NewRTPWriter(sess)
+	ts := w.nextTimestamp
+	n, err := w.Write(make([]byte, 160))
+	require.NoError(t, err)
+	require.Equal(t, 160, n)
+	require.Equal(t, ts+160, w.nextTimestamp)
+
+	remote.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	_, err = remote.Read(make([]byte, 1500))
+	require.Error(t, err)
+
+	offer = sess.ResumeOffer()
+	require.Contains(t, string(offer), "a=sendrecv")
+	require.False(t, sess.OnHold())
+	_, err = w.Write(make([]byte, 160))
+	require.NoError(t, err)
+	remote.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = remote.Read(make([]byte, 1500))
+	require.NoError(t, err)
+
+	// Remote old style hold keeps remote address
+	ip := net.IPv4(127, 0, 0, 1)
+	holdOffer := sdp.GenerateForAudio(ip, net.IPv4zero, 2000, sdp.ModeSendrecv, sdp.NewFormats("0"))
+	_, err = sess.Renegotiate(holdOffer)
+	require.NoError(t, err)
+	require.True(t, sess.OnHold())
+	require.Equal(t, remote.LocalAddr().String(), sess.Raddr.String())
+}
+
+func TestMediaCodecs(t *testing.T) {
+	sess, err := NewMediaSession(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, nil)
+	require.NoError(t, err)
+	defer sess.Close()
+
+	ip := net.IPv4(127, 0, 0, 1)
+	require.NoError(t, sess.RemoteSDP(sdp.GenerateForAudio(ip, ip, 2000, sdp.ModeSendrecv, sdp.NewFormats("8", "9"))))
+	require.Len(t, sess.Codecs, 1)
+	require.Equal(t, "PCMA/8000", sess.Codecs[0].String())
+}
+
+func TestMediaDTMFPayloadType(t *testing.T) {
+	sessA, err := NewMediaSession(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, nil)
+	require.NoError(t, err)
+	defer sessA.Close()
+	sessB, err := NewMediaSession(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, nil)
+	require.NoError(t, err)
+	defer sessB.Close()
+
+	sessA.DTMFPayloadType = 100
+	require.NoError(t, sessB.RemoteSDP(sessA.LocalSDP()))
+	require.Equal(t, uint8(100), sessB.DTMFPayloadType)
+	require.Contains(t, string(sessB.LocalSDP()), "a=rtpmap:100 telephone-event/8000")
+	require.NoError(t, sessA.RemoteSDP(sessB.LocalSDP()))
+
+	digits := []rune{}
+	reader := NewRTPReader(sessB)
+	reader.OnDTMF = func(digit rune) {
+		digits = append(digits, digit)
+	}
+
+	writer := NewRTPWriter(sessA)
+	require.NoError(t, writer.WriteDTMF('5'))
+	require.Error(t, writer.WriteDTMF('x'))
+	_, err = writer.Write([]byte{1, 2, 3})
+	require.NoError(t, err)
+
+	// DTMF packets are consumed and audio is returned
+	sessB.rtpConn.SetReadDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, 1500)
+	n, err := reader.Read(buf)
+	require.NoError(t, err)
+	require.Equal(t, []byte{1, 2, 3}, buf[:n])
+	require.Equal(t, []rune{'5'}, digits)
+}
+
+func TestMediaDynamicPayloadType(t *testing.T) {
+	sess, err := NewMediaSession(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, nil)
+	require.NoError(t, err)
+	defer sess.Close()
+
+	sess.Registry = sdp.NewCodecRegistry()
+	require.NoError(t, sess.Registry.Register(sdp.Codec{PayloadType: 96, Name: "opus", ClockRate: 48000, Channels: 2}))
+	sess.Formats = sdp.NewFormats("96", sdp.FORMAT_TYPE_ULAW)
+	require.Contains(t, string(sess.LocalSDP()), "a=rtpmap:96 opus/48000/2")
+
+	// Remote uses different number for same codec
+	ip := net.IPv4(127, 0, 0, 1)
+	offer := sdp.AppendCodecs(
+		sdp.GenerateForAudio(ip, ip, 2000, sdp.ModeSendrecv, sdp.NewFormats("111", "0")),
+		[]sdp.Codec{{PayloadType: 111, Name: "opus", ClockRate: 48000, Channels: 2}},
+	)
+	require.NoError(t, sess.RemoteSDP(offer))
+	require.Equal(t, sdp.NewFormats("111", "0"), sess.Formats)
+	require.Contains(t, string(sess.LocalSDP()), "a=rtpmap:111 opus/48000/2")
+
+	writer := NewRTPWriter(sess)
+	require.Equal(t, uint8(111), writer.PayloadType)
+	require.Equal(t, uint32(48000), writer.SampleRate)
+}
+
+func TestMediaDirection(t *testing.T) {
+	sess, err := NewMediaSession(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, nil)
+	require.NoError(t, err)
+	defer sess.Close()
+	require.Equal(t, sdp.ModeSendrecv, sess.Direction())
+
+	ip := net.IPv4(127, 0, 0, 1)
+	require.NoError(t, sess.RemoteSDP(sdp.GenerateForAudio(ip, ip, 2000, sdp.ModeSendonly, sdp.NewFormats("0"))))
+	require.Equal(t, sdp.ModeRecvonly, sess.Direction())
+
+	sess.Mode = sdp.ModeSendonly
+	require.Equal(t, sdp.ModeInactive, sess.Direction())
+
+	sess.Mode = sdp.ModeSendrecv
+	require.NoError(t, sess.UpdateDestinationSDP(sdp.GenerateForAudio(ip, ip, 2000, sdp.ModeSendrecv, sdp.NewFormats("0"))))
+	require.Equal(t, sdp.ModeSendrecv, sess.Direction())
+}
+
+// meteredWriter is example decorator of RTPStreamWriter
+type meteredWriter struct {
+	RTPStreamWriter
+	bytes int
+}
+
+func (w *meteredWriter) Write(b []byte) (int, error) {
+	n, err := w.RTPStreamWriter.Write(b)
+	w.bytes += n
+	return n, err
+}
+
+func TestRTPStreamDecorator(t *testing.T) {
+	sessA, err := NewMediaSession(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, nil)
+	require.NoError(t, err)
+	defer sessA.Close()
+	sessB, err := NewMediaSession(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, nil)
+	require.NoError(t, err)
+	defer sessB.Close()
+	require.NoError(t, sessB.RemoteSDP(sessA.LocalSDP()))
+	require.NoError(t, sessA.RemoteSDP(sessB.LocalSDP()))
+
+	w := &meteredWriter{RTPStreamWriter: NewRTPWriter(sessA)}
+	var r RTPStreamReader = NewRTPReader(sessB)
+
+	var sw RTPStreamWriter = w
+	_, err = sw.Write([]byte("hello"))
+	require.NoError(t, err)
+	require.Equal(t, 5, w.bytes)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	buf := make([]byte, 1500)
+	n, err := r.ReadCtx(ctx, buf)
+	require.NoError(t, err)
+	require.Equal(t, "hello", string(buf[:n]))
+}
+
+func TestMediaPtime(t *testing.T) {
+	sess, err := NewMediaSession(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, nil)
+	require.NoError(t, err)
+	defer sess.Close()
+	require.Equal(t, 20*time.Millisecond, sess.PacketTime())
+
+	sess.Ptime = 30 * time.Millisecond
+	require.Contains(t, string(sess.LocalSDP()), "a=ptime:30")
+	require.Equal(t, 30*time.Millisecond, sess.PacketTime())
+
+	ip := net.IPv4(127, 0, 0, 1)
+	offer := sdp.AppendPtime(sdp.GenerateForAudio(ip, ip, 2000, sdp.ModeSendrecv, sdp.NewFormats("0")), 40*time.Millisecond, 20*time.Millisecond)
+	require.NoError(t, sess.RemoteSDP(offer))
+	// Remote maxptime limits remote ptime
+	require.Equal(t, 20*time.Millisecond, sess.PacketTime())
+
+	offer = sdp.AppendPtime(sdp.GenerateForAudio(ip, ip, 2000, sdp.ModeSendrecv, sdp.NewFormats("0")), 10*time.Millisecond, 0)
+	require.NoError(t, sess.RemoteSDP(offer))
+	w := NewRTPWriter(sess)
+	require.Equal(t, uint32(80), w.ClockRateTimestamp)
+}
+
+func TestMediaBandwidth(t *testing.T) {
+	sess, err := NewMediaSession(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, nil)
+	require.NoError(t, err)
+	defer sess.Close()
+
+	sess.Bandwidth = []sdp.Bandwidth{{Type: sdp.BandwidthTIAS, Value: 64000}}
+	require.Contains(t, string(sess.LocalSDP()), "b=TIAS:64000")
+
+	ip := net.IPv4(127, 0, 0, 1)
+	offer := sdp.AppendBandwidth(sdp.GenerateForAudio(ip, ip, 2000, sdp.ModeSendrecv, sdp.NewFormats("0")), "audio", sdp.Bandwidth{Type: sdp.BandwidthAS, Value: 80})
+	require.NoError(t, sess.RemoteSDP(offer))
+	require.Equal(t, []sdp.Bandwidth{{Type: sdp.BandwidthAS, Value: 80}}, sess.RemoteBandwidth)
+}
+
+func TestRTPWriterSendDigits(t *testing.T) {
+	sessA, err := NewMediaSession(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, nil)
+	require.NoError(t, err)
+	defer sessA.Close()
+	sessB, err := NewMediaSession(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, nil)
+	require.NoError(t, err)
+	defer sessB.Close()
+	require.NoError(t, sessB.RemoteSDP(sessA.LocalSDP()))
+	require.NoError(t, sessA.RemoteSDP(sessB.LocalSDP()))
+
+	defer func(pause time.Duration) { DTMFPause = pause }(DTMFPause)
+	DTMFPause = 10 * time.Millisecond
+
+	received := []rune{}
+	reader := NewRTPReader(sessB)
+	reader.OnDTMF = func(digit rune) {
+		received = append(received, digit)
+	}
+
+	writer := NewRTPWriter(sessA)
+	require.Error(t, writer.SendDigits(context.Background(), "12x", nil))
+
+	sent := []rune{}
+	err = writer.SendDigits(context.Background(), "1, #b", func(digit rune) {
+		sent = append(sent, digit)
+	})
+	require.NoError(t, err)
+	require.Equal(t, []rune{'1', '#', 'B'}, sent)
+
+	_, err = writer.Write([]byte{1, 2, 3})
+	require.NoError(t, err)
+	sessB.rtpConn.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = reader.Read(make([]byte, 1500))
+	require.NoError(t, err)
+	require.Equal(t, sent, received)
+
+	// Canceled during pause
+	ctx, cancel := context.WithCancel(context.Background())
+	DTMFPause = time.Second
+	sent = sent[:0]
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		cancel()
+	}()
+	err = writer.SendDigits(ctx, "5,6", func(digit rune) {
+		sent = append(sent, digit)
+	})
+	require.ErrorIs(t, err, context.Canceled)
+	require.Equal(t, []rune{'5'}, sent)
+}
+
+func TestRTPCSRC(t *testing.T) {
+	sessA, err := NewMediaSession(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, nil)
+	require.NoError(t, err)
+	defer sessA.Close()
+	sessB, err := NewMediaSession(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, nil)
+	require.NoError(t, err)
+	defer sessB.Close()
+	require.NoError(t, sessB.RemoteSDP(sessA.LocalSDP()))
+	require.NoError(t, sessA.RemoteSDP(sessB.LocalSDP()))
+
+	w := NewRTPWriter(sessA)
+	r := NewRTPReader(sessB)
+	buf := make([]byte, 1500)
+	sessB.rtpConn.SetReadDeadline(time.Now().Add(time.Second))
+
+	many := make([]uint32, 20)
+	for i := range many {
+		many[i] = uint32(i + 1)
+	}
+	w.SetCSRC(many...)
+	_, err = w.WriteSamples([]byte{1, 2}, 2, false, w.PayloadType)
+	require.NoError(t, err)
+	_, err = r.Read(buf)
+	require.NoError(t, err)
+	require.Equal(t, many[:15], r.CSRC())
+
+	// Pushed audio source is added while it plays
+	w.SetCSRC(100)
+	require.NoError(t, w.PushAudio([]byte{0xFF}, PushAudioOptions{SSRC: 200}))
+	_, err = w.WriteSamples([]byte{1, 2}, 2, false, w.PayloadType)
+	require.NoError(t, err)
+	_, err = r.Read(buf)
+	require.NoError(t, err)
+	require.Equal(t, []uint32{100, 200}, r.CSRC())
+
+	_, err = w.WriteSamples([]byte{1, 2}, 2, false, w.PayloadType)
+	require.NoError(t, err)
+	_, err = r.Read(buf)
+	require.NoError(t, err)
+	require.Equal(t, []uint32{100}, r.CSRC())
+}
+
+func TestMediaOriginVersion(t *testing.T) {
+	sess, err := NewMediaSession(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, nil)
+	require.NoError(t, err)
+	defer sess.Close()
+
+	origin := func(body []byte) sdp.Origin {
+		sd := sdp.SessionDescription{}
+		require.NoError(t, sdp.Unmarshal(body, &sd))
+		o, err := sd.Origin()
+		require.NoError(t, err)
+		return o
+	}
+
+	o := origin(sess.LocalSDP())
+	require.Equal(t, o, origin(sess.LocalSDP()))
+
+	hold := origin(sess.HoldOffer(sdp.ModeSendonly))
+	require.Equal(t, o.SessionID, hold.SessionID)
+	require.Equal(t, o.SessionVersion+1, hold.SessionVersion)
+
+	// Answer to re-INVITE continues versioning
+	ip := net.IPv4(127, 0, 0, 1)
+	answer, err := sess.Renegotiate(sdp.GenerateForAudio(ip, ip, 2000, sdp.ModeSendrecv, sdp.NewFormats("0")))
+	require.NoError(t, err)
+	reinvite := origin(answer)
+	require.Equal(t, o.SessionID, reinvite.SessionID)
+	require.Equal(t, o.SessionVersion+2, reinvite.SessionVersion)
+}
+
+func TestMediaG729AnnexB(t *testing.T) {
+	sess, err := NewMediaSession(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, nil)
+	require.NoError(t, err)
+	defer sess.Close()
+	sess.Formats = sdp.NewFormats(sdp.FORMAT_TYPE_G729, sdp.FORMAT_TYPE_ULAW)
+	sess.FormatParams = map[string]string{sdp.FORMAT_TYPE_G729: "annexb=no"}
+
+	offer := string(sess.LocalSDP())
+	require.Equal(t, 1, strings.Count(offer, "a=rtpmap:18 G729/8000\r\n"))
+	require.Contains(t, offer, "a=fmtp:18 annexb=no\r\n")
+	for _, f := range sdp.Check([]byte(offer)) {
+		require.NotEqual(t, sdp.SeverityError, f.Severity, f.String())
+	}
+
+	// Remote fmtp is kept with negotiated format
+	ip := net.IPv4(127, 0, 0, 1)
+	remote := sdp.GenerateForAudio(ip, ip, 4000, sdp.ModeSendrecv, sdp.NewFormats("8", "18", "0"))
+	remote = sdp.AppendCodecs(remote, []sdp.Codec{{PayloadType: 18, Name: "G729", ClockRate: 8000, Fmtp: "annexb=yes"}})
+	answer, err := sess.Renegotiate(remote)
+	require.NoError(t, err)
+	require.Equal(t, sdp.NewFormats("18", "0"), sess.Formats)
+	require.Len(t, sess.Codecs, 2)
+	require.True(t, sess.Codecs[0].G729AnnexB())
+	require.Contains(t, string(answer), "a=fmtp:18 annexb=yes\r\n")
+	require.Equal(t, 1, strings.Count(string(answer), "a=rtpmap:18 "))
+}
+
+func TestMediaIPv6SDP(t *testing.T) {
+	sess, err := NewMediaSession(&net.UDPAddr{IP: net.IPv6loopback}, nil)
+	if err != nil {
+		t.Skip("IPv6 loopback not available: ", err)
+	}
+	defer sess.Close()
+
+	local := sdp.SessionDescription{}
+	require.NoError(t, sdp.Unmarshal(sess.LocalSDP(), &local))
+	o, err := local.Origin()
+	require.NoError(t, err)
+	require.Equal(t, "IP6", o.AddressType)
+	require.True(t, o.IP().Equal(net.IPv6loopback))
+	ci, err := local.ConnectionInformation()
+	require.NoError(t, err)
+	require.Equal(t, "IP6", ci.AddressType)
+
+	remoteIP := net.ParseIP("2001:db8::2")
+	require.NoError(t, sess.RemoteSDP(sdp.GenerateForAudio(remoteIP, remoteIP, 4000, sdp.ModeSendrecv, sdp.NewFormats("0"))))
+	require.True(t, sess.Raddr.IP.Equal(remoteIP))
+	require.Equal(t, 4000, sess.Raddr.Port)
+}
+
+// nonAdjacentAllocator listens RTCP on any port
+type nonAdjacentAllocator struct{}
+
+func (nonAdjacentAllocator) Allocate(laddr *net.UDPAddr) (net.PacketConn, net.PacketConn, error) {
+	rtpConn, err := net.ListenUDP("udp", laddr)
+	if err != nil {
+		return nil, nil, err
+	}
+	rtcpConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: laddr.IP})
+	if err != nil {
+		rtpConn.Close()
+		return nil, nil, err
+	}
+	return rtpConn, rtcpConn, nil
+}
+
+func (nonAdjacentAllocator) Release(rtpConn net.PacketConn, rtcpConn net.PacketConn) {}
+
+func TestMediaRTCPAttribute(t *testing.T) {
+	sess, err := NewMediaSession(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, nonAdjacentAllocator{})
+	require.NoError(t, err)
+	defer sess.Close()
+
+	rtcpPort := sess.rtcpConn.LocalAddr().(*net.UDPAddr).Port
+	local := sdp.SessionDescription{}
+	require.NoError(t, sdp.Unmarshal(sess.LocalSDP(), &local))
+	a, ok := local.RTCP("audio")
+	require.Equal(t, rtcpPort != sess.Laddr.Port+1, ok)
+	if ok {
+		require.Equal(t, rtcpPort, a.Port)
+	}
+
+	// Adjacent RTCP is not advertised
+	adjacent, err := NewMediaSession(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, nil)
+	require.NoError(t, err)
+	defer adjacent.Close()
+	require.NotContains(t, string(adjacent.LocalSDP()), "a=rtcp:")
+
+	ip := net.IPv4(127, 0, 0, 2)
+	remote := sdp.GenerateForAudio(ip, ip, 4000, sdp.ModeSendrecv, sdp.NewFormats("0"))
+	require.NoError(t, sess.RemoteSDP(remote))
+	require.Equal(t, "127.0.0.2:4001", sess.rtcpRaddr.String())
+
+	require.NoError(t, sess.RemoteSDP(sdp.AppendRTCP(remote, sdp.RTCPAttribute{Port: 5000})))
+	require.Equal(t, "127.0.0.2:5000", sess.rtcpRaddr.String())
+
+	_, err = sess.Renegotiate(sdp.AppendRTCP(remote, sdp.RTCPAttribute{Port: 5002, IP: net.IPv4(127, 0, 0, 3)}))
+	require.NoError(t, err)
+	require.Equal(t, "127.0.0.3:5002", sess.rtcpRaddr.String())
+}
+
+func TestMediaPayloadTypeMapping(t *testing.T) {
+	opus := sdp.OpusCodec(111, sdp.OpusParams{})
+	newSession := func() *MediaSession {
+		sess, err := NewMediaSession(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, nil)
+		require.NoError(t, err)
+		sess.Registry = sdp.NewCodecRegistry()
+		require.NoError(t, sess.Registry.Register(opus))
+		sess.Formats = sdp.NewFormats("111", sdp.FORMAT_TYPE_ULAW)
+		return sess
+	}
+	ip := net.IPv4(127, 0, 0, 2)
+	remote := sdp.GenerateForAudioDTMF(ip, ip, 4000, sdp.ModeSendrecv, sdp.NewFormats("96"), "100")
+	remote = sdp.AppendCodecs(remote, []sdp.Codec{{PayloadType: 96, Name: "opus", ClockRate: 48000, Channels: 2}})
+
+	// Offerer sends with answer payload types and receives with offered ones
+	sess := newSession()
+	defer sess.Close()
+	offer := string(sess.LocalSDP())
+	require.Contains(t, offer, "a=rtpmap:111 opus/48000/2\r\n")
+	require.NoError(t, sess.RemoteSDP(remote))
+	require.Equal(t, sdp.NewFormats("96"), sess.Formats)
+	require.Equal(t, uint8(111), sess.ReceivePayloadType("96"))
+	require.Equal(t, uint8(96), NewRTPWriter(sess).PayloadType)
+	require.Equal(t, uint8(111), NewRTPReader(sess).PayloadType)
+	require.Equal(t, uint8(100), sess.dtmfPayloadType())
+	require.Equal(t, uint8(101), sess.recvDTMFPayloadType())
+
+	// Answerer mirrors offer payload types
+	ans := newSession()
+	defer ans.Close()
+	require.NoError(t, ans.RemoteSDP(remote))
+	answer := string(ans.LocalSDP())
+	require.Contains(t, answer, "m=audio ")
+	require.Contains(t, answer, " RTP/AVP 96 100\r\n")
+	require.Contains(t, answer, "a=rtpmap:96 opus/48000/2\r\n")
+	require.NotContains(t, answer, ":111")
+	require.Equal(t, uint8(96), NewRTPReader(ans).PayloadType)
+	require.Equal(t, uint8(100), ans.recvDTMFPayloadType())
+
+	// Renegotiation answer mirrors offer
+	reneg := newSession()
+	defer reneg.Close()
+	reneg.LocalSDP()
+	_, err := reneg.Renegotiate(remote)
+	require.NoError(t, err)
+	require.Equal(t, uint8(96), NewRTPReader(reneg).PayloadType)
+	require.Equal(t, uint8(100), reneg.recvDTMFPayloadType())
+}
@@ -0,0 +1,82 @@
+package sipgox
+
+import (
+	"errors"
+	"net"
+	"sync/atomic"
+	"syscall"
+)
+
+// MediaUnreachableSendFailures is number of consecutive RTP send failures after which
+// media is reported unreachable
+var MediaUnreachableSendFailures = 3
+
+// MediaUnreachable is event of remote media not being reachable
+type MediaUnreachable struct {
+	Raddr *net.UDPAddr
+	// ICMP is true when detected by ICMP port/host unreachable
+	ICMP bool
+	// Failures is number of consecutive send failures
+	Failures int
+	Err      error
+}
+
+type unreachableDetector struct {
+	handler  func(ev MediaUnreachable)
+	failures atomic.Int32
+	// reported avoids event on every packet. It is reset when RTP is received
+	reported atomic.Bool
+}
+
+// OnMediaUnreachable sets handler called when remote media is detected unreachable,
+// by ICMP unreachable (where OS exposes it) or by repeated send failures.
+// It is called once until RTP is received again. Must be called before reading and writing
+func (s *MediaSession) OnMediaUnreachable(f func(ev MediaUnreachable)) {
+	s.unreachable = &unreachableDetector{handler: f}
+	if err := enableICMPErrors(s.rtpConn); err != nil {
+		s.log.Debug().Err(err).Msg("ICMP errors can not be enabled on RTP socket")
+	}
+}
+
+func isICMPUnreachable(err error) bool {
+	return errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.EHOSTUNREACH) || errors.Is(err, syscall.ENETUNREACH)
+}
+
+// readError handles read error and returns true if reading can continue
+func (d *unreachableDetector) readError(s *MediaSession, err error) bool {
+	if !isICMPUnreachable(err) {
+		return false
+	}
+	drainICMPErrors(s.rtpConn)
+	d.report(MediaUnreachable{Raddr: s.Raddr, ICMP: true, Err: err})
+	return true
+}
+
+func (d *unreachableDetector) sent(s *MediaSession, err error) {
+	if err == nil {
+		d.failures.Store(0)
+		return
+	}
+
+	failures := int(d.failures.Add(1))
+	if isICMPUnreachable(err) {
+		drainICMPErrors(s.rtpConn)
+		d.report(MediaUnreachable{Raddr: s.Raddr, ICMP: true, Failures: failures, Err: err})
+		return
+	}
+	if failures >= MediaUnreachableSendFailures {
+		d.report(MediaUnreachable{Raddr: s.Raddr, Failures: failures, Err: err})
+	}
+}
+
+func (d *unreachableDetector) received() {
+	d.failures.Store(0)
+	d.reported.Store(false)
+}
+
+func (d *unreachableDetector) report(ev MediaUnreachable) {
+	if d.reported.Swap(true) {
+		return
+	}
+	d.handler(ev)
+}
@@ -0,0 +1,57 @@
+//go:build linux
+
+package sipgox
+
+import (
+	"net"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// enableICMPErrors sets IP_RECVERR so that ICMP errors are reported on unconnected socket
+func enableICMPErrors(conn net.PacketConn) error {
+	sc, ok := conn.(syscall.Conn)
+	if !ok {
+		return nil
+	}
+	rc, err := sc.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	level, opt := unix.IPPROTO_IP, unix.IP_RECVERR
+	if addr, ok := conn.LocalAddr().(*net.UDPAddr); ok && addr.IP.To4() == nil && addr.IP != nil {
+		level, opt = unix.IPPROTO_IPV6, unix.IPV6_RECVERR
+	}
+
+	var serr error
+	if err := rc.Control(func(fd uintptr) {
+		serr = unix.SetsockoptInt(int(fd), level, opt, 1)
+	}); err != nil {
+		return err
+	}
+	return serr
+}
+
+// drainICMPErrors empties socket error queue filled due to IP_RECVERR
+func drainICMPErrors(conn net.PacketConn) {
+	sc, ok := conn.(syscall.Conn)
+	if !ok {
+		return
+	}
+	rc, err := sc.SyscallConn()
+	if err != nil {
+		return
+	}
+
+	buf := make([]byte, 512)
+	oob := make([]byte, 512)
+	rc.Control(func(fd uintptr) {
+		for i := 0; i < 64; i++ {
+			if _, _, _, _, err := unix.Recvmsg(int(fd), buf, oob, unix.MSG_ERRQUEUE|unix.MSG_DONTWAIT); err != nil {
+				return
+			}
+		}
+	})
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+
+package sipgox
+
+import (
+	"net"
+)
+
+// enableICMPErrors is noop. ICMP errors are reported only if OS does it by default
+func enableICMPErrors(conn net.PacketConn) error {
+	return nil
+}
+
+func drainICMPErrors(conn net.PacketConn) {}
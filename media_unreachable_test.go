@@ -0,0 +1,82 @@
+package sipgox
+
+import (
+	"net"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/emiago/sipgo/fakes"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMediaUnreachableICMP(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("ICMP errors are exposed only on linux")
+	}
+
+	// Find closed port
+	closed, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	raddr := closed.LocalAddr().(*net.UDPAddr)
+	closed.Close()
+
+	sess, err := NewMediaSession(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, nil)
+	require.NoError(t, err)
+	defer sess.Close()
+	sess.SetRemoteAddr(raddr)
+
+	events := make(chan MediaUnreachable, 10)
+	sess.OnMediaUnreachable(func(ev MediaUnreachable) {
+		events <- ev
+	})
+
+	go func() {
+		buf := make([]byte, 1500)
+		sess.ReadRTPRaw(buf)
+	}()
+
+	for i := 0; i < 3; i++ {
+		sess.WriteRTPRaw([]byte{0x80, 0, 0, 1})
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	select {
+	case ev := <-events:
+		require.True(t, ev.ICMP)
+		require.Equal(t, raddr.String(), ev.Raddr.String())
+	case <-time.After(time.Second):
+		t.Fatal("no unreachable event")
+	}
+	// Reported once
+	require.Empty(t, events)
+}
+
+func TestMediaUnreachableSendFailures(t *testing.T) {
+	conn := &fakes.UDPConn{}
+	sess := &MediaSession{
+		rtpConn: &failingConn{conn},
+		Raddr:   &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1234},
+	}
+
+	count := 0
+	sess.OnMediaUnreachable(func(ev MediaUnreachable) {
+		count++
+		require.False(t, ev.ICMP)
+		require.Equal(t, MediaUnreachableSendFailures, ev.Failures)
+	})
+
+	for i := 0; i < MediaUnreachableSendFailures+2; i++ {
+		_, err := sess.WriteRTPRaw([]byte{0x80})
+		require.Error(t, err)
+	}
+	require.Equal(t, 1, count)
+}
+
+type failingConn struct {
+	*fakes.UDPConn
+}
+
+func (c *failingConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	return 0, net.ErrClosed
+}
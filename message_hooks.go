@@ -0,0 +1,30 @@
+package sipgox
+
+import "github.com/emiago/sipgo/sip"
+
+// MessageHooks allows inspecting and modifying SIP messages of calls made by phone:
+// - OnOutgoing before message is sent, after phone generated local SDP. It is called with INVITE,
+// provisional and 2xx responses on INVITE and re-INVITE, and 183 with early media SDP
+// - OnIncoming before received SDP is parsed and applied on media session. It is called with INVITE,
+// re-INVITE, responses on INVITE, ACK and BYE
+//
+// ACK and BYE sent by phone and non 2xx final responses of Answer are created by dialog and
+// do not reach hooks.
+// This is useful for SBC like tweaks, like stripping codecs or rewriting c= line.
+// Changing body with SetBody keeps Content-Length correct.
+type MessageHooks struct {
+	OnOutgoing func(msg sip.Message)
+	OnIncoming func(msg sip.Message)
+}
+
+func (h *MessageHooks) outgoing(msg sip.Message) {
+	if h.OnOutgoing != nil {
+		h.OnOutgoing(msg)
+	}
+}
+
+func (h *MessageHooks) incoming(msg sip.Message) {
+	if h.OnIncoming != nil {
+		h.OnIncoming(msg)
+	}
+}
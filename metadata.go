@@ -0,0 +1,93 @@
+package sipgox
+
+import (
+	"strings"
+
+	"github.com/emiago/sipgo/sip"
+)
+
+// DefaultMetadataHeaderPrefix is header prefix for metadata keys without configured header name
+var DefaultMetadataHeaderPrefix = "X-Meta-"
+
+// CallMetadata is per call key/value data, ex. correlation or tenant id.
+// It is carried in custom SIP headers so that it survives hop through B2BUA leg:
+//
+//	in, _ := phone.Answer(ctx, opts)
+//	out, _ := phone.Dial(ctx, uri, DialOptions{Metadata: in.Metadata})
+type CallMetadata map[string]string
+
+// MetadataHeaders maps metadata keys to SIP header names.
+// Zero value maps every key with DefaultMetadataHeaderPrefix
+type MetadataHeaders struct {
+	// Headers maps key to header name, ex. "correlation" -> "X-Correlation-ID"
+	Headers map[string]string
+	// Prefix is used for keys not in Headers. Default is DefaultMetadataHeaderPrefix
+	Prefix string
+	// Strict propagates only keys in Headers
+	Strict bool
+}
+
+func (m MetadataHeaders) prefix() string {
+	if m.Prefix == "" {
+		return DefaultMetadataHeaderPrefix
+	}
+	return m.Prefix
+}
+
+// headerName returns header name of metadata key
+func (m MetadataHeaders) headerName(key string) (string, bool) {
+	if name, exists := m.Headers[key]; exists {
+		return name, true
+	}
+	if m.Strict {
+		return "", false
+	}
+	return m.prefix() + key, true
+}
+
+// Apply adds metadata as headers on egress message. Existing headers with same name are replaced
+func (m MetadataHeaders) Apply(msg sip.Message, md CallMetadata) {
+	r, ok := msg.(interface {
+		RemoveHeader(name string) bool
+	})
+	for k, v := range md {
+		name, exists := m.headerName(k)
+		if !exists {
+			continue
+		}
+		if ok {
+			r.RemoveHeader(name)
+		}
+		msg.AppendHeader(sip.NewHeader(name, v))
+	}
+}
+
+// Extract reads metadata from headers of ingress message
+func (m MetadataHeaders) Extract(msg sip.Message) CallMetadata {
+	md := CallMetadata{}
+	for k, name := range m.Headers {
+		if h := msg.GetHeaders(name); len(h) > 0 {
+			md[k] = h[0].Value()
+		}
+	}
+	if m.Strict {
+		return md
+	}
+
+	hs, ok := msg.(interface{ Headers() []sip.Header })
+	if !ok {
+		return md
+	}
+	prefix := m.prefix()
+	for _, h := range hs.Headers() {
+		name := h.Name()
+		if len(name) <= len(prefix) || !strings.EqualFold(name[:len(prefix)], prefix) {
+			continue
+		}
+		key := name[len(prefix):]
+		if _, exists := md[key]; !exists {
+			md[key] = h.Value()
+		}
+	}
+	return md
+}
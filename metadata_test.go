@@ -0,0 +1,32 @@
+package sipgox
+
+import (
+	"testing"
+
+	"github.com/emiago/sipgo/sip"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetadataHeaders(t *testing.T) {
+	m := MetadataHeaders{
+		Headers: map[string]string{"correlation": "X-Correlation-ID"},
+	}
+
+	req := sip.NewRequest(sip.INVITE, sip.Uri{User: "bob", Host: "127.0.0.1"})
+	req.AppendHeader(sip.NewHeader("X-Correlation-ID", "old"))
+	m.Apply(req, CallMetadata{"correlation": "abc", "tenant": "t1"})
+
+	require.Len(t, req.GetHeaders("X-Correlation-ID"), 1)
+	require.Equal(t, "abc", req.GetHeader("X-Correlation-ID").Value())
+	require.Equal(t, "t1", req.GetHeader("X-Meta-tenant").Value())
+
+	// Other leg reads same metadata
+	require.Equal(t, CallMetadata{"correlation": "abc", "tenant": "t1"}, m.Extract(req))
+
+	m.Strict = true
+	require.Equal(t, CallMetadata{"correlation": "abc"}, m.Extract(req))
+
+	bye := sip.NewRequest(sip.BYE, sip.Uri{User: "bob", Host: "127.0.0.1"})
+	m.Apply(bye, CallMetadata{"tenant": "t2"})
+	require.Nil(t, bye.GetHeader("X-Meta-tenant"))
+}
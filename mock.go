@@ -0,0 +1,277 @@
+package sipgox
+
+import (
+	"context"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/emiago/sipgo/sip"
+	"github.com/emiago/sipgox/sdp"
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+)
+
+// MockDialog is Dialog without SIP peer for unit testing call control logic.
+// State changes are driven with SetState and calls are recorded
+type MockDialog struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	state  chan sip.DialogState
+	media  Media
+
+	// HangupErr and ReferErr are returned by Hangup and Refer
+	HangupErr error
+	ReferErr  error
+
+	mu      sync.Mutex
+	hangups int
+	refers  []sip.Uri
+}
+
+// NewMockDialog creates established dialog. media can be nil for signaling only dialog
+func NewMockDialog(media Media) *MockDialog {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &MockDialog{
+		ctx:    ctx,
+		cancel: cancel,
+		state:  make(chan sip.DialogState, 10),
+		media:  media,
+	}
+}
+
+func (d *MockDialog) Context() context.Context {
+	return d.ctx
+}
+
+func (d *MockDialog) Done() <-chan struct{} {
+	return d.ctx.Done()
+}
+
+func (d *MockDialog) State() <-chan sip.DialogState {
+	return d.state
+}
+
+func (d *MockDialog) Media() Media {
+	return d.media
+}
+
+// SetState emits dialog state. Ended state terminates dialog
+func (d *MockDialog) SetState(s sip.DialogState) {
+	select {
+	case d.state <- s:
+	default:
+		// Like real dialog, states are lost if nobody reads them
+	}
+	if s == sip.DialogStateEnded {
+		d.cancel()
+	}
+}
+
+// Hangup records hangup and ends dialog, unless HangupErr is set
+func (d *MockDialog) Hangup(ctx context.Context) error {
+	d.mu.Lock()
+	d.hangups++
+	d.mu.Unlock()
+	if d.HangupErr != nil {
+		return d.HangupErr
+	}
+	d.SetState(sip.DialogStateEnded)
+	return nil
+}
+
+// Refer records transfer target
+func (d *MockDialog) Refer(ctx context.Context, referTo sip.Uri) error {
+	d.mu.Lock()
+	d.refers = append(d.refers, referTo)
+	d.mu.Unlock()
+	return d.ReferErr
+}
+
+func (d *MockDialog) Close() error {
+	d.cancel()
+	if d.media != nil {
+		d.media.Close()
+	}
+	return nil
+}
+
+// Hangups returns number of Hangup calls
+func (d *MockDialog) Hangups() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.hangups
+}
+
+// Refers returns targets of Refer calls
+func (d *MockDialog) Refers() []sip.Uri {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]sip.Uri{}, d.refers...)
+}
+
+// MockMediaSession is in memory Media without sockets.
+// Packets pushed with PushRTP/PushRTCP are read by application and written ones are recorded
+type MockMediaSession struct {
+	rtpIn  chan rtp.Packet
+	rtcpIn chan rtcp.Packet
+	closed chan struct{}
+	once   sync.Once
+
+	mu          sync.Mutex
+	mode        sdp.Mode
+	remoteMode  sdp.Mode
+	remoteSDP   []byte
+	rtpWritten  []rtp.Packet
+	rtcpWritten []rtcp.Packet
+}
+
+func NewMockMediaSession() *MockMediaSession {
+	return &MockMediaSession{
+		rtpIn:  make(chan rtp.Packet, 100),
+		rtcpIn: make(chan rtcp.Packet, 100),
+		closed: make(chan struct{}),
+		mode:   sdp.ModeSendrecv,
+	}
+}
+
+// PushRTP queues packet to be returned by ReadRTP
+func (m *MockMediaSession) PushRTP(p rtp.Packet) {
+	m.rtpIn <- p
+}
+
+// PushRTCP queues packet to be returned by ReadRTCP
+func (m *MockMediaSession) PushRTCP(p rtcp.Packet) {
+	m.rtcpIn <- p
+}
+
+func (m *MockMediaSession) ReadRTP() (rtp.Packet, error) {
+	return m.ReadRTPDeadline(time.Time{})
+}
+
+func (m *MockMediaSession) ReadRTPDeadline(t time.Time) (rtp.Packet, error) {
+	var timeout <-chan time.Time
+	if !t.IsZero() {
+		timer := time.NewTimer(time.Until(t))
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case p := <-m.rtpIn:
+		return p, nil
+	case <-m.closed:
+		return rtp.Packet{}, net.ErrClosed
+	case <-timeout:
+		return rtp.Packet{}, os.ErrDeadlineExceeded
+	}
+}
+
+func (m *MockMediaSession) ReadRTCP(pkts []rtcp.Packet) (int, error) {
+	select {
+	case p := <-m.rtcpIn:
+		if len(pkts) == 0 {
+			return 0, nil
+		}
+		pkts[0] = p
+		return 1, nil
+	case <-m.closed:
+		return 0, net.ErrClosed
+	}
+}
+
+func (m *MockMediaSession) WriteRTP(p *rtp.Packet) error {
+	select {
+	case <-m.closed:
+		return net.ErrClosed
+	default:
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	pkt := *p
+	pkt.Payload = append([]byte{}, p.Payload...)
+	m.rtpWritten = append(m.rtpWritten, pkt)
+	return nil
+}
+
+func (m *MockMediaSession) WriteRTCP(p rtcp.Packet) error {
+	select {
+	case <-m.closed:
+		return net.ErrClosed
+	default:
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.rtcpWritten = append(m.rtcpWritten, p)
+	return nil
+}
+
+// WrittenRTP returns RTP packets written by application
+func (m *MockMediaSession) WrittenRTP() []rtp.Packet {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]rtp.Packet{}, m.rtpWritten...)
+}
+
+// WrittenRTCP returns RTCP packets written by application
+func (m *MockMediaSession) WrittenRTCP() []rtcp.Packet {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]rtcp.Packet{}, m.rtcpWritten...)
+}
+
+// LocalSDP returns audio SDP with current mode on loopback address
+func (m *MockMediaSession) LocalSDP() []byte {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ip := net.IPv4(127, 0, 0, 1)
+	return sdp.GenerateForAudio(ip, ip, 0, m.mode, sdp.NewFormats(sdp.FORMAT_TYPE_ULAW, sdp.FORMAT_TYPE_ALAW))
+}
+
+// RemoteSDP records remote SDP and its direction
+func (m *MockMediaSession) RemoteSDP(sdpReceived []byte) error {
+	sd := sdp.SessionDescription{}
+	if err := sdp.Unmarshal(sdpReceived, &sd); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.remoteSDP = sdpReceived
+	m.remoteMode = sd.Mode()
+	return nil
+}
+
+// RemoteSDPBody returns last applied remote SDP
+func (m *MockMediaSession) RemoteSDPBody() []byte {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.remoteSDP
+}
+
+func (m *MockMediaSession) HoldOffer(mode sdp.Mode) []byte {
+	m.mu.Lock()
+	m.mode = mode
+	m.mu.Unlock()
+	return m.LocalSDP()
+}
+
+func (m *MockMediaSession) ResumeOffer() []byte {
+	return m.HoldOffer(sdp.ModeSendrecv)
+}
+
+func (m *MockMediaSession) Direction() sdp.Mode {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	remote := m.remoteMode
+	if remote == "" {
+		remote = sdp.ModeSendrecv
+	}
+	return sdp.AnswerMode(remote, m.mode)
+}
+
+func (m *MockMediaSession) Close() {
+	m.once.Do(func() {
+		close(m.closed)
+	})
+}
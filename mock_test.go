@@ -0,0 +1,64 @@
+package sipgox
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/emiago/sipgo/sip"
+	"github.com/emiago/sipgox/sdp"
+	"github.com/pion/rtp"
+	"github.com/stretchr/testify/require"
+)
+
+// transferOnSilence is example of call control logic tested with mocks
+func transferOnSilence(ctx context.Context, d Dialog, target sip.Uri, timeout time.Duration) error {
+	for {
+		p, err := d.Media().ReadRTPDeadline(time.Now().Add(timeout))
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return err
+			}
+			return d.Refer(ctx, target)
+		}
+		// Echo back
+		if err := d.Media().WriteRTP(&p); err != nil {
+			return err
+		}
+	}
+}
+
+func TestMockDialog(t *testing.T) {
+	media := NewMockMediaSession()
+	d := NewMockDialog(media)
+	defer d.Close()
+
+	media.PushRTP(rtp.Packet{Header: rtp.Header{SequenceNumber: 1}, Payload: []byte{1}})
+	target := sip.Uri{User: "bob", Host: "127.0.0.1"}
+	require.NoError(t, transferOnSilence(context.Background(), d, target, 20*time.Millisecond))
+
+	require.Len(t, media.WrittenRTP(), 1)
+	require.Equal(t, []sip.Uri{target}, d.Refers())
+
+	require.NoError(t, d.Hangup(context.Background()))
+	require.Equal(t, 1, d.Hangups())
+	require.Equal(t, sip.DialogStateEnded, <-d.State())
+	<-d.Done()
+
+	media.Close()
+	_, err := media.ReadRTP()
+	require.ErrorIs(t, err, net.ErrClosed)
+}
+
+func TestMockMediaSessionDirection(t *testing.T) {
+	media := NewMockMediaSession()
+	require.Contains(t, string(media.HoldOffer(sdp.ModeSendonly)), "a=sendonly")
+	require.Equal(t, sdp.ModeSendonly, media.Direction())
+
+	ip := net.IPv4(127, 0, 0, 1)
+	require.NoError(t, media.RemoteSDP(sdp.GenerateForAudio(ip, ip, 1000, sdp.ModeRecvonly, sdp.NewFormats("0"))))
+	require.Contains(t, string(media.ResumeOffer()), "a=sendrecv")
+	require.Equal(t, sdp.ModeSendonly, media.Direction())
+}
@@ -0,0 +1,149 @@
+package sipgox
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+const (
+	natpmpPort = 5351
+
+	natpmpOpExternalAddr = 0
+	natpmpOpMapUDP       = 1
+)
+
+// NATPMPClient maps ports with NAT-PMP (RFC 6886)
+type NATPMPClient struct {
+	// Gateway is address of router. Default gateway is used if nil
+	Gateway net.IP
+
+	// port is used only for testing
+	port int
+}
+
+// NATPMPError is non zero result code returned by gateway
+type NATPMPError struct {
+	Code uint16
+}
+
+func (e NATPMPError) Error() string {
+	return fmt.Sprintf("nat-pmp result code %d", e.Code)
+}
+
+// ExternalIP returns public address of gateway
+func (c *NATPMPClient) ExternalIP(ctx context.Context) (net.IP, error) {
+	res, err := c.request(ctx, []byte{0, natpmpOpExternalAddr}, 12)
+	if err != nil {
+		return nil, err
+	}
+	return net.IPv4(res[8], res[9], res[10], res[11]), nil
+}
+
+// MapPort maps internal UDP port to same external port if gateway allows it
+func (c *NATPMPClient) MapPort(ctx context.Context, internal *net.UDPAddr, lifetime time.Duration) (*net.UDPAddr, error) {
+	ip, err := c.ExternalIP(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := c.request(ctx, natpmpMapRequest(internal.Port, internal.Port, lifetime), 16)
+	if err != nil {
+		return nil, err
+	}
+	return &net.UDPAddr{IP: ip, Port: int(binary.BigEndian.Uint16(res[10:12]))}, nil
+}
+
+// UnmapPort removes mapping by requesting zero lifetime
+func (c *NATPMPClient) UnmapPort(ctx context.Context, internal *net.UDPAddr) error {
+	_, err := c.request(ctx, natpmpMapRequest(internal.Port, 0, 0), 16)
+	return err
+}
+
+func natpmpMapRequest(internalPort int, externalPort int, lifetime time.Duration) []byte {
+	req := make([]byte, 12)
+	req[1] = natpmpOpMapUDP
+	binary.BigEndian.PutUint16(req[4:6], uint16(internalPort))
+	binary.BigEndian.PutUint16(req[6:8], uint16(externalPort))
+	binary.BigEndian.PutUint32(req[8:12], uint32(lifetime/time.Second))
+	return req
+}
+
+func (c *NATPMPClient) gateway() (*net.UDPAddr, error) {
+	gw := c.Gateway
+	if gw == nil {
+		var err error
+		if gw, err = DefaultGateway(); err != nil {
+			return nil, fmt.Errorf("fail to find default gateway: %w", err)
+		}
+	}
+
+	port := c.port
+	if port == 0 {
+		port = natpmpPort
+	}
+	return &net.UDPAddr{IP: gw, Port: port}, nil
+}
+
+// request sends request retrying with doubling timeout starting at 250ms until context is done
+func (c *NATPMPClient) request(ctx context.Context, req []byte, size int) ([]byte, error) {
+	raddr, err := c.gateway()
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := net.DialUDP("udp", nil, raddr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	buf := make([]byte, 16)
+	timeout := 250 * time.Millisecond
+	for i := 0; i < 9; i++ {
+		if _, err := conn.Write(req); err != nil {
+			return nil, err
+		}
+
+		deadline := time.Now().Add(timeout)
+		ctxDeadline := false
+		if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+			deadline = d
+			ctxDeadline = true
+		}
+		conn.SetReadDeadline(deadline)
+
+		for {
+			n, err := conn.Read(buf)
+			if err != nil {
+				var nerr net.Error
+				if errors.As(err, &nerr) && nerr.Timeout() {
+					break
+				}
+				return nil, err
+			}
+
+			// Skip responses not matching our request
+			if n < size || buf[0] != 0 || buf[1] != 128+req[1] {
+				continue
+			}
+
+			if code := binary.BigEndian.Uint16(buf[2:4]); code != 0 {
+				return nil, NATPMPError{Code: code}
+			}
+			return buf[:n], nil
+		}
+
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if ctxDeadline {
+			return nil, context.DeadlineExceeded
+		}
+		timeout *= 2
+	}
+	return nil, fmt.Errorf("nat-pmp gateway %s not responding", raddr)
+}
@@ -0,0 +1,39 @@
+//go:build linux
+
+package sipgox
+
+import (
+	"net"
+
+	"golang.org/x/sys/unix"
+)
+
+// PathMTU returns path MTU to raddr (host:port) known by kernel.
+// Kernel updates it on ICMP fragmentation needed (packet too big) feedback
+func PathMTU(raddr string) (int, error) {
+	conn, err := net.Dial("udp", raddr)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	rc, err := conn.(*net.UDPConn).SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+
+	level, opt := unix.IPPROTO_IP, unix.IP_MTU
+	if addr := conn.RemoteAddr().(*net.UDPAddr); addr.IP.To4() == nil {
+		level, opt = unix.IPPROTO_IPV6, unix.IPV6_MTU
+	}
+
+	var mtu int
+	var serr error
+	err = rc.Control(func(fd uintptr) {
+		mtu, serr = unix.GetsockoptInt(int(fd), level, opt)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return mtu, serr
+}
@@ -0,0 +1,12 @@
+//go:build !linux
+
+package sipgox
+
+import (
+	"errors"
+)
+
+// PathMTU is not supported on this platform
+func PathMTU(raddr string) (int, error) {
+	return 0, errors.ErrUnsupported
+}
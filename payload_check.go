@@ -0,0 +1,109 @@
+package sipgox
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/emiago/sipgox/sdp"
+)
+
+// PayloadAnomaly is class of received RTP payload which is not plausible for negotiated codec.
+// It usually means payload type is mislabeled and audio would be garbled
+type PayloadAnomaly int
+
+const (
+	PayloadAnomalyNone PayloadAnomaly = iota
+	// PayloadAnomalyEmpty is packet without payload
+	PayloadAnomalyEmpty
+	// PayloadAnomalyFrameSize is payload length which is not whole number of codec frames
+	PayloadAnomalyFrameSize
+	// PayloadAnomalyTooLong is payload longer than maximum packet time of 200ms
+	PayloadAnomalyTooLong
+)
+
+func (a PayloadAnomaly) String() string {
+	switch a {
+	case PayloadAnomalyNone:
+		return "none"
+	case PayloadAnomalyEmpty:
+		return "empty"
+	case PayloadAnomalyFrameSize:
+		return "frame size"
+	case PayloadAnomalyTooLong:
+		return "too long"
+	}
+	return "unknown"
+}
+
+// codecFrame is payload frame of codec with constant bitrate
+type codecFrame struct {
+	// size is bytes of frame
+	size int
+	// duration is audio length of frame
+	duration time.Duration
+	// maxFrames is number of frames in 200ms
+	maxFrames int
+	// sid is size of silence descriptor allowed after frames, ex. G.729 Annex B
+	sid int
+}
+
+// codecFrames are frames by codec name and clock rate. G.711 and G.722 have no frames,
+// but they are packetized in multiples of 10ms
+var codecFrames = map[string]codecFrame{
+	"PCMU/8000": {size: 80, duration: 10 * time.Millisecond, maxFrames: 20},
+	"PCMA/8000": {size: 80, duration: 10 * time.Millisecond, maxFrames: 20},
+	"G722/8000": {size: 80, duration: 10 * time.Millisecond, maxFrames: 20},
+	"G729/8000": {size: 10, duration: 10 * time.Millisecond, maxFrames: 20, sid: 2},
+	"GSM/8000":  {size: 33, duration: 20 * time.Millisecond, maxFrames: 10},
+}
+
+func codecFrameOf(c sdp.Codec) (codecFrame, bool) {
+	f, ok := codecFrames[strings.ToUpper(c.Name)+"/"+strconv.Itoa(int(c.ClockRate))]
+	return f, ok
+}
+
+// framePacketTime rounds ptime down to whole frames of codec, but at least one frame.
+// Codecs without fixed frames keep ptime
+func framePacketTime(c sdp.Codec, ptime time.Duration) time.Duration {
+	f, ok := codecFrameOf(c)
+	if !ok {
+		return ptime
+	}
+	return max(ptime/f.duration, 1) * f.duration
+}
+
+// PayloadSize returns payload bytes of packet with ptime for codecs with fixed frames,
+// ex. 33 for GSM with 20ms. Ptime is rounded down to whole frames.
+// It returns false for codecs with variable frames, ex. Opus
+func PayloadSize(c sdp.Codec, ptime time.Duration) (int, bool) {
+	f, ok := codecFrameOf(c)
+	if !ok {
+		return 0, false
+	}
+	return int(framePacketTime(c, ptime)/f.duration) * f.size, true
+}
+
+// CheckPayload returns anomaly of received payload for codec.
+// Codecs with variable frame size, ex. Opus, are checked only for empty payload
+func CheckPayload(c sdp.Codec, payload []byte) PayloadAnomaly {
+	if len(payload) == 0 {
+		return PayloadAnomalyEmpty
+	}
+	f, ok := codecFrameOf(c)
+	if !ok {
+		return PayloadAnomalyNone
+	}
+
+	n := len(payload)
+	if f.sid > 0 && n%f.size == f.sid {
+		n -= f.sid
+	}
+	if n%f.size != 0 {
+		return PayloadAnomalyFrameSize
+	}
+	if n/f.size > f.maxFrames {
+		return PayloadAnomalyTooLong
+	}
+	return PayloadAnomalyNone
+}
@@ -0,0 +1,105 @@
+package sipgox
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/emiago/sipgo/fakes"
+	"github.com/emiago/sipgox/sdp"
+	"github.com/pion/rtp"
+	"github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckPayload(t *testing.T) {
+	pcmu, _ := sdp.StaticCodec(sdp.FORMAT_TYPE_ULAW)
+	g729, _ := sdp.StaticCodec(sdp.FORMAT_TYPE_G729)
+	opus := sdp.OpusCodec(111, sdp.OpusParams{})
+
+	require.Equal(t, PayloadAnomalyNone, CheckPayload(pcmu, make([]byte, 160)))
+	require.Equal(t, PayloadAnomalyNone, CheckPayload(pcmu, make([]byte, 240)))
+	require.Equal(t, PayloadAnomalyEmpty, CheckPayload(pcmu, nil))
+	// G.729 20ms labeled as PCMU
+	require.Equal(t, PayloadAnomalyFrameSize, CheckPayload(pcmu, make([]byte, 20)))
+	require.Equal(t, PayloadAnomalyTooLong, CheckPayload(pcmu, make([]byte, 1680)))
+
+	require.Equal(t, PayloadAnomalyNone, CheckPayload(g729, make([]byte, 20)))
+	require.Equal(t, PayloadAnomalyNone, CheckPayload(g729, make([]byte, 22)))
+	require.Equal(t, PayloadAnomalyNone, CheckPayload(g729, make([]byte, 2)))
+	require.Equal(t, PayloadAnomalyFrameSize, CheckPayload(g729, make([]byte, 160+5)))
+
+	require.Equal(t, PayloadAnomalyNone, CheckPayload(opus, make([]byte, 37)))
+	require.Equal(t, PayloadAnomalyEmpty, CheckPayload(opus, nil))
+	require.Equal(t, "frame size", PayloadAnomalyFrameSize.String())
+}
+
+func TestRTPReaderPayloadAnomaly(t *testing.T) {
+	sess := &MediaSession{
+		Formats: sdp.Formats{sdp.FORMAT_TYPE_ULAW},
+		Laddr:   &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)},
+		log:     log.Logger,
+	}
+	conn := &fakes.UDPConn{}
+	sess.rtpConn = conn
+
+	r := NewRTPReader(sess)
+	anomalies := []PayloadAnomaly{}
+	r.OnPayloadAnomaly = func(pkt *rtp.Packet, a PayloadAnomaly) {
+		anomalies = append(anomalies, a)
+	}
+
+	buf := make([]byte, 1500)
+	for i, size := range []int{160, 20, 160} {
+		pkt := rtp.Packet{
+			Header:  rtp.Header{Version: 2, SSRC: 1, SequenceNumber: uint16(i), PayloadType: 0},
+			Payload: make([]byte, size),
+		}
+		data, _ := pkt.Marshal()
+		conn.Reader = bytes.NewBuffer(data)
+		n, err := r.Read(buf)
+		require.NoError(t, err)
+		require.Equal(t, size, n)
+	}
+	require.Equal(t, []PayloadAnomaly{PayloadAnomalyFrameSize}, anomalies)
+}
+
+func TestGSMPacketization(t *testing.T) {
+	gsm, ok := sdp.StaticCodec(sdp.FORMAT_TYPE_GSM)
+	require.True(t, ok)
+	pcmu, _ := sdp.StaticCodec(sdp.FORMAT_TYPE_ULAW)
+	opus := sdp.OpusCodec(111, sdp.OpusParams{})
+
+	size, _ := PayloadSize(gsm, 20*time.Millisecond)
+	require.Equal(t, 33, size)
+	size, _ = PayloadSize(gsm, 40*time.Millisecond)
+	require.Equal(t, 66, size)
+	// Only whole frames can be sent
+	size, _ = PayloadSize(gsm, 30*time.Millisecond)
+	require.Equal(t, 33, size)
+	size, _ = PayloadSize(gsm, 10*time.Millisecond)
+	require.Equal(t, 33, size)
+	size, _ = PayloadSize(pcmu, 30*time.Millisecond)
+	require.Equal(t, 240, size)
+	_, ok = PayloadSize(opus, 20*time.Millisecond)
+	require.False(t, ok)
+
+	require.Equal(t, PayloadAnomalyNone, CheckPayload(gsm, make([]byte, 66)))
+	require.Equal(t, PayloadAnomalyFrameSize, CheckPayload(gsm, make([]byte, 160)))
+
+	sess := &MediaSession{
+		Formats: sdp.Formats{sdp.FORMAT_TYPE_GSM},
+		Laddr:   &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)},
+		Raddr:   &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1234},
+		Ptime:   30 * time.Millisecond,
+	}
+	sess.SetLogger(log.Logger)
+	w := NewRTPWriter(sess)
+	require.Equal(t, uint8(3), w.PayloadType)
+	require.Equal(t, uint32(160), w.ClockRateTimestamp)
+
+	body := sdp.GenerateForAudio(net.IPv4(127, 0, 0, 1), net.IPv4(127, 0, 0, 1), 1234, sdp.ModeSendrecv, sdp.NewFormats(sdp.FORMAT_TYPE_GSM, sdp.FORMAT_TYPE_ULAW))
+	require.Contains(t, string(body), "m=audio 1234 RTP/AVP 3 0")
+	require.Contains(t, string(body), "a=rtpmap:3 GSM/8000")
+}
@@ -10,6 +10,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/emiago/sipgo"
@@ -40,6 +41,43 @@ type Phone struct {
 	// By default they are created
 	client *sipgo.Client
 	server *sipgo.Server
+
+	// blocklist is checked before every dial
+	blocklist DialBlocklist
+
+	hooks MessageHooks
+
+	// taps are matched on every call. Tapped calls are stored by call id
+	taps   []*Tap
+	tapped sync.Map
+
+	dialogStore DialogStore
+
+	// listenerAlloc creates RTP/RTCP sockets for media sessions. If nil ephemeral ports are used
+	listenerAlloc ListenerAllocator
+
+	// mediaAddrMapper maps local media address to advertised address in SDP
+	mediaAddrMapper func(laddr *net.UDPAddr) *net.UDPAddr
+
+	// resolver resolves dial destination. If nil transport layer resolves it
+	resolver Resolver
+
+	udpMessageLimit int
+
+	// stun discovers public address of media sockets
+	stun *STUNClient
+
+	iceLite bool
+	iceFull bool
+
+	// metadataHeaders maps call metadata to SIP headers
+	metadataHeaders MetadataHeaders
+
+	// onCDR receives records of outbound calls. See WithPhoneCDR
+	onCDR func(cdr CDR)
+
+	// peerQuirks are interop quirks by peer host. See WithPhonePeerQuirks
+	peerQuirks map[string]PeerQuirks
 }
 
 type ListenAddr struct {
@@ -70,6 +108,104 @@ func WithPhoneLogger(l zerolog.Logger) PhoneOption {
 	}
 }
 
+// WithPhoneRTPPortPool allocates RTP/RTCP ports of calls from pool
+func WithPhoneRTPPortPool(pool *PortPool) PhoneOption {
+	return func(p *Phone) {
+		p.listenerAlloc = pool
+	}
+}
+
+// WithPhoneMediaExternalIP advertises ip in SDP while media sockets stay bound locally.
+// Useful for 1:1 NAT and cloud deployments
+func WithPhoneMediaExternalIP(ip net.IP) PhoneOption {
+	return func(p *Phone) {
+		p.mediaAddrMapper = func(laddr *net.UDPAddr) *net.UDPAddr {
+			return &net.UDPAddr{IP: ip, Port: laddr.Port}
+		}
+	}
+}
+
+// WithPhoneMediaSTUN discovers public address of every media session with STUN and
+// advertises it in SDP. Media address mapper takes precedence if set
+func WithPhoneMediaSTUN(c *STUNClient) PhoneOption {
+	return func(p *Phone) {
+		p.stun = c
+	}
+}
+
+// WithPhoneMetadataHeaders configures header names used for call metadata
+func WithPhoneMetadataHeaders(m MetadataHeaders) PhoneOption {
+	return func(p *Phone) {
+		p.metadataHeaders = m
+	}
+}
+
+// WithPhoneICELite enables ICE-lite on every media session.
+// Needed for interop with ICE full peers like WebRTC
+func WithPhoneICELite() PhoneOption {
+	return func(p *Phone) {
+		p.iceLite = true
+	}
+}
+
+// WithPhoneICE enables full ICE agent on every media session. Server reflexive
+// candidates are gathered if STUN is set with WithPhoneMediaSTUN.
+// Relay (TURN) candidates are not supported
+func WithPhoneICE() PhoneOption {
+	return func(p *Phone) {
+		p.iceFull = true
+	}
+}
+
+// WithPhoneMediaAddrMapper maps local media address of every session to address advertised in SDP.
+// Returning nil advertises local address
+func WithPhoneMediaAddrMapper(f func(laddr *net.UDPAddr) *net.UDPAddr) PhoneOption {
+	return func(p *Phone) {
+		p.mediaAddrMapper = f
+	}
+}
+
+// WithPhoneResolver resolves dial destinations with r (SRV and A/AAAA).
+// Wrap it with NewCachingResolver for caching
+func WithPhoneResolver(r Resolver) PhoneOption {
+	return func(p *Phone) {
+		p.resolver = r
+	}
+}
+
+// WithPhoneListenerAllocator sets custom RTP/RTCP socket creation for calls
+func WithPhoneListenerAllocator(alloc ListenerAllocator) PhoneOption {
+	return func(p *Phone) {
+		p.listenerAlloc = alloc
+	}
+}
+
+// WithPhonePortMapping maps RTP/RTCP ports of calls on local gateway and advertises mapped address.
+// Use NATPMPClient or UPnPClient as mapper. Pass it after other allocator options as it wraps current allocator
+func WithPhonePortMapping(m PortMapper) PhoneOption {
+	return func(p *Phone) {
+		p.listenerAlloc = &PortMappingAllocator{
+			Allocator: p.listenerAlloc,
+			Mapper:    m,
+		}
+	}
+}
+
+// WithPhoneDialBlocklist enforces blocklist on every Dial and transfer dial.
+// Blocked dials return DialBlockedError
+func WithPhoneDialBlocklist(b DialBlocklist) PhoneOption {
+	return func(p *Phone) {
+		p.blocklist = b
+	}
+}
+
+// WithPhoneMessageHooks sets hooks for inspecting and modifying SIP messages with SDP
+func WithPhoneMessageHooks(hooks MessageHooks) PhoneOption {
+	return func(p *Phone) {
+		p.hooks = hooks
+	}
+}
+
 // func WithPhoneClient(c *sipgo.Client) PhoneOption {
 // 	return func(p *Phone) {
 // 		p.client = c
@@ -133,8 +269,8 @@ func (p *Phone) getLoggerCtx(ctx context.Context, caller string) zerolog.Logger
 	return p.log.With().Str("caller", caller).Logger()
 }
 
-func (p *Phone) getInterfaceAddr(network string, targetAddr string) (addr string, err error) {
-	host, port, err := p.getInterfaceHostPort(network, targetAddr)
+func (p *Phone) getInterfaceAddr(ctx context.Context, network string, targetAddr string) (addr string, err error) {
+	host, port, err := p.getInterfaceHostPort(ctx, network, targetAddr)
 	if err != nil {
 		return "", err
 	}
@@ -207,7 +343,7 @@ func (p *Phone) createServerListeners(s *sipgo.Server) (listeners []*Listener, e
 	}
 
 	if len(p.listenAddrs) == 0 {
-		addr, err := p.getInterfaceAddr("udp", "")
+		addr, err := p.getInterfaceAddr(context.Background(), "udp", "")
 		if err != nil {
 			return listeners, err
 		}
@@ -229,7 +365,7 @@ func (p *Phone) createServerListeners(s *sipgo.Server) (listeners []*Listener, e
 	return listeners, nil
 }
 
-func (p *Phone) getInterfaceHostPort(network string, targetAddr string) (host string, port int, err error) {
+func (p *Phone) getInterfaceHostPort(ctx context.Context, network string, targetAddr string) (host string, port int, err error) {
 	for _, a := range p.listenAddrs {
 		if a.Network == network {
 			host, port, err = sip.ParseAddr(a.Addr)
@@ -255,13 +391,29 @@ func (p *Phone) getInterfaceHostPort(network string, targetAddr string) (host st
 		}
 	}
 
-	ip, port, err := FindFreeInterfaceHostPort(network, targetAddr)
+	ip, port, err := FindFreeInterfaceHostPort(network, p.resolveTargetAddr(ctx, targetAddr))
 	if err != nil {
 		return "", 0, err
 	}
 	return ip.String(), port, nil
 }
 
+// resolveTargetAddr resolves host of target with phone resolver, so interface is chosen by
+// same address requests are sent to. Target is unchanged if it can not be resolved
+func (p *Phone) resolveTargetAddr(ctx context.Context, targetAddr string) string {
+	host, port, err := sip.ParseAddr(targetAddr)
+	if p.resolver == nil || err != nil || host == "" || net.ParseIP(host) != nil {
+		return targetAddr
+	}
+
+	addrs, err := p.resolver.LookupIPAddr(ctx, host)
+	if err != nil || len(addrs) == 0 {
+		p.log.Debug().Err(err).Str("host", host).Msg("Fail to resolve target for interface lookup")
+		return targetAddr
+	}
+	return net.JoinHostPort(addrs[0].IP.String(), strconv.Itoa(port))
+}
+
 var (
 	ErrRegisterFail        = fmt.Errorf("register failed")
 	ErrRegisterUnathorized = fmt.Errorf("register unathorized")
@@ -300,7 +452,7 @@ func (p *Phone) Register(ctx context.Context, recipient sip.Uri, opts RegisterOp
 	if network == "" {
 		network = "udp"
 	}
-	lhost, lport, _ := p.getInterfaceHostPort(network, recipient.HostPort())
+	lhost, lport, _ := p.getInterfaceHostPort(ctx, network, recipient.HostPort())
 	// addr := net.JoinHostPort(lhost, strconv.Itoa(lport))
 
 	// Run server on UA just to handle OPTIONS
@@ -355,6 +507,9 @@ func (p *Phone) Register(ctx context.Context, recipient sip.Uri, opts RegisterOp
 
 func (p *Phone) register(ctx context.Context, client *sipgo.Client, recipient sip.Uri, contact sip.ContactHeader, opts RegisterOptions) (*RegisterTransaction, error) {
 	t := NewRegisterTransaction(p.getLoggerCtx(ctx, "Register"), client, recipient, contact, opts)
+	if err := p.resolveDestination(ctx, t.Origin); err != nil {
+		return nil, err
+	}
 
 	if opts.UnregisterAll {
 		if err := t.Unregister(ctx); err != nil {
@@ -373,6 +528,8 @@ func (p *Phone) register(ctx context.Context, client *sipgo.Client, recipient si
 type DialResponseError struct {
 	InviteReq  *sip.Request
 	InviteResp *sip.Response
+	// Timings of call until final response
+	Timings CallTimings
 
 	Msg string
 }
@@ -393,8 +550,23 @@ type DialOptions struct {
 	// Custom headers passed on INVITE
 	SipHeaders []sip.Header
 
+	// Metadata is sent as custom headers on INVITE. See WithPhoneMetadataHeaders
+	Metadata CallMetadata
+
 	// SDP Formats to customize. NOTE: Only ulaw and alaw are fully supported
 	Formats sdp.Formats
+	// Profile is codec profile of call, ex. per trunk. Formats override profile formats
+	Profile *CodecProfile
+	// Quirks are interop workarounds for peer. If nil, quirks of recipient host are used
+	Quirks *PeerQuirks
+
+	// SDPTrim limits advertised codecs and strips nonessential SDP lines.
+	// Use it per trunk for peers with SDP size or codec count limits
+	SDPTrim *sdp.TrimOptions
+
+	// PassthroughSDP is sent as is in INVITE and no local media session is created.
+	// Only SDPTrim and peer quirks change it. Answer SDP can be read from dialog InviteResponse. Used for signaling only (proxy like) calls
+	PassthroughSDP []byte
 
 	// OnResponse is just callback called after INVITE is sent and all responses before final one
 	// Useful for tracking call state
@@ -430,6 +602,10 @@ func (p *Phone) Dial(dialCtx context.Context, recipient sip.Uri, o DialOptions)
 	// Remove password from uri.
 	recipient.Password = ""
 
+	if err := p.checkBlocklist(recipient); err != nil {
+		return nil, err
+	}
+
 	server, err := sipgo.NewServer(p.UA)
 	if err != nil {
 		return nil, err
@@ -444,15 +620,16 @@ func (p *Phone) Dial(dialCtx context.Context, recipient sip.Uri, o DialOptions)
 	// host, listenPort, _ := sip.ParseAddr(listeners[0].Addr)
 
 	// NOTE: this can return empty port, in this case we probably have hostname
-	host, port, err := p.getInterfaceHostPort(network, recipient.HostPort())
+	host, port, err := p.getInterfaceHostPort(ctx, network, recipient.HostPort())
 	if err != nil {
 		return nil, err
 	}
 
-	contactHDR := sip.ContactHeader{
+	quirks := p.quirksFor(o.Quirks, recipient.Host)
+	contactHDR := quirks.Contact(sip.ContactHeader{
 		Address: sip.Uri{User: p.UA.Name(), Host: host, Port: port},
 		Params:  sip.HeaderParams{"transport": network},
-	}
+	})
 
 	// We will force client to use same interface and port as defined for contact header
 	// The problem could be if this is required to be different, but for now keeping phone simple
@@ -468,6 +645,8 @@ func (p *Phone) Dial(dialCtx context.Context, recipient sip.Uri, o DialOptions)
 	dc := sipgo.NewDialogClient(client, contactHDR)
 
 	server.OnBye(func(req *sip.Request, tx sip.ServerTransaction) {
+		p.hooks.incoming(req)
+		p.tapSIP(TapDirectionIn, req)
 		if err := dc.ReadBye(req, tx); err != nil {
 			if errors.Is(err, sipgo.ErrDialogDoesNotExists) {
 				log.Info().Msg("Received BYE but dialog was already closed")
@@ -480,19 +659,36 @@ func (p *Phone) Dial(dialCtx context.Context, recipient sip.Uri, o DialOptions)
 		log.Debug().Msg("Received BYE")
 	})
 
+	// NOTIFY of our REFER can arrive only after dialog is created
+	var notifyDialog atomic.Pointer[DialogClientSession]
+	server.OnNotify(func(req *sip.Request, tx sip.ServerTransaction) {
+		p.tapSIP(TapDirectionIn, req)
+		err := sipgo.ErrDialogDoesNotExists
+		if d := notifyDialog.Load(); d != nil {
+			err = d.Notify(req)
+		}
+		if err != nil {
+			log.Info().Err(err).Msg("Fail to read NOTIFY")
+		}
+		tx.Respond(notifyResponse(req, err))
+	})
+
 	server.OnRefer(func(req *sip.Request, tx sip.ServerTransaction) {
 		if o.OnRefer == nil {
 			log.Warn().Str("req", req.StartLine()).Msg("Refer is not handled. Missing OnRefer")
 			tx.Respond(sip.NewResponseFromRequest(req, sip.StatusMethodNotAllowed, "Method not allowed", nil))
 			return
 		}
+		if p.rejectBlockedRefer(req, tx) {
+			return
+		}
 
 		var dialog *sipgo.DialogClientSession
 		var newDialog *DialogClientSession
+		var referUri sip.Uri
 		var err error
 		// TODO refactor this
 		refer := func() error {
-			referUri := sip.Uri{}
 			dialog, err = dc.ReadRefer(req, tx, &referUri)
 			if err != nil {
 				return err
@@ -503,16 +699,19 @@ func (p *Phone) Dial(dialCtx context.Context, recipient sip.Uri, o DialOptions)
 			if lip := net.ParseIP(host); lip != nil && !lip.IsUnspecified() {
 				rtpIp = lip
 			}
-			msess, err := NewMediaSession(&net.UDPAddr{IP: rtpIp, Port: 0})
+			msess, err := p.newMediaSession(rtpIp)
 			if err != nil {
 				return err
 			}
+			quirks.Apply(msess)
 
 			notifyAccepted := true
 			{
 				// Do Notify
 				log.Info().Msg("Sending NOTIFY")
 				notify := sip.NewRequest(sip.NOTIFY, req.Contact().Address)
+				notify.AppendHeader(sip.NewHeader("Event", referEvent(req)))
+				notify.AppendHeader(sip.NewHeader("Subscription-State", "active"))
 				notify.AppendHeader(sip.NewHeader("Content-Type", "message/sipfrag;version=2.0"))
 				notify.SetBody([]byte("SIP/2.0 100 Trying"))
 				cliTx, err := dialog.TransactionRequest(dialog.Context(), notify)
@@ -531,7 +730,7 @@ func (p *Phone) Dial(dialCtx context.Context, recipient sip.Uri, o DialOptions)
 			invite := sip.NewRequest(sip.INVITE, referUri)
 			invite.SetTransport(network)
 			invite.AppendHeader(sip.NewHeader("Content-Type", "application/sdp"))
-			invite.SetBody(msess.LocalSDP())
+			invite.SetBody(quirks.SDP(msess.LocalSDP()))
 
 			newDialog, err = p.dial(context.TODO(), dc, invite, msess, o)
 			if err != nil {
@@ -540,6 +739,8 @@ func (p *Phone) Dial(dialCtx context.Context, recipient sip.Uri, o DialOptions)
 
 			if notifyAccepted {
 				notify := sip.NewRequest(sip.NOTIFY, req.Contact().Address)
+				notify.AppendHeader(sip.NewHeader("Event", referEvent(req)))
+				notify.AppendHeader(sip.NewHeader("Subscription-State", "terminated;reason=noresource"))
 				notify.AppendHeader(sip.NewHeader("Content-Type", "message/sipfrag;version=2.0"))
 				notify.SetBody([]byte("SIP/2.0 200 OK"))
 				cliTx, err := dialog.TransactionRequest(dialog.Context(), notify)
@@ -557,6 +758,7 @@ func (p *Phone) Dial(dialCtx context.Context, recipient sip.Uri, o DialOptions)
 		o.OnRefer(DialogReferState{State: 0})
 		if err := refer(); err != nil {
 			log.Error().Err(err).Msg("Fail to dial REFER")
+			MediaEngineDefault.reportError(&BackgroundError{Source: BackgroundRefer, Addr: referUri.String(), Err: err})
 			// Handle better this errors
 			tx.Respond(sip.NewResponseFromRequest(req, sip.StatusInternalServerError, err.Error(), nil))
 			o.OnRefer(DialogReferState{State: sip.DialogStateEnded})
@@ -576,21 +778,42 @@ func (p *Phone) Dial(dialCtx context.Context, recipient sip.Uri, o DialOptions)
 	// 	go l.Listen()
 	// }
 
-	// Setup session
-	rtpIp := p.UA.GetIP()
-	if lip := net.ParseIP(host); lip != nil && !lip.IsUnspecified() {
-		rtpIp = lip
-	}
-	msess, err := NewMediaSession(&net.UDPAddr{IP: rtpIp, Port: 0})
-	if err != nil {
-		return nil, err
-	}
+	var msess *MediaSession
+	sdpSend := o.PassthroughSDP
+	if sdpSend == nil {
+		// Setup session
+		rtpIp := p.UA.GetIP()
+		if lip := net.ParseIP(host); lip != nil && !lip.IsUnspecified() {
+			rtpIp = lip
+		}
+		msess, err = p.newMediaSession(rtpIp)
+		if err != nil {
+			return nil, err
+		}
 
-	// Create Generic SDP
-	if len(o.Formats) > 0 {
-		msess.Formats = o.Formats
+		// Create Generic SDP
+		if o.Profile != nil {
+			if err := o.Profile.Apply(msess); err != nil {
+				return nil, err
+			}
+		}
+		if len(o.Formats) > 0 {
+			msess.Formats = o.Formats
+		}
+		quirks.Apply(msess)
+		if a := msess.ICE(); a != nil {
+			// Offerer is controlling
+			a.Controlling = true
+		}
+		if o.SDPTrim != nil && o.SDPTrim.MaxFormats > 0 && len(msess.Formats) > o.SDPTrim.MaxFormats {
+			msess.Formats = msess.Formats[:o.SDPTrim.MaxFormats]
+		}
+		sdpSend = msess.LocalSDP()
 	}
-	sdpSend := msess.LocalSDP()
+	if o.SDPTrim != nil {
+		sdpSend = sdp.Trim(sdpSend, *o.SDPTrim)
+	}
+	sdpSend = quirks.SDP(sdpSend)
 
 	// Creating INVITE
 	req := sip.NewRequest(sip.INVITE, recipient)
@@ -603,33 +826,167 @@ func (p *Phone) Dial(dialCtx context.Context, recipient sip.Uri, o DialOptions)
 		log.Info().Str(h.Name(), h.Value()).Msg("Adding SIP header")
 		req.AppendHeader(h)
 	}
+	p.metadataHeaders.Apply(req, o.Metadata)
 
 	dialog, err := p.dial(ctx, dc, req, msess, o)
 	if err != nil {
 		return nil, err
 	}
+	notifyDialog.Store(dialog)
 
 	return dialog, nil
 }
 
+func (p *Phone) newMediaSession(ip net.IP) (*MediaSession, error) {
+	msess, err := NewMediaSession(&net.UDPAddr{IP: ip, Port: 0}, p.listenerAlloc)
+	if err != nil {
+		return nil, err
+	}
+
+	if p.stun != nil {
+		if err := msess.DiscoverAdvertisedAddr(p.stun); err != nil {
+			p.log.Error().Err(err).Msg("Fail to discover public media address. Advertising local")
+		}
+	}
+
+	if pm, ok := p.listenerAlloc.(*PortMappingAllocator); ok {
+		if ext := pm.ExternalAddr(msess.Laddr); ext != nil {
+			msess.AdvertisedAddr = ext
+		}
+	}
+
+	if p.mediaAddrMapper != nil {
+		msess.AdvertisedAddr = p.mediaAddrMapper(msess.Laddr)
+	}
+
+	if p.iceFull {
+		if _, err := msess.EnableICE(p.stun); err != nil {
+			msess.Close()
+			return nil, err
+		}
+	} else if p.iceLite {
+		msess.EnableICELite()
+	}
+	return msess, nil
+}
+
+func (p *Phone) resolveDestination(ctx context.Context, req *sip.Request) error {
+	if p.resolver == nil || req.MessageData.Destination() != "" {
+		return nil
+	}
+
+	uri := &req.Recipient
+	if hdr := req.Route(); hdr != nil {
+		uri = &hdr.Address
+	}
+
+	dest, err := resolveSIPDestination(ctx, p.resolver, req.Transport(), uri.Host, uri.Port)
+	if err != nil {
+		return fmt.Errorf("fail to resolve %q: %w", uri.Host, err)
+	}
+	req.SetDestination(dest)
+	return nil
+}
+
+// iceConnect runs ICE checks of full agent. On failure media continues to SDP connection address
+func (p *Phone) iceConnect(ctx context.Context, msess *MediaSession) {
+	if a := msess.ICE(); a == nil || a.Lite {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, iceConnectTimeout)
+	defer cancel()
+	if err := msess.ICEConnect(ctx); err != nil {
+		p.log.Warn().Err(err).Msg("ICE connectivity checks failed")
+	}
+}
+
+func (p *Phone) checkBlocklist(recipient sip.Uri) error {
+	if p.blocklist == nil {
+		return nil
+	}
+
+	if reason, blocked := p.blocklist.Blocked(recipient); blocked {
+		return &DialBlockedError{
+			Recipient: recipient,
+			Reason:    reason,
+		}
+	}
+	return nil
+}
+
+// rejectBlockedRefer refuses REFER with blocked transfer target before it is accepted
+func (p *Phone) rejectBlockedRefer(req *sip.Request, tx sip.ServerTransaction) bool {
+	h := req.GetHeader("Refer-To")
+	if h == nil {
+		return false
+	}
+	referUri := sip.Uri{}
+	if err := sip.ParseUri(h.Value(), &referUri); err != nil {
+		// Invalid header is rejected when REFER is read
+		return false
+	}
+	if err := p.checkBlocklist(referUri); err != nil {
+		p.log.Warn().Err(err).Msg("REFER target is blocked")
+		tx.Respond(sip.NewResponseFromRequest(req, sip.StatusForbidden, "Forbidden", nil))
+		return true
+	}
+	return false
+}
+
 func (p *Phone) dial(ctx context.Context, dc *sipgo.DialogClient, invite *sip.Request, msess *MediaSession, o DialOptions) (*DialogClientSession, error) {
 	log := p.getLoggerCtx(ctx, "Dial")
+	if err := p.resolveDestination(ctx, invite); err != nil {
+		return nil, err
+	}
+	p.hooks.outgoing(invite)
+	p.fitUDPMessage(invite, &log)
+	timings := &CallTimings{InviteSent: time.Now()}
 	dialog, err := dc.WriteInvite(ctx, invite)
 	if err != nil {
 		return nil, err
 	}
 	p.logSipRequest(&log, invite)
-	return p.dialWaitAnswer(ctx, dialog, msess, o)
+
+	// Call id is present only after invite is sent
+	callID := invite.CallID().Value()
+	if ct := p.tapCall(invite, TapDirectionOut); ct != nil && msess != nil {
+		msess.tap = ct
+	}
+
+	d, err := p.dialWaitAnswer(ctx, dialog, msess, o, timings)
+	if err != nil {
+		p.untapCall(callID)
+		var res *sip.Response
+		var rerr *DialResponseError
+		if errors.As(err, &rerr) {
+			res = rerr.InviteResp
+		}
+		p.recordCDR(invite, res, *timings)
+		return nil, err
+	}
+	p.saveDialog(true, d.InviteRequest, d.InviteResponse, d.InviteRequest.CSeq().SeqNo)
+	d.onRequest = p.dialogCSeqSaver(true, d.InviteRequest, d.InviteResponse)
+	d.onClose = func() {
+		p.untapCall(callID)
+		p.deleteDialog(d.InviteResponse)
+		d.Timings.Ended = time.Now()
+		p.recordCDR(d.InviteRequest, d.InviteResponse, d.Timings)
+	}
+	return d, nil
 }
 
-func (p *Phone) dialWaitAnswer(ctx context.Context, dialog *sipgo.DialogClientSession, msess *MediaSession, o DialOptions) (*DialogClientSession, error) {
+func (p *Phone) dialWaitAnswer(ctx context.Context, dialog *sipgo.DialogClientSession, msess *MediaSession, o DialOptions, timings *CallTimings) (*DialogClientSession, error) {
 	log := p.getLoggerCtx(ctx, "Dial")
 	invite := dialog.InviteRequest
 	// Wait 200
 	waitStart := time.Now()
 	err := dialog.WaitAnswer(ctx, sipgo.AnswerOptions{
 		OnResponse: func(res *sip.Response) {
+			timings.response(res)
 			p.logSipResponse(&log, res)
+			p.hooks.incoming(res)
+			p.tapSIP(TapDirectionIn, res)
 			if o.OnResponse != nil {
 				o.OnResponse(res)
 			}
@@ -643,6 +1000,7 @@ func (p *Phone) dialWaitAnswer(ctx context.Context, dialog *sipgo.DialogClientSe
 		return nil, &DialResponseError{
 			InviteReq:  invite,
 			InviteResp: rerr.Res,
+			Timings:    *timings,
 			Msg:        fmt.Sprintf("Call not answered: %s", rerr.Res.StartLine()),
 		}
 	}
@@ -652,33 +1010,43 @@ func (p *Phone) dialWaitAnswer(ctx context.Context, dialog *sipgo.DialogClientSe
 	}
 
 	r := dialog.InviteResponse
+	timings.response(r)
 	log.Info().
 		Int("code", int(r.StatusCode)).
 		// Str("reason", r.Reason).
 		Str("duration", time.Since(waitStart).String()).
+		Str("pdd", timings.PostDialDelay().String()).
 		Msg("Call answered")
 
-	// Setup media
-	err = msess.RemoteSDP(r.Body())
-	// TODO handle bad SDP
-	if err != nil {
-		return nil, err
-	}
+	// Setup media. Hooks were called with response in OnResponse
+	if msess != nil {
+		err = msess.RemoteSDP(r.Body())
+		// TODO handle bad SDP
+		if err != nil {
+			return nil, err
+		}
 
-	log.Info().
-		Str("formats", logFormats(msess.Formats)).
-		Str("localAddr", msess.Laddr.String()).
-		Str("remoteAddr", msess.Raddr.String()).
-		Msg("Media/RTP session created")
+		log.Info().
+			Str("formats", logFormats(msess.Formats)).
+			Str("localAddr", msess.Laddr.String()).
+			Str("remoteAddr", msess.Raddr.String()).
+			Msg("Media/RTP session created")
+	}
 
 	// Send ACK
 	if err := dialog.Ack(ctx); err != nil {
 		return nil, fmt.Errorf("fail to send ACK: %w", err)
 	}
 
+	if msess != nil {
+		p.iceConnect(ctx, msess)
+	}
+
 	return &DialogClientSession{
 		MediaSession:        msess,
 		DialogClientSession: dialog,
+		Metadata:            p.metadataHeaders.Extract(dialog.InviteResponse),
+		Timings:             *timings,
 	}, nil
 }
 
@@ -693,6 +1061,9 @@ type AnswerOptions struct {
 	Ringtime   time.Duration
 	SipHeaders []sip.Header
 
+	// Metadata is sent as custom headers on answer response. See WithPhoneMetadataHeaders
+	Metadata CallMetadata
+
 	// For authorizing INVITE unless RegisterAddr is defined
 	Username string
 	Password string
@@ -702,6 +1073,15 @@ type AnswerOptions struct {
 
 	// For SDP codec manipulating
 	Formats sdp.Formats
+	// Profile is codec profile of answered calls. Formats override profile formats
+	Profile *CodecProfile
+	// Quirks are interop workarounds for peer. If nil, quirks of INVITE source host are used
+	Quirks *PeerQuirks
+
+	// OnPassthroughSDP switches answer to signaling only mode.
+	// It receives offer SDP and must return answer SDP which is sent untouched, except for peer quirks.
+	// No local media session is created. Use this to bridge SDP with other leg.
+	OnPassthroughSDP func(offer []byte) (answer []byte, err error)
 
 	// OnCall is just INVITE request handler that you can use to notify about incoming call
 	// After this dialog should be created and you can watch your changes with dialog.State
@@ -710,6 +1090,12 @@ type AnswerOptions struct {
 	// >0 different response
 	OnCall func(inviteRequest *sip.Request) int
 
+	// OnEarlyMedia is called after 183 Session Progress with SDP is sent, so that announcement
+	// can be streamed with media session before answer, ex. custom ringback or queue position.
+	// ctx is canceled when caller cancels call. Returned value is as in OnCall:
+	// 0 answers call, >0 rejects call with that status code without answering
+	OnEarlyMedia func(ctx context.Context, sess *MediaSession) int
+
 	// Default is 200 (answer a call)
 	AnswerCode   sip.StatusCode
 	AnswerReason string
@@ -829,6 +1215,9 @@ func (p *Phone) answer(ansCtx context.Context, opts AnswerOptions) (*DialogServe
 		})
 		go func(ctx context.Context) {
 			err := regTr.QualifyLoop(ctx)
+			if ctx.Err() == nil {
+				MediaEngineDefault.reportError(&BackgroundError{Source: BackgroundRegister, Addr: opts.RegisterAddr, Err: err})
+			}
 			exitError(err)
 			stopAnswer()
 		}(ctx)
@@ -842,16 +1231,32 @@ func (p *Phone) answer(ansCtx context.Context, opts AnswerOptions) (*DialogServe
 			did, _ := sip.MakeDialogIDFromRequest(req)
 			if did == didAnswered {
 				// We received INVITE for update
-				if err := d.MediaSession.UpdateDestinationSDP(req.Body()); err != nil {
-					res := sip.NewResponseFromRequest(req, 400, err.Error(), nil)
+				p.hooks.incoming(req)
+				if d.MediaSession == nil {
+					log.Warn().Msg("Received re-INVITE on passthrough dialog. Not supported")
+					res := sip.NewResponseFromRequest(req, sip.StatusNotAcceptableHere, "Not Acceptable Here", nil)
 					if err := tx.Respond(res); err != nil {
-						log.Error().Err(err).Msg("Fail to send 400")
-						return
+						log.Error().Err(err).Msg("Fail to send 488")
 					}
 					return
 				}
+				// Re-INVITE without SDP gets our offer. Answer in ACK is not applied
+				body := d.MediaSession.LocalSDP()
+				if len(req.Body()) > 0 {
+					answer, err := d.MediaSession.Renegotiate(req.Body())
+					if err != nil {
+						res := sip.NewResponseFromRequest(req, sip.StatusNotAcceptableHere, err.Error(), nil)
+						if err := tx.Respond(res); err != nil {
+							log.Error().Err(err).Msg("Fail to send 488")
+						}
+						return
+					}
+					body = answer
+				}
 
-				res := sip.NewResponseFromRequest(req, 200, "OK", nil)
+				res := sip.NewResponseFromRequest(req, 200, "OK", body)
+				res.AppendHeader(sip.NewHeader("Content-Type", "application/sdp"))
+				p.hooks.outgoing(res)
 				if err := tx.Respond(res); err != nil {
 					log.Error().Err(err).Msg("Fail to send 200")
 					return
@@ -923,8 +1328,12 @@ func (p *Phone) answer(ansCtx context.Context, opts AnswerOptions) (*DialogServe
 			log.Info().Str("username", cred.Username).Str("source", req.Source()).Msg("INVITE authorized")
 		}
 		p.logSipRequest(&log, req)
+		p.hooks.incoming(req)
 
 		dialog, err := ds.ReadInvite(req, tx)
+		if err == nil {
+			p.tapCall(req, TapDirectionIn)
+		}
 		if err != nil {
 			res := sip.NewResponseFromRequest(req, 400, err.Error(), nil)
 			if err := tx.Respond(res); err != nil {
@@ -954,28 +1363,30 @@ func (p *Phone) answer(ansCtx context.Context, opts AnswerOptions) (*DialogServe
 				}
 			}
 
-			if opts.AnswerCode > 0 && opts.AnswerCode != sip.StatusOK {
-				log.Info().Int("code", int(opts.AnswerCode)).Msg("Answering call")
-				if opts.AnswerReason == "" {
+			// respondFinal rejects call with non 2xx response and returns dialog after ACK
+			respondFinal := func(code sip.StatusCode, reason string) error {
+				log.Info().Int("code", int(code)).Msg("Answering call")
+				if reason == "" {
 					// apply some default one
-					switch opts.AnswerCode {
+					switch code {
 					case sip.StatusBusyHere:
-						opts.AnswerReason = "Busy"
+						reason = "Busy"
 					case sip.StatusForbidden:
-						opts.AnswerReason = "Forbidden"
+						reason = "Forbidden"
 					case sip.StatusUnauthorized:
-						opts.AnswerReason = "Unathorized"
+						reason = "Unathorized"
 					}
 				}
 
-				if err := dialog.Respond(opts.AnswerCode, opts.AnswerReason, nil); err != nil {
+				if err := dialog.Respond(code, reason, nil); err != nil {
 					d = nil
-					return fmt.Errorf("failed to respond custom status code %d: %w", int(opts.AnswerCode), err)
+					return fmt.Errorf("failed to respond custom status code %d: %w", int(code), err)
 				}
 				p.logSipResponse(&log, dialog.InviteResponse)
 
 				d = &DialogServerSession{
 					DialogServerSession: dialog,
+					Metadata:            p.metadataHeaders.Extract(req),
 					// done:                make(chan struct{}),
 				}
 				select {
@@ -996,6 +1407,10 @@ func (p *Phone) answer(ansCtx context.Context, opts AnswerOptions) (*DialogServe
 				return nil
 			}
 
+			if opts.AnswerCode > 0 && opts.AnswerCode != sip.StatusOK {
+				return respondFinal(opts.AnswerCode, opts.AnswerReason)
+			}
+
 			if err != nil {
 				return fmt.Errorf("fail to setup client handle: %w", err)
 			}
@@ -1003,6 +1418,7 @@ func (p *Phone) answer(ansCtx context.Context, opts AnswerOptions) (*DialogServe
 			// Now place a ring tone or do autoanswer
 			if ringtime > 0 {
 				res := sip.NewResponseFromRequest(req, 180, "Ringing", nil)
+				p.hooks.outgoing(res)
 				if err := dialog.WriteResponse(res); err != nil {
 					return fmt.Errorf("failed to send 180 response: %w", err)
 				}
@@ -1021,6 +1437,7 @@ func (p *Phone) answer(ansCtx context.Context, opts AnswerOptions) (*DialogServe
 			} else {
 				// Send progress
 				res := sip.NewResponseFromRequest(req, 100, "Trying", nil)
+				p.hooks.outgoing(res)
 				if err := dialog.WriteResponse(res); err != nil {
 					return fmt.Errorf("failed to send 100 response: %w", err)
 				}
@@ -1033,33 +1450,72 @@ func (p *Phone) answer(ansCtx context.Context, opts AnswerOptions) (*DialogServe
 				return fmt.Errorf("no SDP in INVITE provided")
 			}
 
-			ip := p.UA.GetIP()
-			// rtpPort := rand.Intn(1000*2)/2 + 6000
-			if lip := net.ParseIP(lhost); lip != nil && !lip.IsUnspecified() {
-				ip = lip
-			}
+			quirks := p.quirksFor(opts.Quirks, req.Source())
+			var msess *MediaSession
+			var answerSDP []byte
+			if opts.OnPassthroughSDP != nil {
+				// Signaling only mode. No local media is created
+				answerSDP, err = opts.OnPassthroughSDP(req.Body())
+				if err != nil {
+					return fmt.Errorf("passthrough SDP failed: %w", err)
+				}
+			} else {
+				ip := p.UA.GetIP()
+				// rtpPort := rand.Intn(1000*2)/2 + 6000
+				if lip := net.ParseIP(lhost); lip != nil && !lip.IsUnspecified() {
+					ip = lip
+				}
 
-			msess, err := NewMediaSession(&net.UDPAddr{IP: ip, Port: 0})
-			if err != nil {
-				return err
-			}
-			// Set our custom formats in this negotiation
-			if len(opts.Formats) > 0 {
-				msess.Formats = opts.Formats
+				msess, err = p.newMediaSession(ip)
+				if err != nil {
+					return err
+				}
+				// Set our custom formats in this negotiation
+				if opts.Profile != nil {
+					if err := opts.Profile.Apply(msess); err != nil {
+						return err
+					}
+				}
+				if len(opts.Formats) > 0 {
+					msess.Formats = opts.Formats
+				}
+				quirks.Apply(msess)
+
+				err = msess.RemoteSDP(req.Body())
+				if err != nil {
+					return err
+				}
+
+				log.Info().
+					Str("formats", logFormats(msess.Formats)).
+					Str("localAddr", msess.Laddr.String()).
+					Str("remoteAddr", msess.Raddr.String()).
+					Msg("Media/RTP session created")
+				answerSDP = msess.LocalSDP()
 			}
+			answerSDP = quirks.SDP(answerSDP)
 
-			err = msess.RemoteSDP(req.Body())
-			if err != nil {
-				return err
+			if v, ok := p.tapped.Load(req.CallID().Value()); ok && msess != nil {
+				msess.tap = v.(*callTap)
 			}
 
-			log.Info().
-				Str("formats", logFormats(msess.Formats)).
-				Str("localAddr", msess.Laddr.String()).
-				Str("remoteAddr", msess.Raddr.String()).
-				Msg("Media/RTP session created")
+			if opts.OnEarlyMedia != nil && msess != nil {
+				code, err := p.earlyMedia(ctx, &log, dialog, tx, req, msess, answerSDP, opts.OnEarlyMedia)
+				if err != nil {
+					msess.Close()
+					return err
+				}
+				if code > 0 {
+					msess.Close()
+					return respondFinal(sip.StatusCode(code), "")
+				}
+			}
 
-			res := sip.NewSDPResponseFromRequest(req, msess.LocalSDP())
+			res := sip.NewSDPResponseFromRequest(req, answerSDP)
+			if quirks.ContactNoTransport {
+				contact := quirks.Contact(contactHdr)
+				res.AppendHeader(&contact)
+			}
 
 			// via, _ := res.Via()
 			// via.Params["received"] = rhost
@@ -1070,13 +1526,18 @@ func (p *Phone) answer(ansCtx context.Context, opts AnswerOptions) (*DialogServe
 				log.Info().Str(h.Name(), h.Value()).Msg("Adding SIP header")
 				res.AppendHeader(h)
 			}
+			p.metadataHeaders.Apply(res, opts.Metadata)
 
 			d = &DialogServerSession{
 				DialogServerSession: dialog,
 				MediaSession:        msess,
+				Metadata:            p.metadataHeaders.Extract(req),
 				// done:                make(chan struct{}),
 			}
 
+			p.hooks.outgoing(res)
+			p.tapSIP(TapDirectionOut, res)
+
 			log.Info().Msg("Answering call")
 			if err := dialog.WriteResponse(res); err != nil {
 				d = nil
@@ -1098,6 +1559,7 @@ func (p *Phone) answer(ansCtx context.Context, opts AnswerOptions) (*DialogServe
 		}()
 
 		if err != nil {
+			p.untapCall(req.CallID().Value())
 			dialog.Close()
 			exitError(err)
 			stopAnswer()
@@ -1106,6 +1568,7 @@ func (p *Phone) answer(ansCtx context.Context, opts AnswerOptions) (*DialogServe
 	})
 
 	server.OnAck(func(req *sip.Request, tx sip.ServerTransaction) {
+		p.hooks.incoming(req)
 		// This on 2xx
 		if d == nil {
 			if chal != nil {
@@ -1134,6 +1597,8 @@ func (p *Phone) answer(ansCtx context.Context, opts AnswerOptions) (*DialogServe
 	})
 
 	server.OnBye(func(req *sip.Request, tx sip.ServerTransaction) {
+		p.hooks.incoming(req)
+		p.tapSIP(TapDirectionIn, req)
 		if err := ds.ReadBye(req, tx); err != nil {
 			exitError(fmt.Errorf("dialog BYE err: %w", err))
 			return
@@ -1153,6 +1618,19 @@ func (p *Phone) answer(ansCtx context.Context, opts AnswerOptions) (*DialogServe
 		tx.Respond(res)
 	})
 
+	var notifyDialog atomic.Pointer[DialogServerSession]
+	server.OnNotify(func(req *sip.Request, tx sip.ServerTransaction) {
+		p.tapSIP(TapDirectionIn, req)
+		err := sipgo.ErrDialogDoesNotExists
+		if d := notifyDialog.Load(); d != nil {
+			err = d.Notify(req)
+		}
+		if err != nil {
+			log.Info().Err(err).Msg("Fail to read NOTIFY")
+		}
+		tx.Respond(notifyResponse(req, err))
+	})
+
 	for _, l := range listeners {
 		log.Info().Str("network", l.Network).Str("addr", l.Addr).Msg("Listening on")
 		go l.Listen()
@@ -1165,8 +1643,23 @@ func (p *Phone) answer(ansCtx context.Context, opts AnswerOptions) (*DialogServe
 	log.Info().Msg("Waiting for INVITE...")
 	select {
 	case d = <-waitDialog:
+		if d.MediaSession != nil {
+			p.iceConnect(ctx, d.MediaSession)
+		}
+
 		// Make sure we have cleanup after dialog stop
-		d.onClose = stopAnswer
+		callID := d.InviteRequest.CallID().Value()
+		if d.InviteResponse.IsSuccess() {
+			p.saveDialog(false, d.InviteRequest, d.InviteResponse, 0)
+			d.onRequest = p.dialogCSeqSaver(false, d.InviteRequest, d.InviteResponse)
+		}
+		dialog := d
+		notifyDialog.Store(d)
+		d.onClose = func() {
+			stopAnswer()
+			p.untapCall(callID)
+			p.deleteDialog(dialog.InviteResponse)
+		}
 		return d, nil
 	case <-ctx.Done():
 		// Check is this caller stopped answer
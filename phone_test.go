@@ -0,0 +1,331 @@
+package sipgox
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/emiago/sipgo"
+	"github.com/emiago/sipgo/sip"
+	"github.com/emiago/sipgox/sdp"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeSIPPeer is remote side of call which writes and reads raw SIP over UDP
+type fakeSIPPeer struct {
+	t      *testing.T
+	conn   *net.UDPConn
+	callID string
+	// toTag is tag of phone side, set from response
+	toTag  string
+	branch int
+}
+
+func newFakeSIPPeer(t *testing.T) *fakeSIPPeer {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return &fakeSIPPeer{t: t, conn: conn, callID: sip.RandString(16)}
+}
+
+func (p *fakeSIPPeer) addr() string {
+	return p.conn.LocalAddr().String()
+}
+
+// request builds request of peer within call. Phone side is target
+func (p *fakeSIPPeer) request(method sip.RequestMethod, target string, cseq int, body []byte) string {
+	p.branch++
+	to := "<sip:alice@" + target + ">"
+	if p.toTag != "" {
+		to += ";tag=" + p.toTag
+	}
+	msg := fmt.Sprintf("%s sip:alice@%s SIP/2.0\r\n"+
+		"Via: SIP/2.0/UDP %s;branch=z9hG4bK.%s.%d\r\n"+
+		"From: <sip:bob@%s>;tag=peer\r\n"+
+		"To: %s\r\n"+
+		"Call-ID: %s\r\n"+
+		"CSeq: %d %s\r\n"+
+		"Contact: <sip:bob@%s>\r\n"+
+		"Max-Forwards: 70\r\n",
+		method, target, p.addr(), p.callID, p.branch, p.addr(), to, p.callID, cseq, method, p.addr())
+	if len(body) > 0 {
+		msg += "Content-Type: application/sdp\r\n"
+	}
+	return msg + fmt.Sprintf("Content-Length: %d\r\n\r\n", len(body)) + string(body)
+}
+
+// response builds response on request received from phone
+func (p *fakeSIPPeer) response(req *sip.Request, code sip.StatusCode, reason string, body []byte) string {
+	res := sip.NewResponseFromRequest(req, code, reason, body)
+	if code != sip.StatusTrying {
+		res.To().Params.Add("tag", "peer")
+	}
+	res.AppendHeader(&sip.ContactHeader{Address: sip.Uri{User: "bob", Host: "127.0.0.1", Port: p.conn.LocalAddr().(*net.UDPAddr).Port}})
+	if len(body) > 0 {
+		res.AppendHeader(sip.NewHeader("Content-Type", "application/sdp"))
+	}
+	return res.String()
+}
+
+func (p *fakeSIPPeer) send(raddr string, msg string) {
+	addr, err := net.ResolveUDPAddr("udp", raddr)
+	require.NoError(p.t, err)
+	_, err = p.conn.WriteToUDP([]byte(msg), addr)
+	require.NoError(p.t, err)
+}
+
+// read returns next message and address of sender
+func (p *fakeSIPPeer) read() (sip.Message, string) {
+	buf := make([]byte, 65535)
+	p.conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	n, raddr, err := p.conn.ReadFromUDP(buf)
+	require.NoError(p.t, err)
+	msg, err := sip.ParseMessage(buf[:n])
+	require.NoError(p.t, err)
+	return msg, raddr.String()
+}
+
+// readResponse skips messages until final response of CSeq method
+func (p *fakeSIPPeer) readResponse(method sip.RequestMethod) *sip.Response {
+	for {
+		msg, _ := p.read()
+		res, ok := msg.(*sip.Response)
+		if !ok || res.CSeq().MethodName != method || res.IsProvisional() {
+			continue
+		}
+		if tag, ok := res.To().Params.Get("tag"); ok {
+			p.toTag = tag
+		}
+		return res
+	}
+}
+
+// readRequest skips messages until request with method
+func (p *fakeSIPPeer) readRequest(method sip.RequestMethod) (*sip.Request, string) {
+	for {
+		msg, raddr := p.read()
+		if req, ok := msg.(*sip.Request); ok && req.Method == method {
+			return req, raddr
+		}
+	}
+}
+
+func freeUDPAddr(t *testing.T) string {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	defer conn.Close()
+	return conn.LocalAddr().String()
+}
+
+// answerFakeCall answers INVITE with offer sent by peer to phone listening on addr
+func answerFakeCall(t *testing.T, phone *Phone, addr string, peer *fakeSIPPeer, offer []byte, opts AnswerOptions) *DialogServerSession {
+	ready := make(AnswerReadyCtxValue)
+	ctx, cancel := context.WithTimeout(context.WithValue(context.Background(), AnswerReadyCtxKey, ready), 5*time.Second)
+	t.Cleanup(cancel)
+
+	type answered struct {
+		d   *DialogServerSession
+		err error
+	}
+	done := make(chan answered, 1)
+	go func() {
+		d, err := phone.Answer(ctx, opts)
+		done <- answered{d, err}
+	}()
+	<-ready
+
+	peer.send(addr, peer.request(sip.INVITE, addr, 1, offer))
+	res := peer.readResponse(sip.INVITE)
+	require.Equal(t, sip.StatusOK, res.StatusCode)
+	peer.send(addr, peer.request(sip.ACK, addr, 1, nil))
+
+	a := <-done
+	require.NoError(t, a.err)
+	t.Cleanup(func() { a.d.Close() })
+	return a.d
+}
+
+func newTestPhone(t *testing.T, options ...PhoneOption) (*Phone, string) {
+	ua, err := sipgo.NewUA()
+	require.NoError(t, err)
+	t.Cleanup(func() { ua.Close() })
+	addr := freeUDPAddr(t)
+	options = append(options, WithPhoneListenAddr(ListenAddr{Network: "udp", Addr: addr}))
+	return NewPhone(ua, options...), addr
+}
+
+func TestPhoneAnswerReInvite(t *testing.T) {
+	phone, addr := newTestPhone(t)
+	peer := newFakeSIPPeer(t)
+	ip := net.IPv4(127, 0, 0, 1)
+	d := answerFakeCall(t, phone, addr, peer, sdp.GenerateForAudio(ip, ip, 4000, sdp.ModeSendrecv, sdp.NewFormats("0")), AnswerOptions{})
+	require.Equal(t, 4000, d.MediaSession.Raddr.Port)
+
+	// Remote moves media and puts call on hold
+	peer.send(addr, peer.request(sip.INVITE, addr, 2, sdp.GenerateForAudio(ip, ip, 5000, sdp.ModeSendonly, sdp.NewFormats("0"))))
+	res := peer.readResponse(sip.INVITE)
+	require.Equal(t, sip.StatusOK, res.StatusCode)
+	require.Equal(t, "application/sdp", res.ContentType().Value())
+	require.Contains(t, string(res.Body()), "a=recvonly")
+	require.Contains(t, string(res.Body()), fmt.Sprintf("m=audio %d ", d.MediaSession.Laddr.Port))
+	require.Equal(t, 5000, d.MediaSession.Raddr.Port)
+	require.Equal(t, sdp.ModeRecvonly, d.MediaSession.Direction())
+
+	// Offer which can not be negotiated is rejected
+	peer.send(addr, peer.request(sip.INVITE, addr, 3, sdp.GenerateForAudio(ip, ip, 5000, sdp.ModeSendrecv, sdp.NewFormats("9"))))
+	res = peer.readResponse(sip.INVITE)
+	require.Equal(t, sip.StatusNotAcceptableHere, res.StatusCode)
+}
+
+func TestPhoneAnswerReInviteNACK(t *testing.T) {
+	phone, addr := newTestPhone(t)
+	peer := newFakeSIPPeer(t)
+	ip := net.IPv4(127, 0, 0, 1)
+	offer := sdp.GenerateForAudio(ip, ip, 4000, sdp.ModeSendrecv, sdp.NewFormats("0"))
+	d := answerFakeCall(t, phone, addr, peer, offer, AnswerOptions{})
+	d.MediaSession.NACK = true
+	require.False(t, d.MediaSession.nackNegotiated.Load())
+
+	// Remote starts supporting NACK
+	peer.send(addr, peer.request(sip.INVITE, addr, 2, sdp.AppendRTCPFeedback(offer, "*", sdp.RTCPFeedbackNACK)))
+	res := peer.readResponse(sip.INVITE)
+	require.Equal(t, sip.StatusOK, res.StatusCode)
+	require.Contains(t, string(res.Body()), "a=rtcp-fb:* nack")
+	require.True(t, d.MediaSession.nackNegotiated.Load())
+
+	// and stops
+	peer.send(addr, peer.request(sip.INVITE, addr, 3, offer))
+	res = peer.readResponse(sip.INVITE)
+	require.Equal(t, sip.StatusOK, res.StatusCode)
+	require.NotContains(t, string(res.Body()), "rtcp-fb")
+	require.False(t, d.MediaSession.nackNegotiated.Load())
+}
+
+// hookRecorder records messages passed to MessageHooks as "in INVITE" or "out 200"
+type hookRecorder struct {
+	mu   sync.Mutex
+	msgs []string
+}
+
+func (r *hookRecorder) hooks() MessageHooks {
+	record := func(dir string) func(msg sip.Message) {
+		return func(msg sip.Message) {
+			label := ""
+			switch m := msg.(type) {
+			case *sip.Request:
+				label = m.Method.String()
+			case *sip.Response:
+				label = fmt.Sprint(int(m.StatusCode))
+			}
+			r.mu.Lock()
+			defer r.mu.Unlock()
+			r.msgs = append(r.msgs, dir+" "+label)
+		}
+	}
+	return MessageHooks{OnOutgoing: record("out"), OnIncoming: record("in")}
+}
+
+func (r *hookRecorder) recorded() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string{}, r.msgs...)
+}
+
+func TestPhoneMessageHooks(t *testing.T) {
+	ip := net.IPv4(127, 0, 0, 1)
+	offer := sdp.GenerateForAudio(ip, ip, 4000, sdp.ModeSendrecv, sdp.NewFormats("0"))
+
+	t.Run("Answer", func(t *testing.T) {
+		rec := &hookRecorder{}
+		phone, addr := newTestPhone(t, WithPhoneMessageHooks(rec.hooks()))
+		peer := newFakeSIPPeer(t)
+		answerFakeCall(t, phone, addr, peer, offer, AnswerOptions{})
+
+		peer.send(addr, peer.request(sip.INVITE, addr, 2, offer))
+		peer.readResponse(sip.INVITE)
+		// Dialog expects BYE right after INVITE CSeq, as re-INVITE is not tracked by it
+		peer.send(addr, peer.request(sip.BYE, addr, 2, nil))
+		peer.readResponse(sip.BYE)
+		require.Equal(t, []string{"in INVITE", "out 100", "out 200", "in ACK", "in INVITE", "out 200", "in BYE"}, rec.recorded())
+	})
+
+	t.Run("Dial", func(t *testing.T) {
+		rec := &hookRecorder{}
+		phone, _ := newTestPhone(t, WithPhoneMessageHooks(rec.hooks()))
+		peer := newFakeSIPPeer(t)
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		dialed := make(chan error, 1)
+		go func() {
+			d, err := phone.Dial(ctx, sip.Uri{User: "bob", Host: "127.0.0.1", Port: peer.conn.LocalAddr().(*net.UDPAddr).Port}, DialOptions{})
+			if err == nil {
+				d.Close()
+			}
+			dialed <- err
+		}()
+
+		invite, raddr := peer.readRequest(sip.INVITE)
+		peer.send(raddr, peer.response(invite, sip.StatusRinging, "Ringing", nil))
+		// Responses are handled concurrently, so 200 could overtake 180
+		time.Sleep(50 * time.Millisecond)
+		peer.send(raddr, peer.response(invite, sip.StatusOK, "OK", offer))
+		peer.readRequest(sip.ACK)
+		require.NoError(t, <-dialed)
+		require.Equal(t, []string{"out INVITE", "in 180", "in 200"}, rec.recorded())
+	})
+}
+
+func TestPhonePassthroughSDP(t *testing.T) {
+	ip := net.IPv4(127, 0, 0, 1)
+	offer := sdp.GenerateForAudio(ip, ip, 4000, sdp.ModeSendrecv, sdp.NewFormats("0", "8"))
+	answer := sdp.GenerateForAudio(ip, ip, 6000, sdp.ModeSendrecv, sdp.NewFormats("8"))
+
+	t.Run("Answer", func(t *testing.T) {
+		phone, addr := newTestPhone(t)
+		peer := newFakeSIPPeer(t)
+		var received []byte
+		d := answerFakeCall(t, phone, addr, peer, offer, AnswerOptions{
+			OnPassthroughSDP: func(o []byte) ([]byte, error) {
+				received = o
+				return answer, nil
+			},
+		})
+		require.Equal(t, string(offer), string(received))
+		require.Equal(t, string(answer), string(d.InviteResponse.Body()))
+		require.Nil(t, d.MediaSession)
+	})
+
+	t.Run("Dial", func(t *testing.T) {
+		phone, _ := newTestPhone(t)
+		peer := newFakeSIPPeer(t)
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		type dialed struct {
+			d   *DialogClientSession
+			err error
+		}
+		done := make(chan dialed, 1)
+		go func() {
+			d, err := phone.Dial(ctx, sip.Uri{User: "bob", Host: "127.0.0.1", Port: peer.conn.LocalAddr().(*net.UDPAddr).Port}, DialOptions{
+				PassthroughSDP: offer,
+			})
+			done <- dialed{d, err}
+		}()
+
+		invite, raddr := peer.readRequest(sip.INVITE)
+		require.Equal(t, string(offer), string(invite.Body()))
+		peer.send(raddr, peer.response(invite, sip.StatusOK, "OK", answer))
+		peer.readRequest(sip.ACK)
+
+		res := <-done
+		require.NoError(t, res.err)
+		defer res.d.Close()
+		require.Equal(t, string(answer), string(res.d.InviteResponse.Body()))
+		require.Nil(t, res.d.MediaSession)
+	})
+}
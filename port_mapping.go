@@ -0,0 +1,185 @@
+package sipgox
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// DefaultPortMappingLifetime is lifetime requested for port mappings. Mappings are renewed at half of lifetime
+var DefaultPortMappingLifetime = 2 * time.Hour
+
+// PortMapper requests UDP port mappings from local gateway. See NATPMPClient and UPnPClient
+type PortMapper interface {
+	// MapPort maps internal address on gateway and returns external address
+	MapPort(ctx context.Context, internal *net.UDPAddr, lifetime time.Duration) (*net.UDPAddr, error)
+	// UnmapPort removes mapping of internal address
+	UnmapPort(ctx context.Context, internal *net.UDPAddr) error
+}
+
+// PortMappingAllocator maps RTP and RTCP ports on gateway after they are allocated
+// and removes mappings on release.
+// Mapping failure does not fail allocation, media stays unmapped and failure is reported
+// to MediaEngineDefault OnError
+type PortMappingAllocator struct {
+	// Allocator creates sockets. DefaultListenerAllocator is used if nil
+	Allocator ListenerAllocator
+	Mapper    PortMapper
+	// Lifetime of mapping. DefaultPortMappingLifetime is used if 0
+	Lifetime time.Duration
+	// Timeout of mapping both ports on allocation, and of single renew or unmap request.
+	// Allocation waits for it at most once. Default 2s
+	Timeout time.Duration
+
+	mu     sync.Mutex
+	mapped map[string]*portMapping
+}
+
+type portMapping struct {
+	internal *net.UDPAddr
+	external *net.UDPAddr
+	renew    *time.Timer
+}
+
+func (a *PortMappingAllocator) Allocate(laddr *net.UDPAddr) (net.PacketConn, net.PacketConn, error) {
+	alloc := a.Allocator
+	if alloc == nil {
+		alloc = DefaultListenerAllocator
+	}
+
+	rtpConn, rtcpConn, err := alloc.Allocate(laddr)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	internals := make([]*net.UDPAddr, 0, 2)
+	for _, c := range []net.PacketConn{rtpConn, rtcpConn} {
+		internal, ok := c.LocalAddr().(*net.UDPAddr)
+		if !ok {
+			rtpConn.Close()
+			rtcpConn.Close()
+			alloc.Release(rtpConn, rtcpConn)
+			return nil, nil, fmt.Errorf("port mapping: media connection %s is not udp", c.LocalAddr())
+		}
+		internals = append(internals, internal)
+	}
+
+	// Ports are mapped in parallel with shared deadline
+	ctx, cancel := a.context()
+	defer cancel()
+	wg := sync.WaitGroup{}
+	for _, internal := range internals {
+		wg.Add(1)
+		go func(internal *net.UDPAddr) {
+			defer wg.Done()
+			if err := a.mapPort(ctx, internal); err != nil {
+				log.Warn().Err(err).Str("laddr", internal.String()).Msg("Fail to map media port on gateway")
+				MediaEngineDefault.reportError(&BackgroundError{Source: BackgroundPortMapping, Addr: internal.String(), Err: err})
+			}
+		}(internal)
+	}
+	wg.Wait()
+	return rtpConn, rtcpConn, nil
+}
+
+func (a *PortMappingAllocator) Release(rtpConn net.PacketConn, rtcpConn net.PacketConn) {
+	for _, c := range []net.PacketConn{rtpConn, rtcpConn} {
+		if internal, ok := c.LocalAddr().(*net.UDPAddr); ok {
+			a.unmapPort(internal)
+		}
+	}
+
+	alloc := a.Allocator
+	if alloc == nil {
+		alloc = DefaultListenerAllocator
+	}
+	alloc.Release(rtpConn, rtcpConn)
+}
+
+// ExternalAddr returns mapped external address of local address or nil if port is not mapped
+func (a *PortMappingAllocator) ExternalAddr(laddr *net.UDPAddr) *net.UDPAddr {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	m, exists := a.mapped[laddr.String()]
+	if !exists {
+		return nil
+	}
+	return m.external
+}
+
+func (a *PortMappingAllocator) lifetime() time.Duration {
+	if a.Lifetime > 0 {
+		return a.Lifetime
+	}
+	return DefaultPortMappingLifetime
+}
+
+func (a *PortMappingAllocator) context() (context.Context, context.CancelFunc) {
+	timeout := a.Timeout
+	if timeout == 0 {
+		timeout = 2 * time.Second
+	}
+	return context.WithTimeout(context.Background(), timeout)
+}
+
+func (a *PortMappingAllocator) mapPort(ctx context.Context, internal *net.UDPAddr) error {
+	lifetime := a.lifetime()
+	external, err := a.Mapper.MapPort(ctx, internal, lifetime)
+	if err != nil {
+		return err
+	}
+
+	m := &portMapping{
+		internal: internal,
+		external: external,
+	}
+	m.renew = time.AfterFunc(lifetime/2, func() { a.renewPort(m) })
+
+	a.mu.Lock()
+	if a.mapped == nil {
+		a.mapped = make(map[string]*portMapping)
+	}
+	a.mapped[internal.String()] = m
+	a.mu.Unlock()
+	return nil
+}
+
+func (a *PortMappingAllocator) renewPort(m *portMapping) {
+	a.mu.Lock()
+	_, exists := a.mapped[m.internal.String()]
+	a.mu.Unlock()
+	if !exists {
+		return
+	}
+
+	ctx, cancel := a.context()
+	defer cancel()
+
+	lifetime := a.lifetime()
+	if _, err := a.Mapper.MapPort(ctx, m.internal, lifetime); err != nil {
+		log.Warn().Err(err).Str("laddr", m.internal.String()).Msg("Fail to renew media port mapping")
+		MediaEngineDefault.reportError(&BackgroundError{Source: BackgroundPortMapping, Addr: m.internal.String(), Err: err})
+	}
+	m.renew.Reset(lifetime / 2)
+}
+
+func (a *PortMappingAllocator) unmapPort(internal *net.UDPAddr) {
+	a.mu.Lock()
+	m, exists := a.mapped[internal.String()]
+	delete(a.mapped, internal.String())
+	a.mu.Unlock()
+	if !exists {
+		return
+	}
+	m.renew.Stop()
+
+	ctx, cancel := a.context()
+	defer cancel()
+	if err := a.Mapper.UnmapPort(ctx, internal); err != nil {
+		log.Warn().Err(err).Str("laddr", internal.String()).Msg("Fail to remove media port mapping")
+	}
+}
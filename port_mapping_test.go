@@ -0,0 +1,264 @@
+package sipgox
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func natpmpTestGateway(t *testing.T) (*net.UDPConn, *sync.Map) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	mappings := &sync.Map{}
+	go func() {
+		buf := make([]byte, 64)
+		for {
+			n, raddr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			req := buf[:n]
+			switch req[1] {
+			case natpmpOpExternalAddr:
+				res := []byte{0, 128, 0, 0, 0, 0, 0, 1, 198, 51, 100, 7}
+				conn.WriteTo(res, raddr)
+			case natpmpOpMapUDP:
+				internal := binary.BigEndian.Uint16(req[4:6])
+				lifetime := binary.BigEndian.Uint32(req[8:12])
+				if lifetime == 0 {
+					mappings.Delete(internal)
+				} else {
+					mappings.Store(internal, lifetime)
+				}
+				res := make([]byte, 16)
+				res[1] = 128 + natpmpOpMapUDP
+				copy(res[8:10], req[4:6])
+				// External port is shifted
+				binary.BigEndian.PutUint16(res[10:12], internal+10000)
+				copy(res[12:16], req[8:12])
+				conn.WriteTo(res, raddr)
+			}
+		}
+	}()
+	return conn, mappings
+}
+
+func TestNATPMPClient(t *testing.T) {
+	gw, mappings := natpmpTestGateway(t)
+	c := &NATPMPClient{
+		Gateway: net.IPv4(127, 0, 0, 1),
+		port:    gw.LocalAddr().(*net.UDPAddr).Port,
+	}
+
+	ctx := context.Background()
+	ip, err := c.ExternalIP(ctx)
+	require.NoError(t, err)
+	require.Equal(t, "198.51.100.7", ip.String())
+
+	ext, err := c.MapPort(ctx, &net.UDPAddr{Port: 20000}, time.Hour)
+	require.NoError(t, err)
+	require.Equal(t, "198.51.100.7:30000", ext.String())
+	lifetime, _ := mappings.Load(uint16(20000))
+	require.Equal(t, uint32(3600), lifetime)
+
+	require.NoError(t, c.UnmapPort(ctx, &net.UDPAddr{Port: 20000}))
+	_, exists := mappings.Load(uint16(20000))
+	require.False(t, exists)
+}
+
+func TestNATPMPClientTimeout(t *testing.T) {
+	// Nothing answers on this port
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	defer conn.Close()
+
+	c := &NATPMPClient{
+		Gateway: net.IPv4(127, 0, 0, 1),
+		port:    conn.LocalAddr().(*net.UDPAddr).Port,
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	_, err = c.ExternalIP(ctx)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestUPnPClient(t *testing.T) {
+	mu := sync.Mutex{}
+	actions := []string{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/desc.xml", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<?xml version="1.0"?>
+<root xmlns="urn:schemas-upnp-org:device-1-0">
+<device><deviceList><device><deviceList><device>
+<serviceList><service>
+<serviceType>urn:schemas-upnp-org:service:WANIPConnection:1</serviceType>
+<controlURL>/ctl/IPConn</controlURL>
+</service></serviceList>
+</device></deviceList></device></deviceList></device>
+</root>`)
+	})
+	mux.HandleFunc("/ctl/IPConn", func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		action := r.Header.Get("SOAPAction")
+		mu.Lock()
+		actions = append(actions, action)
+		mu.Unlock()
+
+		switch {
+		case strings.HasSuffix(action, `#GetExternalIPAddress"`):
+			fmt.Fprint(w, `<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/"><s:Body>
+<u:GetExternalIPAddressResponse><NewExternalIPAddress>203.0.113.5</NewExternalIPAddress></u:GetExternalIPAddressResponse>
+</s:Body></s:Envelope>`)
+		case strings.HasSuffix(action, `#AddPortMapping"`):
+			require.Contains(t, string(body), "<NewInternalClient>192.168.1.20</NewInternalClient>")
+			require.Contains(t, string(body), "<NewLeaseDuration>60</NewLeaseDuration>")
+		case strings.HasSuffix(action, `#DeletePortMapping"`):
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprint(w, `<s:Envelope><s:Body><s:Fault><detail><UPnPError>
+<errorCode>714</errorCode><errorDescription>NoSuchEntryInArray</errorDescription>
+</UPnPError></detail></s:Fault></s:Body></s:Envelope>`)
+		}
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	ctx := context.Background()
+	c, err := NewUPnPClient(ctx, srv.URL+"/desc.xml")
+	require.NoError(t, err)
+	require.Equal(t, srv.URL+"/ctl/IPConn", c.ControlURL)
+	require.Equal(t, "urn:schemas-upnp-org:service:WANIPConnection:1", c.ServiceType)
+
+	c.LocalIP = net.IPv4(192, 168, 1, 20)
+	ext, err := c.MapPort(ctx, &net.UDPAddr{IP: net.IPv4zero, Port: 20000}, time.Minute)
+	require.NoError(t, err)
+	require.Equal(t, "203.0.113.5:20000", ext.String())
+
+	err = c.UnmapPort(ctx, &net.UDPAddr{Port: 20000})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "714")
+	require.Len(t, actions, 3)
+}
+
+type testPortMapper struct {
+	mu     sync.Mutex
+	mapped map[int]int
+}
+
+func (m *testPortMapper) MapPort(ctx context.Context, internal *net.UDPAddr, lifetime time.Duration) (*net.UDPAddr, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.mapped[internal.Port]++
+	return &net.UDPAddr{IP: net.IPv4(198, 51, 100, 1), Port: internal.Port}, nil
+}
+
+func (m *testPortMapper) UnmapPort(ctx context.Context, internal *net.UDPAddr) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.mapped, internal.Port)
+	return nil
+}
+
+func TestPortMappingAllocator(t *testing.T) {
+	mapper := &testPortMapper{mapped: map[int]int{}}
+	alloc := &PortMappingAllocator{Mapper: mapper, Lifetime: 100 * time.Millisecond}
+
+	sess, err := NewMediaSession(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, alloc)
+	require.NoError(t, err)
+
+	ext := alloc.ExternalAddr(sess.Laddr)
+	require.NotNil(t, ext)
+	require.Equal(t, "198.51.100.1", ext.IP.String())
+	require.Equal(t, sess.Laddr.Port, ext.Port)
+
+	// Mappings are renewed at half lifetime
+	time.Sleep(120 * time.Millisecond)
+	mapper.mu.Lock()
+	require.GreaterOrEqual(t, mapper.mapped[sess.Laddr.Port], 2)
+	require.Len(t, mapper.mapped, 2)
+	mapper.mu.Unlock()
+
+	sess.Close()
+	mapper.mu.Lock()
+	require.Len(t, mapper.mapped, 0)
+	mapper.mu.Unlock()
+	require.Nil(t, alloc.ExternalAddr(sess.Laddr))
+}
+
+// blockingPortMapper does not answer until request is canceled
+type blockingPortMapper struct{}
+
+func (blockingPortMapper) MapPort(ctx context.Context, internal *net.UDPAddr, lifetime time.Duration) (*net.UDPAddr, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func (blockingPortMapper) UnmapPort(ctx context.Context, internal *net.UDPAddr) error {
+	return nil
+}
+
+func TestPortMappingAllocatorTimeout(t *testing.T) {
+	errs := make(chan *BackgroundError, 2)
+	MediaEngineDefault.OnError(func(err *BackgroundError) { errs <- err })
+	defer MediaEngineDefault.OnError(nil)
+
+	alloc := &PortMappingAllocator{Mapper: blockingPortMapper{}, Timeout: 100 * time.Millisecond}
+	start := time.Now()
+	sess, err := NewMediaSession(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, alloc)
+	require.NoError(t, err)
+	defer sess.Close()
+
+	// Both ports share deadline
+	require.Less(t, time.Since(start), 190*time.Millisecond)
+	require.Nil(t, alloc.ExternalAddr(sess.Laddr))
+	for i := 0; i < 2; i++ {
+		err := <-errs
+		require.Equal(t, BackgroundPortMapping, err.Source)
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+	}
+}
+
+// tcpListenerAllocator allocates TCP media connections
+type tcpListenerAllocator struct{}
+
+func (tcpListenerAllocator) Allocate(laddr *net.UDPAddr) (net.PacketConn, net.PacketConn, error) {
+	rtpConn, err := listenTCPMedia(&net.TCPAddr{IP: laddr.IP})
+	if err != nil {
+		return nil, nil, err
+	}
+	rtcpConn, err := listenTCPMedia(&net.TCPAddr{IP: laddr.IP})
+	if err != nil {
+		rtpConn.Close()
+		return nil, nil, err
+	}
+	return rtpConn, rtcpConn, nil
+}
+
+func (tcpListenerAllocator) Release(rtpConn net.PacketConn, rtcpConn net.PacketConn) {}
+
+func TestPortMappingAllocatorNotUDP(t *testing.T) {
+	mapper := &testPortMapper{mapped: map[int]int{}}
+	alloc := &PortMappingAllocator{Mapper: mapper, Allocator: tcpListenerAllocator{}}
+
+	_, _, err := alloc.Allocate(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.Error(t, err)
+	require.Len(t, mapper.mapped, 0)
+
+	// Release skips connections which were not mapped
+	rtpConn, rtcpConn, err := tcpListenerAllocator{}.Allocate(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	defer rtpConn.Close()
+	defer rtcpConn.Close()
+	alloc.Release(rtpConn, rtcpConn)
+}
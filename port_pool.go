@@ -0,0 +1,106 @@
+package sipgox
+
+import (
+	"fmt"
+	"net"
+	"sync"
+)
+
+// PortPool hands out RTP/RTCP port pairs from range [Start, End).
+// RTP port is always even and RTCP is RTP port + 1.
+// Multiple pools can exist per process, but ranges should not overlap.
+// PortPool is ListenerAllocator
+type PortPool struct {
+	start int
+	end   int
+
+	// SocketOptions are applied on RTP and RTCP sockets. Set before first Allocate
+	SocketOptions *MediaSocketOptions
+
+	mu     sync.Mutex
+	offset int // next pair index
+	inUse  map[int]struct{}
+}
+
+// NewPortPool creates pool for range [start, end). Start is rounded up to even port
+func NewPortPool(start int, end int) (*PortPool, error) {
+	if start%2 != 0 {
+		start++
+	}
+	if start <= 0 || end > 65536 || end-start < 2 {
+		return nil, fmt.Errorf("invalid port range %d:%d", start, end)
+	}
+
+	return &PortPool{
+		start: start,
+		end:   end,
+		inUse: make(map[int]struct{}),
+	}, nil
+}
+
+// Range returns pool port range
+func (p *PortPool) Range() (start int, end int) {
+	return p.start, p.end
+}
+
+// Allocate opens RTP and RTCP listeners on next free pair of ports.
+// If laddr has port set, it is used directly and not tracked by pool.
+// Ports must be returned with Release once connections are closed
+func (p *PortPool) Allocate(laddr *net.UDPAddr) (net.PacketConn, net.PacketConn, error) {
+	if laddr.Port != 0 {
+		return listenRTPandRTCP(laddr, p.SocketOptions)
+	}
+	return p.listen(laddr.IP)
+}
+
+func (p *PortPool) listen(ip net.IP) (rtpConn *net.UDPConn, rtcpConn *net.UDPConn, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	pairs := (p.end - p.start) / 2
+	for i := 0; i < pairs; i++ {
+		port := p.start + 2*((p.offset+i)%pairs)
+		if _, exists := p.inUse[port]; exists {
+			continue
+		}
+
+		rtpConn, err = p.SocketOptions.listenUDP(&net.UDPAddr{IP: ip, Port: port})
+		if err != nil {
+			continue
+		}
+
+		rtcpConn, err = p.SocketOptions.listenUDP(&net.UDPAddr{IP: ip, Port: port + 1})
+		if err != nil {
+			rtpConn.Close()
+			continue
+		}
+
+		p.inUse[port] = struct{}{}
+		// Continue from next pair so that we use more from range
+		p.offset = ((port-p.start)/2 + 1) % pairs
+		return rtpConn, rtcpConn, nil
+	}
+
+	if err == nil {
+		err = fmt.Errorf("all ports in use")
+	}
+	return nil, nil, fmt.Errorf("no available ports in range %d:%d: %w", p.start, p.end, err)
+}
+
+// Release returns ports of connections back to pool
+func (p *PortPool) Release(rtpConn net.PacketConn, rtcpConn net.PacketConn) {
+	addr, ok := rtpConn.LocalAddr().(*net.UDPAddr)
+	if !ok {
+		return
+	}
+	p.mu.Lock()
+	delete(p.inUse, addr.Port)
+	p.mu.Unlock()
+}
+
+// InUse returns number of port pairs handed out
+func (p *PortPool) InUse() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.inUse)
+}
@@ -0,0 +1,126 @@
+package sipgox
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/emiago/sipgo/sip"
+)
+
+// PeerQuirks are interop workarounds for PBX or SBC which does not follow standards.
+// They are applied to negotiation and media of calls with peer. See WithPhonePeerQuirks
+type PeerQuirks struct {
+	Name string
+	// NoRTCPMux removes a=rtcp-mux from sent SDP, including passthrough SDP
+	NoRTCPMux bool
+	// RequirePtime always advertises a=ptime, 20ms if session has no Ptime
+	RequirePtime bool
+	// DTMFPayloadType is payload type peer sends DTMF with, regardless of negotiated one.
+	// Zero uses negotiated payload type
+	DTMFPayloadType uint8
+	// ContactNoTransport sends Contact without transport parameter
+	ContactNoTransport bool
+}
+
+// Peer quirks names
+const (
+	PeerQuirksAvaya     = "avaya"
+	PeerQuirksCisco     = "cisco"
+	PeerQuirksLegacyPBX = "legacy-pbx"
+)
+
+// PeerQuirkProfiles are built in quirks by name. Applications can add own profiles before calls are made
+var PeerQuirkProfiles = map[string]PeerQuirks{
+	PeerQuirksAvaya: {
+		Name:         PeerQuirksAvaya,
+		RequirePtime: true,
+	},
+	PeerQuirksCisco: {
+		Name:               PeerQuirksCisco,
+		NoRTCPMux:          true,
+		ContactNoTransport: true,
+	},
+	PeerQuirksLegacyPBX: {
+		Name:               PeerQuirksLegacyPBX,
+		NoRTCPMux:          true,
+		RequirePtime:       true,
+		DTMFPayloadType:    101,
+		ContactNoTransport: true,
+	},
+}
+
+// LookupPeerQuirks returns quirks profile by name
+func LookupPeerQuirks(name string) (PeerQuirks, error) {
+	q, exists := PeerQuirkProfiles[name]
+	if !exists {
+		return q, fmt.Errorf("peer quirks %q not found", name)
+	}
+	return q, nil
+}
+
+// WithPhonePeerQuirks applies quirks to calls with peer host, matched with Dial recipient
+// and source of incoming INVITE. Quirks in DialOptions and AnswerOptions take precedence
+func WithPhonePeerQuirks(host string, q PeerQuirks) PhoneOption {
+	return func(p *Phone) {
+		if p.peerQuirks == nil {
+			p.peerQuirks = map[string]PeerQuirks{}
+		}
+		p.peerQuirks[host] = q
+	}
+}
+
+// quirksFor returns quirks of call. Explicit quirks are used if not nil
+func (p *Phone) quirksFor(explicit *PeerQuirks, host string) PeerQuirks {
+	if explicit != nil {
+		return *explicit
+	}
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return p.peerQuirks[host]
+}
+
+// Apply configures session with quirks. It must be called before session SDP is created
+func (q PeerQuirks) Apply(s *MediaSession) {
+	if q.RequirePtime && s.Ptime == 0 {
+		s.Ptime = 20 * time.Millisecond
+	}
+	if q.DTMFPayloadType != 0 {
+		s.DTMFReceivePayloadType = q.DTMFPayloadType
+	}
+}
+
+// SDP returns SDP body to send to peer
+func (q PeerQuirks) SDP(body []byte) []byte {
+	if !q.NoRTCPMux || body == nil {
+		return body
+	}
+	lines := strings.Split(strings.TrimRight(string(body), "\r\n"), "\r\n")
+	kept := lines[:0]
+	for _, l := range lines {
+		if l != "a=rtcp-mux" {
+			kept = append(kept, l)
+		}
+	}
+	return []byte(strings.Join(kept, "\r\n") + "\r\n")
+}
+
+// Contact returns contact to send to peer
+func (q PeerQuirks) Contact(c sip.ContactHeader) sip.ContactHeader {
+	if !q.ContactNoTransport {
+		return c
+	}
+	c = *c.Clone()
+	if c.Params != nil {
+		c.Params.Remove("transport")
+	}
+	if c.Address.UriParams != nil {
+		c.Address.UriParams.Remove("transport")
+	}
+	if c.Address.Headers != nil {
+		c.Address.Headers.Remove("transport")
+	}
+	return c
+}
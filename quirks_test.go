@@ -0,0 +1,71 @@
+package sipgox
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/emiago/sipgo/sip"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPeerQuirks(t *testing.T) {
+	q, err := LookupPeerQuirks(PeerQuirksLegacyPBX)
+	require.NoError(t, err)
+	_, err = LookupPeerQuirks("unknown")
+	require.Error(t, err)
+
+	body := []byte("v=0\r\nm=audio 1000 RTP/AVP 0\r\na=rtcp-mux\r\na=sendrecv\r\n")
+	require.Equal(t, "v=0\r\nm=audio 1000 RTP/AVP 0\r\na=sendrecv\r\n", string(q.SDP(body)))
+	require.Equal(t, body, PeerQuirks{}.SDP(body))
+
+	contact := sip.ContactHeader{
+		Address: sip.Uri{User: "alice", Host: "127.0.0.1", Port: 5060, UriParams: sip.HeaderParams{"transport": "tcp"}},
+		Params:  sip.HeaderParams{"transport": "tcp"},
+	}
+	c := q.Contact(contact)
+	require.Equal(t, "<sip:alice@127.0.0.1:5060>", c.Value())
+	// Original is not modified
+	require.Equal(t, "tcp", contact.Params["transport"])
+
+	p := &Phone{}
+	WithPhonePeerQuirks("10.0.0.1", q)(p)
+	require.Equal(t, q, p.quirksFor(nil, "10.0.0.1:5060"))
+	require.Equal(t, PeerQuirks{}, p.quirksFor(nil, "10.0.0.2"))
+	require.Equal(t, PeerQuirks{Name: "own"}, p.quirksFor(&PeerQuirks{Name: "own"}, "10.0.0.1"))
+}
+
+func TestPeerQuirksDTMF(t *testing.T) {
+	sessA, err := NewMediaSession(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, nil)
+	require.NoError(t, err)
+	defer sessA.Close()
+	sessB, err := NewMediaSession(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, nil)
+	require.NoError(t, err)
+	defer sessB.Close()
+
+	PeerQuirks{RequirePtime: true, DTMFPayloadType: 96}.Apply(sessB)
+	require.Equal(t, 20*time.Millisecond, sessB.Ptime)
+
+	require.NoError(t, sessB.RemoteSDP(sessA.LocalSDP()))
+	require.NoError(t, sessA.RemoteSDP(sessB.LocalSDP()))
+	require.Contains(t, string(sessB.LocalSDP()), "a=ptime:20")
+
+	// Peer sends DTMF with payload type other than negotiated
+	sessA.DTMFPayloadType = 96
+	digits := []rune{}
+	reader := NewRTPReader(sessB)
+	reader.OnDTMF = func(digit rune) {
+		digits = append(digits, digit)
+	}
+	writer := NewRTPWriter(sessA)
+	require.NoError(t, writer.WriteDTMF('7'))
+	_, err = writer.Write([]byte{1, 2, 3})
+	require.NoError(t, err)
+
+	sessB.rtpConn.SetReadDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, 1500)
+	n, err := reader.Read(buf)
+	require.NoError(t, err)
+	require.Equal(t, []byte{1, 2, 3}, buf[:n])
+	require.Equal(t, []rune{'7'}, digits)
+}
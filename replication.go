@@ -0,0 +1,344 @@
+package sipgox
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+type ReplicationEventType string
+
+const (
+	ReplicationDialogSave   ReplicationEventType = "dialog_save"
+	ReplicationDialogDelete ReplicationEventType = "dialog_delete"
+	ReplicationMediaSave    ReplicationEventType = "media_save"
+	ReplicationMediaDelete  ReplicationEventType = "media_delete"
+)
+
+// ReplicationEvent is single state change streamed from primary to standby.
+// Events are encoded as JSON lines
+type ReplicationEvent struct {
+	Type ReplicationEventType
+	// ID is dialog ID. Media state is keyed by same ID
+	ID     string
+	Dialog *DialogState       `json:",omitempty"`
+	Media  *MediaSessionState `json:",omitempty"`
+}
+
+// ReplicationPrimary streams dialog and media state to standby instances.
+// It implements DialogStore so it can be passed to WithPhoneDialogStore and
+// every confirmed dialog is replicated automatically.
+// Media state must be published with PublishMedia
+type ReplicationPrimary struct {
+	// WriteTimeout drops standby which does not read events in time. Default 5s
+	WriteTimeout time.Duration
+
+	store DialogStore
+
+	mu       sync.Mutex
+	media    map[string]MediaSessionState
+	standbys map[net.Conn]*replicationPeer
+
+	log zerolog.Logger
+}
+
+// NewReplicationPrimary creates primary. Dialogs are also saved in store
+func NewReplicationPrimary(store DialogStore) *ReplicationPrimary {
+	if store == nil {
+		store = &MemoryDialogStore{}
+	}
+	return &ReplicationPrimary{
+		store:    store,
+		media:    make(map[string]MediaSessionState),
+		standbys: make(map[net.Conn]*replicationPeer),
+		log:      log.With().Str("caller", "replication").Logger(),
+	}
+}
+
+func (r *ReplicationPrimary) Save(state DialogState) error {
+	if err := r.store.Save(state); err != nil {
+		return err
+	}
+	r.broadcast(ReplicationEvent{Type: ReplicationDialogSave, ID: state.ID, Dialog: &state})
+	return nil
+}
+
+func (r *ReplicationPrimary) Delete(id string) error {
+	if err := r.store.Delete(id); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	delete(r.media, id)
+	r.mu.Unlock()
+	r.broadcast(ReplicationEvent{Type: ReplicationDialogDelete, ID: id})
+	return nil
+}
+
+func (r *ReplicationPrimary) List() ([]DialogState, error) {
+	return r.store.List()
+}
+
+// PublishMedia replicates media state of dialog. Publish it again whenever
+// writer state should be refreshed, as standby continues stream from last published state
+func (r *ReplicationPrimary) PublishMedia(id string, state MediaSessionState) {
+	r.mu.Lock()
+	r.media[id] = state
+	r.mu.Unlock()
+	r.broadcast(ReplicationEvent{Type: ReplicationMediaSave, ID: id, Media: &state})
+}
+
+// DeleteMedia removes media state of dialog
+func (r *ReplicationPrimary) DeleteMedia(id string) {
+	r.mu.Lock()
+	delete(r.media, id)
+	r.mu.Unlock()
+	r.broadcast(ReplicationEvent{Type: ReplicationMediaDelete, ID: id})
+}
+
+// Serve accepts standby connections. Each new standby receives full snapshot first.
+// It blocks until listener is closed
+func (r *ReplicationPrimary) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+
+		if err := r.addStandby(conn); err != nil {
+			r.log.Error().Err(err).Str("standby", conn.RemoteAddr().String()).Msg("Fail to sync standby")
+			MediaEngineDefault.reportError(&BackgroundError{Source: BackgroundReplication, Addr: conn.RemoteAddr().String(), Err: err})
+			conn.Close()
+		}
+	}
+}
+
+// replicationPeer is connected standby. Lock keeps events of concurrent broadcasts whole
+// and after snapshot
+type replicationPeer struct {
+	conn net.Conn
+	mu   sync.Mutex
+	enc  *json.Encoder
+}
+
+// write encodes events with write deadline. Caller must hold peer lock
+func (p *replicationPeer) write(timeout time.Duration, evs ...ReplicationEvent) error {
+	p.conn.SetWriteDeadline(time.Now().Add(timeout))
+	for i := range evs {
+		if err := p.enc.Encode(evs[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *ReplicationPrimary) addStandby(conn net.Conn) error {
+	// Store is listed under lock, so change saved after listing is broadcast to peer
+	r.mu.Lock()
+	dialogs, err := r.store.List()
+	if err != nil {
+		r.mu.Unlock()
+		return err
+	}
+	snapshot := make([]ReplicationEvent, 0, len(dialogs)+len(r.media))
+	for i := range dialogs {
+		snapshot = append(snapshot, ReplicationEvent{Type: ReplicationDialogSave, ID: dialogs[i].ID, Dialog: &dialogs[i]})
+	}
+	for id, m := range r.media {
+		m := m
+		snapshot = append(snapshot, ReplicationEvent{Type: ReplicationMediaSave, ID: id, Media: &m})
+	}
+	// Peer is locked until snapshot is written, so live events follow it
+	p := &replicationPeer{conn: conn, enc: json.NewEncoder(conn)}
+	p.mu.Lock()
+	r.standbys[conn] = p
+	r.mu.Unlock()
+
+	err = p.write(r.writeTimeout(), snapshot...)
+	p.mu.Unlock()
+	if err != nil {
+		r.drop(p)
+		return err
+	}
+	r.log.Info().Str("standby", conn.RemoteAddr().String()).Msg("Standby connected")
+	return nil
+}
+
+// broadcast writes event to standbys outside of lock, so slow standby does not block others
+// longer than write timeout
+func (r *ReplicationPrimary) broadcast(ev ReplicationEvent) {
+	r.mu.Lock()
+	peers := make([]*replicationPeer, 0, len(r.standbys))
+	for _, p := range r.standbys {
+		peers = append(peers, p)
+	}
+	r.mu.Unlock()
+
+	timeout := r.writeTimeout()
+	for _, p := range peers {
+		p.mu.Lock()
+		err := p.write(timeout, ev)
+		p.mu.Unlock()
+		if err != nil {
+			r.log.Error().Err(err).Str("standby", p.conn.RemoteAddr().String()).Msg("Standby replication failed. Dropping")
+			MediaEngineDefault.reportError(&BackgroundError{Source: BackgroundReplication, Addr: p.conn.RemoteAddr().String(), Err: err})
+			r.drop(p)
+		}
+	}
+}
+
+// drop disconnects standby
+func (r *ReplicationPrimary) drop(p *replicationPeer) {
+	r.mu.Lock()
+	if r.standbys[p.conn] == p {
+		delete(r.standbys, p.conn)
+	}
+	r.mu.Unlock()
+	p.conn.Close()
+}
+
+func (r *ReplicationPrimary) writeTimeout() time.Duration {
+	if r.WriteTimeout == 0 {
+		return 5 * time.Second
+	}
+	return r.WriteTimeout
+}
+
+// Close disconnects all standbys
+func (r *ReplicationPrimary) Close() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for conn := range r.standbys {
+		conn.Close()
+		delete(r.standbys, conn)
+	}
+}
+
+// ReplicationStandby receives state from primary.
+// On failover create phone with WithPhoneDialogStore(standby.Store) and use
+// RecoverDialogs and TakeOverMedia
+type ReplicationStandby struct {
+	Store DialogStore
+
+	mu    sync.Mutex
+	media map[string]MediaSessionState
+}
+
+func NewReplicationStandby(store DialogStore) *ReplicationStandby {
+	if store == nil {
+		store = &MemoryDialogStore{}
+	}
+	return &ReplicationStandby{
+		Store: store,
+		media: make(map[string]MediaSessionState),
+	}
+}
+
+// Replicate applies events from primary connection until it is closed.
+// Returning means primary is gone and failover can be considered
+func (r *ReplicationStandby) Replicate(conn net.Conn) error {
+	dec := json.NewDecoder(bufio.NewReader(conn))
+	for {
+		var ev ReplicationEvent
+		if err := dec.Decode(&ev); err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+
+		if err := r.apply(ev); err != nil {
+			return err
+		}
+	}
+}
+
+func (r *ReplicationStandby) apply(ev ReplicationEvent) error {
+	switch ev.Type {
+	case ReplicationDialogSave:
+		if ev.Dialog == nil {
+			return fmt.Errorf("replication event %q without dialog", ev.ID)
+		}
+		return r.Store.Save(*ev.Dialog)
+	case ReplicationDialogDelete:
+		r.mu.Lock()
+		delete(r.media, ev.ID)
+		r.mu.Unlock()
+		return r.Store.Delete(ev.ID)
+	case ReplicationMediaSave:
+		if ev.Media == nil {
+			return fmt.Errorf("replication event %q without media", ev.ID)
+		}
+		r.mu.Lock()
+		r.media[ev.ID] = *ev.Media
+		r.mu.Unlock()
+	case ReplicationMediaDelete:
+		r.mu.Lock()
+		delete(r.media, ev.ID)
+		r.mu.Unlock()
+	default:
+		return fmt.Errorf("unknown replication event type %q", ev.Type)
+	}
+	return nil
+}
+
+// MediaState returns last replicated media state of dialog
+func (r *ReplicationStandby) MediaState(id string) (MediaSessionState, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	m, exists := r.media[id]
+	return m, exists
+}
+
+// TakeOverMedia binds same local address as primary and continues stream.
+// Local IP must be moved to standby (ex. VIP) before calling this.
+// Returned writer keeps SSRC of primary when writer state was replicated, otherwise it is nil
+func (r *ReplicationStandby) TakeOverMedia(id string) (*MediaSession, *RTPWriter, error) {
+	state, exists := r.MediaState(id)
+	if !exists {
+		return nil, nil, fmt.Errorf("no media state for dialog %q", id)
+	}
+
+	laddr, err := net.ResolveUDPAddr("udp", state.Laddr)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sess, err := NewMediaSession(laddr, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	sess.Formats = state.Formats
+	sess.Mode = state.Mode
+
+	if state.Raddr != "" {
+		raddr, err := net.ResolveUDPAddr("udp", state.Raddr)
+		if err != nil {
+			sess.Close()
+			return nil, nil, err
+		}
+		sess.SetRemoteAddr(raddr)
+	}
+	if state.RTCPRaddr != "" {
+		if sess.rtcpRaddr, err = net.ResolveUDPAddr("udp", state.RTCPRaddr); err != nil {
+			sess.Close()
+			return nil, nil, err
+		}
+	}
+
+	if state.Writer == nil {
+		return sess, nil, nil
+	}
+	return sess, NewRTPWriterFromState(sess, *state.Writer), nil
+}
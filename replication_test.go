@@ -0,0 +1,135 @@
+package sipgox
+
+import (
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReplication(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+
+	primary := NewReplicationPrimary(nil)
+	defer primary.Close()
+	go primary.Serve(l)
+
+	// State existing before standby connects is sent as snapshot
+	require.NoError(t, primary.Save(DialogState{ID: "dialog1", UAC: true, CSeq: 1}))
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	require.NoError(t, err)
+	standby := NewReplicationStandby(nil)
+	done := make(chan error)
+	go func() { done <- standby.Replicate(conn) }()
+
+	sess, err := NewMediaSession(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, nil)
+	require.NoError(t, err)
+	sess.SetRemoteAddr(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9000})
+	w := NewRTPWriter(sess)
+	state, files, err := sess.Export(w)
+	require.NoError(t, err)
+	closeFiles(files)
+	primary.PublishMedia("dialog1", state)
+
+	require.Eventually(t, func() bool {
+		_, exists := standby.MediaState("dialog1")
+		return exists
+	}, time.Second, 10*time.Millisecond)
+
+	dialogs, err := standby.Store.List()
+	require.NoError(t, err)
+	require.Len(t, dialogs, 1)
+	require.Equal(t, uint32(1), dialogs[0].CSeq)
+
+	// Failover. Primary media is gone and standby binds same address
+	sess.Close()
+	tsess, tw, err := standby.TakeOverMedia("dialog1")
+	require.NoError(t, err)
+	defer tsess.Close()
+	require.Equal(t, sess.Laddr.String(), tsess.Laddr.String())
+	require.Equal(t, "127.0.0.1:9000", tsess.Raddr.String())
+	require.Equal(t, w.SSRC, tw.SSRC)
+
+	require.NoError(t, primary.Delete("dialog1"))
+	require.Eventually(t, func() bool {
+		_, exists := standby.MediaState("dialog1")
+		return !exists
+	}, time.Second, 10*time.Millisecond)
+
+	primary.Close()
+	require.NoError(t, <-done)
+}
+
+func TestReplicationSlowStandby(t *testing.T) {
+	primary := NewReplicationPrimary(nil)
+	primary.WriteTimeout = 50 * time.Millisecond
+	defer primary.Close()
+
+	// Standby which never reads blocks write until deadline
+	conn, stalled := net.Pipe()
+	defer stalled.Close()
+	require.NoError(t, primary.addStandby(conn))
+
+	errs := make(chan *BackgroundError, 1)
+	MediaEngineDefault.OnError(func(err *BackgroundError) { errs <- err })
+	defer MediaEngineDefault.OnError(nil)
+
+	start := time.Now()
+	require.NoError(t, primary.Save(DialogState{ID: "dialog1"}))
+	require.Less(t, time.Since(start), time.Second)
+	primary.mu.Lock()
+	require.Empty(t, primary.standbys)
+	primary.mu.Unlock()
+
+	err := <-errs
+	require.Equal(t, BackgroundReplication, err.Source)
+	require.ErrorIs(t, err, os.ErrDeadlineExceeded)
+}
+
+// listHookStore calls onList after dialogs are listed
+type listHookStore struct {
+	DialogStore
+	onList func()
+}
+
+func (s *listHookStore) List() ([]DialogState, error) {
+	dialogs, err := s.DialogStore.List()
+	s.onList()
+	return dialogs, err
+}
+
+func TestReplicationSaveDuringSnapshot(t *testing.T) {
+	store := &listHookStore{DialogStore: &MemoryDialogStore{}}
+	primary := NewReplicationPrimary(store)
+	defer primary.Close()
+
+	// Dialog saved right after snapshot is listed is not lost
+	store.onList = func() {
+		store.onList = func() {}
+		saved := make(chan struct{})
+		go func() {
+			defer close(saved)
+			primary.Save(DialogState{ID: "dialog1"})
+		}()
+		select {
+		case <-saved:
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+
+	conn, remote := net.Pipe()
+	defer remote.Close()
+	standby := NewReplicationStandby(nil)
+	go standby.Replicate(remote)
+	require.NoError(t, primary.addStandby(conn))
+
+	require.Eventually(t, func() bool {
+		dialogs, _ := standby.Store.List()
+		return len(dialogs) == 1
+	}, time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,216 @@
+package sipgox
+
+import (
+	"fmt"
+	"math"
+)
+
+// resampleTaps is length of anti-alias filter applied when downsampling
+const resampleTaps = 32
+
+// Resampler converts sample rate of interleaved 16 bit PCM, ex. between 8000, 16000 and 48000.
+// It uses linear interpolation which is good enough for speech. When downsampling, input is first
+// low pass filtered so frequencies above new Nyquist rate do not alias. Filter and position between
+// frames are kept, so stream can be resampled in chunks of any size without clicks on chunk boundaries.
+// State is primed with silence, so chunk of N input frames gives N*To/From output frames
+type Resampler struct {
+	From     int
+	To       int
+	Channels int
+
+	// pos is position of next output in input frames multiplied by To.
+	// Frame 0 is last frame of previous chunk
+	pos  int
+	prev []int16
+
+	// lowpass is anti-alias filter. Nil when upsampling
+	lowpass []float64
+	// history is interleaved input of previous chunks needed by filter
+	history  []int16
+	filtered []int16
+}
+
+// NewResampler creates resampler from one sample rate to other
+func NewResampler(from int, to int, channels int) (*Resampler, error) {
+	if from <= 0 || to <= 0 {
+		return nil, fmt.Errorf("invalid resample rate %d to %d", from, to)
+	}
+	if channels <= 0 {
+		return nil, fmt.Errorf("invalid resample channels %d", channels)
+	}
+	r := &Resampler{From: from, To: to, Channels: channels}
+	if to < from {
+		r.lowpass = resampleLowpass(resampleTaps, 0.45*float64(to)/float64(from))
+	}
+	r.Reset()
+	return r, nil
+}
+
+// resampleLowpass returns Hamming windowed sinc filter with cutoff as fraction of sample rate
+func resampleLowpass(taps int, cutoff float64) []float64 {
+	h := make([]float64, taps)
+	m := float64(taps - 1)
+	var sum float64
+	for k := range h {
+		x := float64(k) - m/2
+		sinc := 2 * cutoff
+		if x != 0 {
+			sinc = math.Sin(2*math.Pi*cutoff*x) / (math.Pi * x)
+		}
+		h[k] = sinc * (0.54 - 0.46*math.Cos(2*math.Pi*float64(k)/m))
+		sum += h[k]
+	}
+	// Unity gain at DC
+	for k := range h {
+		h[k] /= sum
+	}
+	return h
+}
+
+// Reset drops state of previous chunks, ex. when stream restarts
+func (r *Resampler) Reset() {
+	r.pos = 0
+	r.prev = append(r.prev[:0], make([]int16, r.Channels)...)
+	r.history = r.history[:0]
+	if r.lowpass != nil {
+		r.history = append(r.history, make([]int16, (len(r.lowpass)-1)*r.Channels)...)
+	}
+}
+
+// Resample appends resampled src to dst and returns it. Output lags one input frame,
+// and filter delay when downsampling
+func (r *Resampler) Resample(dst []int16, src []int16) []int16 {
+	if r.From == r.To {
+		return append(dst, src...)
+	}
+	ch := r.Channels
+	frames := len(src) / ch
+	if frames == 0 {
+		return dst
+	}
+	src = r.filter(src[:frames*ch])
+
+	// sample returns sample of frame i where 0 is last frame of previous chunk
+	sample := func(i int, c int) int {
+		if i == 0 {
+			return int(r.prev[c])
+		}
+		return int(src[(i-1)*ch+c])
+	}
+
+	for {
+		i, frac := r.pos/r.To, r.pos%r.To
+		if i >= frames {
+			break
+		}
+		for c := 0; c < ch; c++ {
+			s0 := sample(i, c)
+			s1 := s0
+			if frac != 0 {
+				s1 = sample(i+1, c)
+			}
+			dst = append(dst, int16(s0+(s1-s0)*frac/r.To))
+		}
+		r.pos += r.From
+	}
+
+	r.pos -= frames * r.To
+	r.prev = append(r.prev[:0], src[(frames-1)*ch:frames*ch]...)
+	return dst
+}
+
+// filter low pass filters src with history of previous chunks. Src is returned when upsampling
+func (r *Resampler) filter(src []int16) []int16 {
+	if r.lowpass == nil {
+		return src
+	}
+	ch := r.Channels
+	taps := len(r.lowpass)
+	buf := append(r.history, src...)
+	r.filtered = r.filtered[:0]
+	for n := 0; n < len(src)/ch; n++ {
+		for c := 0; c < ch; c++ {
+			var y float64
+			for k, h := range r.lowpass {
+				y += h * float64(buf[(n+taps-1-k)*ch+c])
+			}
+			r.filtered = append(r.filtered, clampInt16(math.Round(y)))
+		}
+	}
+	r.history = append(r.history[:0], buf[len(buf)-(taps-1)*ch:]...)
+	return r.filtered
+}
+
+// resampleDecoder resamples decoded PCM to sample rate other than codec one
+type resampleDecoder struct {
+	Decoder
+	r   *Resampler
+	pcm []int16
+	out []int16
+}
+
+// NewResampleDecoder wraps decoder to return PCM in sample rate, ex. for recording wideband call
+// at 8000 or mixing G.722 with G.711
+func NewResampleDecoder(d Decoder, sampleRate int) (Decoder, error) {
+	info := d.Info()
+	r, err := NewResampler(info.SampleRate, sampleRate, max(info.Channels, 1))
+	if err != nil {
+		return nil, err
+	}
+	return &resampleDecoder{Decoder: d, r: r}, nil
+}
+
+func (d *resampleDecoder) Info() CodecInfo {
+	return resampledInfo(d.Decoder.Info(), d.r.To)
+}
+
+func (d *resampleDecoder) Decode(pcm []int16, payload []byte) (int, error) {
+	info := d.Decoder.Info()
+	// Opus frame can have up to 120ms
+	if size := max(len(payload), 6*info.FrameSize*max(info.Channels, 1)); len(d.pcm) < size {
+		d.pcm = make([]int16, size)
+	}
+	n, err := d.Decoder.Decode(d.pcm, payload)
+	if err != nil {
+		return 0, err
+	}
+	d.out = d.r.Resample(d.out[:0], d.pcm[:n])
+	if len(pcm) < len(d.out) {
+		return 0, fmt.Errorf("pcm buffer too small")
+	}
+	return copy(pcm, d.out), nil
+}
+
+// resampleEncoder resamples PCM to sample rate of codec before encoding
+type resampleEncoder struct {
+	Encoder
+	r   *Resampler
+	pcm []int16
+}
+
+// NewResampleEncoder wraps encoder to accept PCM in sample rate, ex. playing 8000 file over Opus
+func NewResampleEncoder(e Encoder, sampleRate int) (Encoder, error) {
+	info := e.Info()
+	r, err := NewResampler(sampleRate, info.SampleRate, max(info.Channels, 1))
+	if err != nil {
+		return nil, err
+	}
+	return &resampleEncoder{Encoder: e, r: r}, nil
+}
+
+func (e *resampleEncoder) Info() CodecInfo {
+	return resampledInfo(e.Encoder.Info(), e.r.From)
+}
+
+func (e *resampleEncoder) Encode(payload []byte, pcm []int16) (int, error) {
+	e.pcm = e.r.Resample(e.pcm[:0], pcm)
+	return e.Encoder.Encode(payload, e.pcm)
+}
+
+func resampledInfo(info CodecInfo, sampleRate int) CodecInfo {
+	if info.SampleRate > 0 {
+		info.FrameSize = info.FrameSize * sampleRate / info.SampleRate
+	}
+	info.SampleRate = sampleRate
+	return info
+}
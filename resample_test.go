@@ -0,0 +1,96 @@
+package sipgox
+
+import (
+	"math"
+	"testing"
+
+	"github.com/emiago/sipgox/sdp"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResampler(t *testing.T) {
+	r, err := NewResampler(8000, 16000, 1)
+	require.NoError(t, err)
+	// Output lags one frame, which is primed with silence
+	require.Equal(t, []int16{0, 0, 0, 50}, r.Resample(nil, []int16{0, 100}))
+	require.Equal(t, []int16{100, 150, 200, 250}, r.Resample(nil, []int16{200, 300}))
+
+	_, err = NewResampler(0, 8000, 1)
+	require.Error(t, err)
+
+	// Chunks give same output as whole stream and output length is exact
+	for _, rates := range [][2]int{{8000, 16000}, {8000, 48000}, {48000, 8000}, {16000, 48000}, {16000, 8000}} {
+		src := make([]int16, 960)
+		for i := range src {
+			src[i] = int16(i * 7)
+		}
+		whole, _ := NewResampler(rates[0], rates[1], 1)
+		chunked, _ := NewResampler(rates[0], rates[1], 1)
+		expected := whole.Resample(nil, src)
+		var out []int16
+		for i := 0; i < len(src); i += 160 {
+			n := len(out)
+			out = chunked.Resample(out, src[i:i+160])
+			if 160*rates[1]%rates[0] == 0 {
+				require.Equal(t, 160*rates[1]/rates[0], len(out)-n, rates)
+			}
+		}
+		require.Equal(t, expected, out, rates)
+		require.Len(t, out, len(src)*rates[1]/rates[0], rates)
+	}
+}
+
+func TestResamplerAntiAlias(t *testing.T) {
+	// power returns power of 16000 sine at freq after downsampling to 8000
+	power := func(freq float64) float64 {
+		r, err := NewResampler(16000, 8000, 2)
+		require.NoError(t, err)
+		src := make([]int16, 2*1600)
+		for i := 0; i < 1600; i++ {
+			s := int16(10000 * math.Sin(2*math.Pi*freq*float64(i)/16000))
+			src[2*i], src[2*i+1] = s, s
+		}
+		out := r.Resample(nil, src)
+		require.Len(t, out, 2*800)
+		var p float64
+		// Skip filter delay
+		for _, s := range out[200:] {
+			p += float64(s) * float64(s)
+		}
+		return p / float64(len(out)-200)
+	}
+
+	pass := power(1000)
+	require.InDelta(t, 10000*10000/2, pass, 10000*10000/2*0.1)
+	// 6000 Hz would alias to 2000 Hz without filter
+	require.Less(t, 10*math.Log10(power(6000)/pass), -30.0)
+}
+
+func TestResampleCodec(t *testing.T) {
+	c := sdp.Codec{PayloadType: 9, Name: "G722", ClockRate: 8000}
+	enc, err := NewEncoder(c)
+	require.NoError(t, err)
+	enc, err = NewResampleEncoder(enc, 8000)
+	require.NoError(t, err)
+	require.Equal(t, 8000, enc.Info().SampleRate)
+	require.Equal(t, 160, enc.Info().FrameSize)
+
+	dec, err := NewDecoder(c)
+	require.NoError(t, err)
+	dec, err = NewResampleDecoder(dec, 8000)
+	require.NoError(t, err)
+	require.Equal(t, 8000, dec.Info().SampleRate)
+
+	// 20ms at 8000 is encoded as 20ms of G.722 and decoded back to 8000
+	pcm := make([]int16, 160)
+	payload := make([]byte, 320)
+	out := make([]int16, 320)
+	for i := 0; i < 5; i++ {
+		n, err := enc.Encode(payload, pcm)
+		require.NoError(t, err)
+		require.InDelta(t, 160, n, 1)
+		samples, err := dec.Decode(out, payload[:n])
+		require.NoError(t, err)
+		require.InDelta(t, 160, samples, 1)
+	}
+}
@@ -0,0 +1,154 @@
+package sipgox
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// RFC 3550 Appendix A.7 constants
+const (
+	rtcpMinTime            = 5 * time.Second
+	rtcpSenderBWFraction   = 0.25
+	rtcpReceiverBWFraction = 0.75
+	rtcpBWFraction         = 0.05
+	// compensation for timer reconsideration converging below intended average
+	rtcpCompensation = math.E - 1.5
+	// IPv4 + UDP header added to RTCP packet size
+	rtcpHeaderOverhead = 28
+)
+
+// RTCPInterval calculates randomized interval between RTCP packets as in RFC 3550 Appendix A.7
+// rtcpBW is target RTCP bandwidth in bytes per second (5% of session bandwidth)
+// avgRTCPSize is average compound RTCP packet size in bytes including UDP and IP headers
+func RTCPInterval(members int, senders int, rtcpBW float64, weSent bool, avgRTCPSize float64, initial bool) time.Duration {
+	return rtcpInterval(members, senders, rtcpBW, weSent, avgRTCPSize, initial, rtcpMinTime, rand.Float64())
+}
+
+func rtcpInterval(members int, senders int, rtcpBW float64, weSent bool, avgRTCPSize float64, initial bool, min time.Duration, random float64) time.Duration {
+	minTime := min.Seconds()
+	if initial {
+		minTime /= 2
+	}
+
+	// Dedicate fraction of bandwidth to senders unless number of senders is large enough
+	n := float64(members)
+	if float64(senders) <= float64(members)*rtcpSenderBWFraction {
+		if weSent {
+			rtcpBW *= rtcpSenderBWFraction
+			n = float64(senders)
+		} else {
+			rtcpBW *= rtcpReceiverBWFraction
+			n -= float64(senders)
+		}
+	}
+
+	t := minTime
+	if rtcpBW > 0 {
+		t = avgRTCPSize * n / rtcpBW
+	}
+	if t < minTime {
+		t = minTime
+	}
+
+	// Randomize in range [0.5,1.5] to avoid synchronization
+	t = t * (random + 0.5)
+	t = t / rtcpCompensation
+	return time.Duration(t * float64(time.Second))
+}
+
+// RTCPScheduler decides when compound RTCP packets should be sent based on RFC 3550 rules
+// with timer reconsideration. Members and senders should be updated as they are learned from RTP/RTCP.
+// It is safe to update from other goroutines while running
+type RTCPScheduler struct {
+	// MinInterval is minimal interval before randomization. Default is 5s.
+	// RFC 3550 allows smaller value for high bandwidth sessions
+	MinInterval time.Duration
+
+	mu          sync.Mutex
+	rtcpBW      float64
+	members     int
+	senders     int
+	weSent      bool
+	avgRTCPSize float64
+	initial     bool
+
+	random func() float64
+}
+
+// NewRTCPScheduler creates scheduler for session bandwidth in bytes per second.
+// It assumes 2 party call where we are sender
+func NewRTCPScheduler(sessionBandwidth float64) *RTCPScheduler {
+	return &RTCPScheduler{
+		MinInterval: rtcpMinTime,
+		rtcpBW:      sessionBandwidth * rtcpBWFraction,
+		members:     2,
+		senders:     1,
+		weSent:      true,
+		avgRTCPSize: 100 + rtcpHeaderOverhead,
+		initial:     true,
+		random:      rand.Float64,
+	}
+}
+
+// UpdateMembers updates number of session members and senders including us
+func (s *RTCPScheduler) UpdateMembers(members int, senders int) {
+	s.mu.Lock()
+	s.members, s.senders = members, senders
+	s.mu.Unlock()
+}
+
+// SetWeSent should be set when we sent RTP since last two RTCP intervals
+func (s *RTCPScheduler) SetWeSent(weSent bool) {
+	s.mu.Lock()
+	s.weSent = weSent
+	s.mu.Unlock()
+}
+
+// Interval returns next randomized RTCP interval
+func (s *RTCPScheduler) Interval() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return rtcpInterval(s.members, s.senders, s.rtcpBW, s.weSent, s.avgRTCPSize, s.initial, s.MinInterval, s.random())
+}
+
+// packetSent updates average RTCP size with sent compound packet size
+func (s *RTCPScheduler) packetSent(size int) {
+	s.mu.Lock()
+	s.avgRTCPSize = float64(size+rtcpHeaderOverhead)/16 + s.avgRTCPSize*15/16
+	s.initial = false
+	s.mu.Unlock()
+}
+
+// Run calls send when RTCP should be transmitted until context is done or send fails.
+// send should write compound RTCP packet (ex SR + SDES) and return its size.
+func (s *RTCPScheduler) Run(ctx context.Context, send func() (int, error)) error {
+	tp := time.Now()
+	t := time.NewTimer(s.Interval())
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-t.C:
+		}
+
+		// Timer reconsideration. Members could change since timer was scheduled
+		now := time.Now()
+		tn := tp.Add(s.Interval())
+		if tn.After(now) {
+			t.Reset(tn.Sub(now))
+			continue
+		}
+
+		size, err := send()
+		if err != nil {
+			return err
+		}
+		s.packetSent(size)
+		tp = now
+		t.Reset(s.Interval())
+	}
+}
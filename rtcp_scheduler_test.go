@@ -0,0 +1,45 @@
+package sipgox
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRTCPInterval(t *testing.T) {
+	// Minimal interval is used for low traffic. Random 0.5 keeps value as is before compensation
+	interval := rtcpInterval(2, 1, 400, true, 128, false, rtcpMinTime, 0.5)
+	require.InDelta(t, 5/rtcpCompensation, interval.Seconds(), 0.001)
+
+	// Initial is half of minimal
+	interval = rtcpInterval(2, 1, 400, true, 128, true, rtcpMinTime, 0.5)
+	require.InDelta(t, 2.5/rtcpCompensation, interval.Seconds(), 0.001)
+
+	// Many receivers with small bandwidth. Receivers share 75% of bandwidth
+	// 100 members - 1 sender = 99 * 128 / (100 * 0.75) = 168.96s
+	interval = rtcpInterval(100, 1, 100, false, 128, false, rtcpMinTime, 0.5)
+	require.InDelta(t, 168.96/rtcpCompensation, interval.Seconds(), 0.001)
+}
+
+func TestRTCPSchedulerRun(t *testing.T) {
+	s := NewRTCPScheduler(64000)
+	s.MinInterval = 10 * time.Millisecond
+	s.random = func() float64 { return 0 }
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	sent := 0
+	err := s.Run(ctx, func() (int, error) {
+		sent++
+		if sent == 2 {
+			cancel()
+		}
+		return 20, nil
+	})
+	require.ErrorIs(t, err, context.Canceled)
+	require.Equal(t, 2, sent)
+	require.Less(t, s.avgRTCPSize, float64(100+rtcpHeaderOverhead))
+}
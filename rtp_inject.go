@@ -0,0 +1,142 @@
+package sipgox
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+
+	"github.com/emiago/sipgox/sdp"
+)
+
+// AudioPriority decides how pushed audio is combined with primary stream
+type AudioPriority int
+
+const (
+	// AudioPriorityMix adds pushed audio to primary stream
+	AudioPriorityMix AudioPriority = iota
+	// AudioPriorityDuck attenuates primary stream while pushed audio plays
+	AudioPriorityDuck
+	// AudioPriorityReplace sends pushed audio instead of primary stream
+	AudioPriorityReplace
+)
+
+// DefaultDuckGain is gain of primary stream when ducked
+var DefaultDuckGain = 0.3
+
+// PushAudioOptions control how pushed audio is combined with primary stream
+type PushAudioOptions struct {
+	Priority AudioPriority
+	// DuckGain is gain (0-1] of primary stream with AudioPriorityDuck. Default DefaultDuckGain
+	DuckGain float64
+	// SSRC of pushed audio source. If set it is added to CSRC of packets carrying it
+	SSRC uint32
+}
+
+type injectedAudio struct {
+	samples []byte
+	opts    PushAudioOptions
+}
+
+type audioInjector struct {
+	mu    sync.Mutex
+	queue []injectedAudio
+}
+
+// PushAudio queues application audio (alerts, whispers) to be combined with primary stream.
+// Frames must be encoded with writer payload type. Only PCMU and PCMA are supported.
+// Pushed audio is consumed by following Write calls sample by sample, so it plays on
+// primary stream clock. It is safe to call from other goroutine than writer
+func (p *RTPWriter) PushAudio(frames []byte, opts PushAudioOptions) error {
+	switch p.PayloadType {
+	case sdp.FormatNumeric(sdp.FORMAT_TYPE_ULAW), sdp.FormatNumeric(sdp.FORMAT_TYPE_ALAW):
+	default:
+		return fmt.Errorf("audio injection not supported for payload type %d", p.PayloadType)
+	}
+	if opts.DuckGain <= 0 || opts.DuckGain > 1 {
+		opts.DuckGain = DefaultDuckGain
+	}
+
+	p.injector.mu.Lock()
+	defer p.injector.mu.Unlock()
+	p.injector.queue = append(p.injector.queue, injectedAudio{
+		samples: append([]byte{}, frames...),
+		opts:    opts,
+	})
+	return nil
+}
+
+// PushedAudio returns number of samples waiting to be sent
+func (p *RTPWriter) PushedAudio() int {
+	p.injector.mu.Lock()
+	defer p.injector.mu.Unlock()
+	n := 0
+	for _, a := range p.injector.queue {
+		n += len(a.samples)
+	}
+	return n
+}
+
+// ClearPushedAudio drops audio waiting to be sent
+func (p *RTPWriter) ClearPushedAudio() {
+	p.injector.mu.Lock()
+	p.injector.queue = nil
+	p.injector.mu.Unlock()
+}
+
+// mixPushedAudio combines queued audio with payload and returns sources of mixed audio.
+// Payload is not modified
+func (p *RTPWriter) mixPushedAudio(payload []byte, payloadType uint8) ([]byte, []uint32) {
+	decode, encode, ok := g711Funcs(payloadType)
+	if !ok {
+		return payload, nil
+	}
+
+	inj := &p.injector
+	inj.mu.Lock()
+	defer inj.mu.Unlock()
+	if len(inj.queue) == 0 || payloadType != p.PayloadType {
+		return payload, nil
+	}
+
+	var sources []uint32
+
+	out := make([]byte, len(payload))
+	copy(out, payload)
+	for i := range out {
+		if len(inj.queue) == 0 {
+			break
+		}
+		a := &inj.queue[0]
+		s := a.samples[0]
+		a.samples = a.samples[1:]
+		opts := a.opts
+		if len(a.samples) == 0 {
+			inj.queue = inj.queue[1:]
+		}
+		if opts.SSRC != 0 && !slices.Contains(sources, opts.SSRC) {
+			sources = append(sources, opts.SSRC)
+		}
+
+		if opts.Priority == AudioPriorityReplace {
+			out[i] = s
+			continue
+		}
+
+		primary := float64(decode(out[i]))
+		if opts.Priority == AudioPriorityDuck {
+			primary *= opts.DuckGain
+		}
+		out[i] = encode(clampInt16(primary + float64(decode(s))))
+	}
+	return out, sources
+}
+
+func clampInt16(v float64) int16 {
+	switch {
+	case v > 32767:
+		return 32767
+	case v < -32768:
+		return -32768
+	}
+	return int16(v)
+}
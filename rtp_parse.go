@@ -1,6 +1,7 @@
 package sipgox
 
 import (
+	"errors"
 	"fmt"
 	"io"
 
@@ -8,6 +9,9 @@ import (
 	"github.com/pion/rtp"
 )
 
+// ErrRTPInvalidPadding is returned when padding bit is set but padding count is 0
+var ErrRTPInvalidPadding = errors.New("rtp: invalid padding")
+
 // Experimental
 //
 // rtpUnmarshal is optimized unmarshal version based on pion/rtp
@@ -28,7 +32,13 @@ func rtpUnmarshal(buf []byte, p *rtp.Packet) error {
 	end := len(buf)
 	if p.Header.Padding {
 		p.PaddingSize = buf[end-1]
+		// Padding count includes itself so it can not be 0
+		if p.PaddingSize == 0 {
+			return ErrRTPInvalidPadding
+		}
 		end -= int(p.PaddingSize)
+	} else {
+		p.PaddingSize = 0
 	}
 	if end < n {
 		return io.ErrShortBuffer
@@ -61,7 +71,7 @@ func rtcpUnmarshal(data []byte, packets []rtcp.Packet) (n int, err error) {
 		inPacket := data[:pktLen]
 
 		// Check the type and unmarshal
-		packet := rtcpTypedPacket(h.Type)
+		packet := rtcpTypedPacket(h)
 		err = packet.Unmarshal(inPacket)
 		if err != nil {
 			return 0, err
@@ -81,10 +91,10 @@ func rtcpMarshal(packets []rtcp.Packet) ([]byte, error) {
 }
 
 // TODO this would be nice that pion exports
-func rtcpTypedPacket(htype rtcp.PacketType) rtcp.Packet {
+func rtcpTypedPacket(h rtcp.Header) rtcp.Packet {
 	// Currently we are not interested
 
-	switch htype {
+	switch h.Type {
 	case rtcp.TypeSenderReport:
 		return new(rtcp.SenderReport)
 
@@ -97,6 +107,13 @@ func rtcpTypedPacket(htype rtcp.PacketType) rtcp.Packet {
 	case rtcp.TypeGoodbye:
 		return new(rtcp.Goodbye)
 
+	case rtcp.TypeTransportSpecificFeedback:
+		// Count holds feedback message type
+		if h.Count == rtcp.FormatTLN {
+			return new(rtcp.TransportLayerNack)
+		}
+		return new(rtcp.RawPacket)
+
 	default:
 		return new(rtcp.RawPacket)
 	}
@@ -7,6 +7,7 @@ import (
 	"github.com/emiago/sipgo/fakes"
 	"github.com/pion/rtcp"
 	"github.com/pion/rtp"
+	"github.com/stretchr/testify/require"
 )
 
 func BenchmarkRTCPUnmarshal(b *testing.B) {
@@ -115,3 +116,27 @@ func BenchmarkReadRTP(b *testing.B) {
 		})
 	})
 }
+
+func TestRTPUnmarshalPadding(t *testing.T) {
+	pkt := rtp.Packet{
+		Header:      rtp.Header{Version: 2, Padding: true, SSRC: 1},
+		Payload:     []byte{1, 2, 3},
+		PaddingSize: 5,
+	}
+	data, err := pkt.Marshal()
+	require.NoError(t, err)
+	require.Len(t, data, 12+3+5)
+
+	res := rtp.Packet{}
+	require.NoError(t, rtpUnmarshal(data, &res))
+	require.Equal(t, []byte{1, 2, 3}, res.Payload)
+	require.Equal(t, byte(5), res.PaddingSize)
+
+	// Padding count 0 is invalid
+	data[len(data)-1] = 0
+	require.ErrorIs(t, rtpUnmarshal(data, &res), ErrRTPInvalidPadding)
+
+	// Padding larger than packet
+	data[len(data)-1] = 200
+	require.Error(t, rtpUnmarshal(data, &res))
+}
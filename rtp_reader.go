@@ -2,11 +2,13 @@ package sipgox
 
 import (
 	"errors"
-	"fmt"
 	"io"
 	"net"
+	"slices"
+	"time"
 
 	"github.com/emiago/sipgox/sdp"
+	"github.com/pion/rtcp"
 	"github.com/pion/rtp"
 )
 
@@ -20,6 +22,30 @@ type RTPReader struct {
 	PayloadType  uint8
 	Seq          RTPExtendedSequenceNumber
 
+	// NACKEnabled sends RTCP Generic NACK for missing packets.
+	// NACK is sent only when session negotiated it, see MediaSession NACK
+	NACKEnabled bool
+
+	// OnDTMF is called once for every received DTMF digit (RFC 4733) on negotiated payload type.
+	// DTMF packets are not returned by Read
+	OnDTMF func(digit rune)
+
+	// OnPayloadAnomaly is called for packet with payload not plausible for negotiated codec,
+	// ex. G.729 frames labeled as PCMU. Packet is still returned by Read. See CheckPayload
+	OnPayloadAnomaly func(pkt *rtp.Packet, anomaly PayloadAnomaly)
+
+	// AcceptPayloadTypes are payload types other than PayloadType whose payload Read returns,
+	// ex. other negotiated formats when remote switches codec. Check PacketHeader.PayloadType after Read.
+	// Empty by default, as AudioReader and Bridge decode only with codec of PayloadType
+	AcceptPayloadTypes []uint8
+
+	// PayloadHandlers consume packets of payload type instead of Read returning them,
+	// ex. comfort noise (13). Packets of payload types not accepted or handled are dropped
+	PayloadHandlers map[uint8]func(pkt *rtp.Packet)
+
+	// codec is negotiated codec used for payload checks. Zero if format is unknown
+	codec sdp.Codec
+
 	unreadPayload []byte
 	unread        int
 
@@ -27,23 +53,35 @@ type RTPReader struct {
 
 	// We want to track our last SSRC.
 	lastSSRC uint32
+
+	dtmf dtmfReceiver
+
+	// stream is set when session RTP is read by demux. See MediaSession StartDemux
+	stream *DemuxStream
+
+	// audioLevel is RFC 6464 level of last packet
+	audioLevel   rtp.AudioLevelExtension
+	audioLevelOK bool
+}
+
+// CSRC returns contributing sources of last read packet. When remote is mixer
+// these are SSRCs of sources combined in it, useful for speaker attribution
+func (r *RTPReader) CSRC() []uint32 {
+	return append([]uint32{}, r.PacketHeader.CSRC...)
 }
 
 // RTP reader consumes samples of audio from session
 // TODO should it also decode ?
 func NewRTPReader(sess *MediaSession) *RTPReader {
-	f := sess.Formats[0]
-	var payloadType uint8 = sdp.FormatNumeric(f)
-	switch f {
-	case sdp.FORMAT_TYPE_ALAW:
-	case sdp.FORMAT_TYPE_ULAW:
-		// TODO more support
-	default:
+	// Remote can send with different payload type than we send with
+	f, codec, payloadType, ok := sess.negotiated()
+	if !ok {
 		sess.log.Warn().Str("format", f).Msg("Unsupported format. Using default clock rate")
 	}
 
 	w := RTPReader{
 		Sess:          sess,
+		codec:         codec,
 		unreadPayload: []byte{},
 		PayloadType:   payloadType,
 		OnRTP:         func(pkt *rtp.Packet) {},
@@ -52,6 +90,10 @@ func NewRTPReader(sess *MediaSession) *RTPReader {
 		Seq:       RTPExtendedSequenceNumber{},
 	}
 
+	if d := sess.Demux(); d != nil {
+		// Reader filters payload types itself, so AcceptPayloadTypes can be set after
+		w.stream = d.route(0, true, []uint8{payloadType})
+	}
 	return &w
 }
 
@@ -64,26 +106,68 @@ func (r *RTPReader) Read(b []byte) (int, error) {
 		return n, nil
 	}
 
-	// Reuse read buffer.
-	n, err := r.Sess.ReadRTPRaw(b)
-	if err != nil {
-		if errors.Is(err, net.ErrClosed) {
-			return 0, io.EOF
+	pkt := rtp.Packet{}
+	for {
+		// Reuse read buffer.
+		n, err := r.readRTPRaw(b)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return 0, io.EOF
+			}
+
+			return 0, err
+		}
+		// NOTE: pkt after unmarshall will hold reference on b buffer.
+		// Caller should do copy of PacketHeader if it reuses buffer
+		if err := pkt.Unmarshal(b[:n]); err != nil {
+			return 0, err
 		}
 
-		return 0, err
+		// Sequence is shared by all payload types of source
+		r.readSeq(&pkt)
+
+		if pkt.PayloadType == r.PayloadType || slices.Contains(r.AcceptPayloadTypes, pkt.PayloadType) {
+			break
+		}
+		if pkt.PayloadType == r.Sess.recvDTMFPayloadType() {
+			r.dtmf.read(r.Sess, &pkt, r.OnDTMF)
+			continue
+		}
+		if h, ok := r.PayloadHandlers[pkt.PayloadType]; ok {
+			h(&pkt)
+			continue
+		}
+		r.Sess.log.Debug().Uint8("pt", pkt.PayloadType).Msg("Dropping pkt with not accepted payload type")
 	}
-	pkt := rtp.Packet{}
-	// NOTE: pkt after unmarshall will hold reference on b buffer.
-	// Caller should do copy of PacketHeader if it reuses buffer
-	if err := pkt.Unmarshal(b[:n]); err != nil {
-		return 0, err
+
+	r.PacketHeader = pkt.Header
+	r.readAudioLevel(&pkt.Header)
+	r.OnRTP(&pkt)
+	if r.OnPayloadAnomaly != nil && r.codec.Name != "" {
+		if a := CheckPayload(r.codec, pkt.Payload); a != PayloadAnomalyNone {
+			r.OnPayloadAnomaly(&pkt, a)
+		}
 	}
 
-	if r.PayloadType != pkt.PayloadType {
-		return 0, fmt.Errorf("payload type does not match. expected=%d, actual=%d", r.PayloadType, pkt.PayloadType)
+	return r.readPayload(b, pkt.Payload), nil
+}
+
+func (r *RTPReader) readRTPRaw(b []byte) (int, error) {
+	if r.stream != nil {
+		return r.stream.ReadRTPRaw(b)
 	}
+	return r.Sess.ReadRTPRaw(b)
+}
 
+func (r *RTPReader) setReadDeadline(t time.Time) error {
+	if r.stream != nil {
+		return r.stream.SetReadDeadline(t)
+	}
+	return r.Sess.rtpConn.SetReadDeadline(t)
+}
+
+// readSeq tracks sequence of packet and requests missing packets
+func (r *RTPReader) readSeq(pkt *rtp.Packet) {
 	// If we are tracking this source, do check are we keep getting pkts in sequence
 	if r.lastSSRC == pkt.SSRC {
 		prevSeq := r.Seq.ReadExtendedSeq()
@@ -94,16 +178,16 @@ func (r *RTPReader) Read(b []byte) (int, error) {
 		newSeq := r.Seq.ReadExtendedSeq()
 		if prevSeq+1 != newSeq {
 			r.Sess.log.Warn().Uint64("expected", prevSeq+1).Uint64("actual", newSeq).Uint16("real", pkt.SequenceNumber).Msg("Out of order pkt received")
+
+			if r.NACKEnabled && r.Sess.nackNegotiated.Load() && newSeq > prevSeq+1 {
+				r.sendNACK(pkt.SSRC, prevSeq+1, newSeq)
+			}
 		}
 	} else {
 		r.Seq.InitSeq(pkt.SequenceNumber)
 	}
-
+	r.Sess.readRTPStats(pkt, r.Seq.ReadExtendedSeq(), time.Now(), r.codec)
 	r.lastSSRC = pkt.SSRC
-	r.PacketHeader = pkt.Header
-	r.OnRTP(&pkt)
-
-	return r.readPayload(b, pkt.Payload), nil
 }
 
 func (r *RTPReader) readPayload(b []byte, payload []byte) int {
@@ -116,3 +200,29 @@ func (r *RTPReader) readPayload(b []byte, payload []byte) int {
 	}
 	return n
 }
+
+// maxNACKLost limits how many lost packets are requested in single NACK.
+// Bigger gaps are more likely stream restart than loss
+const maxNACKLost = 256
+
+// sendNACK requests retransmission of extended sequence numbers in range [from, to)
+func (r *RTPReader) sendNACK(ssrc uint32, from uint64, to uint64) {
+	if to-from > maxNACKLost {
+		return
+	}
+
+	lost := make([]uint16, 0, to-from)
+	for seq := from; seq < to; seq++ {
+		lost = append(lost, uint16(seq))
+	}
+
+	nack := &rtcp.TransportLayerNack{
+		SenderSSRC: r.Sess.senderSSRC(),
+		MediaSSRC:  ssrc,
+		Nacks:      rtcp.NackPairsFromSequenceNumbers(lost),
+	}
+	if err := r.Sess.WriteRTCP(nack); err != nil {
+		r.Sess.log.Debug().Err(err).Msg("Failed to send NACK")
+		r.Sess.reportError(BackgroundNACK, "", err)
+	}
+}
@@ -2,11 +2,13 @@ package sipgox
 
 import (
 	"bytes"
+	"io"
 	"net"
 	"testing"
 
 	"github.com/emiago/sipgo/fakes"
 	"github.com/emiago/sipgox/sdp"
+	"github.com/pion/rtcp"
 	"github.com/pion/rtp"
 	"github.com/rs/zerolog/log"
 	"github.com/stretchr/testify/require"
@@ -97,3 +99,144 @@ func BenchmarkRTPReader(b *testing.B) {
 		require.NoError(b, err)
 	}
 }
+
+func TestRTPReaderNACK(t *testing.T) {
+	sess := &MediaSession{
+		Formats: sdp.Formats{
+			sdp.FORMAT_TYPE_ULAW,
+		},
+		Laddr: &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)},
+		log:   log.Logger,
+	}
+	sess.SetRemoteAddr(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1234})
+
+	conn := &fakes.UDPConn{}
+	sess.rtpConn = conn
+	rtcpWritten := bytes.NewBuffer([]byte{})
+	sess.rtcpConn = &fakes.UDPConn{
+		Writers: map[string]io.Writer{
+			"127.0.0.1:1235": rtcpWritten,
+		},
+	}
+
+	rtpReader := NewRTPReader(sess)
+	rtpReader.NACKEnabled = true
+	sess.sendSSRC.Store(5678)
+
+	buf := make([]byte, 1500)
+	for _, seq := range []uint16{10, 11, 14, 15, 18} {
+		// NACK is negotiated after first gap
+		if seq == 15 {
+			sess.nackNegotiated.Store(true)
+		}
+		pkt := rtp.Packet{
+			Header: rtp.Header{
+				SSRC:           1234,
+				Version:        2,
+				PayloadType:    0,
+				SequenceNumber: seq,
+			},
+			Payload: []byte("1234"),
+		}
+		data, _ := pkt.Marshal()
+		conn.Reader = bytes.NewBuffer(data)
+		_, err := rtpReader.Read(buf)
+		require.NoError(t, err)
+	}
+
+	pkts, err := rtcp.Unmarshal(rtcpWritten.Bytes())
+	require.NoError(t, err)
+	require.Len(t, pkts, 1)
+	nack := pkts[0].(*rtcp.TransportLayerNack)
+	require.Equal(t, uint32(1234), nack.MediaSSRC)
+	require.Equal(t, uint32(5678), nack.SenderSSRC)
+	require.Equal(t, []uint16{16, 17}, nack.Nacks[0].PacketList())
+}
+
+type rtpPacketsReader struct {
+	pkts [][]byte
+}
+
+func (r *rtpPacketsReader) Read(b []byte) (int, error) {
+	if len(r.pkts) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(b, r.pkts[0])
+	r.pkts = r.pkts[1:]
+	return n, nil
+}
+
+func TestMediaSessionNACKNegotiation(t *testing.T) {
+	sessA, err := NewMediaSession(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, nil)
+	require.NoError(t, err)
+	defer sessA.Close()
+	sessB, err := NewMediaSession(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, nil)
+	require.NoError(t, err)
+	defer sessB.Close()
+
+	sessA.NACK = true
+	sessB.NACK = true
+	offer := sessB.LocalSDP()
+	require.Contains(t, string(offer), "a=rtcp-fb:* nack")
+	require.NoError(t, sessA.RemoteSDP(offer))
+	require.Contains(t, string(sessA.LocalSDP()), "a=rtcp-fb:* nack")
+	require.True(t, sessA.nackNegotiated.Load())
+
+	// Remote without NACK does not get it
+	sessB.NACK = false
+	require.NoError(t, sessA.RemoteSDP(sessB.LocalSDP()))
+	require.False(t, sessA.nackNegotiated.Load())
+	require.NotContains(t, string(sessA.LocalSDP()), "rtcp-fb")
+
+	// Re-INVITE enables it again and answer keeps it
+	answer, err := sessA.Renegotiate(offer)
+	require.NoError(t, err)
+	require.Contains(t, string(answer), "a=rtcp-fb:* nack")
+	require.True(t, sessA.nackNegotiated.Load())
+	require.NoError(t, sessA.UpdateDestinationSDP(sessB.LocalSDP()))
+	require.False(t, sessA.nackNegotiated.Load())
+
+	// Sender is SSRC of sent RTP
+	w := NewRTPWriter(sessA)
+	_, err = w.Write([]byte("1234"))
+	require.NoError(t, err)
+	require.Equal(t, w.SSRC, sessA.senderSSRC())
+	require.NotZero(t, sessB.senderSSRC())
+}
+
+func TestRTPReaderPayloadTypes(t *testing.T) {
+	sess := &MediaSession{
+		Formats: sdp.Formats{
+			sdp.FORMAT_TYPE_ULAW, sdp.FORMAT_TYPE_ALAW, "101",
+		},
+		Laddr: &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)},
+		log:   log.Logger,
+	}
+	reader := &rtpPacketsReader{}
+	sess.rtpConn = &fakes.UDPConn{Reader: reader}
+
+	rtpReader := NewRTPReader(sess)
+	require.Empty(t, rtpReader.AcceptPayloadTypes)
+	rtpReader.AcceptPayloadTypes = []uint8{8}
+
+	var cn []byte
+	rtpReader.PayloadHandlers = map[uint8]func(pkt *rtp.Packet){
+		13: func(pkt *rtp.Packet) { cn = append(cn, pkt.Payload...) },
+	}
+	for i, pt := range []uint8{13, 99, 8} {
+		pkt := rtp.Packet{
+			Header:  rtp.Header{SSRC: 1234, Version: 2, PayloadType: pt, SequenceNumber: uint16(i)},
+			Payload: []byte{pt},
+		}
+		data, _ := pkt.Marshal()
+		reader.pkts = append(reader.pkts, data)
+	}
+
+	buf := make([]byte, 1500)
+	n, err := rtpReader.Read(buf)
+	require.NoError(t, err)
+	require.Equal(t, []byte{8}, buf[:n])
+	require.Equal(t, uint8(8), rtpReader.PacketHeader.PayloadType)
+	require.Equal(t, []byte{13}, cn)
+	require.Equal(t, uint64(2), rtpReader.Seq.ReadExtendedSeq())
+}
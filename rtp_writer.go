@@ -1,10 +1,17 @@
 package sipgox
 
 import (
+	"context"
+	"fmt"
 	"math/rand"
+	"slices"
+	"sync"
+	"sync/atomic"
 	"time"
+	"unicode"
 
 	"github.com/emiago/sipgox/sdp"
+	"github.com/pion/rtcp"
 	"github.com/pion/rtp"
 )
 
@@ -17,18 +24,44 @@ type RTPWriter struct {
 	seq RTPExtendedSequenceNumber
 
 	// Some defaults, can be overriten only after creating writer
-	PayloadType        uint8
-	SSRC               uint32
+	PayloadType uint8
+	SSRC        uint32
+	// SampleRate is RTP clock rate of format
 	SampleRate         uint32
 	ClockRateTimestamp uint32
 	clockTicker        *time.Ticker
-	// MTU         uint32
+	// MTU is max size of UDP datagram. Payloads that do not fit are split in multiple packets
+	MTU uint32
+	// PadSize pads payloads with RTP padding up to this size so all packets have uniform size.
+	// Padding is at most 255 bytes, larger payloads or differences are sent unpadded
+	PadSize int
 
 	nextTimestamp uint32
 
 	// After each write this is set as packet.
 	LastPacket rtp.Packet
 	OnRTP      func(pkt *rtp.Packet)
+
+	// retransmitBuf keeps sent packets indexed by seq % size for NACK handling
+	retransmitMu  sync.Mutex
+	retransmitBuf []rtp.Packet
+
+	// counters for sender reports
+	packetCount uint32
+	octetCount  uint32
+	lastWrite   time.Time
+
+	// injector holds audio pushed with PushAudio
+	injector audioInjector
+
+	// csrc are contributing sources set with SetCSRC
+	csrcMu sync.Mutex
+	csrc   []uint32
+	// mixedSources are sources of pushed audio in payload being written
+	mixedSources []uint32
+
+	// audioLevel is level set with SetAudioLevel, with audioLevelSet flag
+	audioLevel atomic.Uint32
 }
 
 // RTP writer packetize payload in RTP packet before passing on media session
@@ -40,15 +73,17 @@ type RTPWriter struct {
 // - Silence detection and marker set
 // - Padding and encryyption
 func NewRTPWriter(sess *MediaSession) *RTPWriter {
-	f := sess.Formats[0]
+	f, c, _, ok := sess.negotiated()
 	var payloadType uint8 = sdp.FormatNumeric(f)
 	var sampleRate uint32 = 8000
-	clockRate := 20 * time.Millisecond
-	switch f {
-	case sdp.FORMAT_TYPE_ALAW:
-	case sdp.FORMAT_TYPE_ULAW:
-		// TODO more support
-	default:
+	clockRate := sess.PacketTime()
+	if ok {
+		// Timestamps advance with RTP clock rate, not audio sample rate.
+		// G.722 is 16000 audio but 8000 RTP clock, so 20ms packet advances 160
+		sampleRate = c.ClockRate
+		// Frame based codecs, ex. GSM, can only send whole frames
+		clockRate = framePacketTime(c, clockRate)
+	} else {
 		sess.log.Warn().Str("format", f).Msg("Unsupported format. Using default clock rate")
 	}
 
@@ -58,7 +93,7 @@ func NewRTPWriter(sess *MediaSession) *RTPWriter {
 		PayloadType: payloadType,
 		SampleRate:  sampleRate,
 		SSRC:        rand.Uint32(),
-		// MTU:         1500,
+		MTU:         1500,
 
 		// TODO: CSRC CSRC is contribution source identifiers.
 		// This is set when media is passed trough mixer/translators and original SSRC wants to be preserverd
@@ -82,7 +117,6 @@ func (w *RTPWriter) updateClockRate(clockRate time.Duration) {
 // For more control or dynamic payload rate check WriteSamples
 // It is not thread safe and order of payload frames is required
 // Has no capabilities (yet):
-// - MTU UDP limit handling
 // - Media clock rate of payload is consistent
 // - Packet loss detection
 // - RTCP generating
@@ -92,7 +126,83 @@ func (p *RTPWriter) Write(b []byte) (int, error) {
 	return n, err
 }
 
+// WriteSamples packetize payload with provided clock rate timestamp.
+// Payload bigger than MTU is split in multiple packets where timestamp is advanced
+// proportionally to fragment size. Marker is only set on first packet.
 func (p *RTPWriter) WriteSamples(payload []byte, clockRateTimestamp uint32, marker bool, payloadType uint8) (int, error) {
+	payload, p.mixedSources = p.mixPushedAudio(payload, payloadType)
+	defer func() { p.mixedSources = nil }()
+
+	maxPayload := p.maxPayloadSize()
+	if maxPayload <= 0 || len(payload) <= maxPayload {
+		return p.writeSamples(payload, clockRateTimestamp, marker, payloadType)
+	}
+
+	var n int
+	var timestampUsed uint32
+	total := len(payload)
+	for offset := 0; offset < total; offset += maxPayload {
+		end := min(offset+maxPayload, total)
+		// Samples are expected to be linear in payload, so timestamp is split by size
+		timestamp := uint32(uint64(clockRateTimestamp)*uint64(end)/uint64(total)) - timestampUsed
+		timestampUsed += timestamp
+
+		nn, err := p.writeSamples(payload[offset:end], timestamp, marker && offset == 0, payloadType)
+		n += nn
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// SetCSRC sets contributing sources (RFC 3550) of following packets. Mixer should
+// set SSRCs of sources combined in stream for speaker attribution downstream.
+// Only first 15 sources are sent. It is safe to call from other goroutine
+func (p *RTPWriter) SetCSRC(csrc ...uint32) {
+	p.csrcMu.Lock()
+	p.csrc = append([]uint32{}, csrc...)
+	p.csrcMu.Unlock()
+}
+
+// contributingSources returns CSRC list of packet with sources of mixed pushed audio
+func (p *RTPWriter) contributingSources() []uint32 {
+	p.csrcMu.Lock()
+	defer p.csrcMu.Unlock()
+	csrc := make([]uint32, 0, len(p.csrc)+len(p.mixedSources))
+	csrc = append(csrc, p.csrc...)
+	for _, s := range p.mixedSources {
+		if !slices.Contains(csrc, s) {
+			csrc = append(csrc, s)
+		}
+	}
+	if len(csrc) > rtpMaxCSRC {
+		csrc = csrc[:rtpMaxCSRC]
+	}
+	return csrc
+}
+
+// maxPayloadSize returns how much payload can fit in single UDP datagram
+// It returns 0 if MTU is not set
+func (p *RTPWriter) maxPayloadSize() int {
+	if p.MTU == 0 {
+		return 0
+	}
+	// IP + UDP + RTP fixed header + CSRC list + audio level extension
+	size := int(p.MTU) - p.Sess.ipHeaderSize() - 8 - 12 - 4*len(p.contributingSources())
+	if p.Sess.audioLevelID.Load() != 0 {
+		size -= 8
+	}
+	return size
+}
+
+func (p *RTPWriter) writeSamples(payload []byte, clockRateTimestamp uint32, marker bool, payloadType uint8) (int, error) {
+	if !p.Sess.sending() {
+		// On hold media clock keeps running, but nothing is sent
+		p.nextTimestamp += clockRateTimestamp
+		return len(payload), nil
+	}
+
 	pkt := rtp.Packet{
 		Header: rtp.Header{
 			Version:     2,
@@ -106,10 +216,15 @@ func (p *RTPWriter) WriteSamples(payload []byte, clockRateTimestamp uint32, mark
 			Timestamp:      p.nextTimestamp,
 			SequenceNumber: p.seq.NextSeqNumber(),
 			SSRC:           p.SSRC,
-			CSRC:           []uint32{},
+			CSRC:           p.contributingSources(),
 		},
 		Payload: payload,
 	}
+	p.setAudioLevelExtension(&pkt.Header)
+	if pad := p.PadSize - len(payload); pad > 0 && pad <= 255 {
+		pkt.Padding = true
+		pkt.PaddingSize = byte(pad)
+	}
 
 	if p.OnRTP != nil {
 		p.OnRTP(&pkt)
@@ -117,7 +232,212 @@ func (p *RTPWriter) WriteSamples(payload []byte, clockRateTimestamp uint32, mark
 
 	p.LastPacket = pkt
 	p.nextTimestamp += clockRateTimestamp
+	p.packetCount++
+	p.octetCount += uint32(len(pkt.Payload))
+	p.lastWrite = time.Now()
+	p.storeRetransmit(pkt)
+	p.Sess.sendSSRC.Store(p.SSRC)
 
 	err := p.Sess.WriteRTP(&pkt)
 	return len(pkt.Payload), err
 }
+
+// TelephoneEventError is returned when event is not in telephone-event range advertised by remote
+type TelephoneEventError struct {
+	Event     uint8
+	Supported sdp.TelephoneEvents
+}
+
+func (e *TelephoneEventError) Error() string {
+	return fmt.Sprintf("telephone event %d not supported by remote (%s)", e.Event, e.Supported)
+}
+
+// WriteDTMF sends digit as RFC 4733 telephone events with negotiated payload type.
+// It blocks for duration of event (~100ms) and audio should not be written meanwhile
+func (p *RTPWriter) WriteDTMF(digit rune) error {
+	event, exists := dtmfEventMapping[digit]
+	if !exists {
+		return fmt.Errorf("invalid DTMF digit %q", digit)
+	}
+	return p.WriteEvent(event)
+}
+
+// WriteEvent sends RFC 4733 event, ex. 16 for flash, like WriteDTMF.
+// If remote advertised telephone-event range without event, TelephoneEventError is returned
+func (p *RTPWriter) WriteEvent(event uint8) error {
+	pt, remote := p.Sess.sendDTMF()
+	if !remote.IsZero() && !remote.Has(event) {
+		return &TelephoneEventError{Event: event, Supported: remote}
+	}
+
+	events := RTPEventEncode(event)
+	for i, ev := range events {
+		// Event updates are sent every 20ms, end events are redundant
+		if i > 0 && i <= 4 {
+			time.Sleep(20 * time.Millisecond)
+		}
+		// All packets of event have same timestamp
+		if _, err := p.writeSamples(DTMFEncode(ev), 0, i == 0, pt); err != nil {
+			return err
+		}
+	}
+	p.nextTimestamp += uint32(events[len(events)-1].Duration)
+	return nil
+}
+
+// rtpMaxCSRC is limit of CSRC count field
+const rtpMaxCSRC = 15
+
+var (
+	// DTMFPause is pause for ',' character in SendDigits
+	DTMFPause = 2 * time.Second
+	// DTMFInterDigitGap is silence between digits in SendDigits
+	DTMFInterDigitGap = 100 * time.Millisecond
+)
+
+// SendDigits sends digit string with WriteDTMF, ex. "1234,#,*9".
+// Comma pauses for DTMFPause and spaces are ignored. onDigit, if not nil, is called after
+// each digit is sent. String is validated before sending. Canceling ctx stops sending
+// between digits and returns ctx error
+func (p *RTPWriter) SendDigits(ctx context.Context, digits string, onDigit func(digit rune)) error {
+	seq := make([]rune, 0, len(digits))
+	for _, r := range digits {
+		r = unicode.ToUpper(r)
+		switch r {
+		case ' ':
+			continue
+		case ',':
+		default:
+			if _, exists := dtmfEventMapping[r]; !exists {
+				return fmt.Errorf("invalid DTMF digit %q", r)
+			}
+		}
+		seq = append(seq, r)
+	}
+
+	for i, r := range seq {
+		if r == ',' {
+			if err := sleepCtx(ctx, DTMFPause); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if i > 0 && seq[i-1] != ',' {
+			if err := sleepCtx(ctx, DTMFInterDigitGap); err != nil {
+				return err
+			}
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := p.WriteDTMF(r); err != nil {
+			return err
+		}
+		if onDigit != nil {
+			onDigit(r)
+		}
+	}
+	return nil
+}
+
+func sleepCtx(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// SenderReport builds RTCP sender report for this stream.
+// Sending it with WriteRTCP allows remote reports to be used for RTT calculation
+func (p *RTPWriter) SenderReport() *rtcp.SenderReport {
+	now := time.Now()
+	rtpTime := p.LastPacket.Timestamp
+	if !p.lastWrite.IsZero() {
+		rtpTime += uint32(now.Sub(p.lastWrite).Seconds() * float64(p.SampleRate))
+	}
+
+	return &rtcp.SenderReport{
+		SSRC:        p.SSRC,
+		NTPTime:     ntpTime(now),
+		RTPTime:     rtpTime,
+		PacketCount: p.packetCount,
+		OctetCount:  p.octetCount,
+	}
+}
+
+// RunReports sends compound RTCP of sender report and VoIP metrics XR of received media
+// when scheduler decides, until ctx is done or write fails
+func (p *RTPWriter) RunReports(ctx context.Context, sched *RTCPScheduler) error {
+	return sched.Run(ctx, func() (int, error) {
+		pkts := p.reports()
+		data, err := rtcpMarshal(pkts)
+		if err != nil {
+			return 0, err
+		}
+		return len(data), p.Sess.WriteRTCPs(pkts)
+	})
+}
+
+// reports are packets of compound RTCP sent by RunReports
+func (p *RTPWriter) reports() []rtcp.Packet {
+	pkts := []rtcp.Packet{p.SenderReport()}
+	if xr := p.Sess.VoIPMetrics(p.SSRC); xr != nil {
+		pkts = append(pkts, xr)
+	}
+	return pkts
+}
+
+// EnableRetransmission keeps last size sent packets so that they can be retransmitted on NACK.
+// Should be called before writing
+func (p *RTPWriter) EnableRetransmission(size int) {
+	p.retransmitMu.Lock()
+	p.retransmitBuf = make([]rtp.Packet, size)
+	p.retransmitMu.Unlock()
+}
+
+func (p *RTPWriter) storeRetransmit(pkt rtp.Packet) {
+	p.retransmitMu.Lock()
+	defer p.retransmitMu.Unlock()
+	if len(p.retransmitBuf) == 0 {
+		return
+	}
+
+	// Payload can be reused by caller so it must be copied
+	pkt.Payload = append([]byte(nil), pkt.Payload...)
+	p.retransmitBuf[int(pkt.SequenceNumber)%len(p.retransmitBuf)] = pkt
+}
+
+// HandleNACK retransmits requested packets that are still cached.
+// Pass NACKs received from RTCP reading. It returns number of retransmitted packets
+func (p *RTPWriter) HandleNACK(nack *rtcp.TransportLayerNack) (int, error) {
+	if nack.MediaSSRC != p.SSRC {
+		return 0, nil
+	}
+
+	p.retransmitMu.Lock()
+	pkts := []rtp.Packet{}
+	if size := len(p.retransmitBuf); size > 0 {
+		for _, pair := range nack.Nacks {
+			pair.Range(func(seq uint16) bool {
+				pkt := p.retransmitBuf[int(seq)%size]
+				if pkt.SequenceNumber == seq && pkt.SSRC == p.SSRC {
+					pkts = append(pkts, pkt)
+				}
+				return true
+			})
+		}
+	}
+	p.retransmitMu.Unlock()
+
+	for i := range pkts {
+		if err := p.Sess.WriteRTP(&pkts[i]); err != nil {
+			return i, err
+		}
+	}
+	return len(pkts), nil
+}
@@ -8,6 +8,8 @@ import (
 
 	"github.com/emiago/sipgo/fakes"
 	"github.com/emiago/sipgox/sdp"
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
 	"github.com/rs/zerolog/log"
 	"github.com/stretchr/testify/require"
 )
@@ -47,3 +49,206 @@ func TestRTPWriter(t *testing.T) {
 		require.Equal(t, len(payload), len(pkt.Payload))
 	}
 }
+
+func TestRTPWriterMTU(t *testing.T) {
+	sess := &MediaSession{
+		Formats: sdp.Formats{
+			sdp.FORMAT_TYPE_ULAW,
+		},
+		Laddr: &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)},
+		Raddr: &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1234},
+	}
+	sess.SetLogger(log.Logger)
+
+	written := bytes.NewBuffer([]byte{})
+	conn := &fakes.UDPConn{
+		Writers: map[string]io.Writer{
+			"127.0.0.1:1234": written,
+		},
+	}
+	sess.rtpConn = conn
+
+	rtpWriter := NewRTPWriter(sess)
+	rtpWriter.MTU = 100 // 60 bytes of payload per packet
+
+	pkts := []rtp.Packet{}
+	rtpWriter.OnRTP = func(pkt *rtp.Packet) {
+		pkts = append(pkts, *pkt)
+	}
+
+	payload := make([]byte, 160)
+	n, err := rtpWriter.WriteSamples(payload, 160, true, 0)
+	require.NoError(t, err)
+	require.Equal(t, 160, n)
+
+	require.Len(t, pkts, 3)
+	require.Equal(t, []int{60, 60, 40}, []int{len(pkts[0].Payload), len(pkts[1].Payload), len(pkts[2].Payload)})
+	require.True(t, pkts[0].Marker)
+	require.False(t, pkts[1].Marker)
+	require.Equal(t, pkts[0].Timestamp+60, pkts[1].Timestamp)
+	require.Equal(t, pkts[1].Timestamp+60, pkts[2].Timestamp)
+	require.Equal(t, pkts[0].Timestamp+160, rtpWriter.nextTimestamp)
+	require.Equal(t, pkts[0].SequenceNumber+2, pkts[2].SequenceNumber)
+
+	// IPv6 header is 20 bytes bigger
+	sess.SetRemoteAddr(&net.UDPAddr{IP: net.IPv6loopback, Port: 1234})
+	require.Equal(t, 40, rtpWriter.maxPayloadSize())
+}
+
+func TestRTPWriterHandleNACK(t *testing.T) {
+	sess := &MediaSession{
+		Formats: sdp.Formats{
+			sdp.FORMAT_TYPE_ULAW,
+		},
+		Laddr: &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)},
+		Raddr: &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1234},
+	}
+	sess.SetLogger(log.Logger)
+
+	written := bytes.NewBuffer([]byte{})
+	sess.rtpConn = &fakes.UDPConn{
+		Writers: map[string]io.Writer{
+			"127.0.0.1:1234": written,
+		},
+	}
+
+	rtpWriter := NewRTPWriter(sess)
+	rtpWriter.EnableRetransmission(16)
+
+	seqs := []uint16{}
+	for i := 0; i < 5; i++ {
+		_, err := rtpWriter.WriteSamples([]byte{byte(i)}, 160, false, 0)
+		require.NoError(t, err)
+		seqs = append(seqs, rtpWriter.LastPacket.SequenceNumber)
+	}
+	written.Reset()
+
+	n, err := rtpWriter.HandleNACK(&rtcp.TransportLayerNack{
+		MediaSSRC: rtpWriter.SSRC,
+		Nacks:     rtcp.NackPairsFromSequenceNumbers([]uint16{seqs[1], seqs[3]}),
+	})
+	require.NoError(t, err)
+	require.Equal(t, 2, n)
+
+	// Both packets are in same buffer, each is 12 bytes header + 1 byte payload
+	pkt := rtp.Packet{}
+	require.NoError(t, pkt.Unmarshal(written.Bytes()[:13]))
+	require.Equal(t, seqs[1], pkt.SequenceNumber)
+	require.Equal(t, []byte{1}, pkt.Payload)
+}
+
+func TestRTPWriterPadding(t *testing.T) {
+	sess := &MediaSession{
+		Formats: sdp.Formats{
+			sdp.FORMAT_TYPE_ULAW,
+		},
+		Laddr: &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)},
+		Raddr: &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1234},
+	}
+	sess.SetLogger(log.Logger)
+
+	written := bytes.NewBuffer([]byte{})
+	sess.rtpConn = &fakes.UDPConn{
+		Writers: map[string]io.Writer{
+			"127.0.0.1:1234": written,
+		},
+	}
+
+	rtpWriter := NewRTPWriter(sess)
+	rtpWriter.PadSize = 172
+
+	payloads := [][]byte{bytes.Repeat([]byte{1}, 160), bytes.Repeat([]byte{2}, 100), bytes.Repeat([]byte{3}, 172)}
+	for _, p := range payloads {
+		_, err := rtpWriter.Write(p)
+		require.NoError(t, err)
+	}
+
+	// All packets are 12 bytes header + 172
+	data := written.Bytes()
+	require.Len(t, data, 3*184)
+	for i, p := range payloads {
+		pkt := rtp.Packet{}
+		require.NoError(t, rtpUnmarshal(data[i*184:(i+1)*184], &pkt))
+		require.Equal(t, p, pkt.Payload)
+		require.Equal(t, len(p) < 172, pkt.Padding)
+	}
+}
+
+func TestRTPWriterPushAudio(t *testing.T) {
+	sess := &MediaSession{
+		Formats: sdp.Formats{sdp.FORMAT_TYPE_ULAW},
+		Laddr:   &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)},
+		Raddr:   &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1234},
+	}
+	sess.SetLogger(log.Logger)
+	sess.rtpConn = &fakes.UDPConn{
+		Writers: map[string]io.Writer{
+			"127.0.0.1:1234": bytes.NewBuffer([]byte{}),
+		},
+	}
+
+	w := NewRTPWriter(sess)
+	primary := bytes.Repeat([]byte{ulawEncode(1000)}, 4)
+	alert := bytes.Repeat([]byte{ulawEncode(2000)}, 6)
+
+	require.NoError(t, w.PushAudio(alert[:2], PushAudioOptions{Priority: AudioPriorityReplace}))
+	require.NoError(t, w.PushAudio(alert[2:], PushAudioOptions{Priority: AudioPriorityDuck, DuckGain: 0.5}))
+	require.Equal(t, 6, w.PushedAudio())
+
+	_, err := w.WriteSamples(primary, 4, false, w.PayloadType)
+	require.NoError(t, err)
+	out := w.LastPacket.Payload
+	require.Equal(t, alert[:2], out[:2])
+	require.InDelta(t, 2500, ulawDecode(out[2]), 100)
+	require.Equal(t, ulawEncode(1000), primary[2], "primary payload must not be modified")
+
+	// Rest is mixed into next write, then primary passes untouched
+	_, err = w.WriteSamples(primary, 4, false, w.PayloadType)
+	require.NoError(t, err)
+	require.InDelta(t, 2500, ulawDecode(w.LastPacket.Payload[1]), 100)
+	require.Equal(t, primary[2:], w.LastPacket.Payload[2:])
+	require.Equal(t, 0, w.PushedAudio())
+
+	require.NoError(t, w.PushAudio(alert, PushAudioOptions{}))
+	_, err = w.WriteSamples(primary, 4, false, w.PayloadType)
+	require.NoError(t, err)
+	require.InDelta(t, 3000, ulawDecode(w.LastPacket.Payload[0]), 150)
+	w.ClearPushedAudio()
+	require.Equal(t, 0, w.PushedAudio())
+
+	w.PayloadType = 96
+	require.Error(t, w.PushAudio(alert, PushAudioOptions{}))
+}
+
+func TestRTPWriterTelephoneEvents(t *testing.T) {
+	sess := &MediaSession{
+		Formats: sdp.Formats{sdp.FORMAT_TYPE_ULAW},
+		Laddr:   &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)},
+		Raddr:   &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1234},
+	}
+	sess.SetLogger(log.Logger)
+	sess.rtpConn = &fakes.UDPConn{
+		Writers: map[string]io.Writer{
+			"127.0.0.1:1234": bytes.NewBuffer([]byte{}),
+		},
+	}
+
+	// Remote advertised only DTMF without flash
+	ip := net.IPv4(127, 0, 0, 1)
+	remote := sdp.SetTelephoneEvents(sdp.GenerateForAudio(ip, ip, 1234, sdp.ModeSendrecv, sdp.NewFormats("0")), "101", sdp.DTMFTelephoneEvents)
+	require.NoError(t, sess.RemoteSDP(remote))
+
+	w := NewRTPWriter(sess)
+	require.NoError(t, w.WriteDTMF('5'))
+	require.Equal(t, uint8(101), w.LastPacket.PayloadType)
+
+	err := w.WriteEvent(16)
+	var evErr *TelephoneEventError
+	require.ErrorAs(t, err, &evErr)
+	require.Equal(t, uint8(16), evErr.Event)
+
+	// Local extended events are advertised
+	sess.TelephoneEvents = sdp.NewTelephoneEventRange(0, 16)
+	sess.TelephoneEvents.AddRange(32, 49)
+	require.Contains(t, string(sess.LocalSDP()), "a=fmtp:101 0-16,32-49\r\n")
+}
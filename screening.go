@@ -0,0 +1,217 @@
+package sipgox
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// ScreeningDecision is outcome of call screening
+type ScreeningDecision int
+
+const (
+	ScreeningAccepted ScreeningDecision = iota
+	ScreeningDeclined
+	// ScreeningNoAnswer is when target could not be called or did not decide in time
+	ScreeningNoAnswer
+)
+
+func (d ScreeningDecision) String() string {
+	switch d {
+	case ScreeningAccepted:
+		return "accepted"
+	case ScreeningDeclined:
+		return "declined"
+	case ScreeningNoAnswer:
+		return "no answer"
+	}
+	return "unknown"
+}
+
+// ScreeningLeg is media of call leg taking part in screening
+type ScreeningLeg struct {
+	Reader RTPStreamReader
+	Writer RTPStreamWriter
+	// Digits receives DTMF digits of leg. See NewScreeningLeg
+	Digits <-chan rune
+	// Hangup is optional and called when screening does not continue with leg
+	Hangup func()
+	// Session is media session of leg used by default CallScreener Bridge. See NewScreeningLeg
+	Session *MediaSession
+}
+
+// NewScreeningLeg creates leg on reader and writer of media session.
+// Reader OnDTMF is replaced to pass digits to screening
+func NewScreeningLeg(r *RTPReader, w *RTPWriter) ScreeningLeg {
+	digits := make(chan rune, 10)
+	r.OnDTMF = func(digit rune) {
+		select {
+		case digits <- digit:
+		default:
+		}
+	}
+	return ScreeningLeg{Reader: r, Writer: w, Digits: digits, Session: r.Sess}
+}
+
+// ScreeningResult is result of Screen
+type ScreeningResult struct {
+	Decision ScreeningDecision
+	// Name is recorded name of caller
+	Name Greeting
+}
+
+// CallScreener runs call screening: caller records name, target is called and hears
+// name whispered, and target accepts with 1 or declines with 2. Accepted call is bridged and
+// declined or unanswered call is sent to voicemail. Bridging and voicemail can be done by application
+// with Bridge and Voicemail, so screener can be composed with any media handling
+type CallScreener struct {
+	// PayloadType of both legs. Only PCMU and PCMA are supported
+	PayloadType uint8
+
+	// NamePrompt is played to caller before recording, ex. "Please say your name"
+	NamePrompt []byte
+	// WhisperPrompt is played to target before name, ex. "You have call from"
+	WhisperPrompt []byte
+	// DecisionPrompt is played to target after name, ex. "Press 1 to accept or 2 to decline"
+	DecisionPrompt []byte
+
+	// NameDuration limits name recording. Default 5s
+	NameDuration time.Duration
+	// SilenceTimeout stops name recording after silence once speech is detected. Default 1s
+	SilenceTimeout time.Duration
+	// DecisionTimeout is waiting for target decision. Default 15s
+	DecisionTimeout time.Duration
+	// FrameSize is number of samples written per packet. Default 160
+	FrameSize int
+
+	// Dial calls screening target. Error is treated as no answer
+	Dial func(ctx context.Context) (ScreeningLeg, error)
+	// Bridge connects caller with target which accepted call.
+	// Default runs Bridge on sessions of legs until ctx is done or one of sessions is closed
+	Bridge func(ctx context.Context, caller ScreeningLeg, target ScreeningLeg) error
+	// Voicemail is optional and handles declined or unanswered call
+	Voicemail func(ctx context.Context, caller ScreeningLeg, name Greeting) error
+}
+
+func (s *CallScreener) defaults() {
+	if s.NameDuration == 0 {
+		s.NameDuration = 5 * time.Second
+	}
+	if s.SilenceTimeout == 0 {
+		s.SilenceTimeout = time.Second
+	}
+	if s.DecisionTimeout == 0 {
+		s.DecisionTimeout = 15 * time.Second
+	}
+	if s.FrameSize == 0 {
+		s.FrameSize = 160
+	}
+}
+
+// recorder returns greeting recorder on leg, used for recording and collecting digits
+func (s *CallScreener) recorder(leg ScreeningLeg) *GreetingRecorder {
+	rec := &GreetingRecorder{
+		Reader:         leg.Reader,
+		Writer:         leg.Writer,
+		Digits:         leg.Digits,
+		PayloadType:    s.PayloadType,
+		MaxDuration:    s.NameDuration,
+		SilenceTimeout: s.SilenceTimeout,
+		FrameSize:      s.FrameSize,
+		Peak:           0.9,
+	}
+	rec.defaults()
+	return rec
+}
+
+// Screen runs screening for caller and returns after call is bridged or sent to voicemail
+func (s *CallScreener) Screen(ctx context.Context, caller ScreeningLeg) (ScreeningResult, error) {
+	s.defaults()
+	if _, _, ok := g711Funcs(s.PayloadType); !ok {
+		return ScreeningResult{}, fmt.Errorf("screening payload type %d not supported", s.PayloadType)
+	}
+	if s.Dial == nil {
+		return ScreeningResult{}, fmt.Errorf("screening dial is not set")
+	}
+	if s.Bridge == nil && caller.Session == nil {
+		return ScreeningResult{}, fmt.Errorf("screening bridge is not set and caller leg has no session")
+	}
+
+	name, err := s.recordName(ctx, caller)
+	if err != nil {
+		return ScreeningResult{}, err
+	}
+	result := ScreeningResult{Name: name, Decision: ScreeningNoAnswer}
+
+	target, err := s.Dial(ctx)
+	if err == nil {
+		result.Decision, err = s.whisper(ctx, target, name)
+		if err != nil {
+			hangupLeg(target)
+			return result, err
+		}
+	}
+
+	if result.Decision == ScreeningAccepted {
+		return result, s.bridge(ctx, caller, target)
+	}
+	hangupLeg(target)
+	if s.Voicemail == nil {
+		return result, nil
+	}
+	return result, s.Voicemail(ctx, caller, name)
+}
+
+// bridge calls Bridge or bridges sessions of legs
+func (s *CallScreener) bridge(ctx context.Context, caller ScreeningLeg, target ScreeningLeg) error {
+	if s.Bridge != nil {
+		return s.Bridge(ctx, caller, target)
+	}
+	if target.Session == nil {
+		hangupLeg(target)
+		return fmt.Errorf("screening target leg has no session to bridge")
+	}
+	return NewBridge(caller.Session, target.Session).Run(ctx)
+}
+
+func hangupLeg(leg ScreeningLeg) {
+	if leg.Hangup != nil {
+		leg.Hangup()
+	}
+}
+
+// recordName prompts caller and records name until # or silence
+func (s *CallScreener) recordName(ctx context.Context, caller ScreeningLeg) (Greeting, error) {
+	rec := s.recorder(caller)
+	if err := rec.play(ctx, s.NamePrompt); err != nil {
+		return Greeting{}, err
+	}
+	name, err := rec.record(ctx)
+	if err != nil {
+		return Greeting{}, err
+	}
+	return rec.process(name)
+}
+
+// whisper plays name to target and waits decision
+func (s *CallScreener) whisper(ctx context.Context, target ScreeningLeg, name Greeting) (ScreeningDecision, error) {
+	rec := s.recorder(target)
+	rec.drainDigits()
+	for _, audio := range [][]byte{s.WhisperPrompt, name.Audio, s.DecisionPrompt} {
+		if err := rec.play(ctx, audio); err != nil {
+			return ScreeningNoAnswer, err
+		}
+	}
+
+	digit, err := rec.waitDigit(ctx, s.DecisionTimeout)
+	if err != nil {
+		return ScreeningNoAnswer, err
+	}
+	switch digit {
+	case '1':
+		return ScreeningAccepted, nil
+	case 0:
+		return ScreeningNoAnswer, nil
+	}
+	return ScreeningDeclined, nil
+}
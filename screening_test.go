@@ -0,0 +1,142 @@
+package sipgox
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/emiago/sipgox/sdp"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCallScreener(t *testing.T) {
+	callerLeg := func() ScreeningLeg {
+		reader := &greetingFakeReader{frames: func(i int) []byte {
+			if i <= 10 {
+				return greetingTone(160, 4000)
+			}
+			return greetingSilence(160)
+		}}
+		return ScreeningLeg{Reader: reader, Writer: &greetingFakeWriter{}, Digits: make(chan rune, 10)}
+	}
+	targetLeg := func(digit rune) (ScreeningLeg, *bool) {
+		digits := make(chan rune, 10)
+		reader := &greetingFakeReader{frames: func(i int) []byte {
+			if i == 5 && digit != 0 {
+				digits <- digit
+			}
+			return greetingSilence(160)
+		}}
+		hungup := false
+		return ScreeningLeg{Reader: reader, Writer: &greetingFakeWriter{}, Digits: digits, Hangup: func() { hungup = true }}, &hungup
+	}
+
+	for _, tc := range []struct {
+		digit     rune
+		decision  ScreeningDecision
+		bridged   bool
+		voicemail bool
+	}{
+		{digit: '1', decision: ScreeningAccepted, bridged: true},
+		{digit: '2', decision: ScreeningDeclined, voicemail: true},
+		{digit: 0, decision: ScreeningNoAnswer, voicemail: true},
+	} {
+		t.Run(tc.decision.String(), func(t *testing.T) {
+			target, hungup := targetLeg(tc.digit)
+			bridged, voicemail := false, false
+			s := &CallScreener{
+				WhisperPrompt:   greetingTone(320, 1000),
+				DecisionTimeout: 50 * time.Millisecond,
+				SilenceTimeout:  100 * time.Millisecond,
+				Dial: func(ctx context.Context) (ScreeningLeg, error) {
+					return target, nil
+				},
+				Bridge: func(ctx context.Context, caller, target ScreeningLeg) error {
+					bridged = true
+					return nil
+				},
+				Voicemail: func(ctx context.Context, caller ScreeningLeg, name Greeting) error {
+					voicemail = true
+					require.NotEmpty(t, name.Audio)
+					return nil
+				},
+			}
+
+			res, err := s.Screen(context.Background(), callerLeg())
+			require.NoError(t, err)
+			require.Equal(t, tc.decision, res.Decision)
+			require.Equal(t, tc.bridged, bridged)
+			require.Equal(t, tc.voicemail, voicemail)
+			require.Equal(t, !tc.bridged, *hungup)
+
+			// Target heard whisper prompt followed by name
+			written := target.Writer.(*greetingFakeWriter).written
+			require.Equal(t, 320+len(res.Name.Audio), len(written))
+		})
+	}
+	t.Run("validate", func(t *testing.T) {
+		s := &CallScreener{}
+		_, err := s.Screen(context.Background(), callerLeg())
+		require.Error(t, err)
+
+		s.Dial = func(ctx context.Context) (ScreeningLeg, error) {
+			t.Fatal("dial with invalid screener")
+			return ScreeningLeg{}, nil
+		}
+		_, err = s.Screen(context.Background(), callerLeg())
+		require.Error(t, err)
+	})
+
+	t.Run("default bridge", func(t *testing.T) {
+		a, caller := bridgePair(t, sdp.FORMAT_TYPE_ULAW)
+		b, callee := bridgePair(t, sdp.FORMAT_TYPE_ULAW)
+		callerScreen := callerLeg()
+		callerScreen.Session = a
+		target, _ := targetLeg('1')
+		target.Session = b
+
+		s := &CallScreener{
+			DecisionTimeout: 50 * time.Millisecond,
+			SilenceTimeout:  100 * time.Millisecond,
+			Dial: func(ctx context.Context) (ScreeningLeg, error) {
+				return target, nil
+			},
+		}
+		type screened struct {
+			res ScreeningResult
+			err error
+		}
+		done := make(chan screened)
+		go func() {
+			res, err := s.Screen(context.Background(), callerScreen)
+			done <- screened{res, err}
+		}()
+
+		// Callee is bridged to caller once accepted
+		w := NewRTPWriter(callee)
+		r := NewRTPReader(caller)
+		buf := make([]byte, 1500)
+		ctx := ctxTimeout(t, 2*time.Second)
+		for i := 0; ; i++ {
+			_, err := w.WriteSamples([]byte{1, 2, 3}, 160, i == 0, w.PayloadType)
+			require.NoError(t, err)
+			rctx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+			n, err := r.ReadCtx(rctx, buf)
+			cancel()
+			if err == nil {
+				require.Equal(t, []byte{1, 2, 3}, buf[:n])
+				break
+			}
+			require.NoError(t, ctx.Err())
+		}
+
+		a.Close()
+		select {
+		case sc := <-done:
+			require.NoError(t, sc.err)
+			require.Equal(t, ScreeningAccepted, sc.res.Decision)
+		case <-time.After(time.Second):
+			t.Fatal("screening not stopped")
+		}
+	})
+}
@@ -0,0 +1,117 @@
+package sdp
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	// BandwidthAS is application specific maximum in kilobits per second (RFC 4566)
+	BandwidthAS = "AS"
+	// BandwidthTIAS is transport independent maximum in bits per second (RFC 3890)
+	BandwidthTIAS = "TIAS"
+)
+
+// Bandwidth is bandwidth line
+// b=<bwtype>:<bandwidth>
+// https://tools.ietf.org/html/rfc4566#section-5.8
+type Bandwidth struct {
+	Type  string
+	Value int
+}
+
+func (b Bandwidth) String() string {
+	return "b=" + b.Type + ":" + strconv.Itoa(b.Value)
+}
+
+// BitsPerSecond returns bandwidth in bits per second. Unknown types return 0
+func (b Bandwidth) BitsPerSecond() int {
+	switch b.Type {
+	case BandwidthAS:
+		return b.Value * 1000
+	case BandwidthTIAS:
+		return b.Value
+	}
+	return 0
+}
+
+// ParseBandwidth parses bandwidth line value, ex. "AS:64"
+func ParseBandwidth(v string) (Bandwidth, error) {
+	typ, value, found := strings.Cut(v, ":")
+	if !found {
+		return Bandwidth{}, fmt.Errorf("invalid bandwidth %q", v)
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil {
+		return Bandwidth{}, fmt.Errorf("invalid bandwidth value %q", value)
+	}
+	return Bandwidth{Type: typ, Value: n}, nil
+}
+
+// Bandwidths returns bandwidth lines of media, or session level ones if media has none.
+// Empty mediaType returns session level lines. Invalid lines are skipped
+func (sd SessionDescription) Bandwidths(mediaType string) []Bandwidth {
+	values := sd.levels("b")[0]
+	if mediaType != "" {
+		if media := sd.mediaValues("b", mediaType); len(media) > 0 {
+			values = media
+		}
+	}
+
+	bws := make([]Bandwidth, 0, len(values))
+	for _, v := range values {
+		bw, err := ParseBandwidth(v)
+		if err != nil {
+			continue
+		}
+		bws = append(bws, bw)
+	}
+	return bws
+}
+
+// AppendBandwidth adds bandwidth lines to media section of SDP body, after its c= line.
+// Empty mediaType adds session level lines before t= line
+func AppendBandwidth(body []byte, mediaType string, bws ...Bandwidth) []byte {
+	if len(bws) == 0 {
+		return body
+	}
+
+	lines := strings.Split(strings.ReplaceAll(string(body), "\r\n", "\n"), "\n")
+	res := make([]string, 0, len(lines)+len(bws))
+	section := ""
+	inserted := false
+	insert := func() {
+		for _, bw := range bws {
+			res = append(res, bw.String())
+		}
+		inserted = true
+	}
+
+	for i, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if m, found := strings.CutPrefix(line, "m="); found {
+			section, _, _ = strings.Cut(m, " ")
+		}
+		if !inserted && mediaType == "" && strings.HasPrefix(line, "t=") {
+			insert()
+		}
+		res = append(res, line)
+
+		if inserted || section != mediaType || mediaType == "" {
+			continue
+		}
+		// Lines before b= in media section are i= and c=
+		next := ""
+		if i+1 < len(lines) {
+			next = strings.TrimSpace(lines[i+1])
+		}
+		if !strings.HasPrefix(next, "i=") && !strings.HasPrefix(next, "c=") {
+			insert()
+		}
+	}
+	return []byte(strings.Join(res, "\r\n") + "\r\n")
+}
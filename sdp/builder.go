@@ -0,0 +1,224 @@
+package sdp
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Line is single type=value line of SDP
+type Line struct {
+	Type  string
+	Value string
+}
+
+func (l Line) String() string {
+	return l.Type + "=" + l.Value
+}
+
+// Line order on session and media level as in RFC 4566 5
+const (
+	sessionLineOrder = "vosiuepcbtrzka"
+	mediaLineOrder   = "micbka"
+)
+
+// Section is session or media level of SDP. Lines are kept as parsed, including unknown ones
+type Section struct {
+	Lines []Line
+	order string
+}
+
+// Builder edits SDP while keeping every line and its order, so that proprietary attributes
+// survive proxying. Unlike SessionDescription it can be marshaled back.
+//
+//	b, _ := sdp.ParseBuilder(body)
+//	b.Session.AddAttribute("X-nat:0")
+//	b.Media("audio").SetAttribute("silenceSupp", "off - - - -")
+//	body = b.Marshal()
+type Builder struct {
+	Session *Section
+	Medias  []*Section
+}
+
+// ParseBuilder parses SDP body into builder. Use it with Generate to build new SDP
+func ParseBuilder(body []byte) (*Builder, error) {
+	b := &Builder{Session: &Section{order: sessionLineOrder}}
+	sec := b.Session
+	for _, line := range strings.Split(string(body), "\n") {
+		line = strings.TrimRight(line, "\r")
+		if line == "" {
+			continue
+		}
+		typ, value, found := strings.Cut(line, "=")
+		if !found || typ == "" {
+			return nil, fmt.Errorf("Not a type=value line found. line=%q", line)
+		}
+		if typ == "m" {
+			sec = &Section{order: mediaLineOrder}
+			b.Medias = append(b.Medias, sec)
+		}
+		sec.Lines = append(sec.Lines, Line{Type: typ, Value: value})
+	}
+	return b, nil
+}
+
+// Media returns first media section of type, or nil if there is none
+func (b *Builder) Media(mediaType string) *Section {
+	for _, m := range b.Medias {
+		if m.MediaType() == mediaType {
+			return m
+		}
+	}
+	return nil
+}
+
+// AddMedia appends media section generated from description
+func (b *Builder) AddMedia(md MediaDescription) *Section {
+	sec := &Section{order: mediaLineOrder}
+	for _, l := range md.lines() {
+		typ, value, _ := strings.Cut(l, "=")
+		sec.Lines = append(sec.Lines, Line{Type: typ, Value: value})
+	}
+	b.Medias = append(b.Medias, sec)
+	return sec
+}
+
+// Marshal returns SDP body with CRLF line endings
+func (b *Builder) Marshal() []byte {
+	var sb strings.Builder
+	for _, sec := range append([]*Section{b.Session}, b.Medias...) {
+		for _, l := range sec.Lines {
+			sb.WriteString(l.String())
+			sb.WriteString("\r\n")
+		}
+	}
+	return []byte(sb.String())
+}
+
+// SessionDescription returns parsed marshaled SDP
+func (b *Builder) SessionDescription() (SessionDescription, error) {
+	sd := SessionDescription{}
+	err := Unmarshal(b.Marshal(), &sd)
+	return sd, err
+}
+
+// MediaType returns media type of m= line. Empty for session section
+func (s *Section) MediaType() string {
+	v := s.Value("m")
+	media, _, _ := strings.Cut(v, " ")
+	return media
+}
+
+// Value returns first value of line type
+func (s *Section) Value(typ string) string {
+	for _, l := range s.Lines {
+		if l.Type == typ {
+			return l.Value
+		}
+	}
+	return ""
+}
+
+// Values returns values of line type in order
+func (s *Section) Values(typ string) []string {
+	var values []string
+	for _, l := range s.Lines {
+		if l.Type == typ {
+			values = append(values, l.Value)
+		}
+	}
+	return values
+}
+
+// Add adds line keeping RFC 4566 order of line types. Line is placed after existing lines of same type
+func (s *Section) Add(typ string, value string) {
+	rank := strings.Index(s.order, typ)
+	i := len(s.Lines)
+	if rank >= 0 {
+		for i > 0 {
+			r := strings.Index(s.order, s.Lines[i-1].Type)
+			if r >= 0 && r <= rank {
+				break
+			}
+			i--
+		}
+	}
+	s.Lines = append(s.Lines, Line{})
+	copy(s.Lines[i+1:], s.Lines[i:])
+	s.Lines[i] = Line{Type: typ, Value: value}
+}
+
+// Set replaces first line of type or adds it
+func (s *Section) Set(typ string, value string) {
+	for i, l := range s.Lines {
+		if l.Type == typ {
+			s.Lines[i].Value = value
+			return
+		}
+	}
+	s.Add(typ, value)
+}
+
+// Remove removes all lines of type for which match returns true. Nil match removes all lines of type
+func (s *Section) Remove(typ string, match func(value string) bool) {
+	lines := s.Lines[:0]
+	for _, l := range s.Lines {
+		if l.Type == typ && (match == nil || match(l.Value)) {
+			continue
+		}
+		lines = append(lines, l)
+	}
+	s.Lines = lines
+}
+
+// SetInformation sets i= line
+func (s *Section) SetInformation(info string) {
+	s.Set("i", info)
+}
+
+// AddBandwidth adds b= line
+func (s *Section) AddBandwidth(bw Bandwidth) {
+	s.Add("b", strings.TrimPrefix(bw.String(), "b="))
+}
+
+// Attribute returns value of attribute, ex. "rtpmap" for a=rtpmap:0 PCMU/8000 returns "0 PCMU/8000".
+// Flag attribute returns empty value
+func (s *Section) Attribute(name string) (string, bool) {
+	for _, a := range s.Values("a") {
+		n, v, _ := strings.Cut(a, ":")
+		if n == name {
+			return v, true
+		}
+	}
+	return "", false
+}
+
+// AddAttribute adds a= line. Attribute is full value without a= prefix, ex. "X-nat:0" or "sendonly"
+func (s *Section) AddAttribute(attribute string) {
+	s.Add("a", attribute)
+}
+
+// SetAttribute replaces first attribute with name or adds it. Empty value sets flag attribute
+func (s *Section) SetAttribute(name string, value string) {
+	attr := name
+	if value != "" {
+		attr += ":" + value
+	}
+	for i, l := range s.Lines {
+		if l.Type != "a" {
+			continue
+		}
+		if n, _, _ := strings.Cut(l.Value, ":"); n == name {
+			s.Lines[i].Value = attr
+			return
+		}
+	}
+	s.AddAttribute(attr)
+}
+
+// RemoveAttribute removes all attributes with name
+func (s *Section) RemoveAttribute(name string) {
+	s.Remove("a", func(value string) bool {
+		n, _, _ := strings.Cut(value, ":")
+		return n == name
+	})
+}
@@ -0,0 +1,328 @@
+package sdp
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Severity of check finding
+type Severity int
+
+const (
+	SeverityInfo Severity = iota
+	SeverityWarning
+	SeverityError
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityWarning:
+		return "warning"
+	case SeverityError:
+		return "error"
+	}
+	return "info"
+}
+
+// Finding is problem found by Check
+type Finding struct {
+	Severity Severity
+	// Line is 1 based line number. 0 if finding is not related to single line
+	Line    int
+	Message string
+}
+
+func (f Finding) String() string {
+	if f.Line == 0 {
+		return f.Severity.String() + ": " + f.Message
+	}
+	return fmt.Sprintf("%s: line %d: %s", f.Severity, f.Line, f.Message)
+}
+
+// knownProtos are media transport protocols with RTP payload type formats
+var knownProtos = map[string]bool{
+	"RTP/AVP":           true,
+	"RTP/AVPF":          true,
+	"RTP/SAVP":          true,
+	"RTP/SAVPF":         true,
+	"UDP/TLS/RTP/SAVP":  true,
+	"UDP/TLS/RTP/SAVPF": true,
+	"TCP/RTP/AVP":       true,
+}
+
+type checkMedia struct {
+	line     int
+	typ      string
+	proto    string
+	formats  []string
+	hasConn  bool
+	rtpmaps  map[string]int
+	fmtps    map[string]int
+	modes    int
+	cryptos  int
+	hasAudio bool
+}
+
+// Check validates SDP against rules of RFC 4566 and RFC 3264 and reports what
+// sipgox can not handle. Findings are ordered by line, with whole SDP findings first.
+// Useful for debugging interop
+func Check(body []byte) []Finding {
+	c := checker{}
+	c.run(string(body))
+	sort.SliceStable(c.findings, func(i, j int) bool {
+		return c.findings[i].Line < c.findings[j].Line
+	})
+	return c.findings
+}
+
+type checker struct {
+	findings []Finding
+}
+
+func (c *checker) add(sev Severity, line int, format string, args ...any) {
+	c.findings = append(c.findings, Finding{Severity: sev, Line: line, Message: fmt.Sprintf(format, args...)})
+}
+
+func (c *checker) run(body string) {
+	if strings.TrimSpace(body) == "" {
+		c.add(SeverityError, 0, "empty SDP")
+		return
+	}
+	if strings.Contains(strings.ReplaceAll(body, "\r\n", ""), "\n") {
+		c.add(SeverityWarning, 0, "lines are not terminated with CRLF")
+	}
+
+	lines := strings.Split(strings.ReplaceAll(body, "\r\n", "\n"), "\n")
+	seen := map[string]bool{}
+	sessionConn := false
+	sessionModes := 0
+	var medias []*checkMedia
+	var media *checkMedia
+
+	for i, line := range lines {
+		n := i + 1
+		if line == "" {
+			continue
+		}
+		if len(line) < 2 || line[1] != '=' || line[0] < 'a' || line[0] > 'z' {
+			c.add(SeverityError, n, "not a <type>=<value> line: %q", line)
+			continue
+		}
+		key, value := line[:1], line[2:]
+
+		if n == 1 && key != "v" {
+			c.add(SeverityError, n, "first line must be v=")
+		}
+
+		switch key {
+		case "v":
+			if value != "0" {
+				c.add(SeverityError, n, "unsupported version %q", value)
+			}
+		case "o":
+			if len(strings.Fields(value)) != 6 {
+				c.add(SeverityError, n, "origin must have 6 fields")
+			}
+		case "c":
+			c.checkConnection(n, value)
+			if media == nil {
+				sessionConn = true
+			} else {
+				media.hasConn = true
+			}
+		case "m":
+			media = c.checkMediaLine(n, value)
+			medias = append(medias, media)
+		case "a":
+			if media == nil {
+				if isMode(value) {
+					sessionModes++
+				}
+				if strings.HasPrefix(value, "crypto:") {
+					c.add(SeverityWarning, n, "crypto on session level is not standard, it is used only for media without crypto")
+				}
+				continue
+			}
+			c.checkMediaAttribute(n, media, value)
+		}
+
+		if media == nil {
+			seen[key] = true
+		}
+	}
+
+	for _, k := range []string{"v", "o", "s", "t"} {
+		if !seen[k] {
+			c.add(SeverityError, 0, "missing session %s= line", k)
+		}
+	}
+	if sessionModes > 1 {
+		c.add(SeverityError, 0, "multiple session level direction attributes")
+	}
+	if len(medias) == 0 {
+		c.add(SeverityError, 0, "no media description")
+		return
+	}
+
+	hasAudio := false
+	for _, m := range medias {
+		c.checkMedia(m, sessionConn)
+		hasAudio = hasAudio || m.hasAudio
+	}
+	if !hasAudio {
+		c.add(SeverityWarning, 0, "no usable audio media, sipgox handles only audio")
+	}
+}
+
+func (c *checker) checkConnection(n int, value string) {
+	fields := strings.Fields(value)
+	if len(fields) != 3 {
+		c.add(SeverityError, n, "connection must have 3 fields")
+		return
+	}
+	if fields[0] != "IN" {
+		c.add(SeverityError, n, "unsupported network type %q", fields[0])
+	}
+	addr, _, _ := strings.Cut(fields[2], "/")
+	ip := net.ParseIP(addr)
+	switch fields[1] {
+	case "IP4":
+		if ip == nil || ip.To4() == nil {
+			c.add(SeverityError, n, "invalid IP4 address %q", addr)
+		}
+	case "IP6":
+		if ip == nil || ip.To4() != nil {
+			c.add(SeverityError, n, "invalid IP6 address %q", addr)
+		}
+	default:
+		c.add(SeverityError, n, "unsupported address type %q", fields[1])
+	}
+}
+
+func (c *checker) checkMediaLine(n int, value string) *checkMedia {
+	m := &checkMedia{line: n, rtpmaps: map[string]int{}, fmtps: map[string]int{}}
+	fields := strings.Fields(value)
+	if len(fields) == 3 && fields[1] == "0" {
+		c.add(SeverityWarning, n, "rejected media without formats")
+		return m
+	}
+	if len(fields) < 4 {
+		c.add(SeverityError, n, "media must have at least 4 fields")
+		return m
+	}
+	m.typ = fields[0]
+	m.proto = fields[2]
+	m.formats = fields[3:]
+
+	port, _, _ := strings.Cut(fields[1], "/")
+	if p, err := strconv.Atoi(port); err != nil || p < 0 || p > 65535 {
+		c.add(SeverityError, n, "invalid media port %q", fields[1])
+	}
+
+	if !knownProtos[m.proto] {
+		c.add(SeverityWarning, n, "unknown media protocol %q", m.proto)
+		return m
+	}
+	for _, f := range m.formats {
+		if pt, err := strconv.Atoi(f); err != nil || pt < 0 || pt > 127 {
+			c.add(SeverityError, n, "invalid RTP payload type %q", f)
+		}
+	}
+	return m
+}
+
+func (c *checker) checkMediaAttribute(n int, m *checkMedia, value string) {
+	name, v, _ := strings.Cut(value, ":")
+	switch name {
+	case "rtpmap":
+		pt, _, _ := strings.Cut(v, " ")
+		if _, err := ParseRTPMap(v); err != nil {
+			c.add(SeverityError, n, "%s", err)
+		}
+		if _, exists := m.rtpmaps[pt]; exists {
+			c.add(SeverityError, n, "duplicate rtpmap for format %s", pt)
+		}
+		m.rtpmaps[pt] = n
+	case "fmtp":
+		pt, _, _ := strings.Cut(v, " ")
+		m.fmtps[pt] = n
+	case "ptime", "maxptime":
+		if _, err := strconv.ParseFloat(v, 64); err != nil {
+			c.add(SeverityWarning, n, "invalid %s %q", name, v)
+		}
+	case "rtcp":
+		if _, err := ParseRTCPAttribute(v); err != nil {
+			c.add(SeverityWarning, n, "%s", err)
+		}
+	case "crypto":
+		m.cryptos++
+		if _, err := ParseCrypto(v); err != nil {
+			c.add(SeverityError, n, "%s", err)
+		}
+	case "candidate":
+		if _, err := ParseICECandidate(v); err != nil {
+			c.add(SeverityError, n, "%s", err)
+		}
+	default:
+		if isMode(value) {
+			m.modes++
+		}
+	}
+}
+
+func (c *checker) checkMedia(m *checkMedia, sessionConn bool) {
+	if !m.hasConn && !sessionConn {
+		c.add(SeverityError, m.line, "no connection address for %s media", m.typ)
+	}
+	if m.modes > 1 {
+		c.add(SeverityError, m.line, "multiple direction attributes in %s media", m.typ)
+	}
+	if !knownProtos[m.proto] {
+		return
+	}
+
+	for _, f := range m.formats {
+		if _, exists := m.rtpmaps[f]; exists {
+			continue
+		}
+		if _, static := staticRTPMaps[f]; !static {
+			c.add(SeverityError, m.line, "format %s has no rtpmap", f)
+		}
+	}
+	for pt, n := range m.rtpmaps {
+		if !containsFormat(m.formats, pt) {
+			c.add(SeverityWarning, n, "rtpmap for format %s not in media description", pt)
+		}
+	}
+	for pt, n := range m.fmtps {
+		if !containsFormat(m.formats, pt) {
+			c.add(SeverityWarning, n, "fmtp for format %s not in media description", pt)
+		}
+	}
+
+	secure := strings.Contains(m.proto, "SAVP")
+	if secure {
+		c.add(SeverityWarning, m.line, "protocol %s requires SRTP which sipgox does not support", m.proto)
+	}
+	if m.cryptos > 0 && !secure {
+		c.add(SeverityWarning, m.line, "crypto attributes are ignored with %s", m.proto)
+	}
+
+	if m.typ != "audio" {
+		c.add(SeverityInfo, m.line, "%s media is ignored by sipgox", m.typ)
+		return
+	}
+	if containsFormat(m.formats, FORMAT_TYPE_ULAW) || containsFormat(m.formats, FORMAT_TYPE_ALAW) {
+		m.hasAudio = !secure
+		return
+	}
+	c.add(SeverityWarning, m.line, "no PCMU or PCMA format, sipgox fully supports only these")
+}
+
+func isMode(attr string) bool {
+	_, ok := findMode([]string{attr})
+	return ok
+}
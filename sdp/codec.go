@@ -0,0 +1,284 @@
+package sdp
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Codec is payload format described by rtpmap and fmtp attributes
+// a=rtpmap:<payload type> <encoding name>/<clock rate>[/<encoding parameters>]
+// a=fmtp:<format> <format specific parameters>
+// https://tools.ietf.org/html/rfc4566#section-6
+type Codec struct {
+	PayloadType uint8
+	Name        string
+	ClockRate   uint32
+	// Channels is number of audio channels. Default 1
+	Channels int
+	// Fmtp is raw format parameters
+	Fmtp string
+	// Params are key=value pairs of fmtp
+	Params map[string]string
+}
+
+// String returns rtpmap encoding, ex. PCMU/8000
+func (c Codec) String() string {
+	s := c.Name + "/" + strconv.FormatUint(uint64(c.ClockRate), 10)
+	if c.Channels > 1 {
+		s += "/" + strconv.Itoa(c.Channels)
+	}
+	return s
+}
+
+// SampleRate returns audio sample rate. It differs from RTP clock rate only for G.722,
+// which is sampled at 16000 but keeps 8000 RTP clock rate for historical reasons (RFC 3551 4.5.2)
+func (c Codec) SampleRate() uint32 {
+	if strings.EqualFold(c.Name, "G722") && c.ClockRate == 8000 {
+		return 16000
+	}
+	return c.ClockRate
+}
+
+// ParseRTPMap parses rtpmap attribute value, ex. "0 PCMU/8000"
+func ParseRTPMap(v string) (Codec, error) {
+	c := Codec{Channels: 1}
+	pt, enc, found := strings.Cut(v, " ")
+	if !found {
+		return c, fmt.Errorf("invalid rtpmap %q", v)
+	}
+
+	n, err := strconv.ParseUint(pt, 10, 8)
+	if err != nil {
+		return c, fmt.Errorf("invalid rtpmap payload type %q", pt)
+	}
+	c.PayloadType = uint8(n)
+
+	parts := strings.Split(strings.TrimSpace(enc), "/")
+	if len(parts) < 2 {
+		return c, fmt.Errorf("invalid rtpmap encoding %q", enc)
+	}
+	c.Name = parts[0]
+
+	rate, err := strconv.ParseUint(parts[1], 10, 32)
+	if err != nil {
+		return c, fmt.Errorf("invalid rtpmap clock rate %q", parts[1])
+	}
+	c.ClockRate = uint32(rate)
+
+	if len(parts) > 2 {
+		if c.Channels, err = strconv.Atoi(parts[2]); err != nil {
+			return c, fmt.Errorf("invalid rtpmap channels %q", parts[2])
+		}
+	}
+	return c, nil
+}
+
+// ParseFmtpParams parses key=value pairs separated by ;
+// Values without key (ex. telephone-event 0-16) are not included
+func ParseFmtpParams(fmtp string) map[string]string {
+	params := map[string]string{}
+	for _, p := range strings.Split(fmtp, ";") {
+		k, v, found := strings.Cut(strings.TrimSpace(p), "=")
+		if !found || k == "" {
+			continue
+		}
+		params[k] = v
+	}
+	return params
+}
+
+// Codecs returns codecs of media in order of m= line formats.
+// Static payload types without rtpmap are filled from RFC 3551
+func (sd SessionDescription) Codecs(mediaType string) ([]Codec, error) {
+	md, err := sd.MediaDescription(mediaType)
+	if err != nil {
+		return nil, err
+	}
+
+	rtpmaps := map[string]string{}
+	fmtps := map[string]string{}
+	for _, a := range sd.Values("a") {
+		if v, found := strings.CutPrefix(a, "rtpmap:"); found {
+			pt, _, _ := strings.Cut(v, " ")
+			rtpmaps[pt] = v
+			continue
+		}
+		if v, found := strings.CutPrefix(a, "fmtp:"); found {
+			pt, params, _ := strings.Cut(v, " ")
+			fmtps[pt] = params
+		}
+	}
+
+	codecs := make([]Codec, 0, len(md.Formats))
+	for _, f := range md.Formats {
+		rtpmap, exists := rtpmaps[f]
+		if !exists {
+			static, ok := staticRTPMaps[f]
+			if !ok {
+				return nil, fmt.Errorf("missing rtpmap for format %s", f)
+			}
+			rtpmap = f + " " + static
+		}
+
+		c, err := ParseRTPMap(rtpmap)
+		if err != nil {
+			return nil, err
+		}
+		if fmtp, exists := fmtps[f]; exists {
+			c.Fmtp = fmtp
+			c.Params = ParseFmtpParams(fmtp)
+		}
+		codecs = append(codecs, c)
+	}
+	return codecs, nil
+}
+
+// G729AnnexB returns whether G.729 Annex B (silence suppression) is used by codec.
+// It is used unless fmtp has annexb=no (RFC 4856)
+func (c Codec) G729AnnexB() bool {
+	return !strings.EqualFold(ParseFmtpParams(c.Fmtp)["annexb"], "no")
+}
+
+// TelephoneEvent returns payload type of telephone-event/8000 in audio media
+func (sd SessionDescription) TelephoneEvent() (string, bool) {
+	md, err := sd.MediaDescription("audio")
+	if err != nil {
+		return "", false
+	}
+
+	for _, a := range sd.Values("a") {
+		v, found := strings.CutPrefix(a, "rtpmap:")
+		if !found {
+			continue
+		}
+		pt, enc, _ := strings.Cut(v, " ")
+		if !strings.EqualFold(strings.TrimSpace(enc), "telephone-event/8000") {
+			continue
+		}
+		for _, f := range md.Formats {
+			if f == pt {
+				return pt, true
+			}
+		}
+	}
+	return "", false
+}
+
+// Equal compares codecs by encoding name, clock rate and channels. Payload type is ignored
+func (c Codec) Equal(o Codec) bool {
+	ch1, ch2 := c.Channels, o.Channels
+	if ch1 == 0 {
+		ch1 = 1
+	}
+	if ch2 == 0 {
+		ch2 = 1
+	}
+	return strings.EqualFold(c.Name, o.Name) && c.ClockRate == o.ClockRate && ch1 == ch2
+}
+
+// StaticCodec returns codec of static payload type format (RFC 3551)
+func StaticCodec(format string) (Codec, bool) {
+	enc, exists := staticRTPMaps[format]
+	if !exists {
+		return Codec{}, false
+	}
+	c, err := ParseRTPMap(format + " " + enc)
+	return c, err == nil
+}
+
+// CodecRegistry maps dynamic payload types (96-127) to codecs.
+// It is safe for concurrent use, so it can be shared by sessions while profiles register codecs
+type CodecRegistry struct {
+	mu     sync.RWMutex
+	codecs map[uint8]Codec
+}
+
+func NewCodecRegistry() *CodecRegistry {
+	return &CodecRegistry{codecs: map[uint8]Codec{}}
+}
+
+// Register adds codec on its payload type
+func (r *CodecRegistry) Register(c Codec) error {
+	if c.PayloadType < 96 || c.PayloadType > 127 {
+		return fmt.Errorf("payload type %d is not dynamic", c.PayloadType)
+	}
+	if c.Name == "" || c.ClockRate == 0 {
+		return fmt.Errorf("codec name and clock rate are required")
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.codecs[c.PayloadType] = c
+	return nil
+}
+
+// Lookup returns codec of format. Static payload types are always known.
+// It is safe to call on nil registry
+func (r *CodecRegistry) Lookup(format string) (Codec, bool) {
+	if c, ok := StaticCodec(format); ok {
+		return c, true
+	}
+	if r == nil {
+		return Codec{}, false
+	}
+
+	n, err := strconv.ParseUint(format, 10, 8)
+	if err != nil {
+		return Codec{}, false
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	c, exists := r.codecs[uint8(n)]
+	return c, exists
+}
+
+// Codecs returns registered codecs ordered by payload type.
+// It is safe to call on nil registry
+func (r *CodecRegistry) Codecs() []Codec {
+	if r == nil {
+		return nil
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	codecs := make([]Codec, 0, len(r.codecs))
+	for _, c := range r.codecs {
+		codecs = append(codecs, c)
+	}
+	sort.Slice(codecs, func(i, j int) bool { return codecs[i].PayloadType < codecs[j].PayloadType })
+	return codecs
+}
+
+// AppendCodecs adds rtpmap and fmtp attributes of codecs to SDP body.
+// Rtpmap of static payload type is not added, as it is implied or already generated
+func AppendCodecs(body []byte, codecs []Codec) []byte {
+	lines := []string{strings.TrimRight(string(body), "\r\n")}
+	for _, c := range codecs {
+		pt := strconv.Itoa(int(c.PayloadType))
+		if static, ok := StaticCodec(pt); !ok || !static.Equal(c) {
+			lines = append(lines, "a=rtpmap:"+pt+" "+c.String())
+		}
+		if c.Fmtp != "" {
+			lines = append(lines, "a=fmtp:"+pt+" "+c.Fmtp)
+		}
+	}
+	return []byte(strings.Join(lines, "\r\n") + "\r\n")
+}
+
+// AppendPtime adds ptime and maxptime attributes to SDP body. Zero values are not added
+func AppendPtime(body []byte, ptime time.Duration, maxPtime time.Duration) []byte {
+	lines := []string{strings.TrimRight(string(body), "\r\n")}
+	if ptime > 0 {
+		lines = append(lines, "a=ptime:"+formatPacketTime(ptime))
+	}
+	if maxPtime > 0 {
+		lines = append(lines, "a=maxptime:"+formatPacketTime(maxPtime))
+	}
+	return []byte(strings.Join(lines, "\r\n") + "\r\n")
+}
+
+func formatPacketTime(d time.Duration) string {
+	return strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'f', -1, 64)
+}
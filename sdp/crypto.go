@@ -0,0 +1,198 @@
+package sdp
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// SRTP crypto suites (RFC 4568, RFC 7714)
+const (
+	CryptoAES128SHA1_80 = "AES_CM_128_HMAC_SHA1_80"
+	CryptoAES128SHA1_32 = "AES_CM_128_HMAC_SHA1_32"
+	CryptoAEADAES128GCM = "AEAD_AES_128_GCM"
+	CryptoAEADAES256GCM = "AEAD_AES_256_GCM"
+)
+
+var ErrNoCommonCrypto = errors.New("sdp: no common crypto suite")
+
+// cryptoKeyLengths are master key and salt lengths of suites
+var cryptoKeyLengths = map[string]int{
+	CryptoAES128SHA1_80: 16 + 14,
+	CryptoAES128SHA1_32: 16 + 14,
+	CryptoAEADAES128GCM: 16 + 12,
+	CryptoAEADAES256GCM: 32 + 12,
+}
+
+// Crypto is SDES crypto attribute
+// a=crypto:<tag> <crypto-suite> <key-params> [<session-params>]
+// https://tools.ietf.org/html/rfc4568#section-9.1
+type Crypto struct {
+	Tag       int
+	Suite     string
+	KeyParams []CryptoKeyParam
+	// SessionParams are optional parameters, ex. UNENCRYPTED_SRTCP
+	SessionParams []string
+}
+
+// CryptoKeyParam is key parameter
+// inline:<key||salt>[|<lifetime>][|<MKI>:<length>]
+type CryptoKeyParam struct {
+	Method string
+	// Key is base64 encoded master key and salt
+	Key string
+	// Lifetime is optional, ex. 2^31
+	Lifetime string
+	// MKI is optional master key identifier with its length, ex. 1:4
+	MKI string
+}
+
+func (k CryptoKeyParam) String() string {
+	s := k.Method + ":" + k.Key
+	if k.Lifetime != "" {
+		s += "|" + k.Lifetime
+	}
+	if k.MKI != "" {
+		s += "|" + k.MKI
+	}
+	return s
+}
+
+// String returns attribute value without crypto: prefix
+func (c Crypto) String() string {
+	keys := make([]string, len(c.KeyParams))
+	for i, k := range c.KeyParams {
+		keys[i] = k.String()
+	}
+	s := strconv.Itoa(c.Tag) + " " + c.Suite + " " + strings.Join(keys, ";")
+	if len(c.SessionParams) > 0 {
+		s += " " + strings.Join(c.SessionParams, " ")
+	}
+	return s
+}
+
+// MasterKey returns decoded key and salt of first key parameter
+func (c Crypto) MasterKey() ([]byte, error) {
+	if len(c.KeyParams) == 0 {
+		return nil, fmt.Errorf("crypto has no key")
+	}
+	return base64.StdEncoding.DecodeString(c.KeyParams[0].Key)
+}
+
+// ParseCrypto parses crypto attribute value, ex. "1 AES_CM_128_HMAC_SHA1_80 inline:KEY"
+func ParseCrypto(v string) (Crypto, error) {
+	c := Crypto{}
+	fields := strings.Fields(v)
+	if len(fields) < 3 {
+		return c, fmt.Errorf("invalid crypto %q", v)
+	}
+
+	tag, err := strconv.Atoi(fields[0])
+	if err != nil || tag < 0 {
+		return c, fmt.Errorf("invalid crypto tag %q", fields[0])
+	}
+	c.Tag = tag
+	c.Suite = fields[1]
+
+	for _, kp := range strings.Split(fields[2], ";") {
+		method, info, found := strings.Cut(kp, ":")
+		if !found {
+			return c, fmt.Errorf("invalid crypto key params %q", kp)
+		}
+		parts := strings.Split(info, "|")
+		k := CryptoKeyParam{Method: method, Key: parts[0]}
+		for _, p := range parts[1:] {
+			if strings.Contains(p, ":") {
+				k.MKI = p
+				continue
+			}
+			k.Lifetime = p
+		}
+		c.KeyParams = append(c.KeyParams, k)
+	}
+
+	if len(fields) > 3 {
+		c.SessionParams = fields[3:]
+	}
+	return c, nil
+}
+
+// Cryptos returns crypto attributes of media. If media has none, session level ones are returned.
+// Levels are not merged, as keys of media level must not be mixed with others. Invalid ones are skipped
+func (sd SessionDescription) Cryptos(mediaType string) []Crypto {
+	cryptos := parseCryptos(sd.attributes(mediaType))
+	if len(cryptos) == 0 && sd.indexed() {
+		cryptos = parseCryptos(sd.SessionAttributes())
+	}
+	return cryptos
+}
+
+func parseCryptos(attrs []string) []Crypto {
+	cryptos := []Crypto{}
+	for _, a := range attrs {
+		v, found := strings.CutPrefix(a, "crypto:")
+		if !found {
+			continue
+		}
+		c, err := ParseCrypto(v)
+		if err != nil {
+			continue
+		}
+		cryptos = append(cryptos, c)
+	}
+	return cryptos
+}
+
+// AppendCrypto adds crypto attributes to SDP body
+func AppendCrypto(body []byte, cryptos ...Crypto) []byte {
+	lines := []string{strings.TrimRight(string(body), "\r\n")}
+	for _, c := range cryptos {
+		lines = append(lines, "a=crypto:"+c.String())
+	}
+	return []byte(strings.Join(lines, "\r\n") + "\r\n")
+}
+
+// NewCrypto creates crypto with random inline master key and salt of suite
+func NewCrypto(tag int, suite string) (Crypto, error) {
+	size, exists := cryptoKeyLengths[suite]
+	if !exists {
+		return Crypto{}, fmt.Errorf("unsupported crypto suite %q", suite)
+	}
+
+	key := make([]byte, size)
+	if _, err := rand.Read(key); err != nil {
+		return Crypto{}, err
+	}
+	return Crypto{
+		Tag:   tag,
+		Suite: suite,
+		KeyParams: []CryptoKeyParam{
+			{Method: "inline", Key: base64.StdEncoding.EncodeToString(key)},
+		},
+	}, nil
+}
+
+// SelectCrypto selects single offered crypto for answer. First offered crypto with
+// suite in supported is chosen, and answer is created with same tag and own new key
+func SelectCrypto(offered []Crypto, supported []string) (offer Crypto, answer Crypto, err error) {
+	for _, c := range offered {
+		for _, s := range supported {
+			if c.Suite != s {
+				continue
+			}
+			if len(c.KeyParams) == 0 || c.KeyParams[0].Method != "inline" {
+				continue
+			}
+			answer, err := NewCrypto(c.Tag, c.Suite)
+			if err != nil {
+				return c, answer, err
+			}
+			answer.SessionParams = c.SessionParams
+			return c, answer, nil
+		}
+	}
+	return Crypto{}, Crypto{}, ErrNoCommonCrypto
+}
@@ -0,0 +1,87 @@
+package sdp
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ExtMapAudioLevel is URI of client-to-mixer audio level header extension
+// https://datatracker.ietf.org/doc/html/rfc6464
+const ExtMapAudioLevel = "urn:ietf:params:rtp-hdrext:ssrc-audio-level"
+
+// ExtMap is RTP header extension mapping
+// a=extmap:<value>["/"<direction>] <URI> <extensionattributes>
+// https://datatracker.ietf.org/doc/html/rfc8285
+type ExtMap struct {
+	ID uint8
+	// Direction is empty when not present
+	Direction string
+	URI       string
+	// Attributes are extension attributes, ex. "vad=on"
+	Attributes string
+}
+
+// ParseExtMap parses extmap attribute value, ex. "1 urn:ietf:params:rtp-hdrext:ssrc-audio-level vad=on"
+func ParseExtMap(v string) (ExtMap, error) {
+	e := ExtMap{}
+	fields := strings.SplitN(strings.TrimSpace(v), " ", 3)
+	if len(fields) < 2 {
+		return e, fmt.Errorf("invalid extmap attribute %q", v)
+	}
+	id, dir, _ := strings.Cut(fields[0], "/")
+	n, err := strconv.ParseUint(id, 10, 8)
+	if err != nil || n == 0 || n == 15 {
+		return e, fmt.Errorf("invalid extmap id %q", fields[0])
+	}
+	e.ID = uint8(n)
+	e.Direction = dir
+	e.URI = fields[1]
+	if len(fields) == 3 {
+		e.Attributes = fields[2]
+	}
+	return e, nil
+}
+
+// String returns attribute value without extmap: prefix
+func (e ExtMap) String() string {
+	s := strconv.Itoa(int(e.ID))
+	if e.Direction != "" {
+		s += "/" + e.Direction
+	}
+	s += " " + e.URI
+	if e.Attributes != "" {
+		s += " " + e.Attributes
+	}
+	return s
+}
+
+// ExtMaps returns valid extmap attributes of media, followed by session level ones
+func (sd SessionDescription) ExtMaps(mediaType string) []ExtMap {
+	var maps []ExtMap
+	for _, v := range sd.levelAttributes(mediaType) {
+		v, found := strings.CutPrefix(v, "extmap:")
+		if !found {
+			continue
+		}
+		if e, err := ParseExtMap(v); err == nil {
+			maps = append(maps, e)
+		}
+	}
+	return maps
+}
+
+// ExtMap returns extmap of media with URI. False is returned if extension is not mapped
+func (sd SessionDescription) ExtMap(mediaType string, uri string) (ExtMap, bool) {
+	for _, e := range sd.ExtMaps(mediaType) {
+		if e.URI == uri {
+			return e, true
+		}
+	}
+	return ExtMap{}, false
+}
+
+// AppendExtMap adds extmap attribute to SDP body with single media
+func AppendExtMap(body []byte, e ExtMap) []byte {
+	return []byte(strings.TrimRight(string(body), "\r\n") + "\r\na=extmap:" + e.String() + "\r\n")
+}
@@ -0,0 +1,138 @@
+package sdp
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"fmt"
+	"hash"
+	"strings"
+)
+
+// Setup is connection role attribute (RFC 4145, RFC 5763)
+type Setup string
+
+const (
+	SetupActive   Setup = "active"
+	SetupPassive  Setup = "passive"
+	SetupActpass  Setup = "actpass"
+	SetupHoldconn Setup = "holdconn"
+)
+
+// AnswerSetup returns role of answer for offered role.
+// Offerer actpass makes answerer active, as recommended by RFC 5763
+func AnswerSetup(offer Setup) Setup {
+	switch offer {
+	case SetupActive:
+		return SetupPassive
+	case SetupHoldconn:
+		return SetupHoldconn
+	}
+	return SetupActive
+}
+
+// Setup returns setup attribute of media or session level one
+func (sd SessionDescription) Setup(mediaType string) (Setup, bool) {
+	for _, a := range sd.levelAttributes(mediaType) {
+		if v, found := strings.CutPrefix(a, "setup:"); found {
+			return Setup(strings.TrimSpace(v)), true
+		}
+	}
+	return "", false
+}
+
+// Fingerprint is certificate fingerprint attribute
+// a=fingerprint:<hash-func> <fingerprint>
+// https://tools.ietf.org/html/rfc8122#section-5
+type Fingerprint struct {
+	// Hash is hash function, ex. sha-256
+	Hash string
+	// Value is uppercase hex bytes separated with colon
+	Value string
+}
+
+// String returns attribute value without fingerprint: prefix
+func (f Fingerprint) String() string {
+	return f.Hash + " " + f.Value
+}
+
+// ParseFingerprint parses fingerprint attribute value, ex. "sha-256 AB:CD:..."
+func ParseFingerprint(v string) (Fingerprint, error) {
+	h, value, found := strings.Cut(strings.TrimSpace(v), " ")
+	if !found || h == "" || value == "" {
+		return Fingerprint{}, fmt.Errorf("invalid fingerprint %q", v)
+	}
+	return Fingerprint{Hash: strings.ToLower(h), Value: strings.ToUpper(strings.TrimSpace(value))}, nil
+}
+
+// NewFingerprint computes fingerprint of DER encoded certificate.
+// Supported hash functions are sha-1, sha-256, sha-384 and sha-512
+func NewFingerprint(hashFunc string, cert []byte) (Fingerprint, error) {
+	var h hash.Hash
+	switch strings.ToLower(hashFunc) {
+	case "sha-1":
+		h = sha1.New()
+	case "sha-256":
+		h = sha256.New()
+	case "sha-384":
+		h = sha512.New384()
+	case "sha-512":
+		h = sha512.New()
+	default:
+		return Fingerprint{}, fmt.Errorf("unsupported fingerprint hash %q", hashFunc)
+	}
+	h.Write(cert)
+
+	sum := h.Sum(nil)
+	hex := make([]string, len(sum))
+	for i, b := range sum {
+		hex[i] = fmt.Sprintf("%02X", b)
+	}
+	return Fingerprint{Hash: strings.ToLower(hashFunc), Value: strings.Join(hex, ":")}, nil
+}
+
+// Match returns true if fingerprint is of DER encoded certificate
+func (f Fingerprint) Match(cert []byte) bool {
+	c, err := NewFingerprint(f.Hash, cert)
+	return err == nil && c.Value == strings.ToUpper(f.Value)
+}
+
+// Fingerprints returns fingerprint attributes of media, or session level ones if media has none.
+// Invalid ones are skipped
+func (sd SessionDescription) Fingerprints(mediaType string) []Fingerprint {
+	fps := parseFingerprints(sd.attributes(mediaType))
+	if len(fps) == 0 && sd.indexed() {
+		fps = parseFingerprints(sd.SessionAttributes())
+	}
+	return fps
+}
+
+func parseFingerprints(attrs []string) []Fingerprint {
+	fps := []Fingerprint{}
+	for _, a := range attrs {
+		v, found := strings.CutPrefix(a, "fingerprint:")
+		if !found {
+			continue
+		}
+		f, err := ParseFingerprint(v)
+		if err != nil {
+			continue
+		}
+		fps = append(fps, f)
+	}
+	return fps
+}
+
+// AppendFingerprint adds fingerprint attributes to SDP body
+func AppendFingerprint(body []byte, fps ...Fingerprint) []byte {
+	lines := []string{strings.TrimRight(string(body), "\r\n")}
+	for _, f := range fps {
+		lines = append(lines, "a=fingerprint:"+f.String())
+	}
+	return []byte(strings.Join(lines, "\r\n") + "\r\n")
+}
+
+// AppendSetup adds setup attribute to SDP body
+func AppendSetup(body []byte, setup Setup) []byte {
+	return []byte(strings.TrimRight(string(body), "\r\n") + "\r\na=setup:" + string(setup) + "\r\n")
+}
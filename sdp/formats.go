@@ -4,7 +4,10 @@ import "strconv"
 
 const (
 	FORMAT_TYPE_ULAW = "0"
+	FORMAT_TYPE_GSM  = "3"
 	FORMAT_TYPE_ALAW = "8"
+	FORMAT_TYPE_G722 = "9"
+	FORMAT_TYPE_G729 = "18"
 )
 
 type Formats []string
@@ -36,5 +39,9 @@ func FormatNumeric(f string) uint8 {
 	case FORMAT_TYPE_ULAW:
 		return 0
 	}
+	// Dynamic payload types
+	if n, err := strconv.ParseUint(f, 10, 8); err == nil && n <= 127 {
+		return uint8(n)
+	}
 	return 0
 }
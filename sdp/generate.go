@@ -0,0 +1,99 @@
+package sdp
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// Media transport protocols
+const (
+	ProtoRTPAVP = "RTP/AVP"
+	ProtoUDPTL  = "udptl"
+)
+
+// Generate creates SDP offer with media descriptions in given order.
+// Connection address is set on session level if not nil, and media Connection
+// on media level when it differs, so media can use other address or IP family.
+// Proto of media defaults to RTP/AVP.
+// RTP formats without codec get rtpmap only if they are static payload types,
+// so dynamic formats must be described in Codecs.
+//
+//	sdp.Generate(ip, ip,
+//		sdp.MediaDescription{MediaType: "audio", Port: 4000, Formats: []string{"0"}, Mode: sdp.ModeSendrecv},
+//		sdp.MediaDescription{MediaType: "image", Port: 4002, Proto: sdp.ProtoUDPTL, Formats: []string{"t38"}},
+//	)
+func Generate(originIP net.IP, connectionIP net.IP, medias ...MediaDescription) []byte {
+	ntpTime := GetCurrentNTPTimestamp()
+
+	s := []string{
+		"v=0",
+		fmt.Sprintf("o=user1 %d %d IN %s %s", ntpTime, ntpTime, addrType(originIP), originIP),
+		"s=Sip Go Media",
+	}
+	if connectionIP != nil {
+		s = append(s, fmt.Sprintf("c=IN %s %s", addrType(connectionIP), connectionIP))
+	}
+	s = append(s, "t=0 0")
+	for _, md := range medias {
+		if md.Connection.Equal(connectionIP) {
+			md.Connection = nil
+		}
+		s = append(s, md.lines()...)
+	}
+	return []byte(strings.Join(s, "\r\n") + "\r\n")
+}
+
+// lines returns m= line followed by media attributes
+func (m *MediaDescription) lines() []string {
+	md := *m
+	if md.Proto == "" {
+		md.Proto = ProtoRTPAVP
+	}
+	s := []string{md.String()}
+	if md.Connection != nil {
+		s = append(s, fmt.Sprintf("c=IN %s %s", addrType(md.Connection), md.Connection))
+	}
+
+	if strings.Contains(md.Proto, "RTP/") {
+		described := map[string]bool{}
+		for _, c := range md.Codecs {
+			described[strconv.Itoa(int(c.PayloadType))] = true
+		}
+		for _, f := range md.Formats {
+			if enc, static := staticRTPMaps[f]; static && !described[f] {
+				s = append(s, "a=rtpmap:"+f+" "+enc)
+			}
+		}
+	}
+	for _, c := range md.Codecs {
+		pt := strconv.Itoa(int(c.PayloadType))
+		s = append(s, "a=rtpmap:"+pt+" "+c.String())
+		if c.Fmtp != "" {
+			s = append(s, "a=fmtp:"+pt+" "+c.Fmtp)
+		}
+	}
+
+	if md.Ptime > 0 {
+		s = append(s, "a=ptime:"+formatPacketTime(md.Ptime))
+	}
+	if md.MaxPtime > 0 {
+		s = append(s, "a=maxptime:"+formatPacketTime(md.MaxPtime))
+	}
+	if md.Mode != "" {
+		s = append(s, "a="+string(md.Mode))
+	}
+	for _, a := range md.Attributes {
+		s = append(s, "a="+a)
+	}
+	return s
+}
+
+// addrType returns SDP address type of IP family. IPv4-mapped IPv6 address is IP4
+func addrType(ip net.IP) string {
+	if ip.To4() == nil && ip.To16() != nil {
+		return "IP6"
+	}
+	return "IP4"
+}
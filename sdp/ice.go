@@ -0,0 +1,159 @@
+package sdp
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ICECandidate is candidate attribute (RFC 8839)
+// candidate:<foundation> <component> <transport> <priority> <ip> <port> typ <type>
+type ICECandidate struct {
+	Foundation string
+	Component  int
+	Transport  string
+	Priority   uint32
+	IP         net.IP
+	Port       int
+	Type       string
+	// RelatedIP and RelatedPort are set for reflexive and relay candidates
+	RelatedIP   net.IP
+	RelatedPort int
+	// TCPType is set for TCP candidates (RFC 6544)
+	TCPType string
+	// Extensions are other name value pairs, ex. generation
+	Extensions map[string]string
+}
+
+// ParseICECandidate parses candidate attribute value with or without "candidate:" prefix
+func ParseICECandidate(attr string) (ICECandidate, error) {
+	c := ICECandidate{}
+	fields := strings.Fields(strings.TrimPrefix(attr, "candidate:"))
+	if len(fields) < 8 || fields[6] != "typ" {
+		return c, fmt.Errorf("invalid ICE candidate %q", attr)
+	}
+
+	c.Foundation = fields[0]
+	c.Component, _ = strconv.Atoi(fields[1])
+	c.Transport = fields[2]
+	prio, err := strconv.ParseUint(fields[3], 10, 32)
+	if err != nil {
+		return c, fmt.Errorf("invalid ICE candidate priority %q", attr)
+	}
+	c.Priority = uint32(prio)
+	c.IP = net.ParseIP(fields[4])
+	c.Port, _ = strconv.Atoi(fields[5])
+	c.Type = fields[7]
+	for i := 8; i+1 < len(fields); i += 2 {
+		switch fields[i] {
+		case "raddr":
+			c.RelatedIP = net.ParseIP(fields[i+1])
+		case "rport":
+			c.RelatedPort, _ = strconv.Atoi(fields[i+1])
+		case "tcptype":
+			c.TCPType = fields[i+1]
+		default:
+			if c.Extensions == nil {
+				c.Extensions = map[string]string{}
+			}
+			c.Extensions[fields[i]] = fields[i+1]
+		}
+	}
+	if c.IP == nil || c.Component == 0 {
+		return c, fmt.Errorf("invalid ICE candidate %q", attr)
+	}
+	// Active TCP candidates have port 9 or 0
+	if c.Port == 0 && c.TCPType != "active" {
+		return c, fmt.Errorf("invalid ICE candidate %q", attr)
+	}
+	return c, nil
+}
+
+// String returns attribute value with candidate: prefix
+func (c ICECandidate) String() string {
+	s := fmt.Sprintf("candidate:%s %d %s %d %s %d typ %s", c.Foundation, c.Component, c.Transport, c.Priority, c.IP, c.Port, c.Type)
+	if c.RelatedIP != nil {
+		s += fmt.Sprintf(" raddr %s rport %d", c.RelatedIP, c.RelatedPort)
+	}
+	if c.TCPType != "" {
+		s += " tcptype " + c.TCPType
+	}
+
+	keys := make([]string, 0, len(c.Extensions))
+	for k := range c.Extensions {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		s += " " + k + " " + c.Extensions[k]
+	}
+	return s
+}
+
+// ICEAttributes are ICE attributes of media
+type ICEAttributes struct {
+	Ufrag string
+	Pwd   string
+	// Options are ice-options tags, ex. trickle
+	Options []string
+	// Lite is session level ice-lite attribute
+	Lite       bool
+	Candidates []ICECandidate
+}
+
+// ICE returns ICE attributes of media. Credentials and options of media level
+// override session level ones. Invalid candidates are skipped
+func (sd SessionDescription) ICE(mediaType string) ICEAttributes {
+	ice := ICEAttributes{}
+	// Session level first, so media level overrides it
+	attrs := sd.attributes(mediaType)
+	if sd.indexed() {
+		attrs = append(append([]string{}, sd.SessionAttributes()...), attrs...)
+	}
+
+	for _, attr := range attrs {
+		name, value, _ := strings.Cut(attr, ":")
+		switch name {
+		case "ice-ufrag":
+			ice.Ufrag = value
+		case "ice-pwd":
+			ice.Pwd = value
+		case "ice-options":
+			ice.Options = strings.Fields(value)
+		case "ice-lite":
+			ice.Lite = true
+		case "candidate":
+			c, err := ParseICECandidate(value)
+			if err != nil {
+				continue
+			}
+			ice.Candidates = append(ice.Candidates, c)
+		}
+	}
+	return ice
+}
+
+// AppendICE adds ICE attributes to SDP body. ice-lite is added on session level
+func AppendICE(body []byte, ice ICEAttributes) []byte {
+	b := strings.TrimRight(string(body), "\r\n")
+	if ind := strings.Index(b, "\r\nm="); ice.Lite && ind >= 0 {
+		b = b[:ind] + "\r\na=ice-lite" + b[ind:]
+	}
+
+	lines := []string{b}
+	if ice.Ufrag != "" {
+		lines = append(lines, "a=ice-ufrag:"+ice.Ufrag)
+	}
+	if ice.Pwd != "" {
+		lines = append(lines, "a=ice-pwd:"+ice.Pwd)
+	}
+	if len(ice.Options) > 0 {
+		lines = append(lines, "a=ice-options:"+strings.Join(ice.Options, " "))
+	}
+	for _, c := range ice.Candidates {
+		lines = append(lines, "a="+c.String())
+	}
+	return []byte(strings.Join(lines, "\r\n") + "\r\n")
+}
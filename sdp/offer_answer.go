@@ -0,0 +1,483 @@
+package sdp
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var (
+	ErrInvalidState       = errors.New("sdp: invalid offer/answer state")
+	ErrNoCommonFormat     = errors.New("sdp: no common format")
+	ErrIncompatibleAnswer = errors.New("sdp: answer does not match offer")
+	ErrMediaRejected      = errors.New("sdp: media rejected")
+)
+
+// DescriptionType is type of description in offer/answer exchange (RFC 3264)
+type DescriptionType string
+
+const (
+	TypeOffer    DescriptionType = "offer"
+	TypePranswer DescriptionType = "pranswer"
+	TypeAnswer   DescriptionType = "answer"
+)
+
+// SignalingState is state of offer/answer exchange
+type SignalingState int
+
+const (
+	StateStable SignalingState = iota
+	StateHaveLocalOffer
+	StateHaveRemoteOffer
+	StateHaveLocalPranswer
+	StateHaveRemotePranswer
+)
+
+func (s SignalingState) String() string {
+	switch s {
+	case StateHaveLocalOffer:
+		return "have-local-offer"
+	case StateHaveRemoteOffer:
+		return "have-remote-offer"
+	case StateHaveLocalPranswer:
+		return "have-local-pranswer"
+	case StateHaveRemotePranswer:
+		return "have-remote-pranswer"
+	}
+	return "stable"
+}
+
+// AudioOptions are local capabilities used for generating offer or answer
+type AudioOptions struct {
+	OriginIP     net.IP
+	ConnectionIP net.IP
+	// MediaConnectionIP is set as audio media level connection. Used by multi-homed hosts
+	// where media is on different address than session ConnectionIP
+	MediaConnectionIP net.IP
+	Port              int
+	// Mode is local preferred direction. Default sendrecv
+	Mode    Mode
+	Formats Formats
+	// Registry describes dynamic Formats. Answer matches offered dynamic payload types
+	// by codec and reuses offer numbers with rtpmap, as RFC 3264 6.1 requires
+	Registry *CodecRegistry
+	// DTMFPayloadType is telephone-event payload type in offer. Default 101.
+	// Answer uses payload type of offer
+	DTMFPayloadType string
+	// TelephoneEvents are events put in telephone-event fmtp. Default DefaultTelephoneEvents
+	TelephoneEvents TelephoneEvents
+	// Ptime and MaxPtime are added as attributes if set
+	Ptime    time.Duration
+	MaxPtime time.Duration
+}
+
+// OfferAnswer tracks local and remote descriptions and validates state transitions.
+// Current descriptions are result of last completed exchange, pending are ones in progress.
+// Zero value is ready to use in stable state
+type OfferAnswer struct {
+	mu    sync.Mutex
+	state SignalingState
+
+	currentLocal  []byte
+	currentRemote []byte
+	pendingLocal  []byte
+	pendingRemote []byte
+}
+
+// State returns current signaling state
+func (oa *OfferAnswer) State() SignalingState {
+	oa.mu.Lock()
+	defer oa.mu.Unlock()
+	return oa.state
+}
+
+// LocalDescription returns pending local description or current if there is no exchange in progress
+func (oa *OfferAnswer) LocalDescription() []byte {
+	oa.mu.Lock()
+	defer oa.mu.Unlock()
+	if oa.pendingLocal != nil {
+		return oa.pendingLocal
+	}
+	return oa.currentLocal
+}
+
+// RemoteDescription returns pending remote description or current if there is no exchange in progress
+func (oa *OfferAnswer) RemoteDescription() []byte {
+	oa.mu.Lock()
+	defer oa.mu.Unlock()
+	if oa.pendingRemote != nil {
+		return oa.pendingRemote
+	}
+	return oa.currentRemote
+}
+
+// SetLocalDescription applies local offer, pranswer or answer
+func (oa *OfferAnswer) SetLocalDescription(typ DescriptionType, body []byte) error {
+	oa.mu.Lock()
+	defer oa.mu.Unlock()
+	return oa.setLocal(typ, body)
+}
+
+func (oa *OfferAnswer) setLocal(typ DescriptionType, body []byte) error {
+	switch typ {
+	case TypeOffer:
+		if oa.state != StateStable && oa.state != StateHaveLocalOffer {
+			return fmt.Errorf("%w: local offer in %s", ErrInvalidState, oa.state)
+		}
+		oa.pendingLocal = body
+		oa.state = StateHaveLocalOffer
+
+	case TypePranswer, TypeAnswer:
+		if oa.state != StateHaveRemoteOffer && oa.state != StateHaveLocalPranswer {
+			return fmt.Errorf("%w: local %s in %s", ErrInvalidState, typ, oa.state)
+		}
+		if err := validateAnswer(oa.pendingRemote, body); err != nil {
+			return err
+		}
+		oa.applyLocalAnswer(typ, body)
+
+	default:
+		return fmt.Errorf("sdp: unknown description type %q", typ)
+	}
+	return nil
+}
+
+func (oa *OfferAnswer) applyLocalAnswer(typ DescriptionType, body []byte) {
+	if typ == TypePranswer {
+		oa.pendingLocal = body
+		oa.state = StateHaveLocalPranswer
+		return
+	}
+	oa.currentLocal = body
+	oa.currentRemote = oa.pendingRemote
+	oa.pendingLocal, oa.pendingRemote = nil, nil
+	oa.state = StateStable
+}
+
+// SetRemoteDescription applies remote offer, pranswer or answer.
+// Remote answer is validated against pending local offer
+func (oa *OfferAnswer) SetRemoteDescription(typ DescriptionType, body []byte) error {
+	oa.mu.Lock()
+	defer oa.mu.Unlock()
+
+	if err := validateDescription(body); err != nil {
+		return err
+	}
+
+	switch typ {
+	case TypeOffer:
+		if oa.state != StateStable && oa.state != StateHaveRemoteOffer {
+			return fmt.Errorf("%w: remote offer in %s", ErrInvalidState, oa.state)
+		}
+		oa.pendingRemote = body
+		oa.state = StateHaveRemoteOffer
+
+	case TypePranswer, TypeAnswer:
+		if oa.state != StateHaveLocalOffer && oa.state != StateHaveRemotePranswer {
+			return fmt.Errorf("%w: remote %s in %s", ErrInvalidState, typ, oa.state)
+		}
+		if err := validateAnswer(oa.pendingLocal, body); err != nil {
+			return err
+		}
+
+		if typ == TypePranswer {
+			oa.pendingRemote = body
+			oa.state = StateHaveRemotePranswer
+			return nil
+		}
+		oa.currentLocal = oa.pendingLocal
+		oa.currentRemote = body
+		oa.pendingLocal, oa.pendingRemote = nil, nil
+		oa.state = StateStable
+
+	default:
+		return fmt.Errorf("sdp: unknown description type %q", typ)
+	}
+	return nil
+}
+
+// Rollback discards pending exchange and returns to stable state
+func (oa *OfferAnswer) Rollback() {
+	oa.mu.Lock()
+	defer oa.mu.Unlock()
+	oa.pendingLocal, oa.pendingRemote = nil, nil
+	oa.state = StateStable
+}
+
+// CreateOffer generates audio offer and applies it as local offer
+func (oa *OfferAnswer) CreateOffer(opts AudioOptions) ([]byte, error) {
+	oa.mu.Lock()
+	defer oa.mu.Unlock()
+
+	mode := opts.Mode
+	if mode == "" {
+		mode = ModeSendrecv
+	}
+	dtmf := opts.DTMFPayloadType
+	if dtmf == "" {
+		dtmf = DefaultDTMFPayloadType
+	}
+	body := Generate(opts.OriginIP, opts.ConnectionIP, opts.audioMedia(mode, opts.Formats, dtmf))
+	if err := oa.setLocal(TypeOffer, body); err != nil {
+		return nil, err
+	}
+	return body, nil
+}
+
+// CreateAnswer generates answer for pending remote offer and applies it as local answer.
+// Formats are kept in offer order and direction is mirrored as in RFC 3264 6.1.
+// Other than first not rejected audio media are rejected by echoing them with port 0
+func (oa *OfferAnswer) CreateAnswer(opts AudioOptions) ([]byte, error) {
+	oa.mu.Lock()
+	defer oa.mu.Unlock()
+
+	if oa.state != StateHaveRemoteOffer && oa.state != StateHaveLocalPranswer {
+		return nil, fmt.Errorf("%w: answer in %s", ErrInvalidState, oa.state)
+	}
+
+	offer := SessionDescription{}
+	if err := Unmarshal(oa.pendingRemote, &offer); err != nil {
+		return nil, err
+	}
+	md, err := offer.MediaDescription("audio")
+	if err != nil {
+		return nil, err
+	}
+	if md.Rejected() {
+		return nil, fmt.Errorf("%w: audio disabled in offer", ErrMediaRejected)
+	}
+
+	dtmf, _ := offer.TelephoneEvent()
+	offered, err := offer.MediaDescriptions()
+	if err != nil {
+		return nil, err
+	}
+	// Offer without valid rtpmaps is matched by payload type only
+	offerCodecs, _ := offer.Codecs("audio")
+	fmts, codecs := opts.answerFormats(md.Formats, offerCodecs, dtmf)
+	if len(fmts) == 0 {
+		return nil, ErrNoCommonFormat
+	}
+
+	mode := AnswerMode(offer.Mode(), opts.Mode)
+	medias := make([]MediaDescription, 0, len(offered))
+	answered := false
+	for _, m := range offered {
+		if m.MediaType == "audio" && !m.Rejected() && !answered {
+			answered = true
+			audio := opts.audioMedia(mode, fmts, dtmf)
+			audio.Codecs = append(codecs, audio.Codecs...)
+			medias = append(medias, audio)
+			continue
+		}
+		medias = append(medias, m.Reject())
+	}
+	body := Generate(opts.OriginIP, opts.ConnectionIP, medias...)
+	// Answer is consistent with offer by construction
+	oa.applyLocalAnswer(TypeAnswer, body)
+	return body, nil
+}
+
+// answerFormats returns offered formats supported locally in offer order. Dynamic formats
+// are matched by codec and returned codecs describe them with offer payload types
+func (opts AudioOptions) answerFormats(offered Formats, offerCodecs []Codec, dtmf string) (Formats, []Codec) {
+	offerCodec := func(f string) (Codec, bool) {
+		for _, c := range offerCodecs {
+			if strconv.Itoa(int(c.PayloadType)) == f {
+				return c, true
+			}
+		}
+		return Codec{}, false
+	}
+
+	fmts := Formats{}
+	codecs := []Codec{}
+	for _, f := range offered {
+		// telephone-event is added by generator
+		if f == dtmf {
+			continue
+		}
+		oc, described := offerCodec(f)
+		for _, l := range opts.Formats {
+			lc, dynamic := opts.Registry.Lookup(l)
+			if _, static := StaticCodec(l); static {
+				dynamic = false
+			}
+			if !dynamic || !described {
+				if f == l {
+					fmts = append(fmts, f)
+					break
+				}
+				continue
+			}
+			if lc.Equal(oc) {
+				fmts = append(fmts, f)
+				codecs = append(codecs, Codec{
+					PayloadType: oc.PayloadType,
+					Name:        oc.Name,
+					ClockRate:   oc.ClockRate,
+					Channels:    oc.Channels,
+					Fmtp:        lc.Fmtp,
+				})
+				break
+			}
+		}
+	}
+	return fmts, codecs
+}
+
+// audioMedia returns local audio media with telephone-event on dtmf payload type
+func (opts AudioOptions) audioMedia(mode Mode, fmts Formats, dtmf string) MediaDescription {
+	md := MediaDescription{
+		MediaType:  "audio",
+		Port:       opts.Port,
+		Proto:      ProtoRTPAVP,
+		Formats:    append([]string{}, fmts...),
+		Ptime:      opts.Ptime,
+		MaxPtime:   opts.MaxPtime,
+		Mode:       mode,
+		Connection: opts.MediaConnectionIP,
+	}
+	if dtmf != "" {
+		md.Formats = append(md.Formats, dtmf)
+		events := opts.TelephoneEvents
+		if events.IsZero() {
+			events = DefaultTelephoneEvents
+		}
+		md.Codecs = []Codec{{PayloadType: FormatNumeric(dtmf), Name: "telephone-event", ClockRate: 8000, Channels: 1, Fmtp: events.String()}}
+	}
+	return md
+}
+
+// Mode returns direction of audio media. Default is sendrecv
+func (sd SessionDescription) Mode() Mode {
+	return sd.MediaMode("audio")
+}
+
+// MediaMode returns direction of media. Media level attribute overrides session level
+// one and default is sendrecv (RFC 4566 6)
+func (sd SessionDescription) MediaMode(mediaType string) Mode {
+	if m, ok := findMode(sd.MediaAttributes(mediaType)); ok {
+		return m
+	}
+	if m, ok := findMode(sd.SessionAttributes()); ok {
+		return m
+	}
+	return ModeSendrecv
+}
+
+func findMode(attrs []string) (Mode, bool) {
+	for _, a := range attrs {
+		switch m := Mode(a); m {
+		case ModeSendrecv, ModeSendonly, ModeRecvonly, ModeInactive:
+			return m, true
+		}
+	}
+	return "", false
+}
+
+// IsHold returns true if description puts media on hold.
+// Hold is signaled with sendonly or inactive direction, or with 0.0.0.0 connection address (RFC 2543)
+func (sd SessionDescription) IsHold() bool {
+	if m := sd.Mode(); m == ModeSendonly || m == ModeInactive {
+		return true
+	}
+	ci, err := sd.ConnectionInformation()
+	return err == nil && ci.IP.IsUnspecified()
+}
+
+// AnswerMode returns direction of answer for offered direction and local preferred direction
+func AnswerMode(offer Mode, local Mode) Mode {
+	if local == "" {
+		local = ModeSendrecv
+	}
+	// Offerer sending means we can receive
+	send := modeRecv(offer) && modeSend(local)
+	recv := modeSend(offer) && modeRecv(local)
+	return modeFrom(send, recv)
+}
+
+func modeSend(m Mode) bool {
+	return m == ModeSendrecv || m == ModeSendonly
+}
+
+func modeRecv(m Mode) bool {
+	return m == ModeSendrecv || m == ModeRecvonly
+}
+
+func modeFrom(send bool, recv bool) Mode {
+	switch {
+	case send && recv:
+		return ModeSendrecv
+	case send:
+		return ModeSendonly
+	case recv:
+		return ModeRecvonly
+	}
+	return ModeInactive
+}
+
+func validateDescription(body []byte) error {
+	sd := SessionDescription{}
+	if err := Unmarshal(body, &sd); err != nil {
+		return err
+	}
+	if len(sd.Values("m")) == 0 {
+		return fmt.Errorf("sdp: no media description")
+	}
+	return nil
+}
+
+// validateAnswer checks that answer has same media, offered formats and compatible direction
+func validateAnswer(offerBody []byte, answerBody []byte) error {
+	offer, answer := SessionDescription{}, SessionDescription{}
+	if err := Unmarshal(offerBody, &offer); err != nil {
+		return err
+	}
+	if err := Unmarshal(answerBody, &answer); err != nil {
+		return err
+	}
+
+	if len(offer.Values("m")) != len(answer.Values("m")) {
+		return fmt.Errorf("%w: media count differs", ErrIncompatibleAnswer)
+	}
+
+	omd, err := offer.MediaDescription("audio")
+	if err != nil {
+		// Nothing more we can validate for non audio
+		return nil
+	}
+	amd, err := answer.MediaDescription("audio")
+	if err != nil {
+		return fmt.Errorf("%w: audio missing", ErrIncompatibleAnswer)
+	}
+
+	// Rejected stream
+	if amd.Port == 0 {
+		return nil
+	}
+
+	for _, f := range amd.Formats {
+		if !containsFormat(omd.Formats, f) {
+			return fmt.Errorf("%w: format %s not offered", ErrIncompatibleAnswer, f)
+		}
+	}
+
+	om, am := offer.Mode(), answer.Mode()
+	if (modeSend(am) && !modeRecv(om)) || (modeRecv(am) && !modeSend(om)) {
+		return fmt.Errorf("%w: direction %s for offered %s", ErrIncompatibleAnswer, am, om)
+	}
+	return nil
+}
+
+func containsFormat(fmts []string, f string) bool {
+	for _, v := range fmts {
+		if v == f {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,77 @@
+package sdp
+
+import (
+	"strconv"
+	"strings"
+)
+
+// FORMAT_TYPE_OPUS is commonly used dynamic payload type of Opus. Opus has no static payload type,
+// so negotiated one must be looked up from rtpmap
+const FORMAT_TYPE_OPUS = "111"
+
+// OpusParams are Opus fmtp parameters
+// https://datatracker.ietf.org/doc/html/rfc7587#section-6.1
+type OpusParams struct {
+	// MaxPlaybackRate is maximum output sampling rate receiver can render. Zero is not set (48000)
+	MaxPlaybackRate int
+	// Stereo is receiver preference for stereo signal
+	Stereo bool
+	// UseInbandFEC means receiver can use inband forward error correction
+	UseInbandFEC bool
+	// UseDTX means receiver prefers discontinuous transmission
+	UseDTX bool
+}
+
+// ParseOpusParams parses Opus fmtp. Unknown parameters are ignored
+func ParseOpusParams(fmtp string) OpusParams {
+	params := ParseFmtpParams(fmtp)
+	p := OpusParams{
+		Stereo:       params["stereo"] == "1",
+		UseInbandFEC: params["useinbandfec"] == "1",
+		UseDTX:       params["usedtx"] == "1",
+	}
+	if v, err := strconv.Atoi(params["maxplaybackrate"]); err == nil && v > 0 {
+		p.MaxPlaybackRate = v
+	}
+	return p
+}
+
+// String returns fmtp of parameters. Parameters with default value are not included
+func (p OpusParams) String() string {
+	var params []string
+	if p.MaxPlaybackRate > 0 {
+		params = append(params, "maxplaybackrate="+strconv.Itoa(p.MaxPlaybackRate))
+	}
+	if p.Stereo {
+		params = append(params, "stereo=1")
+	}
+	if p.UseInbandFEC {
+		params = append(params, "useinbandfec=1")
+	}
+	if p.UseDTX {
+		params = append(params, "usedtx=1")
+	}
+	return strings.Join(params, ";")
+}
+
+// OpusCodec returns Opus codec on payload type. Rtpmap is always opus/48000/2 as RFC 7587 requires,
+// channels of actual signal are signaled by stereo parameter
+func OpusCodec(payloadType uint8, p OpusParams) Codec {
+	fmtp := p.String()
+	return Codec{
+		PayloadType: payloadType,
+		Name:        "opus",
+		ClockRate:   48000,
+		Channels:    2,
+		Fmtp:        fmtp,
+		Params:      ParseFmtpParams(fmtp),
+	}
+}
+
+// OpusParams returns Opus parameters of codec. False is returned if codec is not Opus
+func (c Codec) OpusParams() (OpusParams, bool) {
+	if !strings.EqualFold(c.Name, "opus") {
+		return OpusParams{}, false
+	}
+	return ParseOpusParams(c.Fmtp), true
+}
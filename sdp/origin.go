@@ -0,0 +1,125 @@
+package sdp
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Origin is o= line
+// o=<username> <sess-id> <sess-version> <nettype> <addrtype> <unicast-address>
+// https://tools.ietf.org/html/rfc4566#section-5.2
+type Origin struct {
+	Username       string
+	SessionID      uint64
+	SessionVersion uint64
+	NetworkType    string
+	AddressType    string
+	Address        string
+}
+
+func (o Origin) String() string {
+	return fmt.Sprintf("%s %d %d %s %s %s", o.Username, o.SessionID, o.SessionVersion, o.NetworkType, o.AddressType, o.Address)
+}
+
+// IP returns unicast address of origin. Nil is returned if address is not IP4 or IP6 literal
+func (o Origin) IP() net.IP {
+	ip := net.ParseIP(o.Address)
+	if o.AddressType == "IP4" {
+		return ip.To4()
+	}
+	return ip
+}
+
+// ParseOrigin parses o= line value
+func ParseOrigin(v string) (Origin, error) {
+	o := Origin{}
+	fields := strings.Fields(v)
+	if len(fields) != 6 {
+		return o, fmt.Errorf("invalid origin %q", v)
+	}
+	var err error
+	if o.SessionID, err = strconv.ParseUint(fields[1], 10, 64); err != nil {
+		return o, fmt.Errorf("invalid origin session id %q", fields[1])
+	}
+	if o.SessionVersion, err = strconv.ParseUint(fields[2], 10, 64); err != nil {
+		return o, fmt.Errorf("invalid origin session version %q", fields[2])
+	}
+	o.Username = fields[0]
+	o.NetworkType = fields[3]
+	o.AddressType = fields[4]
+	o.Address = fields[5]
+	return o, nil
+}
+
+// Origin returns parsed o= line
+func (sd SessionDescription) Origin() (Origin, error) {
+	return ParseOrigin(sd.Value("o"))
+}
+
+// SetOrigin replaces o= line of SDP body
+func SetOrigin(body []byte, o Origin) []byte {
+	origin, _ := cutOrigin(body)
+	if origin == "" {
+		return body
+	}
+	return []byte(strings.Replace(string(body), "o="+origin, "o="+o.String(), 1))
+}
+
+// cutOrigin returns o= line value and body without it
+func cutOrigin(body []byte) (string, string) {
+	lines := strings.SplitAfter(string(body), "\n")
+	for i, line := range lines {
+		if v, found := strings.CutPrefix(line, "o="); found {
+			rest := strings.Join(lines[:i], "") + strings.Join(lines[i+1:], "")
+			return strings.TrimRight(v, "\r\n"), rest
+		}
+	}
+	return "", string(body)
+}
+
+// OriginVersion keeps o= line of local descriptions in dialog consistent as RFC 3264 8 requires.
+// Session id and username stay as in first description, and version is incremented
+// only when description changes. Zero value is ready to use
+type OriginVersion struct {
+	mu     sync.Mutex
+	origin *Origin
+	last   string
+}
+
+// Apply sets tracked origin on body and returns it
+func (v *OriginVersion) Apply(body []byte) []byte {
+	value, rest := cutOrigin(body)
+	o, err := ParseOrigin(value)
+	if err != nil {
+		return body
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.origin == nil {
+		v.origin = &o
+		v.last = rest
+		return body
+	}
+
+	if rest != v.last || o.Address != v.origin.Address {
+		v.origin.SessionVersion++
+		v.origin.Address = o.Address
+		v.origin.AddressType = o.AddressType
+		v.last = rest
+	}
+	return SetOrigin(body, *v.origin)
+}
+
+// Current returns origin of last applied description
+func (v *OriginVersion) Current() (Origin, bool) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.origin == nil {
+		return Origin{}, false
+	}
+	return *v.origin, true
+}
@@ -0,0 +1,72 @@
+package sdp
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// RTCPAttribute is RTCP port and optional address of media when RTCP is not on RTP port + 1
+// a=rtcp:<port> [<nettype> <addrtype> <connection-address>]
+// https://datatracker.ietf.org/doc/html/rfc3605
+type RTCPAttribute struct {
+	Port int
+	// IP is nil if address is not present and connection address is used
+	IP net.IP
+}
+
+// ParseRTCPAttribute parses rtcp attribute value, ex. "53020 IN IP4 126.16.64.4"
+func ParseRTCPAttribute(v string) (RTCPAttribute, error) {
+	a := RTCPAttribute{}
+	fields := strings.Fields(v)
+	if len(fields) != 1 && len(fields) != 4 {
+		return a, fmt.Errorf("invalid rtcp attribute %q", v)
+	}
+	port, err := strconv.ParseUint(fields[0], 10, 16)
+	if err != nil || port == 0 {
+		return a, fmt.Errorf("invalid rtcp port %q", fields[0])
+	}
+	a.Port = int(port)
+	if len(fields) == 1 {
+		return a, nil
+	}
+
+	if fields[1] != "IN" || (fields[2] != "IP4" && fields[2] != "IP6") {
+		return a, fmt.Errorf("invalid rtcp address type %q", v)
+	}
+	if a.IP = net.ParseIP(fields[3]); a.IP == nil {
+		return a, fmt.Errorf("invalid rtcp address %q", fields[3])
+	}
+	return a, nil
+}
+
+// String returns attribute value without rtcp: prefix
+func (a RTCPAttribute) String() string {
+	if a.IP == nil {
+		return strconv.Itoa(a.Port)
+	}
+	addrType := "IP4"
+	if a.IP.To4() == nil {
+		addrType = "IP6"
+	}
+	return strconv.Itoa(a.Port) + " IN " + addrType + " " + a.IP.String()
+}
+
+// RTCP returns rtcp attribute of media. False is returned if attribute is not present or invalid
+func (sd SessionDescription) RTCP(mediaType string) (RTCPAttribute, bool) {
+	for _, v := range sd.attributes(mediaType) {
+		v, found := strings.CutPrefix(v, "rtcp:")
+		if !found {
+			continue
+		}
+		a, err := ParseRTCPAttribute(v)
+		return a, err == nil
+	}
+	return RTCPAttribute{}, false
+}
+
+// AppendRTCP adds rtcp attribute to SDP body with single media
+func AppendRTCP(body []byte, a RTCPAttribute) []byte {
+	return []byte(strings.TrimRight(string(body), "\r\n") + "\r\na=rtcp:" + a.String() + "\r\n")
+}
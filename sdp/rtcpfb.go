@@ -0,0 +1,28 @@
+package sdp
+
+import "strings"
+
+// RTCPFeedbackNACK is Generic NACK feedback type
+// https://datatracker.ietf.org/doc/html/rfc4585#section-4.2
+const RTCPFeedbackNACK = "nack"
+
+// RTCPFeedback returns true if media has rtcp-fb attribute with feedback for format or all formats (*),
+// ex. a=rtcp-fb:* nack. Feedback is matched with its parameters, so "nack" does not match "nack pli"
+func (sd SessionDescription) RTCPFeedback(mediaType string, format string, feedback string) bool {
+	for _, v := range sd.levelAttributes(mediaType) {
+		v, found := strings.CutPrefix(v, "rtcp-fb:")
+		if !found {
+			continue
+		}
+		pt, fb, _ := strings.Cut(strings.TrimSpace(v), " ")
+		if (pt == "*" || pt == format) && strings.Join(strings.Fields(fb), " ") == feedback {
+			return true
+		}
+	}
+	return false
+}
+
+// AppendRTCPFeedback adds rtcp-fb attribute to SDP body with single media. Format * applies to all formats
+func AppendRTCPFeedback(body []byte, format string, feedback string) []byte {
+	return []byte(strings.TrimRight(string(body), "\r\n") + "\r\na=rtcp-fb:" + format + " " + feedback + "\r\n")
+}
@@ -8,6 +8,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 )
 
 var bufReader = sync.Pool{
@@ -45,6 +46,21 @@ type MediaDescription struct {
 	Proto string
 
 	Formats []string
+
+	// Ptime is packet time (a=ptime), MaxPtime is maximum packet time (a=maxptime).
+	// Zero if not present
+	Ptime    time.Duration
+	MaxPtime time.Duration
+
+	// Mode is media level direction. Empty if not present
+	Mode Mode
+	// Connection is media level connection address. Nil if not present
+	Connection net.IP
+
+	// Codecs describe formats with rtpmap and fmtp. Used by Generate
+	Codecs []Codec
+	// Attributes are other media attributes without a= prefix. Used by Generate
+	Attributes []string
 }
 
 func (m *MediaDescription) String() string {
@@ -56,47 +72,292 @@ func (m *MediaDescription) String() string {
 	return fmt.Sprintf("m=%s %s %s %s", m.MediaType, ports, m.Proto, strings.Join(m.Formats, " "))
 }
 
+// MediaDescription returns media of type. If there are more media of same type,
+// first not rejected is returned
 func (sd SessionDescription) MediaDescription(mediaType string) (MediaDescription, error) {
-	values := sd.Values("m")
+	index := sd.mediaIndex(mediaType)
+	if index < 0 {
+		return MediaDescription{}, fmt.Errorf("Media not found for %q", mediaType)
+	}
+	md, err := parseMediaLine(sd.Values("m")[index])
+	if err != nil {
+		return md, err
+	}
 
-	md := MediaDescription{}
-	var v string
-	for _, val := range values {
-		ind := strings.Index(val, " ")
-		if ind < 1 {
-			continue
+	for _, a := range sd.attributes(mediaType) {
+		name, value, _ := strings.Cut(a, ":")
+		switch name {
+		case "ptime":
+			md.Ptime = parsePacketTime(value)
+		case "maxptime":
+			md.MaxPtime = parsePacketTime(value)
 		}
-		media := val[:ind]
-		if media == mediaType {
-			v = val
-			break
+	}
+	if sd.indexed() {
+		md.Mode, _ = findMode(sd.MediaAttributes(mediaType))
+		if c := sd.mediaValues("c", mediaType); len(c) > 0 {
+			ci, err := parseConnectionInformation(c[0])
+			if err != nil {
+				return md, err
+			}
+			md.Connection = ci.IP
 		}
 	}
+	return md, nil
+}
 
-	if v == "" {
-		return md, fmt.Errorf("Media not found for %q", mediaType)
+// MediaDescriptions returns m= lines in order. Only m= line fields and media level
+// Connection are set
+func (sd SessionDescription) MediaDescriptions() ([]MediaDescription, error) {
+	values := sd.Values("m")
+	conns := sd.levels("c")
+	medias := make([]MediaDescription, 0, len(values))
+	for i, val := range values {
+		md, err := parseMediaLine(val)
+		if err != nil {
+			return nil, err
+		}
+		if c := conns[i+1]; len(c) > 0 {
+			ci, err := parseConnectionInformation(c[0])
+			if err != nil {
+				return nil, err
+			}
+			md.Connection = ci.IP
+		}
+		medias = append(medias, md)
 	}
+	return medias, nil
+}
 
+// parseMediaLine parses m= line value. Rejected media is allowed without formats,
+// as some implementations send it that way
+func parseMediaLine(v string) (MediaDescription, error) {
+	md := MediaDescription{}
 	fields := strings.Fields(v)
-	// TODO: is this really a must
-	if len(fields) < 4 {
+	if len(fields) < 3 {
 		return md, fmt.Errorf("Not enough fields in media description")
 	}
 
 	md.MediaType = fields[0]
 
 	ports := strings.Split(fields[1], "/")
-	md.Port, _ = strconv.Atoi(ports[0])
+	port, err := strconv.Atoi(ports[0])
+	if err != nil {
+		return md, fmt.Errorf("Invalid media port %q", fields[1])
+	}
+	md.Port = port
 	if len(ports) > 1 {
 		md.PortNumbers, _ = strconv.Atoi(ports[1])
 	}
 
 	md.Proto = fields[2]
-
 	md.Formats = fields[3:]
+	// TODO: is this really a must
+	if len(md.Formats) == 0 && !md.Rejected() {
+		return md, fmt.Errorf("Not enough fields in media description")
+	}
 	return md, nil
 }
 
+// Rejected reports whether media stream is rejected or disabled with port 0
+func (m *MediaDescription) Rejected() bool {
+	return m.Port == 0
+}
+
+// Reject returns media description which rejects this media in answer, as in RFC 3264 6.
+// Media type, proto and formats are echoed with port 0
+func (m *MediaDescription) Reject() MediaDescription {
+	formats := m.Formats
+	if len(formats) == 0 {
+		// At least one format is required even if it is ignored
+		formats = []string{"0"}
+	}
+	return MediaDescription{
+		MediaType: m.MediaType,
+		Proto:     m.Proto,
+		Formats:   append([]string{}, formats...),
+	}
+}
+
+// parsePacketTime parses milliseconds, which can be fractional
+func parsePacketTime(v string) time.Duration {
+	ms, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+	if err != nil || ms < 0 {
+		return 0
+	}
+	return time.Duration(ms * float64(time.Millisecond))
+}
+
+// levelHintKey is key where Unmarshal records values of key on level, which is index of m= line
+// or -1 for session level. Hints only place values of key under their m= line, values of key stay
+// authoritative, so they can be edited directly
+func levelHintKey(key string, level int) string {
+	if level < 0 {
+		return key + "/"
+	}
+	return key + "/" + strconv.Itoa(level)
+}
+
+// levels splits values of key by level. First is session level followed by every m= line.
+// Values are placed on level recorded by Unmarshal. When values were edited, they are aligned
+// with recorded ones: changed value keeps level of value it replaced, and added value is placed
+// on level of value before it. If description is not created with Unmarshal, all values are on
+// session level
+func (sd SessionDescription) levels(key string) [][]string {
+	medias := len(sd.Values("m"))
+	out := make([][]string, medias+1)
+	values := sd.Values(key)
+	if !sd.indexed() {
+		out[0] = values
+		return out
+	}
+
+	recorded := []string{}
+	recordedLevels := []int{}
+	for level := 0; level <= medias; level++ {
+		for _, v := range sd.Values(levelHintKey(key, level-1)) {
+			recorded = append(recorded, v)
+			recordedLevels = append(recordedLevels, level)
+		}
+	}
+
+	// Longest common subsequence of recorded and current values anchors unchanged values
+	lcs := make([][]int, len(recorded)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(values)+1)
+	}
+	for i := len(recorded) - 1; i >= 0; i-- {
+		for j := len(values) - 1; j >= 0; j-- {
+			if recorded[i] == values[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	level := 0
+	i, j := 0, 0
+	// Values between anchors replace recorded values between them in order
+	gapR, gapV := 0, 0
+	for j < len(values) {
+		if i < len(recorded) && recorded[i] == values[j] && lcs[i][j] == lcs[i+1][j+1]+1 {
+			level = recordedLevels[i]
+			out[level] = append(out[level], values[j])
+			i, j = i+1, j+1
+			gapR, gapV = i, j
+			continue
+		}
+		if i < len(recorded) && lcs[i+1][j] >= lcs[i][j+1] {
+			i++
+			continue
+		}
+		l := level
+		if r := gapR + j - gapV; r < i {
+			l = recordedLevels[r]
+		}
+		out[l] = append(out[l], values[j])
+		j++
+	}
+	return out
+}
+
+// mediaIndex returns index of m= line of type, which is first not rejected one. -1 if there is none
+func (sd SessionDescription) mediaIndex(mediaType string) int {
+	index := -1
+	for i, val := range sd.Values("m") {
+		if media, _, _ := strings.Cut(val, " "); media != mediaType {
+			continue
+		}
+		m, err := parseMediaLine(val)
+		if err != nil {
+			continue
+		}
+		if !m.Rejected() {
+			return i
+		}
+		if index < 0 {
+			index = i
+		}
+	}
+	return index
+}
+
+// mediaValues returns media level values of key of media of type. See MediaDescription
+func (sd SessionDescription) mediaValues(key string, mediaType string) []string {
+	index := sd.mediaIndex(mediaType)
+	if index < 0 {
+		return nil
+	}
+	return sd.levels(key)[index+1]
+}
+
+// SessionAttributes returns attributes before first media description
+func (sd SessionDescription) SessionAttributes() []string {
+	return sd.levels("a")[0]
+}
+
+// MediaAttributes returns attributes of media description
+func (sd SessionDescription) MediaAttributes(mediaType string) []string {
+	return sd.mediaValues("a", mediaType)
+}
+
+// SessionLevel are session level values which apply to media without own value.
+// Some PBXes put direction, crypto or connection on session level only, or on both
+type SessionLevel struct {
+	// Mode is session level direction. Empty if not present
+	Mode Mode
+	// Connection is session level connection address. Nil if not present
+	Connection net.IP
+	// Cryptos are session level crypto attributes. RFC 4568 defines them only on media level,
+	// but they are used for media without own crypto
+	Cryptos []Crypto
+}
+
+// SessionLevel returns session level values. Media level values are in MediaDescription,
+// and MediaMode, MediaConnectionInformation and Cryptos apply precedence of both
+func (sd SessionDescription) SessionLevel() (SessionLevel, error) {
+	l := SessionLevel{}
+	attrs := sd.SessionAttributes()
+	l.Mode, _ = findMode(attrs)
+	l.Cryptos = parseCryptos(attrs)
+	if c := sd.levels("c")[0]; len(c) > 0 {
+		ci, err := parseConnectionInformation(c[0])
+		if err != nil {
+			return l, err
+		}
+		l.Connection = ci.IP
+	}
+	return l, nil
+}
+
+// attributes returns attributes of media, or all attributes if description is not created with Unmarshal
+func (sd SessionDescription) attributes(mediaType string) []string {
+	if sd.indexed() {
+		return sd.MediaAttributes(mediaType)
+	}
+	return sd.Values("a")
+}
+
+// levelAttributes returns attributes of media followed by session level ones
+func (sd SessionDescription) levelAttributes(mediaType string) []string {
+	attrs := sd.attributes(mediaType)
+	if sd.indexed() {
+		attrs = append(attrs[:len(attrs):len(attrs)], sd.SessionAttributes()...)
+	}
+	return attrs
+}
+
+// indexed returns true if values are indexed by level, which Unmarshal does
+func (sd SessionDescription) indexed() bool {
+	for k := range sd {
+		if len(k) > 1 && k[1] == '/' {
+			return true
+		}
+	}
+	return false
+}
+
 // c=<nettype> <addrtype> <connection-address>
 // https://tools.ietf.org/html/rfc4566#section-5.7
 type ConnectionInformation struct {
@@ -107,12 +368,29 @@ type ConnectionInformation struct {
 	Range       int
 }
 
+// ConnectionInformation returns connection of audio media. See MediaConnectionInformation
 func (sd SessionDescription) ConnectionInformation() (ci ConnectionInformation, err error) {
-	v := sd.Value("c")
-	if v == "" {
+	return sd.MediaConnectionInformation("audio")
+}
+
+// MediaConnectionInformation returns media level connection, or session level one
+// if media has none (RFC 4566 5.7)
+func (sd SessionDescription) MediaConnectionInformation(mediaType string) (ci ConnectionInformation, err error) {
+	values := sd.mediaValues("c", mediaType)
+	if len(values) == 0 {
+		values = sd.levels("c")[0]
+	}
+	if len(values) == 0 {
 		return ci, fmt.Errorf("Connection information does not exists")
 	}
+	return parseConnectionInformation(values[0])
+}
+
+func parseConnectionInformation(v string) (ci ConnectionInformation, err error) {
 	fields := strings.Fields(v)
+	if len(fields) < 3 {
+		return ci, fmt.Errorf("Not enough fields in connection information")
+	}
 	ci.NetworkType = fields[0]
 	ci.AddressType = fields[1]
 	addr := strings.Split(fields[2], "/")
@@ -122,12 +400,12 @@ func (sd SessionDescription) ConnectionInformation() (ci ConnectionInformation,
 	case "IP4":
 		ci.IP = ci.IP.To4()
 		if ci.IP == nil {
-			return ci, fmt.Errorf("failed to convert to IP4")
+			return ci, fmt.Errorf("invalid IP4 connection address %q", addr[0])
 		}
 	case "IP6":
 		ci.IP = ci.IP.To16()
 		if ci.IP == nil {
-			return ci, fmt.Errorf("failed to convert to IP4")
+			return ci, fmt.Errorf("invalid IP6 connection address %q", addr[0])
 		}
 	}
 
@@ -151,6 +429,7 @@ func Unmarshal(data []byte, sdptr *SessionDescription) error {
 	reader.Write(data)
 
 	sd := *sdptr
+	mediaIndex := -1
 	for {
 		line, err := nextLine(reader)
 		if err != nil {
@@ -172,8 +451,58 @@ func Unmarshal(data []byte, sdptr *SessionDescription) error {
 		value := line[ind+1:]
 
 		sd[key] = append(sd[key], value)
+
+		switch key {
+		case "m":
+			mediaIndex++
+		case "a", "b", "c", "i", "k":
+			// Key holds values of all levels, so level of each is recorded
+			k := levelHintKey(key, mediaIndex)
+			sd[k] = append(sd[k], value)
+		}
+	}
+
+}
+
+// Marshal writes description in RFC 4566 line order with CRLF line endings.
+// Description created with Unmarshal is written back with media level lines
+// under their m= line, so it can be modified and sent again, ex. with changed port
+// or connection. Values are always taken from line type keys, see levels for placement
+// of added values. If description is not created with Unmarshal, all lines other
+// than m= are written on session level. Line types not defined by RFC 4566 are not written.
+// Use Builder to edit sections or keep them
+func (sd SessionDescription) Marshal() []byte {
+	var b bytes.Buffer
+	write := func(key string, values []string) {
+		for _, v := range values {
+			b.WriteString(key)
+			b.WriteByte('=')
+			b.WriteString(v)
+			b.WriteString("\r\n")
+		}
 	}
 
+	levels := map[string][][]string{}
+	for _, k := range mediaLineOrder[1:] {
+		levels[string(k)] = sd.levels(string(k))
+	}
+	for _, k := range sessionLineOrder {
+		key := string(k)
+		values := sd.Values(key)
+		if l, ok := levels[key]; ok {
+			values = l[0]
+		}
+		write(key, values)
+	}
+
+	for i, m := range sd.Values("m") {
+		write("m", []string{m})
+		for _, k := range mediaLineOrder[1:] {
+			key := string(k)
+			write(key, levels[key][i+1])
+		}
+	}
+	return b.Bytes()
 }
 
 func nextLine(reader *bytes.Buffer) (line string, err error) {
@@ -2,7 +2,10 @@ package sdp
 
 import (
 	"net"
+	"slices"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 )
@@ -54,3 +57,1041 @@ a=rtcp-mux`
 	require.Equal(t, net.ParseIP("192.168.100.11"), ci.IP)
 
 }
+
+func TestCompact(t *testing.T) {
+	body := "v=0\r\no=- 1 1 IN IP4 10.0.0.1\r\ns=-\r\ni=Session info\r\nu=http://example.com\r\ne=a@example.com\r\nc=IN IP4 10.0.0.1\r\nt=0 0\r\nm=audio 4000 RTP/AVP 0 8 96\r\na=rtpmap:0 PCMU/8000\r\na=rtpmap:8 PCMA/8000\r\na=rtpmap:96 opus/48000/2\r\n"
+	require.Equal(t,
+		"v=0\r\no=- 1 1 IN IP4 10.0.0.1\r\ns=-\r\nc=IN IP4 10.0.0.1\r\nt=0 0\r\nm=audio 4000 RTP/AVP 0 8 96\r\na=rtpmap:96 opus/48000/2\r\n",
+		string(Compact([]byte(body))),
+	)
+}
+
+func TestTrim(t *testing.T) {
+	body := "v=0\r\nc=IN IP4 10.0.0.1\r\nm=audio 4000 RTP/AVP 96 0 8 101\r\na=rtpmap:96 opus/48000/2\r\na=fmtp:96 useinbandfec=1\r\na=rtpmap:0 PCMU/8000\r\na=rtpmap:8 PCMA/8000\r\na=rtpmap:101 telephone-event/8000\r\na=fmtp:101 0-16\r\n"
+	require.Equal(t,
+		"v=0\r\nc=IN IP4 10.0.0.1\r\nm=audio 4000 RTP/AVP 96 0 101\r\na=rtpmap:96 opus/48000/2\r\na=fmtp:96 useinbandfec=1\r\na=rtpmap:0 PCMU/8000\r\na=rtpmap:101 telephone-event/8000\r\na=fmtp:101 0-16\r\n",
+		string(Trim([]byte(body), TrimOptions{MaxFormats: 2})),
+	)
+
+	require.Equal(t,
+		"v=0\r\nc=IN IP4 10.0.0.1\r\nm=audio 4000 RTP/AVP 96 101\r\na=rtpmap:96 opus/48000/2\r\na=fmtp:96 useinbandfec=1\r\na=rtpmap:101 telephone-event/8000\r\na=fmtp:101 0-16\r\n",
+		string(Trim([]byte(body), TrimOptions{MaxFormats: 1, Compact: true})),
+	)
+}
+
+func TestOfferAnswer(t *testing.T) {
+	ip := net.IPv4(127, 0, 0, 1)
+
+	t.Run("LocalOffer", func(t *testing.T) {
+		oa := &OfferAnswer{}
+		offer, err := oa.CreateOffer(AudioOptions{OriginIP: ip, ConnectionIP: ip, Port: 1234, Formats: NewFormats("0", "8")})
+		require.NoError(t, err)
+		require.Equal(t, StateHaveLocalOffer, oa.State())
+
+		// Answer before offer exchange is done is rejected
+		_, err = oa.CreateAnswer(AudioOptions{Formats: NewFormats("0")})
+		require.ErrorIs(t, err, ErrInvalidState)
+
+		// Answer with format not offered
+		bad := GenerateForAudio(ip, ip, 2000, ModeSendrecv, NewFormats("9"))
+		require.ErrorIs(t, oa.SetRemoteDescription(TypeAnswer, bad), ErrIncompatibleAnswer)
+
+		pranswer := GenerateForAudio(ip, ip, 2000, ModeRecvonly, NewFormats("8"))
+		require.NoError(t, oa.SetRemoteDescription(TypePranswer, pranswer))
+		require.Equal(t, StateHaveRemotePranswer, oa.State())
+
+		answer := GenerateForAudio(ip, ip, 2000, ModeSendrecv, NewFormats("8"))
+		require.NoError(t, oa.SetRemoteDescription(TypeAnswer, answer))
+		require.Equal(t, StateStable, oa.State())
+		require.Equal(t, offer, oa.LocalDescription())
+		require.Equal(t, answer, oa.RemoteDescription())
+	})
+
+	t.Run("RemoteOffer", func(t *testing.T) {
+		oa := &OfferAnswer{}
+		offer := GenerateForAudio(ip, ip, 2000, ModeSendonly, NewFormats("8", "0"))
+		require.NoError(t, oa.SetRemoteDescription(TypeOffer, offer))
+		require.Equal(t, StateHaveRemoteOffer, oa.State())
+
+		// Glare, we can not offer while remote offer is pending
+		_, err := oa.CreateOffer(AudioOptions{Formats: NewFormats("0")})
+		require.ErrorIs(t, err, ErrInvalidState)
+
+		_, err = oa.CreateAnswer(AudioOptions{Formats: NewFormats("9")})
+		require.ErrorIs(t, err, ErrNoCommonFormat)
+
+		answer, err := oa.CreateAnswer(AudioOptions{OriginIP: ip, ConnectionIP: ip, Port: 1234, Formats: NewFormats("0", "8")})
+		require.NoError(t, err)
+		require.Equal(t, StateStable, oa.State())
+
+		sd := SessionDescription{}
+		require.NoError(t, Unmarshal(answer, &sd))
+		md, err := sd.MediaDescription("audio")
+		require.NoError(t, err)
+		// Offer order is kept
+		require.Equal(t, []string{"8", "0", "101"}, md.Formats)
+		require.Equal(t, ModeRecvonly, sd.Mode())
+	})
+
+	t.Run("Rollback", func(t *testing.T) {
+		oa := &OfferAnswer{}
+		_, err := oa.CreateOffer(AudioOptions{OriginIP: ip, ConnectionIP: ip, Port: 1234, Formats: NewFormats("0")})
+		require.NoError(t, err)
+		oa.Rollback()
+		require.Equal(t, StateStable, oa.State())
+		require.Nil(t, oa.LocalDescription())
+	})
+}
+
+func TestAnswerMode(t *testing.T) {
+	require.Equal(t, ModeSendrecv, AnswerMode(ModeSendrecv, ""))
+	require.Equal(t, ModeRecvonly, AnswerMode(ModeSendonly, ModeSendrecv))
+	require.Equal(t, ModeSendonly, AnswerMode(ModeRecvonly, ModeSendrecv))
+	require.Equal(t, ModeInactive, AnswerMode(ModeInactive, ModeSendrecv))
+	require.Equal(t, ModeInactive, AnswerMode(ModeSendonly, ModeSendonly))
+}
+
+func TestIsHold(t *testing.T) {
+	ip := net.IPv4(127, 0, 0, 1)
+	for _, tc := range []struct {
+		body []byte
+		hold bool
+	}{
+		{GenerateForAudio(ip, ip, 1000, ModeSendrecv, NewFormats("0")), false},
+		{GenerateForAudio(ip, ip, 1000, ModeRecvonly, NewFormats("0")), false},
+		{GenerateForAudio(ip, ip, 1000, ModeSendonly, NewFormats("0")), true},
+		{GenerateForAudio(ip, ip, 1000, ModeInactive, NewFormats("0")), true},
+		{GenerateForAudio(ip, net.IPv4zero, 1000, ModeSendrecv, NewFormats("0")), true},
+	} {
+		sd := SessionDescription{}
+		require.NoError(t, Unmarshal(tc.body, &sd))
+		require.Equal(t, tc.hold, sd.IsHold(), string(tc.body))
+	}
+}
+
+func TestCodecs(t *testing.T) {
+	body := "v=0\r\n" +
+		"o=- 1 1 IN IP4 127.0.0.1\r\n" +
+		"s=-\r\n" +
+		"c=IN IP4 127.0.0.1\r\n" +
+		"t=0 0\r\n" +
+		"m=audio 1000 RTP/AVP 111 0 101\r\n" +
+		"a=rtpmap:111 opus/48000/2\r\n" +
+		"a=fmtp:111 minptime=10;useinbandfec=1\r\n" +
+		"a=rtpmap:101 telephone-event/8000\r\n" +
+		"a=fmtp:101 0-16\r\n"
+
+	sd := SessionDescription{}
+	require.NoError(t, Unmarshal([]byte(body), &sd))
+	codecs, err := sd.Codecs("audio")
+	require.NoError(t, err)
+	require.Len(t, codecs, 3)
+
+	require.Equal(t, Codec{
+		PayloadType: 111,
+		Name:        "opus",
+		ClockRate:   48000,
+		Channels:    2,
+		Fmtp:        "minptime=10;useinbandfec=1",
+		Params:      map[string]string{"minptime": "10", "useinbandfec": "1"},
+	}, codecs[0])
+	require.Equal(t, "opus/48000/2", codecs[0].String())
+
+	// Static without rtpmap
+	require.Equal(t, "PCMU/8000", codecs[1].String())
+	require.Equal(t, uint8(0), codecs[1].PayloadType)
+
+	require.Equal(t, "telephone-event", codecs[2].Name)
+	require.Equal(t, "0-16", codecs[2].Fmtp)
+	require.Empty(t, codecs[2].Params)
+
+	_, err = ParseRTPMap("96 H264")
+	require.Error(t, err)
+}
+
+func TestTelephoneEvent(t *testing.T) {
+	ip := net.IPv4(127, 0, 0, 1)
+	sd := SessionDescription{}
+	require.NoError(t, Unmarshal(GenerateForAudioDTMF(ip, ip, 1000, ModeSendrecv, NewFormats("0"), "96"), &sd))
+	pt, ok := sd.TelephoneEvent()
+	require.True(t, ok)
+	require.Equal(t, "96", pt)
+
+	body := GenerateForAudioDTMF(ip, ip, 1000, ModeSendrecv, NewFormats("0"), "")
+	require.Contains(t, string(body), "m=audio 1000 RTP/AVP 0\r\n")
+	require.NotContains(t, string(body), "telephone-event")
+	sd = SessionDescription{}
+	require.NoError(t, Unmarshal(body, &sd))
+	_, ok = sd.TelephoneEvent()
+	require.False(t, ok)
+
+	// Answer keeps payload type of offer
+	oa := &OfferAnswer{}
+	require.NoError(t, oa.SetRemoteDescription(TypeOffer, GenerateForAudioDTMF(ip, ip, 1000, ModeSendrecv, NewFormats("0"), "96")))
+	answer, err := oa.CreateAnswer(AudioOptions{OriginIP: ip, ConnectionIP: ip, Port: 2000, Formats: NewFormats("0")})
+	require.NoError(t, err)
+	require.Contains(t, string(answer), "m=audio 2000 RTP/AVP 0 96\r\n")
+}
+
+func TestCodecRegistry(t *testing.T) {
+	var nilRegistry *CodecRegistry
+	c, ok := nilRegistry.Lookup("8")
+	require.True(t, ok)
+	require.Equal(t, "PCMA/8000", c.String())
+
+	r := NewCodecRegistry()
+	require.Error(t, r.Register(Codec{PayloadType: 8, Name: "PCMA", ClockRate: 8000}))
+	require.Error(t, r.Register(Codec{PayloadType: 96, Name: "opus"}))
+	require.NoError(t, r.Register(Codec{PayloadType: 96, Name: "opus", ClockRate: 48000, Channels: 2}))
+
+	c, ok = r.Lookup("96")
+	require.True(t, ok)
+	require.True(t, c.Equal(Codec{PayloadType: 111, Name: "OPUS", ClockRate: 48000, Channels: 2}))
+	require.False(t, c.Equal(Codec{Name: "opus", ClockRate: 48000}))
+
+	_, ok = r.Lookup("97")
+	require.False(t, ok)
+
+	require.NoError(t, r.Register(Codec{PayloadType: 97, Name: "speex", ClockRate: 16000}))
+	require.Equal(t, []uint8{96, 97}, []uint8{r.Codecs()[0].PayloadType, r.Codecs()[1].PayloadType})
+	require.Nil(t, nilRegistry.Codecs())
+
+	body := AppendCodecs(GenerateForAudio(net.IPv4(127, 0, 0, 1), net.IPv4(127, 0, 0, 1), 1000, ModeSendrecv, NewFormats("96")), []Codec{c})
+	sd := SessionDescription{}
+	require.NoError(t, Unmarshal(body, &sd))
+	codecs, err := sd.Codecs("audio")
+	require.NoError(t, err)
+	require.Equal(t, "opus/48000/2", codecs[0].String())
+}
+
+func TestMediaMode(t *testing.T) {
+	body := "v=0\r\n" +
+		"o=- 1 1 IN IP4 127.0.0.1\r\n" +
+		"s=-\r\n" +
+		"c=IN IP4 127.0.0.1\r\n" +
+		"t=0 0\r\n" +
+		"a=sendonly\r\n" +
+		"m=video 1002 RTP/AVP 96\r\n" +
+		"a=inactive\r\n" +
+		"m=audio 1000 RTP/AVP 0\r\n" +
+		"a=rtpmap:0 PCMU/8000\r\n"
+
+	sd := SessionDescription{}
+	require.NoError(t, Unmarshal([]byte(body), &sd))
+	require.Equal(t, []string{"sendonly"}, sd.SessionAttributes())
+	require.Equal(t, []string{"inactive"}, sd.MediaAttributes("video"))
+	require.Equal(t, ModeInactive, sd.MediaMode("video"))
+	// Audio inherits session level
+	require.Equal(t, ModeSendonly, sd.Mode())
+
+	body2 := SetMediaMode([]byte(body), "audio", ModeRecvonly)
+	sd = SessionDescription{}
+	require.NoError(t, Unmarshal(body2, &sd))
+	require.Equal(t, ModeRecvonly, sd.Mode())
+	require.Equal(t, ModeInactive, sd.MediaMode("video"))
+
+	// Replaced, not duplicated
+	body2 = SetMediaMode(body2, "audio", ModeSendrecv)
+	require.Equal(t, 1, strings.Count(string(body2), "a=sendrecv"))
+	require.NotContains(t, string(body2), "a=recvonly")
+}
+
+func TestPtime(t *testing.T) {
+	ip := net.IPv4(127, 0, 0, 1)
+	body := AppendPtime(GenerateForAudio(ip, ip, 1000, ModeSendrecv, NewFormats("0")), 30*time.Millisecond, 22500*time.Microsecond)
+	require.Contains(t, string(body), "a=ptime:30\r\n")
+	require.Contains(t, string(body), "a=maxptime:22.5\r\n")
+
+	sd := SessionDescription{}
+	require.NoError(t, Unmarshal(body, &sd))
+	md, err := sd.MediaDescription("audio")
+	require.NoError(t, err)
+	require.Equal(t, 30*time.Millisecond, md.Ptime)
+	require.Equal(t, 22500*time.Microsecond, md.MaxPtime)
+
+	body = AppendPtime(GenerateForAudio(ip, ip, 1000, ModeSendrecv, NewFormats("0")), 0, 0)
+	require.NotContains(t, string(body), "ptime")
+}
+
+func TestBandwidth(t *testing.T) {
+	ip := net.IPv4(127, 0, 0, 1)
+	body := GenerateForAudio(ip, ip, 1000, ModeSendrecv, NewFormats("0"))
+	body = AppendBandwidth(body, "", Bandwidth{Type: BandwidthAS, Value: 128})
+	body = AppendBandwidth(body, "audio", Bandwidth{Type: BandwidthAS, Value: 64}, Bandwidth{Type: BandwidthTIAS, Value: 64000})
+	require.Contains(t, string(body), "b=AS:128\r\nt=0 0\r\nm=audio 1000 RTP/AVP 0 101\r\nb=AS:64\r\nb=TIAS:64000\r\n")
+
+	sd := SessionDescription{}
+	require.NoError(t, Unmarshal(body, &sd))
+	require.Equal(t, []Bandwidth{{Type: BandwidthAS, Value: 128}}, sd.Bandwidths(""))
+	bws := sd.Bandwidths("audio")
+	require.Len(t, bws, 2)
+	require.Equal(t, 64000, bws[0].BitsPerSecond())
+	require.Equal(t, 64000, bws[1].BitsPerSecond())
+	// Media without own lines has session level
+	require.Equal(t, 128000, sd.Bandwidths("video")[0].BitsPerSecond())
+
+	_, err := ParseBandwidth("AS")
+	require.Error(t, err)
+}
+
+func TestCrypto(t *testing.T) {
+	v := "1 AES_CM_128_HMAC_SHA1_80 inline:PS1uQCVeeCFCanVmcjkpPywjNWhcYD0mXXtxaVBR|2^20|1:4;inline:QUJDREVGR0hJSktMTU5PUFFSU1RVVldYWVpbXF1e|2^20|2:4 UNENCRYPTED_SRTCP"
+	c, err := ParseCrypto(v)
+	require.NoError(t, err)
+	require.Equal(t, 1, c.Tag)
+	require.Equal(t, CryptoAES128SHA1_80, c.Suite)
+	require.Len(t, c.KeyParams, 2)
+	require.Equal(t, CryptoKeyParam{Method: "inline", Key: "PS1uQCVeeCFCanVmcjkpPywjNWhcYD0mXXtxaVBR", Lifetime: "2^20", MKI: "1:4"}, c.KeyParams[0])
+	require.Equal(t, []string{"UNENCRYPTED_SRTCP"}, c.SessionParams)
+	require.Equal(t, v, c.String())
+
+	_, err = ParseCrypto("x AES_CM_128_HMAC_SHA1_80 inline:abc")
+	require.Error(t, err)
+
+	// Offer with 2 suites, answer picks one
+	c1, err := NewCrypto(1, CryptoAEADAES256GCM)
+	require.NoError(t, err)
+	c2, err := NewCrypto(2, CryptoAES128SHA1_80)
+	require.NoError(t, err)
+	key, err := c2.MasterKey()
+	require.NoError(t, err)
+	require.Len(t, key, 30)
+
+	ip := net.IPv4(127, 0, 0, 1)
+	body := AppendCrypto(GenerateForAudio(ip, ip, 1000, ModeSendrecv, NewFormats("0")), c1, c2)
+	sd := SessionDescription{}
+	require.NoError(t, Unmarshal(body, &sd))
+	offered := sd.Cryptos("audio")
+	require.Equal(t, []Crypto{c1, c2}, offered)
+
+	offer, answer, err := SelectCrypto(offered, []string{CryptoAES128SHA1_80, CryptoAES128SHA1_32})
+	require.NoError(t, err)
+	require.Equal(t, c2, offer)
+	require.Equal(t, 2, answer.Tag)
+	require.Equal(t, CryptoAES128SHA1_80, answer.Suite)
+	require.NotEqual(t, c2.KeyParams[0].Key, answer.KeyParams[0].Key)
+
+	_, _, err = SelectCrypto(offered, []string{CryptoAEADAES128GCM})
+	require.ErrorIs(t, err, ErrNoCommonCrypto)
+}
+
+func TestFingerprintSetup(t *testing.T) {
+	cert := []byte("fake der certificate")
+	f, err := NewFingerprint("SHA-256", cert)
+	require.NoError(t, err)
+	require.Equal(t, "sha-256", f.Hash)
+	require.Len(t, strings.Split(f.Value, ":"), 32)
+	require.True(t, f.Match(cert))
+	require.False(t, f.Match([]byte("other")))
+
+	_, err = NewFingerprint("md5", cert)
+	require.Error(t, err)
+	_, err = ParseFingerprint("sha-256")
+	require.Error(t, err)
+
+	ip := net.IPv4(127, 0, 0, 1)
+	body := GenerateForAudio(ip, ip, 1000, ModeSendrecv, NewFormats("0"))
+	body = AppendFingerprint(body, f)
+	body = AppendSetup(body, SetupActpass)
+
+	sd := SessionDescription{}
+	require.NoError(t, Unmarshal(body, &sd))
+	require.Equal(t, []Fingerprint{f}, sd.Fingerprints("audio"))
+	setup, ok := sd.Setup("audio")
+	require.True(t, ok)
+	require.Equal(t, SetupActpass, setup)
+	require.Equal(t, SetupActive, AnswerSetup(setup))
+	require.Equal(t, SetupPassive, AnswerSetup(SetupActive))
+
+	// Session level fingerprint applies to media without own
+	body = []byte("v=0\r\no=- 1 1 IN IP4 127.0.0.1\r\ns=-\r\nc=IN IP4 127.0.0.1\r\nt=0 0\r\n" +
+		"a=fingerprint:sha-256 " + f.Value + "\r\n" +
+		"m=audio 1000 RTP/AVP 0\r\na=setup:passive\r\n")
+	sd = SessionDescription{}
+	require.NoError(t, Unmarshal(body, &sd))
+	require.Equal(t, []Fingerprint{f}, sd.Fingerprints("audio"))
+	setup, _ = sd.Setup("audio")
+	require.Equal(t, SetupPassive, setup)
+}
+
+func TestICE(t *testing.T) {
+	c, err := ParseICECandidate("candidate:1 1 TCP 2105458943 192.0.2.1 9 typ host tcptype active generation 0")
+	require.NoError(t, err)
+	require.Equal(t, "active", c.TCPType)
+	require.Equal(t, map[string]string{"generation": "0"}, c.Extensions)
+	require.Equal(t, "candidate:1 1 TCP 2105458943 192.0.2.1 9 typ host tcptype active generation 0", c.String())
+
+	_, err = ParseICECandidate("candidate:1 1 UDP 1 192.0.2.1 0 typ host")
+	require.Error(t, err)
+
+	srflx, err := ParseICECandidate("candidate:2 1 UDP 1694498815 203.0.113.5 46154 typ srflx raddr 10.0.0.5 rport 46154")
+	require.NoError(t, err)
+
+	ip := net.IPv4(127, 0, 0, 1)
+	body := AppendICE(GenerateForAudio(ip, ip, 1000, ModeSendrecv, NewFormats("0")), ICEAttributes{
+		Ufrag:      "ufrag",
+		Pwd:        "password1234567890123456",
+		Options:    []string{"trickle"},
+		Lite:       true,
+		Candidates: []ICECandidate{srflx},
+	})
+	require.Contains(t, string(body), "a=ice-lite\r\nm=audio")
+
+	sd := SessionDescription{}
+	require.NoError(t, Unmarshal(body, &sd))
+	ice := sd.ICE("audio")
+	require.Equal(t, "ufrag", ice.Ufrag)
+	require.Equal(t, "password1234567890123456", ice.Pwd)
+	require.Equal(t, []string{"trickle"}, ice.Options)
+	require.True(t, ice.Lite)
+	require.Equal(t, []ICECandidate{srflx}, ice.Candidates)
+}
+
+func TestCheck(t *testing.T) {
+	ip := net.IPv4(127, 0, 0, 1)
+	body := GenerateForAudio(ip, ip, 1000, ModeSendrecv, NewFormats(FORMAT_TYPE_ULAW, FORMAT_TYPE_ALAW))
+	for _, f := range Check(body) {
+		require.NotEqual(t, SeverityError, f.Severity, f.String())
+	}
+
+	body = []byte("v=0\n" +
+		"s=-\n" +
+		"t=0 0\n" +
+		"m=audio 70000 RTP/SAVP 0 101\n" +
+		"a=sendrecv\n" +
+		"a=inactive\n" +
+		"a=rtpmap:102 telephone-event/8000\n")
+
+	messages := map[string]Severity{}
+	for _, f := range Check(body) {
+		messages[f.String()] = f.Severity
+	}
+	require.Contains(t, messages, "warning: lines are not terminated with CRLF")
+	require.Contains(t, messages, "error: missing session o= line")
+	require.Contains(t, messages, "error: line 4: invalid media port \"70000\"")
+	require.Contains(t, messages, "error: line 4: no connection address for audio media")
+	require.Contains(t, messages, "error: line 4: multiple direction attributes in audio media")
+	require.Contains(t, messages, "error: line 4: format 101 has no rtpmap")
+	require.Contains(t, messages, "warning: line 7: rtpmap for format 102 not in media description")
+	require.Contains(t, messages, "warning: line 4: protocol RTP/SAVP requires SRTP which sipgox does not support")
+	require.Contains(t, messages, "warning: no usable audio media, sipgox handles only audio")
+
+	require.Equal(t, []Finding{{Severity: SeverityError, Message: "empty SDP"}}, Check(nil))
+}
+
+func TestGenerate(t *testing.T) {
+	ip := net.IPv4(127, 0, 0, 1)
+	body := Generate(ip, ip,
+		MediaDescription{
+			MediaType: "audio",
+			Port:      4000,
+			Formats:   []string{"0", "101"},
+			Ptime:     20 * time.Millisecond,
+			Mode:      ModeSendrecv,
+			Codecs:    []Codec{{PayloadType: 101, Name: "telephone-event", ClockRate: 8000, Fmtp: "0-16"}},
+		},
+		MediaDescription{
+			MediaType: "video",
+			Port:      4002,
+			Formats:   []string{"96"},
+			Mode:      ModeRecvonly,
+			Codecs:    []Codec{{PayloadType: 96, Name: "H264", ClockRate: 90000, Fmtp: "packetization-mode=1"}},
+		},
+		MediaDescription{
+			MediaType:  "image",
+			Port:       4004,
+			Proto:      ProtoUDPTL,
+			Formats:    []string{"t38"},
+			Attributes: []string{"T38FaxVersion:0", "T38FaxRateManagement:transferredTCF"},
+		},
+	)
+
+	for _, f := range Check(body) {
+		require.NotEqual(t, SeverityError, f.Severity, f.String())
+	}
+
+	sd := SessionDescription{}
+	require.NoError(t, Unmarshal(body, &sd))
+	require.Len(t, sd.Values("m"), 3)
+
+	audio, err := sd.MediaDescription("audio")
+	require.NoError(t, err)
+	require.Equal(t, []string{"0", "101"}, audio.Formats)
+	require.Equal(t, 20*time.Millisecond, audio.Ptime)
+	require.Equal(t, ModeSendrecv, audio.Mode)
+	codecs, err := sd.Codecs("audio")
+	require.NoError(t, err)
+	require.Len(t, codecs, 2)
+
+	video, err := sd.MediaDescription("video")
+	require.NoError(t, err)
+	require.Equal(t, ModeRecvonly, video.Mode)
+	require.Equal(t, ModeRecvonly, sd.MediaMode("video"))
+	require.Equal(t, ModeSendrecv, sd.MediaMode("audio"))
+
+	image, err := sd.MediaDescription("image")
+	require.NoError(t, err)
+	require.Equal(t, ProtoUDPTL, image.Proto)
+	require.Equal(t, []string{"T38FaxVersion:0", "T38FaxRateManagement:transferredTCF"}, sd.MediaAttributes("image"))
+}
+
+func TestMediaConnectionInformation(t *testing.T) {
+	body := []byte("v=0\r\n" +
+		"o=- 1 1 IN IP4 10.0.0.1\r\n" +
+		"s=-\r\n" +
+		"c=IN IP4 10.0.0.1\r\n" +
+		"t=0 0\r\n" +
+		"m=audio 4000 RTP/AVP 0\r\n" +
+		"c=IN IP4 10.0.0.2\r\n" +
+		"m=video 4002 RTP/AVP 96\r\n" +
+		"a=rtpmap:96 H264/90000\r\n")
+
+	sd := SessionDescription{}
+	require.NoError(t, Unmarshal(body, &sd))
+
+	ci, err := sd.ConnectionInformation()
+	require.NoError(t, err)
+	require.Equal(t, "10.0.0.2", ci.IP.String())
+
+	ci, err = sd.MediaConnectionInformation("video")
+	require.NoError(t, err)
+	require.Equal(t, "10.0.0.1", ci.IP.String())
+
+	md, err := sd.MediaDescription("audio")
+	require.NoError(t, err)
+	require.Equal(t, "10.0.0.2", md.Connection.String())
+
+	t.Run("Answer", func(t *testing.T) {
+		oa := &OfferAnswer{}
+		require.NoError(t, oa.SetRemoteDescription(TypeOffer, body))
+
+		ip := net.IPv4(192, 168, 0, 1)
+		mediaIP := net.IPv4(172, 16, 0, 1)
+		answer, err := oa.CreateAnswer(AudioOptions{OriginIP: ip, ConnectionIP: ip, MediaConnectionIP: mediaIP, Port: 2000, Formats: NewFormats("0")})
+		require.NoError(t, err)
+
+		sd := SessionDescription{}
+		require.NoError(t, Unmarshal(answer, &sd))
+		require.Len(t, sd.Values("m"), 2)
+
+		ci, err := sd.ConnectionInformation()
+		require.NoError(t, err)
+		require.Equal(t, mediaIP.String(), ci.IP.String())
+
+		video, err := sd.MediaDescription("video")
+		require.NoError(t, err)
+		require.Equal(t, 0, video.Port)
+		ci, err = sd.MediaConnectionInformation("video")
+		require.NoError(t, err)
+		require.Equal(t, ip.String(), ci.IP.String())
+	})
+}
+
+func TestOriginVersion(t *testing.T) {
+	ip := net.IPv4(127, 0, 0, 1)
+	o, err := ParseOrigin("user1 123 456 IN IP4 127.0.0.1")
+	require.NoError(t, err)
+	require.Equal(t, Origin{Username: "user1", SessionID: 123, SessionVersion: 456, NetworkType: "IN", AddressType: "IP4", Address: "127.0.0.1"}, o)
+	_, err = ParseOrigin("user1 abc 456 IN IP4 127.0.0.1")
+	require.Error(t, err)
+
+	v := OriginVersion{}
+	first := v.Apply(GenerateForAudio(ip, ip, 2000, ModeSendrecv, NewFormats("0")))
+	sd := SessionDescription{}
+	require.NoError(t, Unmarshal(first, &sd))
+	o, err = sd.Origin()
+	require.NoError(t, err)
+
+	// Same description keeps version even with new generated origin
+	time.Sleep(time.Millisecond)
+	same := v.Apply(GenerateForAudio(ip, ip, 2000, ModeSendrecv, NewFormats("0")))
+	require.Equal(t, first, same)
+
+	changed := v.Apply(GenerateForAudio(ip, ip, 2000, ModeSendonly, NewFormats("0")))
+	sd = SessionDescription{}
+	require.NoError(t, Unmarshal(changed, &sd))
+	o2, err := sd.Origin()
+	require.NoError(t, err)
+	require.Equal(t, o.SessionID, o2.SessionID)
+	require.Equal(t, o.SessionVersion+1, o2.SessionVersion)
+
+	cur, ok := v.Current()
+	require.True(t, ok)
+	require.Equal(t, o2, cur)
+}
+
+func TestRejectedMedia(t *testing.T) {
+	ip := net.IPv4(127, 0, 0, 1)
+	offer := []byte("v=0\r\n" +
+		"o=sbc 1 1 IN IP4 127.0.0.1\r\n" +
+		"s=-\r\n" +
+		"c=IN IP4 127.0.0.1\r\n" +
+		"t=0 0\r\n" +
+		"m=video 0 RTP/AVP\r\n" +
+		"m=audio 0 RTP/SAVP 0\r\n" +
+		"m=audio 4000 RTP/AVP 0 8\r\n" +
+		"m=image 4002 udptl t38\r\n")
+
+	for _, f := range Check(offer) {
+		require.NotEqual(t, SeverityError, f.Severity, f.String())
+	}
+
+	sd := SessionDescription{}
+	require.NoError(t, Unmarshal(offer, &sd))
+	video, err := sd.MediaDescription("video")
+	require.NoError(t, err)
+	require.True(t, video.Rejected())
+	require.Empty(t, video.Formats)
+
+	audio, err := sd.MediaDescription("audio")
+	require.NoError(t, err)
+	require.False(t, audio.Rejected())
+	require.Equal(t, 4000, audio.Port)
+
+	medias, err := sd.MediaDescriptions()
+	require.NoError(t, err)
+	require.Len(t, medias, 4)
+
+	oa := OfferAnswer{}
+	require.NoError(t, oa.SetRemoteDescription(TypeOffer, offer))
+	answer, err := oa.CreateAnswer(AudioOptions{OriginIP: ip, ConnectionIP: ip, Port: 2000, Formats: NewFormats("0")})
+	require.NoError(t, err)
+
+	sd = SessionDescription{}
+	require.NoError(t, Unmarshal(answer, &sd))
+	m := sd.Values("m")
+	require.Len(t, m, 4)
+	require.Equal(t, "video 0 RTP/AVP 0", m[0])
+	require.Equal(t, "audio 0 RTP/SAVP 0", m[1])
+	require.True(t, strings.HasPrefix(m[2], "audio 2000 RTP/AVP 0"), m[2])
+	require.Equal(t, "image 0 udptl t38", m[3])
+
+	t.Run("AudioDisabled", func(t *testing.T) {
+		oa := OfferAnswer{}
+		require.NoError(t, oa.SetRemoteDescription(TypeOffer, []byte("v=0\r\no=sbc 1 1 IN IP4 127.0.0.1\r\ns=-\r\nc=IN IP4 127.0.0.1\r\nt=0 0\r\nm=audio 0 RTP/AVP 0\r\n")))
+		_, err := oa.CreateAnswer(AudioOptions{OriginIP: ip, ConnectionIP: ip, Port: 2000, Formats: NewFormats("0")})
+		require.ErrorIs(t, err, ErrMediaRejected)
+	})
+}
+
+func TestBuilder(t *testing.T) {
+	body := "v=0\r\n" +
+		"o=sbc 1 1 IN IP4 10.0.0.1\r\n" +
+		"s=-\r\n" +
+		"c=IN IP4 10.0.0.1\r\n" +
+		"t=0 0\r\n" +
+		"a=X-nat:0\r\n" +
+		"m=audio 4000 RTP/AVP 0 18\r\n" +
+		"a=rtpmap:18 G729/8000\r\n" +
+		"a=silenceSupp:off - - - -\r\n" +
+		"a=X-custom\r\n" +
+		"a=sendrecv\r\n"
+
+	b, err := ParseBuilder([]byte(body))
+	require.NoError(t, err)
+	require.Equal(t, body, string(b.Marshal()))
+
+	b.Session.SetInformation("proxied")
+	b.Session.AddBandwidth(Bandwidth{Type: BandwidthAS, Value: 64})
+	b.Session.AddAttribute("X-relay:1")
+
+	audio := b.Media("audio")
+	require.NotNil(t, audio)
+	v, ok := audio.Attribute("silenceSupp")
+	require.True(t, ok)
+	require.Equal(t, "off - - - -", v)
+	audio.SetAttribute("sendrecv", "")
+	audio.SetAttribute("silenceSupp", "on - - - -")
+	audio.RemoveAttribute("X-custom")
+	audio.AddBandwidth(Bandwidth{Type: BandwidthTIAS, Value: 64000})
+
+	image := b.AddMedia(MediaDescription{MediaType: "image", Port: 4002, Proto: ProtoUDPTL, Formats: []string{"t38"}})
+	image.AddAttribute("T38FaxVersion:0")
+	require.Nil(t, b.Media("video"))
+
+	require.Equal(t, "v=0\r\n"+
+		"o=sbc 1 1 IN IP4 10.0.0.1\r\n"+
+		"s=-\r\n"+
+		"i=proxied\r\n"+
+		"c=IN IP4 10.0.0.1\r\n"+
+		"b=AS:64\r\n"+
+		"t=0 0\r\n"+
+		"a=X-nat:0\r\n"+
+		"a=X-relay:1\r\n"+
+		"m=audio 4000 RTP/AVP 0 18\r\n"+
+		"b=TIAS:64000\r\n"+
+		"a=rtpmap:18 G729/8000\r\n"+
+		"a=silenceSupp:on - - - -\r\n"+
+		"a=sendrecv\r\n"+
+		"m=image 4002 udptl t38\r\n"+
+		"a=T38FaxVersion:0\r\n", string(b.Marshal()))
+
+	sd, err := b.SessionDescription()
+	require.NoError(t, err)
+	require.Equal(t, []string{"X-nat:0", "X-relay:1"}, sd.SessionAttributes())
+	require.Equal(t, []Bandwidth{{Type: BandwidthTIAS, Value: 64000}}, sd.Bandwidths("audio"))
+
+	_, err = ParseBuilder([]byte("v=0\r\ninvalid\r\n"))
+	require.Error(t, err)
+}
+
+func TestMediaConnectionPerLine(t *testing.T) {
+	ip4 := net.IPv4(10, 0, 0, 1)
+	ip6 := net.ParseIP("2001:db8::1")
+	relay := net.IPv4(10, 0, 0, 2)
+	body := Generate(ip4, ip4,
+		MediaDescription{MediaType: "audio", Port: 4000, Formats: []string{"0"}, Connection: ip4},
+		MediaDescription{MediaType: "audio", Port: 4002, Formats: []string{"0"}, Connection: relay},
+		MediaDescription{MediaType: "video", Port: 4004, Formats: []string{"96"}, Connection: ip6,
+			Codecs: []Codec{{PayloadType: 96, Name: "H264", ClockRate: 90000}}},
+	)
+	for _, f := range Check(body) {
+		require.NotEqual(t, SeverityError, f.Severity, f.String())
+	}
+	// Same as session connection is not repeated
+	require.Equal(t, 1, strings.Count(string(body), "c=IN IP4 10.0.0.1\r\n"))
+	require.Contains(t, string(body), "c=IN IP6 2001:db8::1\r\n")
+
+	sd := SessionDescription{}
+	require.NoError(t, Unmarshal(body, &sd))
+	medias, err := sd.MediaDescriptions()
+	require.NoError(t, err)
+	require.Len(t, medias, 3)
+	require.Nil(t, medias[0].Connection)
+	require.Equal(t, "10.0.0.2", medias[1].Connection.String())
+	require.Equal(t, "2001:db8::1", medias[2].Connection.String())
+
+	ci, err := sd.MediaConnectionInformation("video")
+	require.NoError(t, err)
+	require.Equal(t, "IP6", ci.AddressType)
+	require.True(t, ci.IP.Equal(ip6))
+
+	t.Run("IP6", func(t *testing.T) {
+		body := GenerateForAudio(ip6, ip6, 4000, ModeSendrecv, NewFormats("0"))
+		require.Contains(t, string(body), "o=user1 ")
+		require.Contains(t, string(body), "IN IP6 2001:db8::1\r\n")
+		sd := SessionDescription{}
+		require.NoError(t, Unmarshal(body, &sd))
+		ci, err := sd.ConnectionInformation()
+		require.NoError(t, err)
+		require.True(t, ci.IP.Equal(ip6))
+	})
+}
+
+func TestMarshal(t *testing.T) {
+	body := "v=0\r\n" +
+		"o=sbc 1 1 IN IP4 10.0.0.1\r\n" +
+		"s=-\r\n" +
+		"i=session\r\n" +
+		"c=IN IP4 10.0.0.1\r\n" +
+		"b=AS:128\r\n" +
+		"t=0 0\r\n" +
+		"a=X-nat:0\r\n" +
+		"m=audio 4000 RTP/AVP 0 8 101\r\n" +
+		"i=first\r\n" +
+		"b=AS:64\r\n" +
+		"a=rtpmap:101 telephone-event/8000\r\n" +
+		"a=sendrecv\r\n" +
+		"m=audio 4002 RTP/AVP 0\r\n" +
+		"c=IN IP4 10.0.0.2\r\n" +
+		"a=sendonly\r\n" +
+		"m=video 0 RTP/AVP 96\r\n"
+
+	sd := SessionDescription{}
+	require.NoError(t, Unmarshal([]byte(body), &sd))
+	require.Equal(t, body, string(sd.Marshal()))
+
+	// Tweak port and codecs of first media
+	sd["m"][0] = "audio 5000 RTP/AVP 8 101"
+	out := SessionDescription{}
+	require.NoError(t, Unmarshal(sd.Marshal(), &out))
+	md, err := out.MediaDescription("audio")
+	require.NoError(t, err)
+	require.Equal(t, 5000, md.Port)
+	require.Equal(t, []string{"8", "101"}, md.Formats)
+
+	// Edits of line type keys are written on level of lines around them
+	sd["a"] = slices.Insert(sd["a"], 3, "ptime:20")
+	sd["c"][1] = "IN IP4 10.0.0.3"
+	expected := strings.NewReplacer(
+		"m=audio 4000 RTP/AVP 0 8 101", "m=audio 5000 RTP/AVP 8 101",
+		"a=sendrecv\r\n", "a=sendrecv\r\na=ptime:20\r\n",
+		"c=IN IP4 10.0.0.2", "c=IN IP4 10.0.0.3",
+	).Replace(body)
+	require.Equal(t, expected, string(sd.Marshal()))
+	ci, err := sd.MediaConnectionInformation("audio")
+	require.NoError(t, err)
+	require.Equal(t, "10.0.0.1", ci.IP.String())
+	mds, err := sd.MediaDescriptions()
+	require.NoError(t, err)
+	require.Equal(t, "10.0.0.3", mds[1].Connection.String())
+
+	out = SessionDescription{}
+	require.NoError(t, Unmarshal(sd.Marshal(), &out))
+	out["a"] = slices.DeleteFunc(out["a"], func(a string) bool { return a == "sendonly" })
+	out["c"] = out["c"][:1]
+	edited := out.Marshal()
+	out = SessionDescription{}
+	require.NoError(t, Unmarshal(edited, &out))
+	require.Equal(t, []string{"rtpmap:101 telephone-event/8000", "sendrecv", "ptime:20"}, out.MediaAttributes("audio"))
+	require.Equal(t, []string{"X-nat:0"}, out.SessionAttributes())
+	mds, err = out.MediaDescriptions()
+	require.NoError(t, err)
+	require.Nil(t, mds[1].Connection)
+
+	// Not indexed description
+	sd = SessionDescription{
+		"v": {"0"},
+		"o": {"- 1 1 IN IP4 10.0.0.1"},
+		"s": {"-"},
+		"c": {"IN IP4 10.0.0.1"},
+		"t": {"0 0"},
+		"m": {"audio 4000 RTP/AVP 0"},
+		"a": {"sendrecv"},
+	}
+	require.Equal(t, "v=0\r\no=- 1 1 IN IP4 10.0.0.1\r\ns=-\r\nc=IN IP4 10.0.0.1\r\nt=0 0\r\na=sendrecv\r\nm=audio 4000 RTP/AVP 0\r\n", string(sd.Marshal()))
+}
+
+func TestOpus(t *testing.T) {
+	p := ParseOpusParams("maxplaybackrate=16000; stereo=0;useinbandfec=1;usedtx=1;minptime=10")
+	require.Equal(t, OpusParams{MaxPlaybackRate: 16000, UseInbandFEC: true, UseDTX: true}, p)
+	require.Equal(t, "maxplaybackrate=16000;useinbandfec=1;usedtx=1", p.String())
+
+	ip := net.IPv4(127, 0, 0, 1)
+	opus := OpusCodec(FormatNumeric(FORMAT_TYPE_OPUS), OpusParams{Stereo: true, UseInbandFEC: true})
+	body := Generate(ip, ip, MediaDescription{MediaType: "audio", Port: 4000, Formats: []string{FORMAT_TYPE_OPUS, FORMAT_TYPE_ULAW}, Codecs: []Codec{opus}})
+	require.Contains(t, string(body), "a=rtpmap:111 opus/48000/2\r\na=fmtp:111 stereo=1;useinbandfec=1\r\n")
+
+	sd := SessionDescription{}
+	require.NoError(t, Unmarshal(body, &sd))
+	codecs, err := sd.Codecs("audio")
+	require.NoError(t, err)
+	require.True(t, codecs[0].Equal(opus))
+	params, ok := codecs[0].OpusParams()
+	require.True(t, ok)
+	require.Equal(t, OpusParams{Stereo: true, UseInbandFEC: true}, params)
+
+	_, ok = codecs[1].OpusParams()
+	require.False(t, ok)
+}
+
+func TestTelephoneEvents(t *testing.T) {
+	e, err := ParseTelephoneEvents("0-15,16, 32-34")
+	require.NoError(t, err)
+	require.True(t, e.Has(16))
+	require.True(t, e.Has(33))
+	require.False(t, e.Has(17))
+	require.Equal(t, "0-16,32-34", e.String())
+	e = DTMFTelephoneEvents
+	e.Add(255)
+	require.Equal(t, "0-15,255", e.String())
+
+	for _, v := range []string{"", "a", "5-1", "0-256"} {
+		_, err := ParseTelephoneEvents(v)
+		require.Error(t, err, v)
+	}
+
+	ip := net.IPv4(127, 0, 0, 1)
+	body := GenerateForAudio(ip, ip, 4000, ModeSendrecv, NewFormats("0"))
+	sd := SessionDescription{}
+	require.NoError(t, Unmarshal(body, &sd))
+	events, ok := sd.TelephoneEvents()
+	require.True(t, ok)
+	require.Equal(t, DefaultTelephoneEvents, events)
+
+	// Without fmtp only DTMF is supported
+	body = []byte(strings.Replace(string(body), "a=fmtp:101 0-16\r\n", "", 1))
+	sd = SessionDescription{}
+	require.NoError(t, Unmarshal(body, &sd))
+	events, ok = sd.TelephoneEvents()
+	require.True(t, ok)
+	require.Equal(t, DTMFTelephoneEvents, events)
+
+	// Answer advertises configured events
+	oa := OfferAnswer{}
+	offer, err := oa.CreateOffer(AudioOptions{OriginIP: ip, ConnectionIP: ip, Port: 4000, Formats: NewFormats("0"), TelephoneEvents: NewTelephoneEvents(0, 1, 2, 16)})
+	require.NoError(t, err)
+	require.Contains(t, string(offer), "a=fmtp:101 0-2,16\r\n")
+}
+
+func TestConnectionInformationIP6(t *testing.T) {
+	ci, err := parseConnectionInformation("IN IP6 2001:db8::1")
+	require.NoError(t, err)
+	require.True(t, ci.IP.Equal(net.ParseIP("2001:db8::1")))
+
+	_, err = parseConnectionInformation("IN IP4 2001:db8::1")
+	require.Error(t, err)
+	_, err = parseConnectionInformation("IN IP6 bad")
+	require.Error(t, err)
+
+	o, err := ParseOrigin("- 1 1 IN IP6 ::1")
+	require.NoError(t, err)
+	require.True(t, o.IP().Equal(net.IPv6loopback))
+	o, err = ParseOrigin("- 1 1 IN IP4 host.example.com")
+	require.NoError(t, err)
+	require.Nil(t, o.IP())
+}
+
+func TestRTCPAttribute(t *testing.T) {
+	a, err := ParseRTCPAttribute("53020 IN IP4 126.16.64.4")
+	require.NoError(t, err)
+	require.Equal(t, 53020, a.Port)
+	require.True(t, a.IP.Equal(net.ParseIP("126.16.64.4")))
+	require.Equal(t, "53020 IN IP4 126.16.64.4", a.String())
+
+	a, err = ParseRTCPAttribute("53020")
+	require.NoError(t, err)
+	require.Nil(t, a.IP)
+	require.Equal(t, "53020", a.String())
+
+	require.Equal(t, "9 IN IP6 2001:db8::1", RTCPAttribute{Port: 9, IP: net.ParseIP("2001:db8::1")}.String())
+
+	for _, v := range []string{"", "0", "abc", "53020 IN IP4", "53020 IN IPX 1.1.1.1", "53020 IN IP4 bad"} {
+		_, err := ParseRTCPAttribute(v)
+		require.Error(t, err, v)
+	}
+
+	body := GenerateForAudio(net.IPv4(10, 0, 0, 1), net.IPv4(10, 0, 0, 1), 4000, ModeSendrecv, NewFormats("0"))
+	body = AppendRTCP(body, RTCPAttribute{Port: 5000})
+	sd := SessionDescription{}
+	require.NoError(t, Unmarshal(body, &sd))
+	a, ok := sd.RTCP("audio")
+	require.True(t, ok)
+	require.Equal(t, RTCPAttribute{Port: 5000}, a)
+	for _, f := range Check(body) {
+		require.NotEqual(t, SeverityError, f.Severity, f.String())
+	}
+
+	_, ok = SessionDescription{}.RTCP("audio")
+	require.False(t, ok)
+}
+
+func TestAnswerMirrorsDynamicPayloadTypes(t *testing.T) {
+	ip := net.IPv4(10, 0, 0, 1)
+	offer := GenerateForAudioDTMF(ip, ip, 4000, ModeSendrecv, NewFormats("96", "0"), "100")
+	offer = AppendCodecs(offer, []Codec{{PayloadType: 96, Name: "opus", ClockRate: 48000, Channels: 2}})
+
+	reg := NewCodecRegistry()
+	require.NoError(t, reg.Register(OpusCodec(111, OpusParams{UseInbandFEC: true})))
+
+	oa := OfferAnswer{}
+	require.NoError(t, oa.SetRemoteDescription(TypeOffer, offer))
+	answer, err := oa.CreateAnswer(AudioOptions{OriginIP: ip, ConnectionIP: ip, Port: 5000, Formats: NewFormats("111", "0"), Registry: reg})
+	require.NoError(t, err)
+
+	sd := SessionDescription{}
+	require.NoError(t, Unmarshal(answer, &sd))
+	md, err := sd.MediaDescription("audio")
+	require.NoError(t, err)
+	require.Equal(t, []string{"96", "0", "100"}, md.Formats)
+	require.Contains(t, string(answer), "a=rtpmap:96 opus/48000/2\r\n")
+	require.Contains(t, string(answer), "a=fmtp:96 useinbandfec=1\r\n")
+	require.NotContains(t, string(answer), ":111")
+
+	// Without registry dynamic formats match only by number
+	oa = OfferAnswer{}
+	require.NoError(t, oa.SetRemoteDescription(TypeOffer, offer))
+	answer, err = oa.CreateAnswer(AudioOptions{OriginIP: ip, ConnectionIP: ip, Port: 5000, Formats: NewFormats("111", "0")})
+	require.NoError(t, err)
+	require.NotContains(t, string(answer), "opus")
+}
+
+func TestSessionLevelPrecedence(t *testing.T) {
+	key := "inline:WVNfX19zZW1jdGwgKCkgewkyMjA7fQp9CnVubGVz"
+	body := strings.Join([]string{
+		"v=0",
+		"o=- 1 1 IN IP4 10.0.0.1",
+		"s=-",
+		"c=IN IP4 10.0.0.1",
+		"t=0 0",
+		"a=sendonly",
+		"a=crypto:1 AES_CM_128_HMAC_SHA1_80 " + key,
+		"m=audio 4000 RTP/SAVP 0",
+		"c=IN IP4 10.0.0.2",
+		"a=recvonly",
+		"a=crypto:2 AES_CM_128_HMAC_SHA1_32 " + key,
+		"m=video 4002 RTP/SAVP 96",
+		"",
+	}, "\r\n")
+	sd := SessionDescription{}
+	require.NoError(t, Unmarshal([]byte(body), &sd))
+
+	l, err := sd.SessionLevel()
+	require.NoError(t, err)
+	require.Equal(t, ModeSendonly, l.Mode)
+	require.True(t, l.Connection.Equal(net.IPv4(10, 0, 0, 1)))
+	require.Len(t, l.Cryptos, 1)
+	require.Equal(t, 1, l.Cryptos[0].Tag)
+
+	// Media level wins
+	md, err := sd.MediaDescription("audio")
+	require.NoError(t, err)
+	require.Equal(t, ModeRecvonly, md.Mode)
+	require.True(t, md.Connection.Equal(net.IPv4(10, 0, 0, 2)))
+	require.Equal(t, ModeRecvonly, sd.MediaMode("audio"))
+	ci, err := sd.MediaConnectionInformation("audio")
+	require.NoError(t, err)
+	require.True(t, ci.IP.Equal(net.IPv4(10, 0, 0, 2)))
+	cryptos := sd.Cryptos("audio")
+	require.Len(t, cryptos, 1)
+	require.Equal(t, 2, cryptos[0].Tag)
+
+	// Session level applies to media without own values
+	md, err = sd.MediaDescription("video")
+	require.NoError(t, err)
+	require.Empty(t, md.Mode)
+	require.Nil(t, md.Connection)
+	require.Equal(t, ModeSendonly, sd.MediaMode("video"))
+	ci, err = sd.MediaConnectionInformation("video")
+	require.NoError(t, err)
+	require.True(t, ci.IP.Equal(net.IPv4(10, 0, 0, 1)))
+	cryptos = sd.Cryptos("video")
+	require.Len(t, cryptos, 1)
+	require.Equal(t, 1, cryptos[0].Tag)
+
+	findings := Check([]byte(body))
+	require.Contains(t, findings, Finding{Severity: SeverityWarning, Line: 7, Message: "crypto on session level is not standard, it is used only for media without crypto"})
+}
+
+func TestExtMap(t *testing.T) {
+	e, err := ParseExtMap("1 urn:ietf:params:rtp-hdrext:ssrc-audio-level vad=on")
+	require.NoError(t, err)
+	require.Equal(t, ExtMap{ID: 1, URI: ExtMapAudioLevel, Attributes: "vad=on"}, e)
+	require.Equal(t, "1 urn:ietf:params:rtp-hdrext:ssrc-audio-level vad=on", e.String())
+
+	e, err = ParseExtMap("2/sendonly urn:example")
+	require.NoError(t, err)
+	require.Equal(t, ExtMap{ID: 2, Direction: "sendonly", URI: "urn:example"}, e)
+	require.Equal(t, "2/sendonly urn:example", e.String())
+
+	for _, v := range []string{"", "1", "0 urn:example", "15 urn:example", "x urn:example"} {
+		_, err := ParseExtMap(v)
+		require.Error(t, err, v)
+	}
+
+	body := GenerateForAudio(net.IPv4(10, 0, 0, 1), net.IPv4(10, 0, 0, 1), 4000, ModeSendrecv, NewFormats("0"))
+	body = AppendExtMap(body, ExtMap{ID: 3, URI: ExtMapAudioLevel})
+	sd := SessionDescription{}
+	require.NoError(t, Unmarshal(body, &sd))
+	e, ok := sd.ExtMap("audio", ExtMapAudioLevel)
+	require.True(t, ok)
+	require.Equal(t, uint8(3), e.ID)
+	_, ok = sd.ExtMap("audio", "urn:example")
+	require.False(t, ok)
+}
+
+func TestRTCPFeedback(t *testing.T) {
+	body := GenerateForAudio(net.IPv4(10, 0, 0, 1), net.IPv4(10, 0, 0, 1), 4000, ModeSendrecv, NewFormats("0", "8"))
+	body = AppendRTCPFeedback(body, "8", RTCPFeedbackNACK)
+	body = AppendRTCPFeedback(body, "*", "nack pli")
+	sd := SessionDescription{}
+	require.NoError(t, Unmarshal(body, &sd))
+	require.True(t, sd.RTCPFeedback("audio", "8", RTCPFeedbackNACK))
+	require.False(t, sd.RTCPFeedback("audio", "0", RTCPFeedbackNACK))
+	require.True(t, sd.RTCPFeedback("audio", "0", "nack pli"))
+
+	body = AppendRTCPFeedback(body, "*", RTCPFeedbackNACK)
+	sd = SessionDescription{}
+	require.NoError(t, Unmarshal(body, &sd))
+	require.True(t, sd.RTCPFeedback("audio", "0", RTCPFeedbackNACK))
+}
@@ -0,0 +1,59 @@
+// sdpcheck validates SDP offers/answers and prints findings.
+// SDP is read from files given as arguments or from stdin
+//
+//	sdpcheck offer.sdp answer.sdp
+//	cat offer.sdp | sdpcheck
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/emiago/sipgox/sdp"
+)
+
+func main() {
+	strict := flag.Bool("strict", false, "Fail on warnings")
+	quiet := flag.Bool("q", false, "Do not print info findings")
+	flag.Parse()
+
+	files := flag.Args()
+	if len(files) == 0 {
+		files = []string{"-"}
+	}
+
+	failed := false
+	for _, f := range files {
+		body, err := readSDP(f)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+
+		for _, finding := range sdp.Check(body) {
+			if *quiet && finding.Severity == sdp.SeverityInfo {
+				continue
+			}
+			if finding.Severity == sdp.SeverityError || (*strict && finding.Severity == sdp.SeverityWarning) {
+				failed = true
+			}
+			if len(files) > 1 {
+				fmt.Printf("%s: ", f)
+			}
+			fmt.Println(finding)
+		}
+	}
+
+	if failed {
+		os.Exit(1)
+	}
+}
+
+func readSDP(file string) ([]byte, error) {
+	if file == "-" {
+		return io.ReadAll(os.Stdin)
+	}
+	return os.ReadFile(file)
+}
@@ -0,0 +1,134 @@
+package sdp
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// TelephoneEvents is set of RFC 4733 events listed in telephone-event fmtp, ex. "0-15,16"
+// https://datatracker.ietf.org/doc/html/rfc4733#section-2.5.1.3
+type TelephoneEvents [4]uint64
+
+var (
+	// DTMFTelephoneEvents are DTMF events 0-9, *, # and A-D
+	DTMFTelephoneEvents = NewTelephoneEventRange(0, 15)
+	// DefaultTelephoneEvents are DTMF events and flash (16), which are advertised if none are set
+	DefaultTelephoneEvents = NewTelephoneEventRange(0, 16)
+)
+
+// NewTelephoneEvents creates set of events
+func NewTelephoneEvents(events ...uint8) TelephoneEvents {
+	e := TelephoneEvents{}
+	e.Add(events...)
+	return e
+}
+
+// NewTelephoneEventRange creates set of events from first to last inclusive
+func NewTelephoneEventRange(first uint8, last uint8) TelephoneEvents {
+	e := TelephoneEvents{}
+	e.AddRange(first, last)
+	return e
+}
+
+// ParseTelephoneEvents parses telephone-event fmtp, ex. "0-15,16,32-49"
+func ParseTelephoneEvents(fmtp string) (TelephoneEvents, error) {
+	e := TelephoneEvents{}
+	for _, r := range strings.Split(fmtp, ",") {
+		r = strings.TrimSpace(r)
+		first, last, isRange := strings.Cut(r, "-")
+		f, err := strconv.ParseUint(first, 10, 8)
+		if err != nil {
+			return e, fmt.Errorf("invalid telephone event %q", r)
+		}
+		l := f
+		if isRange {
+			if l, err = strconv.ParseUint(last, 10, 8); err != nil || l < f {
+				return e, fmt.Errorf("invalid telephone event range %q", r)
+			}
+		}
+		e.AddRange(uint8(f), uint8(l))
+	}
+	return e, nil
+}
+
+// Add adds events to set
+func (e *TelephoneEvents) Add(events ...uint8) {
+	for _, ev := range events {
+		e[ev/64] |= 1 << (ev % 64)
+	}
+}
+
+// AddRange adds events from first to last inclusive
+func (e *TelephoneEvents) AddRange(first uint8, last uint8) {
+	for ev := int(first); ev <= int(last); ev++ {
+		e.Add(uint8(ev))
+	}
+}
+
+// Has returns true if event is in set
+func (e TelephoneEvents) Has(event uint8) bool {
+	return e[event/64]&(1<<(event%64)) != 0
+}
+
+// IsZero returns true if set has no events
+func (e TelephoneEvents) IsZero() bool {
+	return e == TelephoneEvents{}
+}
+
+// String returns fmtp of events with consecutive events as ranges
+func (e TelephoneEvents) String() string {
+	var ranges []string
+	for ev := 0; ev < 256; ev++ {
+		if !e.Has(uint8(ev)) {
+			continue
+		}
+		last := ev
+		for last < 255 && e.Has(uint8(last+1)) {
+			last++
+		}
+		if last == ev {
+			ranges = append(ranges, strconv.Itoa(ev))
+		} else {
+			ranges = append(ranges, strconv.Itoa(ev)+"-"+strconv.Itoa(last))
+		}
+		ev = last
+	}
+	return strings.Join(ranges, ",")
+}
+
+// TelephoneEvents returns events of audio telephone-event. If there is no fmtp,
+// DTMF events are returned as RFC 4733 defines. False is returned if telephone-event is not present
+func (sd SessionDescription) TelephoneEvents() (TelephoneEvents, bool) {
+	pt, ok := sd.TelephoneEvent()
+	if !ok {
+		return TelephoneEvents{}, false
+	}
+	for _, a := range sd.attributes("audio") {
+		v, found := strings.CutPrefix(a, "fmtp:"+pt+" ")
+		if !found {
+			continue
+		}
+		events, err := ParseTelephoneEvents(v)
+		if err != nil {
+			return TelephoneEvents{}, false
+		}
+		return events, true
+	}
+	return DTMFTelephoneEvents, true
+}
+
+// SetTelephoneEvents replaces events in telephone-event fmtp of payload type in SDP body
+func SetTelephoneEvents(body []byte, pt string, events TelephoneEvents) []byte {
+	prefix := "a=fmtp:" + pt + " "
+	lines := strings.SplitAfter(string(body), "\n")
+	for i, line := range lines {
+		if !strings.HasPrefix(line, prefix) {
+			continue
+		}
+		ending := line[len(strings.TrimRight(line, "\r\n")):]
+		lines[i] = prefix + events.String() + ending
+		break
+	}
+	return []byte(strings.Join(lines, ""))
+}
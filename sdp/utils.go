@@ -28,10 +28,20 @@ const (
 	ModeRecvonly Mode = "recvonly"
 	ModeSendrecv Mode = "sendrecv"
 	ModeSendonly Mode = "sendonly"
+	ModeInactive Mode = "inactive"
 )
 
+// DefaultDTMFPayloadType is dynamic payload type of telephone-event used by GenerateForAudio
+const DefaultDTMFPayloadType = "101"
+
 // GenerateForAudio is minimal AUDIO SDP setup
 func GenerateForAudio(originIP net.IP, connectionIP net.IP, rtpPort int, mode Mode, fmts Formats) []byte {
+	return GenerateForAudioDTMF(originIP, connectionIP, rtpPort, mode, fmts, DefaultDTMFPayloadType)
+}
+
+// GenerateForAudioDTMF is GenerateForAudio with telephone-event on dtmfPT payload type.
+// Empty dtmfPT does not advertise telephone-event
+func GenerateForAudioDTMF(originIP net.IP, connectionIP net.IP, rtpPort int, mode Mode, fmts Formats, dtmfPT string) []byte {
 	ntpTime := GetCurrentNTPTimestamp()
 
 	formatsMap := []string{}
@@ -39,28 +49,36 @@ func GenerateForAudio(originIP net.IP, connectionIP net.IP, rtpPort int, mode Mo
 		switch f {
 		case "0":
 			formatsMap = append(formatsMap, "a=rtpmap:0 PCMU/8000")
+		case "3":
+			formatsMap = append(formatsMap, "a=rtpmap:3 GSM/8000")
 		case "8":
 			formatsMap = append(formatsMap, "a=rtpmap:8 PCMA/8000")
+		case "9":
+			formatsMap = append(formatsMap, "a=rtpmap:9 G722/8000")
+		case "18":
+			formatsMap = append(formatsMap, "a=rtpmap:18 G729/8000")
 
 			// TODO add more here
 		}
 	}
+	mfmts := append([]string{}, fmts...)
+	if dtmfPT != "" {
+		mfmts = append(mfmts, dtmfPT)
+	}
+
 	// Support only ulaw and alaw
 	s := []string{
 		"v=0",
-		fmt.Sprintf("o=user1 %d %d IN IP4 %s", ntpTime, ntpTime, originIP),
+		fmt.Sprintf("o=user1 %d %d IN %s %s", ntpTime, ntpTime, addrType(originIP), originIP),
 		"s=Sip Go Media",
 		// "b=AS:84",
-		fmt.Sprintf("c=IN IP4 %s", connectionIP),
+		fmt.Sprintf("c=IN %s %s", addrType(connectionIP), connectionIP),
 		"t=0 0",
-		fmt.Sprintf("m=audio %d RTP/AVP %s", rtpPort, strings.Join(fmts, " ")+" 101"),
+		fmt.Sprintf("m=audio %d RTP/AVP %s", rtpPort, strings.Join(mfmts, " ")),
 		"a=" + string(mode),
 		// "a=ssrc:111222 cname:user@example.com",
 		// "a=rtpmap:0 PCMU/8000",
 		// "a=rtpmap:8 PCMA/8000",
-		// THIS is FOR DTMF
-		"a=rtpmap:101 telephone-event/8000",
-		"a=fmtp:101 0-16",
 		// "",
 		// "a=rtpmap:120 telephone-event/16000",
 		// "a=fmtp:120 0-16",
@@ -71,6 +89,14 @@ func GenerateForAudio(originIP net.IP, connectionIP net.IP, rtpPort int, mode Mo
 		// fmt.Sprintf("a=rtcp:%d IN IP4 %s", rtpPort+1, connectionIP),
 	}
 
+	// THIS is FOR DTMF
+	if dtmfPT != "" {
+		s = append(s,
+			"a=rtpmap:"+dtmfPT+" telephone-event/8000",
+			"a=fmtp:"+dtmfPT+" "+DefaultTelephoneEvents.String(),
+		)
+	}
+
 	s = append(s, formatsMap...)
 
 	// s := []string{
@@ -99,3 +125,150 @@ func GenerateForAudio(originIP net.IP, connectionIP net.IP, rtpPort int, mode Mo
 	res := strings.Join(s, "\r\n")
 	return []byte(res)
 }
+
+// staticRTPMaps are static payload types (RFC 3551) for which rtpmap is optional
+var staticRTPMaps = map[string]string{
+	"0":  "PCMU/8000",
+	"3":  "GSM/8000",
+	"4":  "G723/8000",
+	"8":  "PCMA/8000",
+	"9":  "G722/8000",
+	"18": "G729/8000",
+}
+
+// Compact removes optional lines from SDP to reduce its size.
+// Informational lines (i,u,e,p) and rtpmap of static payload types are removed
+func Compact(body []byte) []byte {
+	lines := strings.Split(strings.ReplaceAll(string(body), "\r\n", "\n"), "\n")
+	res := make([]string, 0, len(lines))
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		switch line[0] {
+		case 'i', 'u', 'e', 'p':
+			continue
+		}
+
+		if rtpmap, found := strings.CutPrefix(line, "a=rtpmap:"); found {
+			pt, enc, _ := strings.Cut(rtpmap, " ")
+			if static, exists := staticRTPMaps[pt]; exists && strings.EqualFold(static, enc) {
+				continue
+			}
+		}
+		res = append(res, line)
+	}
+	return []byte(strings.Join(res, "\r\n") + "\r\n")
+}
+
+// TrimOptions limits SDP size and advertised codecs
+type TrimOptions struct {
+	// MaxFormats limits number of codecs per media. telephone-event is not counted. Zero is no limit
+	MaxFormats int
+	// Compact removes nonessential lines. See Compact
+	Compact bool
+}
+
+// Trim removes codecs over limit together with their rtpmap and fmtp attributes
+func Trim(body []byte, opts TrimOptions) []byte {
+	if opts.MaxFormats > 0 {
+		body = trimFormats(body, opts.MaxFormats)
+	}
+	if opts.Compact {
+		body = Compact(body)
+	}
+	return body
+}
+
+func trimFormats(body []byte, max int) []byte {
+	lines := strings.Split(strings.ReplaceAll(string(body), "\r\n", "\n"), "\n")
+
+	// Find telephone events as they do not count in limit
+	events := map[string]bool{}
+	for _, line := range lines {
+		if rtpmap, found := strings.CutPrefix(line, "a=rtpmap:"); found {
+			pt, enc, _ := strings.Cut(rtpmap, " ")
+			if strings.HasPrefix(strings.ToLower(enc), "telephone-event/") {
+				events[pt] = true
+			}
+		}
+	}
+
+	res := make([]string, 0, len(lines))
+	removed := map[string]bool{}
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		if strings.HasPrefix(line, "m=") {
+			// New media section
+			removed = map[string]bool{}
+			fields := strings.Fields(line)
+			if len(fields) > 3 {
+				keep := fields[:3]
+				count := 0
+				for _, f := range fields[3:] {
+					if !events[f] {
+						if count >= max {
+							removed[f] = true
+							continue
+						}
+						count++
+					}
+					keep = append(keep, f)
+				}
+				line = strings.Join(keep, " ")
+			}
+		}
+
+		if attr, found := strings.CutPrefix(line, "a="); found && len(removed) > 0 {
+			name, value, _ := strings.Cut(attr, ":")
+			switch name {
+			case "rtpmap", "fmtp", "rtcp-fb":
+				pt, _, _ := strings.Cut(value, " ")
+				if removed[pt] {
+					continue
+				}
+			}
+		}
+		res = append(res, line)
+	}
+	return []byte(strings.Join(res, "\r\n") + "\r\n")
+}
+
+// SetMediaMode sets direction attribute of media section in SDP body.
+// Existing direction of that media is replaced, otherwise it is added after m= line.
+// Session level direction is kept, as media level one overrides it
+func SetMediaMode(body []byte, mediaType string, mode Mode) []byte {
+	lines := strings.Split(strings.ReplaceAll(string(body), "\r\n", "\n"), "\n")
+	res := make([]string, 0, len(lines)+1)
+	inMedia := false
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		if m, found := strings.CutPrefix(line, "m="); found {
+			typ, _, _ := strings.Cut(m, " ")
+			inMedia = typ == mediaType
+			res = append(res, line)
+			if inMedia {
+				res = append(res, "a="+string(mode))
+			}
+			continue
+		}
+
+		if attr, found := strings.CutPrefix(line, "a="); found && inMedia {
+			if _, ok := findMode([]string{attr}); ok {
+				continue
+			}
+		}
+		res = append(res, line)
+	}
+	return []byte(strings.Join(res, "\r\n") + "\r\n")
+}
@@ -0,0 +1,162 @@
+package sipgox
+
+import (
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// SpeechDetector detects speech in decoded audio by energy. Speech starts when frames are above
+// threshold for StartDelay and ends after Hangover of silence, so short noises and pauses
+// between words do not emit events. Time is measured in samples, not wall clock, except
+// when frames stop arriving (DTX, comfort noise or hold) and speech ends on timer.
+// Useful for barge-in of IVR prompts and talk time metrics. See AudioReader Speech
+type SpeechDetector struct {
+	// Threshold is RMS level in dBFS above which frame is speech. Zero is -40 dBFS
+	Threshold float64
+	// StartDelay is speech needed before speech starts. Zero is 60ms
+	StartDelay time.Duration
+	// Hangover is silence needed before speech ends. Zero is 500ms
+	Hangover time.Duration
+
+	// OnSpeechStart is called when speech starts
+	OnSpeechStart func()
+	// OnSpeechEnd is called when speech ends with its duration, without hangover.
+	// It is called from timer goroutine when frames stopped arriving
+	OnSpeechEnd func(d time.Duration)
+
+	mu sync.Mutex
+	// pos is stream time of processed audio
+	pos time.Duration
+	// runStart is start of current run of speech frames
+	runStart time.Duration
+	inRun    bool
+	speaking atomic.Bool
+	// lastSpeech is end of last speech frame
+	lastSpeech time.Duration
+	talk       atomic.Int64
+	// timer ends speech at deadline when frames stop, ex. on DTX or hold
+	timer    *time.Timer
+	deadline time.Time
+}
+
+// Process detects speech in interleaved samples. It should be called with every received frame.
+// When frames stop arriving during speech, speech ends after Hangover of wall clock
+func (d *SpeechDetector) Process(pcm []int16, sampleRate int, channels int) {
+	channels = max(channels, 1)
+	if len(pcm) == 0 || sampleRate <= 0 {
+		return
+	}
+	threshold, startDelay, hangover := d.Threshold, d.StartDelay, d.hangover()
+	if threshold == 0 {
+		threshold = -40
+	}
+	if startDelay == 0 {
+		startDelay = 60 * time.Millisecond
+	}
+
+	var sum float64
+	for _, s := range pcm {
+		sum += float64(s) * float64(s)
+	}
+	rms := math.Sqrt(sum / float64(len(pcm)))
+	speech := rms > 0 && 20*math.Log10(rms/math.MaxInt16) > threshold
+
+	d.mu.Lock()
+	start := d.pos
+	d.pos += time.Duration(len(pcm)/channels) * time.Second / time.Duration(sampleRate)
+
+	if !speech {
+		d.inRun = false
+		if d.speaking.Load() && d.pos-d.lastSpeech >= hangover {
+			dur := d.endSpeech()
+			d.mu.Unlock()
+			if d.OnSpeechEnd != nil {
+				d.OnSpeechEnd(dur)
+			}
+			return
+		}
+		if d.speaking.Load() {
+			d.armTimer(hangover - (d.pos - d.lastSpeech))
+		}
+		d.mu.Unlock()
+		return
+	}
+
+	if !d.inRun && !d.speaking.Load() {
+		d.runStart = start
+	}
+	d.inRun = true
+	if d.speaking.Load() {
+		d.talk.Add(int64(d.pos - d.lastSpeech))
+	}
+	d.lastSpeech = d.pos
+	started := false
+	if !d.speaking.Load() && d.pos-d.runStart >= startDelay {
+		d.speaking.Store(true)
+		d.talk.Add(int64(d.pos - d.runStart))
+		started = true
+	}
+	if d.speaking.Load() {
+		d.armTimer(hangover)
+	}
+	d.mu.Unlock()
+	if started && d.OnSpeechStart != nil {
+		d.OnSpeechStart()
+	}
+}
+
+func (d *SpeechDetector) hangover() time.Duration {
+	if d.Hangover == 0 {
+		return 500 * time.Millisecond
+	}
+	return d.Hangover
+}
+
+// endSpeech stops speech and returns its duration. Caller must hold lock
+func (d *SpeechDetector) endSpeech() time.Duration {
+	d.speaking.Store(false)
+	d.deadline = time.Time{}
+	if d.timer != nil {
+		d.timer.Stop()
+	}
+	return d.lastSpeech - d.runStart
+}
+
+// armTimer moves deadline of ending speech without frames. Caller must hold lock
+func (d *SpeechDetector) armTimer(after time.Duration) {
+	d.deadline = time.Now().Add(after)
+	if d.timer == nil {
+		d.timer = time.AfterFunc(after, d.expire)
+		return
+	}
+	d.timer.Reset(after)
+}
+
+// expire ends speech when no frame was processed until deadline
+func (d *SpeechDetector) expire() {
+	d.mu.Lock()
+	if !d.speaking.Load() || d.deadline.IsZero() || time.Now().Before(d.deadline) {
+		// Stale timer, deadline was moved by later frame
+		d.mu.Unlock()
+		return
+	}
+	d.inRun = false
+	dur := d.endSpeech()
+	d.mu.Unlock()
+	if d.OnSpeechEnd != nil {
+		d.OnSpeechEnd(dur)
+	}
+}
+
+// Speaking reports whether speech is in progress
+func (d *SpeechDetector) Speaking() bool {
+	return d.speaking.Load()
+}
+
+// TalkTime is total duration of detected speech, including pauses shorter than hangover.
+// It is safe to call while audio is processed
+func (d *SpeechDetector) TalkTime() time.Duration {
+	return time.Duration(d.talk.Load())
+}
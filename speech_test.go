@@ -0,0 +1,70 @@
+package sipgox
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSpeechDetector(t *testing.T) {
+	events := []string{}
+	var durations []time.Duration
+	d := &SpeechDetector{
+		OnSpeechStart: func() { events = append(events, "start") },
+		OnSpeechEnd: func(dur time.Duration) {
+			events = append(events, "end")
+			durations = append(durations, dur)
+		},
+	}
+	// 20ms frames at 8000
+	speech := gainTone(8000)
+	silence := make([]int16, 160)
+	process := func(frame []int16, n int) {
+		for i := 0; i < n; i++ {
+			d.Process(frame, 8000, 1)
+		}
+	}
+
+	// Click shorter than start delay is ignored
+	process(speech, 2)
+	process(silence, 30)
+	require.Empty(t, events)
+
+	process(speech, 3)
+	require.Equal(t, []string{"start"}, events)
+	require.True(t, d.Speaking())
+
+	// Pause shorter than hangover does not end speech
+	process(silence, 10)
+	process(speech, 7)
+	process(silence, 24)
+	require.Equal(t, []string{"start"}, events)
+	process(silence, 1)
+	require.Equal(t, []string{"start", "end"}, events)
+	require.Equal(t, []time.Duration{400 * time.Millisecond}, durations)
+	require.Equal(t, 400*time.Millisecond, d.TalkTime())
+	require.False(t, d.Speaking())
+}
+
+func TestSpeechDetectorNoFrames(t *testing.T) {
+	ended := make(chan time.Duration, 1)
+	d := &SpeechDetector{
+		Hangover:    50 * time.Millisecond,
+		OnSpeechEnd: func(dur time.Duration) { ended <- dur },
+	}
+	speech := gainTone(8000)
+	for i := 0; i < 5; i++ {
+		d.Process(speech, 8000, 1)
+	}
+	require.True(t, d.Speaking())
+
+	// Frames stop, ex. remote sends only comfort noise
+	select {
+	case dur := <-ended:
+		require.Equal(t, 100*time.Millisecond, dur)
+	case <-time.After(time.Second):
+		t.Fatal("speech did not end without frames")
+	}
+	require.False(t, d.Speaking())
+}
@@ -0,0 +1,216 @@
+package sipgox
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	stunMagicCookie = 0x2112A442
+
+	stunBindingRequest  = 0x0001
+	stunBindingResponse = 0x0101
+
+	stunAttrMappedAddress    = 0x0001
+	stunAttrXORMappedAddress = 0x0020
+)
+
+var ErrSTUNTimeout = errors.New("stun: no binding response")
+
+// STUNClient discovers server reflexive (public) address of media sockets (RFC 5389)
+type STUNClient struct {
+	// Servers are STUN servers host:port tried in order
+	Servers []string
+	// Timeout per server. Default 2s
+	Timeout time.Duration
+	// CacheTTL keeps discovered address of local address. Zero disables caching
+	CacheTTL time.Duration
+
+	mu    sync.Mutex
+	cache map[string]stunCacheEntry
+}
+
+type stunCacheEntry struct {
+	addr    *net.UDPAddr
+	expires time.Time
+}
+
+// Discover sends binding request from conn and returns mapped address.
+// Conn must not be read by others during discovery
+func (c *STUNClient) Discover(conn net.PacketConn) (*net.UDPAddr, error) {
+	key := conn.LocalAddr().String()
+	if c.CacheTTL > 0 {
+		c.mu.Lock()
+		e, exists := c.cache[key]
+		c.mu.Unlock()
+		if exists && time.Now().Before(e.expires) {
+			return e.addr, nil
+		}
+	}
+
+	timeout := c.Timeout
+	if timeout == 0 {
+		timeout = 2 * time.Second
+	}
+
+	errs := []error{}
+	for _, server := range c.Servers {
+		raddr, err := net.ResolveUDPAddr("udp", server)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		addr, err := STUNBinding(conn, raddr, timeout)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", server, err))
+			continue
+		}
+
+		if c.CacheTTL > 0 {
+			c.mu.Lock()
+			if c.cache == nil {
+				c.cache = make(map[string]stunCacheEntry)
+			}
+			c.cache[key] = stunCacheEntry{addr: addr, expires: time.Now().Add(c.CacheTTL)}
+			c.mu.Unlock()
+		}
+		return addr, nil
+	}
+
+	if len(errs) == 0 {
+		return nil, fmt.Errorf("stun: no servers configured")
+	}
+	return nil, errors.Join(errs...)
+}
+
+// STUNBinding does single binding transaction with retransmissions until timeout
+func STUNBinding(conn net.PacketConn, server net.Addr, timeout time.Duration) (*net.UDPAddr, error) {
+	req := make([]byte, 20)
+	binary.BigEndian.PutUint16(req[0:], stunBindingRequest)
+	binary.BigEndian.PutUint32(req[4:], stunMagicCookie)
+	if _, err := rand.Read(req[8:20]); err != nil {
+		return nil, err
+	}
+	txID := req[8:20]
+
+	deadline := time.Now().Add(timeout)
+	defer conn.SetReadDeadline(time.Time{})
+
+	buf := make([]byte, 1500)
+	// RTO starts with 500ms and doubles (RFC 5389 7.2.1)
+	rto := 500 * time.Millisecond
+	for time.Now().Before(deadline) {
+		if _, err := conn.WriteTo(req, server); err != nil {
+			return nil, err
+		}
+
+		wait := time.Now().Add(rto)
+		if wait.After(deadline) {
+			wait = deadline
+		}
+		conn.SetReadDeadline(wait)
+		rto *= 2
+
+		for {
+			n, _, err := conn.ReadFrom(buf)
+			if err != nil {
+				var netErr net.Error
+				if errors.As(err, &netErr) && netErr.Timeout() {
+					break
+				}
+				return nil, err
+			}
+
+			addr, err := stunParseBindingResponse(buf[:n], txID)
+			if err != nil {
+				// Not our response, could be early RTP
+				continue
+			}
+			return addr, nil
+		}
+	}
+	return nil, ErrSTUNTimeout
+}
+
+func stunParseBindingResponse(msg []byte, txID []byte) (*net.UDPAddr, error) {
+	if len(msg) < 20 || binary.BigEndian.Uint32(msg[4:]) != stunMagicCookie {
+		return nil, fmt.Errorf("stun: not stun message")
+	}
+	if binary.BigEndian.Uint16(msg[0:]) != stunBindingResponse {
+		return nil, fmt.Errorf("stun: not binding success response")
+	}
+	if string(msg[8:20]) != string(txID) {
+		return nil, fmt.Errorf("stun: transaction id mismatch")
+	}
+
+	length := int(binary.BigEndian.Uint16(msg[2:]))
+	if 20+length > len(msg) {
+		return nil, fmt.Errorf("stun: message truncated")
+	}
+	attrs := msg[20 : 20+length]
+
+	var mapped *net.UDPAddr
+	for len(attrs) >= 4 {
+		typ := binary.BigEndian.Uint16(attrs[0:])
+		l := int(binary.BigEndian.Uint16(attrs[2:]))
+		if 4+l > len(attrs) {
+			return nil, fmt.Errorf("stun: attribute truncated")
+		}
+		val := attrs[4 : 4+l]
+
+		switch typ {
+		case stunAttrXORMappedAddress:
+			return stunParseAddress(val, msg[4:20])
+		case stunAttrMappedAddress:
+			mapped, _ = stunParseAddress(val, nil)
+		}
+
+		// Attributes are padded to 4 bytes
+		l = (l + 3) &^ 3
+		if 4+l > len(attrs) {
+			break
+		}
+		attrs = attrs[4+l:]
+	}
+
+	if mapped == nil {
+		return nil, fmt.Errorf("stun: no mapped address")
+	}
+	return mapped, nil
+}
+
+// stunParseAddress parses (XOR-)MAPPED-ADDRESS. xor is cookie+transaction id for XOR variant
+func stunParseAddress(val []byte, xor []byte) (*net.UDPAddr, error) {
+	if len(val) < 4 {
+		return nil, fmt.Errorf("stun: bad address")
+	}
+
+	var ip net.IP
+	switch val[1] {
+	case 0x01:
+		ip = make(net.IP, 4)
+	case 0x02:
+		ip = make(net.IP, 16)
+	default:
+		return nil, fmt.Errorf("stun: bad address family")
+	}
+	if len(val) < 4+len(ip) {
+		return nil, fmt.Errorf("stun: bad address")
+	}
+	copy(ip, val[4:])
+	port := binary.BigEndian.Uint16(val[2:])
+
+	if xor != nil {
+		port ^= uint16(stunMagicCookie >> 16)
+		for i := range ip {
+			ip[i] ^= xor[i]
+		}
+	}
+	return &net.UDPAddr{IP: ip, Port: int(port)}, nil
+}
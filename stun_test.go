@@ -0,0 +1,81 @@
+package sipgox
+
+import (
+	"encoding/binary"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// stunTestServer responds with XOR mapped address of sender
+func stunTestServer(t *testing.T, requests *atomic.Int32) *net.UDPConn {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, raddr, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			if n < 20 || binary.BigEndian.Uint16(buf) != stunBindingRequest {
+				continue
+			}
+			requests.Add(1)
+
+			res := make([]byte, 20, 32)
+			binary.BigEndian.PutUint16(res[0:], stunBindingResponse)
+			binary.BigEndian.PutUint16(res[2:], 12)
+			copy(res[4:20], buf[4:20])
+
+			ip := raddr.IP.To4()
+			attr := []byte{0, 0x20, 0, 8, 0, 0x01, 0, 0, 0, 0, 0, 0}
+			binary.BigEndian.PutUint16(attr[6:], uint16(raddr.Port)^uint16(stunMagicCookie>>16))
+			for i := 0; i < 4; i++ {
+				attr[8+i] = ip[i] ^ res[4+i]
+			}
+			conn.WriteToUDP(append(res, attr...), raddr)
+		}
+	}()
+	return conn
+}
+
+func TestSTUNDiscover(t *testing.T) {
+	requests := atomic.Int32{}
+	server := stunTestServer(t, &requests)
+	defer server.Close()
+
+	sess, err := NewMediaSession(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, nil)
+	require.NoError(t, err)
+	defer sess.Close()
+
+	c := &STUNClient{
+		Servers:  []string{server.LocalAddr().String()},
+		Timeout:  time.Second,
+		CacheTTL: time.Minute,
+	}
+	require.NoError(t, sess.DiscoverAdvertisedAddr(c))
+	require.Equal(t, sess.Laddr.String(), sess.AdvertisedAddr.String())
+
+	// Cached
+	_, err = c.Discover(sess.rtpConn)
+	require.NoError(t, err)
+	require.Equal(t, int32(1), requests.Load())
+}
+
+func TestSTUNTimeout(t *testing.T) {
+	silent, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	defer silent.Close()
+
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	defer conn.Close()
+
+	_, err = STUNBinding(conn, silent.LocalAddr(), 100*time.Millisecond)
+	require.ErrorIs(t, err, ErrSTUNTimeout)
+}
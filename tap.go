@@ -0,0 +1,129 @@
+package sipgox
+
+import (
+	"github.com/emiago/sipgo/sip"
+	"github.com/rs/zerolog"
+)
+
+type TapDirection int
+
+const (
+	// TapDirectionIn is traffic received by phone
+	TapDirectionIn TapDirection = iota
+	// TapDirectionOut is traffic sent by phone
+	TapDirectionOut
+)
+
+func (d TapDirection) String() string {
+	if d == TapDirectionOut {
+		return "out"
+	}
+	return "in"
+}
+
+// TapDelivery is external delivery function receiving mirrored call content.
+// Calls are done inline with signaling and media path, so implementation must not block.
+// Data passed is a copy and can be retained.
+type TapDelivery interface {
+	DeliverSIP(callID string, dir TapDirection, msg sip.Message)
+	DeliverRTP(callID string, dir TapDirection, data []byte)
+}
+
+// Tap mirrors signaling and media of selected calls to delivery function.
+// Calls are selected by Match on initial INVITE, and only these calls are mirrored.
+// Every attach and detach is audit logged with tap ID
+type Tap struct {
+	// ID identifies authorization of this tap (ex. warrant id)
+	ID string
+	// Match decides is call tapped. It is called for incoming and outgoing initial INVITE
+	Match    func(invite *sip.Request) bool
+	Delivery TapDelivery
+}
+
+// callTap is list of taps attached to a single call
+type callTap struct {
+	callID string
+	taps   []*Tap
+}
+
+func (t *callTap) sip(dir TapDirection, msg sip.Message) {
+	for _, tap := range t.taps {
+		tap.Delivery.DeliverSIP(t.callID, dir, cloneMessage(msg))
+	}
+}
+
+// cloneMessage copies message, as dialog keeps changing the one it sends
+func cloneMessage(msg sip.Message) sip.Message {
+	switch m := msg.(type) {
+	case *sip.Request:
+		return m.Clone()
+	case *sip.Response:
+		return m.Clone()
+	}
+	return msg
+}
+
+func (t *callTap) rtp(dir TapDirection, data []byte) {
+	for _, tap := range t.taps {
+		cp := make([]byte, len(data))
+		copy(cp, data)
+		tap.Delivery.DeliverRTP(t.callID, dir, cp)
+	}
+}
+
+// WithPhoneTap adds tap for mirroring matched calls
+func WithPhoneTap(tap *Tap) PhoneOption {
+	return func(p *Phone) {
+		p.taps = append(p.taps, tap)
+	}
+}
+
+// tapCall attaches all matching taps to call of this invite. Returns nil if none matched
+func (p *Phone) tapCall(invite *sip.Request, dir TapDirection) *callTap {
+	if len(p.taps) == 0 {
+		return nil
+	}
+
+	callID := invite.CallID().Value()
+	var ct *callTap
+	for _, tap := range p.taps {
+		if tap.Match == nil || !tap.Match(invite) {
+			continue
+		}
+
+		if ct == nil {
+			ct = &callTap{callID: callID}
+		}
+		ct.taps = append(ct.taps, tap)
+		p.tapAudit(tap, callID).Msg("Tap attached")
+	}
+
+	if ct == nil {
+		return nil
+	}
+	p.tapped.Store(callID, ct)
+	ct.sip(dir, invite)
+	return ct
+}
+
+// tapSIP mirrors message if call is tapped
+func (p *Phone) tapSIP(dir TapDirection, msg sip.Message) {
+	if v, ok := p.tapped.Load(msg.CallID().Value()); ok {
+		v.(*callTap).sip(dir, msg)
+	}
+}
+
+func (p *Phone) untapCall(callID string) {
+	v, ok := p.tapped.LoadAndDelete(callID)
+	if !ok {
+		return
+	}
+
+	for _, tap := range v.(*callTap).taps {
+		p.tapAudit(tap, callID).Msg("Tap detached")
+	}
+}
+
+func (p *Phone) tapAudit(tap *Tap, callID string) *zerolog.Event {
+	return p.log.Info().Str("event", "TAP").Str("tap_id", tap.ID).Str("call_id", callID)
+}
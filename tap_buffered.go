@@ -0,0 +1,126 @@
+package sipgox
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/emiago/sipgo/sip"
+)
+
+// BufferedTapDelivery decouples slow sinks (disk, network upload) from call path.
+// Mirrored content is queued in bounded buffer and delivered from own goroutine.
+// When buffer is full content is dropped and counted, so call is never blocked
+type BufferedTapDelivery struct {
+	delivery TapDelivery
+	// rtpInterval paces RTP delivery. 0 means no limit
+	rtpInterval time.Duration
+
+	queue chan tapItem
+	done  chan struct{}
+	// mu guards closing of queue, so content delivered after Close is dropped
+	mu     sync.RWMutex
+	closed bool
+
+	delivered atomic.Uint64
+	dropped   atomic.Uint64
+}
+
+type tapItem struct {
+	callID string
+	dir    TapDirection
+	msg    sip.Message
+	data   []byte
+}
+
+// TapDeliveryStats are counters of buffered delivery
+type TapDeliveryStats struct {
+	Delivered uint64
+	Dropped   uint64
+	// Queued is current number of items waiting in buffer
+	Queued int
+}
+
+// NewBufferedTapDelivery starts delivery to d with buffer of size items.
+// rate limits delivered RTP packets per second, 0 is unlimited. SIP messages are not limited.
+// Close must be called to stop it. MediaEngineDefault CloseAll closes it as well
+func NewBufferedTapDelivery(d TapDelivery, size int, rate int) *BufferedTapDelivery {
+	b := &BufferedTapDelivery{
+		delivery: d,
+		queue:    make(chan tapItem, size),
+		done:     make(chan struct{}),
+	}
+	if rate > 0 {
+		b.rtpInterval = time.Second / time.Duration(rate)
+	}
+	MediaEngineDefault.goService(b.run, b.Close)
+	return b
+}
+
+func (b *BufferedTapDelivery) DeliverSIP(callID string, dir TapDirection, msg sip.Message) {
+	b.enqueue(tapItem{callID: callID, dir: dir, msg: msg})
+}
+
+func (b *BufferedTapDelivery) DeliverRTP(callID string, dir TapDirection, data []byte) {
+	b.enqueue(tapItem{callID: callID, dir: dir, data: data})
+}
+
+func (b *BufferedTapDelivery) enqueue(item tapItem) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if b.closed {
+		b.dropped.Add(1)
+		return
+	}
+	select {
+	case b.queue <- item:
+	default:
+		b.dropped.Add(1)
+	}
+}
+
+// Stats returns delivery counters
+func (b *BufferedTapDelivery) Stats() TapDeliveryStats {
+	return TapDeliveryStats{
+		Delivered: b.delivered.Load(),
+		Dropped:   b.dropped.Load(),
+		Queued:    len(b.queue),
+	}
+}
+
+// Close stops accepting content and waits until queued content is delivered.
+// Content delivered after Close is dropped
+func (b *BufferedTapDelivery) Close() {
+	b.mu.Lock()
+	if !b.closed {
+		b.closed = true
+		close(b.queue)
+	}
+	b.mu.Unlock()
+	<-b.done
+}
+
+func (b *BufferedTapDelivery) run() {
+	defer close(b.done)
+
+	var next time.Time
+	for item := range b.queue {
+		if item.msg != nil {
+			b.delivery.DeliverSIP(item.callID, item.dir, item.msg)
+			b.delivered.Add(1)
+			continue
+		}
+
+		if b.rtpInterval > 0 {
+			now := time.Now()
+			if next.After(now) {
+				time.Sleep(next.Sub(now))
+			} else {
+				next = now
+			}
+			next = next.Add(b.rtpInterval)
+		}
+		b.delivery.DeliverRTP(item.callID, item.dir, item.data)
+		b.delivered.Add(1)
+	}
+}
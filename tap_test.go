@@ -0,0 +1,142 @@
+package sipgox
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/emiago/sipgo"
+	"github.com/emiago/sipgo/fakes"
+	"github.com/emiago/sipgo/sip"
+	"github.com/stretchr/testify/require"
+)
+
+type tapRecorder struct {
+	sip []sip.Message
+	rtp [][]byte
+}
+
+func (r *tapRecorder) DeliverSIP(callID string, dir TapDirection, msg sip.Message) {
+	r.sip = append(r.sip, msg)
+}
+
+func (r *tapRecorder) DeliverRTP(callID string, dir TapDirection, data []byte) {
+	r.rtp = append(r.rtp, data)
+}
+
+func TestPhoneTap(t *testing.T) {
+	ua, err := sipgo.NewUA()
+	require.NoError(t, err)
+	defer ua.Close()
+
+	rec := &tapRecorder{}
+	phone := NewPhone(ua, WithPhoneTap(&Tap{
+		ID: "warrant-1",
+		Match: func(invite *sip.Request) bool {
+			return invite.Recipient.User == "alice"
+		},
+		Delivery: rec,
+	}))
+
+	newInvite := func(user string, callID string) *sip.Request {
+		req := sip.NewRequest(sip.INVITE, sip.Uri{User: user, Host: "localhost"})
+		cid := sip.CallIDHeader(callID)
+		req.AppendHeader(&cid)
+		return req
+	}
+
+	require.Nil(t, phone.tapCall(newInvite("bob", "1"), TapDirectionOut))
+	ct := phone.tapCall(newInvite("alice", "2"), TapDirectionOut)
+	require.NotNil(t, ct)
+	require.Len(t, rec.sip, 1)
+
+	// Delivered message is copy
+	invite := newInvite("alice", "2")
+	phone.tapSIP(TapDirectionOut, invite)
+	invite.SetBody([]byte("changed"))
+	require.Len(t, rec.sip, 2)
+	require.Empty(t, rec.sip[1].Body())
+
+	sess := &MediaSession{
+		Raddr: &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1234},
+		tap:   ct,
+	}
+	sess.rtpConn = &fakes.UDPConn{
+		Writers: map[string]io.Writer{
+			"127.0.0.1:1234": bytes.NewBuffer([]byte{}),
+		},
+	}
+	_, err = sess.WriteRTPRaw([]byte("rtp"))
+	require.NoError(t, err)
+	require.Equal(t, [][]byte{[]byte("rtp")}, rec.rtp)
+
+	phone.untapCall("2")
+	phone.tapSIP(TapDirectionIn, newInvite("alice", "2"))
+	require.Len(t, rec.sip, 2)
+}
+
+type blockingTapDelivery struct {
+	tapRecorder
+	mu      sync.Mutex
+	release chan struct{}
+}
+
+func (r *blockingTapDelivery) DeliverSIP(callID string, dir TapDirection, msg sip.Message) {
+	<-r.release
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.tapRecorder.DeliverSIP(callID, dir, msg)
+}
+
+func (r *blockingTapDelivery) DeliverRTP(callID string, dir TapDirection, data []byte) {
+	<-r.release
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.tapRecorder.DeliverRTP(callID, dir, data)
+}
+
+func TestBufferedTapDelivery(t *testing.T) {
+	sink := &blockingTapDelivery{release: make(chan struct{})}
+	b := NewBufferedTapDelivery(sink, 2, 0)
+
+	// Slow sink does not block caller. One item is taken by worker, two are buffered
+	start := time.Now()
+	for i := 0; i < 10; i++ {
+		b.DeliverRTP("1", TapDirectionIn, []byte{byte(i)})
+		time.Sleep(time.Millisecond)
+	}
+	require.Less(t, time.Since(start), time.Second)
+
+	stats := b.Stats()
+	require.Equal(t, uint64(7), stats.Dropped)
+	require.Equal(t, 2, stats.Queued)
+
+	close(sink.release)
+	b.Close()
+	require.Equal(t, uint64(3), b.Stats().Delivered)
+	require.Equal(t, [][]byte{{0}, {1}, {2}}, sink.rtp)
+
+	// Content after Close is dropped
+	b.DeliverRTP("1", TapDirectionIn, []byte{10})
+	require.Equal(t, uint64(8), b.Stats().Dropped)
+	b.Close()
+}
+
+func TestBufferedTapDeliveryRate(t *testing.T) {
+	rec := &tapRecorder{}
+	b := NewBufferedTapDelivery(rec, 10, 100)
+
+	start := time.Now()
+	for i := 0; i < 6; i++ {
+		b.DeliverRTP("1", TapDirectionOut, []byte{byte(i)})
+	}
+	b.Close()
+
+	// 6 packets at 100/s take at least 50ms
+	require.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond)
+	require.Len(t, rec.rtp, 6)
+	require.Equal(t, uint64(0), b.Stats().Dropped)
+}
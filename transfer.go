@@ -0,0 +1,338 @@
+package sipgox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/emiago/sipgo"
+	"github.com/emiago/sipgo/sip"
+)
+
+// TransferState is state of REFER implicit subscription (RFC 3515)
+type TransferState int
+
+const (
+	// TransferStateTrying is set when REFER is accepted and no progress is received
+	TransferStateTrying TransferState = iota
+	// TransferStateProgress is set on provisional sipfrag response
+	TransferStateProgress
+	// TransferStateSuccess is set on 2xx sipfrag response. Transfer went through
+	TransferStateSuccess
+	// TransferStateFailed is set on final non 2xx sipfrag response
+	TransferStateFailed
+	// TransferStateAborted is set when subscription or dialog ended without final
+	// sipfrag response. Outcome of transfer is unknown
+	TransferStateAborted
+)
+
+func (s TransferState) String() string {
+	switch s {
+	case TransferStateTrying:
+		return "trying"
+	case TransferStateProgress:
+		return "progress"
+	case TransferStateSuccess:
+		return "success"
+	case TransferStateFailed:
+		return "failed"
+	case TransferStateAborted:
+		return "aborted"
+	}
+	return "unknown"
+}
+
+// Final returns true if transfer is completed in this state
+func (s TransferState) Final() bool {
+	return s >= TransferStateSuccess
+}
+
+// TransferProgress is single transfer state update
+type TransferProgress struct {
+	State TransferState
+	// StatusCode and Reason are from last sipfrag status line. Zero if none received
+	StatusCode int
+	Reason     string
+}
+
+// ErrTransferAborted is returned when transfer outcome is unknown
+var ErrTransferAborted = errors.New("transfer subscription ended without final result")
+
+// TransferError is returned when transfer target rejected call
+type TransferError struct {
+	StatusCode int
+	Reason     string
+}
+
+func (e TransferError) Error() string {
+	return fmt.Sprintf("transfer failed: %d %s", e.StatusCode, e.Reason)
+}
+
+// transferUpdatesSize is buffer of Updates channel
+var transferUpdatesSize = 16
+
+// Transfer tracks REFER progress reported with NOTIFY sipfrag
+type Transfer struct {
+	ReferTo sip.Uri
+
+	mu      sync.Mutex
+	last    TransferProgress
+	updates chan TransferProgress
+	done    chan struct{}
+}
+
+func newTransfer(referTo sip.Uri) *Transfer {
+	return &Transfer{
+		ReferTo: referTo,
+		updates: make(chan TransferProgress, transferUpdatesSize),
+		done:    make(chan struct{}),
+	}
+}
+
+// Updates returns stream of state changes. It is closed after final state.
+// Intermediate progress is dropped if reader is too slow, final state is never dropped
+// and can always be read with Last
+func (t *Transfer) Updates() <-chan TransferProgress {
+	return t.updates
+}
+
+// Done is closed when transfer reaches final state
+func (t *Transfer) Done() <-chan struct{} {
+	return t.done
+}
+
+// Last returns last transfer state
+func (t *Transfer) Last() TransferProgress {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.last
+}
+
+// WaitComplete waits final state. It returns nil only if transfer went through,
+// TransferError if target rejected it, or ErrTransferAborted if outcome is unknown
+func (t *Transfer) WaitComplete(ctx context.Context) error {
+	select {
+	case <-t.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return t.Last().err()
+}
+
+func (p TransferProgress) err() error {
+	switch p.State {
+	case TransferStateSuccess:
+		return nil
+	case TransferStateFailed:
+		return TransferError{StatusCode: p.StatusCode, Reason: p.Reason}
+	}
+	return ErrTransferAborted
+}
+
+func (t *Transfer) update(p TransferProgress) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.last.State.Final() {
+		return
+	}
+	t.last = p
+
+	if !p.State.Final() {
+		select {
+		case t.updates <- p:
+		default:
+		}
+		return
+	}
+
+	// Make room for final state by dropping oldest progress
+	for sent := false; !sent; {
+		select {
+		case t.updates <- p:
+			sent = true
+		default:
+			select {
+			case <-t.updates:
+			default:
+			}
+		}
+	}
+	close(t.updates)
+	close(t.done)
+}
+
+// abort completes transfer as aborted if it is not completed
+func (t *Transfer) abort() {
+	t.update(TransferProgress{State: TransferStateAborted, StatusCode: t.Last().StatusCode, Reason: t.Last().Reason})
+}
+
+// readNotify updates transfer from NOTIFY request
+func (t *Transfer) readNotify(req *sip.Request) error {
+	p := t.Last()
+	if body := req.Body(); len(body) > 0 {
+		code, reason, err := parseSipfrag(body)
+		if err != nil {
+			return err
+		}
+		p.StatusCode, p.Reason = code, reason
+		switch {
+		case code < 200:
+			p.State = TransferStateProgress
+		case code < 300:
+			p.State = TransferStateSuccess
+		default:
+			p.State = TransferStateFailed
+		}
+	}
+
+	if h := req.GetHeader("Subscription-State"); h != nil && !p.State.Final() {
+		if state, _, _ := strings.Cut(h.Value(), ";"); strings.TrimSpace(state) == "terminated" {
+			p.State = TransferStateAborted
+		}
+	}
+	t.update(p)
+	return nil
+}
+
+// parseSipfrag returns status of sipfrag status line, ex. SIP/2.0 200 OK
+func parseSipfrag(body []byte) (int, string, error) {
+	line, _, _ := strings.Cut(string(body), "\n")
+	fields := strings.SplitN(strings.TrimSpace(line), " ", 3)
+	if len(fields) < 2 || !strings.HasPrefix(fields[0], "SIP/") {
+		return 0, "", fmt.Errorf("invalid sipfrag %q", line)
+	}
+	code, err := strconv.Atoi(fields[1])
+	if err != nil || code < 100 || code > 699 {
+		return 0, "", fmt.Errorf("invalid sipfrag status code %q", fields[1])
+	}
+	reason := ""
+	if len(fields) == 3 {
+		reason = fields[2]
+	}
+	return code, reason, nil
+}
+
+type referDialog interface {
+	Context() context.Context
+	TransactionRequest(ctx context.Context, req *sip.Request) (sip.ClientTransaction, error)
+}
+
+// transfers are pending transfers of dialog by subscription id
+type transfers struct {
+	m sync.Map
+}
+
+// refer sends REFER and returns transfer once it is accepted.
+// Transfer is aborted when dialog ends before final NOTIFY
+func (ts *transfers) refer(ctx context.Context, d referDialog, req *sip.Request, referTo sip.Uri) (*Transfer, error) {
+	req.AppendHeader(sip.NewHeader("Refer-to", referTo.String()))
+
+	tx, err := d.TransactionRequest(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Terminate()
+
+	// Subscription id is CSeq of REFER. Store before NOTIFY can arrive
+	id := strconv.Itoa(int(req.CSeq().SeqNo))
+	t := newTransfer(referTo)
+	ts.m.Store(id, t)
+
+	if err := waitReferAccepted(ctx, tx); err != nil {
+		ts.m.Delete(id)
+		return nil, err
+	}
+
+	go func() {
+		select {
+		case <-t.Done():
+		case <-d.Context().Done():
+			t.abort()
+		}
+		ts.m.Delete(id)
+	}()
+	return t, nil
+}
+
+func waitReferAccepted(ctx context.Context, tx sip.ClientTransaction) error {
+	for {
+		select {
+		case <-tx.Done():
+			return tx.Err()
+		case res := <-tx.Responses():
+			if res.IsProvisional() {
+				continue
+			}
+			if res.StatusCode != sip.StatusAccepted {
+				return sipgo.ErrDialogResponse{
+					Res: res,
+				}
+			}
+			return nil
+		case <-ctx.Done():
+			return tx.Cancel()
+		}
+	}
+}
+
+// readNotify passes NOTIFY to transfer matching Event id. Without id, single pending transfer is used
+func (ts *transfers) readNotify(req *sip.Request) error {
+	ev := req.GetHeader("Event")
+	if ev == nil {
+		return fmt.Errorf("no Event header in NOTIFY")
+	}
+	pkg, params, _ := strings.Cut(ev.Value(), ";")
+	if strings.TrimSpace(pkg) != "refer" {
+		return fmt.Errorf("unsupported NOTIFY event %q", pkg)
+	}
+
+	id := ""
+	for _, p := range strings.Split(params, ";") {
+		if v, found := strings.CutPrefix(strings.TrimSpace(p), "id="); found {
+			id = v
+		}
+	}
+
+	var t *Transfer
+	if id != "" {
+		if v, exists := ts.m.Load(id); exists {
+			t = v.(*Transfer)
+		}
+	} else {
+		n := 0
+		ts.m.Range(func(key, value any) bool {
+			t = value.(*Transfer)
+			n++
+			return true
+		})
+		if n > 1 {
+			t = nil
+		}
+	}
+	if t == nil {
+		return sipgo.ErrDialogDoesNotExists
+	}
+	return t.readNotify(req)
+}
+
+// notifyResponse responds to NOTIFY based on read error
+func notifyResponse(req *sip.Request, err error) *sip.Response {
+	switch {
+	case err == nil:
+		return sip.NewResponseFromRequest(req, sip.StatusOK, "OK", nil)
+	case errors.Is(err, sipgo.ErrDialogDoesNotExists):
+		return sip.NewResponseFromRequest(req, sip.StatusCallTransactionDoesNotExists, "Call/Transaction Does Not Exist", nil)
+	}
+	return sip.NewResponseFromRequest(req, sip.StatusBadRequest, err.Error(), nil)
+}
+
+// referEvent returns Event header value for NOTIFY of received REFER
+func referEvent(refer *sip.Request) string {
+	if cseq := refer.CSeq(); cseq != nil {
+		return "refer;id=" + strconv.Itoa(int(cseq.SeqNo))
+	}
+	return "refer"
+}
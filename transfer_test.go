@@ -0,0 +1,92 @@
+package sipgox
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/emiago/sipgo"
+	"github.com/emiago/sipgo/sip"
+	"github.com/stretchr/testify/require"
+)
+
+func newReferNotify(event string, subState string, frag string) *sip.Request {
+	req := sip.NewRequest(sip.NOTIFY, sip.Uri{User: "alice", Host: "127.0.0.1"})
+	req.AppendHeader(sip.NewHeader("Event", event))
+	if subState != "" {
+		req.AppendHeader(sip.NewHeader("Subscription-State", subState))
+	}
+	req.AppendHeader(sip.NewHeader("Content-Type", "message/sipfrag;version=2.0"))
+	if frag != "" {
+		req.SetBody([]byte(frag))
+	}
+	return req
+}
+
+func TestTransferNotify(t *testing.T) {
+	ts := transfers{}
+	tr := newTransfer(sip.Uri{User: "bob", Host: "127.0.0.1"})
+	ts.m.Store("2", tr)
+
+	require.ErrorIs(t, ts.readNotify(newReferNotify("refer;id=3", "active", "SIP/2.0 100 Trying")), sipgo.ErrDialogDoesNotExists)
+	require.Error(t, ts.readNotify(newReferNotify("dialog", "active", "SIP/2.0 100 Trying")))
+	require.Error(t, ts.readNotify(newReferNotify("refer;id=2", "active", "garbage")))
+
+	require.NoError(t, ts.readNotify(newReferNotify("refer;id=2", "active", "SIP/2.0 100 Trying")))
+	require.NoError(t, ts.readNotify(newReferNotify("refer", "active", "SIP/2.0 180 Ringing")))
+	require.Equal(t, TransferProgress{State: TransferStateProgress, StatusCode: 180, Reason: "Ringing"}, tr.Last())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	require.ErrorIs(t, tr.WaitComplete(ctx), context.DeadlineExceeded)
+
+	require.NoError(t, ts.readNotify(newReferNotify("refer;id=2", "terminated;reason=noresource", "SIP/2.0 486 Busy Here")))
+	err := tr.WaitComplete(context.Background())
+	require.Equal(t, TransferError{StatusCode: 486, Reason: "Busy Here"}, err)
+
+	states := []TransferState{}
+	for p := range tr.Updates() {
+		states = append(states, p.State)
+	}
+	require.Equal(t, []TransferState{TransferStateProgress, TransferStateProgress, TransferStateFailed}, states)
+
+	// Final state does not change
+	require.NoError(t, tr.readNotify(newReferNotify("refer", "active", "SIP/2.0 200 OK")))
+	require.Equal(t, TransferStateFailed, tr.Last().State)
+}
+
+func TestTransferComplete(t *testing.T) {
+	t.Run("Success", func(t *testing.T) {
+		tr := newTransfer(sip.Uri{})
+		require.NoError(t, tr.readNotify(newReferNotify("refer", "terminated", "SIP/2.0 200 OK\r\n")))
+		require.NoError(t, tr.WaitComplete(context.Background()))
+	})
+
+	t.Run("Terminated", func(t *testing.T) {
+		tr := newTransfer(sip.Uri{})
+		require.NoError(t, tr.readNotify(newReferNotify("refer", "terminated;reason=timeout", "")))
+		require.ErrorIs(t, tr.WaitComplete(context.Background()), ErrTransferAborted)
+	})
+
+	t.Run("Aborted", func(t *testing.T) {
+		tr := newTransfer(sip.Uri{})
+		require.NoError(t, tr.readNotify(newReferNotify("refer", "active", "SIP/2.0 183 Session Progress")))
+		tr.abort()
+		require.ErrorIs(t, tr.WaitComplete(context.Background()), ErrTransferAborted)
+		require.Equal(t, 183, tr.Last().StatusCode)
+	})
+
+	t.Run("SlowReader", func(t *testing.T) {
+		tr := newTransfer(sip.Uri{})
+		for i := 0; i < transferUpdatesSize*2; i++ {
+			tr.update(TransferProgress{State: TransferStateProgress, StatusCode: 180})
+		}
+		tr.update(TransferProgress{State: TransferStateSuccess, StatusCode: 200})
+
+		var last TransferProgress
+		for p := range tr.Updates() {
+			last = p
+		}
+		require.Equal(t, TransferStateSuccess, last.State)
+	})
+}
@@ -0,0 +1,468 @@
+package sipgox
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	turnAllocate         = 0x0003
+	turnRefresh          = 0x0004
+	turnCreatePermission = 0x0008
+	turnSendIndication   = 0x0016
+	turnDataIndication   = 0x0017
+
+	stunAttrErrorCode          = 0x0009
+	stunAttrLifetime           = 0x000D
+	stunAttrXORPeerAddress     = 0x0012
+	stunAttrData               = 0x0013
+	stunAttrRealm              = 0x0014
+	stunAttrNonce              = 0x0015
+	stunAttrXORRelayedAddress  = 0x0016
+	stunAttrRequestedTransport = 0x0019
+
+	// stunClassMask selects class bits of message type
+	stunClassMask    = 0x0110
+	stunClassSuccess = 0x0100
+
+	// turnPermissionRefresh is before permissions expire after 5 minutes (RFC 5766 8)
+	turnPermissionRefresh = 4 * time.Minute
+	// turnProtocolUDP is REQUESTED-TRANSPORT value of UDP
+	turnProtocolUDP = 17
+)
+
+var ErrTURNTimeout = errors.New("turn: no response")
+
+// TURNError is error response of TURN server
+type TURNError struct {
+	Code   int
+	Reason string
+}
+
+func (e *TURNError) Error() string {
+	return fmt.Sprintf("turn: error response %d %s", e.Code, e.Reason)
+}
+
+// TURNClient allocates relayed addresses on TURN server (RFC 5766) with long term credentials.
+// ICE uses relay when direct checks fail, see EnableICEWithTURN
+type TURNClient struct {
+	// Server is TURN server host:port. Only UDP is supported
+	Server   string
+	Username string
+	Password string
+	// Lifetime requested for allocation. Default 10 minutes
+	Lifetime time.Duration
+	// Timeout per transaction. Default 2s
+	Timeout time.Duration
+}
+
+// TURNAllocation is relayed address allocated for socket. It must be refreshed before lifetime expires
+type TURNAllocation struct {
+	// Relayed is address allocated on server, where peers send to
+	Relayed *net.UDPAddr
+	// Mapped is server reflexive address of socket
+	Mapped *net.UDPAddr
+
+	client *TURNClient
+	conn   net.PacketConn
+	server *net.UDPAddr
+
+	mu       sync.Mutex
+	realm    []byte
+	nonce    []byte
+	key      []byte
+	lifetime time.Duration
+	perms    []net.IP
+	// pending are transactions waiting for response read by relay conn
+	pending map[string]chan []byte
+	// relaying is set while socket is read by relay conn, which delivers responses
+	relaying bool
+
+	indicationID atomic.Uint64
+}
+
+// Allocate requests relayed address for conn. Conn must not be read by others during allocation
+func (c *TURNClient) Allocate(conn net.PacketConn) (*TURNAllocation, error) {
+	server, err := net.ResolveUDPAddr("udp", c.Server)
+	if err != nil {
+		return nil, err
+	}
+
+	lifetime := c.Lifetime
+	if lifetime == 0 {
+		lifetime = 10 * time.Minute
+	}
+	a := &TURNAllocation{
+		client:   c,
+		conn:     conn,
+		server:   server,
+		lifetime: lifetime,
+		pending:  map[string]chan []byte{},
+	}
+
+	res, err := a.transact(turnAllocate, func(msg []byte) []byte {
+		msg = stunAppendAttr(msg, stunAttrRequestedTransport, []byte{turnProtocolUDP, 0, 0, 0})
+		return stunAppendAttr(msg, stunAttrLifetime, binary.BigEndian.AppendUint32(nil, uint32(lifetime.Seconds())))
+	})
+	if err != nil {
+		return nil, fmt.Errorf("turn: allocate: %w", err)
+	}
+
+	err = stunRangeAttrs(res, func(typ uint16, val []byte, off int) {
+		switch typ {
+		case stunAttrXORRelayedAddress:
+			a.Relayed, _ = stunParseAddress(val, res[4:20])
+		case stunAttrXORMappedAddress:
+			a.Mapped, _ = stunParseAddress(val, res[4:20])
+		case stunAttrLifetime:
+			if len(val) == 4 {
+				a.lifetime = time.Duration(binary.BigEndian.Uint32(val)) * time.Second
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	if a.Relayed == nil {
+		return nil, fmt.Errorf("turn: allocate: no relayed address")
+	}
+	if a.Mapped == nil {
+		a.Mapped, _ = conn.LocalAddr().(*net.UDPAddr)
+	}
+	return a, nil
+}
+
+// Refresh extends allocation with lifetime granted by server
+func (a *TURNAllocation) Refresh() error {
+	a.mu.Lock()
+	lifetime := a.lifetime
+	a.mu.Unlock()
+
+	res, err := a.transact(turnRefresh, func(msg []byte) []byte {
+		return stunAppendAttr(msg, stunAttrLifetime, binary.BigEndian.AppendUint32(nil, uint32(lifetime.Seconds())))
+	})
+	if err != nil {
+		return fmt.Errorf("turn: refresh: %w", err)
+	}
+	return stunRangeAttrs(res, func(typ uint16, val []byte, off int) {
+		if typ == stunAttrLifetime && len(val) == 4 {
+			a.mu.Lock()
+			a.lifetime = time.Duration(binary.BigEndian.Uint32(val)) * time.Second
+			a.mu.Unlock()
+		}
+	})
+}
+
+// CreatePermission allows peers with IPs to send through relay. Permissions are kept by Refresh
+func (a *TURNAllocation) CreatePermission(ips ...net.IP) error {
+	_, err := a.transact(turnCreatePermission, func(msg []byte) []byte {
+		for _, ip := range ips {
+			msg = stunAppendXORAddress(msg, stunAttrXORPeerAddress, &net.UDPAddr{IP: ip})
+		}
+		return msg
+	})
+	if err != nil {
+		return fmt.Errorf("turn: create permission: %w", err)
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, ip := range ips {
+		if !containsIP(a.perms, ip) {
+			a.perms = append(a.perms, ip)
+		}
+	}
+	return nil
+}
+
+// Close releases allocation. Release is not confirmed, as socket can be already read by media
+func (a *TURNAllocation) Close() error {
+	req, _, err := a.request(turnRefresh, func(msg []byte) []byte {
+		return stunAppendAttr(msg, stunAttrLifetime, make([]byte, 4))
+	})
+	if err != nil {
+		return err
+	}
+	_, err = a.conn.WriteTo(req, a.server)
+	return err
+}
+
+// refreshLoop refreshes allocation and permissions until done is closed
+func (a *TURNAllocation) refreshLoop(done <-chan struct{}, report func(err error)) {
+	a.mu.Lock()
+	interval := min(a.lifetime/2, turnPermissionRefresh)
+	a.mu.Unlock()
+	t := time.NewTicker(max(interval, time.Second))
+	defer t.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-t.C:
+		}
+
+		if err := a.Refresh(); err != nil {
+			report(err)
+			continue
+		}
+		a.mu.Lock()
+		perms := append([]net.IP(nil), a.perms...)
+		a.mu.Unlock()
+		if len(perms) > 0 {
+			if err := a.CreatePermission(perms...); err != nil {
+				report(err)
+			}
+		}
+	}
+}
+
+// transact sends request and returns success response. Request is repeated with credentials
+// on 401 Unauthorized, and with new nonce on 438 Stale Nonce (RFC 5389 10.2)
+func (a *TURNAllocation) transact(method uint16, attrs func(msg []byte) []byte) ([]byte, error) {
+	for retry := 0; ; retry++ {
+		req, txid, err := a.request(method, attrs)
+		if err != nil {
+			return nil, err
+		}
+		res, err := a.roundTrip(req, txid)
+		if err != nil {
+			return nil, err
+		}
+		if binary.BigEndian.Uint16(res)&stunClassMask == stunClassSuccess {
+			return res, nil
+		}
+
+		terr := &TURNError{}
+		var realm, nonce []byte
+		stunRangeAttrs(res, func(typ uint16, val []byte, off int) {
+			switch typ {
+			case stunAttrErrorCode:
+				if len(val) >= 4 {
+					terr.Code = int(val[2]&0x7)*100 + int(val[3])
+					terr.Reason = string(val[4:])
+				}
+			case stunAttrRealm:
+				realm = append([]byte(nil), val...)
+			case stunAttrNonce:
+				nonce = append([]byte(nil), val...)
+			}
+		})
+		if retry == 0 && (terr.Code == 401 || terr.Code == 438) && nonce != nil {
+			a.mu.Lock()
+			a.nonce = nonce
+			if realm != nil {
+				a.realm = realm
+				sum := md5.Sum([]byte(a.client.Username + ":" + string(realm) + ":" + a.client.Password))
+				a.key = sum[:]
+			}
+			a.mu.Unlock()
+			continue
+		}
+		return nil, terr
+	}
+}
+
+// request builds request with credentials once server provided realm and nonce
+func (a *TURNAllocation) request(method uint16, attrs func(msg []byte) []byte) ([]byte, string, error) {
+	msg := make([]byte, 20, 256)
+	binary.BigEndian.PutUint16(msg[0:], method)
+	binary.BigEndian.PutUint32(msg[4:], stunMagicCookie)
+	if _, err := rand.Read(msg[8:20]); err != nil {
+		return nil, "", err
+	}
+	txid := string(msg[8:20])
+	if attrs != nil {
+		msg = attrs(msg)
+	}
+
+	a.mu.Lock()
+	realm, nonce, key := a.realm, a.nonce, a.key
+	a.mu.Unlock()
+	if key != nil {
+		msg = stunAppendAttr(msg, stunAttrUsername, []byte(a.client.Username))
+		msg = stunAppendAttr(msg, stunAttrRealm, realm)
+		msg = stunAppendAttr(msg, stunAttrNonce, nonce)
+		msg = stunAppendIntegrity(msg, key)
+	}
+	return msg, txid, nil
+}
+
+// roundTrip sends request with retransmissions until response with txid arrives.
+// While relaying, response is delivered by relay conn, otherwise socket is read directly
+func (a *TURNAllocation) roundTrip(req []byte, txid string) ([]byte, error) {
+	timeout := a.client.Timeout
+	if timeout == 0 {
+		timeout = 2 * time.Second
+	}
+
+	a.mu.Lock()
+	relaying := a.relaying
+	ch := make(chan []byte, 1)
+	if relaying {
+		a.pending[txid] = ch
+	}
+	a.mu.Unlock()
+	if relaying {
+		defer func() {
+			a.mu.Lock()
+			delete(a.pending, txid)
+			a.mu.Unlock()
+		}()
+	} else {
+		defer a.conn.SetReadDeadline(time.Time{})
+	}
+
+	deadline := time.Now().Add(timeout)
+	buf := make([]byte, 1500)
+	// RTO starts with 500ms and doubles (RFC 5389 7.2.1)
+	rto := 500 * time.Millisecond
+	for time.Now().Before(deadline) {
+		if _, err := a.conn.WriteTo(req, a.server); err != nil {
+			return nil, err
+		}
+		wait := time.Now().Add(rto)
+		if wait.After(deadline) {
+			wait = deadline
+		}
+		rto *= 2
+
+		if relaying {
+			t := time.NewTimer(time.Until(wait))
+			select {
+			case res := <-ch:
+				t.Stop()
+				return res, nil
+			case <-t.C:
+			}
+			continue
+		}
+
+		a.conn.SetReadDeadline(wait)
+		for {
+			n, _, err := a.conn.ReadFrom(buf)
+			if err != nil {
+				var netErr net.Error
+				if errors.As(err, &netErr) && netErr.Timeout() {
+					break
+				}
+				return nil, err
+			}
+			if isSTUN(buf[:n]) && string(buf[8:20]) == txid {
+				return append([]byte(nil), buf[:n]...), nil
+			}
+		}
+	}
+	return nil, ErrTURNTimeout
+}
+
+// deliver passes response read by relay conn to pending transaction
+func (a *TURNAllocation) deliver(msg []byte) {
+	a.mu.Lock()
+	ch, exists := a.pending[string(msg[8:20])]
+	a.mu.Unlock()
+	if !exists {
+		return
+	}
+	select {
+	case ch <- append([]byte(nil), msg...):
+	default:
+	}
+}
+
+// relayConn returns socket which sends and receives through allocation
+func (a *TURNAllocation) relayConn() *turnConn {
+	a.setRelaying(true)
+	return &turnConn{PacketConn: a.conn, alloc: a}
+}
+
+func (a *TURNAllocation) setRelaying(relaying bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.relaying = relaying
+}
+
+// turnConn sends packets to peers in Send indications and unwraps Data indications.
+// Packets not coming from server are passed as received
+type turnConn struct {
+	net.PacketConn
+	alloc *TURNAllocation
+}
+
+func (c *turnConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	peer, ok := addr.(*net.UDPAddr)
+	if !ok {
+		return 0, fmt.Errorf("turn: not udp address %s", addr)
+	}
+
+	msg := make([]byte, 20, 20+24+4+len(p)+3)
+	binary.BigEndian.PutUint16(msg[0:], turnSendIndication)
+	binary.BigEndian.PutUint32(msg[4:], stunMagicCookie)
+	// Indications are not answered, so id only needs to differ
+	binary.BigEndian.PutUint64(msg[12:], c.alloc.indicationID.Add(1))
+	msg = stunAppendXORAddress(msg, stunAttrXORPeerAddress, peer)
+	msg = stunAppendAttr(msg, stunAttrData, p)
+	if _, err := c.PacketConn.WriteTo(msg, c.alloc.server); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (c *turnConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	for {
+		n, from, err := c.PacketConn.ReadFrom(p)
+		if err != nil {
+			return n, from, err
+		}
+		if !isSTUN(p[:n]) || !udpAddrEqual(from, c.alloc.server) {
+			return n, from, nil
+		}
+		if binary.BigEndian.Uint16(p) != turnDataIndication {
+			c.alloc.deliver(p[:n])
+			continue
+		}
+
+		var peer *net.UDPAddr
+		var data []byte
+		stunRangeAttrs(p[:n], func(typ uint16, val []byte, off int) {
+			switch typ {
+			case stunAttrXORPeerAddress:
+				peer, _ = stunParseAddress(val, p[4:20])
+			case stunAttrData:
+				data = val
+			}
+		})
+		if peer == nil || data == nil {
+			continue
+		}
+		return copy(p, data), peer, nil
+	}
+}
+
+// unwrapRelay returns socket under relay conn
+func unwrapRelay(conn net.PacketConn) net.PacketConn {
+	if c, ok := conn.(*turnConn); ok {
+		return c.PacketConn
+	}
+	return conn
+}
+
+func udpAddrEqual(addr net.Addr, udp *net.UDPAddr) bool {
+	a, ok := addr.(*net.UDPAddr)
+	return ok && a.Port == udp.Port && a.IP.Equal(udp.IP)
+}
+
+func containsIP(ips []net.IP, ip net.IP) bool {
+	for _, i := range ips {
+		if i.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,186 @@
+package sipgox
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// turnTestServer is TURN server with single allocation relayed on relay conn
+type turnTestServer struct {
+	conn  *net.UDPConn
+	relay *net.UDPConn
+	key   []byte
+
+	client *net.UDPAddr
+	perms  chan net.IP
+}
+
+func newTURNTestServer(t *testing.T) *turnTestServer {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	relay, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		conn.Close()
+		relay.Close()
+	})
+
+	sum := md5.Sum([]byte("user:test:pass"))
+	s := &turnTestServer{conn: conn, relay: relay, key: sum[:], perms: make(chan net.IP, 10)}
+	clients := make(chan *net.UDPAddr, 1)
+	go s.serve(clients)
+	go s.serveRelay(clients)
+	return s
+}
+
+func (s *turnTestServer) serve(clients chan<- *net.UDPAddr) {
+	buf := make([]byte, 1500)
+	for {
+		n, raddr, err := s.conn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		msg := buf[:n]
+		if !isSTUN(msg) {
+			continue
+		}
+
+		method := binary.BigEndian.Uint16(msg)
+		var peer *net.UDPAddr
+		var data []byte
+		stunRangeAttrs(msg, func(typ uint16, val []byte, off int) {
+			switch typ {
+			case stunAttrXORPeerAddress:
+				peer, _ = stunParseAddress(val, msg[4:20])
+			case stunAttrData:
+				data = val
+			}
+		})
+		if method == turnSendIndication {
+			s.relay.WriteToUDP(data, peer)
+			continue
+		}
+
+		res := make([]byte, 20, 128)
+		copy(res[4:20], msg[4:20])
+		if !stunCheckResponseIntegrity(msg, s.key) {
+			binary.BigEndian.PutUint16(res, method|0x0110)
+			res = stunAppendAttr(res, stunAttrErrorCode, append([]byte{0, 0, 4, 1}, "Unauthorized"...))
+			res = stunAppendAttr(res, stunAttrRealm, []byte("test"))
+			res = stunAppendAttr(res, stunAttrNonce, []byte("nonce"))
+			s.conn.WriteToUDP(res, raddr)
+			continue
+		}
+
+		binary.BigEndian.PutUint16(res, method|0x0100)
+		switch method {
+		case turnAllocate:
+			res = stunAppendXORAddress(res, stunAttrXORRelayedAddress, s.relay.LocalAddr().(*net.UDPAddr))
+			res = stunAppendXORAddress(res, stunAttrXORMappedAddress, raddr)
+			res = stunAppendAttr(res, stunAttrLifetime, binary.BigEndian.AppendUint32(nil, 600))
+			clients <- raddr
+		case turnCreatePermission:
+			s.perms <- peer.IP
+		}
+		s.conn.WriteToUDP(res, raddr)
+	}
+}
+
+// serveRelay sends data received on relay to client in Data indications
+func (s *turnTestServer) serveRelay(clients <-chan *net.UDPAddr) {
+	client := <-clients
+	buf := make([]byte, 1500)
+	for {
+		n, raddr, err := s.relay.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		msg := make([]byte, 20, 64+n)
+		binary.BigEndian.PutUint16(msg, turnDataIndication)
+		binary.BigEndian.PutUint32(msg[4:], stunMagicCookie)
+		msg = stunAppendXORAddress(msg, stunAttrXORPeerAddress, raddr)
+		msg = stunAppendAttr(msg, stunAttrData, buf[:n])
+		s.conn.WriteToUDP(msg, client)
+	}
+}
+
+func TestTURNAllocation(t *testing.T) {
+	server := newTURNTestServer(t)
+
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	defer conn.Close()
+	peer, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	defer peer.Close()
+
+	c := &TURNClient{
+		Server:   server.conn.LocalAddr().String(),
+		Username: "user",
+		Password: "pass",
+		Timeout:  time.Second,
+	}
+	alloc, err := c.Allocate(conn)
+	require.NoError(t, err)
+	require.Equal(t, server.relay.LocalAddr().String(), alloc.Relayed.String())
+	require.Equal(t, conn.LocalAddr().String(), alloc.Mapped.String())
+	require.Equal(t, 10*time.Minute, alloc.lifetime)
+
+	require.NoError(t, alloc.CreatePermission(peer.LocalAddr().(*net.UDPAddr).IP))
+	require.True(t, net.IPv4(127, 0, 0, 1).Equal(<-server.perms))
+
+	relayed := alloc.relayConn()
+	_, err = relayed.WriteTo([]byte{0x80, 0, 0, 1}, peer.LocalAddr())
+	require.NoError(t, err)
+
+	buf := make([]byte, 1500)
+	peer.SetReadDeadline(time.Now().Add(time.Second))
+	n, from, err := peer.ReadFrom(buf)
+	require.NoError(t, err)
+	require.Equal(t, []byte{0x80, 0, 0, 1}, buf[:n])
+	require.Equal(t, server.relay.LocalAddr().String(), from.String())
+
+	// Responses are delivered by relay conn while it is read
+	received := make(chan []byte, 10)
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, from, err := relayed.ReadFrom(buf)
+			if err != nil {
+				close(received)
+				return
+			}
+			require.Equal(t, peer.LocalAddr().String(), from.String())
+			received <- append([]byte(nil), buf[:n]...)
+		}
+	}()
+	require.NoError(t, alloc.Refresh())
+
+	_, err = peer.WriteTo([]byte{0x80, 0, 0, 2}, alloc.Relayed)
+	require.NoError(t, err)
+	require.Equal(t, []byte{0x80, 0, 0, 2}, <-received)
+}
+
+func TestTURNUnauthorized(t *testing.T) {
+	server := newTURNTestServer(t)
+
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	defer conn.Close()
+
+	c := &TURNClient{
+		Server:   server.conn.LocalAddr().String(),
+		Username: "user",
+		Password: "wrong",
+		Timeout:  time.Second,
+	}
+	_, err = c.Allocate(conn)
+	terr := &TURNError{}
+	require.ErrorAs(t, err, &terr)
+	require.Equal(t, 401, terr.Code)
+}
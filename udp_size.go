@@ -0,0 +1,64 @@
+package sipgox
+
+import (
+	"net"
+	"strings"
+
+	"github.com/emiago/sipgo/sip"
+	"github.com/emiago/sipgox/sdp"
+	"github.com/rs/zerolog"
+)
+
+const (
+	// udpSizeUnknownMTU is max UDP request size when path MTU is unknown (RFC 3261 18.1.1)
+	udpSizeUnknownMTU = 1300
+	// udpSizeMTUMargin is margin under path MTU which request must fit (RFC 3261 18.1.1)
+	udpSizeMTUMargin = 200
+	// udpSizeHeadersEstimate accounts headers added later by transaction layer, like Via
+	udpSizeHeadersEstimate = 100
+)
+
+// WithPhoneUDPMessageLimit sets max size of request sent over UDP.
+// Bigger requests get compacted SDP and if still too big they are sent over TCP.
+// Zero (default) uses path MTU minus 200 bytes or 1300 when path MTU is unknown. Negative disables check
+func WithPhoneUDPMessageLimit(limit int) PhoneOption {
+	return func(p *Phone) {
+		p.udpMessageLimit = limit
+	}
+}
+
+// fitUDPMessage makes sure request is not silently dropped due to fragmentation
+func (p *Phone) fitUDPMessage(req *sip.Request, log *zerolog.Logger) {
+	if p.udpMessageLimit < 0 || !strings.EqualFold(req.Transport(), "udp") {
+		return
+	}
+
+	limit := p.udpMessageLimit
+	if limit == 0 {
+		limit = udpSizeUnknownMTU
+		// Avoid DNS lookup here, path MTU is only checked for resolved destination
+		dest := req.Destination()
+		if host, _, err := net.SplitHostPort(dest); err == nil && net.ParseIP(host) != nil {
+			if mtu, err := PathMTU(dest); err == nil && mtu > 0 {
+				limit = mtu - udpSizeMTUMargin
+			}
+		}
+	}
+
+	size := len(req.String()) + udpSizeHeadersEstimate
+	if size <= limit {
+		return
+	}
+
+	if ct := req.ContentType(); ct != nil && strings.HasPrefix(ct.Value(), "application/sdp") {
+		req.SetBody(sdp.Compact(req.Body()))
+		size = len(req.String()) + udpSizeHeadersEstimate
+		if size <= limit {
+			log.Info().Int("size", size).Int("limit", limit).Msg("Request compacted to fit UDP")
+			return
+		}
+	}
+
+	log.Warn().Int("size", size).Int("limit", limit).Msg("Request too big for UDP. Switching to TCP")
+	req.SetTransport("tcp")
+}
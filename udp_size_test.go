@@ -0,0 +1,46 @@
+package sipgox
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/emiago/sipgo/sip"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFitUDPMessage(t *testing.T) {
+	log := zerolog.Nop()
+	newInvite := func(sdp string) *sip.Request {
+		req := sip.NewRequest(sip.INVITE, sip.Uri{User: "bob", Host: "127.0.0.1", Port: 5060})
+		req.SetTransport("udp")
+		req.AppendHeader(sip.NewHeader("Content-Type", "application/sdp"))
+		req.SetBody([]byte(sdp))
+		return req
+	}
+	sdp := "v=0\r\nc=IN IP4 127.0.0.1\r\nm=audio 4000 RTP/AVP 0\r\na=rtpmap:0 PCMU/8000\r\n"
+
+	p := &Phone{}
+	req := newInvite(sdp)
+	p.fitUDPMessage(req, &log)
+	require.Equal(t, sdp, string(req.Body()))
+
+	// Compacting is enough
+	p.udpMessageLimit = len(req.String()) + udpSizeHeadersEstimate - 1
+	info := "i=" + strings.Repeat("x", 100) + "\r\n"
+	req = newInvite(sdp[:5] + info + sdp[5:])
+	p.fitUDPMessage(req, &log)
+	require.Equal(t, "udp", strings.ToLower(req.Transport()))
+	require.NotContains(t, string(req.Body()), "i=")
+
+	// Still too big
+	p.udpMessageLimit = 100
+	req = newInvite(sdp)
+	p.fitUDPMessage(req, &log)
+	require.Equal(t, "tcp", strings.ToLower(req.Transport()))
+
+	p.udpMessageLimit = -1
+	req = newInvite(sdp + info)
+	p.fitUDPMessage(req, &log)
+	require.Equal(t, "udp", strings.ToLower(req.Transport()))
+}
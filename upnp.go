@@ -0,0 +1,301 @@
+package sipgox
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var upnpServiceTypes = []string{
+	"urn:schemas-upnp-org:service:WANIPConnection:2",
+	"urn:schemas-upnp-org:service:WANIPConnection:1",
+	"urn:schemas-upnp-org:service:WANPPPConnection:1",
+}
+
+var ErrUPnPNotFound = errors.New("upnp internet gateway not found")
+
+// UPnPClient maps ports with UPnP Internet Gateway Device WAN connection service
+type UPnPClient struct {
+	ControlURL  string
+	ServiceType string
+	// LocalIP is used as internal client when mapped address is unspecified
+	LocalIP net.IP
+	// Description of mapping shown on router
+	Description string
+	HTTPClient  *http.Client
+}
+
+type upnpRoot struct {
+	URLBase string     `xml:"URLBase"`
+	Device  upnpDevice `xml:"device"`
+}
+
+type upnpDevice struct {
+	Services []upnpService `xml:"serviceList>service"`
+	Devices  []upnpDevice  `xml:"deviceList>device"`
+}
+
+type upnpService struct {
+	ServiceType string `xml:"serviceType"`
+	ControlURL  string `xml:"controlURL"`
+}
+
+// DiscoverUPnP searches gateway with SSDP and returns client for first gateway found.
+// Use context to limit search time
+func DiscoverUPnP(ctx context.Context) (*UPnPClient, error) {
+	conn, err := net.ListenUDP("udp4", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if d, ok := ctx.Deadline(); ok {
+		conn.SetReadDeadline(d)
+	} else {
+		conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	}
+
+	ssdp := &net.UDPAddr{IP: net.IPv4(239, 255, 255, 250), Port: 1900}
+	for _, st := range upnpServiceTypes {
+		msg := "M-SEARCH * HTTP/1.1\r\n" +
+			"HOST: 239.255.255.250:1900\r\n" +
+			"ST: " + st + "\r\n" +
+			"MAN: \"ssdp:discover\"\r\n" +
+			"MX: 2\r\n\r\n"
+		if _, err := conn.WriteTo([]byte(msg), ssdp); err != nil {
+			return nil, err
+		}
+	}
+
+	buf := make([]byte, 2048)
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			var nerr net.Error
+			if errors.As(err, &nerr) && nerr.Timeout() {
+				return nil, ErrUPnPNotFound
+			}
+			return nil, err
+		}
+
+		res, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(buf[:n])), nil)
+		if err != nil {
+			continue
+		}
+		location := res.Header.Get("Location")
+		if location == "" {
+			continue
+		}
+
+		c, err := NewUPnPClient(ctx, location)
+		if err != nil {
+			continue
+		}
+		return c, nil
+	}
+}
+
+// NewUPnPClient fetches device description from location and finds WAN connection service
+func NewUPnPClient(ctx context.Context, location string) (*UPnPClient, error) {
+	locURL, err := url.Parse(location)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, location, nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("upnp description %s: %s", location, res.Status)
+	}
+
+	var root upnpRoot
+	if err := xml.NewDecoder(res.Body).Decode(&root); err != nil {
+		return nil, err
+	}
+
+	srv := upnpFindService(root.Device)
+	if srv == nil {
+		return nil, ErrUPnPNotFound
+	}
+
+	base := locURL
+	if root.URLBase != "" {
+		if base, err = url.Parse(root.URLBase); err != nil {
+			return nil, err
+		}
+	}
+	control, err := base.Parse(srv.ControlURL)
+	if err != nil {
+		return nil, err
+	}
+
+	// Local address used to reach gateway is our LAN address
+	c := &UPnPClient{
+		ControlURL:  control.String(),
+		ServiceType: srv.ServiceType,
+		Description: "sipgox",
+	}
+	if conn, err := net.Dial("udp", locURL.Host); err == nil {
+		c.LocalIP = conn.LocalAddr().(*net.UDPAddr).IP
+		conn.Close()
+	}
+	return c, nil
+}
+
+func upnpFindService(d upnpDevice) *upnpService {
+	for _, st := range upnpServiceTypes {
+		for i := range d.Services {
+			if d.Services[i].ServiceType == st {
+				return &d.Services[i]
+			}
+		}
+	}
+	for _, sub := range d.Devices {
+		if s := upnpFindService(sub); s != nil {
+			return s
+		}
+	}
+	return nil
+}
+
+// ExternalIP returns public address of gateway
+func (c *UPnPClient) ExternalIP(ctx context.Context) (net.IP, error) {
+	vals, err := c.soap(ctx, "GetExternalIPAddress", nil)
+	if err != nil {
+		return nil, err
+	}
+	ip := net.ParseIP(vals["NewExternalIPAddress"])
+	if ip == nil {
+		return nil, fmt.Errorf("upnp invalid external address %q", vals["NewExternalIPAddress"])
+	}
+	return ip, nil
+}
+
+// MapPort maps same external port as internal
+func (c *UPnPClient) MapPort(ctx context.Context, internal *net.UDPAddr, lifetime time.Duration) (*net.UDPAddr, error) {
+	ip, err := c.ExternalIP(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	client := internal.IP
+	if client == nil || client.IsUnspecified() {
+		client = c.LocalIP
+	}
+	if client == nil {
+		return nil, fmt.Errorf("upnp internal client address unknown")
+	}
+
+	port := strconv.Itoa(internal.Port)
+	_, err = c.soap(ctx, "AddPortMapping", [][2]string{
+		{"NewRemoteHost", ""},
+		{"NewExternalPort", port},
+		{"NewProtocol", "UDP"},
+		{"NewInternalPort", port},
+		{"NewInternalClient", client.String()},
+		{"NewEnabled", "1"},
+		{"NewPortMappingDescription", c.Description},
+		{"NewLeaseDuration", strconv.Itoa(int(lifetime / time.Second))},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &net.UDPAddr{IP: ip, Port: internal.Port}, nil
+}
+
+// UnmapPort deletes port mapping
+func (c *UPnPClient) UnmapPort(ctx context.Context, internal *net.UDPAddr) error {
+	_, err := c.soap(ctx, "DeletePortMapping", [][2]string{
+		{"NewRemoteHost", ""},
+		{"NewExternalPort", strconv.Itoa(internal.Port)},
+		{"NewProtocol", "UDP"},
+	})
+	return err
+}
+
+// soap calls action and returns response arguments by name
+func (c *UPnPClient) soap(ctx context.Context, action string, args [][2]string) (map[string]string, error) {
+	body := &bytes.Buffer{}
+	body.WriteString(`<?xml version="1.0"?>` +
+		`<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/">` +
+		`<s:Body><u:` + action + ` xmlns:u="` + c.ServiceType + `">`)
+	for _, a := range args {
+		body.WriteString("<" + a[0] + ">")
+		xml.EscapeText(body, []byte(a[1]))
+		body.WriteString("</" + a[0] + ">")
+	}
+	body.WriteString(`</u:` + action + `></s:Body></s:Envelope>`)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.ControlURL, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", `text/xml; charset="utf-8"`)
+	req.Header.Set("SOAPAction", `"`+c.ServiceType+"#"+action+`"`)
+
+	client := c.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	vals, err := upnpParseValues(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("upnp %s failed: %s %s %s", action, res.Status, vals["errorCode"], vals["errorDescription"])
+	}
+	return vals, nil
+}
+
+// upnpParseValues collects text of all leaf elements
+func upnpParseValues(r io.Reader) (map[string]string, error) {
+	vals := map[string]string{}
+	dec := xml.NewDecoder(r)
+	var name string
+	var text strings.Builder
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return vals, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			name = t.Name.Local
+			text.Reset()
+		case xml.CharData:
+			text.Write(t)
+		case xml.EndElement:
+			if t.Name.Local == name {
+				vals[name] = strings.TrimSpace(text.String())
+			}
+			name = ""
+		}
+	}
+}